@@ -5,6 +5,8 @@ import (
 )
 
 // BarbicanSpec defines the desired state of the Barbican (Key Manager) service.
+// +kubebuilder:validation:XValidation:rule="(self.secretStore == 'vault') == has(self.vault)",message="vault is required with, and only allowed with, secretStore vault"
+// +kubebuilder:validation:XValidation:rule="(self.secretStore == 'pkcs11') == has(self.pkcs11)",message="pkcs11 is required with, and only allowed with, secretStore pkcs11"
 type BarbicanSpec struct {
 	ServiceTemplate `json:",inline"`
 
@@ -16,16 +18,128 @@ type BarbicanSpec struct {
 	// +optional
 	MessageQueue RabbitMQConfig `json:"messageQueue,omitempty"`
 
-	// SecretStore selects the backend for storing secrets.
+	// SecretStore selects the backend for storing secrets. Secrets stored
+	// in one backend cannot be read through another, so it cannot be
+	// changed.
 	// +kubebuilder:validation:Enum=simple_crypto;pkcs11;vault;kmip
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="secretStore is immutable"
 	// +kubebuilder:default="simple_crypto"
 	// +optional
 	SecretStore string `json:"secretStore,omitempty"`
 
-	// WorkerReplicas is the number of barbican-worker replicas.
+	// Vault configures the vault secret store.
+	// +optional
+	Vault *BarbicanVaultSpec `json:"vault,omitempty"`
+
+	// PKCS11 configures the pkcs11 secret store.
+	// +optional
+	PKCS11 *BarbicanPKCS11Spec `json:"pkcs11,omitempty"`
+
+	// WorkerReplicas is the number of barbican-worker replicas. Replicas
+	// sets the number of barbican-api replicas.
 	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
 	// +optional
 	WorkerReplicas *int32 `json:"workerReplicas,omitempty"`
+
+	// KeystoneListener runs barbican-keystone-listener, which removes the
+	// secrets of deleted Keystone projects. Keystone must send its
+	// notifications to the RabbitMQ of Barbican.
+	// +kubebuilder:default=true
+	// +optional
+	KeystoneListener *bool `json:"keystoneListener,omitempty"`
+
+	// KEKDeletionPolicy decides what happens to the simple_crypto key
+	// encryption key when the Barbican is deleted. Every stored secret is
+	// encrypted with it, so it is retained unless deletion is confirmed
+	// by setting Delete. A retained Secret whose deletion was requested
+	// keeps its finalizer until it is removed by hand.
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +kubebuilder:default="Retain"
+	// +optional
+	KEKDeletionPolicy string `json:"kekDeletionPolicy,omitempty"`
+}
+
+// BarbicanVaultSpec configures storing secrets in the KV secrets engine of
+// HashiCorp Vault, authenticating with AppRole.
+type BarbicanVaultSpec struct {
+	// URL is the address of Vault, e.g. https://vault.example.com:8200.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+
+	// KVMountPoint is the mount point of the KV version 2 secrets engine.
+	// +kubebuilder:default="secret"
+	// +optional
+	KVMountPoint string `json:"kvMountPoint,omitempty"`
+
+	// AppRoleSecretName references a Secret with the roleID and secretID
+	// keys of the AppRole Barbican logs in with. The role needs create,
+	// read and delete on the KV mount.
+	AppRoleSecretName string `json:"appRoleSecretName"`
+
+	// CASecretName references a Secret with a ca.crt key holding the CA
+	// that signed the certificate of Vault. The system CAs are used if
+	// empty.
+	// +optional
+	CASecretName string `json:"caSecretName,omitempty"`
+}
+
+// BarbicanPKCS11Spec configures encrypting secrets with keys kept in an HSM
+// through its PKCS#11 library. Secrets are encrypted with per-project keys
+// that are wrapped by the master key encryption key (MKEK) and signed with
+// the HMAC key; both are generated in the HSM at bootstrap if missing.
+// +kubebuilder:validation:XValidation:rule="has(self.slotID) != has(self.tokenLabel)",message="exactly one of slotID and tokenLabel is required"
+// +kubebuilder:validation:XValidation:rule="!has(self.softHSM) || has(self.tokenLabel)",message="softHSM requires tokenLabel"
+type BarbicanPKCS11Spec struct {
+	// LibraryPath is the path of the PKCS#11 library in the Barbican image,
+	// e.g. /usr/lib/softhsm/libsofthsm2.so. Libraries not in the default
+	// images require a custom Image.
+	// +kubebuilder:validation:Pattern=`^/[A-Za-z0-9_./-]+$`
+	LibraryPath string `json:"libraryPath"`
+
+	// SlotID selects the token by slot.
+	// +optional
+	SlotID *int32 `json:"slotID,omitempty"`
+
+	// TokenLabel selects the token by label.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.-]+$`
+	// +optional
+	TokenLabel string `json:"tokenLabel,omitempty"`
+
+	// PINSecretName references a Secret with a pin key holding the user
+	// PIN of the token.
+	PINSecretName string `json:"pinSecretName"`
+
+	// MKEKLabel is the label of the master key encryption key.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.-]+$`
+	// +kubebuilder:default="barbican_mkek"
+	// +optional
+	MKEKLabel string `json:"mkekLabel,omitempty"`
+
+	// MKEKLength is the length in bytes of the master key encryption key.
+	// +kubebuilder:validation:Enum=16;24;32
+	// +kubebuilder:default=32
+	// +optional
+	MKEKLength int32 `json:"mkekLength,omitempty"`
+
+	// HMACLabel is the label of the HMAC key.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.-]+$`
+	// +kubebuilder:default="barbican_hmac"
+	// +optional
+	HMACLabel string `json:"hmacLabel,omitempty"`
+
+	// SoftHSM keeps a SoftHSM2 token on a PersistentVolumeClaim, for
+	// testing without an HSM. The token is initialized at bootstrap with
+	// the PIN as both user and SO PIN.
+	// +optional
+	SoftHSM *BarbicanSoftHSMSpec `json:"softHSM,omitempty"`
+}
+
+// BarbicanSoftHSMSpec configures the SoftHSM2 token store.
+type BarbicanSoftHSMSpec struct {
+	// ClaimName is the PersistentVolumeClaim holding the token directory.
+	// It must be ReadWriteMany when more than one Barbican pod runs.
+	ClaimName string `json:"claimName"`
 }
 
 // BarbicanStatus defines the observed state of Barbican.
@@ -35,11 +149,19 @@ type BarbicanStatus struct {
 	// APIEndpoint is the internal API URL of the Barbican service.
 	// +optional
 	APIEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// KEKFingerprint identifies the simple_crypto key encryption key in use:
+	// the first 16 hex digits of its SHA-256. Once set, Barbican refuses to
+	// run with a missing or different key.
+	// +optional
+	KEKFingerprint string `json:"kekFingerprint,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Store",type=string,JSONPath=`.spec.secretStore`
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.status.apiEndpoint`
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Barbican is the Schema for the barbicans API.
@@ -73,7 +73,7 @@ type CephPool struct {
 	ReplicaCount int32 `json:"replicaCount,omitempty"`
 
 	// Purpose identifies which OpenStack service uses this pool.
-	// +kubebuilder:validation:Enum=cinder-volumes;glance-images;nova-ephemeral
+	// +kubebuilder:validation:Enum=cinder-volumes;cinder-backups;glance-images;nova-ephemeral
 	// +optional
 	Purpose string `json:"purpose,omitempty"`
 }
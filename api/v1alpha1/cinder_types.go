@@ -4,25 +4,107 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// CinderBackend defines a single Cinder storage backend.
+// CinderBackend defines a single Cinder storage backend. Each backend runs
+// in its own cinder-volume Deployment and gets a volume type of the same
+// name.
+// +kubebuilder:validation:XValidation:rule="self.type != 'lvm' || has(self.lvmVolumeGroup)",message="lvmVolumeGroup is required for type lvm"
+// +kubebuilder:validation:XValidation:rule="self.type == 'lvm' || (!has(self.lvmVolumeGroup) && !has(self.lvmTargetHelper))",message="lvmVolumeGroup and lvmTargetHelper are only valid for type lvm"
+// +kubebuilder:validation:XValidation:rule="self.type == 'ceph' || !has(self.cephPoolName)",message="cephPoolName is only valid for type ceph"
+// +kubebuilder:validation:XValidation:rule="(self.type == 'nfs') == has(self.nfs)",message="nfs is required for type nfs and only valid for it"
+// +kubebuilder:validation:XValidation:rule="(self.type == 'custom') == has(self.custom)",message="custom is required for type custom and only valid for it"
 type CinderBackend struct {
-	// Name is a unique identifier for this backend.
+	// Name is a unique identifier for this backend. It names the
+	// cinder-volume Deployment, the backend section and the volume type.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=40
 	Name string `json:"name"`
 
-	// Type selects the volume driver.
-	// +kubebuilder:validation:Enum=ceph;lvm
+	// Type selects the volume driver. ceph backends wait for the Nova of
+	// the namespace to create the libvirt secret computes attach volumes
+	// with, whatever its ephemeralStorage; they fail while the namespace
+	// has more than one Nova.
+	// +kubebuilder:validation:Enum=ceph;lvm;nfs;custom
 	Type string `json:"type"`
 
-	// CephPoolName is the RBD pool name (required when type is "ceph").
+	// CephPoolName is the RBD pool name. For type "ceph" it defaults to the
+	// CephStorage pool with purpose cinder-volumes.
 	// +optional
 	CephPoolName string `json:"cephPoolName,omitempty"`
 
-	// LVMVolumeGroup is the LVM VG name (required when type is "lvm").
+	// LVMVolumeGroup is the LVM VG name (required when type is "lvm"). It
+	// must exist on the node the backend runs on; see NodeSelector.
 	// +optional
 	LVMVolumeGroup string `json:"lvmVolumeGroup,omitempty"`
+
+	// LVMTargetHelper selects the iSCSI target LVM volumes are exported
+	// with: the kernel LIO target (lioadm) or tgtd (tgtadm), which runs as a
+	// sidecar on the host network. Defaults to lioadm.
+	// +kubebuilder:validation:Enum=lioadm;tgtadm
+	// +optional
+	LVMTargetHelper string `json:"lvmTargetHelper,omitempty"`
+
+	// NFS configures a backend of type "nfs".
+	// +optional
+	NFS *CinderNFSBackend `json:"nfs,omitempty"`
+
+	// Custom configures a backend of type "custom".
+	// +optional
+	Custom *CinderCustomBackend `json:"custom,omitempty"`
+
+	// NodeSelector constrains the backend's cinder-volume pod, replacing
+	// spec.nodeSelector. LVM backends must run on the node holding the
+	// volume group.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+}
+
+// CinderNFSBackend configures volumes stored as files on NFS shares.
+type CinderNFSBackend struct {
+	// SharesSecretName references a Secret whose "shares" key lists one
+	// share per line, as host:/export optionally followed by mount options.
+	// +kubebuilder:validation:MinLength=1
+	SharesSecretName string `json:"sharesSecretName"`
+
+	// MountOptions are the mount options for all shares, e.g. "vers=4.1".
+	// +optional
+	MountOptions string `json:"mountOptions,omitempty"`
+}
+
+// CinderCustomBackend configures any in-tree or vendor volume driver.
+// +kubebuilder:validation:XValidation:rule="!has(self.options) || !self.options.exists(k, k in ['volume_driver', 'volume_backend_name', 'backend_host'])",message="options must not set volume_driver, volume_backend_name or backend_host"
+type CinderCustomBackend struct {
+	// Driver is the volume driver class, e.g.
+	// "cinder.volume.drivers.netapp.common.NetAppDriver".
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)+$`
+	Driver string `json:"driver"`
+
+	// Options are written to the backend section as is. Names may only
+	// hold letters, digits, _, . and -, and values must not hold line
+	// breaks, [ or ].
+	// +optional
+	Options map[string]string `json:"options,omitempty"`
+
+	// CredentialsSecretName references a Secret whose keys are added to the
+	// backend section as options, e.g. san_login and san_password, so they
+	// stay out of the custom resource. They follow the rules of Options;
+	// the backend fails while the Secret breaks them.
+	// +optional
+	CredentialsSecretName string `json:"credentialsSecretName,omitempty"`
+
+	// Image replaces the cinder-volume image, for drivers that need
+	// vendor libraries.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Privileged runs cinder-volume privileged on the host network with
+	// access to host devices and the iSCSI initiator, for drivers that
+	// attach volumes to the cinder-volume host, e.g. to copy images.
+	// +optional
+	Privileged bool `json:"privileged,omitempty"`
 }
 
 // CinderSpec defines the desired state of the Cinder (Block Storage) service.
+// +kubebuilder:validation:XValidation:rule="!has(self.defaultBackend) || self.backends.exists(b, b.name == self.defaultBackend)",message="defaultBackend must name one of backends"
 type CinderSpec struct {
 	ServiceTemplate `json:",inline"`
 
@@ -36,11 +118,108 @@ type CinderSpec struct {
 
 	// Backends lists the storage backends to configure.
 	// +kubebuilder:validation:MinItems=1
+	// +listType=map
+	// +listMapKey=name
 	Backends []CinderBackend `json:"backends"`
 
-	// DefaultBackend is the name of the default backend (must match one of Backends[].Name).
+	// DefaultBackend is the name of the default backend (must match one of
+	// Backends[].Name). Its volume type becomes the default volume type.
+	// Defaults to the first backend.
 	// +optional
 	DefaultBackend string `json:"defaultBackend,omitempty"`
+
+	// Backup runs cinder-backup. Backups are disabled when unset.
+	// +optional
+	Backup *CinderBackupSpec `json:"backup,omitempty"`
+}
+
+// CinderBackupSpec configures cinder-backup and its target.
+// +kubebuilder:validation:XValidation:rule="self.driver != 'nfs' || has(self.nfs)",message="nfs is required for driver nfs"
+// +kubebuilder:validation:XValidation:rule="self.driver != 's3' || has(self.s3)",message="s3 is required for driver s3"
+// +kubebuilder:validation:XValidation:rule="(self.driver == 'ceph' || !has(self.ceph)) && (self.driver == 'swift' || !has(self.swift)) && (self.driver == 'nfs' || !has(self.nfs)) && (self.driver == 's3' || !has(self.s3))",message="only the settings of the selected driver may be set"
+type CinderBackupSpec struct {
+	// Driver selects where backups are stored.
+	// +kubebuilder:validation:Enum=ceph;swift;nfs;s3
+	Driver string `json:"driver"`
+
+	// Replicas is the number of cinder-backup pods.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Ceph configures the ceph driver. Backups go to the CephStorage pool
+	// with purpose cinder-backups; with Rook the pool is created if
+	// CephStorage does not list one.
+	// +optional
+	Ceph *CinderBackupCeph `json:"ceph,omitempty"`
+
+	// Swift configures the swift driver, which stores backups in the
+	// object-store endpoint of the Keystone catalog.
+	// +optional
+	Swift *CinderBackupSwift `json:"swift,omitempty"`
+
+	// NFS configures the nfs driver.
+	// +optional
+	NFS *CinderBackupNFS `json:"nfs,omitempty"`
+
+	// S3 configures the s3 driver.
+	// +optional
+	S3 *CinderBackupS3 `json:"s3,omitempty"`
+}
+
+// CinderBackupCeph configures backups to Ceph.
+type CinderBackupCeph struct {
+	// PoolName overrides the backup pool name.
+	// +optional
+	PoolName string `json:"poolName,omitempty"`
+}
+
+// CinderBackupSwift configures backups to Swift.
+type CinderBackupSwift struct {
+	// Container is the container backups are stored in.
+	// +kubebuilder:default="volumebackups"
+	// +optional
+	Container string `json:"container,omitempty"`
+}
+
+// CinderBackupNFS configures backups to an NFS share.
+type CinderBackupNFS struct {
+	// Share is the export backups are written to, as host:/export.
+	// +kubebuilder:validation:Pattern=`^[^:\s]+:/\S*$`
+	Share string `json:"share"`
+
+	// MountOptions are the mount options for the share.
+	// +optional
+	MountOptions string `json:"mountOptions,omitempty"`
+}
+
+// CinderBackupS3 configures backups to an S3-compatible object store, such
+// as AWS S3, Ceph RGW or MinIO.
+type CinderBackupS3 struct {
+	// EndpointURL is the S3 endpoint, e.g. http://minio.minio.svc:9000.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	EndpointURL string `json:"endpointURL"`
+
+	// Bucket is the bucket backups are stored in. It is created if missing.
+	// +kubebuilder:default="volumebackups"
+	// +optional
+	Bucket string `json:"bucket,omitempty"`
+
+	// CredentialsSecretName references a Secret with the accessKey and
+	// secretKey keys.
+	// +kubebuilder:validation:MinLength=1
+	CredentialsSecretName string `json:"credentialsSecretName"`
+
+	// CASecretName references a Secret whose ca.crt key holds the CA
+	// bundle for an https endpoint with a private CA.
+	// +optional
+	CASecretName string `json:"caSecretName,omitempty"`
+
+	// InsecureSkipVerify disables certificate verification for https
+	// endpoints.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
 }
 
 // CinderStatus defines the observed state of Cinder.
@@ -50,8 +229,47 @@ type CinderStatus struct {
 	// APIEndpoint is the internal API URL of the Cinder service.
 	// +optional
 	APIEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// Backends reports the health of each backend.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Backends []CinderBackendStatus `json:"backends,omitempty"`
 }
 
+// CinderBackendStatus reports the state of one backend.
+type CinderBackendStatus struct {
+	// Name is the backend name.
+	Name string `json:"name"`
+
+	// Ready is true when the backend's cinder-volume pod is available and
+	// its service reports up.
+	Ready bool `json:"ready"`
+
+	// ServiceState is the state of the backend's cinder-volume service as
+	// reported by the Cinder API: up or down. Empty until it registers.
+	// +optional
+	ServiceState string `json:"serviceState,omitempty"`
+
+	// VolumeTypeID is the ID of the volume type for the backend.
+	// +optional
+	VolumeTypeID string `json:"volumeTypeID,omitempty"`
+
+	// Message explains why the backend is not ready.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// ConditionBackendsReady indicates every backend's cinder-volume service
+	// is up. A failing backend does not affect the others or Ready.
+	ConditionBackendsReady ConditionType = "BackendsReady"
+
+	// ConditionBackupReady indicates cinder-backup is running against its
+	// target, or that backups are disabled.
+	ConditionBackupReady ConditionType = "BackupReady"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
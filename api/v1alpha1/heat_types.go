@@ -16,10 +16,28 @@ type HeatSpec struct {
 	// +optional
 	MessageQueue RabbitMQConfig `json:"messageQueue,omitempty"`
 
-	// EngineReplicas is the number of heat-engine replicas.
+	// EngineReplicas is the number of heat-engine replicas. Replicas sets
+	// the number of heat-api and heat-api-cfn replicas.
 	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
 	// +optional
 	EngineReplicas *int32 `json:"engineReplicas,omitempty"`
+
+	// StackDomain is the Keystone domain holding the users and projects
+	// Heat creates for stacks, e.g. for wait conditions and software
+	// deployments. It is administered by the heat_domain_admin user.
+	// Changing it creates the new domain; the old one and the users and
+	// projects Heat created in it are left in Keystone.
+	// +kubebuilder:default="heat"
+	// +optional
+	StackDomain string `json:"stackDomain,omitempty"`
+
+	// MetadataServerURL is the heat-api-cfn URL given to instances for
+	// metadata and wait condition signals. It must be reachable from the
+	// instances and defaults to the in-cluster heat-api-cfn Service.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	MetadataServerURL string `json:"metadataServerURL,omitempty"`
 }
 
 // HeatStatus defines the observed state of Heat.
@@ -29,11 +47,16 @@ type HeatStatus struct {
 	// APIEndpoint is the internal API URL of the Heat service.
 	// +optional
 	APIEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// CFNEndpoint is the internal URL of the CloudFormation-compatible API.
+	// +optional
+	CFNEndpoint string `json:"cfnEndpoint,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.status.apiEndpoint`
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Heat is the Schema for the heats API.
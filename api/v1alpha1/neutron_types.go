@@ -32,8 +32,43 @@ type NeutronSpec struct {
 	// +kubebuilder:default="geneve"
 	// +optional
 	TunnelType string `json:"tunnelType,omitempty"`
+
+	// Plugins lists optional service plugins and ML2 extensions to enable.
+	// Each entry is validated against Mechanism; unsupported combinations
+	// leave the resource not Ready with reason InvalidPlugins.
+	// +listType=set
+	// +optional
+	Plugins []NeutronPlugin `json:"plugins,omitempty"`
+
+	// VPNAgentImage is the image of the OVN VPN agent run for the vpnaas
+	// plugin. Image only applies to neutron-server. If empty, the operator
+	// default is used.
+	// +optional
+	VPNAgentImage string `json:"vpnAgentImage,omitempty"`
 }
 
+// NeutronPlugin identifies an optional Neutron service plugin or ML2 extension.
+// +kubebuilder:validation:Enum=vpnaas;fwaas_v2;qos;trunk;port_forwarding
+type NeutronPlugin string
+
+const (
+	// NeutronPluginVPNaaS enables IPsec site-to-site VPN (neutron-vpnaas).
+	// The OVN VPN agent runs on the OVN gateway chassis nodes.
+	NeutronPluginVPNaaS NeutronPlugin = "vpnaas"
+
+	// NeutronPluginFWaaSv2 enables firewall groups on router ports (neutron-fwaas).
+	NeutronPluginFWaaSv2 NeutronPlugin = "fwaas_v2"
+
+	// NeutronPluginQoS enables QoS policies and the ML2 qos extension driver.
+	NeutronPluginQoS NeutronPlugin = "qos"
+
+	// NeutronPluginTrunk enables VLAN-aware VMs via trunk ports.
+	NeutronPluginTrunk NeutronPlugin = "trunk"
+
+	// NeutronPluginPortForwarding enables floating IP port forwarding.
+	NeutronPluginPortForwarding NeutronPlugin = "port_forwarding"
+)
+
 // NeutronStatus defines the observed state of Neutron.
 type NeutronStatus struct {
 	CommonStatus `json:",inline"`
@@ -41,6 +76,10 @@ type NeutronStatus struct {
 	// APIEndpoint is the internal API URL of the Neutron service.
 	// +optional
 	APIEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// ServicePlugins is the rendered service_plugins list currently configured.
+	// +optional
+	ServicePlugins []string `json:"servicePlugins,omitempty"`
 }
 
 // +kubebuilder:object:root=true
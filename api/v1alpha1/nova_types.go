@@ -22,22 +22,117 @@ type NovaSpec struct {
 	// +optional
 	VirtType string `json:"virtType,omitempty"`
 
-	// CellName is the Nova cell name for this deployment.
+	// CellName is the Nova cell name for this deployment. It is ignored when
+	// Cells is set.
 	// +kubebuilder:default="cell1"
 	// +optional
 	CellName string `json:"cellName,omitempty"`
 
+	// Cells lists the Nova cells. Each cell gets its own database, message
+	// queue and conductors; the API database, cell0 and the super-conductor
+	// stay on the top-level Database and MessageQueue. When empty, a single
+	// cell named CellName shares the top-level database server and queue.
+	// A cell on the top-level RabbitMQ gets its own vhost nova_<name>.
+	// A cell can only be removed once it has no compute hosts and instances.
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=32
+	// +kubebuilder:validation:XValidation:rule="self.all(c, !(c.name in ['cell0', 'api']))",message="cell0 and api are reserved cell names"
+	// +optional
+	Cells []NovaCellSpec `json:"cells,omitempty"`
+
 	// ComputeReplicas is the number of nova-compute pod replicas.
 	// Only relevant when compute runs inside Kubernetes (not on external data-plane nodes).
+	// Compute pods run on at most this many nodes selected by
+	// ComputeNodeSelector; 0 disables in-cluster compute.
 	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=0
 	// +optional
 	ComputeReplicas *int32 `json:"computeReplicas,omitempty"`
 
-	// EphemeralStorage selects the backend for ephemeral disks.
+	// ComputeNodeSelector selects the nodes eligible to run nova-compute and
+	// libvirt. Cells narrow it further with their own ComputeNodeSelector.
+	// +kubebuilder:default={"openstack.k8s.io/compute":"true"}
+	// +optional
+	ComputeNodeSelector map[string]string `json:"computeNodeSelector,omitempty"`
+
+	// EphemeralStorage selects the backend for ephemeral disks. With ceph,
+	// disks live in the nova-ephemeral pool of the namespace's CephStorage,
+	// accessed as client.nova.
 	// +kubebuilder:validation:Enum=local;ceph
 	// +kubebuilder:default="local"
 	// +optional
 	EphemeralStorage string `json:"ephemeralStorage,omitempty"`
+
+	// LiveMigration configures live migration between compute hosts.
+	// +optional
+	LiveMigration NovaLiveMigrationSpec `json:"liveMigration,omitempty"`
+}
+
+// NovaLiveMigrationSpec configures how compute hosts authenticate to each
+// other and which network migration traffic uses.
+type NovaLiveMigrationSpec struct {
+	// Transport secures migrations. ssh tunnels libvirt over SSH with an
+	// operator-generated keypair. tls uses libvirt and QEMU native TLS with
+	// per-host certificates signed by a migration CA issued from IssuerRef:
+	// each host submits a CertificateSigningRequest for its own key, which
+	// the operator signs after checking it against the requesting pod.
+	// none leaves live migration unconfigured.
+	// +kubebuilder:validation:Enum=ssh;tls;none
+	// +kubebuilder:default="ssh"
+	// +optional
+	Transport string `json:"transport,omitempty"`
+
+	// NetworkCIDR is the migration network. Each compute host advertises
+	// its address in this network as live_migration_inbound_addr. Defaults
+	// to the host's primary address.
+	// +kubebuilder:validation:Pattern=`^[0-9a-fA-F.:]+/[0-9]+$`
+	// +optional
+	NetworkCIDR string `json:"networkCIDR,omitempty"`
+
+	// IssuerRef issues the migration CA for the tls transport. Defaults to
+	// the OpenStackControlPlane TLS issuer.
+	// +optional
+	IssuerRef *CertIssuerRef `json:"issuerRef,omitempty"`
+}
+
+// NovaCellSpec defines one Nova cell.
+type NovaCellSpec struct {
+	// Name is the cell name; it also names the cell database (nova_<name>).
+	// +kubebuilder:validation:Pattern=`^[a-z][a-z0-9]*$`
+	// +kubebuilder:validation:MaxLength=20
+	Name string `json:"name"`
+
+	// Database configures the cell database credentials. Defaults to the
+	// top-level Nova database credentials.
+	// +optional
+	Database DatabaseConfig `json:"database,omitempty"`
+
+	// DatabaseInstance names the MariaDB in the namespace that hosts the cell
+	// database. Defaults to the shared MariaDB.
+	// +optional
+	DatabaseInstance string `json:"databaseInstance,omitempty"`
+
+	// MessageQueue configures the cell message queue credentials. Defaults to
+	// those of the RabbitMQ selected by MessageQueueInstance.
+	// +optional
+	MessageQueue RabbitMQConfig `json:"messageQueue,omitempty"`
+
+	// MessageQueueInstance names the RabbitMQ in the namespace used by the
+	// cell. Defaults to the shared RabbitMQ, on the cell's own vhost.
+	// +optional
+	MessageQueueInstance string `json:"messageQueueInstance,omitempty"`
+
+	// ConductorReplicas is the number of nova-conductor replicas of the cell.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ConductorReplicas *int32 `json:"conductorReplicas,omitempty"`
+
+	// ComputeNodeSelector selects the Kubernetes nodes whose compute hosts
+	// belong to this cell.
+	// +optional
+	ComputeNodeSelector map[string]string `json:"computeNodeSelector,omitempty"`
 }
 
 // NovaStatus defines the observed state of Nova.
@@ -47,8 +142,124 @@ type NovaStatus struct {
 	// APIEndpoint is the internal API URL of the Nova service.
 	// +optional
 	APIEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// Components reports the readiness of each control-plane component.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Components []NovaComponentStatus `json:"components,omitempty"`
+
+	// Cells reports the mapped cells, including cells removed from the spec
+	// whose removal is blocked.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Cells []NovaCellStatus `json:"cells,omitempty"`
+
+	// CephSecretName is the Secret holding ceph.conf, the client.nova
+	// keyring (ceph.client.nova.keyring, key) and the libvirt secret UUID
+	// (uuid) for RBD disks. Compute hosts define the libvirt secret from it:
+	// in-cluster computes directly, data plane computes through the
+	// <dataplane>-nova-compute Secret published for their provisioning.
+	// Cinder's RBD backends reference the same UUID. Only set with ceph
+	// ephemeral storage or when a Cinder in the namespace has ceph backends.
+	// +optional
+	CephSecretName string `json:"cephSecretName,omitempty"`
+
+	// MigrationSecretName is the Secret holding the live migration
+	// credentials: the SSH keypair (id_ecdsa, authorized_keys), host key
+	// (ssh_host_ecdsa_key) and known_hosts, or the migration CA, whose
+	// certificate (tls.crt) is the hosts' only trust anchor and whose key
+	// (tls.key) only the operator reads. Data plane computes install the
+	// credentials, and with tls a certificate per host signed by the CA,
+	// from the <dataplane>-nova-compute Secret published for their
+	// provisioning.
+	// +optional
+	MigrationSecretName string `json:"migrationSecretName,omitempty"`
+
+	// Computes reports the nodes running in-cluster nova-compute.
+	// +listType=map
+	// +listMapKey=node
+	// +optional
+	Computes []NovaComputeStatus `json:"computes,omitempty"`
 }
 
+// NovaComponentStatus reports the readiness of one Nova control-plane component.
+type NovaComponentStatus struct {
+	// Name is the component: api, scheduler, conductor (the super-conductor),
+	// metadata, or <cell>-conductor and <cell>-novncproxy for each cell.
+	Name string `json:"name"`
+
+	// Ready is true when all replicas of the component are available.
+	Ready bool `json:"ready"`
+
+	// ReadyReplicas is the number of ready pods.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+}
+
+// NovaCellStatus reports the state of one cell.
+type NovaCellStatus struct {
+	// Name is the cell name.
+	Name string `json:"name"`
+
+	// UUID is the cell mapping UUID.
+	// +optional
+	UUID string `json:"uuid,omitempty"`
+
+	// Hosts is the number of compute hosts mapped to the cell.
+	// +optional
+	Hosts int32 `json:"hosts,omitempty"`
+
+	// Removing is true when the cell was removed from the spec but still has
+	// hosts or instances, so its mapping and conductors are kept.
+	// +optional
+	Removing bool `json:"removing,omitempty"`
+}
+
+// NovaComputeStatus reports one in-cluster compute host. The node name is
+// the Nova host name, so service records survive pod restarts.
+type NovaComputeStatus struct {
+	// Node is the Kubernetes node and Nova compute host name.
+	Node string `json:"node"`
+
+	// Cell is the cell the host belongs to.
+	Cell string `json:"cell"`
+
+	// AvailabilityZone is the availability zone the node is labelled with.
+	// +optional
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+
+	// Ready is true when the compute pod on the node is ready.
+	Ready bool `json:"ready"`
+
+	// Removing is true when the node is no longer wanted but still hosts
+	// instances, so its compute pod is kept.
+	// +optional
+	Removing bool `json:"removing,omitempty"`
+}
+
+const (
+	// ConditionCellsReady indicates every cell is mapped and no cell removal is blocked.
+	ConditionCellsReady ConditionType = "CellsReady"
+
+	// ConditionComputeReady indicates every assigned node runs a ready
+	// nova-compute and no compute removal is blocked.
+	ConditionComputeReady ConditionType = "ComputeReady"
+
+	// ConditionCephSecretReady indicates every compute host, in-cluster or
+	// data plane, defines the libvirt secret for RBD disks and volumes.
+	// Data plane computes count once their provisioning reports the current
+	// <dataplane>-nova-compute Secret installed.
+	ConditionCephSecretReady ConditionType = "CephSecretReady"
+
+	// ConditionMigrationReady indicates every compute host runs with the
+	// current live migration credentials, completing the mesh. Data plane
+	// computes count once their provisioning reports the current
+	// <dataplane>-nova-compute Secret installed.
+	ConditionMigrationReady ConditionType = "MigrationReady"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
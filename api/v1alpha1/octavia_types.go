@@ -5,6 +5,7 @@ import (
 )
 
 // OctaviaSpec defines the desired state of the Octavia (Load Balancer) service.
+// +kubebuilder:validation:XValidation:rule="!has(self.provider) || self.provider != 'ovn' || (!has(self.amphoraImageSecretName) && !has(self.managementNetworkCIDR) && !has(self.amphoraFlavor) && !has(self.workerReplicas) && !has(self.healthManagerReplicas))",message="amphoraImageSecretName, managementNetworkCIDR, amphoraFlavor, workerReplicas and healthManagerReplicas are only valid for provider amphora"
 type OctaviaSpec struct {
 	ServiceTemplate `json:",inline"`
 
@@ -16,31 +17,81 @@ type OctaviaSpec struct {
 	// +optional
 	MessageQueue RabbitMQConfig `json:"messageQueue,omitempty"`
 
-	// Provider selects the load balancer provider.
+	// Provider selects the load balancer provider. The amphora provider
+	// runs each load balancer in a service VM. The ovn provider implements
+	// L4 load balancers in OVN itself and only runs octavia-api with the
+	// OVN driver agent.
 	// +kubebuilder:validation:Enum=amphora;ovn
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="provider is immutable"
 	// +kubebuilder:default="amphora"
 	// +optional
 	Provider string `json:"provider,omitempty"`
 
-	// AmphoraImageSecretName references a Secret containing the amphora image URL/credentials.
-	// Only used when provider is "amphora".
+	// AmphoraImageSecretName references a Secret naming the amphora image.
+	// With a url key the image is imported into Glance from that URL; with
+	// an image key, the name or ID of an image already in Glance, that
+	// image is used. Either way the image is tagged amphora, and Octavia
+	// boots the newest image with the tag and the same owner. Only used
+	// when provider is "amphora".
 	// +optional
 	AmphoraImageSecretName string `json:"amphoraImageSecretName,omitempty"`
 
-	// ManagementNetworkCIDR is the CIDR for the Octavia management network.
-	// +kubebuilder:default="172.23.0.0/24"
+	// ManagementNetworkCIDR is the CIDR of lb-mgmt-net, the network the
+	// controllers reach the amphorae on. Defaults to 172.23.0.0/24.
+	// +kubebuilder:validation:Pattern=`^([0-9]{1,3}\.){3}[0-9]{1,3}/[0-9]{1,2}$`
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="managementNetworkCIDR is immutable"
 	// +optional
 	ManagementNetworkCIDR string `json:"managementNetworkCIDR,omitempty"`
 
-	// WorkerReplicas is the number of octavia-worker replicas.
-	// +kubebuilder:default=1
+	// AmphoraFlavor sizes the Nova flavor amphorae are booted with.
+	// +optional
+	AmphoraFlavor *OctaviaAmphoraFlavorSpec `json:"amphoraFlavor,omitempty"`
+
+	// WorkerReplicas is the number of octavia-worker replicas. Defaults to 1.
 	// +optional
 	WorkerReplicas *int32 `json:"workerReplicas,omitempty"`
 
-	// HealthManagerReplicas is the number of octavia-health-manager replicas.
-	// +kubebuilder:default=1
+	// HealthManagerReplicas is the number of octavia-health-manager
+	// instances. Each runs on its own registered OVN chassis node, with a
+	// port on lb-mgmt-net the amphorae send heartbeats to. Nodes are chosen
+	// by name and kept while their chassis stays registered.
+	// The worker and housekeeping run on the same nodes to reach the
+	// amphorae. Existing amphorae keep the health manager addresses they
+	// were built with until reconfigured with
+	// "openstack loadbalancer amphora configure". Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
 	// +optional
 	HealthManagerReplicas *int32 `json:"healthManagerReplicas,omitempty"`
+
+	// AmphoraCADeletionPolicy decides what happens to the amphora server
+	// and client CAs when the Octavia is deleted. Existing amphorae only
+	// trust the controllers through them, so they are retained unless
+	// deletion is confirmed by setting Delete.
+	// +kubebuilder:validation:Enum=Retain;Delete
+	// +kubebuilder:default="Retain"
+	// +optional
+	AmphoraCADeletionPolicy string `json:"amphoraCADeletionPolicy,omitempty"`
+}
+
+// OctaviaAmphoraFlavorSpec defines the Nova flavor of the amphorae.
+type OctaviaAmphoraFlavorSpec struct {
+	// VCPUs is the number of virtual CPUs.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	VCPUs int32 `json:"vcpus,omitempty"`
+
+	// RAM is the memory size in MiB.
+	// +kubebuilder:default=1024
+	// +kubebuilder:validation:Minimum=512
+	// +optional
+	RAM int32 `json:"ram,omitempty"`
+
+	// Disk is the root disk size in GiB.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=2
+	// +optional
+	Disk int32 `json:"disk,omitempty"`
 }
 
 // OctaviaStatus defines the observed state of Octavia.
@@ -50,12 +101,110 @@ type OctaviaStatus struct {
 	// APIEndpoint is the internal API URL of the Octavia service.
 	// +optional
 	APIEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// Amphora records the OpenStack resources created for the amphora
+	// provider.
+	// +optional
+	Amphora *OctaviaAmphoraStatus `json:"amphora,omitempty"`
+}
+
+// OctaviaAmphoraStatus reports the resources amphorae are built from.
+type OctaviaAmphoraStatus struct {
+	// ProjectID is the service project the amphorae and their resources
+	// belong to.
+	// +optional
+	ProjectID string `json:"projectID,omitempty"`
+
+	// ManagementNetworkID is the ID of lb-mgmt-net.
+	// +optional
+	ManagementNetworkID string `json:"managementNetworkID,omitempty"`
+
+	// ManagementSubnetID is the ID of the subnet of lb-mgmt-net.
+	// +optional
+	ManagementSubnetID string `json:"managementSubnetID,omitempty"`
+
+	// ManagementNetworkMTU is the MTU of lb-mgmt-net, which the health
+	// manager interfaces are set to.
+	// +optional
+	ManagementNetworkMTU int32 `json:"managementNetworkMTU,omitempty"`
+
+	// SecurityGroupID is the security group of the amphorae.
+	// +optional
+	SecurityGroupID string `json:"securityGroupID,omitempty"`
+
+	// HealthManagerSecurityGroupID is the security group of the health
+	// manager ports.
+	// +optional
+	HealthManagerSecurityGroupID string `json:"healthManagerSecurityGroupID,omitempty"`
+
+	// FlavorID is the Nova flavor of the amphorae.
+	// +optional
+	FlavorID string `json:"flavorID,omitempty"`
+
+	// KeypairName is the Nova keypair of the octavia user injected into
+	// the amphorae.
+	// +optional
+	KeypairName string `json:"keypairName,omitempty"`
+
+	// ImageID is the amphora image.
+	// +optional
+	ImageID string `json:"imageID,omitempty"`
+
+	// ImageOwnerID is the project owning the amphora image.
+	// +optional
+	ImageOwnerID string `json:"imageOwnerID,omitempty"`
+
+	// HealthManagers lists the health manager ports on lb-mgmt-net.
+	// +optional
+	HealthManagers []OctaviaHealthManagerStatus `json:"healthManagers,omitempty"`
 }
 
+// OctaviaHealthManagerStatus reports the lb-mgmt-net port of a health manager.
+type OctaviaHealthManagerStatus struct {
+	// Node is the Kubernetes node the port is bound to.
+	Node string `json:"node"`
+
+	// PortID is the Neutron port.
+	PortID string `json:"portID"`
+
+	// MACAddress is the MAC address of the port.
+	MACAddress string `json:"macAddress"`
+
+	// IPAddress is the fixed IP of the port.
+	IPAddress string `json:"ipAddress"`
+}
+
+const (
+	// ConditionAmphoraCertificatesReady indicates the CAs and the client
+	// certificate of the controllers have been generated.
+	ConditionAmphoraCertificatesReady ConditionType = "AmphoraCertificatesReady"
+
+	// ConditionManagementNetworkReady indicates lb-mgmt-net and its subnet exist.
+	ConditionManagementNetworkReady ConditionType = "ManagementNetworkReady"
+
+	// ConditionAmphoraSecurityGroupsReady indicates the security groups of
+	// the amphorae and health managers exist.
+	ConditionAmphoraSecurityGroupsReady ConditionType = "AmphoraSecurityGroupsReady"
+
+	// ConditionAmphoraFlavorReady indicates the amphora flavor exists.
+	ConditionAmphoraFlavorReady ConditionType = "AmphoraFlavorReady"
+
+	// ConditionAmphoraKeypairReady indicates the SSH keypair exists.
+	ConditionAmphoraKeypairReady ConditionType = "AmphoraKeypairReady"
+
+	// ConditionAmphoraImageReady indicates a tagged amphora image is active.
+	ConditionAmphoraImageReady ConditionType = "AmphoraImageReady"
+
+	// ConditionHealthManagerPortsReady indicates every health manager node
+	// has its port on lb-mgmt-net.
+	ConditionHealthManagerPortsReady ConditionType = "HealthManagerPortsReady"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Provider",type=string,JSONPath=`.spec.provider`
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.status.apiEndpoint`
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Octavia is the Schema for the octavias API.
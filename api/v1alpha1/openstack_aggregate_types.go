@@ -0,0 +1,110 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// AvailabilityZoneNodeLabel places an in-cluster compute node in every
+	// OpenStackAggregate with the label's value as availability zone.
+	AvailabilityZoneNodeLabel = "openstack.k8s.io/availability-zone"
+
+	// AggregateNodeLabelPrefix followed by an OpenStackAggregate name, e.g.
+	// aggregate.openstack.k8s.io/gpu, adds an in-cluster compute node to that
+	// aggregate. The label value is ignored.
+	AggregateNodeLabelPrefix = "aggregate.openstack.k8s.io/"
+)
+
+const (
+	// ConditionHostsAssigned indicates all wanted hosts are members of the aggregate.
+	ConditionHostsAssigned ConditionType = "HostsAssigned"
+)
+
+// OpenStackAggregateSpec defines the desired state of a Nova host aggregate.
+//
+// Hosts are gathered from Spec.Hosts, from OpenStackDataPlane nodes in the
+// same namespace listing the aggregate or its availability zone, and from
+// in-cluster compute nodes carrying AggregateNodeLabelPrefix+<name> or
+// AvailabilityZoneNodeLabel. A host is added once its nova-compute service
+// has registered; hosts not gathered are removed from the aggregate.
+//
+// +kubebuilder:validation:XValidation:rule="!has(self.metadata) || !('availability_zone' in self.metadata)",message="set availabilityZone instead of the availability_zone metadata key"
+type OpenStackAggregateSpec struct {
+	// Name is the aggregate name in Nova. Defaults to the resource name.
+	// +kubebuilder:default=""
+	// +kubebuilder:validation:MaxLength=255
+	// +optional
+	Name string `json:"name"`
+
+	// AvailabilityZone exposes the aggregate as an availability zone. A host
+	// can be in only one availability zone.
+	// +kubebuilder:validation:MaxLength=255
+	// +optional
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+
+	// Metadata is the aggregate metadata, matched by scheduler filters and
+	// flavor extra specs. Keys not listed here are removed, except
+	// openstack.k8s.io:owner, which marks the aggregates the operator
+	// manages.
+	// +kubebuilder:validation:XValidation:rule="!('openstack.k8s.io:owner' in self)",message="openstack.k8s.io:owner is reserved"
+	// +optional
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Hosts lists additional Nova compute host names to add.
+	// +listType=set
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// OpenStackAggregateStatus defines the observed state of OpenStackAggregate.
+type OpenStackAggregateStatus struct {
+	CommonStatus `json:",inline"`
+
+	// AggregateID is the ID of the aggregate in Nova.
+	// +optional
+	AggregateID int `json:"aggregateID,omitempty"`
+
+	// AvailabilityZone is the availability zone of the aggregate in Nova.
+	// +optional
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+
+	// Hosts are the hosts currently in the aggregate.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+
+	// PendingHosts are wanted hosts not yet added, either because their
+	// nova-compute service has not registered or because Nova refused them,
+	// e.g. as they already belong to another availability zone.
+	// +optional
+	PendingHosts []string `json:"pendingHosts,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=osaggregate
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="AZ",type=string,JSONPath=`.status.availabilityZone`
+// +kubebuilder:printcolumn:name="ID",type=integer,JSONPath=`.status.aggregateID`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// OpenStackAggregate is the Schema for the openstackaggregates API.
+type OpenStackAggregate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OpenStackAggregateSpec   `json:"spec,omitempty"`
+	Status OpenStackAggregateStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// OpenStackAggregateList contains a list of OpenStackAggregate.
+type OpenStackAggregateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OpenStackAggregate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OpenStackAggregate{}, &OpenStackAggregateList{})
+}
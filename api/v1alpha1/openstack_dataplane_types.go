@@ -16,8 +16,29 @@ type DataPlaneNodeSpec struct {
 	// +kubebuilder:validation:Enum=compute;storage;networker
 	Role string `json:"role"`
 
+	// MigrationIP is the node's address in the Nova live migration network,
+	// which its migration certificate is issued for. Defaults to IP.
+	// +optional
+	MigrationIP string `json:"migrationIP,omitempty"`
+
 	// SSHSecretName references the Secret containing the SSH private key for this node.
 	SSHSecretName string `json:"sshSecretName"`
+
+	// Cell is the Nova cell a compute node is discovered into.
+	// +kubebuilder:default="cell1"
+	// +optional
+	Cell string `json:"cell,omitempty"`
+
+	// AvailabilityZone places a compute node in every OpenStackAggregate in
+	// the namespace with this availability zone.
+	// +kubebuilder:validation:MaxLength=255
+	// +optional
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+
+	// Aggregates lists OpenStackAggregate names the compute node joins.
+	// +listType=set
+	// +optional
+	Aggregates []string `json:"aggregates,omitempty"`
 }
 
 // OpenStackDataPlaneSpec defines the desired state of the data-plane node set.
@@ -64,22 +85,92 @@ type DataPlaneServices struct {
 }
 
 // OpenStackDataPlaneStatus defines the observed state of OpenStackDataPlane.
+//
+// The operator does not provision the nodes. Provisioning, typically the
+// run of AnsiblePlaybook, reports to it through the status subresource:
+// it owns DeployedNodes, NovaComputeConfigHash and the Ready condition,
+// and the operator owns the other fields. Writers update the status with
+// the resourceVersion they read, or patch only their own fields, so that
+// neither side overwrites the other: a status update the operator computed
+// from an older object fails with a conflict and is retried.
 type OpenStackDataPlaneStatus struct {
 	CommonStatus `json:",inline"`
 
-	// DeployedNodes is the count of nodes that have been successfully provisioned.
+	// DeployedNodes is the count of nodes that have been successfully
+	// provisioned. It is written by provisioning, not the operator, which
+	// runs Nova host discovery whenever the count rises above
+	// DiscoveredNodes. Provisioning counts a node once its services are
+	// installed and started; it lowers the count when nodes are removed.
 	// +optional
 	DeployedNodes int32 `json:"deployedNodes,omitempty"`
 
 	// TotalNodes is the total number of nodes in the node set.
 	// +optional
 	TotalNodes int32 `json:"totalNodes,omitempty"`
+
+	// RegisteredNodes is the count of nodes with every registration expected
+	// for their role in place.
+	// +optional
+	RegisteredNodes int32 `json:"registeredNodes,omitempty"`
+
+	// DiscoveredNodes is the DeployedNodes count for which every deployed
+	// node has been verified as registered. While DeployedNodes is higher,
+	// Nova host discovery runs for the cells of registered but unmapped
+	// compute hosts.
+	// +optional
+	DiscoveredNodes int32 `json:"discoveredNodes,omitempty"`
+
+	// Nodes reports the registration of each node with Nova and OVN.
+	// +optional
+	Nodes []DataPlaneNodeStatus `json:"nodes,omitempty"`
+
+	// NovaComputeConfigHash is written by provisioning, not the operator.
+	// Nova publishes the host configuration compute nodes need from it, the
+	// libvirt Ceph secret and the live migration credentials, in the
+	// <name>-nova-compute Secret, whose
+	// openstack.k8s.io/config-hash annotation changes with its content.
+	// Provisioning sets this field to that annotation once every deployed
+	// compute node has installed the Secret's content.
+	// +optional
+	NovaComputeConfigHash string `json:"novaComputeConfigHash,omitempty"`
 }
 
+// DataPlaneNodeStatus reports the registration state of one data-plane node.
+type DataPlaneNodeStatus struct {
+	// Hostname is the node's hostname, which is also its Nova host name.
+	Hostname string `json:"hostname"`
+
+	// ComputeRegistered is true when the node's nova-compute service is up.
+	// Only set for compute nodes.
+	// +optional
+	ComputeRegistered bool `json:"computeRegistered,omitempty"`
+
+	// HostMapped is true when host discovery mapped the node to its cell.
+	// Only set for compute nodes.
+	// +optional
+	HostMapped bool `json:"hostMapped,omitempty"`
+
+	// ChassisRegistered is true when the node's ovn-controller registered a
+	// chassis in the OVN southbound database. Only set for compute and
+	// networker nodes.
+	// +optional
+	ChassisRegistered bool `json:"chassisRegistered,omitempty"`
+
+	// Registered is true when every registration expected for the node's
+	// role is in place.
+	Registered bool `json:"registered"`
+}
+
+const (
+	// ConditionNodesRegistered indicates all nodes registered with Nova and OVN.
+	ConditionNodesRegistered ConditionType = "NodesRegistered"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
 // +kubebuilder:printcolumn:name="Deployed",type=integer,JSONPath=`.status.deployedNodes`
+// +kubebuilder:printcolumn:name="Registered",type=integer,JSONPath=`.status.registeredNodes`
 // +kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.totalNodes`
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
@@ -0,0 +1,129 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OpenStackFlavorSpec defines the desired state of a Nova flavor.
+//
+// Nova flavors are immutable apart from their description, extra specs and
+// project access. A change to the name, sizes or visibility therefore needs
+// a replacement, controlled by UpdatePolicy:
+//   - Recreate (default) deletes the flavor and creates it again with the
+//     same name and a new ID. Running instances keep working, as Nova stores
+//     a copy of the flavor with each instance; references by the old ID, e.g.
+//     in Heat templates, must be updated.
+//   - Reject refuses such changes; create a new OpenStackFlavor under a new
+//     name instead and delete the old one once it is no longer used.
+//
+// +kubebuilder:validation:XValidation:rule="self.updatePolicy != 'Reject' || (self.name == oldSelf.name && self.vcpus == oldSelf.vcpus && self.ram == oldSelf.ram && self.disk == oldSelf.disk && self.ephemeral == oldSelf.ephemeral && self.swap == oldSelf.swap && self.public == oldSelf.public)",message="name, sizes and visibility are immutable with updatePolicy Reject"
+// +kubebuilder:validation:XValidation:rule="!self.public || !has(self.projectAccess) || size(self.projectAccess) == 0",message="projectAccess requires a private flavor"
+type OpenStackFlavorSpec struct {
+	// Name is the flavor name in Nova. Defaults to the resource name. An
+	// existing flavor with this name that was not created by this resource
+	// is not adopted; the resource reports FlavorNotManaged instead.
+	// +kubebuilder:default=""
+	// +kubebuilder:validation:MaxLength=255
+	// +optional
+	Name string `json:"name"`
+
+	// Description is a free-form description shown to users.
+	// +kubebuilder:validation:MaxLength=65535
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// VCPUs is the number of virtual CPUs.
+	// +kubebuilder:validation:Minimum=1
+	VCPUs int32 `json:"vcpus"`
+
+	// RAM is the memory size in MiB.
+	// +kubebuilder:validation:Minimum=1
+	RAM int32 `json:"ram"`
+
+	// Disk is the root disk size in GiB; 0 sizes the root disk to the image.
+	// +kubebuilder:default=0
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Disk int32 `json:"disk"`
+
+	// Ephemeral is the ephemeral disk size in GiB.
+	// +kubebuilder:default=0
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Ephemeral int32 `json:"ephemeral"`
+
+	// Swap is the swap disk size in MiB.
+	// +kubebuilder:default=0
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Swap int32 `json:"swap"`
+
+	// ExtraSpecs are the flavor extra specs, e.g. hw:cpu_policy=dedicated or
+	// trait:HW_CPU_X86_AVX2=required. Keys not listed here are removed, except
+	// openstack.k8s.io:owner, which marks the flavors the operator manages.
+	// +kubebuilder:validation:XValidation:rule="!('openstack.k8s.io:owner' in self)",message="openstack.k8s.io:owner is reserved"
+	// +optional
+	ExtraSpecs map[string]string `json:"extraSpecs,omitempty"`
+
+	// Public makes the flavor visible to all projects.
+	// +kubebuilder:default=true
+	// +optional
+	Public bool `json:"public"`
+
+	// ProjectAccess lists the projects, by name or ID, that may use a private
+	// flavor. Projects not listed here lose access.
+	// +listType=set
+	// +optional
+	ProjectAccess []string `json:"projectAccess,omitempty"`
+
+	// UpdatePolicy selects how changes to immutable flavor fields are applied.
+	// +kubebuilder:validation:Enum=Recreate;Reject
+	// +kubebuilder:default="Recreate"
+	// +optional
+	UpdatePolicy string `json:"updatePolicy"`
+}
+
+// OpenStackFlavorStatus defines the observed state of OpenStackFlavor.
+type OpenStackFlavorStatus struct {
+	CommonStatus `json:",inline"`
+
+	// FlavorID is the ID of the flavor in Nova.
+	// +optional
+	FlavorID string `json:"flavorID,omitempty"`
+
+	// LastReplacementTime is when the flavor was last deleted and recreated.
+	// +optional
+	LastReplacementTime *metav1.Time `json:"lastReplacementTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=osflavor
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="VCPUs",type=integer,JSONPath=`.spec.vcpus`
+// +kubebuilder:printcolumn:name="RAM",type=integer,JSONPath=`.spec.ram`
+// +kubebuilder:printcolumn:name="Disk",type=integer,JSONPath=`.spec.disk`
+// +kubebuilder:printcolumn:name="ID",type=string,JSONPath=`.status.flavorID`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// OpenStackFlavor is the Schema for the openstackflavors API.
+type OpenStackFlavor struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OpenStackFlavorSpec   `json:"spec,omitempty"`
+	Status OpenStackFlavorStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// OpenStackFlavorList contains a list of OpenStackFlavor.
+type OpenStackFlavorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OpenStackFlavor `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OpenStackFlavor{}, &OpenStackFlavorList{})
+}
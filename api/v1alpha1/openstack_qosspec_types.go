@@ -0,0 +1,71 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OpenStackQoSSpecSpec defines the desired state of Cinder QoS specs, which
+// OpenStackVolumeTypes reference by resource name.
+//
+// +kubebuilder:validation:XValidation:rule="self.name == oldSelf.name",message="name is immutable"
+// +kubebuilder:validation:XValidation:rule="!has(self.specs) || !('consumer' in self.specs)",message="set consumer instead of the consumer spec"
+type OpenStackQoSSpecSpec struct {
+	// Name is the QoS specs name in Cinder. Defaults to the resource name.
+	// Existing specs with this name that were not created by this resource
+	// are not adopted; the resource reports QoSSpecsNotManaged instead.
+	// +kubebuilder:default=""
+	// +kubebuilder:validation:MaxLength=255
+	// +optional
+	Name string `json:"name"`
+
+	// Consumer selects who enforces the limits: the storage backend
+	// (back-end), the hypervisor (front-end) or both.
+	// +kubebuilder:validation:Enum=front-end;back-end;both
+	// +kubebuilder:default="back-end"
+	// +optional
+	Consumer string `json:"consumer"`
+
+	// Specs are the QoS keys, e.g. total_iops_sec or read_bytes_sec for the
+	// front-end consumer. Keys not listed here are removed.
+	// +optional
+	Specs map[string]string `json:"specs,omitempty"`
+}
+
+// OpenStackQoSSpecStatus defines the observed state of OpenStackQoSSpec.
+type OpenStackQoSSpecStatus struct {
+	CommonStatus `json:",inline"`
+
+	// QoSSpecID is the ID of the QoS specs in Cinder.
+	// +optional
+	QoSSpecID string `json:"qosSpecID,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=osqos
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Consumer",type=string,JSONPath=`.spec.consumer`
+// +kubebuilder:printcolumn:name="ID",type=string,JSONPath=`.status.qosSpecID`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// OpenStackQoSSpec is the Schema for the openstackqosspecs API.
+type OpenStackQoSSpec struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OpenStackQoSSpecSpec   `json:"spec,omitempty"`
+	Status OpenStackQoSSpecStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// OpenStackQoSSpecList contains a list of OpenStackQoSSpec.
+type OpenStackQoSSpecList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OpenStackQoSSpec `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OpenStackQoSSpec{}, &OpenStackQoSSpecList{})
+}
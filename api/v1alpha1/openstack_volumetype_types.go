@@ -0,0 +1,140 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OpenStackVolumeTypeSpec defines the desired state of a Cinder volume type.
+//
+// Volume types are updated in place. Cinder refuses to change the extra
+// specs or encryption of a type that volumes use; such changes are reported
+// on the Ready condition until the volumes are gone. The type itself is not
+// deleted while volumes use it.
+//
+// +kubebuilder:validation:XValidation:rule="!has(self.backend) || !has(self.extraSpecs) || !('volume_backend_name' in self.extraSpecs)",message="set backend instead of the volume_backend_name extra spec"
+// +kubebuilder:validation:XValidation:rule="!self.public || !has(self.projectAccess) || size(self.projectAccess) == 0",message="projectAccess requires a private volume type"
+type OpenStackVolumeTypeSpec struct {
+	// Name is the volume type name in Cinder. Defaults to the resource name.
+	// A type named after a Cinder backend is that backend's type and must
+	// bind to it; it is adopted, and kept when the resource is deleted. Any
+	// other existing type with this name that was not created by this
+	// resource is not adopted; the resource reports VolumeTypeNotManaged
+	// instead.
+	// +kubebuilder:default=""
+	// +kubebuilder:validation:MaxLength=255
+	// +optional
+	Name string `json:"name"`
+
+	// Description is a free-form description shown to users.
+	// +kubebuilder:validation:MaxLength=255
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// Backend binds the type to a backend of the Cinder in the namespace
+	// through the volume_backend_name extra spec. When unset, the scheduler
+	// may place volumes on any backend matching the extra specs.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+
+	// ExtraSpecs are the volume type extra specs, e.g. thin_provisioning_support
+	// or RESKEY:availability_zones. Keys not listed here are removed.
+	// +optional
+	ExtraSpecs map[string]string `json:"extraSpecs,omitempty"`
+
+	// Public makes the volume type visible to all projects.
+	// +kubebuilder:default=true
+	// +optional
+	Public bool `json:"public"`
+
+	// ProjectAccess lists the projects, by name or ID, that may use a private
+	// volume type. Projects not listed here lose access.
+	// +listType=set
+	// +optional
+	ProjectAccess []string `json:"projectAccess,omitempty"`
+
+	// QoSSpecName names an OpenStackQoSSpec in the same namespace to
+	// associate with the type.
+	// +optional
+	QoSSpecName string `json:"qosSpecName,omitempty"`
+
+	// Encryption encrypts volumes of this type with keys stored in Barbican,
+	// which must be deployed in the namespace.
+	// +optional
+	Encryption *VolumeTypeEncryption `json:"encryption,omitempty"`
+}
+
+// VolumeTypeEncryption configures volume encryption for a volume type.
+type VolumeTypeEncryption struct {
+	// Provider is the encryption format.
+	// +kubebuilder:validation:Enum=luks;plain
+	// +kubebuilder:default="luks"
+	// +optional
+	Provider string `json:"provider"`
+
+	// Cipher is the dm-crypt cipher.
+	// +kubebuilder:default="aes-xts-plain64"
+	// +optional
+	Cipher string `json:"cipher"`
+
+	// KeySize is the key size in bits.
+	// +kubebuilder:validation:Enum=128;256;512
+	// +kubebuilder:default=256
+	// +optional
+	KeySize int32 `json:"keySize"`
+
+	// ControlLocation selects where encryption happens: on the compute host
+	// (front-end) or in the storage backend (back-end).
+	// +kubebuilder:validation:Enum=front-end;back-end
+	// +kubebuilder:default="front-end"
+	// +optional
+	ControlLocation string `json:"controlLocation"`
+}
+
+// OpenStackVolumeTypeStatus defines the observed state of OpenStackVolumeType.
+type OpenStackVolumeTypeStatus struct {
+	CommonStatus `json:",inline"`
+
+	// VolumeTypeID is the ID of the volume type in Cinder.
+	// +optional
+	VolumeTypeID string `json:"volumeTypeID,omitempty"`
+
+	// QoSSpecID is the ID of the associated QoS specs.
+	// +optional
+	QoSSpecID string `json:"qosSpecID,omitempty"`
+
+	// Volumes is the number of volumes using the type, as counted when
+	// deletion was last attempted.
+	// +optional
+	Volumes int32 `json:"volumes,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=osvolumetype
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Backend",type=string,JSONPath=`.spec.backend`
+// +kubebuilder:printcolumn:name="Public",type=boolean,JSONPath=`.spec.public`
+// +kubebuilder:printcolumn:name="ID",type=string,JSONPath=`.status.volumeTypeID`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// OpenStackVolumeType is the Schema for the openstackvolumetypes API.
+type OpenStackVolumeType struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   OpenStackVolumeTypeSpec   `json:"spec,omitempty"`
+	Status OpenStackVolumeTypeStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// OpenStackVolumeTypeList contains a list of OpenStackVolumeType.
+type OpenStackVolumeTypeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OpenStackVolumeType `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OpenStackVolumeType{}, &OpenStackVolumeTypeList{})
+}
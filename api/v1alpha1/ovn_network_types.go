@@ -5,6 +5,12 @@ import (
 )
 
 // OVNNetworkSpec defines the desired state of the OVN networking backend.
+// +kubebuilder:validation:XValidation:rule="!has(self.enableRaftClustering) || !self.enableRaftClustering || ((!has(self.northboundDBReplicas) || self.northboundDBReplicas >= 3) && (!has(self.southboundDBReplicas) || self.southboundDBReplicas >= 3))",message="enableRaftClustering requires at least 3 northbound and southbound DB replicas"
+// +kubebuilder:validation:XValidation:rule="(has(self.enableRaftClustering) && self.enableRaftClustering) || ((!has(self.northboundDBReplicas) || self.northboundDBReplicas == 1) && (!has(self.southboundDBReplicas) || self.southboundDBReplicas == 1))",message="more than one DB replica requires enableRaftClustering"
+// +kubebuilder:validation:XValidation:rule="(has(self.sharedWithCNI) && self.sharedWithCNI) == (has(oldSelf.sharedWithCNI) && oldSelf.sharedWithCNI)",message="sharedWithCNI is immutable"
+// +kubebuilder:validation:XValidation:rule="has(self.externalNorthboundDB) == has(self.externalSouthboundDB)",message="externalNorthboundDB and externalSouthboundDB must be set together"
+// +kubebuilder:validation:XValidation:rule="!has(self.externalNorthboundDB) || (has(self.sharedWithCNI) && self.sharedWithCNI)",message="external databases require sharedWithCNI"
+// +kubebuilder:validation:XValidation:rule="!(has(self.sharedWithCNI) && self.sharedWithCNI) || !has(self.maintenance) || (!has(self.maintenance.backup) && !has(self.maintenance.restore) && !has(self.maintenance.compactionInterval))",message="maintenance is not available for databases owned by the CNI"
 type OVNNetworkSpec struct {
 	// NorthboundDBReplicas is the number of OVN Northbound DB replicas.
 	// +kubebuilder:default=1
@@ -32,16 +38,173 @@ type OVNNetworkSpec struct {
 	// +optional
 	EnableRaftClustering bool `json:"enableRaftClustering,omitempty"`
 
+	// TLS enables ssl: connections to the NB/SB databases using a certificate
+	// issued by the referenced cert-manager issuer.
+	// +optional
+	TLS TLSConfig `json:"tls,omitempty"`
+
+	// Maintenance configures backups, restore and compaction of the OVN databases.
+	// +optional
+	Maintenance OVNMaintenanceSpec `json:"maintenance,omitempty"`
+
+	// Chassis configures ovn-controller and the OVN metadata agent on
+	// Kubernetes-hosted compute nodes. They are deployed while a Nova in the
+	// namespace runs compute inside Kubernetes (computeReplicas > 0).
+	// +optional
+	Chassis OVNChassisSpec `json:"chassis,omitempty"`
+
 	// SharedWithCNI indicates whether Neutron should share the OVN control plane
-	// with the Kubernetes CNI (e.g., OVN-Kubernetes, Kube-OVN).
+	// with the Kubernetes CNI (e.g., OVN-Kubernetes, Kube-OVN). The operator
+	// then deploys no NB/SB databases or northd of its own.
 	// +kubebuilder:default=false
 	// +optional
 	SharedWithCNI bool `json:"sharedWithCNI,omitempty"`
 
+	// CNIProvider selects where the CNI's databases are discovered when no
+	// external connection strings are given. Unset tries each provider.
+	// +kubebuilder:validation:Enum=ovn-kubernetes;kube-ovn
+	// +optional
+	CNIProvider string `json:"cniProvider,omitempty"`
+
 	// ExternalNorthboundDB is the connection string for an external OVN NB DB
 	// (used when sharedWithCNI is true).
 	// +optional
 	ExternalNorthboundDB string `json:"externalNorthboundDB,omitempty"`
+
+	// ExternalSouthboundDB is the connection string for an external OVN SB DB
+	// (used when sharedWithCNI is true).
+	// +optional
+	ExternalSouthboundDB string `json:"externalSouthboundDB,omitempty"`
+
+	// ExternalTLSSecretName is a Secret in this namespace with the client
+	// certificate (tls.crt, tls.key, ca.crt) for ssl: external databases.
+	// +optional
+	ExternalTLSSecretName string `json:"externalTLSSecretName,omitempty"`
+}
+
+// OVNChassisSpec defines how Kubernetes nodes are turned into OVN chassis.
+type OVNChassisSpec struct {
+	// NodeSelector selects the nodes that run ovn-controller and the OVN
+	// metadata agent.
+	// +kubebuilder:default={"openstack.k8s.io/compute":"true"}
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// GatewayNodeLabel is the node label (set to "true") that additionally
+	// makes a chassis a gateway chassis for north-south traffic.
+	// +kubebuilder:default="openstack.k8s.io/network-gateway"
+	// +optional
+	GatewayNodeLabel string `json:"gatewayNodeLabel,omitempty"`
+
+	// BridgeMappings maps physical networks to OVS bridges ("physnet1:br-ex").
+	// Defaults to physnet1 on the Neutron externalBridge.
+	// +optional
+	BridgeMappings []string `json:"bridgeMappings,omitempty"`
+}
+
+// OVNMaintenanceSpec defines day-2 operations for the OVN databases.
+type OVNMaintenanceSpec struct {
+	// Backup schedules ovsdb-client backup snapshots of both databases.
+	// +optional
+	Backup *OVNBackupSpec `json:"backup,omitempty"`
+
+	// Restore seeds a new cluster from backup snapshots. It only takes effect
+	// while the database StatefulSets do not exist yet.
+	// +optional
+	Restore *OVNRestoreSpec `json:"restore,omitempty"`
+
+	// CompactionInterval triggers online compaction (ovsdb-server/compact)
+	// on every member at this interval. Unset leaves compaction to ovsdb-server.
+	// +optional
+	CompactionInterval *metav1.Duration `json:"compactionInterval,omitempty"`
+}
+
+// OVNBackupSpec defines scheduled backups of the OVN databases.
+type OVNBackupSpec struct {
+	// Schedule is a cron expression for the backup CronJob.
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// Retention is the number of snapshots kept per database.
+	// +kubebuilder:default=7
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Retention int32 `json:"retention,omitempty"`
+
+	// Storage defines the backup PVC (<name>-ovndb-backup). The claim is not
+	// owned by the OVNNetwork, so snapshots outlive the cluster they came from.
+	// +optional
+	Storage StorageConfig `json:"storage,omitempty"`
+}
+
+// OVNRestoreSpec selects the snapshots a new cluster is created from.
+type OVNRestoreSpec struct {
+	// ClaimName is the PVC holding the snapshots, usually the
+	// <name>-ovndb-backup claim of the cluster being replaced.
+	// +kubebuilder:validation:MinLength=1
+	ClaimName string `json:"claimName"`
+
+	// NorthboundFile is the NB snapshot file name within the claim.
+	// +kubebuilder:validation:MinLength=1
+	NorthboundFile string `json:"northboundFile"`
+
+	// SouthboundFile is the SB snapshot file name within the claim.
+	// +kubebuilder:validation:MinLength=1
+	SouthboundFile string `json:"southboundFile"`
+}
+
+// OVNRaftStatus reports the Raft state of one clustered database.
+type OVNRaftStatus struct {
+	// ClusterID is the short Raft cluster ID.
+	// +optional
+	ClusterID string `json:"clusterID,omitempty"`
+
+	// Leader is the pod currently holding Raft leadership.
+	// +optional
+	Leader string `json:"leader,omitempty"`
+
+	// Term is the current Raft term as seen by the leader.
+	// +optional
+	Term int64 `json:"term,omitempty"`
+
+	// Members lists the state reported by each database pod.
+	// +optional
+	Members []OVNRaftMemberStatus `json:"members,omitempty"`
+}
+
+// OVNRaftMemberStatus is the Raft state reported by one database pod.
+type OVNRaftMemberStatus struct {
+	// Pod is the name of the database pod.
+	Pod string `json:"pod"`
+
+	// ServerID is the member's short Raft server ID.
+	// +optional
+	ServerID string `json:"serverID,omitempty"`
+
+	// Role is leader, follower or candidate.
+	// +optional
+	Role string `json:"role,omitempty"`
+
+	// Status is the membership status, e.g. "cluster member" or "joining cluster".
+	// +optional
+	Status string `json:"status,omitempty"`
+}
+
+// OVNChassisStatus reports one Kubernetes-hosted chassis.
+type OVNChassisStatus struct {
+	// Node is the Kubernetes node name, also used as the OVN system-id.
+	Node string `json:"node"`
+
+	// Registered is true once the node has a Chassis row in the SB database.
+	Registered bool `json:"registered"`
+
+	// Gateway is true if the chassis is configured as a gateway chassis.
+	// +optional
+	Gateway bool `json:"gateway,omitempty"`
+
+	// EncapIP is the tunnel endpoint address registered for the chassis.
+	// +optional
+	EncapIP string `json:"encapIP,omitempty"`
 }
 
 // OVNNetworkStatus defines the observed state of OVNNetwork.
@@ -49,17 +212,82 @@ type OVNNetworkStatus struct {
 	CommonStatus `json:",inline"`
 
 	// NorthboundDBEndpoint is the connection string for the OVN NB DB.
+	// With Raft clustering this is a comma-separated list of all members.
 	// +optional
 	NorthboundDBEndpoint string `json:"northboundDBEndpoint,omitempty"`
 
 	// SouthboundDBEndpoint is the connection string for the OVN SB DB.
+	// With Raft clustering this is a comma-separated list of all members.
 	// +optional
 	SouthboundDBEndpoint string `json:"southboundDBEndpoint,omitempty"`
+
+	// TLSSecretName is the Secret holding the client certificate (tls.crt,
+	// tls.key, ca.crt) for ssl: endpoints. Empty when TLS is disabled.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// NorthboundRaft is the Raft state of the NB database (Raft mode only).
+	// +optional
+	NorthboundRaft *OVNRaftStatus `json:"northboundRaft,omitempty"`
+
+	// SouthboundRaft is the Raft state of the SB database (Raft mode only).
+	// +optional
+	SouthboundRaft *OVNRaftStatus `json:"southboundRaft,omitempty"`
+
+	// NorthboundSchemaVersion is the schema version served by the NB database.
+	// +optional
+	NorthboundSchemaVersion string `json:"northboundSchemaVersion,omitempty"`
+
+	// SouthboundSchemaVersion is the schema version served by the SB database.
+	// +optional
+	SouthboundSchemaVersion string `json:"southboundSchemaVersion,omitempty"`
+
+	// Chassis reports the SB registration of each selected chassis node.
+	// +optional
+	Chassis []OVNChassisStatus `json:"chassis,omitempty"`
+
+	// MetadataSecretName is the Secret (key: "secret") holding the metadata
+	// proxy shared secret that Nova's metadata API must also use.
+	// +optional
+	MetadataSecretName string `json:"metadataSecretName,omitempty"`
+
+	// LastBackupTime is when the backup CronJob last completed successfully.
+	// +optional
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+
+	// LastCompactionTime is when online compaction last ran on all members.
+	// +optional
+	LastCompactionTime *metav1.Time `json:"lastCompactionTime,omitempty"`
 }
 
+const (
+	// ConditionNorthboundDBReady indicates all NB ovsdb-server replicas are ready.
+	ConditionNorthboundDBReady ConditionType = "NorthboundDBReady"
+
+	// ConditionSouthboundDBReady indicates all SB ovsdb-server replicas are ready.
+	ConditionSouthboundDBReady ConditionType = "SouthboundDBReady"
+
+	// ConditionNorthdReady indicates the ovn-northd Deployment is available.
+	ConditionNorthdReady ConditionType = "NorthdReady"
+
+	// ConditionExternalDBReady indicates the CNI's databases are reachable,
+	// schema-compatible and free of logical switch name collisions.
+	ConditionExternalDBReady ConditionType = "ExternalDBReady"
+
+	// ConditionChassisReady indicates every selected compute node is
+	// registered as a chassis in the SB database.
+	ConditionChassisReady ConditionType = "ChassisReady"
+
+	// ConditionDBRestored indicates the databases were seeded from backup snapshots.
+	ConditionDBRestored ConditionType = "DBRestored"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Raft",type=boolean,JSONPath=`.spec.enableRaftClustering`
+// +kubebuilder:printcolumn:name="NB Leader",type=string,JSONPath=`.status.northboundRaft.leader`,priority=1
+// +kubebuilder:printcolumn:name="SB Leader",type=string,JSONPath=`.status.southboundRaft.leader`,priority=1
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // OVNNetwork is the Schema for the ovnnetworks API.
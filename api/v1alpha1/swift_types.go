@@ -5,48 +5,272 @@ import (
 )
 
 // SwiftSpec defines the desired state of the Swift (Object Storage) service.
+// +kubebuilder:validation:XValidation:rule="self.mode == 'ceph-rgw' || !has(self.rgw)",message="rgw requires mode ceph-rgw"
+// +kubebuilder:validation:XValidation:rule="!has(self.storagePolicy) || !has(self.storagePolicies)",message="storagePolicy is deprecated and cannot be set with storagePolicies"
+// +kubebuilder:validation:XValidation:rule="self.mode != 'ceph-rgw' || !has(self.storagePolicies) || (size(self.storagePolicies) == 1 && self.storagePolicies[0].type == 'replication')",message="ceph-rgw mode supports a single replicated policy"
+// +kubebuilder:validation:XValidation:rule="!has(self.storagePolicies) || self.storagePolicies.all(p, !has(p.devices) || p.devices.all(d, d < (has(self.storageReplicas) ? self.storageReplicas : 3)))",message="policy devices must be ordinals of storage pods below storageReplicas"
+// +kubebuilder:validation:XValidation:rule="!has(self.storagePolicies) || self.storagePolicies.all(p, p.type != 'erasure_coding' || (has(p.devices) ? size(p.devices) : (has(self.storageReplicas) ? self.storageReplicas : 3)) >= p.ecDataFragments + p.ecParityFragments)",message="an erasure coding policy needs at least as many devices as data and parity fragments"
 type SwiftSpec struct {
 	ServiceTemplate `json:",inline"`
 
 	// Mode selects whether to deploy native Swift or use Ceph RGW as a Swift-compatible backend.
+	// With ceph-rgw no swift-proxy is deployed; the RGW is registered as the
+	// object-store endpoint instead. The backends do not share data, so the
+	// mode cannot be changed.
 	// +kubebuilder:validation:Enum=native;ceph-rgw
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="mode is immutable"
 	// +kubebuilder:default="native"
 	// +optional
 	Mode string `json:"mode,omitempty"`
 
+	// RGW configures the Ceph RGW backend in ceph-rgw mode.
+	// +optional
+	RGW *SwiftRGWSpec `json:"rgw,omitempty"`
+
 	// ProxyReplicas is the number of swift-proxy replicas.
 	// +kubebuilder:default=1
 	// +optional
 	ProxyReplicas *int32 `json:"proxyReplicas,omitempty"`
 
-	// StoragePolicy defines the default storage policy (replica count, etc.).
+	// StoragePolicy sets the replica count of the replicated Policy-0 used
+	// when StoragePolicies is empty.
+	//
+	// Deprecated: Use StoragePolicies. It cannot be set with
+	// StoragePolicies.
+	// +optional
+	StoragePolicy *SwiftLegacyStoragePolicy `json:"storagePolicy,omitempty"`
+
+	// StoragePolicies are the storage policies objects can be stored with.
+	// Each policy has its own object ring over its own selection of
+	// devices. Without policies, a replicated Policy-0 is the default, with
+	// the replica count of StoragePolicy or three. The account and container rings use the replica count
+	// of the default policy when it is replicated, and three otherwise.
+	// Policies cannot be removed once defined, as objects may be stored
+	// with them; deprecate them instead. In ceph-rgw mode only a single
+	// replicated policy is supported, which sets the size of the RGW pools.
+	// +listType=map
+	// +listMapKey=name
+	// +kubebuilder:validation:MaxItems=16
+	// +kubebuilder:validation:XValidation:rule="self.exists(p, p.index == 0)",message="a policy with index 0 is required"
+	// +kubebuilder:validation:XValidation:rule="self.exists_one(p, has(p.default) && p.default)",message="exactly one policy must be the default"
+	// +kubebuilder:validation:XValidation:rule="self.all(p, self.exists_one(q, q.index == p.index))",message="policy indexes must be unique"
+	// +kubebuilder:validation:XValidation:rule="oldSelf.all(o, self.exists(p, p.index == o.index))",message="policies cannot be removed; deprecate them instead"
+	// +kubebuilder:validation:XValidation:rule="self.all(p, !has(p.default) || !p.default || !has(p.deprecated) || !p.deprecated)",message="the default policy cannot be deprecated"
 	// +optional
-	StoragePolicy *SwiftStoragePolicy `json:"storagePolicy,omitempty"`
+	StoragePolicies []SwiftStoragePolicy `json:"storagePolicies,omitempty"`
 
 	// Storage defines PVC settings for Swift account/container/object servers.
 	// +optional
 	Storage StorageConfig `json:"storage,omitempty"`
+
+	// StorageReplicas is the number of storage pods in native mode. Each
+	// pod runs the account, container and object servers and their
+	// replicators, auditors and updaters on one device, its PVC. Pods
+	// removed by scaling down are kept until the rings no longer use their
+	// device.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	StorageReplicas *int32 `json:"storageReplicas,omitempty"`
+
+	// Rings configures how the operator builds and rebalances the rings in
+	// native mode. The ring builders are kept in ConfigMap
+	// <name>-ring-builders which, like the storage PVCs, is retained when
+	// the Swift is deleted, so that a Swift recreated with the same name
+	// finds its data where the rings place it. Delete both to start over.
+	// +optional
+	Rings SwiftRingSpec `json:"rings,omitempty"`
+}
+
+// SwiftRGWSpec configures the Ceph RGW serving the Swift API.
+type SwiftRGWSpec struct {
+	// URL is the Swift API root of an existing RGW, including the API
+	// version, e.g. https://rgw.example.com/swift/v1. The RGW must validate
+	// tokens against Keystone and have rgw_swift_account_in_url enabled;
+	// it may use the swift service user the operator creates. Required
+	// when CephStorage is external. With Rook, leave it empty to have the
+	// operator create a CephObjectStore named <namespace>-<name> in the
+	// Rook namespace. Setting it later deletes that store; Rook keeps its
+	// pools, and with them the stored objects.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// Gateways is the number of RGW instances of the CephObjectStore.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Gateways int32 `json:"gateways,omitempty"`
+
+	// AcceptedRoles are the Keystone roles granting access to the object
+	// store.
+	// +kubebuilder:default={"admin","member"}
+	// +optional
+	AcceptedRoles []string `json:"acceptedRoles,omitempty"`
+}
+
+// SwiftRingSpec configures the Swift rings.
+type SwiftRingSpec struct {
+	// PartPower sets the number of partitions to 2^PartPower. It cannot be
+	// changed once the rings are built; allow about 100 partitions per
+	// device at the largest expected size.
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=6
+	// +kubebuilder:validation:Maximum=14
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="partPower is immutable"
+	// +optional
+	PartPower int32 `json:"partPower,omitempty"`
+
+	// MinPartHours is the minimum time between rebalances, and between two
+	// moves of the same partition, giving replication time to copy the
+	// data moved by the previous rebalance.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=168
+	// +optional
+	MinPartHours int32 `json:"minPartHours,omitempty"`
+
+	// WeightStepPercent limits how far a device's weight moves towards its
+	// target, in percent of the target or, when draining, of its current
+	// weight, with each rebalance. New devices thus fill, and removed ones
+	// drain, over several rebalances.
+	// +kubebuilder:default=25
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	WeightStepPercent int32 `json:"weightStepPercent,omitempty"`
+}
+
+// SwiftLegacyStoragePolicy is the default storage policy of the deprecated
+// StoragePolicy field.
+type SwiftLegacyStoragePolicy struct {
+	// ReplicaCount is the number of object replicas.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ReplicaCount int32 `json:"replicaCount,omitempty"`
 }
 
 // SwiftStoragePolicy defines a Swift storage policy.
+// +kubebuilder:validation:XValidation:rule="self.index == oldSelf.index && self.type == oldSelf.type",message="the index and type of a policy are immutable"
+// +kubebuilder:validation:XValidation:rule="self.type == 'erasure_coding' ? (has(self.ecType) && has(self.ecDataFragments) && has(self.ecParityFragments)) : !(has(self.ecType) || has(self.ecDataFragments) || has(self.ecParityFragments))",message="ecType, ecDataFragments and ecParityFragments are required for, and only allowed with, erasure coding"
+// +kubebuilder:validation:XValidation:rule="self.type != 'erasure_coding' || (self.ecType == oldSelf.ecType && self.ecDataFragments == oldSelf.ecDataFragments && self.ecParityFragments == oldSelf.ecParityFragments)",message="the erasure coding scheme of a policy is immutable"
 type SwiftStoragePolicy struct {
-	// ReplicaCount is the number of object replicas.
+	// Name is the policy name clients select with X-Storage-Policy.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9-]+$`
+	// +kubebuilder:validation:MaxLength=64
+	Name string `json:"name"`
+
+	// Index identifies the policy in the stored data and names its object
+	// ring: object for 0, object-<index> otherwise.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=1000
+	Index int32 `json:"index"`
+
+	// Default makes the policy the one used for containers created without
+	// a policy.
+	// +optional
+	Default bool `json:"default,omitempty"`
+
+	// Deprecated keeps the policy for existing containers but refuses new
+	// containers with it.
+	// +optional
+	Deprecated bool `json:"deprecated,omitempty"`
+
+	// Type is the policy type.
+	// +kubebuilder:validation:Enum=replication;erasure_coding
+	// +kubebuilder:default="replication"
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// ReplicaCount is the number of object replicas of a replication policy.
 	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=1
+	// +optional
 	ReplicaCount int32 `json:"replicaCount,omitempty"`
+
+	// ECType is the erasure coding backend of liberasurecode.
+	// +kubebuilder:validation:Enum=liberasurecode_rs_vand;isa_l_rs_vand;isa_l_rs_cauchy;jerasure_rs_vand;jerasure_rs_cauchy
+	// +optional
+	ECType string `json:"ecType,omitempty"`
+
+	// ECDataFragments is the number of data fragments of each object.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ECDataFragments int32 `json:"ecDataFragments,omitempty"`
+
+	// ECParityFragments is the number of parity fragments of each object,
+	// the number of fragments that can be lost.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ECParityFragments int32 `json:"ecParityFragments,omitempty"`
+
+	// Devices selects, by storage pod ordinal, the devices of the policy's
+	// object ring. Empty selects all devices. Devices leaving or joining
+	// the selection drain or fill gradually, like scaled storage pods.
+	// +listType=set
+	// +kubebuilder:validation:MaxItems=256
+	// +optional
+	Devices []int32 `json:"devices,omitempty"`
 }
 
 // SwiftStatus defines the observed state of Swift.
 type SwiftStatus struct {
 	CommonStatus `json:",inline"`
 
-	// APIEndpoint is the internal API URL of the Swift proxy.
+	// APIEndpoint is the internal Swift API URL of the active backend: the
+	// swift-proxy, or the RGW in ceph-rgw mode.
 	// +optional
 	APIEndpoint string `json:"apiEndpoint,omitempty"`
+
+	// Rings reports the rings in native mode.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Rings []SwiftRingStatus `json:"rings,omitempty"`
+}
+
+// SwiftRingStatus reports the state of one ring.
+type SwiftRingStatus struct {
+	// Name is the ring name: account, container, or object and
+	// object-<index> for the storage policies.
+	Name string `json:"name"`
+
+	// Version is incremented with every rebalance.
+	Version int32 `json:"version"`
+
+	// Devices is the number of devices in the ring, including draining ones.
+	Devices int32 `json:"devices"`
+
+	// Balance is the largest deviation, in percent, of a device from the
+	// number of partitions its weight asks for.
+	// +optional
+	Balance string `json:"balance,omitempty"`
+
+	// Converged is true when every device has its target weight and no
+	// device is left to drain.
+	Converged bool `json:"converged"`
+
+	// LastRebalanceTime is when the ring was last rebalanced.
+	// +optional
+	LastRebalanceTime *metav1.Time `json:"lastRebalanceTime,omitempty"`
+
+	// NextRebalanceTime is when the next step towards the target weights
+	// is due, for rings that have not converged.
+	// +optional
+	NextRebalanceTime *metav1.Time `json:"nextRebalanceTime,omitempty"`
 }
 
+const (
+	// ConditionRingsReady indicates the rings are built and distributed.
+	// Rebalances in progress keep it true.
+	ConditionRingsReady ConditionType = "RingsReady"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Mode",type=string,JSONPath=`.spec.mode`
+// +kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.status.apiEndpoint`
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
@@ -12,6 +12,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/controller"
 )
 
 var (
@@ -50,15 +52,36 @@ func main() {
 		os.Exit(1)
 	}
 
-	// TODO: Register controllers here as they are implemented.
-	// Example:
-	// if err = (&controller.OpenStackControlPlaneReconciler{
-	//     Client: mgr.GetClient(),
-	//     Scheme: mgr.GetScheme(),
-	// }).SetupWithManager(mgr); err != nil {
-	//     setupLog.Error(err, "unable to create controller", "controller", "OpenStackControlPlane")
-	//     os.Exit(1)
-	// }
+	executor, err := common.NewPodExecutor(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create pod executor")
+		os.Exit(1)
+	}
+
+	controllers := []struct {
+		name  string
+		setup func(mgr ctrl.Manager) error
+	}{
+		{"Neutron", (&controller.NeutronReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"OVNNetwork", (&controller.OVNNetworkReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme(), Executor: executor}).SetupWithManager},
+		{"Nova", (&controller.NovaReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme(), Executor: executor}).SetupWithManager},
+		{"OpenStackFlavor", (&controller.OpenStackFlavorReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"OpenStackAggregate", (&controller.OpenStackAggregateReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"OpenStackVolumeType", (&controller.OpenStackVolumeTypeReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"OpenStackQoSSpec", (&controller.OpenStackQoSSpecReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"OpenStackDataPlane", (&controller.OpenStackDataPlaneReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme(), Executor: executor}).SetupWithManager},
+		{"Cinder", (&controller.CinderReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"Heat", (&controller.HeatReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"Swift", (&controller.SwiftReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"Barbican", (&controller.BarbicanReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+		{"Octavia", (&controller.OctaviaReconciler{Client: mgr.GetClient(), Scheme: mgr.GetScheme()}).SetupWithManager},
+	}
+	for _, c := range controllers {
+		if err := c.setup(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", c.name)
+			os.Exit(1)
+		}
+	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSignCertificateRequest(t *testing.T) {
+	caCert, caKey, err := GenerateCA("migration CA", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "node-1"},
+		DNSNames:    []string{"node-1"},
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.11")},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := SignCertificateRequest(caCert, caKey, req, 365*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ParseCertificate(caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "node-1", KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+			t.Errorf("verify for %v: %v", usage, err)
+		}
+	}
+	if err := cert.VerifyHostname("192.0.2.11"); err != nil {
+		t.Error(err)
+	}
+	if cert.Subject.CommonName != "node-1" {
+		t.Errorf("common name = %q", cert.Subject.CommonName)
+	}
+	if cert.NotAfter.After(ca.NotAfter) {
+		t.Errorf("certificate expires %v, after the CA (%v)", cert.NotAfter, ca.NotAfter)
+	}
+	if !cert.PublicKey.(*ecdsa.PublicKey).Equal(&key.PublicKey) {
+		t.Error("certificate is not for the requested key")
+	}
+}
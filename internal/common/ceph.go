@@ -0,0 +1,400 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+)
+
+// CephClientGVK is the Rook CephClient kind. It is handled as unstructured so
+// the operator does not depend on Rook's Go module.
+var CephClientGVK = schema.GroupVersionKind{Group: "ceph.rook.io", Version: "v1", Kind: "CephClient"}
+
+// CephBlockPoolGVK is the Rook CephBlockPool kind.
+var CephBlockPoolGVK = schema.GroupVersionKind{Group: "ceph.rook.io", Version: "v1", Kind: "CephBlockPool"}
+
+// CephObjectStoreGVK is the Rook CephObjectStore kind.
+var CephObjectStoreGVK = schema.GroupVersionKind{Group: "ceph.rook.io", Version: "v1", Kind: "CephObjectStore"}
+
+// ErrCephPoolMissing is returned when CephStorage defines no pool for a purpose.
+var ErrCephPoolMissing = errors.New("no Ceph pool for purpose")
+
+// CephClient is a Ceph user and the cluster settings to connect as it.
+type CephClient struct {
+	// User is the client name without the "client." prefix.
+	User string
+	// Key is the base64 cephx key.
+	Key string
+	// Config is a ceph.conf for the cluster.
+	Config string
+}
+
+// Keyring returns the keyring file content for the client.
+func (c *CephClient) Keyring() string {
+	return fmt.Sprintf("[client.%s]\n\tkey = %s\n", c.User, c.Key)
+}
+
+// GetCephStorage returns the CephStorage in the namespace, or
+// ErrDependencyNotReady if there is none.
+func GetCephStorage(ctx context.Context, c client.Client, namespace string) (*openstackv1alpha1.CephStorage, error) {
+	list := &openstackv1alpha1.CephStorageList{}
+	if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		return nil, fmt.Errorf("CephStorage: %w", ErrDependencyNotReady)
+	}
+	return &list.Items[0], nil
+}
+
+// CephPoolName returns the name of the pool with the given purpose, or
+// ErrCephPoolMissing.
+func CephPoolName(ceph *openstackv1alpha1.CephStorage, purpose string) (string, error) {
+	for _, pool := range ceph.Spec.Pools {
+		if pool.Purpose == purpose {
+			return pool.Name, nil
+		}
+	}
+	return "", fmt.Errorf("%w %s in CephStorage %s", ErrCephPoolMissing, purpose, ceph.Name)
+}
+
+// EnsureCephPool returns the name of the pool with the given purpose. With
+// Rook it also creates the CephBlockPool, named after the purpose when
+// CephStorage lists no such pool, and returns ErrDependencyNotReady until
+// Rook reports it Ready. An existing CephBlockPool is used as is: its
+// failure domain and size are only set on creation. External clusters must
+// list the pool, which has to exist already.
+func EnsureCephPool(ctx context.Context, c client.Client, ceph *openstackv1alpha1.CephStorage, purpose string) (string, error) {
+	name, err := CephPoolName(ceph, purpose)
+	if ceph.Spec.Mode == "external" {
+		return name, err
+	}
+	replicas := int64(3)
+	if err != nil {
+		name = purpose
+	}
+	for _, pool := range ceph.Spec.Pools {
+		if pool.Name == name && pool.ReplicaCount > 0 {
+			replicas = int64(pool.ReplicaCount)
+		}
+	}
+
+	pool := &unstructured.Unstructured{}
+	pool.SetGroupVersionKind(CephBlockPoolGVK)
+	pool.SetName(name)
+	pool.SetNamespace(rookNamespace(ceph))
+	if err := c.Get(ctx, client.ObjectKeyFromObject(pool), pool); apierrors.IsNotFound(err) {
+		if err := unstructured.SetNestedField(pool.Object, "host", "spec", "failureDomain"); err != nil {
+			return "", err
+		}
+		if err := unstructured.SetNestedField(pool.Object, replicas, "spec", "replicated", "size"); err != nil {
+			return "", err
+		}
+		if err := c.Create(ctx, pool); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+	if phase, _, _ := unstructured.NestedString(pool.Object, "status", "phase"); phase != "Ready" {
+		return "", fmt.Errorf("Ceph pool %s: %w", name, ErrDependencyNotReady)
+	}
+	return name, nil
+}
+
+// CephObjectStoreParams holds the parameters of an object store serving the
+// Swift API with Keystone authentication.
+type CephObjectStoreParams struct {
+	Name     string
+	Replicas int64 // size of the data and metadata pools
+	Gateways int64 // RGW instances
+	Port     int64
+
+	KeystoneURL      string // without the API version
+	KeystoneUser     string // service user validating tokens, in the "service" project
+	KeystonePassword string
+	AcceptedRoles    []string
+
+	// Labels mark the store and its Keystone Secret with their owner.
+	Labels map[string]string
+}
+
+// EnsureCephObjectStore creates a Rook CephObjectStore serving the Swift API
+// under /swift, authenticated by Keystone, and returns the Swift API root.
+// Rook turns the Keystone settings into the rgw_keystone_* options of the
+// gateways; with implicit tenants each project gets its own namespace of
+// containers. It returns ErrDependencyNotReady until Rook reports the store
+// Ready. Only Rook clusters are supported.
+func EnsureCephObjectStore(ctx context.Context, c client.Client, ceph *openstackv1alpha1.CephStorage, params CephObjectStoreParams) (string, error) {
+	if ceph.Spec.Mode == "external" {
+		return "", fmt.Errorf("CephStorage %s is external: object stores can only be created with Rook", ceph.Name)
+	}
+	namespace := rookNamespace(ceph)
+
+	// Rook reads the service user's credentials from a Secret next to the
+	// store.
+	userSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: params.Name + "-keystone", Namespace: namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, c, userSecret, func() error {
+		userSecret.Labels = params.Labels
+		userSecret.StringData = nil
+		userSecret.Data = map[string][]byte{
+			"OS_AUTH_TYPE":            []byte("password"),
+			"OS_IDENTITY_API_VERSION": []byte("3"),
+			"OS_USERNAME":             []byte(params.KeystoneUser),
+			"OS_PASSWORD":             []byte(params.KeystonePassword),
+			"OS_PROJECT_NAME":         []byte("service"),
+			"OS_PROJECT_DOMAIN_NAME":  []byte("Default"),
+			"OS_USER_DOMAIN_NAME":     []byte("Default"),
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	roles := make([]any, 0, len(params.AcceptedRoles))
+	for _, role := range params.AcceptedRoles {
+		roles = append(roles, role)
+	}
+	pool := func() map[string]any {
+		return map[string]any{
+			"failureDomain": "host",
+			"replicated":    map[string]any{"size": params.Replicas},
+		}
+	}
+	store := &unstructured.Unstructured{}
+	store.SetGroupVersionKind(CephObjectStoreGVK)
+	store.SetName(params.Name)
+	store.SetNamespace(namespace)
+	if _, err := controllerutil.CreateOrUpdate(ctx, c, store, func() error {
+		store.SetLabels(params.Labels)
+		spec := map[string]any{
+			"metadataPool":          pool(),
+			"dataPool":              pool(),
+			"preservePoolsOnDelete": true,
+			"gateway": map[string]any{
+				"port":      params.Port,
+				"instances": params.Gateways,
+			},
+			"auth": map[string]any{
+				"keystone": map[string]any{
+					"url":                   params.KeystoneURL,
+					"acceptedRoles":         roles,
+					"implicitTenants":       "swift",
+					"tokenCacheSize":        int64(1000),
+					"revocationInterval":    int64(1200),
+					"serviceUserSecretName": userSecret.Name,
+				},
+			},
+			"protocols": map[string]any{
+				"swift": map[string]any{
+					"accountInUrl":      true,
+					"urlPrefix":         "swift",
+					"versioningEnabled": true,
+				},
+				"s3": map[string]any{
+					"authUseKeystone": true,
+				},
+			},
+		}
+		return unstructured.SetNestedMap(store.Object, spec, "spec")
+	}); err != nil {
+		return "", err
+	}
+	if phase, _, _ := unstructured.NestedString(store.Object, "status", "phase"); phase != "Ready" {
+		return "", fmt.Errorf("Ceph object store %s: %w", params.Name, ErrDependencyNotReady)
+	}
+	return fmt.Sprintf("http://rook-ceph-rgw-%s.%s.svc:%d/swift/v1", params.Name, namespace, params.Port), nil
+}
+
+// GetCephObjectStore returns the CephObjectStore of the given name, or nil
+// if there is none.
+func GetCephObjectStore(ctx context.Context, c client.Client, ceph *openstackv1alpha1.CephStorage, name string) (*unstructured.Unstructured, error) {
+	store := &unstructured.Unstructured{}
+	store.SetGroupVersionKind(CephObjectStoreGVK)
+	if err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: rookNamespace(ceph)}, store); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return store, nil
+}
+
+// ListCephObjectStores returns the names of the CephObjectStores carrying
+// the given labels.
+func ListCephObjectStores(ctx context.Context, c client.Client, ceph *openstackv1alpha1.CephStorage, labels map[string]string) ([]string, error) {
+	stores := &unstructured.UnstructuredList{}
+	stores.SetGroupVersionKind(CephObjectStoreGVK.GroupVersion().WithKind(CephObjectStoreGVK.Kind + "List"))
+	if err := c.List(ctx, stores, client.InNamespace(rookNamespace(ceph)), client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(stores.Items))
+	for _, store := range stores.Items {
+		names = append(names, store.GetName())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteCephObjectStore deletes a CephObjectStore created by
+// EnsureCephObjectStore and its Keystone Secret. Rook keeps the pools, and
+// with them the stored objects.
+func DeleteCephObjectStore(ctx context.Context, c client.Client, ceph *openstackv1alpha1.CephStorage, name string) error {
+	namespace := rookNamespace(ceph)
+	store := &unstructured.Unstructured{}
+	store.SetGroupVersionKind(CephObjectStoreGVK)
+	store.SetName(name)
+	store.SetNamespace(namespace)
+	if err := c.Delete(ctx, store); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name + "-keystone", Namespace: namespace}}
+	return client.IgnoreNotFound(c.Delete(ctx, secret))
+}
+
+// CephRBDCaps returns cephx caps for RBD access to the given pools, and
+// read-only access to the read-only pools. Empty pool names are skipped.
+func CephRBDCaps(pools, readOnlyPools []string) map[string]string {
+	var osd []string
+	for _, pool := range pools {
+		if pool != "" {
+			osd = append(osd, "profile rbd pool="+pool)
+		}
+	}
+	for _, pool := range readOnlyPools {
+		if pool != "" {
+			osd = append(osd, "profile rbd-read-only pool="+pool)
+		}
+	}
+	return map[string]string{"mon": "profile rbd", "osd": strings.Join(osd, ", ")}
+}
+
+// EnsureCephClient returns a Ceph user with the given caps. With Rook it
+// creates a CephClient and reads the key Rook generates, returning
+// ErrDependencyNotReady until it exists. External clusters cannot be
+// administered, so the user must already be in the keyring Secret.
+func EnsureCephClient(ctx context.Context, c client.Client, ceph *openstackv1alpha1.CephStorage, user string, caps map[string]string) (*CephClient, error) {
+	if ceph.Spec.Mode == "external" {
+		return externalCephClient(ctx, c, ceph, user)
+	}
+
+	namespace := rookNamespace(ceph)
+	cephClient := &unstructured.Unstructured{}
+	cephClient.SetGroupVersionKind(CephClientGVK)
+	cephClient.SetName(user)
+	cephClient.SetNamespace(namespace)
+	if _, err := controllerutil.CreateOrUpdate(ctx, c, cephClient, func() error {
+		capMap := map[string]any{}
+		for k, v := range caps {
+			capMap[k] = v
+		}
+		return unstructured.SetNestedMap(cephClient.Object, capMap, "spec", "caps")
+	}); err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "rook-ceph-client-" + user, Namespace: namespace}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("Ceph client %s: %w", user, ErrDependencyNotReady)
+		}
+		return nil, err
+	}
+	key := strings.TrimSpace(string(secret.Data[user]))
+	if key == "" {
+		return nil, fmt.Errorf("Ceph client %s: %w", user, ErrDependencyNotReady)
+	}
+
+	monitors := &corev1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "rook-ceph-mon-endpoints", Namespace: namespace}, monitors); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("Ceph monitors: %w", ErrDependencyNotReady)
+		}
+		return nil, err
+	}
+	// data is a=10.0.0.1:6789,b=10.0.0.2:6789
+	var hosts []string
+	for _, entry := range strings.Split(monitors.Data["data"], ",") {
+		if _, addr, ok := strings.Cut(entry, "="); ok {
+			hosts = append(hosts, addr)
+		}
+	}
+	sort.Strings(hosts)
+	return &CephClient{User: user, Key: key, Config: cephConfig(strings.Join(hosts, ","))}, nil
+}
+
+// externalCephClient reads a user's key from the keyring Secret and the
+// ceph.conf of an external cluster.
+func externalCephClient(ctx context.Context, c client.Client, ceph *openstackv1alpha1.CephStorage, user string) (*CephClient, error) {
+	if ceph.Spec.External == nil {
+		return nil, fmt.Errorf("CephStorage %s has mode external but no external settings", ceph.Name)
+	}
+	ext := ceph.Spec.External
+	keyring := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: ext.KeyringSecretName, Namespace: ceph.Namespace}, keyring); err != nil {
+		return nil, err
+	}
+	var key string
+	for _, data := range keyring.Data {
+		if key = keyringKey(string(data), "client."+user); key != "" {
+			break
+		}
+	}
+	if key == "" {
+		return nil, fmt.Errorf("keyring Secret %s has no client.%s entry", ext.KeyringSecretName, user)
+	}
+
+	config := cephConfig(ext.MonitorHosts)
+	if ext.CephConfSecretName != "" {
+		conf := &corev1.Secret{}
+		if err := c.Get(ctx, client.ObjectKey{Name: ext.CephConfSecretName, Namespace: ceph.Namespace}, conf); err != nil {
+			return nil, err
+		}
+		if data, ok := conf.Data["ceph.conf"]; ok {
+			config = string(data)
+		}
+	}
+	return &CephClient{User: user, Key: key, Config: config}, nil
+}
+
+// keyringKey returns the key of an entity in a keyring file, or "".
+func keyringKey(keyring, entity string) string {
+	section := ""
+	for _, line := range strings.Split(keyring, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		if name, value, ok := strings.Cut(line, "="); ok && section == entity && strings.TrimSpace(name) == "key" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// rookNamespace returns the namespace of the Rook-managed cluster.
+func rookNamespace(ceph *openstackv1alpha1.CephStorage) string {
+	if ceph.Spec.Rook != nil && ceph.Spec.Rook.Namespace != "" {
+		return ceph.Spec.Rook.Namespace
+	}
+	return "rook-ceph"
+}
+
+func cephConfig(monHosts string) string {
+	return fmt.Sprintf("[global]\nmon_host = %s\n", monHosts)
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+)
+
+// CertificateGVK is the cert-manager Certificate kind. It is handled as
+// unstructured so the operator does not depend on cert-manager's Go module.
+var CertificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// CertificateParams holds parameters for requesting a certificate from cert-manager.
+type CertificateParams struct {
+	Name       string
+	Namespace  string
+	SecretName string // Secret cert-manager writes tls.crt, tls.key and ca.crt into
+	CommonName string
+	DNSNames   []string
+	IssuerRef  openstackv1alpha1.CertIssuerRef
+	// Usages defaults to server and client auth, which suits mutual TLS
+	// between peers, or to certificate signing for a CA.
+	Usages []string
+	// IsCA requests an intermediate CA that signs certificates itself.
+	IsCA bool
+}
+
+// EnsureCertificate creates or updates a cert-manager Certificate.
+func EnsureCertificate(ctx context.Context, c client.Client, params CertificateParams, owner metav1.Object) error {
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(CertificateGVK)
+	cert.SetName(params.Name)
+	cert.SetNamespace(params.Namespace)
+
+	usages := params.Usages
+	if len(usages) == 0 {
+		usages = []string{"server auth", "client auth"}
+		if params.IsCA {
+			usages = []string{"cert sign", "crl sign", "digital signature"}
+		}
+	}
+	issuerKind := params.IssuerRef.Kind
+	if issuerKind == "" {
+		issuerKind = "Issuer"
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, c, cert, func() error {
+		dnsNames := make([]any, 0, len(params.DNSNames))
+		for _, n := range params.DNSNames {
+			dnsNames = append(dnsNames, n)
+		}
+		usageList := make([]any, 0, len(usages))
+		for _, u := range usages {
+			usageList = append(usageList, u)
+		}
+		spec := map[string]any{
+			"secretName": params.SecretName,
+			"dnsNames":   dnsNames,
+			"usages":     usageList,
+			"issuerRef": map[string]any{
+				"name":  params.IssuerRef.Name,
+				"kind":  issuerKind,
+				"group": "cert-manager.io",
+			},
+		}
+		if params.IsCA {
+			spec["isCA"] = true
+		}
+		if params.CommonName != "" {
+			spec["commonName"] = params.CommonName
+		}
+		if err := unstructured.SetNestedMap(cert.Object, spec, "spec"); err != nil {
+			return err
+		}
+		if owner != nil {
+			return controllerutil.SetOwnerReference(owner, cert, c.Scheme())
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,113 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+)
+
+// ErrDependencyNotReady is returned when an infrastructure dependency is missing or not Ready.
+var ErrDependencyNotReady = errors.New("dependency not ready")
+
+// Connections describes the shared infrastructure a service connects to.
+// Names follow the conventions used by the infrastructure controllers:
+// MariaDB "<name>" / "<name>-root-password", RabbitMQ "<name>" / "<name>-credentials",
+// Keystone "<name>-api" / "<name>-admin-password", Memcached "<name>".
+type Connections struct {
+	Region string
+
+	MariaDBHost   string
+	MariaDBSecret string
+
+	RabbitMQHost   string
+	RabbitMQSecret string
+
+	KeystoneURL    string
+	KeystoneSecret string
+
+	MemcachedServers string
+}
+
+// ResolveConnections discovers the MariaDB, RabbitMQ, Keystone and Memcached
+// instances in the namespace, and the region of its OpenStackControlPlane. It returns ErrDependencyNotReady if any of them
+// is missing or not Ready.
+func ResolveConnections(ctx context.Context, c client.Client, namespace string) (*Connections, error) {
+	conns := &Connections{Region: "RegionOne"}
+
+	controlPlanes := &openstackv1alpha1.OpenStackControlPlaneList{}
+	if err := c.List(ctx, controlPlanes, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(controlPlanes.Items) > 0 && controlPlanes.Items[0].Spec.Region != "" {
+		conns.Region = controlPlanes.Items[0].Spec.Region
+	}
+
+	mariadbs := &openstackv1alpha1.MariaDBList{}
+	if err := c.List(ctx, mariadbs, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(mariadbs.Items) == 0 || !IsReady(mariadbs.Items[0].Status.Conditions) {
+		return nil, fmt.Errorf("MariaDB: %w", ErrDependencyNotReady)
+	}
+	db := mariadbs.Items[0]
+	conns.MariaDBHost = fmt.Sprintf("%s.%s.svc", db.Name, namespace)
+	conns.MariaDBSecret = fmt.Sprintf("%s-root-password", db.Name)
+
+	rabbits := &openstackv1alpha1.RabbitMQList{}
+	if err := c.List(ctx, rabbits, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(rabbits.Items) == 0 || !IsReady(rabbits.Items[0].Status.Conditions) {
+		return nil, fmt.Errorf("RabbitMQ: %w", ErrDependencyNotReady)
+	}
+	mq := rabbits.Items[0]
+	conns.RabbitMQHost = fmt.Sprintf("%s.%s.svc", mq.Name, namespace)
+	conns.RabbitMQSecret = fmt.Sprintf("%s-credentials", mq.Name)
+
+	keystones := &openstackv1alpha1.KeystoneList{}
+	if err := c.List(ctx, keystones, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(keystones.Items) == 0 || !IsReady(keystones.Items[0].Status.Conditions) {
+		return nil, fmt.Errorf("Keystone: %w", ErrDependencyNotReady)
+	}
+	ks := keystones.Items[0]
+	conns.KeystoneURL = ks.Status.APIEndpoint
+	if conns.KeystoneURL == "" {
+		conns.KeystoneURL = fmt.Sprintf("http://%s-api.%s.svc:5000/v3", ks.Name, namespace)
+	}
+	conns.KeystoneSecret = ks.Spec.AdminPasswordSecretName
+	if conns.KeystoneSecret == "" {
+		conns.KeystoneSecret = fmt.Sprintf("%s-admin-password", ks.Name)
+	}
+
+	memcacheds := &openstackv1alpha1.MemcachedList{}
+	if err := c.List(ctx, memcacheds, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(memcacheds.Items) > 0 {
+		conns.MemcachedServers = fmt.Sprintf("%s.%s.svc:11211", memcacheds.Items[0].Name, namespace)
+	}
+
+	return conns, nil
+}
+
+// TransportURL builds an oslo.messaging transport URL for RabbitMQ.
+func TransportURL(username, password, host string) string {
+	return VHostTransportURL(username, password, host, "")
+}
+
+// VHostTransportURL builds an oslo.messaging transport URL for a virtual
+// host of RabbitMQ; an empty vhost is the default vhost "/".
+func VHostTransportURL(username, password, host, vhost string) string {
+	return fmt.Sprintf("rabbit://%s:%s@%s:5672/%s", username, password, host, vhost)
+}
+
+// DatabaseURL builds a SQLAlchemy connection URL for MariaDB.
+func DatabaseURL(username, password, host, database string) string {
+	return fmt.Sprintf("mysql+pymysql://%s:%s@%s/%s?charset=utf8", username, password, host, database)
+}
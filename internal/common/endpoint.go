@@ -0,0 +1,197 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// EndpointParams holds parameters for creating a Keystone service + endpoints.
+type EndpointParams struct {
+	Name           string
+	Namespace      string
+	ServiceName    string // e.g., "glance"
+	ServiceType    string // e.g., "image"
+	InternalURL    string
+	PublicURL      string
+	AdminURL       string
+	Region         string
+	KeystoneSecret string // Secret containing admin password (key: "password")
+	KeystoneURL    string // e.g., "http://keystone-api.openstack.svc:5000/v3"
+	BootstrapImage string // Keystone image for running openstack CLI
+
+	// ServiceUser, when set, is created in the "service" project with the admin role.
+	ServiceUser string
+	// ServiceUserSecret contains the service user's password (key: "password").
+	ServiceUserSecret string
+}
+
+// EnsureKeystoneEndpoint creates a Job that registers the service and its endpoints in Keystone.
+func EnsureKeystoneEndpoint(ctx context.Context, c client.Client, params EndpointParams, owner metav1.Object) error {
+	jobName := fmt.Sprintf("%s-endpoint-create", params.Name)
+
+	existing := &batchv1.Job{}
+	err := c.Get(ctx, types.NamespacedName{Name: jobName, Namespace: params.Namespace}, existing)
+	if err == nil {
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	// Script uses the openstack CLI to create/update service and endpoints
+	steps := []string{
+		fmt.Sprintf(`openstack service create --name %s --description "%s service" %s || true`, params.ServiceName, params.ServiceName, params.ServiceType),
+		fmt.Sprintf(`openstack endpoint create --region %s %s internal %s || true`, params.Region, params.ServiceType, params.InternalURL),
+		fmt.Sprintf(`openstack endpoint create --region %s %s public %s || true`, params.Region, params.ServiceType, params.PublicURL),
+		fmt.Sprintf(`openstack endpoint create --region %s %s admin %s || true`, params.Region, params.ServiceType, params.AdminURL),
+	}
+	env := keystoneAdminEnv(params.KeystoneURL, params.KeystoneSecret)
+	if params.ServiceUser != "" {
+		steps = append(steps,
+			`(openstack project show service || openstack project create --domain default service)`,
+			fmt.Sprintf(`openstack user create --domain default --or-show --password "$SERVICE_PASSWORD" %s`, params.ServiceUser),
+			fmt.Sprintf(`openstack user set --password "$SERVICE_PASSWORD" %s`, params.ServiceUser),
+			fmt.Sprintf(`openstack role add --project service --user %s admin`, params.ServiceUser),
+		)
+		env = append(env, corev1.EnvVar{
+			Name: "SERVICE_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: params.ServiceUserSecret},
+					Key:                  "password",
+				},
+			},
+		})
+	}
+	script := strings.Join(steps, " && ")
+
+	backoffLimit := int32(6)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: params.Namespace,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyOnFailure,
+					Containers: []corev1.Container{
+						{
+							Name:    "endpoint-create",
+							Image:   params.BootstrapImage,
+							Command: []string{"sh", "-c", script},
+							Env:     env,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if owner != nil {
+		_ = controllerutil.SetOwnerReference(owner, job, c.Scheme())
+	}
+	return c.Create(ctx, job)
+}
+
+// KeystoneJobParams holds parameters for running openstack CLI commands as
+// the Keystone admin.
+type KeystoneJobParams struct {
+	Name           string // Job name
+	Namespace      string
+	Labels         map[string]string
+	Commands       []string // run in order, stopping at the first failure
+	Env            []corev1.EnvVar
+	KeystoneSecret string // Secret containing admin password (key: "password")
+	KeystoneURL    string
+	BootstrapImage string
+}
+
+// EnsureKeystoneJob creates a Job that runs openstack CLI commands as the
+// Keystone admin, e.g. to create the domains, users and roles a service
+// needs beyond its service user. Commands should be idempotent, as the Job
+// retries them from the start.
+func EnsureKeystoneJob(ctx context.Context, c client.Client, params KeystoneJobParams, owner metav1.Object) error {
+	existing := &batchv1.Job{}
+	err := c.Get(ctx, types.NamespacedName{Name: params.Name, Namespace: params.Namespace}, existing)
+	if err == nil {
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	backoffLimit := int32(6)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      params.Name,
+			Namespace: params.Namespace,
+			Labels:    params.Labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyOnFailure,
+					Containers: []corev1.Container{
+						{
+							Name:    "keystone",
+							Image:   params.BootstrapImage,
+							Command: []string{"sh", "-c", strings.Join(params.Commands, " && ")},
+							Env:     append(keystoneAdminEnv(params.KeystoneURL, params.KeystoneSecret), params.Env...),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if owner != nil {
+		_ = controllerutil.SetOwnerReference(owner, job, c.Scheme())
+	}
+	return c.Create(ctx, job)
+}
+
+// SecretEnvVar returns an environment variable set from a Secret key.
+func SecretEnvVar(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// keystoneAdminEnv returns the OS_* environment for running the openstack CLI as admin.
+func keystoneAdminEnv(keystoneURL, adminSecret string) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{Name: "OS_AUTH_URL", Value: keystoneURL},
+		{Name: "OS_USERNAME", Value: "admin"},
+		{Name: "OS_PROJECT_NAME", Value: "admin"},
+		{Name: "OS_USER_DOMAIN_NAME", Value: "Default"},
+		{Name: "OS_PROJECT_DOMAIN_NAME", Value: "Default"},
+		{Name: "OS_IDENTITY_API_VERSION", Value: "3"},
+		{
+			Name: "OS_PASSWORD",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: adminSecret},
+					Key:                  "password",
+				},
+			},
+		},
+	}
+}
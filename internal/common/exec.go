@@ -0,0 +1,56 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// PodExecutor runs commands inside pod containers. It is used for operations
+// that are only reachable through a local control socket, such as ovs-appctl.
+type PodExecutor struct {
+	config *rest.Config
+	client rest.Interface
+}
+
+// NewPodExecutor returns a PodExecutor using the given REST config.
+func NewPodExecutor(config *rest.Config) (*PodExecutor, error) {
+	c, err := corev1client.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &PodExecutor{config: config, client: c.RESTClient()}, nil
+}
+
+// Exec runs command in the container and returns its stdout. A non-zero exit
+// status is returned as an error that includes stderr.
+func (e *PodExecutor) Exec(ctx context.Context, namespace, pod, container string, command []string) (string, error) {
+	req := e.client.Post().
+		Namespace(namespace).
+		Resource("pods").
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr}); err != nil {
+		return stdout.String(), fmt.Errorf("exec %q in %s/%s: %w: %s", strings.Join(command, " "), namespace, pod, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
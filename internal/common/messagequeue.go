@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// VHostParams holds parameters for creating a RabbitMQ virtual host.
+type VHostParams struct {
+	Name           string // resource name prefix
+	Namespace      string
+	VHost          string // virtual host name
+	SecretName     string // Secret of the service user granted the vhost (keys: "username", "password")
+	RabbitMQSecret string // Secret of the RabbitMQ administrator (keys: "username", "password")
+	RabbitMQHost   string // RabbitMQ hostname (e.g., "rabbitmq.openstack.svc")
+}
+
+// EnsureVHost creates a Job that provisions a virtual host through the
+// RabbitMQ management API and grants the service user full permissions on it.
+// The Job is idempotent. Skips creation if the Job already exists.
+func EnsureVHost(ctx context.Context, c client.Client, params VHostParams, owner metav1.Object) error {
+	jobName := fmt.Sprintf("%s-mq-vhost", params.Name)
+
+	existing := &batchv1.Job{}
+	err := c.Get(ctx, types.NamespacedName{Name: jobName, Namespace: params.Namespace}, existing)
+	if err == nil {
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	api := fmt.Sprintf("http://%s:15672/api", params.RabbitMQHost)
+	script := fmt.Sprintf(
+		`curl -fsS -u "$ADMIN_USER:$ADMIN_PASSWORD" -X PUT %s/vhosts/%s && curl -fsS -u "$ADMIN_USER:$ADMIN_PASSWORD" -X PUT -H "content-type: application/json" -d '{"configure":".*","write":".*","read":".*"}' "%s/permissions/%s/$SERVICE_USER"`,
+		api, params.VHost, api, params.VHost,
+	)
+	secretEnv := func(name, secret, key string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+					Key:                  key,
+				},
+			},
+		}
+	}
+
+	backoffLimit := int32(4)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: params.Namespace,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyOnFailure,
+					Containers: []corev1.Container{
+						{
+							Name:    "mq-vhost",
+							Image:   "curlimages/curl:8.10.1",
+							Command: []string{"sh", "-c", script},
+							Env: []corev1.EnvVar{
+								secretEnv("ADMIN_USER", params.RabbitMQSecret, "username"),
+								secretEnv("ADMIN_PASSWORD", params.RabbitMQSecret, "password"),
+								secretEnv("SERVICE_USER", params.SecretName, "username"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if owner != nil {
+		_ = controllerutil.SetOwnerReference(owner, job, c.Scheme())
+	}
+	return c.Create(ctx, job)
+}
@@ -0,0 +1,205 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// openstackRequestTimeout bounds each API request.
+const openstackRequestTimeout = 30 * time.Second
+
+// OwnerMarkKey is the metadata, extra spec or QoS key marking an OpenStack
+// resource as managed by a custom resource; its value is OwnerMark of that
+// resource. Its scope keeps the scheduler and drivers from interpreting it.
+const OwnerMarkKey = "openstack.k8s.io:owner"
+
+// OwnerMark returns the owner mark value of obj, its namespace/name.
+func OwnerMark(obj client.Object) string {
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// OpenStackClient is a minimal OpenStack REST client authenticated as the
+// Keystone admin. It covers the few API calls the operator makes itself, such
+// as checking for instances before removing a compute host.
+type OpenStackClient struct {
+	http          *http.Client
+	token         string
+	region        string
+	identityURL   string
+	catalog       []catalogService
+	microversions map[string]string
+}
+
+type catalogService struct {
+	Type      string `json:"type"`
+	Endpoints []struct {
+		Interface string `json:"interface"`
+		Region    string `json:"region"`
+		URL       string `json:"url"`
+	} `json:"endpoints"`
+}
+
+// OpenStackError is returned for API responses with an error status.
+type OpenStackError struct {
+	StatusCode int
+	Method     string
+	URL        string
+	Body       string
+}
+
+func (e *OpenStackError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Body)
+}
+
+// IsOpenStackNotFound reports whether err is a 404 from an OpenStack API.
+func IsOpenStackNotFound(err error) bool {
+	apiErr, ok := err.(*OpenStackError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsOpenStackBadRequest reports whether err is a 400 from an OpenStack API.
+// Cinder answers with 400 when a volume type in use may not be changed.
+func IsOpenStackBadRequest(err error) bool {
+	apiErr, ok := err.(*OpenStackError)
+	return ok && apiErr.StatusCode == http.StatusBadRequest
+}
+
+// IsOpenStackConflict reports whether err is a 409 from an OpenStack API.
+func IsOpenStackConflict(err error) bool {
+	apiErr, ok := err.(*OpenStackError)
+	return ok && apiErr.StatusCode == http.StatusConflict
+}
+
+// NewOpenStackClient authenticates as the Keystone admin of conns and loads
+// the service catalog.
+func NewOpenStackClient(ctx context.Context, c client.Client, namespace string, conns *Connections) (*OpenStackClient, error) {
+	password, err := GetSecretValue(ctx, c, conns.KeystoneSecret, namespace, "password")
+	if err != nil {
+		return nil, err
+	}
+	osc := &OpenStackClient{
+		http:          &http.Client{Timeout: openstackRequestTimeout},
+		region:        conns.Region,
+		identityURL:   strings.TrimSuffix(conns.KeystoneURL, "/"),
+		microversions: map[string]string{},
+	}
+
+	auth := map[string]any{"auth": map[string]any{
+		"identity": map[string]any{
+			"methods": []string{"password"},
+			"password": map[string]any{"user": map[string]any{
+				"name": "admin", "domain": map[string]string{"name": "Default"}, "password": password,
+			}},
+		},
+		"scope": map[string]any{"project": map[string]any{
+			"name": "admin", "domain": map[string]string{"name": "Default"},
+		}},
+	}}
+	var token struct {
+		Token struct {
+			Catalog []catalogService `json:"catalog"`
+		} `json:"token"`
+	}
+	resp, err := osc.do(ctx, http.MethodPost, osc.identityURL+"/auth/tokens", "", auth, &token)
+	if err != nil {
+		return nil, fmt.Errorf("keystone authentication: %w", err)
+	}
+	osc.token = resp.Header.Get("X-Subject-Token")
+	osc.catalog = token.Token.Catalog
+	return osc, nil
+}
+
+// WithMicroversion returns a copy of the client that requests the given API
+// microversion from a service type, e.g. ("compute", "2.53").
+func (c *OpenStackClient) WithMicroversion(serviceType, version string) *OpenStackClient {
+	out := *c
+	out.microversions = map[string]string{}
+	for k, v := range c.microversions {
+		out.microversions[k] = v
+	}
+	out.microversions[serviceType] = version
+	return &out
+}
+
+// Endpoint returns the internal endpoint of a service type in the client's
+// region. The identity endpoint is the versioned Keystone URL authenticated
+// against, as catalog entries for Keystone usually carry no version.
+func (c *OpenStackClient) Endpoint(serviceType string) (string, error) {
+	if serviceType == "identity" {
+		return c.identityURL, nil
+	}
+	for _, svc := range c.catalog {
+		if svc.Type != serviceType {
+			continue
+		}
+		for _, ep := range svc.Endpoints {
+			if ep.Interface == "internal" && (ep.Region == "" || ep.Region == c.region) {
+				return strings.TrimSuffix(ep.URL, "/"), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no internal %s endpoint in region %s", serviceType, c.region)
+}
+
+// Do calls path on the internal endpoint of serviceType. body and out are
+// JSON-encoded and decoded when non-nil.
+func (c *OpenStackClient) Do(ctx context.Context, method, serviceType, path string, body, out any) error {
+	endpoint, err := c.Endpoint(serviceType)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, method, endpoint+path, serviceType, body, out)
+	return err
+}
+
+func (c *OpenStackClient) do(ctx context.Context, method, url, serviceType string, body, out any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Auth-Token", c.token)
+	}
+	if version := c.microversions[serviceType]; version != "" {
+		req.Header.Set("OpenStack-API-Version", serviceType+" "+version)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		return resp, &OpenStackError{StatusCode: resp.StatusCode, Method: method, URL: url, Body: strings.TrimSpace(string(data))}
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp, fmt.Errorf("%s %s: decoding response: %w", method, url, err)
+		}
+	}
+	return resp, nil
+}
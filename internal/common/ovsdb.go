@@ -0,0 +1,240 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ovsdbDialTimeout bounds connection and requests when ctx has no deadline.
+const ovsdbDialTimeout = 10 * time.Second
+
+// OVSDBClient is a minimal OVSDB (RFC 7047) JSON-RPC client, sufficient for
+// probing schema versions and reading tables of databases the operator does
+// not run itself.
+type OVSDBClient struct {
+	conn   net.Conn
+	enc    *json.Encoder
+	dec    *json.Decoder
+	nextID int
+}
+
+// DialOVSDB connects to the first reachable remote of an OVSDB connection
+// string such as "tcp:a:6641,tcp:b:6641". tlsConfig is required for ssl: remotes.
+func DialOVSDB(ctx context.Context, remotes string, tlsConfig *tls.Config) (*OVSDBClient, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ovsdbDialTimeout)
+		defer cancel()
+	}
+
+	var errs []string
+	for _, remote := range strings.Split(remotes, ",") {
+		proto, addr, ok := strings.Cut(strings.TrimSpace(remote), ":")
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s: invalid remote", remote))
+			continue
+		}
+		var conn net.Conn
+		var err error
+		switch proto {
+		case "tcp":
+			conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		case "ssl":
+			if tlsConfig == nil {
+				err = fmt.Errorf("no client certificate configured")
+				break
+			}
+			conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+		default:
+			err = fmt.Errorf("unsupported protocol %q", proto)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", remote, err))
+			continue
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		} else {
+			_ = conn.SetDeadline(time.Now().Add(ovsdbDialTimeout))
+		}
+		return &OVSDBClient{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}, nil
+	}
+	return nil, fmt.Errorf("no OVSDB remote reachable: %s", strings.Join(errs, "; "))
+}
+
+// Close closes the connection.
+func (c *OVSDBClient) Close() error {
+	return c.conn.Close()
+}
+
+// call sends a request and waits for its response, answering server echo
+// requests in between.
+func (c *OVSDBClient) call(method string, params []any, result any) error {
+	id := c.nextID
+	c.nextID++
+	if err := c.enc.Encode(map[string]any{"method": method, "params": params, "id": id}); err != nil {
+		return err
+	}
+	for {
+		var msg struct {
+			ID     any             `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  any             `json:"error"`
+		}
+		if err := c.dec.Decode(&msg); err != nil {
+			return err
+		}
+		if msg.Method == "echo" {
+			if err := c.enc.Encode(map[string]any{"id": msg.ID, "result": msg.Params, "error": nil}); err != nil {
+				return err
+			}
+			continue
+		}
+		if n, ok := msg.ID.(float64); !ok || int(n) != id {
+			continue
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("%s: %v", method, msg.Error)
+		}
+		return json.Unmarshal(msg.Result, result)
+	}
+}
+
+// SchemaVersion returns the schema version of the database.
+func (c *OVSDBClient) SchemaVersion(db string) (string, error) {
+	var schema struct {
+		Version string `json:"version"`
+	}
+	if err := c.call("get_schema", []any{db}, &schema); err != nil {
+		return "", err
+	}
+	return schema.Version, nil
+}
+
+// Select returns the given columns of all rows in a table.
+func (c *OVSDBClient) Select(db, table string, columns []string) ([]map[string]any, error) {
+	var results []struct {
+		Rows  []map[string]any `json:"rows"`
+		Error string           `json:"error"`
+	}
+	op := map[string]any{"op": "select", "table": table, "where": []any{}, "columns": columns}
+	if err := c.call("transact", []any{db, op}, &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("select %s: empty result", table)
+	}
+	if results[0].Error != "" {
+		return nil, fmt.Errorf("select %s: %s", table, results[0].Error)
+	}
+	return results[0].Rows, nil
+}
+
+// OVSDBMap converts an OVSDB map value (["map", [[k, v], ...]]) to a Go map.
+func OVSDBMap(value any) map[string]string {
+	out := map[string]string{}
+	pair, ok := value.([]any)
+	if !ok || len(pair) != 2 || pair[0] != "map" {
+		return out
+	}
+	entries, _ := pair[1].([]any)
+	for _, e := range entries {
+		kv, ok := e.([]any)
+		if !ok || len(kv) != 2 {
+			continue
+		}
+		k, _ := kv[0].(string)
+		v, _ := kv[1].(string)
+		out[k] = v
+	}
+	return out
+}
+
+// CompareOVSDBVersions compares two "x.y.z" schema versions, returning -1, 0 or 1.
+func CompareOVSDBVersions(a, b string) (int, error) {
+	pa, err := parseOVSDBVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	pb, err := parseOVSDBVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range pa {
+		switch {
+		case pa[i] < pb[i]:
+			return -1, nil
+		case pa[i] > pb[i]:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// OVSDBMajorVersion returns the major component of an "x.y.z" schema version.
+func OVSDBMajorVersion(v string) (int, error) {
+	p, err := parseOVSDBVersion(v)
+	return p[0], err
+}
+
+func parseOVSDBVersion(v string) ([3]int, error) {
+	var out [3]int
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return out, fmt.Errorf("invalid schema version %q", v)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return out, fmt.Errorf("invalid schema version %q", v)
+		}
+		out[i] = n
+	}
+	return out, nil
+}
+
+// OVSDBTLSConfig builds a client TLS config from a Secret with tls.crt,
+// tls.key and ca.crt. Like ovsdb-client, it verifies the server certificate
+// against the CA but not its host name: OVN PKIs rarely issue certificates
+// for the service DNS names clients connect to.
+func OVSDBTLSConfig(ctx context.Context, c client.Client, name, namespace string) (*tls.Config, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, secret); err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(secret.Data["tls.crt"], secret.Data["tls.key"])
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %w", name, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(secret.Data["ca.crt"]) {
+		return nil, fmt.Errorf("secret %s: no CA certificate in ca.crt", name)
+	}
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true, // replaced by VerifyConnection below
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("server presented no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, ic := range state.PeerCertificates[1:] {
+				intermediates.AddCert(ic)
+			}
+			_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+			return err
+		},
+	}, nil
+}
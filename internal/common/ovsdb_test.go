@@ -0,0 +1,191 @@
+package common
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestCompareOVSDBVersions(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		want    int
+		wantErr bool
+	}{
+		{a: "7.3.0", b: "7.3.0", want: 0},
+		{a: "7.3.0", b: "7.3.1", want: -1},
+		{a: "7.10.0", b: "7.9.5", want: 1},
+		{a: "20.37.0", b: "7.3.0", want: 1},
+		{a: "6.99.99", b: "7.0.0", want: -1},
+		{a: "7.3", b: "7.3.0", wantErr: true},
+		{a: "7.3.0", b: "7.x.0", wantErr: true},
+		{a: "", b: "7.3.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := CompareOVSDBVersions(tt.a, tt.b)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("CompareOVSDBVersions(%q, %q): expected an error", tt.a, tt.b)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CompareOVSDBVersions(%q, %q) = %d, %v, want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+}
+
+func TestOVSDBMap(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  map[string]string
+	}{
+		{"map", `["map", [["k1", "v1"], ["k2", "v2"]]]`, map[string]string{"k1": "v1", "k2": "v2"}},
+		{"empty map", `["map", []]`, map[string]string{}},
+		{"set", `["set", ["a"]]`, map[string]string{}},
+		{"atom", `"x"`, map[string]string{}},
+	}
+	for _, tt := range tests {
+		var value any
+		if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+			t.Fatal(err)
+		}
+		if got := OVSDBMap(value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fakeOVSDB serves one request on conn: it sends the messages in before,
+// each followed by reading the client's reply if it is an echo request,
+// then the response to the request.
+func fakeOVSDB(t *testing.T, conn net.Conn, before []map[string]any, response func(id any) map[string]any) <-chan map[string]any {
+	requests := make(chan map[string]any, 1)
+	go func() {
+		defer conn.Close()
+		dec := json.NewDecoder(conn)
+		enc := json.NewEncoder(conn)
+		var req map[string]any
+		if err := dec.Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+			return
+		}
+		requests <- req
+		for _, msg := range before {
+			if err := enc.Encode(msg); err != nil {
+				t.Errorf("encode: %v", err)
+				return
+			}
+			if msg["method"] == "echo" {
+				var reply map[string]any
+				if err := dec.Decode(&reply); err != nil {
+					t.Errorf("decode echo reply: %v", err)
+					return
+				}
+				if !reflect.DeepEqual(reply["id"], msg["id"]) || !reflect.DeepEqual(reply["result"], msg["params"]) {
+					t.Errorf("echo reply = %v, want id %v and result %v", reply, msg["id"], msg["params"])
+				}
+			}
+		}
+		if err := enc.Encode(response(req["id"])); err != nil {
+			t.Errorf("encode response: %v", err)
+		}
+	}()
+	return requests
+}
+
+func newPipeOVSDBClient() (*OVSDBClient, net.Conn) {
+	client, server := net.Pipe()
+	return &OVSDBClient{conn: client, enc: json.NewEncoder(client), dec: json.NewDecoder(client)}, server
+}
+
+func TestOVSDBClientSchemaVersion(t *testing.T) {
+	c, server := newPipeOVSDBClient()
+	defer c.Close()
+	before := []map[string]any{
+		{"method": "echo", "params": []any{"ping"}, "id": "echo"},
+		// A response to another request is skipped.
+		{"id": 99.0, "result": map[string]any{"version": "0.0.0"}, "error": nil},
+		{"method": "update", "params": []any{nil, map[string]any{}}, "id": nil},
+	}
+	requests := fakeOVSDB(t, server, before, func(id any) map[string]any {
+		return map[string]any{"id": id, "result": map[string]any{"name": "OVN_Northbound", "version": "7.3.0"}, "error": nil}
+	})
+
+	version, err := c.SchemaVersion("OVN_Northbound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "7.3.0" {
+		t.Errorf("version = %q, want 7.3.0", version)
+	}
+	req := <-requests
+	if req["method"] != "get_schema" || !reflect.DeepEqual(req["params"], []any{"OVN_Northbound"}) {
+		t.Errorf("request = %v", req)
+	}
+}
+
+func TestOVSDBClientSelect(t *testing.T) {
+	tests := []struct {
+		name     string
+		response func(id any) map[string]any
+		want     []map[string]any
+		wantErr  string
+	}{
+		{
+			name: "rows",
+			response: func(id any) map[string]any {
+				return map[string]any{"id": id, "error": nil, "result": []any{
+					map[string]any{"rows": []any{map[string]any{"name": "node-1"}, map[string]any{"name": "node-2"}}},
+				}}
+			},
+			want: []map[string]any{{"name": "node-1"}, {"name": "node-2"}},
+		},
+		{
+			name: "operation error",
+			response: func(id any) map[string]any {
+				return map[string]any{"id": id, "error": nil, "result": []any{map[string]any{"error": "unknown table"}}}
+			},
+			wantErr: "select Chassis: unknown table",
+		},
+		{
+			name: "empty result",
+			response: func(id any) map[string]any {
+				return map[string]any{"id": id, "error": nil, "result": []any{}}
+			},
+			wantErr: "select Chassis: empty result",
+		},
+		{
+			name: "request error",
+			response: func(id any) map[string]any {
+				return map[string]any{"id": id, "error": "unknown database", "result": nil}
+			},
+			wantErr: "transact: unknown database",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newPipeOVSDBClient()
+			defer c.Close()
+			requests := fakeOVSDB(t, server, nil, tt.response)
+
+			rows, err := c.Select("OVN_Southbound", "Chassis", []string{"name"})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("rows = %v, want %v", rows, tt.want)
+			}
+			req := <-requests
+			want := []any{"OVN_Southbound", map[string]any{"op": "select", "table": "Chassis", "where": []any{}, "columns": []any{"name"}}}
+			if req["method"] != "transact" || !reflect.DeepEqual(req["params"], want) {
+				t.Errorf("request = %v", req)
+			}
+		})
+	}
+}
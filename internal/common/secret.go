@@ -0,0 +1,68 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// GeneratePassword returns a random hex string of the given length.
+func GeneratePassword(length int) string {
+	b := make([]byte, (length+1)/2)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)[:length]
+}
+
+// EnsureSecret creates a Secret with generated random values for each key if it doesn't exist.
+// The keys map specifies key name -> desired password length.
+// If owner is non-nil, an owner reference is set.
+func EnsureSecret(ctx context.Context, c client.Client, name, namespace string, keys map[string]int, owner metav1.Object) error {
+	existing := &corev1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, existing)
+	if err == nil {
+		return nil // already exists
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	data := make(map[string][]byte, len(keys))
+	for k, length := range keys {
+		data[k] = []byte(GeneratePassword(length))
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: data,
+	}
+
+	if owner != nil {
+		_ = controllerutil.SetOwnerReference(owner, secret, c.Scheme())
+	}
+
+	return c.Create(ctx, secret)
+}
+
+// GetSecretValue reads a single key from a Secret.
+func GetSecretValue(ctx context.Context, c client.Client, name, namespace, key string) (string, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, secret); err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %q", namespace, name, key)
+	}
+	return string(value), nil
+}
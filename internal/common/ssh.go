@@ -0,0 +1,39 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+)
+
+// GenerateSSHKeyPair returns a new ECDSA P-256 key as a PEM private key,
+// which OpenSSH reads, and its authorized_keys line.
+func GenerateSSHKeyPair(comment string) (privateKey, authorizedKey string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	privateKey = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+
+	// RFC 5656 public key: key type, curve name and the uncompressed point
+	point, err := key.PublicKey.ECDH()
+	if err != nil {
+		return "", "", err
+	}
+	var blob []byte
+	for _, field := range [][]byte{[]byte("ecdsa-sha2-nistp256"), []byte("nistp256"), point.Bytes()} {
+		blob = binary.BigEndian.AppendUint32(blob, uint32(len(field)))
+		blob = append(blob, field...)
+	}
+	authorizedKey = fmt.Sprintf("ecdsa-sha2-nistp256 %s %s\n", base64.StdEncoding.EncodeToString(blob), comment)
+	return privateKey, authorizedKey, nil
+}
@@ -0,0 +1,377 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// SwiftRingDevice is a device of a Swift ring, serialized as in the ring
+// files read by the Swift daemons.
+type SwiftRingDevice struct {
+	ID              int     `json:"id"`
+	Region          int     `json:"region"`
+	Zone            int     `json:"zone"`
+	IP              string  `json:"ip"`
+	Port            int     `json:"port"`
+	ReplicationIP   string  `json:"replication_ip"`
+	ReplicationPort int     `json:"replication_port"`
+	Device          string  `json:"device"`
+	Weight          float64 `json:"weight"`
+	Meta            string  `json:"meta"`
+}
+
+// SwiftRingBuilder is the state from which a Swift ring is built, kept
+// between rebalances like the .builder files of swift-ring-builder.
+//
+// Like swift-ring-builder, a rebalance moves at most one replica of a
+// partition, and only partitions that have not moved for MinPartHours;
+// replicas on removed devices always move. Weights should be changed in
+// steps between rebalances so that replication can keep up.
+type SwiftRingBuilder struct {
+	PartPower    int `json:"partPower"`
+	Replicas     int `json:"replicas"`
+	MinPartHours int `json:"minPartHours"`
+
+	// Devices is indexed by device ID; removed devices leave a nil entry,
+	// as IDs are never reused.
+	Devices []*SwiftRingDevice `json:"devices"`
+
+	// Assignment maps replica and partition to a device ID; nil until the
+	// first rebalance.
+	Assignment [][]int `json:"assignment,omitempty"`
+
+	// LastPartMoves is the number of hours since each partition last
+	// moved, saturating at 255.
+	LastPartMoves []uint8 `json:"lastPartMoves,omitempty"`
+
+	// LastRebalance is when the ring was last rebalanced.
+	LastRebalance time.Time `json:"lastRebalance"`
+
+	// Version is incremented with every rebalance.
+	Version int `json:"version"`
+}
+
+// ErrSwiftRingNoDevices is returned when rebalancing a ring without any
+// weighted device.
+var ErrSwiftRingNoDevices = errors.New("no devices with weight")
+
+// NewSwiftRingBuilder returns an empty builder.
+func NewSwiftRingBuilder(partPower, replicas, minPartHours int) *SwiftRingBuilder {
+	return &SwiftRingBuilder{PartPower: partPower, Replicas: replicas, MinPartHours: minPartHours}
+}
+
+// LoadSwiftRingBuilder decodes a builder saved with Save.
+func LoadSwiftRingBuilder(data []byte) (*SwiftRingBuilder, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	b := &SwiftRingBuilder{}
+	if err := json.Unmarshal(raw, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Save encodes the builder as gzipped JSON.
+func (b *SwiftRingBuilder) Save() ([]byte, error) {
+	raw, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return gzipBytes(raw)
+}
+
+// Partitions returns the number of partitions.
+func (b *SwiftRingBuilder) Partitions() int {
+	return 1 << b.PartPower
+}
+
+// FindDevice returns the device with the given address and name, or nil.
+func (b *SwiftRingBuilder) FindDevice(ip, device string) *SwiftRingDevice {
+	for _, dev := range b.Devices {
+		if dev != nil && dev.IP == ip && dev.Device == device {
+			return dev
+		}
+	}
+	return nil
+}
+
+// AddDevice adds a device under the next free ID and returns it.
+func (b *SwiftRingBuilder) AddDevice(dev SwiftRingDevice) *SwiftRingDevice {
+	dev.ID = len(b.Devices)
+	b.Devices = append(b.Devices, &dev)
+	return &dev
+}
+
+// RemoveDevice removes a device. Its partitions are reassigned on the next
+// rebalance regardless of MinPartHours, so only devices already drained by
+// weight should be removed.
+func (b *SwiftRingBuilder) RemoveDevice(id int) {
+	if id >= 0 && id < len(b.Devices) {
+		b.Devices[id] = nil
+	}
+}
+
+// PartsOn returns the number of partition replicas assigned to a device.
+func (b *SwiftRingBuilder) PartsOn(id int) int {
+	n := 0
+	for _, part2dev := range b.Assignment {
+		for _, dev := range part2dev {
+			if dev == id {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// NextRebalance returns when MinPartHours will have passed since the last
+// rebalance; the zero time if the ring was never balanced.
+func (b *SwiftRingBuilder) NextRebalance() time.Time {
+	if b.Assignment == nil {
+		return time.Time{}
+	}
+	return b.LastRebalance.Add(time.Duration(b.MinPartHours) * time.Hour)
+}
+
+// wanted returns the number of partition replicas each weighted device
+// should hold.
+func (b *SwiftRingBuilder) wanted() map[int]float64 {
+	total := 0.0
+	for _, dev := range b.Devices {
+		if dev != nil && dev.Weight > 0 {
+			total += dev.Weight
+		}
+	}
+	want := map[int]float64{}
+	if total == 0 {
+		return want
+	}
+	slots := float64(b.Partitions() * b.Replicas)
+	for _, dev := range b.Devices {
+		if dev != nil && dev.Weight > 0 {
+			want[dev.ID] = dev.Weight / total * slots
+		}
+	}
+	return want
+}
+
+// Balance returns the largest deviation, in percent, of a weighted device
+// from the number of partition replicas its weight asks for.
+func (b *SwiftRingBuilder) Balance() float64 {
+	if b.Assignment == nil {
+		return 0
+	}
+	counts := map[int]int{}
+	for _, part2dev := range b.Assignment {
+		for _, dev := range part2dev {
+			counts[dev]++
+		}
+	}
+	balance := 0.0
+	for id, want := range b.wanted() {
+		balance = math.Max(balance, math.Abs(float64(counts[id])-want)/want*100)
+	}
+	return balance
+}
+
+// Rebalance reassigns partitions to follow the device weights and returns
+// the number of partition replicas moved.
+func (b *SwiftRingBuilder) Rebalance(now time.Time) (int, error) {
+	want := b.wanted()
+	if len(want) == 0 {
+		return 0, ErrSwiftRingNoDevices
+	}
+	parts := b.Partitions()
+	if b.Assignment == nil {
+		b.Assignment = [][]int{}
+		b.LastPartMoves = make([]uint8, parts)
+		for p := range b.LastPartMoves {
+			b.LastPartMoves[p] = math.MaxUint8
+		}
+	}
+	// Rows for added replicas start unassigned; rows of removed ones go.
+	for len(b.Assignment) < b.Replicas {
+		row := make([]int, parts)
+		for p := range row {
+			row[p] = -1
+		}
+		b.Assignment = append(b.Assignment, row)
+	}
+	b.Assignment = b.Assignment[:b.Replicas]
+
+	// Age the partitions by the time since the last rebalance. The builder
+	// is only changed if something moves.
+	hours := max(int(now.Sub(b.LastRebalance).Hours()), 0)
+	ages := make([]uint8, parts)
+	for p := range ages {
+		ages[p] = uint8(min(int(b.LastPartMoves[p])+hours, math.MaxUint8))
+	}
+
+	type slot struct{ replica, part, dev int }
+	var gathered []slot
+	moved := make([]bool, parts)
+	counts := map[int]int{}
+	for _, part2dev := range b.Assignment {
+		for _, dev := range part2dev {
+			counts[dev]++
+		}
+	}
+
+	// Replicas without a device, or on a removed one, must move now.
+	for r, part2dev := range b.Assignment {
+		for p, dev := range part2dev {
+			if dev < 0 || dev >= len(b.Devices) || b.Devices[dev] == nil {
+				gathered = append(gathered, slot{r, p, dev})
+				counts[dev]--
+				moved[p] = true
+			}
+		}
+	}
+
+	// Take replicas off devices holding two replicas of a partition, or
+	// holding more than their weight asks for while a device short of its
+	// share could take the replica, one replica per partition and only
+	// from partitions that may move. The scan starts at a different
+	// partition each time to spread the moves.
+	start := (b.Version * 7919) % parts
+	for i := 0; i < parts; i++ {
+		p := (start + i) % parts
+		if moved[p] || int(ages[p]) < b.MinPartHours {
+			continue
+		}
+		for r := range b.Assignment {
+			dev := b.Assignment[r][p]
+			if b.holds(p, dev, r) || float64(counts[dev]) > math.Ceil(want[dev]) && b.placeable(p, want, counts) {
+				gathered = append(gathered, slot{r, p, dev})
+				counts[dev]--
+				moved[p] = true
+				break
+			}
+		}
+	}
+
+	// A replica put back on its device has not moved.
+	moves := 0
+	for _, s := range gathered {
+		dev := b.pickDevice(s.part, s.replica, want, counts)
+		counts[dev]++
+		if dev != s.dev {
+			b.Assignment[s.replica][s.part] = dev
+			ages[s.part] = 0
+			moves++
+		}
+	}
+	if moves == 0 {
+		return 0, nil
+	}
+	b.LastPartMoves = ages
+	b.LastRebalance = now
+	b.Version++
+	return moves, nil
+}
+
+// placeable reports whether a weighted device short of its share holds no
+// replica of part.
+func (b *SwiftRingBuilder) placeable(part int, want map[int]float64, counts map[int]int) bool {
+	for id, w := range want {
+		if float64(counts[id]) < math.Floor(w) && !b.holds(part, id, -1) {
+			return true
+		}
+	}
+	return false
+}
+
+// holds reports whether a replica other than skip of part is on dev; skip
+// -1 checks every replica.
+func (b *SwiftRingBuilder) holds(part, dev, skip int) bool {
+	for r := range b.Assignment {
+		if r != skip && b.Assignment[r][part] == dev {
+			return true
+		}
+	}
+	return false
+}
+
+// pickDevice returns the weighted device most in need of partitions,
+// preferring devices and then zones not yet holding a replica of part.
+func (b *SwiftRingBuilder) pickDevice(part, replica int, want map[int]float64, counts map[int]int) int {
+	zones := map[int]bool{}
+	for r := range b.Assignment {
+		if dev := b.Assignment[r][part]; r != replica && dev >= 0 && dev < len(b.Devices) && b.Devices[dev] != nil {
+			zones[b.Devices[dev].Zone] = true
+		}
+	}
+	best, bestScore := -1, math.Inf(-1)
+	for _, dev := range b.Devices {
+		if dev == nil || dev.Weight <= 0 {
+			continue
+		}
+		score := want[dev.ID] - float64(counts[dev.ID])
+		if b.holds(part, dev.ID, replica) {
+			score -= 2 * float64(b.Partitions()*b.Replicas)
+		} else if zones[dev.Zone] {
+			score -= float64(b.Partitions() * b.Replicas)
+		}
+		if score > bestScore {
+			best, bestScore = dev.ID, score
+		}
+	}
+	return best
+}
+
+// RingData returns the gzipped ring file read by the Swift daemons, in
+// version 1 of the format written by swift.common.ring.RingData.
+func (b *SwiftRingBuilder) RingData() ([]byte, error) {
+	if b.Assignment == nil {
+		return nil, fmt.Errorf("ring was never rebalanced")
+	}
+	meta, err := json.Marshal(map[string]any{
+		"devs":          b.Devices,
+		"part_shift":    32 - b.PartPower,
+		"replica_count": len(b.Assignment),
+		"byteorder":     "little",
+		"version":       b.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var raw bytes.Buffer
+	raw.WriteString("R1NG")
+	_ = binary.Write(&raw, binary.BigEndian, uint16(1))
+	_ = binary.Write(&raw, binary.BigEndian, uint32(len(meta)))
+	raw.Write(meta)
+	for _, part2dev := range b.Assignment {
+		for _, dev := range part2dev {
+			_ = binary.Write(&raw, binary.LittleEndian, uint16(dev))
+		}
+	}
+	return gzipBytes(raw.Bytes())
+}
+
+// gzipBytes compresses data. The header carries no name or modification
+// time, so equal input gives equal output and unchanged rings are not
+// rewritten.
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
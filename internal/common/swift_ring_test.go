@@ -0,0 +1,293 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func testSwiftRing(t *testing.T, replicas int, weights ...float64) *SwiftRingBuilder {
+	t.Helper()
+	b := NewSwiftRingBuilder(6, replicas, 1)
+	for i, weight := range weights {
+		b.AddDevice(SwiftRingDevice{Region: 1, Zone: i + 1, IP: fmt.Sprintf("10.0.0.%d", i+1), Port: 6200, Device: "d0", Weight: weight})
+	}
+	return b
+}
+
+// checkSwiftRing fails if a partition has two replicas on one device, or a
+// replica on no device or a removed one.
+func checkSwiftRing(t *testing.T, b *SwiftRingBuilder) {
+	t.Helper()
+	for p := 0; p < b.Partitions(); p++ {
+		seen := map[int]bool{}
+		for r := range b.Assignment {
+			dev := b.Assignment[r][p]
+			if dev < 0 || dev >= len(b.Devices) || b.Devices[dev] == nil {
+				t.Fatalf("replica %d of partition %d is on device %d", r, p, dev)
+			}
+			if seen[dev] {
+				t.Fatalf("partition %d has two replicas on device %d", p, dev)
+			}
+			seen[dev] = true
+		}
+	}
+}
+
+func TestSwiftRingInitialAssignment(t *testing.T) {
+	tests := []struct {
+		name     string
+		replicas int
+		weights  []float64
+		want     []int
+	}{
+		{name: "one replica per device", replicas: 3, weights: []float64{100, 100, 100}, want: []int{64, 64, 64}},
+		{name: "equal weights", replicas: 3, weights: []float64{100, 100, 100, 100}, want: []int{48, 48, 48, 48}},
+		{name: "weighted", replicas: 2, weights: []float64{100, 100, 200}, want: []int{32, 32, 64}},
+		{name: "unweighted device", replicas: 2, weights: []float64{100, 100, 0}, want: []int{64, 64, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testSwiftRing(t, tt.replicas, tt.weights...)
+			now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			moved, err := b.Rebalance(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := b.Partitions() * tt.replicas; moved != want {
+				t.Errorf("moved %d partition replicas, want %d", moved, want)
+			}
+			checkSwiftRing(t, b)
+			for id, want := range tt.want {
+				if got := b.PartsOn(id); got != want {
+					t.Errorf("device %d holds %d partition replicas, want %d", id, got, want)
+				}
+			}
+			if balance := b.Balance(); balance != 0 {
+				t.Errorf("balance = %v, want 0", balance)
+			}
+			if b.Version != 1 || !b.LastRebalance.Equal(now) || !b.NextRebalance().Equal(now.Add(time.Hour)) {
+				t.Errorf("version %d, last rebalance %v, next %v", b.Version, b.LastRebalance, b.NextRebalance())
+			}
+		})
+	}
+}
+
+func TestSwiftRingRebalance(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// change is applied to a balanced ring of three devices of weight
+		// 100 and two replicas before rebalancing after the given time.
+		change func(b *SwiftRingBuilder)
+		after  time.Duration
+		// wantMoved is the number of partition replicas moved, or -1 for
+		// any number.
+		wantMoved int
+		// wantParts are the partition replicas per device after the
+		// rebalance, or nil to skip the check.
+		wantParts []int
+	}{
+		{name: "nothing changed", change: func(*SwiftRingBuilder) {}, after: 2 * time.Hour, wantMoved: 0},
+		{
+			name:      "within min part hours",
+			change:    func(b *SwiftRingBuilder) { b.Devices[2].Weight = 200 },
+			after:     30 * time.Minute,
+			wantMoved: 0,
+		},
+		{
+			name:      "weight raised",
+			change:    func(b *SwiftRingBuilder) { b.Devices[2].Weight = 200 },
+			after:     time.Hour,
+			wantMoved: -1,
+			wantParts: []int{32, 32, 64},
+		},
+		{
+			name:      "weight drained",
+			change:    func(b *SwiftRingBuilder) { b.Devices[2].Weight = 0 },
+			after:     time.Hour,
+			wantMoved: -1,
+			wantParts: []int{64, 64, 0},
+		},
+		{
+			name: "device added",
+			change: func(b *SwiftRingBuilder) {
+				b.AddDevice(SwiftRingDevice{Region: 1, Zone: 4, IP: "10.0.0.4", Port: 6200, Device: "d0", Weight: 100})
+			},
+			after:     time.Hour,
+			wantMoved: 32,
+			wantParts: []int{32, 32, 32, 32},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testSwiftRing(t, 2, 100, 100, 100)
+			if _, err := b.Rebalance(start); err != nil {
+				t.Fatal(err)
+			}
+			before := make([][]int, len(b.Assignment))
+			for r := range b.Assignment {
+				before[r] = append([]int(nil), b.Assignment[r]...)
+			}
+			tt.change(b)
+			moved, err := b.Rebalance(start.Add(tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantMoved >= 0 && moved != tt.wantMoved {
+				t.Errorf("moved %d partition replicas, want %d", moved, tt.wantMoved)
+			}
+			checkSwiftRing(t, b)
+
+			// A rebalance moves at most one replica of a partition.
+			changed := 0
+			for p := 0; p < b.Partitions(); p++ {
+				n := 0
+				for r := range b.Assignment {
+					if b.Assignment[r][p] != before[r][p] {
+						n++
+					}
+				}
+				if n > 1 {
+					t.Errorf("partition %d moved %d replicas", p, n)
+				}
+				changed += n
+			}
+			if changed != moved {
+				t.Errorf("%d partition replicas changed, but %d reported moved", changed, moved)
+			}
+			if moved == 0 && b.Version != 1 {
+				t.Errorf("version = %d after a rebalance that moved nothing", b.Version)
+			}
+			for id, want := range tt.wantParts {
+				if got := b.PartsOn(id); got != want {
+					t.Errorf("device %d holds %d partition replicas, want %d", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestSwiftRingRemoveDevice checks that the replicas of a removed device
+// move right away, regardless of MinPartHours, and no others.
+func TestSwiftRingRemoveDevice(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := testSwiftRing(t, 2, 100, 100, 100, 100)
+	if _, err := b.Rebalance(start); err != nil {
+		t.Fatal(err)
+	}
+	before := make([][]int, len(b.Assignment))
+	for r := range b.Assignment {
+		before[r] = append([]int(nil), b.Assignment[r]...)
+	}
+	removed := b.PartsOn(3)
+	b.RemoveDevice(3)
+	moved, err := b.Rebalance(start.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != removed {
+		t.Errorf("moved %d partition replicas, want the %d of the removed device", moved, removed)
+	}
+	checkSwiftRing(t, b)
+	for r := range b.Assignment {
+		for p, dev := range b.Assignment[r] {
+			if before[r][p] != 3 && dev != before[r][p] {
+				t.Errorf("replica %d of partition %d moved off device %d", r, p, before[r][p])
+			}
+		}
+	}
+}
+
+func TestSwiftRingNoDevices(t *testing.T) {
+	b := testSwiftRing(t, 3, 0, 0)
+	if _, err := b.Rebalance(time.Now()); !errors.Is(err, ErrSwiftRingNoDevices) {
+		t.Fatalf("error = %v, want ErrSwiftRingNoDevices", err)
+	}
+	if _, err := b.RingData(); err == nil {
+		t.Fatal("RingData of a ring never rebalanced succeeded")
+	}
+}
+
+func TestSwiftRingBuilderSave(t *testing.T) {
+	b := testSwiftRing(t, 3, 100, 100, 100, 50)
+	b.RemoveDevice(3)
+	if _, err := b.Rebalance(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := b.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := b.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, again) {
+		t.Error("saving the same builder twice gave different bytes")
+	}
+	loaded, err := LoadSwiftRingBuilder(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resaved, err := loaded.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, resaved) {
+		t.Error("a loaded builder saves differently")
+	}
+	if loaded.Devices[3] != nil || loaded.PartsOn(0) != b.PartsOn(0) {
+		t.Error("loaded builder differs")
+	}
+}
+
+// TestSwiftRingData checks the ring file byte for byte against the version
+// 1 format of swift.common.ring.RingData: the magic, a big-endian version
+// and metadata length, the JSON metadata, then the replica to partition to
+// device table as little-endian uint16 rows.
+func TestSwiftRingData(t *testing.T) {
+	b := NewSwiftRingBuilder(2, 2, 1)
+	b.AddDevice(SwiftRingDevice{Region: 1, Zone: 1, IP: "10.0.0.1", Port: 6200, ReplicationIP: "10.0.0.1", ReplicationPort: 6200, Device: "d0", Weight: 100})
+	b.AddDevice(SwiftRingDevice{})
+	b.AddDevice(SwiftRingDevice{Region: 1, Zone: 2, IP: "10.0.0.2", Port: 6200, ReplicationIP: "10.0.0.2", ReplicationPort: 6200, Device: "d0", Weight: 50.5})
+	b.RemoveDevice(1)
+	b.Assignment = [][]int{{0, 2, 0, 2}, {2, 0, 2, 0}}
+	b.Version = 7
+
+	data, err := b.RingData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No name or modification time in the gzip header.
+	if !bytes.Equal(data[3:8], []byte{0, 0, 0, 0, 0}) {
+		t.Errorf("gzip header = % x", data[:10])
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []byte
+	want = append(want, 'R', '1', 'N', 'G', 0x00, 0x01, 0x00, 0x00, 0x01, 0x77)
+	want = append(want, `{"byteorder":"little","devs":[`+
+		`{"id":0,"region":1,"zone":1,"ip":"10.0.0.1","port":6200,"replication_ip":"10.0.0.1","replication_port":6200,"device":"d0","weight":100,"meta":""},`+
+		`null,`+
+		`{"id":2,"region":1,"zone":2,"ip":"10.0.0.2","port":6200,"replication_ip":"10.0.0.2","replication_port":6200,"device":"d0","weight":50.5,"meta":""}`+
+		`],"part_shift":30,"replica_count":2,"version":7}`...)
+	want = append(want,
+		0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x02, 0x00,
+		0x02, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00,
+	)
+	if !bytes.Equal(got, want) {
+		t.Errorf("ring data =\n% x\nwant\n% x", got, want)
+	}
+}
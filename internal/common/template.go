@@ -0,0 +1,53 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/mrrauch/openstack-operator/templates"
+)
+
+// ConfigHashAnnotation is the pod template annotation carrying ConfigHash.
+const ConfigHashAnnotation = "openstack.k8s.io/config-hash"
+
+// templateFuncs are available to every service configuration template.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// RenderTemplate renders the named template (e.g. "neutron/neutron.conf") with data.
+func RenderTemplate(name string, data any) (string, error) {
+	tmpl, err := template.New(name[strings.LastIndex(name, "/")+1:]).
+		Funcs(templateFuncs).
+		ParseFS(templates.FS, name)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ConfigHash returns a stable hash of rendered config files. It is stamped on
+// pod templates so that config changes trigger a rolling restart.
+func ConfigHash(files map[string]string) string {
+	keys := make([]string, 0, len(files))
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(files[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
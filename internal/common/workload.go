@@ -0,0 +1,27 @@
+package common
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// IsDeploymentReady returns true once every desired replica of the Deployment's
+// current generation is updated and available.
+func IsDeploymentReady(deploy *appsv1.Deployment) bool {
+	desired := int32(1)
+	if deploy.Spec.Replicas != nil {
+		desired = *deploy.Spec.Replicas
+	}
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Status.UpdatedReplicas == desired &&
+		deploy.Status.AvailableReplicas == desired
+}
+
+// IsStatefulSetReady returns true once every desired replica of the StatefulSet is ready.
+func IsStatefulSetReady(sts *appsv1.StatefulSet) bool {
+	desired := int32(1)
+	if sts.Spec.Replicas != nil {
+		desired = *sts.Spec.Replicas
+	}
+	return sts.Status.ObservedGeneration >= sts.Generation &&
+		sts.Status.ReadyReplicas == desired
+}
@@ -0,0 +1,453 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const barbicanAPIPort = 9311
+
+// barbicanComponent describes one Barbican Deployment.
+type barbicanComponent struct {
+	name         string
+	defaultImage string
+	command      []string
+	// port is exposed through a Service named <barbican>-<name>; 0 means none.
+	port int32
+}
+
+var barbicanComponents = []barbicanComponent{
+	{name: "api", defaultImage: images.DefaultBarbicanAPI, command: []string{"barbican-wsgi-api", "--port", fmt.Sprint(barbicanAPIPort), "--host", "0.0.0.0"}, port: barbicanAPIPort},
+	{name: "worker", defaultImage: images.DefaultBarbicanWorker, command: []string{"barbican-worker", "--config-file", "/etc/barbican/barbican.conf"}},
+	{name: "keystone-listener", defaultImage: images.DefaultBarbicanKeystoneListener, command: []string{"barbican-keystone-listener", "--config-file", "/etc/barbican/barbican.conf"}},
+}
+
+// BarbicanReconciler reconciles a Barbican object.
+type BarbicanReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=barbicans,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=barbicans/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=barbicans/finalizers,verbs=update
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *BarbicanReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.Barbican{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion: the KEK is not owned by the Barbican and outlives it
+	// unless its deletion was confirmed.
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			if err := r.releaseKEK(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "Reconciling", "Reconciliation in progress", instance.Generation,
+	)
+
+	if store := barbicanSecretStore(instance); store == "kmip" {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "SecretStoreNotSupported", fmt.Sprintf("Secret store %s is not supported", store))
+	}
+
+	conns, err := common.ResolveConnections(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Ensure generated credentials
+	for _, name := range []string{barbicanDBSecretName(instance), barbicanServiceSecretName(instance)} {
+		if err := common.EnsureSecret(ctx, r.Client, name, instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	var kek string
+	if barbicanSecretStore(instance) == "simple_crypto" {
+		kek, err = r.ensureKEK(ctx, instance)
+		if err != nil {
+			var kekErr *barbicanKEKError
+			if errors.As(err, &kekErr) {
+				return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), kekErr.reason, kekErr.Error())
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Ensure database
+	if err := common.EnsureDatabase(ctx, r.Client, common.DatabaseParams{
+		Name:          instance.Name,
+		Namespace:     instance.Namespace,
+		DatabaseName:  "barbican",
+		Username:      "barbican",
+		SecretName:    barbicanDBSecretName(instance),
+		MariaDBSecret: conns.MariaDBSecret,
+		MariaDBHost:   conns.MariaDBHost,
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "CreatingDatabase", "Waiting for database creation")
+	}
+
+	apiEndpoint := fmt.Sprintf("http://%s-api.%s.svc:%d", instance.Name, instance.Namespace, barbicanAPIPort)
+	configHash, err := r.ensureConfig(ctx, instance, conns, apiEndpoint, kek)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	params := common.DBSyncParams{
+		Name:         instance.Name,
+		Namespace:    instance.Namespace,
+		Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultBarbicanAPI),
+		Command:      []string{"barbican-manage", "db", "upgrade"},
+		SecretName:   barbicanDBSecretName(instance),
+		Volumes:      []corev1.Volume{barbicanConfigVolume(instance)},
+		VolumeMounts: []corev1.VolumeMount{barbicanConfigVolumeMount()},
+	}
+	if err := common.EnsureDBSync(ctx, r.Client, params, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-sync", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "SyncingDatabase", "Waiting for database migration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDatabaseReady), metav1.ConditionTrue, "DatabaseSynced", "Database created and migrated", instance.Generation,
+	)
+
+	// The PKCS#11 plugin needs its MKEK and HMAC key to start.
+	if instance.Spec.PKCS11 != nil {
+		if done, err := r.ensureHSMKeys(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		} else if !done {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "GeneratingHSMKeys", "Waiting for the MKEK and HMAC key")
+		}
+	}
+
+	// Ensure Barbican services
+	for _, component := range barbicanComponents {
+		name := instance.Name + "-" + component.name
+		labels := labelsForBarbican(instance.Name, component.name)
+		if err := r.ensureDeployment(ctx, instance, name, labels, component, configHash); err != nil {
+			return ctrl.Result{}, err
+		}
+		if component.port != 0 {
+			if err := r.ensureService(ctx, instance, name, labels, component); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	if err := common.EnsureKeystoneEndpoint(ctx, r.Client, common.EndpointParams{
+		Name:              instance.Name,
+		Namespace:         instance.Namespace,
+		ServiceName:       "barbican",
+		ServiceType:       "key-manager",
+		InternalURL:       apiEndpoint,
+		PublicURL:         apiEndpoint,
+		AdminURL:          apiEndpoint,
+		Region:            conns.Region,
+		KeystoneSecret:    conns.KeystoneSecret,
+		KeystoneURL:       conns.KeystoneURL,
+		BootstrapImage:    images.DefaultKeystone,
+		ServiceUser:       "barbican",
+		ServiceUserSecret: barbicanServiceSecretName(instance),
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-endpoint-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "RegisteringEndpoint", "Waiting for Keystone endpoint registration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady), metav1.ConditionTrue, "EndpointRegistered", "Keystone service, user and endpoints registered", instance.Generation,
+	)
+	instance.Status.APIEndpoint = apiEndpoint
+
+	var notReady []string
+	for _, component := range barbicanComponents {
+		deploy := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-" + component.name, Namespace: instance.Namespace}, deploy); err != nil {
+			return ctrl.Result{}, err
+		}
+		if !common.IsDeploymentReady(deploy) {
+			notReady = append(notReady, component.name)
+		}
+	}
+	if len(notReady) > 0 {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "DeploymentNotReady", fmt.Sprintf("Waiting for %v", notReady))
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDeploymentReady), metav1.ConditionTrue, "DeploymentReady", "barbican-api, barbican-worker and barbican-keystone-listener are available", instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "Barbican is ready", instance.Generation,
+	)
+
+	if err := r.updateStatus(ctx, instance); err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// waitFor records a not-yet-satisfied condition and requeues.
+func (r *BarbicanReconciler) waitFor(ctx context.Context, instance *openstackv1alpha1.Barbican, condType, reason, message string) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(instance.Status.Conditions, condType, metav1.ConditionFalse, reason, message, instance.Generation)
+	if condType != string(openstackv1alpha1.ConditionReady) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+func (r *BarbicanReconciler) updateStatus(ctx context.Context, instance *openstackv1alpha1.Barbican) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	return r.Status().Update(ctx, instance)
+}
+
+// ensureConfig renders barbican.conf, and softhsm2.conf for SoftHSM, into a
+// Secret (they embed credentials and the KEK) and returns its hash.
+func (r *BarbicanReconciler) ensureConfig(ctx context.Context, instance *openstackv1alpha1.Barbican, conns *common.Connections, apiEndpoint, kek string) (string, error) {
+	dbPassword, err := common.GetSecretValue(ctx, r.Client, barbicanDBSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	servicePassword, err := common.GetSecretValue(ctx, r.Client, barbicanServiceSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	mqSecret := instance.Spec.MessageQueue.SecretName
+	if mqSecret == "" {
+		mqSecret = conns.RabbitMQSecret
+	}
+	mqUser, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "username")
+	if err != nil {
+		return "", err
+	}
+	mqPassword, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]any{
+		"DatabaseURL":      common.DatabaseURL("barbican", dbPassword, conns.MariaDBHost, "barbican"),
+		"TransportURL":     common.TransportURL(mqUser, mqPassword, conns.RabbitMQHost),
+		"HostHref":         apiEndpoint,
+		"KeystoneURL":      conns.KeystoneURL,
+		"MemcachedServers": conns.MemcachedServers,
+		"Region":           conns.Region,
+		"ServiceUser":      "barbican",
+		"ServicePassword":  servicePassword,
+		"KeystoneListener": barbicanKeystoneListener(instance),
+		"SecretStore":      barbicanSecretStore(instance),
+		"KEK":              kek,
+	}
+	storeData, err := r.barbicanStoreData(ctx, instance)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range storeData {
+		data[key] = value
+	}
+	conf, err := common.RenderTemplate("barbican/barbican.conf", data)
+	if err != nil {
+		return "", err
+	}
+	files := map[string]string{"barbican.conf": conf}
+	if p11 := instance.Spec.PKCS11; p11 != nil && p11.SoftHSM != nil {
+		if files["softhsm2.conf"], err = common.RenderTemplate("barbican/softhsm2.conf", nil); err != nil {
+			return "", err
+		}
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-config", Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labelsForBarbican(instance.Name, "config")
+		secret.Data = map[string][]byte{}
+		for name, content := range files {
+			secret.Data[name] = []byte(content)
+		}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return "", err
+	}
+	return common.ConfigHash(files), nil
+}
+
+func (r *BarbicanReconciler) ensureDeployment(ctx context.Context, instance *openstackv1alpha1.Barbican, name string, labels map[string]string, component barbicanComponent, configHash string) error {
+	replicas := int32(1)
+	switch component.name {
+	case "worker":
+		if instance.Spec.WorkerReplicas != nil {
+			replicas = *instance.Spec.WorkerReplicas
+		}
+	case "keystone-listener":
+		if !barbicanKeystoneListener(instance) {
+			replicas = 0
+		}
+	default:
+		if instance.Spec.Replicas != nil {
+			replicas = *instance.Spec.Replicas
+		}
+	}
+	container := corev1.Container{
+		Name:         "barbican-" + component.name,
+		Image:        images.ImageOrDefault(instance.Spec.Image, component.defaultImage),
+		Command:      component.command,
+		Resources:    instance.Spec.Resources,
+		Env:          barbicanEnv(instance),
+		VolumeMounts: barbicanVolumeMounts(instance),
+	}
+	if component.port != 0 {
+		container.Ports = []corev1.ContainerPort{{ContainerPort: component.port, Name: component.name}}
+		container.ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt32(component.port)},
+			},
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       10,
+		}
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{container}
+		deploy.Spec.Template.Spec.SecurityContext = barbicanPodSecurityContext(instance)
+		deploy.Spec.Template.Spec.Volumes = barbicanVolumes(instance)
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
+
+func (r *BarbicanReconciler) ensureService(ctx context.Context, instance *openstackv1alpha1.Barbican, name string, labels map[string]string, component barbicanComponent) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labels
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: component.name, Port: component.port, TargetPort: intstr.FromInt32(component.port), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+func (r *BarbicanReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.Barbican{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
+
+func barbicanSecretStore(instance *openstackv1alpha1.Barbican) string {
+	if instance.Spec.SecretStore != "" {
+		return instance.Spec.SecretStore
+	}
+	return "simple_crypto"
+}
+
+func barbicanKeystoneListener(instance *openstackv1alpha1.Barbican) bool {
+	return instance.Spec.KeystoneListener == nil || *instance.Spec.KeystoneListener
+}
+
+func barbicanDBSecretName(instance *openstackv1alpha1.Barbican) string {
+	if instance.Spec.Database.SecretName != "" {
+		return instance.Spec.Database.SecretName
+	}
+	return instance.Name + "-db-password"
+}
+
+func barbicanServiceSecretName(instance *openstackv1alpha1.Barbican) string {
+	return instance.Name + "-service-password"
+}
+
+func barbicanConfigVolume(instance *openstackv1alpha1.Barbican) corev1.Volume {
+	return corev1.Volume{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: instance.Name + "-config"},
+		},
+	}
+}
+
+// barbicanConfigVolumeMount mounts only barbican.conf, keeping the paste
+// configuration of the image next to it.
+func barbicanConfigVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{Name: "config", MountPath: "/etc/barbican/barbican.conf", SubPath: "barbican.conf", ReadOnly: true}
+}
+
+func labelsForBarbican(name, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "barbican",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/component":  component,
+		"app.kubernetes.io/managed-by": "openstack-operator",
+	}
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// barbicanKEKFinalizer holds the KEK Secret while its Barbican exists, so
+// that deleting the Secret by accident does not take effect.
+const barbicanKEKFinalizer = "openstack.k8s.io/barbican-kek"
+
+// barbicanKEKError reports a KEK that Barbican must not run with. The
+// reason is used for the Ready condition.
+type barbicanKEKError struct {
+	reason  string
+	message string
+}
+
+func (e *barbicanKEKError) Error() string { return e.message }
+
+// ensureKEK returns the simple_crypto key encryption key, generating it on
+// first use. The Secret has no owner, is immutable and is never rewritten:
+// a new key would make every stored secret unreadable. Once a key has been
+// used its fingerprint is recorded, and a missing or different key stops
+// the reconciliation instead of being replaced.
+func (r *BarbicanReconciler) ensureKEK(ctx context.Context, instance *openstackv1alpha1.Barbican) (string, error) {
+	name := barbicanKEKSecretName(instance)
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: instance.Namespace}, secret)
+	if apierrors.IsNotFound(err) {
+		if instance.Status.KEKFingerprint != "" {
+			return "", &barbicanKEKError{"KEKMissing", fmt.Sprintf("KEK Secret %s with fingerprint %s is missing; restore it from a backup", name, instance.Status.KEKFingerprint)}
+		}
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return "", err
+		}
+		immutable := true
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  instance.Namespace,
+				Labels:     labelsForBarbican(instance.Name, "kek"),
+				Finalizers: []string{barbicanKEKFinalizer},
+			},
+			Immutable: &immutable,
+			Data:      map[string][]byte{"kek": []byte(base64.URLEncoding.EncodeToString(key))},
+		}
+		if err := r.Create(ctx, secret); err != nil {
+			return "", err
+		}
+		log.FromContext(ctx).Info("generated simple_crypto KEK", "secret", name)
+	} else if err != nil {
+		return "", err
+	}
+
+	kek := string(secret.Data["kek"])
+	if key, err := base64.URLEncoding.DecodeString(kek); err != nil || len(key) != 32 {
+		return "", &barbicanKEKError{"KEKInvalid", fmt.Sprintf("KEK Secret %s does not hold a base64 encoded 32 byte key", name)}
+	}
+	fingerprint := barbicanKEKFingerprint(kek)
+	if instance.Status.KEKFingerprint != "" && instance.Status.KEKFingerprint != fingerprint {
+		return "", &barbicanKEKError{"KEKChanged", fmt.Sprintf("KEK Secret %s has fingerprint %s, expected %s", name, fingerprint, instance.Status.KEKFingerprint)}
+	}
+	// A Secret adopted from an earlier Barbican, or released by one, is
+	// protected again.
+	if secret.DeletionTimestamp.IsZero() && !common.HasFinalizer(secret, barbicanKEKFinalizer) {
+		common.AddFinalizer(secret, barbicanKEKFinalizer)
+		if err := r.Update(ctx, secret); err != nil {
+			return "", err
+		}
+	}
+	instance.Status.KEKFingerprint = fingerprint
+	return kek, nil
+}
+
+// releaseKEK lifts the protection of the KEK Secret when its Barbican is
+// deleted. The Secret itself is only deleted with KEKDeletionPolicy Delete;
+// with Retain, a deletion requested while the Barbican ran stays blocked
+// until the finalizer is removed by hand.
+func (r *BarbicanReconciler) releaseKEK(ctx context.Context, instance *openstackv1alpha1.Barbican) error {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Name: barbicanKEKSecretName(instance), Namespace: instance.Namespace}, secret); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if instance.Spec.KEKDeletionPolicy != "Delete" && !secret.DeletionTimestamp.IsZero() {
+		log.FromContext(ctx).Info("retaining simple_crypto KEK whose deletion was requested; remove its finalizer to delete it",
+			"secret", secret.Name, "finalizer", barbicanKEKFinalizer)
+		return nil
+	}
+	if common.HasFinalizer(secret, barbicanKEKFinalizer) {
+		common.RemoveFinalizer(secret, barbicanKEKFinalizer)
+		if err := r.Update(ctx, secret); err != nil {
+			return err
+		}
+	}
+	if instance.Spec.KEKDeletionPolicy != "Delete" {
+		log.FromContext(ctx).Info("retaining simple_crypto KEK", "secret", secret.Name)
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, secret))
+}
+
+func barbicanKEKSecretName(instance *openstackv1alpha1.Barbican) string {
+	return instance.Name + "-simple-crypto-kek"
+}
+
+// barbicanKEKFingerprint returns the first 16 hex digits of the SHA-256 of
+// the key.
+func barbicanKEKFingerprint(kek string) string {
+	sum := sha256.Sum256([]byte(kek))
+	return hex.EncodeToString(sum[:])[:16]
+}
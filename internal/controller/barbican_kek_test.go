@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func newBarbican(fingerprint string) *openstackv1alpha1.Barbican {
+	instance := &openstackv1alpha1.Barbican{
+		ObjectMeta: metav1.ObjectMeta{Name: "barbican", Namespace: "openstack"},
+	}
+	instance.Status.KEKFingerprint = fingerprint
+	return instance
+}
+
+// kekSecret is a KEK Secret as an earlier Barbican or a backup left it.
+func kekSecret(kek string, finalizers ...string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "barbican-simple-crypto-kek", Namespace: "openstack", Finalizers: finalizers},
+		Data:       map[string][]byte{"kek": []byte(kek)},
+	}
+}
+
+func getKEKSecret(t *testing.T, c client.Client) (*corev1.Secret, error) {
+	t.Helper()
+	secret := &corev1.Secret{}
+	err := c.Get(context.Background(), client.ObjectKey{Name: "barbican-simple-crypto-kek", Namespace: "openstack"}, secret)
+	return secret, err
+}
+
+func TestBarbicanEnsureKEK(t *testing.T) {
+	kek := base64.URLEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	other := base64.URLEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	deleting := kekSecret(kek, barbicanKEKFinalizer)
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		secret      *corev1.Secret
+		want        string
+		wantReason  string
+	}{
+		{name: "existing key", fingerprint: barbicanKEKFingerprint(kek), secret: kekSecret(kek, barbicanKEKFinalizer), want: kek},
+		// A Secret restored from a backup or left by an earlier Barbican
+		// is adopted and protected again.
+		{name: "adopted", secret: kekSecret(kek), want: kek},
+		{name: "missing after use", fingerprint: barbicanKEKFingerprint(kek), wantReason: "KEKMissing"},
+		{name: "not base64", secret: kekSecret("not a key!"), wantReason: "KEKInvalid"},
+		{name: "wrong length", secret: kekSecret(base64.URLEncoding.EncodeToString([]byte("short"))), wantReason: "KEKInvalid"},
+		{name: "replaced", fingerprint: barbicanKEKFingerprint(kek), secret: kekSecret(other, barbicanKEKFinalizer), wantReason: "KEKChanged"},
+		// The finalizer holds the deletion; the key stays in use meanwhile.
+		{name: "deletion requested", fingerprint: barbicanKEKFingerprint(kek), secret: deleting, want: kek},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []client.Object
+			if tt.secret != nil {
+				objs = append(objs, tt.secret.DeepCopy())
+			}
+			c := newFakeClient(t, objs...)
+			r := &BarbicanReconciler{Client: c, Scheme: testScheme}
+			instance := newBarbican(tt.fingerprint)
+
+			got, err := r.ensureKEK(context.Background(), instance)
+			if tt.wantReason != "" {
+				var kekErr *barbicanKEKError
+				if !errors.As(err, &kekErr) || kekErr.reason != tt.wantReason {
+					t.Fatalf("error = %v, want %s", err, tt.wantReason)
+				}
+				if instance.Status.KEKFingerprint != tt.fingerprint {
+					t.Errorf("fingerprint = %q, want %q kept", instance.Status.KEKFingerprint, tt.fingerprint)
+				}
+				// The key in the Secret is never replaced.
+				secret, err := getKEKSecret(t, c)
+				if tt.secret == nil {
+					if !apierrors.IsNotFound(err) {
+						t.Errorf("get = %v, want no key generated", err)
+					}
+				} else if string(secret.Data["kek"]) != string(tt.secret.Data["kek"]) {
+					t.Error("KEK Secret rewritten")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("kek = %q, want %q", got, tt.want)
+			}
+			if instance.Status.KEKFingerprint != barbicanKEKFingerprint(tt.want) {
+				t.Errorf("fingerprint = %q", instance.Status.KEKFingerprint)
+			}
+			secret, err := getKEKSecret(t, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !common.HasFinalizer(secret, barbicanKEKFinalizer) {
+				t.Error("KEK Secret not protected by the finalizer")
+			}
+		})
+	}
+}
+
+func TestBarbicanEnsureKEKGenerated(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t)
+	r := &BarbicanReconciler{Client: c, Scheme: testScheme}
+	instance := newBarbican("")
+
+	kek, err := r.ensureKEK(ctx, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := base64.URLEncoding.DecodeString(kek); err != nil || len(key) != 32 {
+		t.Fatalf("kek = %q, want a base64 encoded 32 byte key", kek)
+	}
+	if instance.Status.KEKFingerprint != barbicanKEKFingerprint(kek) {
+		t.Errorf("fingerprint = %q, want %q", instance.Status.KEKFingerprint, barbicanKEKFingerprint(kek))
+	}
+	secret, err := getKEKSecret(t, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Deleting the Barbican must not take the key with it.
+	if len(secret.OwnerReferences) != 0 {
+		t.Errorf("owner references = %v", secret.OwnerReferences)
+	}
+	if secret.Immutable == nil || !*secret.Immutable {
+		t.Error("KEK Secret is not immutable")
+	}
+	if !common.HasFinalizer(secret, barbicanKEKFinalizer) {
+		t.Error("KEK Secret not protected by the finalizer")
+	}
+
+	// The next pass uses the same key and leaves the Secret alone.
+	again, err := r.ensureKEK(ctx, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != kek {
+		t.Error("KEK regenerated")
+	}
+	if current, err := getKEKSecret(t, c); err != nil || current.ResourceVersion != secret.ResourceVersion {
+		t.Errorf("get = %v, want the KEK Secret unchanged", err)
+	}
+}
+
+func TestBarbicanReleaseKEK(t *testing.T) {
+	kek := base64.URLEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	deleting := kekSecret(kek, barbicanKEKFinalizer)
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+
+	tests := []struct {
+		name           string
+		policy         string
+		secret         *corev1.Secret
+		wantKept       bool
+		wantFinalizers []string
+	}{
+		{name: "retained by default", secret: kekSecret(kek, barbicanKEKFinalizer), wantKept: true},
+		{name: "deleted", policy: "Delete", secret: kekSecret(kek, barbicanKEKFinalizer)},
+		{name: "other finalizers kept", secret: kekSecret(kek, barbicanKEKFinalizer, "backup"), wantKept: true, wantFinalizers: []string{"backup"}},
+		// A retained key is not released by a deletion that was requested
+		// while Barbican ran.
+		{name: "retained while deletion requested", secret: deleting, wantKept: true, wantFinalizers: []string{barbicanKEKFinalizer}},
+		{name: "deleted while deletion requested", policy: "Delete", secret: deleting},
+		{name: "missing", policy: "Delete"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []client.Object
+			if tt.secret != nil {
+				objs = append(objs, tt.secret.DeepCopy())
+			}
+			c := newFakeClient(t, objs...)
+			r := &BarbicanReconciler{Client: c, Scheme: testScheme}
+			instance := newBarbican(barbicanKEKFingerprint(kek))
+			instance.Spec.KEKDeletionPolicy = tt.policy
+
+			if err := r.releaseKEK(context.Background(), instance); err != nil {
+				t.Fatal(err)
+			}
+			secret, err := getKEKSecret(t, c)
+			if !tt.wantKept {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("get = %v, want the KEK Secret deleted", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(secret.Finalizers, ",") != strings.Join(tt.wantFinalizers, ",") {
+				t.Errorf("finalizers = %v, want %v", secret.Finalizers, tt.wantFinalizers)
+			}
+		})
+	}
+}
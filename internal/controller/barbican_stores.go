@@ -0,0 +1,248 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	// barbicanUID is the barbican user of the Kolla images.
+	barbicanUID = 42403
+
+	barbicanVaultCAPath   = "/etc/barbican/vault/ca.crt"
+	barbicanSoftHSMConf   = "/etc/barbican/softhsm2.conf"
+	barbicanSoftHSMTokens = "/var/lib/softhsm/tokens"
+)
+
+// barbicanStoreData returns the template data of the vault and pkcs11
+// secret stores, with the credentials read from their Secrets.
+func (r *BarbicanReconciler) barbicanStoreData(ctx context.Context, instance *openstackv1alpha1.Barbican) (map[string]any, error) {
+	data := map[string]any{}
+	if vault := instance.Spec.Vault; vault != nil {
+		roleID, err := common.GetSecretValue(ctx, r.Client, vault.AppRoleSecretName, instance.Namespace, "roleID")
+		if err != nil {
+			return nil, err
+		}
+		secretID, err := common.GetSecretValue(ctx, r.Client, vault.AppRoleSecretName, instance.Namespace, "secretID")
+		if err != nil {
+			return nil, err
+		}
+		mount := vault.KVMountPoint
+		if mount == "" {
+			mount = "secret"
+		}
+		caFile := ""
+		if vault.CASecretName != "" {
+			caFile = barbicanVaultCAPath
+		}
+		data["Vault"] = map[string]any{
+			"URL":          vault.URL,
+			"RoleID":       roleID,
+			"SecretID":     secretID,
+			"KVMountPoint": mount,
+			"UseSSL":       strings.HasPrefix(vault.URL, "https://"),
+			"CAFile":       caFile,
+		}
+	}
+	if p11 := instance.Spec.PKCS11; p11 != nil {
+		pin, err := common.GetSecretValue(ctx, r.Client, p11.PINSecretName, instance.Namespace, "pin")
+		if err != nil {
+			return nil, err
+		}
+		mkekLabel, mkekLength, hmacLabel := barbicanHSMKeys(p11)
+		slotID := int32(0)
+		if p11.SlotID != nil {
+			slotID = *p11.SlotID
+		}
+		data["PKCS11"] = map[string]any{
+			"LibraryPath": p11.LibraryPath,
+			"PIN":         pin,
+			"SlotID":      slotID,
+			"TokenLabel":  p11.TokenLabel,
+			"MKEKLabel":   mkekLabel,
+			"MKEKLength":  mkekLength,
+			"HMACLabel":   hmacLabel,
+		}
+	}
+	return data, nil
+}
+
+// ensureHSMKeys runs a Job that generates the MKEK and HMAC key in the HSM
+// unless keys with their labels exist; with SoftHSM it first initializes
+// the token. The Job is named after the key settings, so that changing
+// them generates the new keys.
+func (r *BarbicanReconciler) ensureHSMKeys(ctx context.Context, instance *openstackv1alpha1.Barbican) (bool, error) {
+	p11 := instance.Spec.PKCS11
+	mkekLabel, mkekLength, hmacLabel := barbicanHSMKeys(p11)
+	token := barbicanHSMToken(p11)
+	hash := common.ConfigHash(map[string]string{
+		"library": p11.LibraryPath,
+		"token":   token,
+		"mkek":    fmt.Sprintf("%s/%d", mkekLabel, mkekLength),
+		"hmac":    hmacLabel,
+	})
+	name := fmt.Sprintf("%s-hsm-keys-%s", instance.Name, hash[:8])
+	labels := labelsForBarbican(instance.Name, "hsm-keys")
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: instance.Namespace}, job)
+	if err == nil {
+		return common.IsJobComplete(ctx, r.Client, name, instance.Namespace)
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(instance.Namespace), client.MatchingLabels(labels)); err != nil {
+		return false, err
+	}
+	for i := range jobs.Items {
+		if err := r.Delete(ctx, &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+	}
+
+	backoffLimit := int32(4)
+	job = &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyOnFailure,
+					SecurityContext: barbicanPodSecurityContext(instance),
+					Volumes:         barbicanVolumes(instance),
+					Containers: []corev1.Container{
+						{
+							Name:         "hsm-keys",
+							Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultBarbicanAPI),
+							Command:      []string{"/bin/sh", "-c", barbicanHSMKeysScript(p11)},
+							Env:          append(barbicanEnv(instance), common.SecretEnvVar("PKCS11_PIN", p11.PINSecretName, "pin")),
+							VolumeMounts: barbicanVolumeMounts(instance),
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := controllerutil.SetControllerReference(instance, job, r.Scheme); err != nil {
+		return false, err
+	}
+	return false, r.Create(ctx, job)
+}
+
+// barbicanHSMKeysScript returns the script of the HSM key Job.
+func barbicanHSMKeysScript(p11 *openstackv1alpha1.BarbicanPKCS11Spec) string {
+	mkekLabel, mkekLength, hmacLabel := barbicanHSMKeys(p11)
+	token := barbicanHSMToken(p11)
+
+	// barbican-manage refuses to generate a key under a label in use, which
+	// is how existing keys are kept.
+	var script strings.Builder
+	script.WriteString("set -e\n")
+	if p11.SoftHSM != nil {
+		fmt.Fprintf(&script, `if ! softhsm2-util --show-slots | grep -Eq "Label: +%[1]s *$"; then
+  softhsm2-util --init-token --free --label %[1]s --pin "$PKCS11_PIN" --so-pin "$PKCS11_PIN"
+fi
+`, p11.TokenLabel)
+	}
+	fmt.Fprintf(&script, `gen() {
+  out=$(barbican-manage hsm "$@" --library-path %s --passphrase "$PKCS11_PIN" %s 2>&1) && return 0
+  case "$out" in *"already exists"*) return 0 ;; esac
+  echo "$out" >&2
+  return 1
+}
+gen gen_mkek --label %s --length %d
+gen gen_hmac --label %s --length 32
+`, p11.LibraryPath, token, mkekLabel, mkekLength, hmacLabel)
+	return script.String()
+}
+
+// barbicanHSMToken returns the barbican-manage option selecting the token.
+func barbicanHSMToken(p11 *openstackv1alpha1.BarbicanPKCS11Spec) string {
+	if p11.SlotID != nil {
+		return fmt.Sprintf("--slot-id %d", *p11.SlotID)
+	}
+	return fmt.Sprintf("--token-label %s", p11.TokenLabel)
+}
+
+// barbicanHSMKeys returns the MKEK label and length and the HMAC key label.
+func barbicanHSMKeys(p11 *openstackv1alpha1.BarbicanPKCS11Spec) (string, int32, string) {
+	mkekLabel, mkekLength, hmacLabel := p11.MKEKLabel, p11.MKEKLength, p11.HMACLabel
+	if mkekLabel == "" {
+		mkekLabel = "barbican_mkek"
+	}
+	if mkekLength == 0 {
+		mkekLength = 32
+	}
+	if hmacLabel == "" {
+		hmacLabel = "barbican_hmac"
+	}
+	return mkekLabel, mkekLength, hmacLabel
+}
+
+// barbicanVolumes returns the volumes of the Barbican pods: the config and,
+// depending on the secret store, the Vault CA or the SoftHSM tokens.
+func barbicanVolumes(instance *openstackv1alpha1.Barbican) []corev1.Volume {
+	volumes := []corev1.Volume{barbicanConfigVolume(instance)}
+	if vault := instance.Spec.Vault; vault != nil && vault.CASecretName != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: "vault-ca",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: vault.CASecretName},
+			},
+		})
+	}
+	if p11 := instance.Spec.PKCS11; p11 != nil && p11.SoftHSM != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: "softhsm-tokens",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: p11.SoftHSM.ClaimName},
+			},
+		})
+	}
+	return volumes
+}
+
+func barbicanVolumeMounts(instance *openstackv1alpha1.Barbican) []corev1.VolumeMount {
+	mounts := []corev1.VolumeMount{barbicanConfigVolumeMount()}
+	if vault := instance.Spec.Vault; vault != nil && vault.CASecretName != "" {
+		mounts = append(mounts, corev1.VolumeMount{Name: "vault-ca", MountPath: barbicanVaultCAPath, SubPath: "ca.crt", ReadOnly: true})
+	}
+	if p11 := instance.Spec.PKCS11; p11 != nil && p11.SoftHSM != nil {
+		mounts = append(mounts,
+			corev1.VolumeMount{Name: "config", MountPath: barbicanSoftHSMConf, SubPath: "softhsm2.conf", ReadOnly: true},
+			corev1.VolumeMount{Name: "softhsm-tokens", MountPath: barbicanSoftHSMTokens},
+		)
+	}
+	return mounts
+}
+
+func barbicanEnv(instance *openstackv1alpha1.Barbican) []corev1.EnvVar {
+	if p11 := instance.Spec.PKCS11; p11 != nil && p11.SoftHSM != nil {
+		return []corev1.EnvVar{{Name: "SOFTHSM2_CONF", Value: barbicanSoftHSMConf}}
+	}
+	return nil
+}
+
+// barbicanPodSecurityContext makes the SoftHSM tokens writable by the
+// barbican user.
+func barbicanPodSecurityContext(instance *openstackv1alpha1.Barbican) *corev1.PodSecurityContext {
+	if p11 := instance.Spec.PKCS11; p11 != nil && p11.SoftHSM != nil {
+		fsGroup := int64(barbicanUID)
+		return &corev1.PodSecurityContext{FSGroup: &fsGroup}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestBarbicanHSMKeysScript(t *testing.T) {
+	slot := int32(2)
+	tests := []struct {
+		name string
+		p11  *openstackv1alpha1.BarbicanPKCS11Spec
+		want []string
+		omit []string
+	}{
+		{
+			name: "softhsm",
+			p11: &openstackv1alpha1.BarbicanPKCS11Spec{
+				LibraryPath: "/usr/lib/softhsm/libsofthsm2.so", TokenLabel: "barbican", PINSecretName: "barbican-pin",
+				SoftHSM: &openstackv1alpha1.BarbicanSoftHSMSpec{ClaimName: "barbican-softhsm"},
+			},
+			want: []string{
+				`softhsm2-util --show-slots | grep -Eq "Label: +barbican *$"`,
+				`softhsm2-util --init-token --free --label barbican --pin "$PKCS11_PIN" --so-pin "$PKCS11_PIN"`,
+				`--library-path /usr/lib/softhsm/libsofthsm2.so --passphrase "$PKCS11_PIN" --token-label barbican`,
+				"gen gen_mkek --label barbican_mkek --length 32",
+				"gen gen_hmac --label barbican_hmac --length 32",
+			},
+		},
+		{
+			name: "hsm slot",
+			p11: &openstackv1alpha1.BarbicanPKCS11Spec{
+				LibraryPath: "/opt/hsm/lib/libcryptoki.so", SlotID: &slot, PINSecretName: "barbican-pin",
+				MKEKLabel: "mkek2", MKEKLength: 16, HMACLabel: "hmac2",
+			},
+			want: []string{
+				`--library-path /opt/hsm/lib/libcryptoki.so --passphrase "$PKCS11_PIN" --slot-id 2`,
+				"gen gen_mkek --label mkek2 --length 16",
+				"gen gen_hmac --label hmac2 --length 32",
+			},
+			omit: []string{"softhsm2-util"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := barbicanHSMKeysScript(tt.p11)
+			for _, want := range tt.want {
+				if !strings.Contains(script, want) {
+					t.Errorf("missing %q in:\n%s", want, script)
+				}
+			}
+			for _, omit := range tt.omit {
+				if strings.Contains(script, omit) {
+					t.Errorf("unexpected %q in:\n%s", omit, script)
+				}
+			}
+		})
+	}
+}
+
+// TestBarbicanStoreConfig checks the plugin options for the Vault dev-mode
+// server and the SoftHSM2 token of config/samples/barbican_vault_dev.yaml
+// and config/samples/barbican_softhsm.yaml.
+func TestBarbicanStoreConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		store string
+		data  map[string]any
+		want  []string
+		omit  []string
+	}{
+		{
+			name:  "vault dev mode",
+			store: "vault",
+			data: map[string]any{"Vault": map[string]any{
+				"URL": "http://vault.openstack.svc:8200", "RoleID": "role", "SecretID": "secret",
+				"KVMountPoint": "secret", "UseSSL": false, "CAFile": "",
+			}},
+			want: []string{
+				"enabled_secretstore_plugins = vault_plugin",
+				"vault_url = http://vault.openstack.svc:8200",
+				"approle_role_id = role",
+				"approle_secret_id = secret",
+				"kv_mountpoint = secret",
+				"use_ssl = false",
+			},
+			omit: []string{"ssl_ca_crt_file", "[crypto]"},
+		},
+		{
+			name:  "vault with CA",
+			store: "vault",
+			data: map[string]any{"Vault": map[string]any{
+				"URL": "https://vault.example.com:8200", "RoleID": "role", "SecretID": "secret",
+				"KVMountPoint": "kv", "UseSSL": true, "CAFile": barbicanVaultCAPath,
+			}},
+			want: []string{"use_ssl = true", "ssl_ca_crt_file = " + barbicanVaultCAPath},
+		},
+		{
+			name:  "softhsm",
+			store: "pkcs11",
+			data: map[string]any{"PKCS11": map[string]any{
+				"LibraryPath": "/usr/lib/softhsm/libsofthsm2.so", "PIN": "1234", "SlotID": int32(0), "TokenLabel": "barbican",
+				"MKEKLabel": "barbican_mkek", "MKEKLength": int32(32), "HMACLabel": "barbican_hmac",
+			}},
+			want: []string{
+				"enabled_secretstore_plugins = store_crypto",
+				"enabled_crypto_plugins = p11_crypto",
+				"library_path = /usr/lib/softhsm/libsofthsm2.so",
+				"login = 1234",
+				"token_labels = barbican",
+				"mkek_label = barbican_mkek",
+				"mkek_length = 32",
+				"hmac_label = barbican_hmac",
+			},
+			omit: []string{"slot_id", "vault_plugin"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]any{"SecretStore": tt.store, "KeystoneListener": true}
+			for k, v := range tt.data {
+				data[k] = v
+			}
+			rendered, err := common.RenderTemplate("barbican/barbican.conf", data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(rendered, "\n")
+			for _, want := range tt.want {
+				if !containsLine(lines, want) {
+					t.Errorf("missing %q in:\n%s", want, rendered)
+				}
+			}
+			for _, omit := range tt.omit {
+				if strings.Contains(rendered, omit) {
+					t.Errorf("unexpected %q in:\n%s", omit, rendered)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,602 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	// cinderBackendLabel marks a cinder-volume Deployment with its backend.
+	cinderBackendLabel = "openstack.k8s.io/cinder-backend"
+	// cinderCephUser is the Ceph user for RBD backends.
+	cinderCephUser = "cinder"
+	// cinderBackendInterval is how often backend health is polled once all
+	// backends are up.
+	cinderBackendInterval = 5 * time.Minute
+)
+
+// errCinderBackendInvalid marks a backend that cannot be deployed until the
+// namespace changes.
+var errCinderBackendInvalid = errors.New("invalid backend")
+
+// cinderOptionName matches the option names a custom backend may set.
+var cinderOptionName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// cinderReservedOptions are set by the operator in every backend section.
+var cinderReservedOptions = []string{"volume_driver", "volume_backend_name", "backend_host"}
+
+// cinderBackendConfig is the template data for one backend's cinder-volume.
+type cinderBackendConfig struct {
+	Name string
+	Type string
+	// Host is the backend_host, so the service is <Host>@<Name> whichever
+	// pod runs it.
+	Host           string
+	CephPool       string
+	Ceph           *cinderCephConfig
+	LVMVolumeGroup string
+	// TargetHelper is the LVM iSCSI target: lioadm or tgtadm.
+	TargetHelper    string
+	NFSMountOptions string
+	// NFSShares is the nfs_shares_config file content.
+	NFSShares string
+	// Driver and Options are a custom backend's driver class and section
+	// options, including those from its credentials Secret.
+	Driver  string
+	Options map[string]string
+
+	spec openstackv1alpha1.CinderBackend
+}
+
+// cinderCephConfig is the Ceph client shared by all RBD backends.
+type cinderCephConfig struct {
+	User string
+	// SecretUUID is the libvirt secret computes hold the key in.
+	SecretUUID string
+	// Config and Keyring are the ceph.conf and keyring files.
+	Config  string
+	Keyring string
+}
+
+// validateCinderBackends checks that backend names are unique, that each
+// backend and the backup target have the settings of their type and that
+// the default backend exists.
+func validateCinderBackends(instance *openstackv1alpha1.Cinder) error {
+	names := map[string]bool{}
+	for _, backend := range instance.Spec.Backends {
+		if names[backend.Name] {
+			return fmt.Errorf("backend %s is defined more than once", backend.Name)
+		}
+		names[backend.Name] = true
+		switch {
+		case backend.Type == "lvm" && backend.LVMVolumeGroup == "":
+			return fmt.Errorf("backend %s: lvmVolumeGroup is required for type lvm", backend.Name)
+		case backend.Type == "nfs" && backend.NFS == nil:
+			return fmt.Errorf("backend %s: nfs is required for type nfs", backend.Name)
+		case backend.Type == "custom" && backend.Custom == nil:
+			return fmt.Errorf("backend %s: custom is required for type custom", backend.Name)
+		}
+		if backend.Custom != nil {
+			if err := checkCinderOptions(backend.Custom.Options); err != nil {
+				return fmt.Errorf("backend %s: custom.options: %w", backend.Name, err)
+			}
+		}
+	}
+	if def := instance.Spec.DefaultBackend; def != "" && !names[def] {
+		return fmt.Errorf("defaultBackend %s does not name a backend", def)
+	}
+	if backup := instance.Spec.Backup; backup != nil {
+		switch {
+		case backup.Driver == "nfs" && backup.NFS == nil:
+			return errors.New("backup: nfs is required for driver nfs")
+		case backup.Driver == "s3" && backup.S3 == nil:
+			return errors.New("backup: s3 is required for driver s3")
+		}
+	}
+	return nil
+}
+
+// checkCinderOptions returns an error unless every option renders as a
+// single line of the backend section: names must not hold anything but
+// letters, digits, _, . and -, nor be set by the operator, and values must
+// not hold line breaks or brackets, which could start another section.
+// Values are left out of the error, as they may be credentials.
+func checkCinderOptions(options map[string]string) error {
+	keys := make([]string, 0, len(options))
+	for k := range options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch {
+		case !cinderOptionName.MatchString(k):
+			return fmt.Errorf("option name %q may only hold letters, digits, _, . and -", k)
+		case slices.Contains(cinderReservedOptions, k):
+			return fmt.Errorf("option %s is set by the operator", k)
+		case strings.ContainsAny(options[k], "\r\n[]"):
+			return fmt.Errorf("the value of option %s must not hold line breaks, [ or ]", k)
+		}
+	}
+	return nil
+}
+
+// cinderDefaultBackend returns the backend whose volume type is the default.
+func cinderDefaultBackend(instance *openstackv1alpha1.Cinder) string {
+	if instance.Spec.DefaultBackend != "" {
+		return instance.Spec.DefaultBackend
+	}
+	if len(instance.Spec.Backends) > 0 {
+		return instance.Spec.Backends[0].Name
+	}
+	return ""
+}
+
+// resolveBackends returns the config of every backend that can be deployed,
+// and why each other backend cannot.
+func (r *CinderReconciler) resolveBackends(ctx context.Context, instance *openstackv1alpha1.Cinder) ([]*cinderBackendConfig, map[string]string, error) {
+	failed := map[string]string{}
+	var configs, cephBackends []*cinderBackendConfig
+	var ceph *openstackv1alpha1.CephStorage
+	var cephErr error
+	for _, backend := range instance.Spec.Backends {
+		cfg := &cinderBackendConfig{
+			Name:           backend.Name,
+			Type:           backend.Type,
+			Host:           instance.Name,
+			LVMVolumeGroup: backend.LVMVolumeGroup,
+			spec:           backend,
+		}
+		switch backend.Type {
+		case "ceph":
+			if ceph == nil && cephErr == nil {
+				ceph, cephErr = common.GetCephStorage(ctx, r.Client, instance.Namespace)
+				if cephErr != nil && !errors.Is(cephErr, common.ErrDependencyNotReady) {
+					return nil, nil, cephErr
+				}
+			}
+			if cephErr != nil {
+				failed[backend.Name] = cephErr.Error()
+				continue
+			}
+			cfg.CephPool = backend.CephPoolName
+			if cfg.CephPool == "" {
+				pool, err := common.CephPoolName(ceph, "cinder-volumes")
+				if err != nil {
+					failed[backend.Name] = err.Error()
+					continue
+				}
+				cfg.CephPool = pool
+			}
+			cephBackends = append(cephBackends, cfg)
+
+		case "lvm":
+			cfg.TargetHelper = backend.LVMTargetHelper
+			if cfg.TargetHelper == "" {
+				cfg.TargetHelper = "lioadm"
+			}
+
+		case "nfs":
+			secret, err := r.backendSecret(ctx, instance, backend.NFS.SharesSecretName)
+			if err != nil {
+				return nil, nil, err
+			}
+			if secret == nil || len(secret.Data["shares"]) == 0 {
+				failed[backend.Name] = fmt.Sprintf("Secret %s with a shares key not found", backend.NFS.SharesSecretName)
+				continue
+			}
+			cfg.NFSShares = string(secret.Data["shares"])
+			cfg.NFSMountOptions = backend.NFS.MountOptions
+
+		case "custom":
+			cfg.Driver = backend.Custom.Driver
+			cfg.Options = map[string]string{}
+			for k, v := range backend.Custom.Options {
+				cfg.Options[k] = v
+			}
+			if name := backend.Custom.CredentialsSecretName; name != "" {
+				secret, err := r.backendSecret(ctx, instance, name)
+				if err != nil {
+					return nil, nil, err
+				}
+				if secret == nil {
+					failed[backend.Name] = fmt.Sprintf("credentials Secret %s not found", name)
+					continue
+				}
+				credentials := map[string]string{}
+				for k, v := range secret.Data {
+					credentials[k] = strings.TrimSpace(string(v))
+				}
+				if err := checkCinderOptions(credentials); err != nil {
+					failed[backend.Name] = fmt.Sprintf("credentials Secret %s: %v", name, err)
+					continue
+				}
+				for k, v := range credentials {
+					cfg.Options[k] = v
+				}
+			}
+		}
+		configs = append(configs, cfg)
+	}
+
+	if len(cephBackends) > 0 {
+		cephConfig, err := r.ensureCeph(ctx, instance, ceph, cephBackends)
+		if err != nil && !errors.Is(err, common.ErrDependencyNotReady) && !errors.Is(err, errCinderBackendInvalid) {
+			return nil, nil, err
+		}
+		ready := configs[:0]
+		for _, cfg := range configs {
+			if cfg.Type == "ceph" {
+				if err != nil {
+					failed[cfg.Name] = err.Error()
+					continue
+				}
+				cfg.Ceph = cephConfig
+			}
+			ready = append(ready, cfg)
+		}
+		configs = ready
+	}
+	return configs, failed, nil
+}
+
+// backendSecret returns a Secret a backend references, or nil if it does
+// not exist.
+func (r *CinderReconciler) backendSecret(ctx context.Context, instance *openstackv1alpha1.Cinder, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: instance.Namespace}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+// ensureCeph creates the client.cinder Ceph user with access to every RBD
+// backend's pool and read access to Glance's, so volumes can be cloned from
+// images. The libvirt secret UUID is Nova's, as computes define the secret
+// from Nova's Ceph Secret; it returns ErrDependencyNotReady until Nova has
+// created it, and fails while the namespace has more than one Nova, as
+// their computes would hold different UUIDs.
+func (r *CinderReconciler) ensureCeph(ctx context.Context, instance *openstackv1alpha1.Cinder, ceph *openstackv1alpha1.CephStorage, backends []*cinderBackendConfig) (*cinderCephConfig, error) {
+	var pools []string
+	for _, backend := range backends {
+		pools = append(pools, backend.CephPool)
+	}
+	imagePool, _ := common.CephPoolName(ceph, "glance-images")
+	cephClient, err := common.EnsureCephClient(ctx, r.Client, ceph, cinderCephUser, common.CephRBDCaps(pools, []string{imagePool}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Without the UUID computes could not open the volumes they attach, so
+	// the backends wait until Nova has created its Ceph Secret.
+	novas := &openstackv1alpha1.NovaList{}
+	if err := r.List(ctx, novas, client.InNamespace(instance.Namespace)); err != nil {
+		return nil, err
+	}
+	if len(novas.Items) > 1 {
+		return nil, fmt.Errorf("%w: ceph backends need a single Nova in the namespace, found %d", errCinderBackendInvalid, len(novas.Items))
+	}
+	if len(novas.Items) == 0 || novas.Items[0].Status.CephSecretName == "" {
+		return nil, fmt.Errorf("Nova Ceph Secret: %w", common.ErrDependencyNotReady)
+	}
+	secretUUID, err := common.GetSecretValue(ctx, r.Client, novas.Items[0].Status.CephSecretName, instance.Namespace, "uuid")
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-ceph", Namespace: instance.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labelsForCinder(instance.Name, "ceph")
+		secret.Data = map[string][]byte{
+			"ceph.conf": []byte(cephClient.Config),
+			"ceph.client." + cinderCephUser + ".keyring": []byte(cephClient.Keyring()),
+		}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return nil, err
+	}
+	return &cinderCephConfig{
+		User:       cinderCephUser,
+		SecretUUID: secretUUID,
+		Config:     cephClient.Config,
+		Keyring:    cephClient.Keyring(),
+	}, nil
+}
+
+// reconcileBackends runs a cinder-volume Deployment per resolved backend,
+// removes those of backends dropped from the spec, ensures each backend's
+// volume type and records backend health. It returns the backends that are
+// not ready.
+func (r *CinderReconciler) reconcileBackends(ctx context.Context, instance *openstackv1alpha1.Cinder, conns *common.Connections, backends []*cinderBackendConfig, failed map[string]string, configHashes map[string]string) ([]string, error) {
+	for _, backend := range backends {
+		if err := r.ensureVolumeDeployment(ctx, instance, backend, configHashes[backend.Name]); err != nil {
+			return nil, err
+		}
+	}
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments, client.InNamespace(instance.Namespace), client.MatchingLabels{
+		"app.kubernetes.io/name":     "cinder",
+		"app.kubernetes.io/instance": instance.Name,
+	}, client.HasLabels{cinderBackendLabel}); err != nil {
+		return nil, err
+	}
+	wanted := map[string]bool{}
+	for _, backend := range instance.Spec.Backends {
+		wanted[backend.Name] = true
+	}
+	deployReady := map[string]bool{}
+	for i := range deployments.Items {
+		deploy := &deployments.Items[i]
+		name := deploy.Labels[cinderBackendLabel]
+		if !wanted[name] {
+			if err := r.Delete(ctx, deploy); client.IgnoreNotFound(err) != nil {
+				return nil, err
+			}
+			continue
+		}
+		deployReady[name] = common.IsDeploymentReady(deploy)
+	}
+
+	osc, err := common.NewOpenStackClient(ctx, r.Client, instance.Namespace, conns)
+	if err != nil {
+		return nil, err
+	}
+	var services struct {
+		Services []struct {
+			Host  string `json:"host"`
+			State string `json:"state"`
+		} `json:"services"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", "/os-services?binary=cinder-volume", nil, &services); err != nil {
+		return nil, err
+	}
+	states := map[string]string{}
+	for _, svc := range services.Services {
+		states[svc.Host] = svc.State
+	}
+
+	var notReady []string
+	statuses := make([]openstackv1alpha1.CinderBackendStatus, 0, len(instance.Spec.Backends))
+	for _, backend := range instance.Spec.Backends {
+		typeID, err := ensureVolumeType(ctx, osc, common.OwnerMark(instance), backend.Name)
+		var unmanaged *cinderNotManagedError
+		if err != nil && !errors.As(err, &unmanaged) {
+			return nil, err
+		}
+		status := openstackv1alpha1.CinderBackendStatus{
+			Name:         backend.Name,
+			ServiceState: states[instance.Name+"@"+backend.Name],
+			VolumeTypeID: typeID,
+		}
+		switch {
+		case failed[backend.Name] != "":
+			status.Message = failed[backend.Name]
+		case unmanaged != nil:
+			status.Message = unmanaged.Error() + " and does not route to the backend"
+		case !deployReady[backend.Name]:
+			status.Message = "cinder-volume pod is not available"
+		case status.ServiceState != "up":
+			status.Message = "cinder-volume service is not up"
+		default:
+			status.Ready = true
+		}
+		if !status.Ready {
+			notReady = append(notReady, backend.Name)
+		}
+		statuses = append(statuses, status)
+	}
+	instance.Status.Backends = statuses
+	return notReady, nil
+}
+
+// ensureVolumeDeployment runs cinder-volume for one backend. Only one pod
+// may run a backend at a time, as cinder-volume is active/passive without a
+// coordination backend, so the Deployment is recreated rather than rolled.
+func (r *CinderReconciler) ensureVolumeDeployment(ctx context.Context, instance *openstackv1alpha1.Cinder, backend *cinderBackendConfig, configHash string) error {
+	volumes := []corev1.Volume{cinderConfigVolume(instance)}
+	mounts := []corev1.VolumeMount{
+		cinderConfigVolumeMount(),
+		{Name: "var-lib-cinder", MountPath: "/var/lib/cinder"},
+	}
+	env := []corev1.EnvVar{
+		{Name: "HOST_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"}}},
+	}
+	image := images.ImageOrDefault(instance.Spec.Image, images.DefaultCinderVolume)
+	privileged := true
+	rootUser := int64(0)
+	privilegedContext := &corev1.SecurityContext{Privileged: &privileged, RunAsUser: &rootUser}
+	var securityContext *corev1.SecurityContext
+	var sidecars []corev1.Container
+	hostNetwork := false
+
+	switch backend.Type {
+	case "ceph":
+		volumes = append(volumes,
+			emptyDirVolume("var-lib-cinder"),
+			corev1.Volume{Name: "ceph", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: instance.Name + "-ceph"}}},
+		)
+		mounts = append(mounts, corev1.VolumeMount{Name: "ceph", MountPath: "/etc/ceph", ReadOnly: true})
+
+	case "lvm":
+		// The volume group and the LIO target live in the node's kernel;
+		// tgtd is a user-space daemon and must listen on the node address.
+		volumes = append(volumes,
+			hostPathVolume("var-lib-cinder", "/var/lib/cinder"),
+			hostPathVolume("dev", "/dev"),
+			hostPathVolume("lib-modules", "/lib/modules"),
+			hostPathVolume("run-lvm", "/run/lvm"),
+		)
+		mounts = append(mounts,
+			corev1.VolumeMount{Name: "dev", MountPath: "/dev"},
+			corev1.VolumeMount{Name: "lib-modules", MountPath: "/lib/modules", ReadOnly: true},
+			corev1.VolumeMount{Name: "run-lvm", MountPath: "/run/lvm"},
+		)
+		securityContext = privilegedContext
+		if backend.TargetHelper == "tgtadm" {
+			volumes = append(volumes, emptyDirVolume("run-tgtd"))
+			mounts = append(mounts, corev1.VolumeMount{Name: "run-tgtd", MountPath: "/var/run/tgtd"})
+			sidecars = append(sidecars, corev1.Container{
+				Name:    "tgtd",
+				Image:   images.DefaultTGTD,
+				Command: []string{"tgtd", "-f"},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "var-lib-cinder", MountPath: "/var/lib/cinder"},
+					{Name: "dev", MountPath: "/dev"},
+					{Name: "run-tgtd", MountPath: "/var/run/tgtd"},
+				},
+				SecurityContext: privilegedContext,
+			})
+			hostNetwork = true
+		} else {
+			volumes = append(volumes,
+				hostPathVolume("etc-target", "/etc/target"),
+				hostPathVolume("sys-kernel-config", "/sys/kernel/config"),
+			)
+			mounts = append(mounts,
+				corev1.VolumeMount{Name: "etc-target", MountPath: "/etc/target"},
+				corev1.VolumeMount{Name: "sys-kernel-config", MountPath: "/sys/kernel/config"},
+			)
+		}
+
+	case "nfs":
+		// Shares are mounted inside the pod, which needs CAP_SYS_ADMIN.
+		volumes = append(volumes, emptyDirVolume("var-lib-cinder"))
+		securityContext = privilegedContext
+
+	case "custom":
+		volumes = append(volumes, emptyDirVolume("var-lib-cinder"))
+		image = images.ImageOrDefault(backend.spec.Custom.Image, image)
+		if backend.spec.Custom.Privileged {
+			// os-brick attaches volumes through the host's initiator.
+			volumes = append(volumes,
+				hostPathVolume("dev", "/dev"),
+				hostPathVolume("lib-modules", "/lib/modules"),
+				hostPathVolume("etc-iscsi", "/etc/iscsi"),
+				hostPathVolume("run-udev", "/run/udev"),
+			)
+			mounts = append(mounts,
+				corev1.VolumeMount{Name: "dev", MountPath: "/dev"},
+				corev1.VolumeMount{Name: "lib-modules", MountPath: "/lib/modules", ReadOnly: true},
+				corev1.VolumeMount{Name: "etc-iscsi", MountPath: "/etc/iscsi"},
+				corev1.VolumeMount{Name: "run-udev", MountPath: "/run/udev"},
+			)
+			securityContext = privilegedContext
+			hostNetwork = true
+		}
+	}
+
+	nodeSelector := instance.Spec.NodeSelector
+	if len(backend.spec.NodeSelector) > 0 {
+		nodeSelector = backend.spec.NodeSelector
+	}
+	containers := append([]corev1.Container{{
+		Name:            "cinder-volume",
+		Image:           image,
+		Command:         []string{"/bin/sh", "/etc/cinder/cinder-volume-" + backend.Name + ".sh"},
+		Env:             env,
+		Resources:       instance.Spec.Resources,
+		VolumeMounts:    mounts,
+		SecurityContext: securityContext,
+	}}, sidecars...)
+
+	replicas := int32(1)
+	labels := labelsForCinder(instance.Name, "volume-"+backend.Name)
+	labels[cinderBackendLabel] = backend.Name
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-volume-" + backend.Name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = nodeSelector
+		deploy.Spec.Template.Spec.HostNetwork = hostNetwork
+		if hostNetwork {
+			deploy.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		} else {
+			deploy.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+		}
+		deploy.Spec.Template.Spec.Containers = containers
+		deploy.Spec.Template.Spec.Volumes = volumes
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
+
+func emptyDirVolume(name string) corev1.Volume {
+	return corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+}
+
+// ensureVolumeType returns the ID of the public volume type named after a
+// backend, creating it with the owner mark of the Cinder. A type that does
+// not route to the backend is pointed back at it only if it carries that
+// mark; otherwise a cinderNotManagedError is returned and the type is left
+// alone.
+func ensureVolumeType(ctx context.Context, osc *common.OpenStackClient, owner, backend string) (string, error) {
+	var list struct {
+		VolumeTypes []struct {
+			ID         string            `json:"id"`
+			Name       string            `json:"name"`
+			ExtraSpecs map[string]string `json:"extra_specs"`
+		} `json:"volume_types"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", "/types?is_public=None", nil, &list); err != nil {
+		return "", err
+	}
+	for _, vt := range list.VolumeTypes {
+		if vt.Name != backend {
+			continue
+		}
+		if vt.ExtraSpecs["volume_backend_name"] != backend {
+			if mark := vt.ExtraSpecs[common.OwnerMarkKey]; mark != owner {
+				return "", &cinderNotManagedError{kind: "volume type", id: vt.ID, name: vt.Name, owner: mark}
+			}
+			body := map[string]any{"extra_specs": map[string]string{"volume_backend_name": backend}}
+			if err := osc.Do(ctx, http.MethodPost, "volumev3", "/types/"+vt.ID+"/extra_specs", body, nil); err != nil {
+				return "", err
+			}
+		}
+		return vt.ID, nil
+	}
+
+	var created struct {
+		VolumeType struct {
+			ID string `json:"id"`
+		} `json:"volume_type"`
+	}
+	body := map[string]any{"volume_type": map[string]any{
+		"name":                            backend,
+		"description":                     fmt.Sprintf("Volumes on the %s backend", backend),
+		"os-volume-type-access:is_public": true,
+		"extra_specs":                     map[string]string{"volume_backend_name": backend, common.OwnerMarkKey: owner},
+	}}
+	if err := osc.Do(ctx, http.MethodPost, "volumev3", "/types", body, &created); err != nil {
+		return "", err
+	}
+	return created.VolumeType.ID, nil
+}
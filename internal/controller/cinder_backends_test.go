@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestCheckCinderOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "valid", options: map[string]string{"san_ip": "192.0.2.10", "netapp.pool_name_search_pattern": "(pool1|pool2)"}},
+		{name: "newline in value", options: map[string]string{"san_login": "admin\n[DEFAULT]"}, wantErr: "san_login must not hold"},
+		{name: "carriage return in value", options: map[string]string{"san_login": "admin\rdebug = true"}, wantErr: "san_login must not hold"},
+		{name: "bracket in value", options: map[string]string{"san_password": "pa]ss"}, wantErr: "san_password must not hold"},
+		{name: "section in name", options: map[string]string{"[DEFAULT]\ndebug": "true"}, wantErr: "may only hold"},
+		{name: "assignment in name", options: map[string]string{"debug = true\nsan_ip": "x"}, wantErr: "may only hold"},
+		{name: "reserved name", options: map[string]string{"volume_driver": "cinder.volume.drivers.lvm.LVMVolumeDriver"}, wantErr: "set by the operator"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCinderOptions(tt.options)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			for _, v := range tt.options {
+				if strings.Contains(err.Error(), v) {
+					t.Errorf("error %q reveals a value", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCinderBackendsOptions(t *testing.T) {
+	instance := &openstackv1alpha1.Cinder{}
+	instance.Spec.Backends = []openstackv1alpha1.CinderBackend{{
+		Name: "netapp", Type: "custom",
+		Custom: &openstackv1alpha1.CinderCustomBackend{
+			Driver:  "cinder.volume.drivers.netapp.common.NetAppDriver",
+			Options: map[string]string{"netapp_server_hostname": "netapp.example.com\n[DEFAULT]"},
+		},
+	}}
+	err := validateCinderBackends(instance)
+	if err == nil || !strings.Contains(err.Error(), "backend netapp: custom.options") {
+		t.Fatalf("error = %v", err)
+	}
+}
+
+func TestResolveBackendsCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials map[string][]byte
+		wantFailed  string
+	}{
+		{
+			name: "valid",
+			// Values are trimmed, so a trailing newline is fine.
+			credentials: map[string][]byte{"san_login": []byte("admin\n"), "san_password": []byte("secret")},
+		},
+		{name: "line break", credentials: map[string][]byte{"san_password": []byte("secret\n[DEFAULT]\ndebug = true")}, wantFailed: "credentials Secret netapp-credentials: the value of option san_password"},
+		{name: "reserved name", credentials: map[string][]byte{"volume_driver": []byte("x")}, wantFailed: "volume_driver is set by the operator"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &openstackv1alpha1.Cinder{ObjectMeta: metav1.ObjectMeta{Name: "cinder", Namespace: "openstack"}}
+			instance.Spec.Backends = []openstackv1alpha1.CinderBackend{{
+				Name: "netapp", Type: "custom",
+				Custom: &openstackv1alpha1.CinderCustomBackend{
+					Driver:                "cinder.volume.drivers.netapp.common.NetAppDriver",
+					Options:               map[string]string{"netapp_storage_family": "ontap_cluster"},
+					CredentialsSecretName: "netapp-credentials",
+				},
+			}}
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "netapp-credentials", Namespace: "openstack"},
+				Data:       tt.credentials,
+			}
+			r := &CinderReconciler{Client: newFakeClient(t, secret), Scheme: testScheme}
+
+			configs, failed, err := r.resolveBackends(context.Background(), instance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantFailed != "" {
+				if len(configs) != 0 || !strings.Contains(failed["netapp"], tt.wantFailed) {
+					t.Fatalf("configs %v, failed %v, want failure %q", configs, failed, tt.wantFailed)
+				}
+				return
+			}
+			if len(configs) != 1 || len(failed) != 0 {
+				t.Fatalf("configs %v, failed %v", configs, failed)
+			}
+			want := map[string]string{"netapp_storage_family": "ontap_cluster", "san_login": "admin", "san_password": "secret"}
+			for k, v := range want {
+				if configs[0].Options[k] != v {
+					t.Errorf("option %s = %q, want %q", k, configs[0].Options[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestEnsureVolumeType(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    map[string]string
+		wantID      string
+		wantErr     bool
+		wantCreated bool
+		wantRouted  bool
+	}{
+		{name: "created", wantID: "vt-2", wantCreated: true},
+		{name: "routes to the backend", existing: map[string]string{"volume_backend_name": "rbd", common.OwnerMarkKey: "openstack/cinder"}, wantID: "vt-1"},
+		// Held by an OpenStackVolumeType, which keeps it on the backend.
+		{name: "lent to a volume type", existing: map[string]string{"volume_backend_name": "rbd", common.OwnerMarkKey: "openstack/gold"}, wantID: "vt-1"},
+		{name: "rerouted outside", existing: map[string]string{"volume_backend_name": "lvm", common.OwnerMarkKey: "openstack/cinder"}, wantID: "vt-1", wantRouted: true},
+		{name: "created by hand", existing: map[string]string{"volume_backend_name": "lvm"}, wantErr: true},
+		{name: "marked by another Cinder", existing: map[string]string{common.OwnerMarkKey: "other/cinder"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var types []map[string]any
+			if tt.existing != nil {
+				types = append(types, map[string]any{"id": "vt-1", "name": "rbd", "extra_specs": tt.existing})
+			}
+			osc, fos := newFakeOpenStack(t, map[string]fakeResponse{
+				"GET /types?is_public=None":    {body: map[string]any{"volume_types": types}},
+				"POST /types":                  {body: map[string]any{"volume_type": map[string]string{"id": "vt-2"}}},
+				"POST /types/vt-1/extra_specs": {},
+			})
+
+			id, err := ensureVolumeType(context.Background(), osc, "openstack/cinder", "rbd")
+			if tt.wantErr {
+				var unmanaged *cinderNotManagedError
+				if !errors.As(err, &unmanaged) {
+					t.Fatalf("error = %v, want cinderNotManagedError", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if id != tt.wantID {
+				t.Errorf("ID = %s, want %s", id, tt.wantID)
+			}
+			if got := fos.sent("POST /types"); got != tt.wantCreated {
+				t.Errorf("created = %v, want %v", got, tt.wantCreated)
+			}
+			if tt.wantCreated {
+				specs := fos.body("POST /types")["volume_type"].(map[string]any)["extra_specs"].(map[string]any)
+				if specs["volume_backend_name"] != "rbd" || specs[common.OwnerMarkKey] != "openstack/cinder" {
+					t.Errorf("extra specs = %v", specs)
+				}
+			}
+			if got := fos.sent("POST /types/vt-1/extra_specs"); got != tt.wantRouted {
+				t.Errorf("rerouted = %v, want %v", got, tt.wantRouted)
+			}
+		})
+	}
+}
+
+func TestCinderReconcileBackendsUnmanagedType(t *testing.T) {
+	_, fos := newFakeOpenStack(t, map[string]fakeResponse{
+		"GET /os-services?binary=cinder-volume": {body: map[string]any{"services": []any{}}},
+		"GET /types?is_public=None": {body: map[string]any{"volume_types": []map[string]any{
+			{"id": "vt-1", "name": "rbd", "extra_specs": map[string]string{"volume_backend_name": "tier2"}},
+		}}},
+	})
+	c := newFakeClient(t, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keystone", Namespace: "openstack"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+	r := &CinderReconciler{Client: c, Scheme: testScheme}
+	instance := &openstackv1alpha1.Cinder{ObjectMeta: metav1.ObjectMeta{Name: "cinder", Namespace: "openstack"}}
+	instance.Spec.Backends = []openstackv1alpha1.CinderBackend{{Name: "rbd", Type: "ceph"}}
+	conns := &common.Connections{Region: "RegionOne", KeystoneURL: fos.url, KeystoneSecret: "keystone"}
+
+	notReady, err := r.reconcileBackends(context.Background(), instance, conns, nil, map[string]string{}, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notReady) != 1 || notReady[0] != "rbd" {
+		t.Errorf("not ready = %v, want rbd", notReady)
+	}
+	if status := instance.Status.Backends[0]; status.Ready || !strings.Contains(status.Message, "not managed by the operator") {
+		t.Errorf("backend status = %+v, want the type reported", status)
+	}
+	if fos.sent("POST /types/vt-1/extra_specs") {
+		t.Error("volume type created by hand was rerouted")
+	}
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+// cinderBackupCephUser is the Ceph user for the ceph backup driver.
+const cinderBackupCephUser = "cinder-backup"
+
+// cinderBackupConfig is the template data for cinder-backup.conf.
+type cinderBackupConfig struct {
+	Driver          string
+	CephPool        string
+	CephUser        string
+	SwiftContainer  string
+	NFSShare        string
+	NFSMountOptions string
+	S3EndpointURL   string
+	S3Bucket        string
+	S3AccessKey     string
+	S3SecretKey     string
+	S3VerifySSL     bool
+	S3CACert        bool
+}
+
+// ensureBackup runs cinder-backup against the configured target, or removes
+// it when backups are disabled. It returns why cinder-backup is not running
+// yet, or "" once it is.
+func (r *CinderReconciler) ensureBackup(ctx context.Context, instance *openstackv1alpha1.Cinder, backends []*cinderBackendConfig, apiConfigHash string) (string, error) {
+	name := instance.Name + "-backup"
+	spec := instance.Spec.Backup
+	if spec == nil {
+		deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
+		return "", client.IgnoreNotFound(r.Delete(ctx, deploy))
+	}
+
+	cfg := &cinderBackupConfig{Driver: spec.Driver}
+	files := map[string]string{}
+	switch spec.Driver {
+	case "ceph":
+		ceph, err := common.GetCephStorage(ctx, r.Client, instance.Namespace)
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return err.Error(), nil
+		} else if err != nil {
+			return "", err
+		}
+		if spec.Ceph != nil && spec.Ceph.PoolName != "" {
+			cfg.CephPool = spec.Ceph.PoolName
+		} else if cfg.CephPool, err = common.EnsureCephPool(ctx, r.Client, ceph, "cinder-backups"); err != nil {
+			if errors.Is(err, common.ErrDependencyNotReady) || errors.Is(err, common.ErrCephPoolMissing) {
+				return err.Error(), nil
+			}
+			return "", err
+		}
+		cephClient, err := common.EnsureCephClient(ctx, r.Client, ceph, cinderBackupCephUser, common.CephRBDCaps([]string{cfg.CephPool}, nil))
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return err.Error(), nil
+		} else if err != nil {
+			return "", err
+		}
+		cfg.CephUser = cinderBackupCephUser
+		files["ceph.conf"] = cephClient.Config
+		files["ceph.client."+cinderBackupCephUser+".keyring"] = cephClient.Keyring()
+
+	case "swift":
+		cfg.SwiftContainer = "volumebackups"
+		if spec.Swift != nil && spec.Swift.Container != "" {
+			cfg.SwiftContainer = spec.Swift.Container
+		}
+
+	case "nfs":
+		cfg.NFSShare = spec.NFS.Share
+		cfg.NFSMountOptions = spec.NFS.MountOptions
+
+	case "s3":
+		s3 := spec.S3
+		cfg.S3EndpointURL = s3.EndpointURL
+		cfg.S3Bucket = s3.Bucket
+		if cfg.S3Bucket == "" {
+			cfg.S3Bucket = "volumebackups"
+		}
+		cfg.S3VerifySSL = !s3.InsecureSkipVerify
+		credentials, err := r.backendSecret(ctx, instance, s3.CredentialsSecretName)
+		if err != nil {
+			return "", err
+		}
+		if credentials == nil || len(credentials.Data["accessKey"]) == 0 || len(credentials.Data["secretKey"]) == 0 {
+			return fmt.Sprintf("Secret %s with accessKey and secretKey not found", s3.CredentialsSecretName), nil
+		}
+		cfg.S3AccessKey = strings.TrimSpace(string(credentials.Data["accessKey"]))
+		cfg.S3SecretKey = strings.TrimSpace(string(credentials.Data["secretKey"]))
+		if s3.CASecretName != "" {
+			ca, err := r.backendSecret(ctx, instance, s3.CASecretName)
+			if err != nil {
+				return "", err
+			}
+			if ca == nil || len(ca.Data["ca.crt"]) == 0 {
+				return fmt.Sprintf("Secret %s with ca.crt not found", s3.CASecretName), nil
+			}
+			files["s3-ca.crt"] = string(ca.Data["ca.crt"])
+			cfg.S3CACert = true
+		}
+	}
+
+	// RBD volumes are read through os-brick with the volume backends' user,
+	// whatever the backup target.
+	for _, backend := range backends {
+		if backend.Ceph != nil {
+			if _, ok := files["ceph.conf"]; !ok {
+				files["ceph.conf"] = backend.Ceph.Config
+			}
+			files["ceph.client."+backend.Ceph.User+".keyring"] = backend.Ceph.Keyring
+			break
+		}
+	}
+	_, ceph := files["ceph.conf"]
+
+	rendered, err := common.RenderTemplate("cinder/cinder-backup.conf", cfg)
+	if err != nil {
+		return "", err
+	}
+	files["cinder-backup.conf"] = rendered
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name + "-config", Namespace: instance.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labelsForCinder(instance.Name, "backup-config")
+		secret.Data = make(map[string][]byte, len(files))
+		for k, v := range files {
+			secret.Data[k] = []byte(v)
+		}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return "", err
+	}
+	files["cinder.conf"] = apiConfigHash
+
+	if err := r.ensureBackupDeployment(ctx, instance, name, secret.Name, ceph, common.ConfigHash(files)); err != nil {
+		return "", err
+	}
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: instance.Namespace}, deploy); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "Waiting for cinder-backup", nil
+		}
+		return "", err
+	}
+	if !common.IsDeploymentReady(deploy) {
+		return "Waiting for cinder-backup pods", nil
+	}
+	return "", nil
+}
+
+// ensureBackupDeployment runs cinder-backup with its config Secret at
+// /etc/cinder-backup, which also serves as /etc/ceph when it holds a
+// ceph.conf.
+func (r *CinderReconciler) ensureBackupDeployment(ctx context.Context, instance *openstackv1alpha1.Cinder, name, configSecret string, ceph bool, configHash string) error {
+	spec := instance.Spec.Backup
+	replicas := int32(1)
+	if spec.Replicas != nil {
+		replicas = *spec.Replicas
+	}
+	volumes := []corev1.Volume{
+		cinderConfigVolume(instance),
+		{Name: "backup-config", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: configSecret}}},
+		emptyDirVolume("var-lib-cinder"),
+	}
+	mounts := []corev1.VolumeMount{
+		cinderConfigVolumeMount(),
+		{Name: "backup-config", MountPath: "/etc/cinder-backup", ReadOnly: true},
+		{Name: "var-lib-cinder", MountPath: "/var/lib/cinder"},
+	}
+	if ceph {
+		mounts = append(mounts, corev1.VolumeMount{Name: "backup-config", MountPath: "/etc/ceph", ReadOnly: true})
+	}
+	var securityContext *corev1.SecurityContext
+	if spec.Driver == "nfs" {
+		// The share is mounted inside the pod.
+		privileged := true
+		rootUser := int64(0)
+		securityContext = &corev1.SecurityContext{Privileged: &privileged, RunAsUser: &rootUser}
+	}
+
+	labels := labelsForCinder(instance.Name, "backup")
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{{
+			Name:  "cinder-backup",
+			Image: images.ImageOrDefault(instance.Spec.Image, images.DefaultCinderBackup),
+			Command: []string{
+				"cinder-backup",
+				"--config-file", "/etc/cinder/cinder.conf",
+				"--config-file", "/etc/cinder-backup/cinder-backup.conf",
+			},
+			Resources:       instance.Spec.Resources,
+			VolumeMounts:    mounts,
+			SecurityContext: securityContext,
+		}}
+		deploy.Spec.Template.Spec.Volumes = volumes
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
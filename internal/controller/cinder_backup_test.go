@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestValidateCinderBackup(t *testing.T) {
+	tests := []struct {
+		name    string
+		backup  *openstackv1alpha1.CinderBackupSpec
+		wantErr string
+	}{
+		{name: "disabled"},
+		{name: "ceph", backup: &openstackv1alpha1.CinderBackupSpec{Driver: "ceph"}},
+		{
+			name: "s3",
+			backup: &openstackv1alpha1.CinderBackupSpec{Driver: "s3", S3: &openstackv1alpha1.CinderBackupS3{
+				EndpointURL: "http://minio.openstack.svc:9000", CredentialsSecretName: "cinder-backup-s3",
+			}},
+		},
+		{name: "s3 without settings", backup: &openstackv1alpha1.CinderBackupSpec{Driver: "s3"}, wantErr: "s3 is required"},
+		{name: "nfs without settings", backup: &openstackv1alpha1.CinderBackupSpec{Driver: "nfs"}, wantErr: "nfs is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &openstackv1alpha1.Cinder{}
+			instance.Spec.Backends = []openstackv1alpha1.CinderBackend{{Name: "lvm-default", Type: "lvm", LVMVolumeGroup: "cinder-volumes"}}
+			instance.Spec.Backup = tt.backup
+			err := validateCinderBackends(instance)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestCinderBackupS3Config checks the s3 driver options for the MinIO of
+// config/samples/cinder_backup_minio.yaml and for an https endpoint with a
+// private CA.
+func TestCinderBackupS3Config(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  cinderBackupConfig
+		want []string
+		omit []string
+	}{
+		{
+			name: "minio",
+			cfg: cinderBackupConfig{
+				Driver: "s3", S3EndpointURL: "http://minio.openstack.svc:9000", S3Bucket: "volumebackups",
+				S3AccessKey: "cinder-backup", S3SecretKey: "cinder-backup-secret", S3VerifySSL: true,
+			},
+			want: []string{
+				"backup_driver = cinder.backup.drivers.s3.S3BackupDriver",
+				"backup_s3_endpoint_url = http://minio.openstack.svc:9000",
+				"backup_s3_store_bucket = volumebackups",
+				"backup_s3_store_access_key = cinder-backup",
+				"backup_s3_store_secret_key = cinder-backup-secret",
+			},
+			omit: []string{"backup_s3_ca_cert_file"},
+		},
+		{
+			name: "private CA",
+			cfg: cinderBackupConfig{
+				Driver: "s3", S3EndpointURL: "https://s3.example.com", S3Bucket: "backups",
+				S3AccessKey: "a", S3SecretKey: "s", S3VerifySSL: true, S3CACert: true,
+			},
+			want: []string{"backup_s3_verify_ssl = true", "backup_s3_ca_cert_file = /etc/cinder-backup/s3-ca.crt"},
+		},
+		{
+			name: "no verification",
+			cfg: cinderBackupConfig{
+				Driver: "s3", S3EndpointURL: "https://s3.example.com", S3Bucket: "backups", S3AccessKey: "a", S3SecretKey: "s",
+			},
+			want: []string{"backup_s3_verify_ssl = false"},
+			omit: []string{"backup_s3_ca_cert_file"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendered, err := common.RenderTemplate("cinder/cinder-backup.conf", tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(rendered, "\n")
+			for _, want := range tt.want {
+				if !containsLine(lines, want) {
+					t.Errorf("missing %q in:\n%s", want, rendered)
+				}
+			}
+			for _, omit := range tt.omit {
+				if strings.Contains(rendered, omit) {
+					t.Errorf("unexpected %q in:\n%s", omit, rendered)
+				}
+			}
+		})
+	}
+}
+
+func TestCinderEnsureBackupCephVolumes(t *testing.T) {
+	rbd := &cinderBackendConfig{Name: "rbd", Type: "ceph", Ceph: &cinderCephConfig{
+		User: "cinder", Config: "[global]\nmon_host = 10.0.0.1\n", Keyring: "[client.cinder]\nkey = AQ==\n",
+	}}
+	lvm := &cinderBackendConfig{Name: "lvm", Type: "lvm"}
+	tests := []struct {
+		name     string
+		backends []*cinderBackendConfig
+		wantCeph bool
+	}{
+		// The swift driver reads RBD volumes through os-brick too.
+		{name: "ceph volumes", backends: []*cinderBackendConfig{lvm, rbd}, wantCeph: true},
+		{name: "no ceph volumes", backends: []*cinderBackendConfig{lvm}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := &openstackv1alpha1.Cinder{ObjectMeta: metav1.ObjectMeta{Name: "cinder", Namespace: "openstack", UID: "cinder-uid"}}
+			instance.Spec.Backup = &openstackv1alpha1.CinderBackupSpec{Driver: "swift"}
+			c := newFakeClient(t)
+			r := &CinderReconciler{Client: c, Scheme: testScheme}
+
+			if _, err := r.ensureBackup(ctx, instance, tt.backends, "hash"); err != nil {
+				t.Fatal(err)
+			}
+			secret := &corev1.Secret{}
+			if err := c.Get(ctx, client.ObjectKey{Name: "cinder-backup-config", Namespace: "openstack"}, secret); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(secret.Data["cinder-backup.conf"]), "swift") {
+				t.Errorf("cinder-backup.conf =\n%s", secret.Data["cinder-backup.conf"])
+			}
+			if got := string(secret.Data["ceph.conf"]) == rbd.Ceph.Config; got != tt.wantCeph {
+				t.Errorf("ceph.conf = %q, want the volume backend's: %v", secret.Data["ceph.conf"], tt.wantCeph)
+			}
+			if got := string(secret.Data["ceph.client.cinder.keyring"]) == rbd.Ceph.Keyring; got != tt.wantCeph {
+				t.Errorf("cinder keyring = %q, want the volume backend's: %v", secret.Data["ceph.client.cinder.keyring"], tt.wantCeph)
+			}
+			deploy := &appsv1.Deployment{}
+			if err := c.Get(ctx, client.ObjectKey{Name: "cinder-backup", Namespace: "openstack"}, deploy); err != nil {
+				t.Fatal(err)
+			}
+			mounted := false
+			for _, mount := range deploy.Spec.Template.Spec.Containers[0].VolumeMounts {
+				mounted = mounted || mount.MountPath == "/etc/ceph"
+			}
+			if mounted != tt.wantCeph {
+				t.Errorf("/etc/ceph mounted = %v, want %v", mounted, tt.wantCeph)
+			}
+		})
+	}
+}
+
+func containsLine(lines []string, want string) bool {
+	for _, line := range lines {
+		if line == want {
+			return true
+		}
+	}
+	return false
+}
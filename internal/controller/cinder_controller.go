@@ -0,0 +1,499 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const cinderAPIPort = 8776
+
+// cinderComponent describes one Cinder control-plane Deployment.
+type cinderComponent struct {
+	name         string
+	defaultImage string
+	command      []string
+	// port is exposed through a Service named <cinder>-<name>; 0 means none.
+	port int32
+}
+
+// cinderComponents run once per Cinder; cinder-volume runs once per backend.
+var cinderComponents = []cinderComponent{
+	{name: "api", defaultImage: images.DefaultCinderAPI, command: []string{"cinder-api", "--config-file", "/etc/cinder/cinder.conf"}, port: cinderAPIPort},
+	{name: "scheduler", defaultImage: images.DefaultCinderScheduler, command: []string{"cinder-scheduler", "--config-file", "/etc/cinder/cinder.conf"}},
+}
+
+// CinderReconciler reconciles a Cinder object.
+type CinderReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=cinders,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=cinders/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=cinders/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=cephstorages;novas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ceph.rook.io,resources=cephclients;cephblockpools,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *CinderReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.Cinder{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "Reconciling", "Reconciliation in progress", instance.Generation,
+	)
+
+	// The CRD validates this too, but Cinder may also be embedded in an
+	// OpenStackControlPlane created before the rule existed.
+	if err := validateCinderBackends(instance); err != nil {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "InvalidBackends", err.Error(), instance.Generation,
+		)
+		return ctrl.Result{}, r.updateStatus(ctx, instance)
+	}
+
+	conns, err := common.ResolveConnections(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Ensure generated credentials
+	if err := common.EnsureSecret(ctx, r.Client, cinderDBSecretName(instance), instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := common.EnsureSecret(ctx, r.Client, cinderServiceSecretName(instance), instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Ensure database
+	if err := common.EnsureDatabase(ctx, r.Client, common.DatabaseParams{
+		Name:          instance.Name,
+		Namespace:     instance.Namespace,
+		DatabaseName:  "cinder",
+		Username:      "cinder",
+		SecretName:    cinderDBSecretName(instance),
+		MariaDBSecret: conns.MariaDBSecret,
+		MariaDBHost:   conns.MariaDBHost,
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "CreatingDatabase", "Waiting for database creation")
+	}
+
+	// Backends that cannot be configured yet are reported, not fatal: the
+	// rest of Cinder keeps running without them.
+	backends, failed, err := r.resolveBackends(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	configHashes, err := r.ensureConfig(ctx, instance, conns, backends, failed)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	params := common.DBSyncParams{
+		Name:         instance.Name,
+		Namespace:    instance.Namespace,
+		Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultCinderAPI),
+		Command:      []string{"cinder-manage", "--config-file", "/etc/cinder/cinder.conf", "db", "sync"},
+		SecretName:   cinderDBSecretName(instance),
+		Volumes:      []corev1.Volume{cinderConfigVolume(instance)},
+		VolumeMounts: []corev1.VolumeMount{cinderConfigVolumeMount()},
+	}
+	if err := common.EnsureDBSync(ctx, r.Client, params, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-sync", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "SyncingDatabase", "Waiting for database migration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDatabaseReady), metav1.ConditionTrue, "DatabaseSynced", "Database created and migrated", instance.Generation,
+	)
+
+	// Ensure control-plane services
+	for _, component := range cinderComponents {
+		name := instance.Name + "-" + component.name
+		labels := labelsForCinder(instance.Name, component.name)
+		if err := r.ensureDeployment(ctx, instance, name, labels, component, configHashes[""]); err != nil {
+			return ctrl.Result{}, err
+		}
+		if component.port != 0 {
+			if err := r.ensureService(ctx, instance, name, labels, component); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	// Volume URLs carry the project, as clients older than microversion
+	// 3.67 expect.
+	apiEndpoint := fmt.Sprintf("http://%s-api.%s.svc:%d/v3", instance.Name, instance.Namespace, cinderAPIPort)
+	catalogURL := apiEndpoint + "/%(project_id)s"
+	if err := common.EnsureKeystoneEndpoint(ctx, r.Client, common.EndpointParams{
+		Name:              instance.Name,
+		Namespace:         instance.Namespace,
+		ServiceName:       "cinderv3",
+		ServiceType:       "volumev3",
+		InternalURL:       catalogURL,
+		PublicURL:         catalogURL,
+		AdminURL:          catalogURL,
+		Region:            conns.Region,
+		KeystoneSecret:    conns.KeystoneSecret,
+		KeystoneURL:       conns.KeystoneURL,
+		BootstrapImage:    images.DefaultKeystone,
+		ServiceUser:       "cinder",
+		ServiceUserSecret: cinderServiceSecretName(instance),
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-endpoint-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "RegisteringEndpoint", "Waiting for Keystone endpoint registration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady), metav1.ConditionTrue, "EndpointRegistered", "Keystone service, user and endpoints registered", instance.Generation,
+	)
+	instance.Status.APIEndpoint = apiEndpoint
+
+	var notReady []string
+	for _, component := range cinderComponents {
+		deploy := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-" + component.name, Namespace: instance.Namespace}, deploy); err != nil {
+			return ctrl.Result{}, err
+		}
+		if !common.IsDeploymentReady(deploy) {
+			notReady = append(notReady, component.name)
+		}
+	}
+	if len(notReady) > 0 {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "DeploymentNotReady", fmt.Sprintf("Waiting for %v", notReady))
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDeploymentReady), metav1.ConditionTrue, "DeploymentReady", "cinder-api and cinder-scheduler are available", instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "Cinder is ready", instance.Generation,
+	)
+
+	// Run one cinder-volume per backend. Backends fail independently, so
+	// they are reported separately from Ready.
+	result := ctrl.Result{RequeueAfter: cinderBackendInterval}
+	unhealthy, err := r.reconcileBackends(ctx, instance, conns, backends, failed, configHashes)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(unhealthy) > 0 {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionBackendsReady), metav1.ConditionFalse, "BackendsNotReady",
+			fmt.Sprintf("Backends not ready: %s", strings.Join(unhealthy, ", ")), instance.Generation,
+		)
+		result.RequeueAfter = 30 * time.Second
+	} else {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionBackendsReady), metav1.ConditionTrue, "BackendsReady",
+			fmt.Sprintf("%d backends up", len(instance.Spec.Backends)), instance.Generation,
+		)
+	}
+
+	backupWaiting, err := r.ensureBackup(ctx, instance, backends, configHashes[""])
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	switch {
+	case instance.Spec.Backup == nil:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionBackupReady), metav1.ConditionTrue, "BackupDisabled", "cinder-backup is not configured", instance.Generation,
+		)
+	case backupWaiting != "":
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionBackupReady), metav1.ConditionFalse, "BackupNotReady", backupWaiting, instance.Generation,
+		)
+		result.RequeueAfter = 30 * time.Second
+	default:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionBackupReady), metav1.ConditionTrue, "BackupReady",
+			fmt.Sprintf("cinder-backup is running with the %s driver", instance.Spec.Backup.Driver), instance.Generation,
+		)
+	}
+
+	if err := r.updateStatus(ctx, instance); err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// waitFor records a not-yet-satisfied condition and requeues.
+func (r *CinderReconciler) waitFor(ctx context.Context, instance *openstackv1alpha1.Cinder, condType, reason, message string) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(instance.Status.Conditions, condType, metav1.ConditionFalse, reason, message, instance.Generation)
+	if condType != string(openstackv1alpha1.ConditionReady) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+func (r *CinderReconciler) updateStatus(ctx context.Context, instance *openstackv1alpha1.Cinder) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	return r.Status().Update(ctx, instance)
+}
+
+// ensureConfig renders cinder.conf and, per backend, the cinder-volume
+// config, start script and NFS shares file into a Secret (they embed credentials). It returns
+// the hash of cinder.conf under "" and of each backend's files under the
+// backend name, so changing one backend restarts only its cinder-volume.
+// Files of backends that failed to resolve are kept as they are, so their
+// running pods are not disturbed.
+func (r *CinderReconciler) ensureConfig(ctx context.Context, instance *openstackv1alpha1.Cinder, conns *common.Connections, backends []*cinderBackendConfig, failed map[string]string) (map[string]string, error) {
+	dbPassword, err := common.GetSecretValue(ctx, r.Client, cinderDBSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return nil, err
+	}
+	servicePassword, err := common.GetSecretValue(ctx, r.Client, cinderServiceSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return nil, err
+	}
+	mqSecret := instance.Spec.MessageQueue.SecretName
+	if mqSecret == "" {
+		mqSecret = conns.RabbitMQSecret
+	}
+	mqUser, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "username")
+	if err != nil {
+		return nil, err
+	}
+	mqPassword, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "password")
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := common.RenderTemplate("cinder/cinder.conf", map[string]any{
+		"DatabaseURL":       common.DatabaseURL("cinder", dbPassword, conns.MariaDBHost, "cinder"),
+		"TransportURL":      common.TransportURL(mqUser, mqPassword, conns.RabbitMQHost),
+		"KeystoneURL":       conns.KeystoneURL,
+		"MemcachedServers":  conns.MemcachedServers,
+		"Region":            conns.Region,
+		"ServiceUser":       "cinder",
+		"ServicePassword":   servicePassword,
+		"DefaultVolumeType": cinderDefaultBackend(instance),
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{"cinder.conf": conf}
+	hashes := map[string]string{"": common.ConfigHash(files)}
+	for _, backend := range backends {
+		backendFiles := map[string]string{}
+		for _, ext := range []string{".conf", ".sh"} {
+			rendered, err := common.RenderTemplate("cinder/cinder-volume"+ext, backend)
+			if err != nil {
+				return nil, err
+			}
+			backendFiles["cinder-volume-"+backend.Name+ext] = rendered
+		}
+		if backend.NFSShares != "" {
+			backendFiles["cinder-volume-"+backend.Name+".shares"] = backend.NFSShares
+		}
+		for name, content := range backendFiles {
+			files[name] = content
+		}
+		backendFiles["cinder.conf"] = conf
+		if backend.Ceph != nil {
+			backendFiles["ceph.conf"] = backend.Ceph.Config
+			backendFiles["keyring"] = backend.Ceph.Keyring
+		}
+		hashes[backend.Name] = common.ConfigHash(backendFiles)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-config", Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		previous := secret.Data
+		secret.Labels = labelsForCinder(instance.Name, "config")
+		secret.Data = make(map[string][]byte, len(files))
+		for k, v := range files {
+			secret.Data[k] = []byte(v)
+		}
+		for name := range failed {
+			for _, ext := range []string{".conf", ".sh", ".shares"} {
+				if data, ok := previous["cinder-volume-"+name+ext]; ok {
+					secret.Data["cinder-volume-"+name+ext] = data
+				}
+			}
+		}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+func (r *CinderReconciler) ensureDeployment(ctx context.Context, instance *openstackv1alpha1.Cinder, name string, labels map[string]string, component cinderComponent, configHash string) error {
+	replicas := int32(1)
+	if instance.Spec.Replicas != nil {
+		replicas = *instance.Spec.Replicas
+	}
+	container := corev1.Container{
+		Name:         "cinder-" + component.name,
+		Image:        images.ImageOrDefault(instance.Spec.Image, component.defaultImage),
+		Command:      component.command,
+		Resources:    instance.Spec.Resources,
+		VolumeMounts: []corev1.VolumeMount{cinderConfigVolumeMount()},
+	}
+	if component.port != 0 {
+		container.Ports = []corev1.ContainerPort{{ContainerPort: component.port, Name: component.name}}
+		container.ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt32(component.port)},
+			},
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       10,
+		}
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{container}
+		deploy.Spec.Template.Spec.Volumes = []corev1.Volume{cinderConfigVolume(instance)}
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
+
+func (r *CinderReconciler) ensureService(ctx context.Context, instance *openstackv1alpha1.Cinder, name string, labels map[string]string, component cinderComponent) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labels
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: component.name, Port: component.port, TargetPort: intstr.FromInt32(component.port), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+func (r *CinderReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.Cinder{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}).
+		Owns(&batchv1.Job{}).
+		Watches(&openstackv1alpha1.CephStorage{}, handler.EnqueueRequestsFromMapFunc(r.cindersForObject)).
+		Watches(&openstackv1alpha1.Nova{}, handler.EnqueueRequestsFromMapFunc(r.cindersForObject)).
+		Complete(r)
+}
+
+// cindersForObject maps an object to the Cinders in its namespace.
+func (r *CinderReconciler) cindersForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	cinders := &openstackv1alpha1.CinderList{}
+	if err := r.List(ctx, cinders, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(cinders.Items))
+	for _, cinder := range cinders.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cinder)})
+	}
+	return requests
+}
+
+func cinderDBSecretName(instance *openstackv1alpha1.Cinder) string {
+	if instance.Spec.Database.SecretName != "" {
+		return instance.Spec.Database.SecretName
+	}
+	return instance.Name + "-db-password"
+}
+
+func cinderServiceSecretName(instance *openstackv1alpha1.Cinder) string {
+	return instance.Name + "-service-password"
+}
+
+func cinderConfigVolume(instance *openstackv1alpha1.Cinder) corev1.Volume {
+	return corev1.Volume{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: instance.Name + "-config"},
+		},
+	}
+}
+
+func cinderConfigVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{Name: "config", MountPath: "/etc/cinder", ReadOnly: true}
+}
+
+func labelsForCinder(name, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "cinder",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/component":  component,
+		"app.kubernetes.io/managed-by": "openstack-operator",
+	}
+}
@@ -0,0 +1,487 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	heatAPIPort    = 8004
+	heatAPICFNPort = 8000
+)
+
+// heatComponent describes one Heat Deployment.
+type heatComponent struct {
+	name         string
+	defaultImage string
+	command      []string
+	// port is exposed through a Service named <heat>-<name>; 0 means none.
+	port int32
+}
+
+var heatComponents = []heatComponent{
+	{name: "api", defaultImage: images.DefaultHeatAPI, command: []string{"heat-api", "--config-file", "/etc/heat/heat.conf"}, port: heatAPIPort},
+	{name: "api-cfn", defaultImage: images.DefaultHeatAPICFN, command: []string{"heat-api-cfn", "--config-file", "/etc/heat/heat.conf"}, port: heatAPICFNPort},
+	{name: "engine", defaultImage: images.DefaultHeatEngine, command: []string{"heat-engine", "--config-file", "/etc/heat/heat.conf"}},
+}
+
+// HeatReconciler reconciles a Heat object.
+type HeatReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=heats,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=heats/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=heats/finalizers,verbs=update
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *HeatReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.Heat{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "Reconciling", "Reconciliation in progress", instance.Generation,
+	)
+
+	conns, err := common.ResolveConnections(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Ensure generated credentials
+	for _, name := range []string{heatDBSecretName(instance), heatServiceSecretName(instance), heatDomainAdminSecretName(instance)} {
+		if err := common.EnsureSecret(ctx, r.Client, name, instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Ensure database
+	if err := common.EnsureDatabase(ctx, r.Client, common.DatabaseParams{
+		Name:          instance.Name,
+		Namespace:     instance.Namespace,
+		DatabaseName:  "heat",
+		Username:      "heat",
+		SecretName:    heatDBSecretName(instance),
+		MariaDBSecret: conns.MariaDBSecret,
+		MariaDBHost:   conns.MariaDBHost,
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "CreatingDatabase", "Waiting for database creation")
+	}
+
+	cfnEndpoint := fmt.Sprintf("http://%s-api-cfn.%s.svc:%d", instance.Name, instance.Namespace, heatAPICFNPort)
+	configHash, err := r.ensureConfig(ctx, instance, conns, cfnEndpoint)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	params := common.DBSyncParams{
+		Name:         instance.Name,
+		Namespace:    instance.Namespace,
+		Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultHeatAPI),
+		Command:      []string{"heat-manage", "--config-file", "/etc/heat/heat.conf", "db_sync"},
+		SecretName:   heatDBSecretName(instance),
+		Volumes:      []corev1.Volume{heatConfigVolume(instance)},
+		VolumeMounts: []corev1.VolumeMount{heatConfigVolumeMount()},
+	}
+	if err := common.EnsureDBSync(ctx, r.Client, params, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-sync", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "SyncingDatabase", "Waiting for database migration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDatabaseReady), metav1.ConditionTrue, "DatabaseSynced", "Database created and migrated", instance.Generation,
+	)
+
+	// Ensure Heat services
+	for _, component := range heatComponents {
+		name := instance.Name + "-" + component.name
+		labels := labelsForHeat(instance.Name, component.name)
+		if err := r.ensureDeployment(ctx, instance, name, labels, component, configHash); err != nil {
+			return ctrl.Result{}, err
+		}
+		if component.port != 0 {
+			if err := r.ensureService(ctx, instance, name, labels, component); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	// Register the orchestration and cloudformation services. The heat
+	// service user also acts as trustee for deferred operations.
+	apiEndpoint := fmt.Sprintf("http://%s-api.%s.svc:%d/v1", instance.Name, instance.Namespace, heatAPIPort)
+	endpoints := []common.EndpointParams{
+		{
+			Name:              instance.Name,
+			ServiceName:       "heat",
+			ServiceType:       "orchestration",
+			InternalURL:       apiEndpoint + "/%(tenant_id)s",
+			ServiceUser:       "heat",
+			ServiceUserSecret: heatServiceSecretName(instance),
+		},
+		{
+			Name:        instance.Name + "-cfn",
+			ServiceName: "heat-cfn",
+			ServiceType: "cloudformation",
+			InternalURL: cfnEndpoint + "/v1",
+		},
+	}
+	for _, endpoint := range endpoints {
+		endpoint.Namespace = instance.Namespace
+		endpoint.PublicURL = endpoint.InternalURL
+		endpoint.AdminURL = endpoint.InternalURL
+		endpoint.Region = conns.Region
+		endpoint.KeystoneSecret = conns.KeystoneSecret
+		endpoint.KeystoneURL = conns.KeystoneURL
+		endpoint.BootstrapImage = images.DefaultKeystone
+		if err := common.EnsureKeystoneEndpoint(ctx, r.Client, endpoint, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	stackDomainJob, err := r.ensureStackDomain(ctx, instance, conns)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, job := range []string{instance.Name + "-endpoint-create", instance.Name + "-cfn-endpoint-create", stackDomainJob} {
+		if done, err := common.IsJobComplete(ctx, r.Client, job, instance.Namespace); err != nil {
+			return ctrl.Result{}, err
+		} else if !done {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "Bootstrapping", fmt.Sprintf("Waiting for Job %s", job))
+		}
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady), metav1.ConditionTrue, "Bootstrapped",
+		fmt.Sprintf("Keystone services, endpoints, stack domain %s and heat_stack_owner role registered", heatStackDomain(instance)), instance.Generation,
+	)
+	instance.Status.APIEndpoint = apiEndpoint
+	instance.Status.CFNEndpoint = cfnEndpoint + "/v1"
+
+	var notReady []string
+	for _, component := range heatComponents {
+		deploy := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-" + component.name, Namespace: instance.Namespace}, deploy); err != nil {
+			return ctrl.Result{}, err
+		}
+		if !common.IsDeploymentReady(deploy) {
+			notReady = append(notReady, component.name)
+		}
+	}
+	if len(notReady) > 0 {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "DeploymentNotReady", fmt.Sprintf("Waiting for %v", notReady))
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDeploymentReady), metav1.ConditionTrue, "DeploymentReady", "heat-api, heat-api-cfn and heat-engine are available", instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "Heat is ready", instance.Generation,
+	)
+
+	if err := r.updateStatus(ctx, instance); err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// waitFor records a not-yet-satisfied condition and requeues.
+func (r *HeatReconciler) waitFor(ctx context.Context, instance *openstackv1alpha1.Heat, condType, reason, message string) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(instance.Status.Conditions, condType, metav1.ConditionFalse, reason, message, instance.Generation)
+	if condType != string(openstackv1alpha1.ConditionReady) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+func (r *HeatReconciler) updateStatus(ctx context.Context, instance *openstackv1alpha1.Heat) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	return r.Status().Update(ctx, instance)
+}
+
+// ensureConfig renders heat.conf into a Secret (it embeds credentials) and
+// returns its hash.
+func (r *HeatReconciler) ensureConfig(ctx context.Context, instance *openstackv1alpha1.Heat, conns *common.Connections, cfnEndpoint string) (string, error) {
+	dbPassword, err := common.GetSecretValue(ctx, r.Client, heatDBSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	servicePassword, err := common.GetSecretValue(ctx, r.Client, heatServiceSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	domainAdminPassword, err := common.GetSecretValue(ctx, r.Client, heatDomainAdminSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	mqSecret := instance.Spec.MessageQueue.SecretName
+	if mqSecret == "" {
+		mqSecret = conns.RabbitMQSecret
+	}
+	mqUser, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "username")
+	if err != nil {
+		return "", err
+	}
+	mqPassword, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	metadataServerURL := instance.Spec.MetadataServerURL
+	if metadataServerURL == "" {
+		metadataServerURL = cfnEndpoint
+	}
+
+	conf, err := common.RenderTemplate("heat/heat.conf", map[string]any{
+		"DatabaseURL":         common.DatabaseURL("heat", dbPassword, conns.MariaDBHost, "heat"),
+		"TransportURL":        common.TransportURL(mqUser, mqPassword, conns.RabbitMQHost),
+		"KeystoneURL":         conns.KeystoneURL,
+		"KeystoneAuthURI":     strings.TrimSuffix(conns.KeystoneURL, "/v3"),
+		"MemcachedServers":    conns.MemcachedServers,
+		"Region":              conns.Region,
+		"ServiceUser":         "heat",
+		"ServicePassword":     servicePassword,
+		"StackDomain":         heatStackDomain(instance),
+		"DomainAdminPassword": domainAdminPassword,
+		"MetadataServerURL":   strings.TrimSuffix(metadataServerURL, "/"),
+	})
+	if err != nil {
+		return "", err
+	}
+	files := map[string]string{"heat.conf": conf}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-config", Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labelsForHeat(instance.Name, "config")
+		secret.Data = map[string][]byte{"heat.conf": []byte(conf)}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return "", err
+	}
+	return common.ConfigHash(files), nil
+}
+
+// ensureStackDomain runs the Job that creates the stack domain and sets the
+// password of its admin, and returns the Job's name. The name is derived from
+// the domain and password, so the Job runs again when either changes; Jobs
+// for earlier settings are removed.
+func (r *HeatReconciler) ensureStackDomain(ctx context.Context, instance *openstackv1alpha1.Heat, conns *common.Connections) (string, error) {
+	domain := heatStackDomain(instance)
+	password, err := common.GetSecretValue(ctx, r.Client, heatDomainAdminSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	hash := common.ConfigHash(map[string]string{"domain": domain, "password": password})
+	name := fmt.Sprintf("%s-stack-domain-%s", instance.Name, hash[:8])
+	labels := labelsForHeat(instance.Name, "stack-domain")
+
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(instance.Namespace), client.MatchingLabels(labels)); err != nil {
+		return "", err
+	}
+	for i := range jobs.Items {
+		if jobs.Items[i].Name == name {
+			continue
+		}
+		if err := r.Delete(ctx, &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return "", err
+		}
+	}
+
+	return name, common.EnsureKeystoneJob(ctx, r.Client, common.KeystoneJobParams{
+		Name:           name,
+		Namespace:      instance.Namespace,
+		Labels:         labels,
+		Commands:       heatStackDomainCommands(domain),
+		Env:            []corev1.EnvVar{common.SecretEnvVar("DOMAIN_ADMIN_PASSWORD", heatDomainAdminSecretName(instance), "password")},
+		KeystoneSecret: conns.KeystoneSecret,
+		KeystoneURL:    conns.KeystoneURL,
+		BootstrapImage: images.DefaultKeystone,
+	}, instance)
+}
+
+// heatStackDomainCommands create the stack user domain with its admin and
+// the roles Heat relies on. heat_stack_owner lets users create stacks that
+// need trusts or stack users; the admin user gets it so the cloud admin can
+// too. heat_stack_user is given to the users Heat creates for stacks.
+func heatStackDomainCommands(domain string) []string {
+	return []string{
+		fmt.Sprintf(`openstack domain create --or-show --description "Owns users and projects created by heat" %s`, domain),
+		fmt.Sprintf(`openstack user create --domain %s --or-show --password "$DOMAIN_ADMIN_PASSWORD" --description "Manages users and projects created by heat" heat_domain_admin`, domain),
+		fmt.Sprintf(`openstack user set --domain %s --password "$DOMAIN_ADMIN_PASSWORD" heat_domain_admin`, domain),
+		fmt.Sprintf(`openstack role add --domain %s --user-domain %s --user heat_domain_admin admin`, domain, domain),
+		`openstack role create --or-show heat_stack_owner`,
+		`openstack role create --or-show heat_stack_user`,
+		`openstack role add --project admin --user admin heat_stack_owner`,
+	}
+}
+
+func (r *HeatReconciler) ensureDeployment(ctx context.Context, instance *openstackv1alpha1.Heat, name string, labels map[string]string, component heatComponent, configHash string) error {
+	replicas := int32(1)
+	if component.name == "engine" {
+		if instance.Spec.EngineReplicas != nil {
+			replicas = *instance.Spec.EngineReplicas
+		}
+	} else if instance.Spec.Replicas != nil {
+		replicas = *instance.Spec.Replicas
+	}
+	container := corev1.Container{
+		Name:         "heat-" + component.name,
+		Image:        images.ImageOrDefault(instance.Spec.Image, component.defaultImage),
+		Command:      component.command,
+		Resources:    instance.Spec.Resources,
+		VolumeMounts: []corev1.VolumeMount{heatConfigVolumeMount()},
+	}
+	if component.port != 0 {
+		container.Ports = []corev1.ContainerPort{{ContainerPort: component.port, Name: component.name}}
+		container.ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt32(component.port)},
+			},
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       10,
+		}
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{container}
+		deploy.Spec.Template.Spec.Volumes = []corev1.Volume{heatConfigVolume(instance)}
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
+
+func (r *HeatReconciler) ensureService(ctx context.Context, instance *openstackv1alpha1.Heat, name string, labels map[string]string, component heatComponent) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labels
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: component.name, Port: component.port, TargetPort: intstr.FromInt32(component.port), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+func (r *HeatReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.Heat{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
+
+func heatStackDomain(instance *openstackv1alpha1.Heat) string {
+	if instance.Spec.StackDomain != "" {
+		return instance.Spec.StackDomain
+	}
+	return "heat"
+}
+
+func heatDBSecretName(instance *openstackv1alpha1.Heat) string {
+	if instance.Spec.Database.SecretName != "" {
+		return instance.Spec.Database.SecretName
+	}
+	return instance.Name + "-db-password"
+}
+
+func heatServiceSecretName(instance *openstackv1alpha1.Heat) string {
+	return instance.Name + "-service-password"
+}
+
+func heatDomainAdminSecretName(instance *openstackv1alpha1.Heat) string {
+	return instance.Name + "-domain-admin-password"
+}
+
+func heatConfigVolume(instance *openstackv1alpha1.Heat) corev1.Volume {
+	return corev1.Volume{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: instance.Name + "-config"},
+		},
+	}
+}
+
+func heatConfigVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{Name: "config", MountPath: "/etc/heat", ReadOnly: true}
+}
+
+func labelsForHeat(name, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "heat",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/component":  component,
+		"app.kubernetes.io/managed-by": "openstack-operator",
+	}
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func newHeat(name string) *openstackv1alpha1.Heat {
+	return &openstackv1alpha1.Heat{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openstack", UID: "heat-uid"},
+	}
+}
+
+func domainAdminPassword(heat, password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: heat + "-domain-admin-password", Namespace: "openstack"},
+		Data:       map[string][]byte{"password": []byte(password)},
+	}
+}
+
+func stackDomainJobs(t *testing.T, c client.Client, heat string) []batchv1.Job {
+	t.Helper()
+	jobs := &batchv1.JobList{}
+	if err := c.List(context.Background(), jobs, client.InNamespace("openstack"), client.MatchingLabels(labelsForHeat(heat, "stack-domain"))); err != nil {
+		t.Fatal(err)
+	}
+	return jobs.Items
+}
+
+func TestHeatStackDomainCommands(t *testing.T) {
+	commands := strings.Join(heatStackDomainCommands("stacks"), "\n")
+	for _, want := range []string{
+		"openstack domain create --or-show",
+		"--domain stacks --or-show --password \"$DOMAIN_ADMIN_PASSWORD\"",
+		// An existing user gets the current password too.
+		"openstack user set --domain stacks --password \"$DOMAIN_ADMIN_PASSWORD\" heat_domain_admin",
+		"openstack role add --domain stacks --user-domain stacks --user heat_domain_admin admin",
+		"openstack role create --or-show heat_stack_owner",
+		"openstack role create --or-show heat_stack_user",
+	} {
+		if !strings.Contains(commands, want) {
+			t.Errorf("commands do not contain %q:\n%s", want, commands)
+		}
+	}
+}
+
+func TestHeatEnsureStackDomain(t *testing.T) {
+	ctx := context.Background()
+	conns := &common.Connections{KeystoneURL: "http://keystone-api.openstack.svc:5000/v3", KeystoneSecret: "keystone"}
+	secret := domainAdminPassword("heat", "first")
+	c := newFakeClient(t, secret, domainAdminPassword("other", "first"))
+	r := &HeatReconciler{Client: c, Scheme: testScheme}
+	instance := newHeat("heat")
+
+	// Another Heat in the namespace keeps its Job.
+	if _, err := r.ensureStackDomain(ctx, newHeat("other"), conns); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := r.ensureStackDomain(ctx, instance, conns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := stackDomainJobs(t, c, "heat")
+	if len(jobs) != 1 || jobs[0].Name != first || !strings.HasPrefix(first, "heat-stack-domain-") {
+		t.Fatalf("jobs = %v, want %s", jobs, first)
+	}
+	container := jobs[0].Spec.Template.Spec.Containers[0]
+	if script := strings.Join(container.Command, " "); !strings.Contains(script, "--domain heat ") || strings.Contains(script, "first") {
+		t.Errorf("script = %s, want the heat domain and no password", script)
+	}
+	found := false
+	for _, env := range container.Env {
+		if env.Name == "DOMAIN_ADMIN_PASSWORD" {
+			found = env.ValueFrom != nil && env.ValueFrom.SecretKeyRef.Name == "heat-domain-admin-password"
+		}
+	}
+	if !found {
+		t.Error("DOMAIN_ADMIN_PASSWORD is not read from the Secret")
+	}
+
+	// Unchanged settings keep the Job, so it does not run again.
+	if again, err := r.ensureStackDomain(ctx, instance, conns); err != nil || again != first {
+		t.Fatalf("job = %s, error = %v, want %s", again, err, first)
+	}
+	if len(stackDomainJobs(t, c, "heat")) != 1 {
+		t.Error("Job duplicated")
+	}
+
+	// A new domain or password runs a new Job in place of the old one.
+	instance.Spec.StackDomain = "stacks"
+	renamed, err := r.ensureStackDomain(ctx, instance, conns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["password"] = []byte("second")
+	if err := c.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := r.ensureStackDomain(ctx, instance, conns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed == first || rotated == renamed {
+		t.Errorf("jobs %s, %s, %s, want a new Job for each change", first, renamed, rotated)
+	}
+	jobs = stackDomainJobs(t, c, "heat")
+	if len(jobs) != 1 || jobs[0].Name != rotated {
+		t.Errorf("jobs = %d, want %s only", len(jobs), rotated)
+	}
+	if len(stackDomainJobs(t, c, "other")) != 1 {
+		t.Error("the Job of another Heat was removed")
+	}
+}
+
+func TestHeatReconcileBootstrap(t *testing.T) {
+	ctx := context.Background()
+	_, fos := newFakeOpenStack(t, map[string]fakeResponse{})
+	objs := append(fos.controlPlane(), newHeat("heat"), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "rabbitmq-credentials", Namespace: "openstack"},
+		Data:       map[string][]byte{"username": []byte("heat"), "password": []byte("mqpass")},
+	})
+	c := newFakeClient(t, objs...)
+	r := &HeatReconciler{Client: c, Scheme: testScheme}
+	reconcile := func() *openstackv1alpha1.Heat {
+		t.Helper()
+		if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "heat", Namespace: "openstack"}}); err != nil {
+			t.Fatal(err)
+		}
+		instance := &openstackv1alpha1.Heat{}
+		if err := c.Get(ctx, client.ObjectKey{Name: "heat", Namespace: "openstack"}, instance); err != nil {
+			t.Fatal(err)
+		}
+		return instance
+	}
+
+	reconcile()
+	completeJob(t, c, "heat-db-create")
+	reconcile()
+	completeJob(t, c, "heat-db-sync")
+	reconcile()
+	completeJob(t, c, "heat-endpoint-create")
+	completeJob(t, c, "heat-cfn-endpoint-create")
+
+	// Heat is not bootstrapped until the stack domain exists.
+	instance := reconcile()
+	jobs := stackDomainJobs(t, c, "heat")
+	if len(jobs) != 1 {
+		t.Fatalf("%d stack domain Jobs", len(jobs))
+	}
+	cond := meta.FindStatusCondition(instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady))
+	if cond == nil || cond.Status != metav1.ConditionFalse || !strings.Contains(cond.Message, jobs[0].Name) {
+		t.Fatalf("BootstrapReady = %+v, want waiting for %s", cond, jobs[0].Name)
+	}
+
+	completeJob(t, c, jobs[0].Name)
+	instance = reconcile()
+	if !meta.IsStatusConditionTrue(instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady)) {
+		t.Errorf("BootstrapReady = %+v", meta.FindStatusCondition(instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady)))
+	}
+	if instance.Status.CFNEndpoint != "http://heat-api-cfn.openstack.svc:8000/v1" {
+		t.Errorf("CFN endpoint = %q", instance.Status.CFNEndpoint)
+	}
+	// The completed Job is kept and not rerun.
+	if jobs := stackDomainJobs(t, c, "heat"); len(jobs) != 1 || jobs[0].Status.Conditions == nil {
+		t.Errorf("stack domain Jobs = %v", jobs)
+	}
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// testScheme holds the Kubernetes and operator types.
+var testScheme = func() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(openstackv1alpha1.AddToScheme(scheme))
+	return scheme
+}()
+
+// newFakeClient returns a fake client holding objs, with the status
+// subresource of the operator types that have one.
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	return fake.NewClientBuilder().
+		WithScheme(testScheme).
+		WithObjects(objs...).
+		WithStatusSubresource(
+			&openstackv1alpha1.Heat{},
+			&openstackv1alpha1.Nova{},
+			&openstackv1alpha1.Octavia{},
+			&openstackv1alpha1.OVNNetwork{},
+			&openstackv1alpha1.OpenStackDataPlane{},
+			&openstackv1alpha1.OpenStackFlavor{},
+			&openstackv1alpha1.OpenStackQoSSpec{},
+			&openstackv1alpha1.OpenStackVolumeType{},
+		).
+		Build()
+}
+
+// fakeResponse is the status and JSON body a fake OpenStack API answers a
+// request with.
+type fakeResponse struct {
+	status int
+	body   any
+}
+
+// fakeOpenStack is an OpenStack API answering each request, keyed by method
+// and path with query, from its routes and 404 otherwise. It records the
+// requests and the JSON body each was last sent with.
+type fakeOpenStack struct {
+	mu       sync.Mutex
+	routes   map[string]fakeResponse
+	requests []string
+	bodies   map[string]map[string]any
+	// url is the Keystone endpoint, serving every other API too.
+	url string
+}
+
+// newFakeOpenStack returns an admin client of a fake OpenStack whose catalog
+// points every service at routes.
+func newFakeOpenStack(t *testing.T, routes map[string]fakeResponse) (*common.OpenStackClient, *fakeOpenStack) {
+	t.Helper()
+	fos := &fakeOpenStack{routes: routes, bodies: map[string]map[string]any{}}
+	server := httptest.NewServer(fos)
+	t.Cleanup(server.Close)
+	fos.url = server.URL
+
+	var catalog []map[string]any
+	for _, serviceType := range []string{"compute", "volumev3", "network", "image", "orchestration"} {
+		catalog = append(catalog, map[string]any{
+			"type":      serviceType,
+			"endpoints": []map[string]string{{"interface": "internal", "region": "RegionOne", "url": server.URL}},
+		})
+	}
+	fos.routes["POST /auth/tokens"] = fakeResponse{status: http.StatusCreated, body: map[string]any{"token": map[string]any{"catalog": catalog}}}
+
+	c := newFakeClient(t, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keystone", Namespace: "openstack"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+	osc, err := common.NewOpenStackClient(context.Background(), c, "openstack", &common.Connections{
+		Region: "RegionOne", KeystoneURL: server.URL, KeystoneSecret: "keystone",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fos.requests = nil
+	return osc, fos
+}
+
+// controlPlane returns the Ready MariaDB, RabbitMQ and Keystone through
+// which controllers resolve the connections to the fake OpenStack, and the
+// Keystone admin password Secret.
+func (f *fakeOpenStack) controlPlane() []client.Object {
+	ready := common.SetCondition(nil, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "", 0)
+	return []client.Object{
+		&openstackv1alpha1.MariaDB{
+			ObjectMeta: metav1.ObjectMeta{Name: "mariadb", Namespace: "openstack"},
+			Status:     openstackv1alpha1.MariaDBStatus{CommonStatus: openstackv1alpha1.CommonStatus{Conditions: ready}},
+		},
+		&openstackv1alpha1.RabbitMQ{
+			ObjectMeta: metav1.ObjectMeta{Name: "rabbitmq", Namespace: "openstack"},
+			Status:     openstackv1alpha1.RabbitMQStatus{CommonStatus: openstackv1alpha1.CommonStatus{Conditions: ready}},
+		},
+		&openstackv1alpha1.Keystone{
+			ObjectMeta: metav1.ObjectMeta{Name: "keystone", Namespace: "openstack"},
+			Spec:       openstackv1alpha1.KeystoneSpec{AdminPasswordSecretName: "keystone"},
+			Status:     openstackv1alpha1.KeystoneStatus{CommonStatus: openstackv1alpha1.CommonStatus{Conditions: ready}, APIEndpoint: f.url},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "keystone", Namespace: "openstack"},
+			Data:       map[string][]byte{"password": []byte("secret")},
+		},
+	}
+}
+
+func (f *fakeOpenStack) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	key := req.Method + " " + req.URL.RequestURI()
+	data, _ := io.ReadAll(req.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, key)
+	if len(data) > 0 {
+		var body map[string]any
+		_ = json.Unmarshal(data, &body)
+		f.bodies[key] = body
+	}
+	resp, ok := f.routes[key]
+	f.mu.Unlock()
+	if !ok {
+		resp = fakeResponse{status: http.StatusNotFound}
+	}
+	if resp.status == 0 {
+		resp.status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Subject-Token", "token")
+	w.WriteHeader(resp.status)
+	if resp.body != nil {
+		_ = json.NewEncoder(w).Encode(resp.body)
+	}
+}
+
+// sent reports whether a request with the given method and path was made.
+func (f *fakeOpenStack) sent(key string) bool {
+	return f.count(key) > 0
+}
+
+// count returns how many requests with the given method and path were made.
+func (f *fakeOpenStack) count(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, request := range f.requests {
+		if request == key {
+			n++
+		}
+	}
+	return n
+}
+
+// body returns the JSON body the last request with the given method and
+// path was sent with.
+func (f *fakeOpenStack) body(key string) map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bodies[key]
+}
@@ -0,0 +1,604 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const neutronAPIPort = 9696
+
+// NeutronReconciler reconciles a Neutron object.
+type NeutronReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=neutrons,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=neutrons/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=neutrons/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=ovnnetworks,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments;daemonsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *NeutronReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.Neutron{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "Reconciling", "Reconciliation in progress", instance.Generation,
+	)
+
+	// Validate plugins before touching anything else; a bad combination is a
+	// spec error and will not fix itself by requeueing.
+	plugins, err := resolveNeutronPlugins(neutronMechanism(instance), instance.Spec.Plugins)
+	if err != nil {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "InvalidPlugins", err.Error(), instance.Generation,
+		)
+		return ctrl.Result{}, r.updateStatus(ctx, instance)
+	}
+
+	conns, err := common.ResolveConnections(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	var ovn *openstackv1alpha1.OVNNetworkStatus
+	if neutronMechanism(instance) == "ovn" {
+		ovn, err = ovnNetworkStatus(ctx, r.Client, instance.Namespace)
+		if err != nil {
+			if errors.Is(err, common.ErrDependencyNotReady) {
+				return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Ensure generated credentials
+	if err := common.EnsureSecret(ctx, r.Client, neutronDBSecretName(instance), instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := common.EnsureSecret(ctx, r.Client, neutronServiceSecretName(instance), instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Ensure database
+	if err := common.EnsureDatabase(ctx, r.Client, common.DatabaseParams{
+		Name:          instance.Name,
+		Namespace:     instance.Namespace,
+		DatabaseName:  "neutron",
+		Username:      "neutron",
+		SecretName:    neutronDBSecretName(instance),
+		MariaDBSecret: conns.MariaDBSecret,
+		MariaDBHost:   conns.MariaDBHost,
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "CreatingDatabase", "Waiting for database creation")
+	}
+
+	configHash, err := r.ensureConfig(ctx, instance, conns, plugins, ovn)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Run migrations: the core schema first, then one Job per plugin
+	// subproject so that plugins enabled later still get their branch upgraded.
+	image := images.ImageOrDefault(instance.Spec.Image, images.DefaultNeutronServer)
+	syncJobs := []common.DBSyncParams{{
+		Name:    instance.Name,
+		Command: neutronDBManage("upgrade", "heads"),
+	}}
+	for _, subproject := range plugins.Subprojects {
+		syncJobs = append(syncJobs, common.DBSyncParams{
+			Name:    fmt.Sprintf("%s-%s", instance.Name, strings.TrimPrefix(subproject, "neutron-")),
+			Command: neutronDBManage("--subproject", subproject, "upgrade", "head"),
+		})
+	}
+	for _, params := range syncJobs {
+		params.Namespace = instance.Namespace
+		params.Image = image
+		params.SecretName = neutronDBSecretName(instance)
+		params.Volumes = []corev1.Volume{neutronConfigVolume(instance)}
+		params.VolumeMounts = []corev1.VolumeMount{neutronConfigVolumeMount()}
+		if err := common.EnsureDBSync(ctx, r.Client, params, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		if done, err := common.IsJobComplete(ctx, r.Client, params.Name+"-db-sync", instance.Namespace); err != nil {
+			return ctrl.Result{}, err
+		} else if !done {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "SyncingDatabase", fmt.Sprintf("Waiting for %s-db-sync", params.Name))
+		}
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDatabaseReady), metav1.ConditionTrue, "DatabaseSynced", "Database created and migrated", instance.Generation,
+	)
+
+	// Ensure neutron-server and any plugin agents
+	if err := r.ensureDeployment(ctx, instance, ovn, configHash); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.ensureAgents(ctx, instance, plugins, ovn, configHash); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.ensureService(ctx, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	apiEndpoint := fmt.Sprintf("http://%s-server.%s.svc:%d", instance.Name, instance.Namespace, neutronAPIPort)
+	if err := common.EnsureKeystoneEndpoint(ctx, r.Client, common.EndpointParams{
+		Name:              instance.Name,
+		Namespace:         instance.Namespace,
+		ServiceName:       "neutron",
+		ServiceType:       "network",
+		InternalURL:       apiEndpoint,
+		PublicURL:         apiEndpoint,
+		AdminURL:          apiEndpoint,
+		Region:            conns.Region,
+		KeystoneSecret:    conns.KeystoneSecret,
+		KeystoneURL:       conns.KeystoneURL,
+		BootstrapImage:    images.DefaultKeystone,
+		ServiceUser:       "neutron",
+		ServiceUserSecret: neutronServiceSecretName(instance),
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-endpoint-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "RegisteringEndpoint", "Waiting for Keystone endpoint registration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady), metav1.ConditionTrue, "EndpointRegistered", "Keystone service, user and endpoints registered", instance.Generation,
+	)
+
+	instance.Status.APIEndpoint = apiEndpoint
+	instance.Status.ServicePlugins = plugins.ServicePlugins
+
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-server", Namespace: instance.Namespace}, deploy); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !common.IsDeploymentReady(deploy) {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "DeploymentNotReady", "Waiting for neutron-server pods")
+	}
+	for _, agent := range plugins.Agents {
+		ds := &appsv1.DaemonSet{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-" + agent.name, Namespace: instance.Namespace}, ds); err != nil {
+			return ctrl.Result{}, err
+		}
+		if ds.Status.DesiredNumberScheduled == 0 {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "NoGatewayNodes", fmt.Sprintf("No OVN gateway chassis node to run %s on", agent.name))
+		}
+		if ds.Status.ObservedGeneration < ds.Generation || ds.Status.NumberReady < ds.Status.DesiredNumberScheduled ||
+			ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "DeploymentNotReady", fmt.Sprintf("Waiting for %s pods", agent.name))
+		}
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDeploymentReady), metav1.ConditionTrue, "DeploymentReady", "neutron-server is available", instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "Neutron is ready", instance.Generation,
+	)
+
+	if err := r.updateStatus(ctx, instance); err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// waitFor records a not-yet-satisfied condition and requeues.
+func (r *NeutronReconciler) waitFor(ctx context.Context, instance *openstackv1alpha1.Neutron, condType, reason, message string) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(instance.Status.Conditions, condType, metav1.ConditionFalse, reason, message, instance.Generation)
+	if condType != string(openstackv1alpha1.ConditionReady) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+func (r *NeutronReconciler) updateStatus(ctx context.Context, instance *openstackv1alpha1.Neutron) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	return r.Status().Update(ctx, instance)
+}
+
+// ovnNetworkStatus returns the status of the OVNNetwork in the namespace
+// once it publishes NB/SB endpoints.
+func ovnNetworkStatus(ctx context.Context, c client.Client, namespace string) (*openstackv1alpha1.OVNNetworkStatus, error) {
+	ovns := &openstackv1alpha1.OVNNetworkList{}
+	if err := c.List(ctx, ovns, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(ovns.Items) == 0 {
+		return nil, fmt.Errorf("OVNNetwork: %w", common.ErrDependencyNotReady)
+	}
+	status := ovns.Items[0].Status
+	if status.NorthboundDBEndpoint == "" || status.SouthboundDBEndpoint == "" {
+		return nil, fmt.Errorf("OVNNetwork endpoints: %w", common.ErrDependencyNotReady)
+	}
+	return &status, nil
+}
+
+// ensureConfig renders neutron.conf, ml2_conf.ini and any agent configs into a
+// Secret (they embed credentials) and returns their hash.
+func (r *NeutronReconciler) ensureConfig(ctx context.Context, instance *openstackv1alpha1.Neutron, conns *common.Connections, plugins *neutronPluginConfig, ovn *openstackv1alpha1.OVNNetworkStatus) (string, error) {
+	dbPassword, err := common.GetSecretValue(ctx, r.Client, neutronDBSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	servicePassword, err := common.GetSecretValue(ctx, r.Client, neutronServiceSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	mqSecret := instance.Spec.MessageQueue.SecretName
+	if mqSecret == "" {
+		mqSecret = conns.RabbitMQSecret
+	}
+	mqUser, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "username")
+	if err != nil {
+		return "", err
+	}
+	mqPassword, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+
+	tunnelType := instance.Spec.TunnelType
+	if tunnelType == "" {
+		tunnelType = "geneve"
+	}
+	externalBridge := instance.Spec.ExternalBridge
+	if externalBridge == "" {
+		externalBridge = "br-ex"
+	}
+	data := map[string]any{
+		"DatabaseURL":      common.DatabaseURL("neutron", dbPassword, conns.MariaDBHost, "neutron"),
+		"TransportURL":     common.TransportURL(mqUser, mqPassword, conns.RabbitMQHost),
+		"KeystoneURL":      conns.KeystoneURL,
+		"MemcachedServers": conns.MemcachedServers,
+		"Region":           conns.Region,
+		"ServiceUser":      "neutron",
+		"ServicePassword":  servicePassword,
+		"Mechanism":        neutronMechanism(instance),
+		"TunnelType":       tunnelType,
+		"ExternalBridge":   externalBridge,
+		"ServicePlugins":   plugins.ServicePlugins,
+		"ExtensionDrivers": plugins.ExtensionDrivers,
+		"ServiceProviders": plugins.ServiceProviders,
+	}
+
+	if ovn != nil {
+		data["OVNNBConnection"] = ovn.NorthboundDBEndpoint
+		data["OVNSBConnection"] = ovn.SouthboundDBEndpoint
+		data["OVNTLS"] = ovn.TLSSecretName != ""
+	}
+
+	files := map[string]string{}
+	templates := []string{"neutron.conf", "ml2_conf.ini"}
+	for _, agent := range plugins.Agents {
+		templates = append(templates, agent.configFile)
+	}
+	for _, name := range templates {
+		rendered, err := common.RenderTemplate("neutron/"+name, data)
+		if err != nil {
+			return "", err
+		}
+		files[name] = rendered
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-config", Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labelsForNeutron(instance.Name, "config")
+		secret.StringData = nil
+		secret.Data = make(map[string][]byte, len(files))
+		for k, v := range files {
+			secret.Data[k] = []byte(v)
+		}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return "", err
+	}
+	return common.ConfigHash(files), nil
+}
+
+func (r *NeutronReconciler) ensureDeployment(ctx context.Context, instance *openstackv1alpha1.Neutron, ovn *openstackv1alpha1.OVNNetworkStatus, configHash string) error {
+	replicas := int32(1)
+	if instance.Spec.Replicas != nil {
+		replicas = *instance.Spec.Replicas
+	}
+	labels := labelsForNeutron(instance.Name, "server")
+	volumes, volumeMounts := neutronVolumes(instance, ovn)
+
+	containers := []corev1.Container{
+		{
+			Name:  "neutron-server",
+			Image: images.ImageOrDefault(instance.Spec.Image, images.DefaultNeutronServer),
+			Command: []string{
+				"neutron-server",
+				"--config-file", "/etc/neutron/neutron.conf",
+				"--config-file", "/etc/neutron/ml2_conf.ini",
+			},
+			Ports: []corev1.ContainerPort{
+				{ContainerPort: neutronAPIPort, Name: "api"},
+			},
+			Resources:    instance.Spec.Resources,
+			VolumeMounts: volumeMounts,
+			ReadinessProbe: &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt32(neutronAPIPort)},
+				},
+				InitialDelaySeconds: 10,
+				PeriodSeconds:       10,
+			},
+		},
+	}
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-server", Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		deploy.Spec.Template.Spec.Containers = containers
+		deploy.Spec.Template.Spec.Volumes = volumes
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
+
+// ensureAgents runs each plugin agent as a DaemonSet on the OVN gateway
+// chassis, next to the Open vSwitch it plugs router namespaces into, and
+// removes the DaemonSets of agents no longer needed.
+func (r *NeutronReconciler) ensureAgents(ctx context.Context, instance *openstackv1alpha1.Neutron, plugins *neutronPluginConfig, ovn *openstackv1alpha1.OVNNetworkStatus, configHash string) error {
+	enabled := map[string]bool{}
+	for _, agent := range plugins.Agents {
+		enabled[agent.name] = true
+	}
+	for _, byMechanism := range neutronPluginCatalog {
+		for _, support := range byMechanism {
+			if support.agent == nil || enabled[support.agent.name] {
+				continue
+			}
+			ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-" + support.agent.name, Namespace: instance.Namespace}}
+			if err := r.Delete(ctx, ds); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	if len(plugins.Agents) == 0 {
+		return nil
+	}
+
+	nodeSelector, err := ovnGatewayNodeSelector(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		return err
+	}
+	volumes, volumeMounts := neutronVolumes(instance, ovn)
+	volumes = append(volumes,
+		hostPathVolume("run-openvswitch", "/run/openvswitch"),
+		hostPathVolume("run-netns", "/run/netns"),
+	)
+	bidirectional := corev1.MountPropagationBidirectional
+	volumeMounts = append(volumeMounts,
+		corev1.VolumeMount{Name: "run-openvswitch", MountPath: "/run/openvswitch"},
+		corev1.VolumeMount{Name: "run-netns", MountPath: "/run/netns", MountPropagation: &bidirectional},
+	)
+	privileged := true
+
+	for _, agent := range plugins.Agents {
+		labels := labelsForNeutron(instance.Name, agent.name)
+		ds := &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-" + agent.name, Namespace: instance.Namespace},
+		}
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, ds, func() error {
+			ds.Labels = labels
+			if ds.CreationTimestamp.IsZero() {
+				ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+			}
+			ds.Spec.Template.Labels = labels
+			ds.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+			ds.Spec.Template.Spec.NodeSelector = nodeSelector
+			ds.Spec.Template.Spec.HostNetwork = true
+			ds.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+			ds.Spec.Template.Spec.Volumes = volumes
+			ds.Spec.Template.Spec.Containers = []corev1.Container{
+				{
+					Name:            agent.name,
+					Image:           neutronAgentImage(instance, agent),
+					Command:         agent.command,
+					VolumeMounts:    volumeMounts,
+					SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+				},
+			}
+			return controllerutil.SetControllerReference(instance, ds, r.Scheme)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// neutronAgentImage returns the image of an agent: the one set for it in
+// the spec, or its default. The neutron-server Image never applies to
+// agents.
+func neutronAgentImage(instance *openstackv1alpha1.Neutron, agent *neutronAgent) string {
+	image := ""
+	switch agent.name {
+	case "ovn-vpn-agent":
+		image = instance.Spec.VPNAgentImage
+	}
+	return images.ImageOrDefault(image, agent.defaultImage)
+}
+
+// ovnGatewayNodeSelector returns the node selector of the gateway chassis
+// of the OVNNetwork in the namespace.
+func ovnGatewayNodeSelector(ctx context.Context, c client.Client, namespace string) (map[string]string, error) {
+	ovns := &openstackv1alpha1.OVNNetworkList{}
+	if err := c.List(ctx, ovns, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(ovns.Items) == 0 {
+		return nil, fmt.Errorf("OVNNetwork: %w", common.ErrDependencyNotReady)
+	}
+	chassis := ovns.Items[0].Spec.Chassis
+	selector := map[string]string{}
+	for k, v := range chassis.NodeSelector {
+		selector[k] = v
+	}
+	if len(selector) == 0 {
+		selector[defaultComputeNodeLabel] = "true"
+	}
+	gatewayLabel := chassis.GatewayNodeLabel
+	if gatewayLabel == "" {
+		gatewayLabel = defaultGatewayNodeLabel
+	}
+	selector[gatewayLabel] = "true"
+	return selector, nil
+}
+
+func (r *NeutronReconciler) ensureService(ctx context.Context, instance *openstackv1alpha1.Neutron) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-server", Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labelsForNeutron(instance.Name, "server")
+		svc.Spec.Selector = labelsForNeutron(instance.Name, "server")
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: "api", Port: neutronAPIPort, TargetPort: intstr.FromInt32(neutronAPIPort), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+func (r *NeutronReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.Neutron{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
+
+func neutronMechanism(instance *openstackv1alpha1.Neutron) string {
+	if instance.Spec.Mechanism == "" {
+		return "ovn"
+	}
+	return instance.Spec.Mechanism
+}
+
+func neutronDBSecretName(instance *openstackv1alpha1.Neutron) string {
+	if instance.Spec.Database.SecretName != "" {
+		return instance.Spec.Database.SecretName
+	}
+	return instance.Name + "-db-password"
+}
+
+func neutronServiceSecretName(instance *openstackv1alpha1.Neutron) string {
+	return instance.Name + "-service-password"
+}
+
+func neutronDBManage(args ...string) []string {
+	return append([]string{
+		"neutron-db-manage",
+		"--config-file", "/etc/neutron/neutron.conf",
+		"--config-file", "/etc/neutron/ml2_conf.ini",
+	}, args...)
+}
+
+// neutronVolumes returns the config volume and, with OVN TLS, the client
+// certificate.
+func neutronVolumes(instance *openstackv1alpha1.Neutron, ovn *openstackv1alpha1.OVNNetworkStatus) ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{neutronConfigVolume(instance)}
+	volumeMounts := []corev1.VolumeMount{neutronConfigVolumeMount()}
+	if ovn != nil && ovn.TLSSecretName != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name:         "ovn-tls",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: ovn.TLSSecretName}},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: "ovn-tls", MountPath: "/etc/ovn/tls", ReadOnly: true})
+	}
+	return volumes, volumeMounts
+}
+
+func neutronConfigVolume(instance *openstackv1alpha1.Neutron) corev1.Volume {
+	return corev1.Volume{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: instance.Name + "-config"},
+		},
+	}
+}
+
+func neutronConfigVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{Name: "config", MountPath: "/etc/neutron", ReadOnly: true}
+}
+
+func labelsForNeutron(name, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "neutron",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/component":  component,
+		"app.kubernetes.io/managed-by": "openstack-operator",
+	}
+}
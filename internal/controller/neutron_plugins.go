@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+// neutronPluginSupport describes how an optional plugin is wired for one ML2 mechanism.
+type neutronPluginSupport struct {
+	// servicePlugin is the entry appended to service_plugins, if any.
+	servicePlugin string
+	// extensionDriver is the entry appended to [ml2] extension_drivers, if any.
+	extensionDriver string
+	// serviceProvider is a [service_providers] service_provider line, if any.
+	serviceProvider string
+	// subproject is the neutron-db-manage subproject whose alembic branch must be migrated.
+	subproject string
+	// agent is the node agent the plugin needs, if any.
+	agent *neutronAgent
+}
+
+// neutronAgent describes an agent that runs as a DaemonSet on the OVN
+// gateway chassis, where it plugs into the node's Open vSwitch.
+type neutronAgent struct {
+	name         string
+	defaultImage string
+	command      []string
+	// configFile is the template rendered into the config Secret for this agent.
+	configFile string
+}
+
+// neutronPluginCatalog lists, per plugin, the mechanisms it is supported with.
+// A plugin missing an entry for a mechanism is rejected during validation.
+// ML2/OVS support is limited to plugins that do not need neutron-l3-agent
+// extensions, since the operator does not deploy the OVS agents.
+var neutronPluginCatalog = map[openstackv1alpha1.NeutronPlugin]map[string]neutronPluginSupport{
+	openstackv1alpha1.NeutronPluginQoS: {
+		"ovn": {servicePlugin: "qos", extensionDriver: "qos"},
+		"ovs": {servicePlugin: "qos", extensionDriver: "qos"},
+	},
+	openstackv1alpha1.NeutronPluginTrunk: {
+		"ovn": {servicePlugin: "trunk"},
+		"ovs": {servicePlugin: "trunk"},
+	},
+	openstackv1alpha1.NeutronPluginPortForwarding: {
+		"ovn": {servicePlugin: "port_forwarding"},
+	},
+	openstackv1alpha1.NeutronPluginFWaaSv2: {
+		"ovn": {
+			servicePlugin:   "firewall_v2",
+			serviceProvider: "FIREWALL_V2:fwaas_db:neutron_fwaas.services.firewall.service_drivers.ovn.firewall_l3_driver.OVNFwaasDriver:default",
+			subproject:      "neutron-fwaas",
+		},
+	},
+	openstackv1alpha1.NeutronPluginVPNaaS: {
+		"ovn": {
+			servicePlugin:   "ovn-vpnaas",
+			serviceProvider: "VPN:strongswan:neutron_vpnaas.services.vpn.service_drivers.ovn_ipsec.IPsecOvnVPNDriver:default",
+			subproject:      "neutron-vpnaas",
+			agent: &neutronAgent{
+				name:         "ovn-vpn-agent",
+				defaultImage: images.DefaultNeutronOVNVPNAgent,
+				command: []string{
+					"neutron-ovn-vpn-agent",
+					"--config-file", "/etc/neutron/neutron.conf",
+					"--config-file", "/etc/neutron/ovn_vpn_agent.ini",
+				},
+				configFile: "ovn_vpn_agent.ini",
+			},
+		},
+	},
+}
+
+// neutronPluginConfig is the merged configuration for all enabled plugins.
+type neutronPluginConfig struct {
+	ServicePlugins   []string
+	ExtensionDrivers []string
+	ServiceProviders []string
+	Subprojects      []string
+	Agents           []*neutronAgent
+}
+
+// resolveNeutronPlugins validates the requested plugins against the mechanism
+// and merges them with the base configuration for that mechanism.
+func resolveNeutronPlugins(mechanism string, plugins []openstackv1alpha1.NeutronPlugin) (*neutronPluginConfig, error) {
+	cfg := &neutronPluginConfig{
+		ExtensionDrivers: []string{"port_security"},
+	}
+	if mechanism == "ovs" {
+		cfg.ServicePlugins = []string{"router"}
+	} else {
+		cfg.ServicePlugins = []string{"ovn-router"}
+	}
+
+	var unsupported []string
+	seen := map[openstackv1alpha1.NeutronPlugin]bool{}
+	for _, p := range plugins {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		byMechanism, ok := neutronPluginCatalog[p]
+		if !ok {
+			unsupported = append(unsupported, fmt.Sprintf("%s (unknown plugin)", p))
+			continue
+		}
+		support, ok := byMechanism[mechanism]
+		if !ok {
+			unsupported = append(unsupported, fmt.Sprintf("%s (not supported with mechanism %s)", p, mechanism))
+			continue
+		}
+		if support.servicePlugin != "" {
+			cfg.ServicePlugins = append(cfg.ServicePlugins, support.servicePlugin)
+		}
+		if support.extensionDriver != "" {
+			cfg.ExtensionDrivers = append(cfg.ExtensionDrivers, support.extensionDriver)
+		}
+		if support.serviceProvider != "" {
+			cfg.ServiceProviders = append(cfg.ServiceProviders, support.serviceProvider)
+		}
+		if support.subproject != "" {
+			cfg.Subprojects = append(cfg.Subprojects, support.subproject)
+		}
+		if support.agent != nil {
+			cfg.Agents = append(cfg.Agents, support.agent)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, fmt.Errorf("invalid plugins: %s", strings.Join(unsupported, ", "))
+	}
+
+	// Keep rendering stable regardless of the order plugins were listed in.
+	sort.Strings(cfg.ServicePlugins[1:])
+	sort.Strings(cfg.ExtensionDrivers[1:])
+	sort.Strings(cfg.ServiceProviders)
+	sort.Strings(cfg.Subprojects)
+	sort.Slice(cfg.Agents, func(i, j int) bool { return cfg.Agents[i].name < cfg.Agents[j].name })
+	return cfg, nil
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+)
+
+func TestResolveNeutronPlugins(t *testing.T) {
+	tests := []struct {
+		name      string
+		mechanism string
+		plugins   []openstackv1alpha1.NeutronPlugin
+		want      *neutronPluginConfig
+		wantErr   string
+	}{
+		{
+			name:      "ovn base",
+			mechanism: "ovn",
+			want: &neutronPluginConfig{
+				ServicePlugins:   []string{"ovn-router"},
+				ExtensionDrivers: []string{"port_security"},
+			},
+		},
+		{
+			name:      "ovs base",
+			mechanism: "ovs",
+			want: &neutronPluginConfig{
+				ServicePlugins:   []string{"router"},
+				ExtensionDrivers: []string{"port_security"},
+			},
+		},
+		{
+			name:      "sorted after the router and deduplicated",
+			mechanism: "ovn",
+			plugins: []openstackv1alpha1.NeutronPlugin{
+				openstackv1alpha1.NeutronPluginTrunk,
+				openstackv1alpha1.NeutronPluginQoS,
+				openstackv1alpha1.NeutronPluginTrunk,
+				openstackv1alpha1.NeutronPluginPortForwarding,
+			},
+			want: &neutronPluginConfig{
+				ServicePlugins:   []string{"ovn-router", "port_forwarding", "qos", "trunk"},
+				ExtensionDrivers: []string{"port_security", "qos"},
+			},
+		},
+		{
+			name:      "providers, subprojects and agents",
+			mechanism: "ovn",
+			plugins: []openstackv1alpha1.NeutronPlugin{
+				openstackv1alpha1.NeutronPluginVPNaaS,
+				openstackv1alpha1.NeutronPluginFWaaSv2,
+			},
+			want: &neutronPluginConfig{
+				ServicePlugins:   []string{"ovn-router", "firewall_v2", "ovn-vpnaas"},
+				ExtensionDrivers: []string{"port_security"},
+				ServiceProviders: []string{
+					"FIREWALL_V2:fwaas_db:neutron_fwaas.services.firewall.service_drivers.ovn.firewall_l3_driver.OVNFwaasDriver:default",
+					"VPN:strongswan:neutron_vpnaas.services.vpn.service_drivers.ovn_ipsec.IPsecOvnVPNDriver:default",
+				},
+				Subprojects: []string{"neutron-fwaas", "neutron-vpnaas"},
+				Agents: []*neutronAgent{
+					neutronPluginCatalog[openstackv1alpha1.NeutronPluginVPNaaS]["ovn"].agent,
+				},
+			},
+		},
+		{
+			name:      "unsupported with mechanism",
+			mechanism: "ovs",
+			plugins: []openstackv1alpha1.NeutronPlugin{
+				openstackv1alpha1.NeutronPluginVPNaaS,
+				openstackv1alpha1.NeutronPluginQoS,
+				openstackv1alpha1.NeutronPluginFWaaSv2,
+			},
+			wantErr: "invalid plugins: fwaas_v2 (not supported with mechanism ovs), vpnaas (not supported with mechanism ovs)",
+		},
+		{
+			name:      "unknown plugin",
+			mechanism: "ovn",
+			plugins:   []openstackv1alpha1.NeutronPlugin{"lbaas"},
+			wantErr:   "invalid plugins: lbaas (unknown plugin)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveNeutronPlugins(tt.mechanism, tt.plugins)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeutronAgentImage(t *testing.T) {
+	agent := neutronPluginCatalog[openstackv1alpha1.NeutronPluginVPNaaS]["ovn"].agent
+	tests := []struct {
+		name string
+		spec openstackv1alpha1.NeutronSpec
+		want string
+	}{
+		{name: "default", want: agent.defaultImage},
+		{
+			name: "server image does not apply",
+			spec: openstackv1alpha1.NeutronSpec{ServiceTemplate: openstackv1alpha1.ServiceTemplate{Image: "example.com/neutron-server:custom"}},
+			want: agent.defaultImage,
+		},
+		{
+			name: "agent image",
+			spec: openstackv1alpha1.NeutronSpec{VPNAgentImage: "example.com/neutron-ovn-vpn-agent:custom"},
+			want: "example.com/neutron-ovn-vpn-agent:custom",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &openstackv1alpha1.Neutron{Spec: tt.spec}
+			if got := neutronAgentImage(instance, agent); got != tt.want {
+				t.Errorf("image = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
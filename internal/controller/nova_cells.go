@@ -0,0 +1,497 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+// novaCellLabel marks the resources that belong to one cell, so a removed
+// cell's conductors can be found after it has left the spec.
+const novaCellLabel = "openstack.k8s.io/nova-cell"
+
+// novaRemovalInterval is how often a blocked cell or compute host removal is retried.
+const novaRemovalInterval = time.Minute
+
+// nova-manage cell_v2 delete_cell exit codes when the cell is not empty.
+const (
+	deleteCellHasHosts            = 2
+	deleteCellHasInstances        = 3
+	deleteCellHasInstanceMappings = 4
+)
+
+// novaCellConnection is where a cell's database and message queue live.
+type novaCellConnection struct {
+	dbHost       string
+	dbRootSecret string
+	dbSecret     string
+	dbUser       string
+	mqHost       string
+	mqSecret     string
+	// mqVHost is the cell's own vhost on a broker it shares with the
+	// top-level services, whose conductor topic must not reach the cell's
+	// conductors; empty on a broker of its own.
+	mqVHost string
+}
+
+// novaCells returns the cells to deploy; without a Cells list this is a
+// single cell on the top-level database server and message queue.
+func novaCells(instance *openstackv1alpha1.Nova) []openstackv1alpha1.NovaCellSpec {
+	if len(instance.Spec.Cells) > 0 {
+		return instance.Spec.Cells
+	}
+	name := instance.Spec.CellName
+	if name == "" {
+		name = "cell1"
+	}
+	return []openstackv1alpha1.NovaCellSpec{{Name: name}}
+}
+
+// resolveCell finds the cell's MariaDB and RabbitMQ and the credentials to use.
+func (r *NovaReconciler) resolveCell(ctx context.Context, instance *openstackv1alpha1.Nova, conns *common.Connections, cell openstackv1alpha1.NovaCellSpec) (*novaCellConnection, error) {
+	cc := &novaCellConnection{
+		dbHost:       conns.MariaDBHost,
+		dbRootSecret: conns.MariaDBSecret,
+		dbSecret:     novaDBSecretName(instance),
+		dbUser:       "nova",
+		mqHost:       conns.RabbitMQHost,
+		mqSecret:     novaTopMQSecret(instance, conns),
+	}
+
+	if cell.DatabaseInstance != "" {
+		db := &openstackv1alpha1.MariaDB{}
+		if err := r.Get(ctx, client.ObjectKey{Name: cell.DatabaseInstance, Namespace: instance.Namespace}, db); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("MariaDB %s: %w", cell.DatabaseInstance, common.ErrDependencyNotReady)
+		}
+		if !common.IsReady(db.Status.Conditions) {
+			return nil, fmt.Errorf("MariaDB %s: %w", cell.DatabaseInstance, common.ErrDependencyNotReady)
+		}
+		cc.dbHost = fmt.Sprintf("%s.%s.svc", db.Name, instance.Namespace)
+		cc.dbRootSecret = fmt.Sprintf("%s-root-password", db.Name)
+	}
+	// A cell with its own credentials gets its own SQL user, so the password
+	// of the shared nova user is never changed underneath the other cells.
+	if cell.Database.SecretName != "" {
+		cc.dbSecret = cell.Database.SecretName
+		cc.dbUser = "nova_" + cell.Name
+	}
+
+	if cell.MessageQueueInstance != "" {
+		mq := &openstackv1alpha1.RabbitMQ{}
+		if err := r.Get(ctx, client.ObjectKey{Name: cell.MessageQueueInstance, Namespace: instance.Namespace}, mq); err != nil {
+			if !errors.IsNotFound(err) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("RabbitMQ %s: %w", cell.MessageQueueInstance, common.ErrDependencyNotReady)
+		}
+		if !common.IsReady(mq.Status.Conditions) {
+			return nil, fmt.Errorf("RabbitMQ %s: %w", cell.MessageQueueInstance, common.ErrDependencyNotReady)
+		}
+		cc.mqHost = fmt.Sprintf("%s.%s.svc", mq.Name, instance.Namespace)
+		cc.mqSecret = fmt.Sprintf("%s-credentials", mq.Name)
+	}
+	if cell.MessageQueue.SecretName != "" {
+		cc.mqSecret = cell.MessageQueue.SecretName
+	}
+	if cc.mqHost == conns.RabbitMQHost {
+		cc.mqVHost = "nova_" + cell.Name
+	}
+	return cc, nil
+}
+
+// ensureCell creates the cell database, renders the cell config, creates or
+// updates the cell mapping, migrates the database and deploys the cell's
+// conductor and console proxy. It returns a non-empty message while a step
+// is still in progress.
+func (r *NovaReconciler) ensureCell(ctx context.Context, instance *openstackv1alpha1.Nova, conns *common.Connections, cell openstackv1alpha1.NovaCellSpec, baseConfig map[string]any) (string, error) {
+	cc, err := r.resolveCell(ctx, instance, conns, cell)
+	if err != nil {
+		return "", err
+	}
+	prefix := instance.Name + "-" + cell.Name
+
+	if err := common.EnsureSecret(ctx, r.Client, cc.dbSecret, instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+		return "", err
+	}
+	if err := common.EnsureDatabase(ctx, r.Client, common.DatabaseParams{
+		Name:          prefix,
+		Namespace:     instance.Namespace,
+		DatabaseName:  "nova_" + cell.Name,
+		Username:      cc.dbUser,
+		SecretName:    cc.dbSecret,
+		MariaDBSecret: cc.dbRootSecret,
+		MariaDBHost:   cc.dbHost,
+	}, instance); err != nil {
+		return "", err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, prefix+"-db-create", instance.Namespace); err != nil {
+		return "", err
+	} else if !done {
+		return "waiting for database creation", nil
+	}
+	if cc.mqVHost != "" {
+		if err := common.EnsureVHost(ctx, r.Client, common.VHostParams{
+			Name:           prefix,
+			Namespace:      instance.Namespace,
+			VHost:          cc.mqVHost,
+			SecretName:     cc.mqSecret,
+			RabbitMQSecret: conns.RabbitMQSecret,
+			RabbitMQHost:   cc.mqHost,
+		}, instance); err != nil {
+			return "", err
+		}
+		if done, err := common.IsJobComplete(ctx, r.Client, prefix+"-mq-vhost", instance.Namespace); err != nil {
+			return "", err
+		} else if !done {
+			return "waiting for message queue vhost creation", nil
+		}
+	}
+
+	dbPassword, err := common.GetSecretValue(ctx, r.Client, cc.dbSecret, instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	mqUser, mqPassword, err := r.rabbitCredentials(ctx, instance, cc.mqSecret)
+	if err != nil {
+		return "", err
+	}
+	transportURL := common.VHostTransportURL(mqUser, mqPassword, cc.mqHost, cc.mqVHost)
+	databaseURL := common.DatabaseURL(cc.dbUser, dbPassword, cc.dbHost, "nova_"+cell.Name)
+	configSecret := prefix + "-config"
+	configHash, err := r.ensureConfig(ctx, instance, configSecret, novaCellLabels(instance.Name, cell.Name, "config"), withConfig(baseConfig, map[string]any{
+		"TransportURL":  transportURL,
+		"DatabaseURL":   databaseURL,
+		"NoVNCProxyURL": fmt.Sprintf("http://%s-novncproxy.%s.svc:%d/vnc_lite.html", prefix, instance.Namespace, novaNoVNCProxyPort),
+	}))
+	if err != nil {
+		return "", err
+	}
+
+	// The mapping job reruns whenever the cell's connections change, so the
+	// mapping in the API database always matches the cell config.
+	mappingHash := common.ConfigHash(map[string]string{"transport": transportURL, "database": databaseURL})
+	if done, err := r.ensureCellMapping(ctx, instance, cell.Name, configSecret, mappingHash); err != nil {
+		return "", err
+	} else if !done {
+		return "waiting for the cell mapping", nil
+	}
+
+	if err := common.EnsureDBSync(ctx, r.Client, common.DBSyncParams{
+		Name:         prefix,
+		Namespace:    instance.Namespace,
+		Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultNovaAPI),
+		Command:      []string{"nova-manage", "db", "sync", "--local_cell"},
+		SecretName:   cc.dbSecret,
+		Volumes:      []corev1.Volume{novaConfigVolume(configSecret)},
+		VolumeMounts: []corev1.VolumeMount{novaConfigVolumeMount()},
+	}, instance); err != nil {
+		return "", err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, prefix+"-db-sync", instance.Namespace); err != nil {
+		return "", err
+	} else if !done {
+		return "waiting for database migration", nil
+	}
+
+	for _, component := range novaCellComponents {
+		replicas := int32(1)
+		if component.name == "conductor" && cell.ConductorReplicas != nil {
+			replicas = *cell.ConductorReplicas
+		} else if component.name != "conductor" && instance.Spec.Replicas != nil {
+			replicas = *instance.Spec.Replicas
+		}
+		name := prefix + "-" + component.name
+		labels := novaCellLabels(instance.Name, cell.Name, component.name)
+		if err := r.ensureDeployment(ctx, instance, name, labels, component, replicas, configSecret, configHash); err != nil {
+			return "", err
+		}
+		if component.port != 0 {
+			if err := r.ensureService(ctx, instance, name, labels, component); err != nil {
+				return "", err
+			}
+		}
+	}
+	return "", nil
+}
+
+// ensureCellMapping runs a Job that creates the cell mapping, or updates its
+// transport URL and database connection from the cell config. Jobs for
+// earlier connection settings are removed.
+func (r *NovaReconciler) ensureCellMapping(ctx context.Context, instance *openstackv1alpha1.Nova, cell, configSecret, hash string) (bool, error) {
+	name := fmt.Sprintf("%s-%s-mapping-%s", instance.Name, cell, hash[:8])
+	labels := novaCellLabels(instance.Name, cell, "cell-mapping")
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: instance.Namespace}, job)
+	if err == nil {
+		return common.IsJobComplete(ctx, r.Client, name, instance.Namespace)
+	}
+	if !errors.IsNotFound(err) {
+		return false, err
+	}
+	if err := r.deleteCellJobs(ctx, instance, cell); err != nil {
+		return false, err
+	}
+
+	script := fmt.Sprintf(`set -e
+uuid=$(%s)
+if [ -z "$uuid" ]; then
+  nova-manage cell_v2 create_cell --name %s --verbose
+else
+  nova-manage cell_v2 update_cell --cell_uuid "$uuid"
+fi`, novaCellUUIDCommand(cell), cell)
+	backoffLimit := int32(4)
+	job = &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyOnFailure,
+					Volumes:       []corev1.Volume{novaConfigVolume(configSecret)},
+					Containers: []corev1.Container{
+						{
+							Name:         "cell-mapping",
+							Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultNovaAPI),
+							Command:      []string{"/bin/sh", "-c", script},
+							VolumeMounts: []corev1.VolumeMount{novaConfigVolumeMount()},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := controllerutil.SetControllerReference(instance, job, r.Scheme); err != nil {
+		return false, err
+	}
+	return false, r.Create(ctx, job)
+}
+
+// reconcileCellMappings refreshes Status.Cells from the cell mappings and
+// deletes the mapping and workloads of cells removed from the spec. nova-manage
+// refuses to delete a cell that still has hosts or instances; such cells keep
+// running and are returned with the reason.
+func (r *NovaReconciler) reconcileCellMappings(ctx context.Context, instance *openstackv1alpha1.Nova) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	pod, err := novaAPIPod(ctx, r.Client, instance)
+	if err != nil || pod == "" {
+		return nil, err
+	}
+	exec := func(script string) (string, error) {
+		return r.Executor.Exec(ctx, instance.Namespace, pod, "nova-api", []string{"/bin/sh", "-c", script})
+	}
+
+	out, err := exec("nova-manage cell_v2 list_cells")
+	if err != nil {
+		logger.Info("unable to list cells", "error", err.Error())
+		return nil, nil
+	}
+	uuids := map[string]string{}
+	for _, row := range parseNovaManageTable(out) {
+		if len(row) >= 2 {
+			uuids[row[0]] = row[1]
+		}
+	}
+	hosts := map[string]int32{}
+	if out, err := exec("nova-manage cell_v2 list_hosts"); err != nil {
+		logger.Info("unable to list cell hosts", "error", err.Error())
+	} else {
+		for _, row := range parseNovaManageTable(out) {
+			hosts[row[0]]++
+		}
+	}
+
+	desired := map[string]bool{}
+	var cells []openstackv1alpha1.NovaCellStatus
+	for _, cell := range novaCells(instance) {
+		desired[cell.Name] = true
+		cells = append(cells, openstackv1alpha1.NovaCellStatus{Name: cell.Name, UUID: uuids[cell.Name], Hosts: hosts[cell.Name]})
+	}
+
+	// Cells that left the spec are found through their labelled conductors
+	deploys := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deploys, client.InNamespace(instance.Namespace), client.MatchingLabels{"app.kubernetes.io/instance": instance.Name}, client.HasLabels{novaCellLabel}); err != nil {
+		return nil, err
+	}
+	removed := map[string]bool{}
+	for _, d := range deploys.Items {
+		if cell := d.Labels[novaCellLabel]; !desired[cell] {
+			removed[cell] = true
+		}
+	}
+
+	var blocked []string
+	for cell := range removed {
+		if uuid := uuids[cell]; uuid != "" {
+			out, err := exec(fmt.Sprintf(`nova-manage cell_v2 delete_cell --cell_uuid %s >/dev/null 2>&1; echo "$?"`, uuid))
+			if err != nil {
+				return nil, err
+			}
+			reason, err := deleteCellBlocked(cell, out, hosts[cell])
+			if err != nil {
+				return nil, err
+			}
+			if reason != "" {
+				blocked = append(blocked, reason)
+				cells = append(cells, openstackv1alpha1.NovaCellStatus{Name: cell, UUID: uuid, Hosts: hosts[cell], Removing: true})
+				continue
+			}
+		}
+		logger.Info("removing Nova cell", "cell", cell)
+		if err := r.deleteCellResources(ctx, instance, cell); err != nil {
+			return nil, err
+		}
+	}
+	instance.Status.Cells = cells
+	return blocked, nil
+}
+
+// deleteCellBlocked interprets the exit status printed after nova-manage
+// cell_v2 delete_cell. It returns why a cell that is not empty was kept, or
+// "" once its mapping is deleted. Any other status is an error, so that the
+// workloads of a cell are never removed while its mapping may remain.
+func deleteCellBlocked(cell, status string, hosts int32) (string, error) {
+	switch strings.TrimSpace(status) {
+	case "0":
+		return "", nil
+	case fmt.Sprint(deleteCellHasHosts):
+		return fmt.Sprintf("%s has %d compute hosts", cell, hosts), nil
+	case fmt.Sprint(deleteCellHasInstances):
+		return fmt.Sprintf("%s has instances", cell), nil
+	case fmt.Sprint(deleteCellHasInstanceMappings):
+		return fmt.Sprintf("%s has mappings of deleted instances (archive them with nova-manage db archive_deleted_rows)", cell), nil
+	default:
+		return "", fmt.Errorf("deleting cell %s: nova-manage exited with %q", cell, strings.TrimSpace(status))
+	}
+}
+
+// deleteCellResources removes the conductors, compute DaemonSet, services,
+// configs and mapping jobs of a cell. The cell database is kept.
+func (r *NovaReconciler) deleteCellResources(ctx context.Context, instance *openstackv1alpha1.Nova, cell string) error {
+	selector := []client.ListOption{
+		client.InNamespace(instance.Namespace),
+		client.MatchingLabels{"app.kubernetes.io/instance": instance.Name, novaCellLabel: cell},
+	}
+	deploys := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deploys, selector...); err != nil {
+		return err
+	}
+	for i := range deploys.Items {
+		if err := r.Delete(ctx, &deploys.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	daemonSets := &appsv1.DaemonSetList{}
+	if err := r.List(ctx, daemonSets, selector...); err != nil {
+		return err
+	}
+	for i := range daemonSets.Items {
+		if err := r.Delete(ctx, &daemonSets.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, selector...); err != nil {
+		return err
+	}
+	for i := range services.Items {
+		if err := r.Delete(ctx, &services.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, selector...); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		if err := r.Delete(ctx, &secrets.Items[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return r.deleteCellJobs(ctx, instance, cell)
+}
+
+// deleteCellJobs removes a cell's mapping jobs.
+func (r *NovaReconciler) deleteCellJobs(ctx context.Context, instance *openstackv1alpha1.Nova, cell string) error {
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(instance.Namespace), client.MatchingLabels(novaCellLabels(instance.Name, cell, "cell-mapping"))); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if err := r.Delete(ctx, &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// novaAPIPod returns a running nova-api pod to run nova-manage in, or "" if none is ready.
+func novaAPIPod(ctx context.Context, c client.Client, instance *openstackv1alpha1.Nova) (string, error) {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(instance.Namespace), client.MatchingLabels(labelsForNova(instance.Name, "api"))); err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				return pod.Name, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// novaCellUUIDCommand prints the UUID of the named cell mapping, or nothing.
+func novaCellUUIDCommand(cell string) string {
+	return fmt.Sprintf(`nova-manage cell_v2 list_cells | awk -F'|' -v cell=%s '{n=$2; u=$3; gsub(/ /, "", n); gsub(/ /, "", u)} n == cell {print u}'`, cell)
+}
+
+// parseNovaManageTable returns the data rows of a PrettyTable printed by
+// nova-manage, without the header row.
+func parseNovaManageTable(out string) [][]string {
+	var rows [][]string
+	header := true
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "|") {
+			continue
+		}
+		if header {
+			header = false
+			continue
+		}
+		var row []string
+		for _, field := range strings.Split(strings.Trim(line, "|"), "|") {
+			row = append(row, strings.TrimSpace(field))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func novaCellLabels(name, cell, component string) map[string]string {
+	labels := labelsForNova(name, cell+"-"+component)
+	labels[novaCellLabel] = cell
+	return labels
+}
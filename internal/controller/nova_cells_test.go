@@ -0,0 +1,298 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func cellMappingJobs(t *testing.T, c client.Client) []string {
+	t.Helper()
+	jobs := &batchv1.JobList{}
+	if err := c.List(context.Background(), jobs, client.InNamespace("openstack")); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, job := range jobs.Items {
+		names = append(names, job.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestNovaEnsureCellMapping(t *testing.T) {
+	ctx := context.Background()
+	instance := computeNova(nil)
+	instance.UID = "nova-uid"
+	// A mapping job of another cell is left alone.
+	other := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name: "nova-cell2-mapping-00000000", Namespace: "openstack", Labels: novaCellLabels("nova", "cell2", "cell-mapping"),
+	}}
+	c := newFakeClient(t, instance, other)
+	r := &NovaReconciler{Client: c, Scheme: testScheme}
+
+	done, err := r.ensureCellMapping(ctx, instance, "cell1", "nova-cell1-config", "aaaaaaaaffff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done {
+		t.Error("done before the Job ran")
+	}
+	job := &batchv1.Job{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "nova-cell1-mapping-aaaaaaaa", Namespace: "openstack"}, job); err != nil {
+		t.Fatal(err)
+	}
+	script := strings.Join(job.Spec.Template.Spec.Containers[0].Command, " ")
+	for _, want := range []string{"create_cell --name cell1", `update_cell --cell_uuid "$uuid"`, "-v cell=cell1"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script does not contain %q:\n%s", want, script)
+		}
+	}
+	if volume := job.Spec.Template.Spec.Volumes[0]; volume.Secret == nil || volume.Secret.SecretName != "nova-cell1-config" {
+		t.Errorf("config volume = %+v", volume)
+	}
+
+	// The same connections wait for the same Job.
+	if done, err := r.ensureCellMapping(ctx, instance, "cell1", "nova-cell1-config", "aaaaaaaaffff"); err != nil || done {
+		t.Fatalf("done = %v, error = %v", done, err)
+	}
+	completeJob(t, c, "nova-cell1-mapping-aaaaaaaa")
+	if done, err := r.ensureCellMapping(ctx, instance, "cell1", "nova-cell1-config", "aaaaaaaaffff"); err != nil || !done {
+		t.Fatalf("done = %v, error = %v, want the mapping done", done, err)
+	}
+
+	// Changed connections rerun the mapping and drop the Job of the old ones.
+	if done, err := r.ensureCellMapping(ctx, instance, "cell1", "nova-cell1-config", "bbbbbbbbffff"); err != nil || done {
+		t.Fatalf("done = %v, error = %v", done, err)
+	}
+	want := []string{"nova-cell1-mapping-bbbbbbbb", "nova-cell2-mapping-00000000"}
+	if got := cellMappingJobs(t, c); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("jobs = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteCellBlocked(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		wantReason string
+		wantErr    string
+	}{
+		{name: "deleted", status: "0\n"},
+		{name: "hosts", status: "2\n", wantReason: "cell2 has 3 compute hosts"},
+		{name: "instances", status: "3\n", wantReason: "cell2 has instances"},
+		{name: "deleted instances", status: "4\n", wantReason: "archive_deleted_rows"},
+		// nova-manage also exits 1 on errors, so a missing cell is not
+		// told apart from a failed deletion.
+		{name: "not found or failed", status: "1\n", wantErr: `exited with "1"`},
+		{name: "no status", status: "", wantErr: `exited with ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := deleteCellBlocked("cell2", tt.status, 3)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantReason == "" && reason != "" || !strings.Contains(reason, tt.wantReason) {
+				t.Errorf("reason = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestNovaResolveCellMessageQueue(t *testing.T) {
+	ready := common.SetCondition(nil, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "", 0)
+	conns := &common.Connections{RabbitMQHost: "rabbitmq.openstack.svc", RabbitMQSecret: "rabbitmq-credentials"}
+	topURL := common.TransportURL("nova", "secret", conns.RabbitMQHost)
+
+	tests := []struct {
+		name      string
+		cell      openstackv1alpha1.NovaCellSpec
+		wantHost  string
+		wantVHost string
+	}{
+		// The super-conductor listens on the conductor topic of the API
+		// broker; a cell sharing that broker must not receive its RPCs.
+		{name: "shared broker", cell: openstackv1alpha1.NovaCellSpec{Name: "cell1"}, wantHost: "rabbitmq.openstack.svc", wantVHost: "nova_cell1"},
+		{name: "shared broker named", cell: openstackv1alpha1.NovaCellSpec{Name: "cell2", MessageQueueInstance: "rabbitmq"}, wantHost: "rabbitmq.openstack.svc", wantVHost: "nova_cell2"},
+		{name: "own broker", cell: openstackv1alpha1.NovaCellSpec{Name: "cell3", MessageQueueInstance: "rabbitmq-cell3"}, wantHost: "rabbitmq-cell3.openstack.svc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []client.Object
+			for _, name := range []string{"rabbitmq", "rabbitmq-cell3"} {
+				objs = append(objs, &openstackv1alpha1.RabbitMQ{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openstack"},
+					Status:     openstackv1alpha1.RabbitMQStatus{CommonStatus: openstackv1alpha1.CommonStatus{Conditions: ready}},
+				})
+			}
+			r := &NovaReconciler{Client: newFakeClient(t, objs...), Scheme: testScheme}
+
+			cc, err := r.resolveCell(context.Background(), computeNova(nil), conns, tt.cell)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cc.mqHost != tt.wantHost || cc.mqVHost != tt.wantVHost {
+				t.Errorf("message queue = %s vhost %q, want %s vhost %q", cc.mqHost, cc.mqVHost, tt.wantHost, tt.wantVHost)
+			}
+			if cellURL := common.VHostTransportURL("nova", "secret", cc.mqHost, cc.mqVHost); cellURL == topURL {
+				t.Errorf("cell transport URL %s is the top-level one", cellURL)
+			}
+		})
+	}
+}
+
+func TestNovaEnsureCellVHost(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t)
+	r := &NovaReconciler{Client: c, Scheme: testScheme}
+	instance := computeNova(nil)
+	conns := &common.Connections{
+		MariaDBHost: "mariadb.openstack.svc", MariaDBSecret: "mariadb-root-password",
+		RabbitMQHost: "rabbitmq.openstack.svc", RabbitMQSecret: "rabbitmq-credentials",
+	}
+	cell := openstackv1alpha1.NovaCellSpec{Name: "cell1"}
+
+	if msg, err := r.ensureCell(ctx, instance, conns, cell, nil); err != nil || msg != "waiting for database creation" {
+		t.Fatalf("ensureCell = %q, %v", msg, err)
+	}
+	completeJob(t, c, "nova-cell1-db-create")
+	// The cell config is not rendered before its vhost exists.
+	if msg, err := r.ensureCell(ctx, instance, conns, cell, nil); err != nil || msg != "waiting for message queue vhost creation" {
+		t.Fatalf("ensureCell = %q, %v", msg, err)
+	}
+	job := &batchv1.Job{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "nova-cell1-mq-vhost", Namespace: "openstack"}, job); err != nil {
+		t.Fatal(err)
+	}
+	if script := job.Spec.Template.Spec.Containers[0].Command[2]; !strings.Contains(script, "http://rabbitmq.openstack.svc:15672/api/vhosts/nova_cell1") ||
+		!strings.Contains(script, "/permissions/nova_cell1/$SERVICE_USER") {
+		t.Errorf("script = %s", script)
+	}
+	if err := c.Get(ctx, client.ObjectKey{Name: "nova-cell1-config", Namespace: "openstack"}, &corev1.Secret{}); err == nil {
+		t.Error("cell config rendered before the vhost exists")
+	}
+}
+
+func TestNovaDeleteCellResources(t *testing.T) {
+	ctx := context.Background()
+	instance := computeNova(nil)
+	var objs []client.Object
+	for _, cell := range []string{"cell1", "cell2"} {
+		labels := novaCellLabels("nova", cell, "conductor")
+		objs = append(objs,
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nova-" + cell + "-conductor", Namespace: "openstack", Labels: labels}},
+			&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "nova-" + cell + "-compute", Namespace: "openstack", Labels: novaCellLabels("nova", cell, "compute")}},
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "nova-" + cell + "-novncproxy", Namespace: "openstack", Labels: novaCellLabels("nova", cell, "novncproxy")}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "nova-" + cell + "-config", Namespace: "openstack", Labels: novaCellLabels("nova", cell, "config")}},
+			&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "nova-" + cell + "-mapping-00000000", Namespace: "openstack", Labels: novaCellLabels("nova", cell, "cell-mapping")}},
+			// The database and its password are kept.
+			&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "nova-" + cell + "-db-create", Namespace: "openstack"}},
+		)
+	}
+	c := newFakeClient(t, objs...)
+	r := &NovaReconciler{Client: c, Scheme: testScheme}
+
+	if err := r.deleteCellResources(ctx, instance, "cell2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj.DeepCopyObject().(client.Object))
+		removed := strings.HasPrefix(obj.GetName(), "nova-cell2-") && obj.GetName() != "nova-cell2-db-create"
+		if removed != (err != nil) {
+			t.Errorf("%T %s: get = %v, want removed %v", obj, obj.GetName(), err, removed)
+		}
+	}
+}
+
+func TestNovaReconcileCellMappingsWithoutAPI(t *testing.T) {
+	instance := computeNova(nil)
+	instance.Status.Cells = []openstackv1alpha1.NovaCellStatus{{Name: "cell1", UUID: "uuid-1"}}
+	// An API pod that is not ready is not exec'd into; the Executor is nil.
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "nova-api-0", Namespace: "openstack", Labels: labelsForNova("nova", "api")},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	r := &NovaReconciler{Client: newFakeClient(t, pod), Scheme: testScheme}
+
+	blocked, err := r.reconcileCellMappings(context.Background(), instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocked != nil {
+		t.Errorf("blocked = %v", blocked)
+	}
+	if len(instance.Status.Cells) != 1 || instance.Status.Cells[0].UUID != "uuid-1" {
+		t.Errorf("cells = %+v, want the last known cells kept", instance.Status.Cells)
+	}
+}
+
+func TestNovaAPIPod(t *testing.T) {
+	ready := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	pod := func(name string, phase corev1.PodPhase, conditions []corev1.PodCondition) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openstack", Labels: labelsForNova("nova", "api")},
+			Status:     corev1.PodStatus{Phase: phase, Conditions: conditions},
+		}
+	}
+	terminating := pod("nova-api-2", corev1.PodRunning, ready)
+	terminating.Finalizers = []string{"test"}
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+
+	tests := []struct {
+		name string
+		pods []client.Object
+		want string
+	}{
+		{name: "none"},
+		{name: "pending", pods: []client.Object{pod("nova-api-0", corev1.PodPending, nil)}},
+		{name: "not ready", pods: []client.Object{pod("nova-api-0", corev1.PodRunning, nil)}},
+		{name: "terminating", pods: []client.Object{terminating}},
+		{name: "ready", pods: []client.Object{pod("nova-api-0", corev1.PodRunning, nil), pod("nova-api-1", corev1.PodRunning, ready)}, want: "nova-api-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := novaAPIPod(context.Background(), newFakeClient(t, tt.pods...), computeNova(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("pod = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNovaManageTable(t *testing.T) {
+	out := `+-------+--------------------------------------+----------------+
+|  Name |                 UUID                 | Transport URL  |
++-------+--------------------------------------+----------------+
+| cell0 | 00000000-0000-0000-0000-000000000000 |     none:/     |
+| cell1 | 4d6ff8e5-28c0-4ef4-9e4c-c9d2f1c6c001 | rabbit://****@ |
++-------+--------------------------------------+----------------+
+`
+	rows := parseNovaManageTable(out)
+	if len(rows) != 2 || rows[1][0] != "cell1" || rows[1][1] != "4d6ff8e5-28c0-4ef4-9e4c-c9d2f1c6c001" {
+		t.Errorf("rows = %q", rows)
+	}
+	if rows := parseNovaManageTable(""); rows != nil {
+		t.Errorf("rows of no output = %q", rows)
+	}
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// novaCephUser is the Ceph user for ephemeral disks and volume attachments.
+const novaCephUser = "nova"
+
+// novaCephConfig is the template data for the libvirt Ceph secret and RBD
+// ephemeral disks.
+type novaCephConfig struct {
+	// Pool is the ephemeral disk pool, empty when only volumes use Ceph.
+	Pool       string
+	User       string
+	SecretUUID string
+	// Config and Keyring are the ceph.conf and keyring files.
+	Config  string
+	Keyring string
+}
+
+// cinderCephPools returns the pools of the ceph backends of the Cinders in
+// the namespace, empty for a backend using the default pool.
+func (r *NovaReconciler) cinderCephPools(ctx context.Context, namespace string) ([]string, error) {
+	cinders := &openstackv1alpha1.CinderList{}
+	if err := r.List(ctx, cinders, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var pools []string
+	for _, cinder := range cinders.Items {
+		for _, backend := range cinder.Spec.Backends {
+			if backend.Type == "ceph" {
+				pools = append(pools, backend.CephPoolName)
+			}
+		}
+	}
+	return pools, nil
+}
+
+// ensureCeph creates the client.nova Ceph user and the Secret shared by the
+// in-cluster computes and Cinder. It is needed with Ceph ephemeral storage
+// and whenever Cinder has ceph backends, whose volumes computes attach with
+// the libvirt secret; volumePools are the pools of those backends, as
+// returned by cinderCephPools. The libvirt secret UUID is generated once and
+// kept, as running instances reference it in their domain XML.
+func (r *NovaReconciler) ensureCeph(ctx context.Context, instance *openstackv1alpha1.Nova, volumePools []string) (*novaCephConfig, error) {
+	ceph, err := common.GetCephStorage(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var pool string
+	if instance.Spec.EphemeralStorage == "ceph" {
+		if pool, err = common.CephPoolName(ceph, "nova-ephemeral"); err != nil {
+			return nil, err
+		}
+	}
+	// Volumes are attached with Nova's credentials, and images are cloned
+	// from Glance's pool.
+	defaultVolumes, _ := common.CephPoolName(ceph, "cinder-volumes")
+	writable := map[string]bool{defaultVolumes: true}
+	for _, volumes := range volumePools {
+		if volumes == "" {
+			volumes = defaultVolumes
+		}
+		writable[volumes] = true
+	}
+	pools := []string{pool}
+	for name := range writable {
+		pools = append(pools, name)
+	}
+	sort.Strings(pools[1:])
+	images, _ := common.CephPoolName(ceph, "glance-images")
+	cephClient, err := common.EnsureCephClient(ctx, r.Client, ceph, novaCephUser,
+		common.CephRBDCaps(pools, []string{images}))
+	if err != nil {
+		return nil, err
+	}
+
+	name := instance.Name + "-ceph"
+	var secretUUID string
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secretUUID = string(secret.Data["uuid"])
+		if secretUUID == "" {
+			secretUUID = string(uuid.NewUUID())
+		}
+		secret.Labels = labelsForNova(instance.Name, "ceph")
+		secret.Data = map[string][]byte{
+			"ceph.conf": []byte(cephClient.Config),
+			"ceph.client." + novaCephUser + ".keyring": []byte(cephClient.Keyring()),
+			"key":  []byte(cephClient.Key),
+			"uuid": []byte(secretUUID),
+		}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return nil, err
+	}
+	instance.Status.CephSecretName = name
+
+	return &novaCephConfig{
+		Pool:       pool,
+		User:       novaCephUser,
+		SecretUUID: secretUUID,
+		Config:     cephClient.Config,
+		Keyring:    cephClient.Keyring(),
+	}, nil
+}
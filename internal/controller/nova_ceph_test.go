@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// externalCephStorage is an external CephStorage whose keyring Secret holds
+// client.nova and client.cinder.
+func externalCephStorage(pools ...openstackv1alpha1.CephPool) []client.Object {
+	return []client.Object{
+		&openstackv1alpha1.CephStorage{
+			ObjectMeta: metav1.ObjectMeta{Name: "ceph", Namespace: "openstack"},
+			Spec: openstackv1alpha1.CephStorageSpec{
+				Mode: "external",
+				External: &openstackv1alpha1.ExternalCephConfig{
+					KeyringSecretName: "ceph-keyring",
+					MonitorHosts:      "192.0.2.1:6789",
+				},
+				Pools: pools,
+			},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ceph-keyring", Namespace: "openstack"},
+			Data:       map[string][]byte{"keyring": []byte("[client.nova]\n\tkey = AQBnova==\n[client.cinder]\n\tkey = AQBcinder==\n")},
+		},
+	}
+}
+
+func TestNovaEnsureCeph(t *testing.T) {
+	ephemeral := openstackv1alpha1.CephPool{Name: "vms", Purpose: "nova-ephemeral"}
+	cephVolumes := &openstackv1alpha1.Cinder{
+		ObjectMeta: metav1.ObjectMeta{Name: "cinder", Namespace: "openstack"},
+		Spec: openstackv1alpha1.CinderSpec{Backends: []openstackv1alpha1.CinderBackend{
+			{Name: "lvm", Type: "lvm", LVMVolumeGroup: "cinder-volumes"},
+			{Name: "rbd", Type: "ceph", CephPoolName: "volumes"},
+		}},
+	}
+	tests := []struct {
+		name      string
+		ephemeral string
+		objs      []client.Object
+		wantPool  string
+		wantErr   error
+	}{
+		{name: "no CephStorage", ephemeral: "ceph", wantErr: common.ErrDependencyNotReady},
+		{name: "no ephemeral pool", ephemeral: "ceph", objs: externalCephStorage(), wantErr: common.ErrCephPoolMissing},
+		{name: "external cluster", ephemeral: "ceph", objs: externalCephStorage(ephemeral), wantPool: "vms"},
+		{name: "ceph volumes with local ephemeral storage", ephemeral: "local", objs: append(externalCephStorage(), cephVolumes)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := &NovaReconciler{Client: newFakeClient(t, tt.objs...), Scheme: testScheme}
+			instance := &openstackv1alpha1.Nova{
+				ObjectMeta: metav1.ObjectMeta{Name: "nova", Namespace: "openstack", UID: "nova-uid"},
+				Spec:       openstackv1alpha1.NovaSpec{EphemeralStorage: tt.ephemeral},
+			}
+
+			volumePools, err := r.cinderCephPools(ctx, "openstack")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := r.ensureCeph(ctx, instance, volumePools)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if instance.Status.CephSecretName != "" {
+					t.Errorf("CephSecretName = %q before the Secret exists", instance.Status.CephSecretName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Pool != tt.wantPool || cfg.User != "nova" || cfg.SecretUUID == "" {
+				t.Fatalf("config = %+v", cfg)
+			}
+			// Computes define the libvirt secret either way, but only put
+			// ephemeral disks in Ceph when asked to.
+			conf, err := common.RenderTemplate("nova/nova-compute.conf", map[string]any{"Ceph": cfg})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(conf, "rbd_secret_uuid = "+cfg.SecretUUID) {
+				t.Errorf("nova-compute.conf has no rbd_secret_uuid:\n%s", conf)
+			}
+			if got := strings.Contains(conf, "images_type = rbd"); got != (tt.wantPool != "") {
+				t.Errorf("nova-compute.conf has images_type rbd = %v:\n%s", got, conf)
+			}
+			secret := &corev1.Secret{}
+			if err := r.Get(ctx, client.ObjectKey{Name: instance.Status.CephSecretName, Namespace: "openstack"}, secret); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(secret.Data["uuid"]); got != cfg.SecretUUID {
+				t.Errorf("Secret uuid = %q, want %q", got, cfg.SecretUUID)
+			}
+			if got := string(secret.Data["key"]); got != "AQBnova==" {
+				t.Errorf("Secret key = %q", got)
+			}
+
+			// Running instances reference the UUID in their domain XML, so
+			// it is kept across reconciles.
+			again, err := r.ensureCeph(ctx, instance, volumePools)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if again.SecretUUID != cfg.SecretUUID {
+				t.Errorf("UUID changed from %s to %s", cfg.SecretUUID, again.SecretUUID)
+			}
+		})
+	}
+}
+
+func TestCinderEnsureCephNova(t *testing.T) {
+	nova := func(name, cephSecret string) client.Object {
+		return &openstackv1alpha1.Nova{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openstack"},
+			Spec:       openstackv1alpha1.NovaSpec{EphemeralStorage: "local"},
+			Status:     openstackv1alpha1.NovaStatus{CephSecretName: cephSecret},
+		}
+	}
+	novaSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "nova-ceph", Namespace: "openstack"},
+		Data:       map[string][]byte{"uuid": []byte("0b1c5e2a-9f0e-4c1e-8d5c-5a1f2f6b9e11")},
+	}
+	tests := []struct {
+		name    string
+		objs    []client.Object
+		wantErr error
+	}{
+		{name: "no Nova", wantErr: common.ErrDependencyNotReady},
+		{name: "Nova without Ceph Secret", objs: []client.Object{nova("nova", "")}, wantErr: common.ErrDependencyNotReady},
+		{name: "two Novas", objs: []client.Object{nova("nova", "nova-ceph"), nova("other", "other-ceph"), novaSecret}, wantErr: errCinderBackendInvalid},
+		{name: "Nova with local ephemeral storage", objs: []client.Object{nova("nova", "nova-ceph"), novaSecret}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			objs := append(externalCephStorage(openstackv1alpha1.CephPool{Name: "volumes", Purpose: "cinder-volumes"}), tt.objs...)
+			c := newFakeClient(t, objs...)
+			ceph, err := common.GetCephStorage(ctx, c, "openstack")
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &CinderReconciler{Client: c, Scheme: testScheme}
+			instance := &openstackv1alpha1.Cinder{ObjectMeta: metav1.ObjectMeta{Name: "cinder", Namespace: "openstack", UID: "cinder-uid"}}
+
+			cfg, err := r.ensureCeph(ctx, instance, ceph, []*cinderBackendConfig{{Name: "rbd", Type: "ceph", CephPool: "volumes"}})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.SecretUUID != "0b1c5e2a-9f0e-4c1e-8d5c-5a1f2f6b9e11" || cfg.User != "cinder" {
+				t.Errorf("config = %+v", cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,582 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	// novaComputeNodeLabel assigns a node to the compute DaemonSet of one
+	// cell. Its value is <namespace>.<nova>.<cell>, or h<hash>.<cell> with
+	// a hash of the namespace and Nova name where that would not fit in a
+	// label value.
+	novaComputeNodeLabel = "openstack.k8s.io/nova-compute"
+
+	// novaComputeRemovedAnnotation marks a node whose compute pod is being
+	// stopped; its Nova service record is deleted once the pod is gone.
+	novaComputeRemovedAnnotation = "openstack.k8s.io/nova-compute-removed"
+)
+
+// reconcileCompute assigns up to ComputeReplicas nodes to the cells' compute
+// DaemonSets, filling cells in spec order with the nodes matching their
+// selectors, and runs nova-compute with libvirt on them. A node that is no
+// longer wanted keeps its compute pod while it hosts instances. It returns
+// the blocked removals and whether compute pods are still settling.
+func (r *NovaReconciler) reconcileCompute(ctx context.Context, instance *openstackv1alpha1.Nova, conns *common.Connections, baseConfig map[string]any) ([]string, bool, error) {
+	logger := log.FromContext(ctx)
+
+	want := 1
+	if instance.Spec.ComputeReplicas != nil {
+		want = int(*instance.Spec.ComputeReplicas)
+	}
+	cells := novaCells(instance)
+	cellValues := map[string]string{}
+	for _, cell := range cells {
+		cellValues[novaComputeLabelValue(instance, cell.Name)] = cell.Name
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return nil, false, err
+	}
+	sort.Slice(nodes.Items, func(i, j int) bool { return nodes.Items[i].Name < nodes.Items[j].Name })
+
+	// Keep current assignments that are still eligible, then fill up with
+	// unassigned schedulable nodes.
+	desired := map[string]string{}
+	for _, node := range nodes.Items {
+		cell, ok := cellValues[node.Labels[novaComputeNodeLabel]]
+		if ok && len(desired) < want && novaNodeMatchesCell(instance, cellSpec(cells, cell), &node) {
+			desired[node.Name] = cell
+		}
+	}
+	for _, cell := range cells {
+		for _, node := range nodes.Items {
+			if len(desired) >= want {
+				break
+			}
+			if _, taken := desired[node.Name]; taken || node.Labels[novaComputeNodeLabel] != "" || node.Spec.Unschedulable {
+				continue
+			}
+			if novaNodeMatchesCell(instance, cell, &node) {
+				desired[node.Name] = cell.Name
+			}
+		}
+	}
+
+	osc := &openstackClientOnce{r: r, instance: instance, conns: conns}
+	var blocked []string
+	var computes []openstackv1alpha1.NovaComputeStatus
+	settling := false
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		value := node.Labels[novaComputeNodeLabel]
+		cell, ours := novaComputeLabelCell(instance, value)
+
+		switch {
+		case desired[node.Name] != "" && value == novaComputeLabelValue(instance, desired[node.Name]):
+			computes = append(computes, openstackv1alpha1.NovaComputeStatus{Node: node.Name, AvailabilityZone: node.Labels[openstackv1alpha1.AvailabilityZoneNodeLabel], Cell: cell})
+
+		case desired[node.Name] != "":
+			// Newly assigned node
+			if err := r.patchComputeNode(ctx, node, novaComputeLabelValue(instance, desired[node.Name]), false); err != nil {
+				return nil, false, err
+			}
+			computes = append(computes, openstackv1alpha1.NovaComputeStatus{Node: node.Name, AvailabilityZone: node.Labels[openstackv1alpha1.AvailabilityZoneNodeLabel], Cell: desired[node.Name]})
+
+		case ours:
+			// No longer wanted: stop the compute pod only once it is empty
+			api, err := osc.get(ctx)
+			if err != nil {
+				return nil, false, err
+			}
+			hasInstances, err := novaHostHasInstances(ctx, api, node.Name)
+			if err != nil {
+				return nil, false, err
+			}
+			if hasInstances {
+				blocked = append(blocked, node.Name)
+				computes = append(computes, openstackv1alpha1.NovaComputeStatus{Node: node.Name, AvailabilityZone: node.Labels[openstackv1alpha1.AvailabilityZoneNodeLabel], Cell: cell, Removing: true})
+				continue
+			}
+			logger.Info("removing compute host", "node", node.Name, "cell", cell)
+			if err := r.patchComputeNode(ctx, node, value, true); err != nil {
+				return nil, false, err
+			}
+			settling = true
+
+		case novaComputeLabelOurs(instance, node.Annotations[novaComputeRemovedAnnotation]):
+			// The node left; delete its service record once the pod is gone
+			running, err := r.computePodOnNode(ctx, instance, node.Name)
+			if err != nil {
+				return nil, false, err
+			}
+			if running {
+				settling = true
+				continue
+			}
+			api, err := osc.get(ctx)
+			if err != nil {
+				return nil, false, err
+			}
+			if err := novaDeleteComputeService(ctx, api, node.Name); err != nil {
+				return nil, false, err
+			}
+			patch := client.MergeFrom(node.DeepCopy())
+			delete(node.Annotations, novaComputeRemovedAnnotation)
+			if err := r.Patch(ctx, node, patch); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: novaComputeServiceAccount(instance), Namespace: instance.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, sa, func() error {
+		sa.Labels = labelsForNova(instance.Name, "compute")
+		return controllerutil.SetControllerReference(instance, sa, r.Scheme)
+	}); err != nil {
+		return nil, false, err
+	}
+	for _, cell := range cells {
+		if err := r.ensureComputeDaemonSet(ctx, instance, conns, cell, baseConfig); err != nil {
+			return nil, false, err
+		}
+	}
+
+	ready, err := r.computePodsReady(ctx, instance)
+	if err != nil {
+		return nil, false, err
+	}
+	for i := range computes {
+		computes[i].Ready = ready[computes[i].Node]
+		if !computes[i].Ready {
+			settling = true
+		}
+	}
+	instance.Status.Computes = computes
+	return blocked, settling, nil
+}
+
+// openstackClientOnce authenticates to Keystone on first use only, so
+// reconciles that remove no compute host make no API calls.
+type openstackClientOnce struct {
+	r        *NovaReconciler
+	instance *openstackv1alpha1.Nova
+	conns    *common.Connections
+	client   *common.OpenStackClient
+}
+
+func (o *openstackClientOnce) get(ctx context.Context) (*common.OpenStackClient, error) {
+	if o.client == nil {
+		c, err := common.NewOpenStackClient(ctx, o.r.Client, o.instance.Namespace, o.conns)
+		if err != nil {
+			return nil, err
+		}
+		o.client = c.WithMicroversion("compute", "2.53")
+	}
+	return o.client, nil
+}
+
+// patchComputeNode labels a node for a cell's compute DaemonSet, or removes
+// the label and marks the node for service record cleanup.
+func (r *NovaReconciler) patchComputeNode(ctx context.Context, node *corev1.Node, value string, remove bool) error {
+	patch := client.MergeFrom(node.DeepCopy())
+	if node.Labels == nil {
+		node.Labels = map[string]string{}
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	if remove {
+		delete(node.Labels, novaComputeNodeLabel)
+		node.Annotations[novaComputeRemovedAnnotation] = value
+	} else {
+		node.Labels[novaComputeNodeLabel] = value
+		delete(node.Annotations, novaComputeRemovedAnnotation)
+	}
+	return r.Patch(ctx, node, patch)
+}
+
+// ensureComputeDaemonSet renders the cell's compute config and runs
+// nova-compute, libvirtd and virtlogd on the nodes assigned to the cell.
+func (r *NovaReconciler) ensureComputeDaemonSet(ctx context.Context, instance *openstackv1alpha1.Nova, conns *common.Connections, cell openstackv1alpha1.NovaCellSpec, baseConfig map[string]any) error {
+	cc, err := r.resolveCell(ctx, instance, conns, cell)
+	if err != nil {
+		return err
+	}
+	mqUser, mqPassword, err := r.rabbitCredentials(ctx, instance, cc.mqSecret)
+	if err != nil {
+		return err
+	}
+	prefix := instance.Name + "-" + cell.Name
+	virtType := instance.Spec.VirtType
+	if virtType == "" {
+		virtType = "kvm"
+	}
+	data := withConfig(baseConfig, map[string]any{
+		"TransportURL":  common.VHostTransportURL(mqUser, mqPassword, cc.mqHost, cc.mqVHost),
+		"VirtType":      virtType,
+		"NoVNCProxyURL": fmt.Sprintf("http://%s-novncproxy.%s.svc:%d/vnc_lite.html", prefix, instance.Namespace, novaNoVNCProxyPort),
+	})
+	files := map[string]string{}
+	for name, template := range map[string]string{
+		"nova.conf":             "nova/nova-compute.conf",
+		"nova-compute.sh":       "nova/nova-compute.sh",
+		"nova-compute-ready.py": "nova/nova-compute-ready.py",
+		"libvirtd.conf":         "nova/libvirtd.conf",
+		"libvirtd.sh":           "nova/libvirtd.sh",
+	} {
+		rendered, err := common.RenderTemplate(template, data)
+		if err != nil {
+			return err
+		}
+		files[name] = rendered
+	}
+	var cephMounts []corev1.VolumeMount
+	if ceph, ok := baseConfig["Ceph"].(*novaCephConfig); ok {
+		keyring := "ceph.client." + ceph.User + ".keyring"
+		files["ceph.conf"] = ceph.Config
+		files[keyring] = ceph.Keyring
+		cephMounts = []corev1.VolumeMount{
+			{Name: "config", MountPath: "/etc/ceph/ceph.conf", SubPath: "ceph.conf", ReadOnly: true},
+			{Name: "config", MountPath: "/etc/ceph/" + keyring, SubPath: keyring, ReadOnly: true},
+		}
+	}
+	var migrationMounts, sshMounts []corev1.VolumeMount
+	migration, _ := baseConfig["Migration"].(*novaMigrationConfig)
+	if migration != nil {
+		scripts := map[string]string{"migration-addr.sh": "nova/migration-addr.sh"}
+		switch migration.Transport {
+		case "ssh":
+			scripts["sshd_config"] = "nova/sshd_config"
+			scripts["nova-ssh.sh"] = "nova/nova-ssh.sh"
+		case "tls":
+			scripts["migration-cert.py"] = "nova/migration-cert.py"
+		}
+		for name, template := range scripts {
+			rendered, err := common.RenderTemplate(template, data)
+			if err != nil {
+				return err
+			}
+			files[name] = rendered
+		}
+		migrationMounts = []corev1.VolumeMount{
+			{Name: "config", MountPath: "/usr/local/bin/migration-addr.sh", SubPath: "migration-addr.sh", ReadOnly: true},
+		}
+		if migration.Transport == "tls" {
+			migrationMounts = append(migrationMounts, corev1.VolumeMount{
+				Name: "config", MountPath: "/usr/local/bin/migration-cert.py", SubPath: "migration-cert.py", ReadOnly: true,
+			})
+		}
+		for name, content := range migration.Files {
+			files["migration-"+name] = content
+			migrationMounts = append(migrationMounts, corev1.VolumeMount{
+				Name: "config", MountPath: "/etc/nova/migration/" + name, SubPath: "migration-" + name, ReadOnly: true,
+			})
+		}
+		sshMounts = append([]corev1.VolumeMount{
+			{Name: "config", MountPath: "/usr/local/bin/nova-ssh.sh", SubPath: "nova-ssh.sh", ReadOnly: true},
+			{Name: "config", MountPath: "/etc/nova/sshd_config", SubPath: "sshd_config", ReadOnly: true},
+			{Name: "run-libvirt", MountPath: "/run/libvirt"},
+		}, migrationMounts...)
+	}
+	configSecret := prefix + "-compute-config"
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: configSecret, Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = novaCellLabels(instance.Name, cell.Name, "compute-config")
+		secret.Data = map[string][]byte{}
+		for name, content := range files {
+			secret.Data[name] = []byte(content)
+		}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return err
+	}
+
+	volumes := []corev1.Volume{
+		novaConfigVolume(configSecret),
+		hostPathVolume("run-libvirt", "/run/libvirt"),
+		hostPathVolume("var-lib-libvirt", "/var/lib/libvirt"),
+		hostPathVolume("var-log-libvirt", "/var/log/libvirt"),
+		hostPathVolume("var-lib-nova", "/var/lib/nova"),
+		hostPathVolume("run-openvswitch", "/run/openvswitch"),
+		hostPathVolume("dev", "/dev"),
+		hostPathVolume("sys-fs-cgroup", "/sys/fs/cgroup"),
+		hostPathVolume("lib-modules", "/lib/modules"),
+	}
+	hostMounts := []corev1.VolumeMount{
+		{Name: "run-libvirt", MountPath: "/run/libvirt"},
+		{Name: "var-lib-libvirt", MountPath: "/var/lib/libvirt"},
+		{Name: "var-log-libvirt", MountPath: "/var/log/libvirt"},
+		{Name: "var-lib-nova", MountPath: "/var/lib/nova"},
+		{Name: "run-openvswitch", MountPath: "/run/openvswitch"},
+		{Name: "dev", MountPath: "/dev"},
+		{Name: "sys-fs-cgroup", MountPath: "/sys/fs/cgroup"},
+		{Name: "lib-modules", MountPath: "/lib/modules", ReadOnly: true},
+	}
+	libvirtMounts := append([]corev1.VolumeMount{
+		{Name: "config", MountPath: "/etc/libvirt/libvirtd.conf", SubPath: "libvirtd.conf", ReadOnly: true},
+		{Name: "config", MountPath: "/usr/local/bin/libvirtd.sh", SubPath: "libvirtd.sh", ReadOnly: true},
+	}, hostMounts...)
+	libvirtMounts = append(libvirtMounts, cephMounts...)
+	libvirtMounts = append(libvirtMounts, migrationMounts...)
+	computeMounts := append([]corev1.VolumeMount{novaConfigVolumeMount()}, hostMounts...)
+	computeMounts = append(computeMounts, cephMounts...)
+
+	nodeEnv := []corev1.EnvVar{
+		{Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}},
+		{Name: "HOST_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"}}},
+	}
+	privileged := true
+	rootUser := int64(0)
+	securityContext := &corev1.SecurityContext{Privileged: &privileged, RunAsUser: &rootUser}
+	labels := novaCellLabels(instance.Name, cell.Name, "compute")
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: prefix + "-compute", Namespace: instance.Namespace},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, ds, func() error {
+		ds.Labels = labels
+		if ds.CreationTimestamp.IsZero() {
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		ds.Spec.Template.Labels = labels
+		ds.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: common.ConfigHash(files)}
+		ds.Spec.Template.Spec.NodeSelector = map[string]string{novaComputeNodeLabel: novaComputeLabelValue(instance, cell.Name)}
+		ds.Spec.Template.Spec.ServiceAccountName = novaComputeServiceAccount(instance)
+		ds.Spec.Template.Spec.HostNetwork = true
+		ds.Spec.Template.Spec.HostPID = true
+		ds.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		ds.Spec.Template.Spec.Volumes = volumes
+		ds.Spec.Template.Spec.Containers = []corev1.Container{
+			{
+				Name:            "libvirtd",
+				Image:           images.DefaultNovaLibvirt,
+				Command:         []string{"/bin/sh", "/usr/local/bin/libvirtd.sh"},
+				Env:             nodeEnv,
+				VolumeMounts:    libvirtMounts,
+				SecurityContext: securityContext,
+			},
+			{
+				Name:            "virtlogd",
+				Image:           images.DefaultNovaLibvirt,
+				Command:         []string{"/usr/sbin/virtlogd"},
+				VolumeMounts:    hostMounts,
+				SecurityContext: securityContext,
+			},
+			{
+				Name:            "nova-compute",
+				Image:           images.ImageOrDefault(instance.Spec.Image, images.DefaultNovaCompute),
+				Command:         []string{"/bin/sh", "/etc/nova/nova-compute.sh"},
+				Env:             nodeEnv,
+				Resources:       instance.Spec.Resources,
+				VolumeMounts:    computeMounts,
+				SecurityContext: securityContext,
+				// Ready once nova-compute is connected to the message
+				// queue, not when the container starts.
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						Exec: &corev1.ExecAction{Command: []string{"python3", "/etc/nova/nova-compute-ready.py"}},
+					},
+					InitialDelaySeconds: 15,
+					PeriodSeconds:       30,
+					TimeoutSeconds:      10,
+				},
+			},
+		}
+		if migration != nil && migration.Transport == "ssh" {
+			ds.Spec.Template.Spec.Containers = append(ds.Spec.Template.Spec.Containers, corev1.Container{
+				Name:            "nova-ssh",
+				Image:           images.DefaultNovaSSH,
+				Command:         []string{"/bin/sh", "/usr/local/bin/nova-ssh.sh"},
+				VolumeMounts:    sshMounts,
+				SecurityContext: securityContext,
+			})
+		}
+		return controllerutil.SetControllerReference(instance, ds, r.Scheme)
+	})
+	return err
+}
+
+// computePods lists the compute pods of this Nova.
+func (r *NovaReconciler) computePods(ctx context.Context, instance *openstackv1alpha1.Nova) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(instance.Namespace), client.MatchingLabels{"app.kubernetes.io/instance": instance.Name, "app.kubernetes.io/name": "nova"}, client.HasLabels{novaCellLabel}); err != nil {
+		return nil, err
+	}
+	var computes []corev1.Pod
+	for _, pod := range pods.Items {
+		if strings.HasSuffix(pod.Labels["app.kubernetes.io/component"], "-compute") {
+			computes = append(computes, pod)
+		}
+	}
+	return computes, nil
+}
+
+// computePodsReady returns, per node, whether its compute pod is ready.
+func (r *NovaReconciler) computePodsReady(ctx context.Context, instance *openstackv1alpha1.Nova) (map[string]bool, error) {
+	pods, err := r.computePods(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	ready := map[string]bool{}
+	for _, pod := range pods {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				ready[pod.Spec.NodeName] = true
+			}
+		}
+	}
+	return ready, nil
+}
+
+// computePodOnNode reports whether a compute pod of this Nova still exists on the node.
+func (r *NovaReconciler) computePodOnNode(ctx context.Context, instance *openstackv1alpha1.Nova, node string) (bool, error) {
+	pods, err := r.computePods(ctx, instance)
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == node {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// novaHostHasInstances reports whether any instance, in any project, is on host.
+func novaHostHasInstances(ctx context.Context, osc *common.OpenStackClient, host string) (bool, error) {
+	var resp struct {
+		Servers []struct {
+			ID string `json:"id"`
+		} `json:"servers"`
+	}
+	path := "/servers?all_tenants=1&limit=1&host=" + url.QueryEscape(host)
+	if err := osc.Do(ctx, http.MethodGet, "compute", path, nil, &resp); err != nil {
+		return false, err
+	}
+	return len(resp.Servers) > 0, nil
+}
+
+// novaDeleteComputeService deletes the nova-compute service record of host,
+// which also removes its host mapping and resource provider.
+func novaDeleteComputeService(ctx context.Context, osc *common.OpenStackClient, host string) error {
+	var resp struct {
+		Services []struct {
+			ID string `json:"id"`
+		} `json:"services"`
+	}
+	path := "/os-services?binary=nova-compute&host=" + url.QueryEscape(host)
+	if err := osc.Do(ctx, http.MethodGet, "compute", path, nil, &resp); err != nil {
+		return err
+	}
+	for _, svc := range resp.Services {
+		if err := osc.Do(ctx, http.MethodDelete, "compute", "/os-services/"+svc.ID, nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// novaNodeMatchesCell reports whether the node matches both the Nova and
+// the cell compute node selectors.
+func novaNodeMatchesCell(instance *openstackv1alpha1.Nova, cell openstackv1alpha1.NovaCellSpec, node *corev1.Node) bool {
+	nodeLabels := labels.Set(node.Labels)
+	return labels.SelectorFromSet(instance.Spec.ComputeNodeSelector).Matches(nodeLabels) &&
+		labels.SelectorFromSet(cell.ComputeNodeSelector).Matches(nodeLabels)
+}
+
+func cellSpec(cells []openstackv1alpha1.NovaCellSpec, name string) openstackv1alpha1.NovaCellSpec {
+	for _, cell := range cells {
+		if cell.Name == name {
+			return cell
+		}
+	}
+	return openstackv1alpha1.NovaCellSpec{Name: name}
+}
+
+// novaComputeServiceAccount names the service account of the compute pods.
+func novaComputeServiceAccount(instance *openstackv1alpha1.Nova) string {
+	return instance.Name + "-compute"
+}
+
+// novaComputeLabelValue is the node label value assigning a node to a cell.
+// Label values are limited to 63 characters, so a namespace and Nova name
+// too long to spell out are replaced by a hash.
+func novaComputeLabelValue(instance *openstackv1alpha1.Nova, cell string) string {
+	value := fmt.Sprintf("%s.%s.%s", instance.Namespace, instance.Name, cell)
+	if len(value) <= validation.LabelValueMaxLength {
+		return value
+	}
+	return novaComputeLabelHashPrefix(instance) + cell
+}
+
+// novaComputeLabelHashPrefix is the prefix of the label values of a Nova
+// whose namespace and name are too long for the label.
+func novaComputeLabelHashPrefix(instance *openstackv1alpha1.Nova) string {
+	sum := sha256.Sum256([]byte(instance.Namespace + "/" + instance.Name))
+	return fmt.Sprintf("h%x.", sum[:8])
+}
+
+// novaComputeLabelCell returns the cell a node label value of this Nova
+// assigns the node to, and false if the value is not one of this Nova.
+func novaComputeLabelCell(instance *openstackv1alpha1.Nova, value string) (string, bool) {
+	for _, prefix := range []string{instance.Namespace + "." + instance.Name + ".", novaComputeLabelHashPrefix(instance)} {
+		if cell, ok := strings.CutPrefix(value, prefix); ok && !strings.Contains(cell, ".") {
+			return cell, true
+		}
+	}
+	return "", false
+}
+
+// novaComputeLabelOurs reports whether a node label value is one of this
+// Nova.
+func novaComputeLabelOurs(instance *openstackv1alpha1.Nova, value string) bool {
+	_, ours := novaComputeLabelCell(instance, value)
+	return ours
+}
+
+// novaForComputeNode returns the Nova among novas that a node is assigned
+// to, or nil.
+func novaForComputeNode(novas []openstackv1alpha1.Nova, node client.Object) *openstackv1alpha1.Nova {
+	value := node.GetLabels()[novaComputeNodeLabel]
+	for i := range novas {
+		if novaComputeLabelOurs(&novas[i], value) {
+			return &novas[i]
+		}
+	}
+	return nil
+}
+
+// novasForNode maps a node to every Nova, since any of them may assign it.
+func (r *NovaReconciler) novasForNode(ctx context.Context, _ client.Object) []reconcile.Request {
+	novas := &openstackv1alpha1.NovaList{}
+	if err := r.List(ctx, novas); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(novas.Items))
+	for _, nova := range novas.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nova)})
+	}
+	return requests
+}
@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+)
+
+func TestNovaComputeLabelValue(t *testing.T) {
+	long := strings.Repeat("n", 40)
+	tests := []struct {
+		name      string
+		namespace string
+		nova      string
+		cell      string
+		want      string
+	}{
+		{name: "spelled out", namespace: "openstack", nova: "nova", cell: "cell1", want: "openstack.nova.cell1"},
+		{name: "at the limit", namespace: long, nova: "nova", cell: strings.Repeat("c", 17), want: long + ".nova." + strings.Repeat("c", 17)},
+		{name: "too long", namespace: long, nova: "nova", cell: strings.Repeat("c", 18)},
+		{name: "long nova name", namespace: "openstack", nova: long + "." + long, cell: "cell1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &openstackv1alpha1.Nova{ObjectMeta: metav1.ObjectMeta{Name: tt.nova, Namespace: tt.namespace}}
+			value := novaComputeLabelValue(instance, tt.cell)
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				t.Fatalf("value %q: %v", value, errs)
+			}
+			if tt.want != "" && value != tt.want {
+				t.Errorf("value = %q, want %q", value, tt.want)
+			}
+			if cell, ours := novaComputeLabelCell(instance, value); !ours || cell != tt.cell {
+				t.Errorf("cell of %q = %q (ours %v), want %q", value, cell, ours, tt.cell)
+			}
+			other := &openstackv1alpha1.Nova{ObjectMeta: metav1.ObjectMeta{Name: tt.nova + "x", Namespace: tt.namespace}}
+			if novaComputeLabelOurs(other, value) {
+				t.Errorf("value %q is also one of Nova %s", value, other.Name)
+			}
+		})
+	}
+}
+
+func TestNovaComputeLabelCell(t *testing.T) {
+	instance := &openstackv1alpha1.Nova{ObjectMeta: metav1.ObjectMeta{Name: "nova", Namespace: "openstack"}}
+	tests := []struct {
+		value    string
+		wantCell string
+		wantOurs bool
+	}{
+		{value: "openstack.nova.cell1", wantCell: "cell1", wantOurs: true},
+		{value: novaComputeLabelHashPrefix(instance) + "cell1", wantCell: "cell1", wantOurs: true},
+		{value: "openstack.nova.x.cell1"},
+		{value: "other.nova.cell1"},
+		{value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cell, ours := novaComputeLabelCell(instance, tt.value)
+			if cell != tt.wantCell || ours != tt.wantOurs {
+				t.Errorf("cell = %q, ours = %v, want %q, %v", cell, ours, tt.wantCell, tt.wantOurs)
+			}
+		})
+	}
+}
@@ -0,0 +1,686 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	novaAPIPort        = 8774
+	novaMetadataPort   = 8775
+	novaNoVNCProxyPort = 6080
+)
+
+// novaComponent describes one Nova control-plane Deployment.
+type novaComponent struct {
+	name         string
+	defaultImage string
+	command      []string
+	// port is exposed through a Service named <nova>-<name>; 0 means none.
+	port int32
+	// httpProbe selects an HTTP readiness probe on port instead of TCP.
+	httpProbe bool
+}
+
+// novaComponents run once per Nova; the conductor is the super-conductor.
+var novaComponents = []novaComponent{
+	{name: "api", defaultImage: images.DefaultNovaAPI, command: []string{"nova-api-os-compute"}, port: novaAPIPort, httpProbe: true},
+	{name: "scheduler", defaultImage: images.DefaultNovaScheduler, command: []string{"nova-scheduler"}},
+	{name: "conductor", defaultImage: images.DefaultNovaConductor, command: []string{"nova-conductor"}},
+	{name: "metadata", defaultImage: images.DefaultNovaAPI, command: []string{"nova-api-metadata"}, port: novaMetadataPort, httpProbe: true},
+}
+
+// novaCellComponents run once per cell. Console tokens live in the cell
+// database, so each cell needs its own proxy.
+var novaCellComponents = []novaComponent{
+	{name: "conductor", defaultImage: images.DefaultNovaConductor, command: []string{"nova-conductor"}},
+	{name: "novncproxy", defaultImage: images.DefaultNovaNoVNCProxy, command: []string{"nova-novncproxy"}, port: novaNoVNCProxyPort},
+}
+
+// NovaReconciler reconciles a Nova object.
+type NovaReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Executor *common.PodExecutor
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=novas,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=novas/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=novas/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=ovnnetworks,verbs=get;list;watch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackdataplanes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=mariadbs;rabbitmqs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=cephstorages,verbs=get;list;watch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=cinders,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ceph.rook.io,resources=cephclients,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackcontrolplanes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments;daemonsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=certificatesigningrequests/approval;certificatesigningrequests/status,verbs=update
+// +kubebuilder:rbac:groups=certificates.k8s.io,resources=signers,resourceNames=openstack.k8s.io/nova-live-migration,verbs=approve;sign
+
+func (r *NovaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.Nova{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion: cluster-scoped objects are not garbage collected with
+	// the Nova.
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			if err := r.deleteMigrationCSRAccess(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "Reconciling", "Reconciliation in progress", instance.Generation,
+	)
+
+	conns, err := common.ResolveConnections(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	metadataSecret, err := r.metadataSecret(ctx, instance)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Ensure generated credentials
+	if err := common.EnsureSecret(ctx, r.Client, novaDBSecretName(instance), instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := common.EnsureSecret(ctx, r.Client, novaServiceSecretName(instance), instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Ensure the API and cell0 databases; cell databases follow per cell
+	databases := []struct{ suffix, name string }{
+		{"api", "nova_api"},
+		{"cell0", "nova_cell0"},
+	}
+	for _, db := range databases {
+		name := fmt.Sprintf("%s-%s", instance.Name, db.suffix)
+		if err := common.EnsureDatabase(ctx, r.Client, common.DatabaseParams{
+			Name:          name,
+			Namespace:     instance.Namespace,
+			DatabaseName:  db.name,
+			Username:      "nova",
+			SecretName:    novaDBSecretName(instance),
+			MariaDBSecret: conns.MariaDBSecret,
+			MariaDBHost:   conns.MariaDBHost,
+		}, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		if done, err := common.IsJobComplete(ctx, r.Client, name+"-db-create", instance.Namespace); err != nil {
+			return ctrl.Result{}, err
+		} else if !done {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "CreatingDatabase", fmt.Sprintf("Waiting for database %s", db.name))
+		}
+	}
+
+	baseConfig, err := r.baseConfigData(ctx, instance, conns, metadataSecret)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Computes define the libvirt Ceph secret for RBD ephemeral disks and
+	// for the volumes of Cinder's ceph backends.
+	volumePools, err := r.cinderCephPools(ctx, instance.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if instance.Spec.EphemeralStorage == "ceph" || len(volumePools) > 0 {
+		cephConfig, err := r.ensureCeph(ctx, instance, volumePools)
+		switch {
+		case errors.Is(err, common.ErrCephPoolMissing):
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionComputeReady), "CephPoolMissing", err.Error())
+		case errors.Is(err, common.ErrDependencyNotReady):
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionComputeReady), "WaitingForCeph", err.Error())
+		case err != nil:
+			return ctrl.Result{}, err
+		}
+		baseConfig["Ceph"] = cephConfig
+	} else {
+		instance.Status.CephSecretName = ""
+	}
+	migration, err := r.ensureMigration(ctx, instance)
+	switch {
+	case errors.Is(err, errNoMigrationIssuer):
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionMigrationReady), "MigrationIssuerMissing", err.Error())
+	case errors.Is(err, common.ErrDependencyNotReady):
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionMigrationReady), "WaitingForMigrationCA", err.Error())
+	case err != nil:
+		return ctrl.Result{}, err
+	}
+	if migration != nil {
+		baseConfig["Migration"] = migration
+	}
+	mqUser, mqPassword, err := r.rabbitCredentials(ctx, instance, novaTopMQSecret(instance, conns))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	dbPassword, err := common.GetSecretValue(ctx, r.Client, novaDBSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// Top-level services use the API message queue; their main database is
+	// cell0, as for a super-conductor.
+	topConfig := withConfig(baseConfig, map[string]any{
+		"TransportURL": common.TransportURL(mqUser, mqPassword, conns.RabbitMQHost),
+		"DatabaseURL":  common.DatabaseURL("nova", dbPassword, conns.MariaDBHost, "nova_cell0"),
+	})
+	configHash, err := r.ensureConfig(ctx, instance, instance.Name+"-config", labelsForNova(instance.Name, "config"), topConfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Migrate the API database, then map and migrate cell0. Each step needs
+	// the previous one.
+	mapCell0 := fmt.Sprintf(`nova-manage cell_v2 list_cells | grep -q " cell0 " || nova-manage cell_v2 map_cell0 --database_connection "%s"`,
+		common.DatabaseURL("nova", "${DB_PASSWORD}", conns.MariaDBHost, "nova_cell0"))
+	syncJobs := []common.DBSyncParams{
+		{Name: instance.Name + "-api", Command: []string{"nova-manage", "api_db", "sync"}},
+		{Name: instance.Name + "-cell0-mapping", Command: []string{"/bin/sh", "-c", mapCell0}},
+		{Name: instance.Name + "-cell0", Command: []string{"nova-manage", "db", "sync", "--local_cell"}},
+	}
+	for _, params := range syncJobs {
+		params.Namespace = instance.Namespace
+		params.Image = images.ImageOrDefault(instance.Spec.Image, images.DefaultNovaAPI)
+		params.SecretName = novaDBSecretName(instance)
+		params.Volumes = []corev1.Volume{novaConfigVolume(instance.Name + "-config")}
+		params.VolumeMounts = []corev1.VolumeMount{novaConfigVolumeMount()}
+		if err := common.EnsureDBSync(ctx, r.Client, params, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		if done, err := common.IsJobComplete(ctx, r.Client, params.Name+"-db-sync", instance.Namespace); err != nil {
+			return ctrl.Result{}, err
+		} else if !done {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "SyncingDatabase", fmt.Sprintf("Waiting for %s-db-sync", params.Name))
+		}
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDatabaseReady), metav1.ConditionTrue, "DatabaseSynced", "API and cell0 databases migrated", instance.Generation,
+	)
+
+	// Create, map and migrate every cell, then run its conductors
+	for _, cell := range novaCells(instance) {
+		waiting, err := r.ensureCell(ctx, instance, conns, cell, baseConfig)
+		if err != nil {
+			if errors.Is(err, common.ErrDependencyNotReady) {
+				return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionCellsReady), "WaitingForDependencies", fmt.Sprintf("Cell %s: %v", cell.Name, err))
+			}
+			return ctrl.Result{}, err
+		}
+		if waiting != "" {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionCellsReady), "MappingCell", fmt.Sprintf("Cell %s: %s", cell.Name, waiting))
+		}
+	}
+
+	// Ensure control-plane services
+	for _, component := range novaComponents {
+		replicas := int32(1)
+		if instance.Spec.Replicas != nil {
+			replicas = *instance.Spec.Replicas
+		}
+		name := instance.Name + "-" + component.name
+		labels := labelsForNova(instance.Name, component.name)
+		if err := r.ensureDeployment(ctx, instance, name, labels, component, replicas, instance.Name+"-config", configHash); err != nil {
+			return ctrl.Result{}, err
+		}
+		if component.port != 0 {
+			if err := r.ensureService(ctx, instance, name, labels, component); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	apiEndpoint := fmt.Sprintf("http://%s-api.%s.svc:%d/v2.1", instance.Name, instance.Namespace, novaAPIPort)
+	if err := common.EnsureKeystoneEndpoint(ctx, r.Client, common.EndpointParams{
+		Name:              instance.Name,
+		Namespace:         instance.Namespace,
+		ServiceName:       "nova",
+		ServiceType:       "compute",
+		InternalURL:       apiEndpoint,
+		PublicURL:         apiEndpoint,
+		AdminURL:          apiEndpoint,
+		Region:            conns.Region,
+		KeystoneSecret:    conns.KeystoneSecret,
+		KeystoneURL:       conns.KeystoneURL,
+		BootstrapImage:    images.DefaultKeystone,
+		ServiceUser:       "nova",
+		ServiceUserSecret: novaServiceSecretName(instance),
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-endpoint-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "RegisteringEndpoint", "Waiting for Keystone endpoint registration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady), metav1.ConditionTrue, "EndpointRegistered", "Keystone service, user and endpoints registered", instance.Generation,
+	)
+	instance.Status.APIEndpoint = apiEndpoint
+
+	// Report per-component readiness
+	var names []string
+	for _, component := range novaComponents {
+		names = append(names, component.name)
+	}
+	for _, cell := range novaCells(instance) {
+		for _, component := range novaCellComponents {
+			names = append(names, cell.Name+"-"+component.name)
+		}
+	}
+	var notReady []string
+	instance.Status.Components = make([]openstackv1alpha1.NovaComponentStatus, 0, len(names))
+	for _, name := range names {
+		deploy := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-" + name, Namespace: instance.Namespace}, deploy); err != nil {
+			return ctrl.Result{}, err
+		}
+		ready := common.IsDeploymentReady(deploy)
+		if !ready {
+			notReady = append(notReady, name)
+		}
+		instance.Status.Components = append(instance.Status.Components, openstackv1alpha1.NovaComponentStatus{
+			Name:          name,
+			Ready:         ready,
+			ReadyReplicas: deploy.Status.ReadyReplicas,
+		})
+	}
+	if len(notReady) > 0 {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "DeploymentNotReady", fmt.Sprintf("Waiting for %v", notReady))
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDeploymentReady), metav1.ConditionTrue, "DeploymentReady", "All Nova components are available", instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "Nova is ready", instance.Generation,
+	)
+
+	// Run compute on the assigned nodes. Compute hosts come and go without
+	// affecting the control plane, so they are reported separately from Ready.
+	result := ctrl.Result{}
+	blockedComputes, settling, err := r.reconcileCompute(ctx, instance, conns, baseConfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	switch {
+	case len(blockedComputes) > 0:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionComputeReady), metav1.ConditionFalse, "ComputeRemovalBlocked",
+			fmt.Sprintf("Nodes no longer selected still host instances: %s", strings.Join(blockedComputes, ", ")), instance.Generation,
+		)
+		result.RequeueAfter = novaRemovalInterval
+	case settling:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionComputeReady), metav1.ConditionFalse, "ComputeNotReady", "Waiting for compute pods", instance.Generation,
+		)
+		result.RequeueAfter = 10 * time.Second
+	default:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionComputeReady), metav1.ConditionTrue, "ComputeReady",
+			fmt.Sprintf("%d compute hosts ready", len(instance.Status.Computes)), instance.Generation,
+		)
+	}
+
+	// Data plane computes get their host configuration from the Secret
+	// published for their provisioning; they define the libvirt Ceph secret
+	// once provisioning reports it installed.
+	pendingDataPlane, err := r.ensureDataPlaneSecrets(ctx, instance, baseConfig)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	switch {
+	case instance.Status.CephSecretName == "":
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionCephSecretReady), metav1.ConditionTrue, "CephNotUsed", "Neither ephemeral storage nor volumes use Ceph", instance.Generation,
+		)
+	case len(pendingDataPlane) > 0:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionCephSecretReady), metav1.ConditionFalse, "DataPlaneComputesPending",
+			fmt.Sprintf("Data plane computes have not installed the libvirt Ceph secret: %s", strings.Join(pendingDataPlane, ", ")), instance.Generation,
+		)
+	default:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionCephSecretReady), metav1.ConditionTrue, "CephSecretDefined", "All compute hosts define the libvirt Ceph secret", instance.Generation,
+		)
+	}
+
+	// All hosts trust each other once every compute pod runs the current
+	// credentials and data plane provisioning has installed them.
+	if migration == nil {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionMigrationReady), metav1.ConditionTrue, "MigrationDisabled", "Live migration is not configured", instance.Generation,
+		)
+	} else if meshReady, err := r.migrationMeshReady(ctx, instance, migration); err != nil {
+		return ctrl.Result{}, err
+	} else if !meshReady {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionMigrationReady), metav1.ConditionFalse, "MigrationMeshIncomplete", "Waiting for compute hosts to pick up migration credentials", instance.Generation,
+		)
+		if result.RequeueAfter == 0 {
+			result.RequeueAfter = 10 * time.Second
+		}
+	} else if len(pendingDataPlane) > 0 {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionMigrationReady), metav1.ConditionFalse, "DataPlaneComputesPending",
+			fmt.Sprintf("Data plane computes have not installed the migration credentials: %s", strings.Join(pendingDataPlane, ", ")), instance.Generation,
+		)
+	} else {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionMigrationReady), metav1.ConditionTrue, "MigrationMeshComplete",
+			fmt.Sprintf("All compute hosts share %s migration credentials", migration.Transport), instance.Generation,
+		)
+	}
+
+	// Sync cell status and remove cells dropped from the spec. A blocked
+	// removal does not affect the rest of the deployment, so Nova stays Ready.
+	blocked, err := r.reconcileCellMappings(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(blocked) > 0 {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionCellsReady), metav1.ConditionFalse, "CellRemovalBlocked",
+			fmt.Sprintf("Removed cells still have compute hosts or instances: %s", strings.Join(blocked, "; ")), instance.Generation,
+		)
+		result.RequeueAfter = novaRemovalInterval
+	} else {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionCellsReady), metav1.ConditionTrue, "CellsMapped", "All cells are mapped", instance.Generation,
+		)
+	}
+
+	if err := r.updateStatus(ctx, instance); err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// waitFor records a not-yet-satisfied condition and requeues.
+func (r *NovaReconciler) waitFor(ctx context.Context, instance *openstackv1alpha1.Nova, condType, reason, message string) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(instance.Status.Conditions, condType, metav1.ConditionFalse, reason, message, instance.Generation)
+	if condType != string(openstackv1alpha1.ConditionReady) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+func (r *NovaReconciler) updateStatus(ctx context.Context, instance *openstackv1alpha1.Nova) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	return r.Status().Update(ctx, instance)
+}
+
+// metadataSecret returns the metadata proxy shared secret published by the
+// OVNNetwork. It is only required while compute runs inside Kubernetes, which
+// is when the OVNNetwork deploys metadata agents; otherwise an empty string
+// disables the metadata proxy configuration.
+func (r *NovaReconciler) metadataSecret(ctx context.Context, instance *openstackv1alpha1.Nova) (string, error) {
+	ovns := &openstackv1alpha1.OVNNetworkList{}
+	if err := r.List(ctx, ovns, client.InNamespace(instance.Namespace)); err != nil {
+		return "", err
+	}
+	if len(ovns.Items) == 0 {
+		return "", nil
+	}
+	name := ovns.Items[0].Status.MetadataSecretName
+	if name == "" {
+		if instance.Spec.ComputeReplicas != nil && *instance.Spec.ComputeReplicas == 0 {
+			return "", nil
+		}
+		return "", fmt.Errorf("OVNNetwork metadata secret: %w", common.ErrDependencyNotReady)
+	}
+	return common.GetSecretValue(ctx, r.Client, name, instance.Namespace, "secret")
+}
+
+// baseConfigData returns the nova.conf settings shared by the top-level and
+// cell configs; callers add TransportURL, DatabaseURL and NoVNCProxyURL.
+func (r *NovaReconciler) baseConfigData(ctx context.Context, instance *openstackv1alpha1.Nova, conns *common.Connections, metadataSecret string) (map[string]any, error) {
+	dbPassword, err := common.GetSecretValue(ctx, r.Client, novaDBSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return nil, err
+	}
+	servicePassword, err := common.GetSecretValue(ctx, r.Client, novaServiceSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"APIDatabaseURL":   common.DatabaseURL("nova", dbPassword, conns.MariaDBHost, "nova_api"),
+		"KeystoneURL":      conns.KeystoneURL,
+		"MemcachedServers": conns.MemcachedServers,
+		"Region":           conns.Region,
+		"ServiceUser":      "nova",
+		"ServicePassword":  servicePassword,
+		"MetadataSecret":   metadataSecret,
+		"NoVNCProxyURL":    "",
+	}, nil
+}
+
+// withConfig returns a copy of base with overrides applied.
+func withConfig(base, overrides map[string]any) map[string]any {
+	data := make(map[string]any, len(base)+len(overrides))
+	for k, v := range base {
+		data[k] = v
+	}
+	for k, v := range overrides {
+		data[k] = v
+	}
+	return data
+}
+
+// rabbitCredentials reads the username and password from a RabbitMQ credentials Secret.
+func (r *NovaReconciler) rabbitCredentials(ctx context.Context, instance *openstackv1alpha1.Nova, secret string) (string, string, error) {
+	user, err := common.GetSecretValue(ctx, r.Client, secret, instance.Namespace, "username")
+	if err != nil {
+		return "", "", err
+	}
+	password, err := common.GetSecretValue(ctx, r.Client, secret, instance.Namespace, "password")
+	if err != nil {
+		return "", "", err
+	}
+	return user, password, nil
+}
+
+// ensureConfig renders nova.conf into a Secret (it embeds credentials) and returns its hash.
+func (r *NovaReconciler) ensureConfig(ctx context.Context, instance *openstackv1alpha1.Nova, name string, labels map[string]string, data map[string]any) (string, error) {
+	rendered, err := common.RenderTemplate("nova/nova.conf", data)
+	if err != nil {
+		return "", err
+	}
+	files := map[string]string{"nova.conf": rendered}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labels
+		secret.Data = map[string][]byte{"nova.conf": []byte(rendered)}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return "", err
+	}
+	return common.ConfigHash(files), nil
+}
+
+func (r *NovaReconciler) ensureDeployment(ctx context.Context, instance *openstackv1alpha1.Nova, name string, labels map[string]string, component novaComponent, replicas int32, configSecret, configHash string) error {
+	container := corev1.Container{
+		Name:         "nova-" + component.name,
+		Image:        images.ImageOrDefault(instance.Spec.Image, component.defaultImage),
+		Command:      component.command,
+		Resources:    instance.Spec.Resources,
+		VolumeMounts: []corev1.VolumeMount{novaConfigVolumeMount()},
+	}
+	if component.port != 0 {
+		container.Ports = []corev1.ContainerPort{{ContainerPort: component.port, Name: component.name}}
+		probe := corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(component.port)}}
+		if component.httpProbe {
+			probe = corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt32(component.port)}}
+		}
+		container.ReadinessProbe = &corev1.Probe{ProbeHandler: probe, InitialDelaySeconds: 10, PeriodSeconds: 10}
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{container}
+		deploy.Spec.Template.Spec.Volumes = []corev1.Volume{novaConfigVolume(configSecret)}
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
+
+func (r *NovaReconciler) ensureService(ctx context.Context, instance *openstackv1alpha1.Nova, name string, labels map[string]string, component novaComponent) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labels
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: component.name, Port: component.port, TargetPort: intstr.FromInt32(component.port), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+func (r *NovaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.Nova{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}).
+		Owns(&batchv1.Job{}).
+		Owns(&appsv1.DaemonSet{}).
+		Watches(&openstackv1alpha1.OVNNetwork{}, handler.EnqueueRequestsFromMapFunc(r.novasForObject)).
+		Watches(&openstackv1alpha1.CephStorage{}, handler.EnqueueRequestsFromMapFunc(r.novasForObject)).
+		Watches(&openstackv1alpha1.Cinder{}, handler.EnqueueRequestsFromMapFunc(r.novasForObject)).
+		Watches(&openstackv1alpha1.OpenStackDataPlane{}, handler.EnqueueRequestsFromMapFunc(r.novasForObject)).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.novasForNode), builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Watches(&certificatesv1.CertificateSigningRequest{}, handler.EnqueueRequestsFromMapFunc(novaForMigrationCSR)).
+		Complete(r)
+}
+
+// novasForObject maps an object to the Novas in its namespace.
+func (r *NovaReconciler) novasForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	novas := &openstackv1alpha1.NovaList{}
+	if err := r.List(ctx, novas, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(novas.Items))
+	for _, nova := range novas.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&nova)})
+	}
+	return requests
+}
+
+func novaDBSecretName(instance *openstackv1alpha1.Nova) string {
+	if instance.Spec.Database.SecretName != "" {
+		return instance.Spec.Database.SecretName
+	}
+	return instance.Name + "-db-password"
+}
+
+func novaServiceSecretName(instance *openstackv1alpha1.Nova) string {
+	return instance.Name + "-service-password"
+}
+
+// novaTopMQSecret returns the RabbitMQ credentials Secret of the top-level services.
+func novaTopMQSecret(instance *openstackv1alpha1.Nova, conns *common.Connections) string {
+	if instance.Spec.MessageQueue.SecretName != "" {
+		return instance.Spec.MessageQueue.SecretName
+	}
+	return conns.RabbitMQSecret
+}
+
+func novaConfigVolume(secretName string) corev1.Volume {
+	return corev1.Volume{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: secretName},
+		},
+	}
+}
+
+func novaConfigVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{Name: "config", MountPath: "/etc/nova", ReadOnly: true}
+}
+
+func labelsForNova(name, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "nova",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/component":  component,
+		"app.kubernetes.io/managed-by": "openstack-operator",
+	}
+}
@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// novaMigrationCertRenewal is how long before it expires a data plane host
+// certificate is reissued.
+const novaMigrationCertRenewal = 30 * 24 * time.Hour
+
+// novaDataPlaneCompute is a data plane with compute nodes running
+// nova-compute.
+type novaDataPlaneCompute struct {
+	dataplane *openstackv1alpha1.OpenStackDataPlane
+	// nodes are its compute nodes, sorted by host name.
+	nodes []openstackv1alpha1.DataPlaneNodeSpec
+}
+
+// hosts returns the host names of the compute nodes.
+func (c novaDataPlaneCompute) hosts() []string {
+	hosts := make([]string, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		hosts = append(hosts, node.Hostname)
+	}
+	return hosts
+}
+
+// novaDataPlaneSecretName names the Secret published for the compute nodes
+// of a data plane.
+func novaDataPlaneSecretName(dataplane string) string {
+	return dataplane + "-nova-compute"
+}
+
+// dataPlaneComputes returns the data planes in the namespace with compute
+// nodes running nova-compute.
+func (r *NovaReconciler) dataPlaneComputes(ctx context.Context, namespace string) ([]novaDataPlaneCompute, error) {
+	dataplanes := &openstackv1alpha1.OpenStackDataPlaneList{}
+	if err := r.List(ctx, dataplanes, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var computes []novaDataPlaneCompute
+	for i := range dataplanes.Items {
+		dataplane := &dataplanes.Items[i]
+		if services := dataplane.Spec.ServicesOverride; services != nil && !services.NovaCompute {
+			continue
+		}
+		var nodes []openstackv1alpha1.DataPlaneNodeSpec
+		for _, node := range dataplane.Spec.Nodes {
+			if node.Role == "compute" {
+				nodes = append(nodes, node)
+			}
+		}
+		if len(nodes) > 0 {
+			sort.Slice(nodes, func(i, j int) bool { return nodes[i].Hostname < nodes[j].Hostname })
+			computes = append(computes, novaDataPlaneCompute{dataplane: dataplane, nodes: nodes})
+		}
+	}
+	return computes, nil
+}
+
+// novaDataPlaneFiles returns the host configuration all data plane compute
+// nodes install from Nova: with Ceph, the client.nova keyring, ceph.conf and
+// the libvirt secret definition whose value is the client key; with live
+// migration, the credentials the in-cluster computes mount under
+// /etc/nova/migration, prefixed with migration-, and the live_migration_uri.
+// With ssh, sshd listens on the migration port with migration-sshd_config.
+func novaDataPlaneFiles(baseConfig map[string]any) (map[string]string, error) {
+	files := map[string]string{}
+	if ceph, ok := baseConfig["Ceph"].(*novaCephConfig); ok {
+		files["ceph.conf"] = ceph.Config
+		files["ceph.client."+ceph.User+".keyring"] = ceph.Keyring
+		files["ceph-secret-uuid"] = ceph.SecretUUID
+		files["ceph-secret.xml"] = fmt.Sprintf(`<secret ephemeral='no' private='no'>
+  <uuid>%s</uuid>
+  <usage type='ceph'>
+    <name>client.%s secret</name>
+  </usage>
+</secret>
+`, ceph.SecretUUID, ceph.User)
+	}
+	if migration, ok := baseConfig["Migration"].(*novaMigrationConfig); ok {
+		for name, content := range migration.Files {
+			files["migration-"+name] = content
+		}
+		files["migration-uri"] = migration.URI
+		if migration.Transport == "ssh" {
+			rendered, err := common.RenderTemplate("nova/sshd_config", map[string]any{"Migration": migration})
+			if err != nil {
+				return nil, err
+			}
+			files["migration-sshd_config"] = rendered
+		}
+	}
+	return files, nil
+}
+
+// novaDataPlaneMigrationCert returns the migration certificate and key of a
+// data plane compute node for the tls transport. Data plane hosts cannot
+// request their certificate through the CSR API, so the operator issues
+// it for the node's host name and migration address. A current certificate
+// in existing is kept, so the Secret only changes when one is due.
+func novaDataPlaneMigrationCert(migration *novaMigrationConfig, node openstackv1alpha1.DataPlaneNodeSpec, existing map[string][]byte) (certPEM, keyPEM []byte, err error) {
+	addr := node.MigrationIP
+	if addr == "" {
+		addr = node.IP
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, nil, fmt.Errorf("data plane node %s has no valid migration address", node.Hostname)
+	}
+	certPEM, keyPEM = existing["migration-"+node.Hostname+".crt"], existing["migration-"+node.Hostname+".key"]
+	if len(keyPEM) > 0 && novaMigrationCertCurrent(certPEM, migration.caCert, node.Hostname, ip) {
+		return certPEM, keyPEM, nil
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		return nil, nil, err
+	}
+	req := &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: node.Hostname},
+		DNSNames:    []string{node.Hostname},
+		IPAddresses: []net.IP{ip},
+		PublicKey:   &key.PublicKey,
+	}
+	if certPEM, err = common.SignCertificateRequest(migration.caCert, migration.caKey, req, novaMigrationCertValidity); err != nil {
+		return nil, nil, err
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+// novaMigrationCertCurrent reports whether a host certificate is signed by
+// the migration CA, names the host and its migration address, and is not due
+// for renewal. A certificate expiring with the CA is only due once the CA
+// has been renewed.
+func novaMigrationCertCurrent(certPEM, caCertPEM []byte, host string, ip net.IP) bool {
+	cert, err := common.ParseCertificate(certPEM)
+	if err != nil {
+		return false
+	}
+	ca, err := common.ParseCertificate(caCertPEM)
+	if err != nil || cert.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	if cert.Subject.CommonName != host || len(cert.DNSNames) != 1 || cert.DNSNames[0] != host ||
+		len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(ip) {
+		return false
+	}
+	return time.Until(cert.NotAfter) > novaMigrationCertRenewal || !cert.NotAfter.Before(ca.NotAfter)
+}
+
+// ensureDataPlaneSecrets publishes the host configuration of the data plane
+// compute nodes in a <dataplane>-nova-compute Secret per data plane, for
+// their provisioning to install, and removes the Secrets no longer needed.
+// It returns the sorted hosts of the data planes whose provisioning has not
+// reported the current Secret installed.
+func (r *NovaReconciler) ensureDataPlaneSecrets(ctx context.Context, instance *openstackv1alpha1.Nova, baseConfig map[string]any) ([]string, error) {
+	computes, err := r.dataPlaneComputes(ctx, instance.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	files, err := novaDataPlaneFiles(baseConfig)
+	if err != nil {
+		return nil, err
+	}
+	migration, _ := baseConfig["Migration"].(*novaMigrationConfig)
+
+	wanted := map[string]bool{}
+	var pending []string
+	if len(files) > 0 {
+		for _, compute := range computes {
+			name := novaDataPlaneSecretName(compute.dataplane.Name)
+			wanted[name] = true
+			var hash string
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
+			if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+				data := map[string][]byte{}
+				for key, content := range files {
+					data[key] = []byte(content)
+				}
+				if migration != nil && migration.Transport == "tls" {
+					for _, node := range compute.nodes {
+						cert, key, err := novaDataPlaneMigrationCert(migration, node, secret.Data)
+						if err != nil {
+							return err
+						}
+						data["migration-"+node.Hostname+".crt"] = cert
+						data["migration-"+node.Hostname+".key"] = key
+					}
+				}
+				hashed := make(map[string]string, len(data))
+				for key, content := range data {
+					hashed[key] = string(content)
+				}
+				hash = common.ConfigHash(hashed)
+				secret.Labels = labelsForNova(instance.Name, "dataplane-compute")
+				secret.Annotations = map[string]string{common.ConfigHashAnnotation: hash}
+				secret.Data = data
+				return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+			}); err != nil {
+				return nil, err
+			}
+			if compute.dataplane.Status.NovaComputeConfigHash != hash {
+				pending = append(pending, compute.hosts()...)
+			}
+		}
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.InNamespace(instance.Namespace), client.MatchingLabels(labelsForNova(instance.Name, "dataplane-compute"))); err != nil {
+		return nil, err
+	}
+	for i := range secrets.Items {
+		if secret := &secrets.Items[i]; !wanted[secret.Name] {
+			if err := r.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(pending)
+	return pending, nil
+}
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestNovaEnsureDataPlaneSecrets(t *testing.T) {
+	ctx := context.Background()
+	dataplane := func(name string, services *openstackv1alpha1.DataPlaneServices, nodes ...openstackv1alpha1.DataPlaneNodeSpec) *openstackv1alpha1.OpenStackDataPlane {
+		return &openstackv1alpha1.OpenStackDataPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openstack"},
+			Spec:       openstackv1alpha1.OpenStackDataPlaneSpec{Nodes: nodes, ServicesOverride: services},
+		}
+	}
+	r := &NovaReconciler{Client: newFakeClient(t,
+		dataplane("edge", nil,
+			openstackv1alpha1.DataPlaneNodeSpec{Hostname: "compute-1", Role: "compute"},
+			openstackv1alpha1.DataPlaneNodeSpec{Hostname: "compute-0", Role: "compute"},
+			openstackv1alpha1.DataPlaneNodeSpec{Hostname: "net-0", Role: "networker"},
+		),
+		dataplane("network", nil, openstackv1alpha1.DataPlaneNodeSpec{Hostname: "net-1", Role: "networker"}),
+		dataplane("baremetal", &openstackv1alpha1.DataPlaneServices{NovaCompute: false},
+			openstackv1alpha1.DataPlaneNodeSpec{Hostname: "compute-9", Role: "compute"},
+		),
+	), Scheme: testScheme}
+	instance := &openstackv1alpha1.Nova{ObjectMeta: metav1.ObjectMeta{Name: "nova", Namespace: "openstack", UID: "nova-uid"}}
+	ceph := &novaCephConfig{Pool: "vms", User: "nova", SecretUUID: "6f1c", Config: "[global]\n", Keyring: "[client.nova]\n\tkey = AQB=\n"}
+	baseConfig := map[string]any{"Ceph": ceph}
+
+	pending, err := r.ensureDataPlaneSecrets(ctx, instance, baseConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"compute-0", "compute-1"}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("pending = %v, want %v", pending, want)
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Name: "edge-nova-compute", Namespace: "openstack"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(secret.Data["ceph-secret-uuid"]); got != "6f1c" {
+		t.Errorf("ceph-secret-uuid = %q", got)
+	}
+	if got := string(secret.Data["ceph.client.nova.keyring"]); got != ceph.Keyring {
+		t.Errorf("keyring = %q", got)
+	}
+	if xml := string(secret.Data["ceph-secret.xml"]); !strings.Contains(xml, "<uuid>6f1c</uuid>") || !strings.Contains(xml, "<name>client.nova secret</name>") {
+		t.Errorf("ceph-secret.xml = %s", xml)
+	}
+	hash := secret.Annotations[common.ConfigHashAnnotation]
+	if hash == "" {
+		t.Fatal("no config hash annotation")
+	}
+	for _, name := range []string{"network-nova-compute", "baremetal-nova-compute"} {
+		if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: "openstack"}, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+			t.Errorf("%s: error = %v, want not found", name, err)
+		}
+	}
+
+	// Provisioning reports the Secret installed; the hash is stable across
+	// reconciles.
+	edge := &openstackv1alpha1.OpenStackDataPlane{}
+	if err := r.Get(ctx, client.ObjectKey{Name: "edge", Namespace: "openstack"}, edge); err != nil {
+		t.Fatal(err)
+	}
+	edge.Status.NovaComputeConfigHash = hash
+	if err := r.Status().Update(ctx, edge); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = r.ensureDataPlaneSecrets(ctx, instance, baseConfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("pending = %v after provisioning installed the Secret", pending)
+	}
+
+	// A new key changes the hash, so the hosts wait for provisioning again.
+	ceph.Keyring = "[client.nova]\n\tkey = AQC=\n"
+	if pending, err = r.ensureDataPlaneSecrets(ctx, instance, baseConfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Errorf("pending = %v after the key changed", pending)
+	}
+
+	// Without anything to install the Secret is removed.
+	if pending, err = r.ensureDataPlaneSecrets(ctx, instance, map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("pending = %v without Ceph", pending)
+	}
+	if err := r.Get(ctx, client.ObjectKey{Name: "edge-nova-compute", Namespace: "openstack"}, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("error = %v, want the Secret removed", err)
+	}
+}
+
+func TestNovaDataPlaneFilesMigration(t *testing.T) {
+	migration := &novaMigrationConfig{
+		Transport: "ssh",
+		URI:       "qemu+ssh://root@%s:2022/system?keyfile=/root/.ssh/id_ecdsa",
+		SSHPort:   novaMigrationSSHPort,
+		Files:     map[string]string{"id_ecdsa": "private", "authorized_keys": "ecdsa-sha2-nistp256 AAAA", "known_hosts": "[*]:2022 ecdsa-sha2-nistp256 BBBB"},
+	}
+	files, err := novaDataPlaneFiles(map[string]any{"Migration": migration})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"migration-id_ecdsa":    "private",
+		"migration-known_hosts": "[*]:2022 ecdsa-sha2-nistp256 BBBB",
+		"migration-uri":         migration.URI,
+	} {
+		if files[key] != want {
+			t.Errorf("%s = %q, want %q", key, files[key], want)
+		}
+	}
+	if !strings.Contains(files["migration-sshd_config"], "Port 2022") {
+		t.Errorf("sshd_config does not listen on the migration port:\n%s", files["migration-sshd_config"])
+	}
+	if _, ok := files["ceph.conf"]; ok {
+		t.Error("ceph.conf without Ceph")
+	}
+}
+
+func TestNovaDataPlaneMigrationCert(t *testing.T) {
+	caCert, caKey, err := common.GenerateCA("nova live migration CA", 24*time.Hour*3650)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCert, otherKey, err := common.GenerateCA("other CA", 24*time.Hour*3650)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migration := &novaMigrationConfig{Transport: "tls", caCert: caCert, caKey: caKey}
+	node := openstackv1alpha1.DataPlaneNodeSpec{Hostname: "compute-0.example.com", IP: "192.0.2.10", MigrationIP: "198.51.100.10", Role: "compute"}
+
+	certPEM, keyPEM, err := novaDataPlaneMigrationCert(migration, node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := common.ParseCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := common.ParseCertificate(caCert)
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("not signed by the migration CA: %v", err)
+	}
+	if cert.Subject.CommonName != node.Hostname || !reflect.DeepEqual(cert.DNSNames, []string{node.Hostname}) {
+		t.Errorf("names = %s %v", cert.Subject.CommonName, cert.DNSNames)
+	}
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "198.51.100.10" {
+		t.Errorf("addresses = %v, want the migration IP", cert.IPAddresses)
+	}
+	existing := map[string][]byte{"migration-compute-0.example.com.crt": certPEM, "migration-compute-0.example.com.key": keyPEM}
+
+	tests := []struct {
+		name      string
+		migration *novaMigrationConfig
+		node      func(node *openstackv1alpha1.DataPlaneNodeSpec)
+		wantKept  bool
+		wantErr   string
+	}{
+		{name: "current certificate", migration: migration, wantKept: true},
+		{
+			name:      "new migration address",
+			migration: migration,
+			node:      func(node *openstackv1alpha1.DataPlaneNodeSpec) { node.MigrationIP = "198.51.100.11" },
+		},
+		{
+			name:      "management IP without a migration IP",
+			migration: migration,
+			node:      func(node *openstackv1alpha1.DataPlaneNodeSpec) { node.MigrationIP = "" },
+		},
+		{
+			name:      "replaced CA",
+			migration: &novaMigrationConfig{Transport: "tls", caCert: otherCert, caKey: otherKey},
+		},
+		{
+			name:      "invalid address",
+			migration: migration,
+			node:      func(node *openstackv1alpha1.DataPlaneNodeSpec) { node.MigrationIP = "compute-0" },
+			wantErr:   "no valid migration address",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := node
+			if tt.node != nil {
+				tt.node(&n)
+			}
+			gotCert, _, err := novaDataPlaneMigrationCert(tt.migration, n, existing)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := string(gotCert) == string(certPEM); kept != tt.wantKept {
+				t.Errorf("kept = %v, want %v", kept, tt.wantKept)
+			}
+			if !tt.wantKept {
+				cert, err := common.ParseCertificate(gotCert)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ca, _ := common.ParseCertificate(tt.migration.caCert)
+				if err := cert.CheckSignatureFrom(ca); err != nil {
+					t.Errorf("reissued certificate not signed by the current CA: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestNovaEnsureDataPlaneSecretsTLS(t *testing.T) {
+	ctx := context.Background()
+	caCert, caKey, err := common.GenerateCA("nova live migration CA", 24*time.Hour*3650)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &NovaReconciler{Client: newFakeClient(t, &openstackv1alpha1.OpenStackDataPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "openstack"},
+		Spec: openstackv1alpha1.OpenStackDataPlaneSpec{Nodes: []openstackv1alpha1.DataPlaneNodeSpec{
+			{Hostname: "compute-0", IP: "192.0.2.10", Role: "compute"},
+			{Hostname: "compute-1", IP: "192.0.2.11", Role: "compute"},
+		}},
+	}), Scheme: testScheme}
+	instance := &openstackv1alpha1.Nova{ObjectMeta: metav1.ObjectMeta{Name: "nova", Namespace: "openstack", UID: "nova-uid"}}
+	baseConfig := map[string]any{"Migration": &novaMigrationConfig{
+		Transport: "tls", URI: "qemu+tls://%s/system", Files: map[string]string{"ca.crt": string(caCert)}, caCert: caCert, caKey: caKey,
+	}}
+
+	secret := &corev1.Secret{}
+	var hashes []string
+	for range 2 {
+		if _, err := r.ensureDataPlaneSecrets(ctx, instance, baseConfig); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Get(ctx, client.ObjectKey{Name: "edge-nova-compute", Namespace: "openstack"}, secret); err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, secret.Annotations[common.ConfigHashAnnotation])
+	}
+	if hashes[0] != hashes[1] {
+		t.Errorf("hash changed from %s to %s without a change", hashes[0], hashes[1])
+	}
+	for _, key := range []string{"migration-ca.crt", "migration-compute-0.crt", "migration-compute-0.key", "migration-compute-1.crt", "migration-compute-1.key"} {
+		if len(secret.Data[key]) == 0 {
+			t.Errorf("no %s", key)
+		}
+	}
+	if _, ok := secret.Data["migration-sshd_config"]; ok {
+		t.Error("sshd_config with the tls transport")
+	}
+}
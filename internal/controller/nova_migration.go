@@ -0,0 +1,425 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+const (
+	// novaMigrationSSHPort is where the nova-ssh container listens on the
+	// host network, clear of the node's own sshd.
+	novaMigrationSSHPort = 2022
+
+	// novaMigrationSigner is the CSR signer name of the operator's live
+	// migration CA.
+	novaMigrationSigner = "openstack.k8s.io/nova-live-migration"
+
+	// novaMigrationOwnerLabel marks the CSRs of a Nova's compute hosts. Its
+	// value is <namespace>.<nova>.
+	novaMigrationOwnerLabel = "openstack.k8s.io/nova-migration"
+
+	// novaMigrationCertValidity is how long a host certificate is valid; a
+	// compute pod requests a new one whenever it starts.
+	novaMigrationCertValidity = 365 * 24 * time.Hour
+)
+
+// errNoMigrationIssuer is returned when the tls transport has no issuer.
+var errNoMigrationIssuer = errors.New("liveMigration transport tls needs liveMigration.issuerRef or an OpenStackControlPlane TLS issuer")
+
+// novaMigrationConfig is the template data for live migration.
+type novaMigrationConfig struct {
+	Transport   string
+	URI         string
+	NetworkCIDR string
+	SSHPort     int
+	// Files are the credentials libvirtd and sshd find under
+	// /etc/nova/migration.
+	Files map[string]string
+	// Signer, OwnerLabel, Owner and CSRPrefix describe the CSRs compute
+	// hosts submit for their certificate with the tls transport.
+	Signer     string
+	OwnerLabel string
+	Owner      string
+	CSRPrefix  string
+
+	// issued lists the compute pods, by UID, holding a signed certificate.
+	issued map[types.UID]bool
+	// caCert and caKey are the migration CA, which signs the certificates of
+	// data plane computes.
+	caCert, caKey []byte
+}
+
+// ensureMigration prepares the live migration credentials of the compute
+// hosts: an SSH client keypair and host key, generated once and shared, or
+// a migration CA issued by cert-manager. With the CA, each host generates
+// its own key and requests a certificate for its node name and migration
+// address through the CSR API; the operator checks the request against the
+// requesting pod and signs it, so the CA key never leaves the operator. It
+// returns nil when live migration is disabled.
+func (r *NovaReconciler) ensureMigration(ctx context.Context, instance *openstackv1alpha1.Nova) (*novaMigrationConfig, error) {
+	spec := instance.Spec.LiveMigration
+	transport := spec.Transport
+	if transport == "" {
+		transport = "ssh"
+	}
+	cfg := &novaMigrationConfig{Transport: transport, NetworkCIDR: spec.NetworkCIDR, SSHPort: novaMigrationSSHPort}
+
+	switch transport {
+	case "ssh":
+		name := instance.Name + "-migration-ssh"
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			if len(secret.Data["id_ecdsa"]) == 0 {
+				private, authorized, err := common.GenerateSSHKeyPair("nova-migration@" + instance.Namespace)
+				if err != nil {
+					return err
+				}
+				secret.Data["id_ecdsa"] = []byte(private)
+				secret.Data["authorized_keys"] = []byte(authorized)
+			}
+			// All hosts share one host key, as they share the client key
+			// that logs in to every one of them anyway; known_hosts lets
+			// the source verify it on the migration port of any address.
+			if len(secret.Data["ssh_host_ecdsa_key"]) == 0 {
+				private, public, err := common.GenerateSSHKeyPair("nova-migration-host@" + instance.Namespace)
+				if err != nil {
+					return err
+				}
+				secret.Data["ssh_host_ecdsa_key"] = []byte(private)
+				secret.Data["known_hosts"] = []byte(fmt.Sprintf("[*]:%d %s", novaMigrationSSHPort, public))
+			}
+			secret.Labels = labelsForNova(instance.Name, "migration")
+			return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+		}); err != nil {
+			return nil, err
+		}
+		instance.Status.MigrationSecretName = name
+		cfg.URI = fmt.Sprintf("qemu+ssh://root@%%s:%d/system?keyfile=/root/.ssh/id_ecdsa", novaMigrationSSHPort)
+		cfg.Files = map[string]string{}
+		for _, key := range []string{"id_ecdsa", "authorized_keys", "ssh_host_ecdsa_key", "known_hosts"} {
+			cfg.Files[key] = string(secret.Data[key])
+		}
+
+	case "tls":
+		issuer := spec.IssuerRef
+		if issuer == nil {
+			controlPlanes := &openstackv1alpha1.OpenStackControlPlaneList{}
+			if err := r.List(ctx, controlPlanes, client.InNamespace(instance.Namespace)); err != nil {
+				return nil, err
+			}
+			if len(controlPlanes.Items) > 0 {
+				issuer = controlPlanes.Items[0].Spec.TLS.IssuerRef
+			}
+		}
+		if issuer == nil {
+			return nil, errNoMigrationIssuer
+		}
+		name := instance.Name + "-migration-ca"
+		if err := common.EnsureCertificate(ctx, r.Client, common.CertificateParams{
+			Name:       name,
+			Namespace:  instance.Namespace,
+			SecretName: name,
+			CommonName: fmt.Sprintf("%s live migration CA", instance.Name),
+			IssuerRef:  *issuer,
+			IsCA:       true,
+		}, instance); err != nil {
+			return nil, err
+		}
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: instance.Namespace}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("migration CA: %w", common.ErrDependencyNotReady)
+			}
+			return nil, err
+		}
+		if len(secret.Data["tls.key"]) == 0 {
+			return nil, fmt.Errorf("migration CA: %w", common.ErrDependencyNotReady)
+		}
+		if err := r.ensureMigrationCSRAccess(ctx, instance); err != nil {
+			return nil, err
+		}
+		issued, err := r.signMigrationCSRs(ctx, instance, secret.Data["tls.crt"], secret.Data["tls.key"])
+		if err != nil {
+			return nil, err
+		}
+		instance.Status.MigrationSecretName = name
+		cfg.URI = "qemu+tls://%s/system"
+		cfg.Signer = novaMigrationSigner
+		cfg.OwnerLabel = novaMigrationOwnerLabel
+		cfg.Owner = novaMigrationOwner(instance)
+		cfg.CSRPrefix = instance.Name + "-migration-"
+		cfg.issued = issued
+		cfg.caCert, cfg.caKey = secret.Data["tls.crt"], secret.Data["tls.key"]
+		// Hosts trust the migration CA alone, not the issuer above it that
+		// also signs the control plane's certificates.
+		cfg.Files = map[string]string{"ca.crt": string(secret.Data["tls.crt"])}
+
+	default:
+		instance.Status.MigrationSecretName = ""
+		return nil, r.deleteMigrationCSRAccess(ctx, instance)
+	}
+	if transport != "tls" {
+		if err := r.deleteMigrationCSRAccess(ctx, instance); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// novaMigrationOwner is the novaMigrationOwnerLabel value of a Nova's CSRs.
+func novaMigrationOwner(instance *openstackv1alpha1.Nova) string {
+	return instance.Namespace + "." + instance.Name
+}
+
+// novaMigrationCSRRoleName names the cluster role and binding that let the
+// compute hosts of a Nova submit CSRs.
+func novaMigrationCSRRoleName(instance *openstackv1alpha1.Nova) string {
+	return fmt.Sprintf("openstack-%s-%s-migration", instance.Namespace, instance.Name)
+}
+
+// ensureMigrationCSRAccess lets the compute service account create CSRs and
+// read them back. CSRs are cluster scoped, so the role and binding are too
+// and are removed by deleteMigrationCSRAccess rather than garbage collected.
+func (r *NovaReconciler) ensureMigrationCSRAccess(ctx context.Context, instance *openstackv1alpha1.Nova) error {
+	name := novaMigrationCSRRoleName(instance)
+	role := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, role, func() error {
+		role.Labels = labelsForNova(instance.Name, "migration")
+		role.Rules = []rbacv1.PolicyRule{{
+			APIGroups: []string{certificatesv1.GroupName},
+			Resources: []string{"certificatesigningrequests"},
+			Verbs:     []string{"create", "get"},
+		}}
+		return nil
+	}); err != nil {
+		return err
+	}
+	binding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, binding, func() error {
+		binding.Labels = labelsForNova(instance.Name, "migration")
+		binding.RoleRef = rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name}
+		binding.Subjects = []rbacv1.Subject{{
+			Kind: rbacv1.ServiceAccountKind, Name: novaComputeServiceAccount(instance), Namespace: instance.Namespace,
+		}}
+		return nil
+	})
+	return err
+}
+
+// deleteMigrationCSRAccess removes the role and binding of
+// ensureMigrationCSRAccess, if any.
+func (r *NovaReconciler) deleteMigrationCSRAccess(ctx context.Context, instance *openstackv1alpha1.Nova) error {
+	name := novaMigrationCSRRoleName(instance)
+	for _, obj := range []client.Object{
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: name}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name}},
+	} {
+		if err := r.Delete(ctx, obj); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// signMigrationCSRs approves and signs the pending CSRs of the Nova's
+// compute hosts that pass checkMigrationCSR, and denies the others. It
+// returns the UIDs of the pods that hold a signed certificate.
+func (r *NovaReconciler) signMigrationCSRs(ctx context.Context, instance *openstackv1alpha1.Nova, caCert, caKey []byte) (map[types.UID]bool, error) {
+	logger := log.FromContext(ctx)
+	csrs := &certificatesv1.CertificateSigningRequestList{}
+	if err := r.List(ctx, csrs, client.MatchingLabels{novaMigrationOwnerLabel: novaMigrationOwner(instance)}); err != nil {
+		return nil, err
+	}
+	issued := map[types.UID]bool{}
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+		if csr.Spec.SignerName != novaMigrationSigner || csrCondition(csr, certificatesv1.CertificateDenied) || csrCondition(csr, certificatesv1.CertificateFailed) {
+			continue
+		}
+		podUID := types.UID(csrExtra(csr, "authentication.kubernetes.io/pod-uid"))
+		if len(csr.Status.Certificate) > 0 {
+			issued[podUID] = true
+			continue
+		}
+
+		// A pod that is not in the cache yet is retried on the next
+		// reconcile; pending requests of deleted pods expire.
+		pod := &corev1.Pod{}
+		if err := r.Get(ctx, client.ObjectKey{Name: csrExtra(csr, "authentication.kubernetes.io/pod-name"), Namespace: instance.Namespace}, pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		req, err := checkMigrationCSR(instance, csr, pod)
+		if err != nil {
+			logger.Info("denying live migration certificate request", "csr", csr.Name, "reason", err.Error())
+			csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+				Type: certificatesv1.CertificateDenied, Status: corev1.ConditionTrue, Reason: "RequestInvalid", Message: err.Error(),
+			})
+			if err := r.SubResource("approval").Update(ctx, csr); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !csrCondition(csr, certificatesv1.CertificateApproved) {
+			csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+				Type: certificatesv1.CertificateApproved, Status: corev1.ConditionTrue, Reason: "ComputeHost",
+				Message: fmt.Sprintf("Live migration certificate of compute host %s", pod.Spec.NodeName),
+			})
+			if err := r.SubResource("approval").Update(ctx, csr); err != nil {
+				return nil, err
+			}
+		}
+		if csr.Status.Certificate, err = common.SignCertificateRequest(caCert, caKey, req, novaMigrationCertValidity); err != nil {
+			return nil, err
+		}
+		if err := r.Status().Update(ctx, csr); err != nil {
+			return nil, err
+		}
+		logger.Info("signed live migration certificate", "csr", csr.Name, "node", pod.Spec.NodeName)
+		issued[podUID] = true
+	}
+	return issued, nil
+}
+
+// checkMigrationCSR verifies that a CSR was submitted by a current compute
+// pod of the Nova and only asks for that pod's node name and migration
+// address: its host IP, or an address in the migration network.
+func checkMigrationCSR(instance *openstackv1alpha1.Nova, csr *certificatesv1.CertificateSigningRequest, pod *corev1.Pod) (*x509.CertificateRequest, error) {
+	if want := fmt.Sprintf("system:serviceaccount:%s:%s", instance.Namespace, novaComputeServiceAccount(instance)); csr.Spec.Username != want {
+		return nil, fmt.Errorf("requested by %s, not %s", csr.Spec.Username, want)
+	}
+	if pod.UID != types.UID(csrExtra(csr, "authentication.kubernetes.io/pod-uid")) {
+		return nil, fmt.Errorf("not requested by pod %s", pod.Name)
+	}
+	if pod.Labels["app.kubernetes.io/instance"] != instance.Name || !strings.HasSuffix(pod.Labels["app.kubernetes.io/component"], "-compute") || pod.Spec.NodeName == "" {
+		return nil, fmt.Errorf("pod %s is not a compute pod", pod.Name)
+	}
+
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("request is not a PEM encoded certificate request")
+	}
+	req, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.CheckSignature(); err != nil {
+		return nil, err
+	}
+	node := pod.Spec.NodeName
+	if req.Subject.CommonName != node {
+		return nil, fmt.Errorf("common name %q is not the node name %s", req.Subject.CommonName, node)
+	}
+	if len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return nil, errors.New("only DNS and IP subject alternative names are allowed")
+	}
+	for _, name := range req.DNSNames {
+		if name != node {
+			return nil, fmt.Errorf("DNS name %s is not the node name %s", name, node)
+		}
+	}
+	if len(req.IPAddresses) == 0 {
+		return nil, errors.New("no migration address")
+	}
+	var migrationNet *net.IPNet
+	if cidr := instance.Spec.LiveMigration.NetworkCIDR; cidr != "" {
+		if _, migrationNet, err = net.ParseCIDR(cidr); err != nil {
+			return nil, err
+		}
+	}
+	for _, ip := range req.IPAddresses {
+		if migrationNet != nil && !migrationNet.Contains(ip) {
+			return nil, fmt.Errorf("address %s is outside the migration network %s", ip, migrationNet)
+		}
+		if migrationNet == nil && !ip.Equal(net.ParseIP(pod.Status.HostIP)) {
+			return nil, fmt.Errorf("address %s is not the host IP %s", ip, pod.Status.HostIP)
+		}
+	}
+	return req, nil
+}
+
+func csrCondition(csr *certificatesv1.CertificateSigningRequest, condType certificatesv1.RequestConditionType) bool {
+	for _, cond := range csr.Status.Conditions {
+		if cond.Type == condType && cond.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func csrExtra(csr *certificatesv1.CertificateSigningRequest, key string) string {
+	if values := csr.Spec.Extra[key]; len(values) == 1 {
+		return values[0]
+	}
+	return ""
+}
+
+// novaForMigrationCSR maps a CSR to the Nova whose compute host submitted it.
+func novaForMigrationCSR(_ context.Context, obj client.Object) []reconcile.Request {
+	namespace, name, ok := strings.Cut(obj.GetLabels()[novaMigrationOwnerLabel], ".")
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+}
+
+// migrationMeshReady reports whether every compute DaemonSet has rolled out
+// the current configuration, so all in-cluster hosts hold the current
+// migration credentials, and, with the tls transport, whether every compute
+// pod holds a signed certificate.
+func (r *NovaReconciler) migrationMeshReady(ctx context.Context, instance *openstackv1alpha1.Nova, migration *novaMigrationConfig) (bool, error) {
+	for _, cell := range novaCells(instance) {
+		ds := &appsv1.DaemonSet{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-" + cell.Name + "-compute", Namespace: instance.Namespace}, ds); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+		if ds.Status.ObservedGeneration < ds.Generation ||
+			ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled ||
+			ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled {
+			return false, nil
+		}
+	}
+	if migration.Transport != "tls" {
+		return true, nil
+	}
+	pods, err := r.computePods(ctx, instance)
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range pods {
+		if !migration.issued[pod.UID] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
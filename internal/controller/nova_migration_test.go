@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+)
+
+func TestCheckMigrationCSR(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(template x509.CertificateRequest) []byte {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+	valid := x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "node-1"},
+		DNSNames:    []string{"node-1"},
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.11")},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "nova-cell1-compute-x7k2p",
+			Namespace: "openstack",
+			UID:       "3f1c",
+			Labels:    map[string]string{"app.kubernetes.io/instance": "nova", "app.kubernetes.io/component": "cell1-compute"},
+		},
+		Spec:   corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{HostIP: "192.0.2.11"},
+	}
+
+	tests := []struct {
+		name    string
+		cidr    string
+		change  func(csr *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest, pod *corev1.Pod)
+		wantErr string
+	}{
+		{name: "host IP"},
+		{
+			name: "address in the migration network",
+			cidr: "198.51.100.0/24",
+			change: func(_ *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest, _ *corev1.Pod) {
+				req.IPAddresses = []net.IP{net.ParseIP("198.51.100.7")}
+			},
+		},
+		{
+			name: "address outside the migration network",
+			cidr: "198.51.100.0/24",
+			change: func(_ *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest, _ *corev1.Pod) {
+				req.IPAddresses = []net.IP{net.ParseIP("198.51.101.7")}
+			},
+			wantErr: "outside the migration network",
+		},
+		{
+			name: "address of another host",
+			change: func(_ *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest, _ *corev1.Pod) {
+				req.IPAddresses = []net.IP{net.ParseIP("192.0.2.12")}
+			},
+			wantErr: "is not the host IP",
+		},
+		{
+			name: "no address",
+			change: func(_ *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest, _ *corev1.Pod) {
+				req.IPAddresses = nil
+			},
+			wantErr: "no migration address",
+		},
+		{
+			name: "common name of another node",
+			change: func(_ *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest, _ *corev1.Pod) {
+				req.Subject.CommonName = "node-2"
+			},
+			wantErr: "is not the node name",
+		},
+		{
+			name: "extra DNS name",
+			change: func(_ *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest, _ *corev1.Pod) {
+				req.DNSNames = append(req.DNSNames, "keystone.openstack.svc")
+			},
+			wantErr: "DNS name keystone.openstack.svc",
+		},
+		{
+			name: "URI name",
+			change: func(_ *certificatesv1.CertificateSigningRequest, req *x509.CertificateRequest, _ *corev1.Pod) {
+				req.URIs = []*url.URL{{Scheme: "spiffe", Host: "cluster.local"}}
+			},
+			wantErr: "only DNS and IP",
+		},
+		{
+			name: "other service account",
+			change: func(csr *certificatesv1.CertificateSigningRequest, _ *x509.CertificateRequest, _ *corev1.Pod) {
+				csr.Spec.Username = "system:serviceaccount:openstack:default"
+			},
+			wantErr: "requested by system:serviceaccount:openstack:default",
+		},
+		{
+			name: "replaced pod",
+			change: func(csr *certificatesv1.CertificateSigningRequest, _ *x509.CertificateRequest, _ *corev1.Pod) {
+				csr.Spec.Extra["authentication.kubernetes.io/pod-uid"] = certificatesv1.ExtraValue{"9a0b"}
+			},
+			wantErr: "not requested by pod",
+		},
+		{
+			name: "not a compute pod",
+			change: func(_ *certificatesv1.CertificateSigningRequest, _ *x509.CertificateRequest, pod *corev1.Pod) {
+				pod.Labels["app.kubernetes.io/component"] = "api"
+			},
+			wantErr: "is not a compute pod",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &openstackv1alpha1.Nova{ObjectMeta: metav1.ObjectMeta{Name: "nova", Namespace: "openstack"}}
+			instance.Spec.LiveMigration.NetworkCIDR = tt.cidr
+			csr := &certificatesv1.CertificateSigningRequest{Spec: certificatesv1.CertificateSigningRequestSpec{
+				Username: "system:serviceaccount:openstack:nova-compute",
+				Extra: map[string]certificatesv1.ExtraValue{
+					"authentication.kubernetes.io/pod-name": {pod.Name},
+					"authentication.kubernetes.io/pod-uid":  {string(pod.UID)},
+				},
+			}}
+			req := valid
+			p := pod.DeepCopy()
+			if tt.change != nil {
+				tt.change(csr, &req, p)
+			}
+			csr.Spec.Request = request(req)
+
+			got, err := checkMigrationCSR(instance, csr, p)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Subject.CommonName != req.Subject.CommonName {
+				t.Errorf("common name = %q", got.Subject.CommonName)
+			}
+		})
+	}
+}
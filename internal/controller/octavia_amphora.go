@@ -0,0 +1,719 @@
+package controller
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+const (
+	octaviaManagementNetwork = "lb-mgmt-net"
+	octaviaManagementSubnet  = "lb-mgmt-subnet"
+	octaviaAmphoraSecGroup   = "lb-mgmt-sec-grp"
+	octaviaHealthSecGroup    = "lb-health-mgr-sec-grp"
+	octaviaKeypair           = "octavia-amphora"
+	octaviaImageTag          = "amphora"
+
+	// octaviaImageURLProperty records on an imported image the URL it
+	// was imported from.
+	octaviaImageURLProperty = "octavia_image_url"
+
+	// octaviaAmphoraAgentPort is the port of the amphora agent API the
+	// controllers call.
+	octaviaAmphoraAgentPort = 9443
+
+	octaviaCAValidity         = 10 * 365 * 24 * time.Hour
+	octaviaClientCertValidity = 2 * 365 * 24 * time.Hour
+	// octaviaClientCertRenewal is how long before expiry the client
+	// certificate is reissued.
+	octaviaClientCertRenewal = 30 * 24 * time.Hour
+
+	// octaviaCAFinalizer holds the amphora CA Secrets while their Octavia
+	// exists, so that deleting them by accident does not take effect.
+	octaviaCAFinalizer = "openstack.k8s.io/octavia-amphora-ca"
+)
+
+// octaviaBootstrap holds the state shared by the amphora bootstrap steps.
+type octaviaBootstrap struct {
+	r        *OctaviaReconciler
+	osc      *common.OpenStackClient
+	instance *openstackv1alpha1.Octavia
+	status   *openstackv1alpha1.OctaviaAmphoraStatus
+	// userID is the octavia service user, which owns the keypair.
+	userID string
+}
+
+// octaviaBootstrapStep is one step of the amphora bootstrap, reported as
+// its own condition. ensure returns whether the step is done and a
+// message describing its state.
+type octaviaBootstrapStep struct {
+	condition   openstackv1alpha1.ConditionType
+	readyReason string
+	waitReason  string
+	ensure      func(context.Context) (bool, string, error)
+}
+
+// ensureAmphoraBootstrap creates what the amphora provider needs in the
+// cloud, step by step: the controller certificates, lb-mgmt-net, the
+// security groups, the flavor, the keypair, the image and the health
+// manager ports. It returns a non-zero result while a step is waiting.
+func (r *OctaviaReconciler) ensureAmphoraBootstrap(ctx context.Context, instance *openstackv1alpha1.Octavia, conns *common.Connections) (ctrl.Result, error) {
+	osc, err := common.NewOpenStackClient(ctx, r.Client, instance.Namespace, conns)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, serviceType := range []string{"network", "compute", "image"} {
+		if _, err := osc.Endpoint(serviceType); err != nil {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "WaitingForServices", err.Error())
+		}
+	}
+
+	if instance.Status.Amphora == nil {
+		instance.Status.Amphora = &openstackv1alpha1.OctaviaAmphoraStatus{}
+	}
+	b := &octaviaBootstrap{r: r, osc: osc, instance: instance, status: instance.Status.Amphora}
+	if err := b.resolveIdentity(ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	steps := []octaviaBootstrapStep{
+		{openstackv1alpha1.ConditionAmphoraCertificatesReady, "CertificatesGenerated", "GeneratingCertificates", b.ensureCertificates},
+		{openstackv1alpha1.ConditionManagementNetworkReady, "NetworkCreated", "CreatingNetwork", b.ensureManagementNetwork},
+		{openstackv1alpha1.ConditionAmphoraSecurityGroupsReady, "SecurityGroupsCreated", "CreatingSecurityGroups", b.ensureSecurityGroups},
+		{openstackv1alpha1.ConditionAmphoraFlavorReady, "FlavorCreated", "WaitingForFlavor", b.ensureFlavor},
+		{openstackv1alpha1.ConditionAmphoraKeypairReady, "KeypairCreated", "CreatingKeypair", b.ensureKeypair},
+		{openstackv1alpha1.ConditionAmphoraImageReady, "ImageActive", "WaitingForImage", b.ensureImage},
+		{openstackv1alpha1.ConditionHealthManagerPortsReady, "PortsCreated", "WaitingForPorts", b.ensureHealthManagerPorts},
+	}
+	for _, step := range steps {
+		done, message, err := step.ensure(ctx)
+		if err != nil {
+			instance.Status.Conditions = common.SetCondition(
+				instance.Status.Conditions, string(step.condition), metav1.ConditionFalse, "Failed", err.Error(), instance.Generation,
+			)
+			if statusErr := r.updateStatus(ctx, instance); statusErr != nil {
+				log.FromContext(ctx).Error(statusErr, "failed to update status")
+			}
+			return ctrl.Result{}, err
+		}
+		if !done {
+			return r.waitFor(ctx, instance, string(step.condition), step.waitReason, message)
+		}
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(step.condition), metav1.ConditionTrue, step.readyReason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{}, nil
+}
+
+// resolveIdentity looks up the service project and the octavia user. The
+// amphorae and their resources belong to the project, as Octavia boots
+// them with the credentials of the user.
+func (b *octaviaBootstrap) resolveIdentity(ctx context.Context) error {
+	var projects struct {
+		Projects []struct {
+			ID string `json:"id"`
+		} `json:"projects"`
+	}
+	if err := b.osc.Do(ctx, http.MethodGet, "identity", "/projects?name=service", nil, &projects); err != nil {
+		return err
+	}
+	if len(projects.Projects) == 0 {
+		return errors.New("service project not found")
+	}
+	var users struct {
+		Users []struct {
+			ID string `json:"id"`
+		} `json:"users"`
+	}
+	if err := b.osc.Do(ctx, http.MethodGet, "identity", "/users?name=octavia", nil, &users); err != nil {
+		return err
+	}
+	if len(users.Users) == 0 {
+		return errors.New("octavia user not found")
+	}
+	b.status.ProjectID = projects.Projects[0].ID
+	b.userID = users.Users[0].ID
+	return nil
+}
+
+// ensureCertificates generates the two CAs of the amphora control channel
+// and the client certificate of the controllers. The server CA signs the
+// certificate of each amphora, which the controllers verify; the client
+// CA is installed in the amphorae to verify the controllers. The CAs are
+// never regenerated, as existing amphorae trust them, and outlive the
+// Octavia unless AmphoraCADeletionPolicy is Delete; the client certificate
+// is reissued before it expires.
+func (b *octaviaBootstrap) ensureCertificates(ctx context.Context) (bool, string, error) {
+	if _, err := b.ensureCA(ctx, octaviaServerCASecretName(b.instance), "octavia-amphora-server-ca"); err != nil {
+		return false, "", err
+	}
+	clientCA, err := b.ensureCA(ctx, octaviaClientCASecretName(b.instance), "octavia-amphora-client-ca")
+	if err != nil {
+		return false, "", err
+	}
+	caCert, err := common.ParseCertificate(clientCA.Data["tls.crt"])
+	if err != nil {
+		return false, "", err
+	}
+
+	var notAfter time.Time
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: octaviaClientCertSecretName(b.instance), Namespace: b.instance.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, b.r.Client, secret, func() error {
+		cert, err := common.ParseCertificate(secret.Data["tls.crt"])
+		if err != nil || cert.CheckSignatureFrom(caCert) != nil || time.Until(cert.NotAfter) < octaviaClientCertRenewal {
+			certPEM, keyPEM, err := common.IssueCertificate(clientCA.Data["tls.crt"], clientCA.Data["tls.key"], "octavia-controller", x509.ExtKeyUsageClientAuth, octaviaClientCertValidity)
+			if err != nil {
+				return err
+			}
+			secret.Data = map[string][]byte{
+				"tls.crt":                 certPEM,
+				"tls.key":                 keyPEM,
+				"client.cert-and-key.pem": append(append([]byte{}, certPEM...), keyPEM...),
+			}
+			if cert, err = common.ParseCertificate(certPEM); err != nil {
+				return err
+			}
+		}
+		notAfter = cert.NotAfter
+		secret.Labels = labelsForOctavia(b.instance.Name, "certificates")
+		return controllerutil.SetControllerReference(b.instance, secret, b.r.Scheme)
+	}); err != nil {
+		return false, "", err
+	}
+	return true, fmt.Sprintf("Client certificate valid until %s", notAfter.UTC().Format(time.RFC3339)), nil
+}
+
+// ensureCA returns the Secret of a CA, generating the CA on first use. The
+// Secret has no owner, is immutable and is never rewritten: a new CA would
+// cut the controllers off from every existing amphora. A Secret without a
+// valid CA stops the bootstrap instead of being replaced.
+func (b *octaviaBootstrap) ensureCA(ctx context.Context, name, commonName string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := b.r.Get(ctx, client.ObjectKey{Name: name, Namespace: b.instance.Namespace}, secret)
+	if apierrors.IsNotFound(err) {
+		certPEM, keyPEM, err := common.GenerateCA(commonName, octaviaCAValidity)
+		if err != nil {
+			return nil, err
+		}
+		immutable := true
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  b.instance.Namespace,
+				Labels:     labelsForOctavia(b.instance.Name, "certificates"),
+				Finalizers: []string{octaviaCAFinalizer},
+			},
+			Immutable: &immutable,
+			Data:      map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM},
+		}
+		if err := b.r.Create(ctx, secret); err != nil {
+			return nil, err
+		}
+		log.FromContext(ctx).Info("generated amphora CA", "secret", name)
+		return secret, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := common.ParseCertificate(secret.Data["tls.crt"]); err != nil || len(secret.Data["tls.key"]) == 0 {
+		return nil, fmt.Errorf("CA Secret %s does not hold a certificate and key; restore it from a backup", name)
+	}
+	// A Secret created owned by the Octavia, adopted from an earlier one or
+	// released by one is protected again.
+	owners := slices.DeleteFunc(slices.Clone(secret.OwnerReferences), func(ref metav1.OwnerReference) bool {
+		return ref.UID == b.instance.UID
+	})
+	protect := secret.DeletionTimestamp.IsZero() && !common.HasFinalizer(secret, octaviaCAFinalizer)
+	if len(owners) != len(secret.OwnerReferences) || protect {
+		secret.OwnerReferences = owners
+		if protect {
+			common.AddFinalizer(secret, octaviaCAFinalizer)
+		}
+		if err := b.r.Update(ctx, secret); err != nil {
+			return nil, err
+		}
+	}
+	return secret, nil
+}
+
+// releaseAmphoraCAs lifts the protection of the amphora CA Secrets when
+// their Octavia is deleted. The Secrets themselves are only deleted with
+// AmphoraCADeletionPolicy Delete.
+func (r *OctaviaReconciler) releaseAmphoraCAs(ctx context.Context, instance *openstackv1alpha1.Octavia) error {
+	for _, name := range []string{octaviaServerCASecretName(instance), octaviaClientCASecretName(instance)} {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: instance.Namespace}, secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if common.HasFinalizer(secret, octaviaCAFinalizer) {
+			common.RemoveFinalizer(secret, octaviaCAFinalizer)
+			if err := r.Update(ctx, secret); err != nil {
+				return err
+			}
+		}
+		if instance.Spec.AmphoraCADeletionPolicy != "Delete" {
+			log.FromContext(ctx).Info("retaining amphora CA", "secret", name)
+			continue
+		}
+		if err := r.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// neutronNetwork is a network as represented by the Neutron API.
+type neutronNetwork struct {
+	ID  string `json:"id"`
+	MTU int32  `json:"mtu"`
+}
+
+// ensureManagementNetwork creates lb-mgmt-net and its subnet in the
+// service project. The subnet has no gateway: the amphorae only talk to
+// the controllers, whose ports are on the same network.
+func (b *octaviaBootstrap) ensureManagementNetwork(ctx context.Context) (bool, string, error) {
+	var networks struct {
+		Networks []neutronNetwork `json:"networks"`
+	}
+	query := url.Values{"name": {octaviaManagementNetwork}, "project_id": {b.status.ProjectID}}
+	if err := b.osc.Do(ctx, http.MethodGet, "network", "/v2.0/networks?"+query.Encode(), nil, &networks); err != nil {
+		return false, "", err
+	}
+	var network neutronNetwork
+	if len(networks.Networks) > 0 {
+		network = networks.Networks[0]
+	} else {
+		var created struct {
+			Network neutronNetwork `json:"network"`
+		}
+		body := map[string]any{"network": map[string]any{
+			"name":           octaviaManagementNetwork,
+			"project_id":     b.status.ProjectID,
+			"admin_state_up": true,
+		}}
+		if err := b.osc.Do(ctx, http.MethodPost, "network", "/v2.0/networks", body, &created); err != nil {
+			return false, "", err
+		}
+		network = created.Network
+	}
+	b.status.ManagementNetworkID = network.ID
+	b.status.ManagementNetworkMTU = network.MTU
+
+	var subnets struct {
+		Subnets []struct {
+			ID string `json:"id"`
+		} `json:"subnets"`
+	}
+	query = url.Values{"name": {octaviaManagementSubnet}, "network_id": {network.ID}}
+	if err := b.osc.Do(ctx, http.MethodGet, "network", "/v2.0/subnets?"+query.Encode(), nil, &subnets); err != nil {
+		return false, "", err
+	}
+	if len(subnets.Subnets) > 0 {
+		b.status.ManagementSubnetID = subnets.Subnets[0].ID
+	} else {
+		var created struct {
+			Subnet struct {
+				ID string `json:"id"`
+			} `json:"subnet"`
+		}
+		body := map[string]any{"subnet": map[string]any{
+			"name":        octaviaManagementSubnet,
+			"network_id":  network.ID,
+			"project_id":  b.status.ProjectID,
+			"ip_version":  4,
+			"cidr":        octaviaManagementCIDR(b.instance),
+			"gateway_ip":  nil,
+			"enable_dhcp": true,
+		}}
+		if err := b.osc.Do(ctx, http.MethodPost, "network", "/v2.0/subnets", body, &created); err != nil {
+			return false, "", err
+		}
+		b.status.ManagementSubnetID = created.Subnet.ID
+	}
+	return true, fmt.Sprintf("Network %s with subnet %s", network.ID, b.status.ManagementSubnetID), nil
+}
+
+// neutronSecurityGroupRule is an ingress rule of a security group.
+type neutronSecurityGroupRule struct {
+	Direction      string `json:"direction"`
+	Ethertype      string `json:"ethertype"`
+	Protocol       string `json:"protocol"`
+	PortRangeMin   *int   `json:"port_range_min"`
+	PortRangeMax   *int   `json:"port_range_max"`
+	RemoteIPPrefix string `json:"remote_ip_prefix"`
+}
+
+// ensureSecurityGroups creates the security group of the amphorae, open to
+// the controllers for the agent API, SSH and ping, and that of the health
+// manager ports, open to the heartbeats of the amphorae.
+func (b *octaviaBootstrap) ensureSecurityGroups(ctx context.Context) (bool, string, error) {
+	cidr := octaviaManagementCIDR(b.instance)
+	rule := func(protocol string, port int) neutronSecurityGroupRule {
+		r := neutronSecurityGroupRule{Direction: "ingress", Ethertype: "IPv4", Protocol: protocol, RemoteIPPrefix: cidr}
+		if port != 0 {
+			r.PortRangeMin, r.PortRangeMax = &port, &port
+		}
+		return r
+	}
+	var err error
+	b.status.SecurityGroupID, err = b.ensureSecurityGroup(ctx, octaviaAmphoraSecGroup, []neutronSecurityGroupRule{
+		rule("tcp", octaviaAmphoraAgentPort), rule("tcp", 22), rule("icmp", 0),
+	})
+	if err != nil {
+		return false, "", err
+	}
+	b.status.HealthManagerSecurityGroupID, err = b.ensureSecurityGroup(ctx, octaviaHealthSecGroup, []neutronSecurityGroupRule{
+		rule("udp", octaviaHealthManagerPort),
+	})
+	if err != nil {
+		return false, "", err
+	}
+	return true, fmt.Sprintf("Security groups %s and %s", octaviaAmphoraSecGroup, octaviaHealthSecGroup), nil
+}
+
+// ensureSecurityGroup returns the ID of the named security group of the
+// service project, creating the group and any missing rule.
+func (b *octaviaBootstrap) ensureSecurityGroup(ctx context.Context, name string, rules []neutronSecurityGroupRule) (string, error) {
+	var groups struct {
+		SecurityGroups []struct {
+			ID    string                     `json:"id"`
+			Rules []neutronSecurityGroupRule `json:"security_group_rules"`
+		} `json:"security_groups"`
+	}
+	query := url.Values{"name": {name}, "project_id": {b.status.ProjectID}}
+	if err := b.osc.Do(ctx, http.MethodGet, "network", "/v2.0/security-groups?"+query.Encode(), nil, &groups); err != nil {
+		return "", err
+	}
+	var id string
+	var existing []neutronSecurityGroupRule
+	if len(groups.SecurityGroups) > 0 {
+		id, existing = groups.SecurityGroups[0].ID, groups.SecurityGroups[0].Rules
+	} else {
+		var created struct {
+			SecurityGroup struct {
+				ID string `json:"id"`
+			} `json:"security_group"`
+		}
+		body := map[string]any{"security_group": map[string]any{"name": name, "project_id": b.status.ProjectID}}
+		if err := b.osc.Do(ctx, http.MethodPost, "network", "/v2.0/security-groups", body, &created); err != nil {
+			return "", err
+		}
+		id = created.SecurityGroup.ID
+	}
+
+	for _, rule := range rules {
+		found := false
+		for _, have := range existing {
+			if have.Direction == rule.Direction && have.Protocol == rule.Protocol && have.RemoteIPPrefix == rule.RemoteIPPrefix &&
+				equalPort(have.PortRangeMin, rule.PortRangeMin) && equalPort(have.PortRangeMax, rule.PortRangeMax) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		body := map[string]any{"security_group_rule": map[string]any{
+			"security_group_id": id,
+			"direction":         rule.Direction,
+			"ethertype":         rule.Ethertype,
+			"protocol":          rule.Protocol,
+			"port_range_min":    rule.PortRangeMin,
+			"port_range_max":    rule.PortRangeMax,
+			"remote_ip_prefix":  rule.RemoteIPPrefix,
+		}}
+		if err := b.osc.Do(ctx, http.MethodPost, "network", "/v2.0/security-group-rules", body, nil); err != nil && !common.IsOpenStackConflict(err) {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+func equalPort(a, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// ensureFlavor creates the amphora flavor through an OpenStackFlavor,
+// private to the service project.
+func (b *octaviaBootstrap) ensureFlavor(ctx context.Context) (bool, string, error) {
+	spec := openstackv1alpha1.OctaviaAmphoraFlavorSpec{}
+	if b.instance.Spec.AmphoraFlavor != nil {
+		spec = *b.instance.Spec.AmphoraFlavor
+	}
+	flavor := &openstackv1alpha1.OpenStackFlavor{
+		ObjectMeta: metav1.ObjectMeta{Name: b.instance.Name + "-amphora", Namespace: b.instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, b.r.Client, flavor, func() error {
+		flavor.Labels = labelsForOctavia(b.instance.Name, "amphora")
+		flavor.Spec = openstackv1alpha1.OpenStackFlavorSpec{
+			Name:          b.instance.Name + "-amphora",
+			Description:   "Octavia amphora",
+			VCPUs:         max(spec.VCPUs, 1),
+			RAM:           max(spec.RAM, 1024),
+			Disk:          max(spec.Disk, 3),
+			Public:        false,
+			ProjectAccess: []string{b.status.ProjectID},
+			UpdatePolicy:  "Recreate",
+		}
+		return controllerutil.SetControllerReference(b.instance, flavor, b.r.Scheme)
+	}); err != nil {
+		return false, "", err
+	}
+	if flavor.Status.ObservedGeneration != flavor.Generation || !common.IsReady(flavor.Status.Conditions) || flavor.Status.FlavorID == "" {
+		return false, fmt.Sprintf("Waiting for OpenStackFlavor %s", flavor.Name), nil
+	}
+	b.status.FlavorID = flavor.Status.FlavorID
+	return true, fmt.Sprintf("Flavor %s", flavor.Status.FlavorID), nil
+}
+
+// ensureKeypair registers the SSH key injected into the amphorae as a
+// keypair of the octavia user. The private key stays in a Secret for
+// debugging amphorae.
+func (b *octaviaBootstrap) ensureKeypair(ctx context.Context) (bool, string, error) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: b.instance.Name + "-amphora-ssh", Namespace: b.instance.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, b.r.Client, secret, func() error {
+		if len(secret.Data["id_ecdsa"]) == 0 {
+			private, authorized, err := common.GenerateSSHKeyPair("octavia@" + b.instance.Namespace)
+			if err != nil {
+				return err
+			}
+			secret.Data = map[string][]byte{"id_ecdsa": []byte(private), "authorized_keys": []byte(authorized)}
+		}
+		secret.Labels = labelsForOctavia(b.instance.Name, "amphora")
+		return controllerutil.SetControllerReference(b.instance, secret, b.r.Scheme)
+	}); err != nil {
+		return false, "", err
+	}
+	publicKey := strings.TrimSpace(string(secret.Data["authorized_keys"]))
+
+	// Keypairs of other users are managed from microversion 2.10 on.
+	compute := b.osc.WithMicroversion("compute", "2.10")
+	path := fmt.Sprintf("/os-keypairs/%s?user_id=%s", octaviaKeypair, url.QueryEscape(b.userID))
+	var keypair struct {
+		Keypair struct {
+			PublicKey string `json:"public_key"`
+		} `json:"keypair"`
+	}
+	err := compute.Do(ctx, http.MethodGet, "compute", path, nil, &keypair)
+	switch {
+	case err == nil && strings.TrimSpace(keypair.Keypair.PublicKey) == publicKey:
+		b.status.KeypairName = octaviaKeypair
+		return true, fmt.Sprintf("Keypair %s of the octavia user", octaviaKeypair), nil
+	case err == nil:
+		if err := compute.Do(ctx, http.MethodDelete, "compute", path, nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return false, "", err
+		}
+	case !common.IsOpenStackNotFound(err):
+		return false, "", err
+	}
+	body := map[string]any{"keypair": map[string]any{
+		"name":       octaviaKeypair,
+		"type":       "ssh",
+		"public_key": publicKey,
+		"user_id":    b.userID,
+	}}
+	if err := compute.Do(ctx, http.MethodPost, "compute", "/os-keypairs", body, nil); err != nil {
+		return false, "", err
+	}
+	b.status.KeypairName = octaviaKeypair
+	return true, fmt.Sprintf("Keypair %s of the octavia user", octaviaKeypair), nil
+}
+
+// glanceImage is an image as represented by the Glance API.
+type glanceImage struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	Owner     string   `json:"owner"`
+	Tags      []string `json:"tags"`
+	CreatedAt string   `json:"created_at"`
+	SourceURL string   `json:"octavia_image_url"`
+}
+
+// ensureImage provides the amphora image named by the image Secret: it
+// imports an image from a URL into the service project, or uses an image
+// already in Glance, and tags it. Once the image is active, the tag is
+// removed from the amphora images it replaces, so that new amphorae use
+// it; existing amphorae keep running on their image until failed over.
+func (b *octaviaBootstrap) ensureImage(ctx context.Context) (bool, string, error) {
+	if b.instance.Spec.AmphoraImageSecretName == "" {
+		return false, "amphoraImageSecretName is required for the amphora provider", nil
+	}
+	secret := &corev1.Secret{}
+	if err := b.r.Get(ctx, client.ObjectKey{Name: b.instance.Spec.AmphoraImageSecretName, Namespace: b.instance.Namespace}, secret); err != nil {
+		return false, "", err
+	}
+	imageURL, imageRef := string(secret.Data["url"]), string(secret.Data["image"])
+
+	var image *glanceImage
+	var err error
+	switch {
+	case imageRef != "":
+		if image, err = b.findImage(ctx, imageRef); err != nil {
+			return false, "", err
+		}
+		if image == nil {
+			return false, fmt.Sprintf("Image %s not found", imageRef), nil
+		}
+		if !slices.Contains(image.Tags, octaviaImageTag) {
+			if err := b.osc.Do(ctx, http.MethodPut, "image", fmt.Sprintf("/v2/images/%s/tags/%s", image.ID, octaviaImageTag), nil, nil); err != nil {
+				return false, "", err
+			}
+		}
+	case imageURL != "":
+		if image, err = b.importImage(ctx, imageURL); err != nil {
+			return false, "", err
+		}
+	default:
+		return false, fmt.Sprintf("Secret %s has neither a url nor an image key", secret.Name), nil
+	}
+
+	if image.Status != "active" {
+		return false, fmt.Sprintf("Image %s is %s", image.ID, image.Status), nil
+	}
+	if err := b.untagImages(ctx, image); err != nil {
+		return false, "", err
+	}
+	b.status.ImageID = image.ID
+	b.status.ImageOwnerID = image.Owner
+	return true, fmt.Sprintf("Image %s", image.ID), nil
+}
+
+// findImage returns the image with the given ID or name, or nil.
+func (b *octaviaBootstrap) findImage(ctx context.Context, ref string) (*glanceImage, error) {
+	image := &glanceImage{}
+	err := b.osc.Do(ctx, http.MethodGet, "image", "/v2/images/"+url.PathEscape(ref), nil, image)
+	if err == nil {
+		return image, nil
+	}
+	if !common.IsOpenStackNotFound(err) {
+		return nil, err
+	}
+	var images struct {
+		Images []glanceImage `json:"images"`
+	}
+	if err := b.osc.Do(ctx, http.MethodGet, "image", "/v2/images?name="+url.QueryEscape(ref), nil, &images); err != nil {
+		return nil, err
+	}
+	if len(images.Images) == 0 {
+		return nil, nil
+	}
+	return &images.Images[0], nil
+}
+
+// importImage returns the image imported from imageURL, starting a
+// web-download import if there is none. Failed imports are deleted so
+// that the next attempt starts over.
+func (b *octaviaBootstrap) importImage(ctx context.Context, imageURL string) (*glanceImage, error) {
+	images, err := b.taggedImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range images {
+		image := &images[i]
+		if image.SourceURL != imageURL {
+			continue
+		}
+		if image.Status != "killed" {
+			return image, nil
+		}
+		if err := b.osc.Do(ctx, http.MethodDelete, "image", "/v2/images/"+image.ID, nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return nil, err
+		}
+	}
+
+	image := &glanceImage{}
+	body := map[string]any{
+		"name":                  b.instance.Name + "-amphora",
+		"disk_format":           "qcow2",
+		"container_format":      "bare",
+		"visibility":            "private",
+		"owner":                 b.status.ProjectID,
+		"tags":                  []string{octaviaImageTag},
+		octaviaImageURLProperty: imageURL,
+	}
+	if err := b.osc.Do(ctx, http.MethodPost, "image", "/v2/images", body, image); err != nil {
+		return nil, err
+	}
+	importBody := map[string]any{"method": map[string]any{"name": "web-download", "uri": imageURL}}
+	if err := b.osc.Do(ctx, http.MethodPost, "image", fmt.Sprintf("/v2/images/%s/import", image.ID), importBody, nil); err != nil {
+		return nil, err
+	}
+	log.FromContext(ctx).Info("importing amphora image", "image", image.ID, "url", imageURL)
+	image.Status = "importing"
+	return image, nil
+}
+
+// taggedImages returns the amphora images of the service project, newest
+// first.
+func (b *octaviaBootstrap) taggedImages(ctx context.Context) ([]glanceImage, error) {
+	var images struct {
+		Images []glanceImage `json:"images"`
+	}
+	query := url.Values{"tag": {octaviaImageTag}, "owner": {b.status.ProjectID}}
+	if err := b.osc.Do(ctx, http.MethodGet, "image", "/v2/images?"+query.Encode(), nil, &images); err != nil {
+		return nil, err
+	}
+	sort.Slice(images.Images, func(i, j int) bool { return images.Images[i].CreatedAt > images.Images[j].CreatedAt })
+	return images.Images, nil
+}
+
+// untagImages removes the amphora tag from the images of the service
+// project other than the current one.
+func (b *octaviaBootstrap) untagImages(ctx context.Context, current *glanceImage) error {
+	images, err := b.taggedImages(ctx)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if image.ID == current.ID {
+			continue
+		}
+		if err := b.osc.Do(ctx, http.MethodDelete, "image", fmt.Sprintf("/v2/images/%s/tags/%s", image.ID, octaviaImageTag), nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+		log.FromContext(ctx).Info("untagged replaced amphora image", "image", image.ID)
+	}
+	return nil
+}
+
+func octaviaManagementCIDR(instance *openstackv1alpha1.Octavia) string {
+	if instance.Spec.ManagementNetworkCIDR != "" {
+		return instance.Spec.ManagementNetworkCIDR
+	}
+	return "172.23.0.0/24"
+}
+
+func octaviaServerCASecretName(instance *openstackv1alpha1.Octavia) string {
+	return instance.Name + "-amphora-server-ca"
+}
+
+func octaviaClientCASecretName(instance *openstackv1alpha1.Octavia) string {
+	return instance.Name + "-amphora-client-ca"
+}
+
+func octaviaClientCertSecretName(instance *openstackv1alpha1.Octavia) string {
+	return instance.Name + "-amphora-client-cert"
+}
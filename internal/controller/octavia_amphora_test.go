@@ -0,0 +1,514 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// newOctaviaBootstrap returns the bootstrap of an amphora Octavia whose
+// service project is proj and octavia user uid, against a fake OpenStack
+// answering routes and a fake client holding objs.
+func newOctaviaBootstrap(t *testing.T, routes map[string]fakeResponse, objs ...client.Object) (*octaviaBootstrap, *fakeOpenStack) {
+	t.Helper()
+	osc, fos := newFakeOpenStack(t, routes)
+	instance := &openstackv1alpha1.Octavia{
+		ObjectMeta: metav1.ObjectMeta{Name: "octavia", Namespace: "openstack", UID: "octavia-uid"},
+		Spec:       openstackv1alpha1.OctaviaSpec{Provider: "amphora"},
+		Status: openstackv1alpha1.OctaviaStatus{Amphora: &openstackv1alpha1.OctaviaAmphoraStatus{
+			ProjectID:                    "proj",
+			ManagementNetworkID:          "net-1",
+			HealthManagerSecurityGroupID: "sg-hm",
+		}},
+	}
+	r := &OctaviaReconciler{Client: newFakeClient(t, objs...), Scheme: testScheme}
+	return &octaviaBootstrap{r: r, osc: osc, instance: instance, status: instance.Status.Amphora, userID: "uid"}, fos
+}
+
+func TestOctaviaEnsureCertificates(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newOctaviaBootstrap(t, map[string]fakeResponse{})
+
+	if done, _, err := b.ensureCertificates(ctx); err != nil || !done {
+		t.Fatalf("ensureCertificates = %v, %v", done, err)
+	}
+	cas := map[string][]byte{}
+	for _, name := range []string{octaviaServerCASecretName(b.instance), octaviaClientCASecretName(b.instance)} {
+		secret := &corev1.Secret{}
+		if err := b.r.Get(ctx, client.ObjectKey{Name: name, Namespace: "openstack"}, secret); err != nil {
+			t.Fatal(err)
+		}
+		if len(secret.OwnerReferences) != 0 {
+			t.Errorf("%s is owned by %v", name, secret.OwnerReferences)
+		}
+		if !common.HasFinalizer(secret, octaviaCAFinalizer) || secret.Immutable == nil || !*secret.Immutable {
+			t.Errorf("%s is not protected: finalizers %v, immutable %v", name, secret.Finalizers, secret.Immutable)
+		}
+		cas[name] = secret.Data["tls.crt"]
+	}
+	clientCert := &corev1.Secret{}
+	if err := b.r.Get(ctx, client.ObjectKey{Name: octaviaClientCertSecretName(b.instance), Namespace: "openstack"}, clientCert); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := common.ParseCertificate(clientCert.Data["tls.crt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := common.ParseCertificate(cas[octaviaClientCASecretName(b.instance)])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("client certificate not signed by the client CA: %v", err)
+	}
+
+	// The CAs are kept, and the client certificate is not reissued while
+	// it is current.
+	if _, _, err := b.ensureCertificates(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, certPEM := range cas {
+		secret := &corev1.Secret{}
+		if err := b.r.Get(ctx, client.ObjectKey{Name: name, Namespace: "openstack"}, secret); err != nil {
+			t.Fatal(err)
+		}
+		if string(secret.Data["tls.crt"]) != string(certPEM) {
+			t.Errorf("%s was regenerated", name)
+		}
+	}
+	again := &corev1.Secret{}
+	if err := b.r.Get(ctx, client.ObjectKey{Name: octaviaClientCertSecretName(b.instance), Namespace: "openstack"}, again); err != nil {
+		t.Fatal(err)
+	}
+	if string(again.Data["tls.crt"]) != string(clientCert.Data["tls.crt"]) {
+		t.Error("client certificate was reissued")
+	}
+}
+
+func TestOctaviaEnsureCA(t *testing.T) {
+	ctx := context.Background()
+	certPEM, keyPEM, err := common.GenerateCA("octavia-amphora-server-ca", octaviaCAValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := true
+	tests := []struct {
+		name    string
+		secret  *corev1.Secret
+		wantErr bool
+	}{
+		{
+			name: "owned by the Octavia",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "octavia-amphora-server-ca", Namespace: "openstack",
+					OwnerReferences: []metav1.OwnerReference{{APIVersion: "openstack.k8s.io/v1alpha1", Kind: "Octavia", Name: "octavia", UID: "octavia-uid", Controller: &controller}},
+				},
+				Data: map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM},
+			},
+		},
+		{
+			name: "without a key",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "octavia-amphora-server-ca", Namespace: "openstack"},
+				Data:       map[string][]byte{"tls.crt": certPEM},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newOctaviaBootstrap(t, map[string]fakeResponse{}, tt.secret)
+
+			_, err := b.ensureCA(ctx, tt.secret.Name, "octavia-amphora-server-ca")
+			secret := &corev1.Secret{}
+			if getErr := b.r.Get(ctx, client.ObjectKeyFromObject(tt.secret), secret); getErr != nil {
+				t.Fatal(getErr)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("invalid CA accepted")
+				}
+				if len(secret.Data["tls.key"]) != 0 {
+					t.Error("invalid CA was replaced")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(secret.OwnerReferences) != 0 || !common.HasFinalizer(secret, octaviaCAFinalizer) {
+				t.Errorf("owners %v, finalizers %v", secret.OwnerReferences, secret.Finalizers)
+			}
+			if string(secret.Data["tls.crt"]) != string(certPEM) {
+				t.Error("CA was regenerated")
+			}
+		})
+	}
+}
+
+func TestReleaseAmphoraCAs(t *testing.T) {
+	for _, policy := range []string{"Retain", "Delete"} {
+		t.Run(policy, func(t *testing.T) {
+			ctx := context.Background()
+			b, _ := newOctaviaBootstrap(t, map[string]fakeResponse{})
+			b.instance.Spec.AmphoraCADeletionPolicy = policy
+			if _, _, err := b.ensureCertificates(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := b.r.releaseAmphoraCAs(ctx, b.instance); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{octaviaServerCASecretName(b.instance), octaviaClientCASecretName(b.instance)} {
+				secret := &corev1.Secret{}
+				err := b.r.Get(ctx, client.ObjectKey{Name: name, Namespace: "openstack"}, secret)
+				switch {
+				case policy == "Delete" && !apierrors.IsNotFound(err):
+					t.Errorf("%s not deleted: %v", name, err)
+				case policy == "Retain" && err != nil:
+					t.Errorf("%s not retained: %v", name, err)
+				case policy == "Retain" && len(secret.Finalizers) != 0:
+					t.Errorf("%s still protected: %v", name, secret.Finalizers)
+				}
+			}
+		})
+	}
+}
+
+func TestOctaviaEnsureManagementNetwork(t *testing.T) {
+	const (
+		networks = "GET /v2.0/networks?name=lb-mgmt-net&project_id=proj"
+		subnets  = "GET /v2.0/subnets?name=lb-mgmt-subnet&network_id=net-1"
+	)
+	tests := []struct {
+		name           string
+		routes         map[string]fakeResponse
+		wantNetworkNew bool
+		wantSubnetNew  bool
+	}{
+		{
+			name: "created",
+			routes: map[string]fakeResponse{
+				networks:              {body: map[string]any{"networks": []any{}}},
+				"POST /v2.0/networks": {status: http.StatusCreated, body: map[string]any{"network": map[string]any{"id": "net-1", "mtu": 1442}}},
+				subnets:               {body: map[string]any{"subnets": []any{}}},
+				"POST /v2.0/subnets":  {status: http.StatusCreated, body: map[string]any{"subnet": map[string]any{"id": "subnet-1"}}},
+			},
+			wantNetworkNew: true,
+			wantSubnetNew:  true,
+		},
+		{
+			name: "subnet missing",
+			routes: map[string]fakeResponse{
+				networks:             {body: map[string]any{"networks": []any{map[string]any{"id": "net-1", "mtu": 1442}}}},
+				subnets:              {body: map[string]any{"subnets": []any{}}},
+				"POST /v2.0/subnets": {status: http.StatusCreated, body: map[string]any{"subnet": map[string]any{"id": "subnet-1"}}},
+			},
+			wantSubnetNew: true,
+		},
+		{
+			name: "existing",
+			routes: map[string]fakeResponse{
+				networks: {body: map[string]any{"networks": []any{map[string]any{"id": "net-1", "mtu": 1442}}}},
+				subnets:  {body: map[string]any{"subnets": []any{map[string]any{"id": "subnet-1"}}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, fos := newOctaviaBootstrap(t, tt.routes)
+			b.instance.Spec.ManagementNetworkCIDR = "172.24.0.0/24"
+
+			done, _, err := b.ensureManagementNetwork(context.Background())
+			if err != nil || !done {
+				t.Fatalf("ensureManagementNetwork = %v, %v", done, err)
+			}
+			if b.status.ManagementNetworkID != "net-1" || b.status.ManagementNetworkMTU != 1442 || b.status.ManagementSubnetID != "subnet-1" {
+				t.Errorf("status = %+v", b.status)
+			}
+			if got := fos.sent("POST /v2.0/networks"); got != tt.wantNetworkNew {
+				t.Errorf("network created = %v, want %v", got, tt.wantNetworkNew)
+			}
+			if got := fos.sent("POST /v2.0/subnets"); got != tt.wantSubnetNew {
+				t.Fatalf("subnet created = %v, want %v", got, tt.wantSubnetNew)
+			}
+			if tt.wantSubnetNew {
+				subnet := fos.body("POST /v2.0/subnets")["subnet"].(map[string]any)
+				if subnet["cidr"] != "172.24.0.0/24" || subnet["gateway_ip"] != nil || subnet["project_id"] != "proj" {
+					t.Errorf("subnet = %v", subnet)
+				}
+			}
+		})
+	}
+}
+
+func TestOctaviaEnsureSecurityGroups(t *testing.T) {
+	port := func(p int) *int { return &p }
+	cidr := "172.23.0.0/24"
+	b, fos := newOctaviaBootstrap(t, map[string]fakeResponse{
+		// The amphora group exists with the agent API rule only.
+		"GET /v2.0/security-groups?name=lb-mgmt-sec-grp&project_id=proj": {body: map[string]any{"security_groups": []any{map[string]any{
+			"id": "sg-amp",
+			"security_group_rules": []neutronSecurityGroupRule{
+				{Direction: "ingress", Ethertype: "IPv4", Protocol: "tcp", PortRangeMin: port(octaviaAmphoraAgentPort), PortRangeMax: port(octaviaAmphoraAgentPort), RemoteIPPrefix: cidr},
+			},
+		}}}},
+		"GET /v2.0/security-groups?name=lb-health-mgr-sec-grp&project_id=proj": {body: map[string]any{"security_groups": []any{}}},
+		"POST /v2.0/security-groups":      {status: http.StatusCreated, body: map[string]any{"security_group": map[string]any{"id": "sg-hm"}}},
+		"POST /v2.0/security-group-rules": {status: http.StatusCreated},
+	})
+
+	done, _, err := b.ensureSecurityGroups(context.Background())
+	if err != nil || !done {
+		t.Fatalf("ensureSecurityGroups = %v, %v", done, err)
+	}
+	if b.status.SecurityGroupID != "sg-amp" || b.status.HealthManagerSecurityGroupID != "sg-hm" {
+		t.Errorf("status = %+v", b.status)
+	}
+	if got := fos.count("POST /v2.0/security-groups"); got != 1 {
+		t.Errorf("created %d security groups, want 1", got)
+	}
+	// SSH and ping for the amphorae, the heartbeat port for the health
+	// managers.
+	if got := fos.count("POST /v2.0/security-group-rules"); got != 3 {
+		t.Errorf("created %d rules, want 3", got)
+	}
+}
+
+func TestOctaviaEnsureFlavor(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newOctaviaBootstrap(t, map[string]fakeResponse{})
+	b.instance.Spec.AmphoraFlavor = &openstackv1alpha1.OctaviaAmphoraFlavorSpec{VCPUs: 2}
+
+	done, message, err := b.ensureFlavor(ctx)
+	if err != nil || done {
+		t.Fatalf("ensureFlavor = %v, %q, %v before the flavor is ready", done, message, err)
+	}
+	flavor := &openstackv1alpha1.OpenStackFlavor{}
+	if err := b.r.Get(ctx, client.ObjectKey{Name: "octavia-amphora", Namespace: "openstack"}, flavor); err != nil {
+		t.Fatal(err)
+	}
+	if flavor.Spec.VCPUs != 2 || flavor.Spec.RAM != 1024 || flavor.Spec.Disk != 3 || flavor.Spec.Public ||
+		len(flavor.Spec.ProjectAccess) != 1 || flavor.Spec.ProjectAccess[0] != "proj" {
+		t.Errorf("flavor spec = %+v", flavor.Spec)
+	}
+
+	flavor.Status.FlavorID = "flavor-1"
+	flavor.Status.ObservedGeneration = flavor.Generation
+	flavor.Status.Conditions = common.SetCondition(nil, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Synced", "", flavor.Generation)
+	if err := b.r.Status().Update(ctx, flavor); err != nil {
+		t.Fatal(err)
+	}
+	if done, _, err := b.ensureFlavor(ctx); err != nil || !done {
+		t.Fatalf("ensureFlavor = %v, %v once the flavor is ready", done, err)
+	}
+	if b.status.FlavorID != "flavor-1" {
+		t.Errorf("FlavorID = %q", b.status.FlavorID)
+	}
+}
+
+func TestOctaviaEnsureKeypair(t *testing.T) {
+	const keypair = "/os-keypairs/octavia-amphora?user_id=uid"
+	sshSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "octavia-amphora-ssh", Namespace: "openstack"},
+		Data:       map[string][]byte{"id_ecdsa": []byte("private"), "authorized_keys": []byte("ecdsa-sha2-nistp256 AAAA octavia@openstack\n")},
+	}
+	tests := []struct {
+		name        string
+		current     *fakeResponse
+		wantDeleted bool
+		wantCreated bool
+	}{
+		{name: "missing", wantCreated: true},
+		{name: "current", current: &fakeResponse{body: map[string]any{"keypair": map[string]any{"public_key": "ecdsa-sha2-nistp256 AAAA octavia@openstack"}}}},
+		{name: "other key", current: &fakeResponse{body: map[string]any{"keypair": map[string]any{"public_key": "ssh-ed25519 BBBB"}}}, wantDeleted: true, wantCreated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := map[string]fakeResponse{
+				"DELETE " + keypair: {status: http.StatusAccepted},
+				"POST /os-keypairs": {status: http.StatusOK},
+			}
+			if tt.current != nil {
+				routes["GET "+keypair] = *tt.current
+			}
+			b, fos := newOctaviaBootstrap(t, routes, sshSecret.DeepCopy())
+
+			done, _, err := b.ensureKeypair(context.Background())
+			if err != nil || !done {
+				t.Fatalf("ensureKeypair = %v, %v", done, err)
+			}
+			if b.status.KeypairName != octaviaKeypair {
+				t.Errorf("KeypairName = %q", b.status.KeypairName)
+			}
+			if got := fos.sent("DELETE " + keypair); got != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", got, tt.wantDeleted)
+			}
+			if got := fos.sent("POST /os-keypairs"); got != tt.wantCreated {
+				t.Fatalf("created = %v, want %v", got, tt.wantCreated)
+			}
+			if tt.wantCreated {
+				body := fos.body("POST /os-keypairs")["keypair"].(map[string]any)
+				if body["user_id"] != "uid" || body["public_key"] != "ecdsa-sha2-nistp256 AAAA octavia@openstack" {
+					t.Errorf("keypair = %v", body)
+				}
+			}
+		})
+	}
+}
+
+func TestOctaviaEnsureImage(t *testing.T) {
+	const tagged = "GET /v2/images?owner=proj&tag=amphora"
+	imageSecret := func(data map[string]string) *corev1.Secret {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "amphora-image", Namespace: "openstack"}, Data: map[string][]byte{}}
+		for k, v := range data {
+			secret.Data[k] = []byte(v)
+		}
+		return secret
+	}
+	newer := glanceImage{ID: "img-2", Status: "active", Owner: "proj", Tags: []string{"amphora"}, CreatedAt: "2026-02-01T00:00:00Z", SourceURL: "https://example.com/amphora.qcow2"}
+	older := glanceImage{ID: "img-1", Status: "active", Owner: "proj", Tags: []string{"amphora"}, CreatedAt: "2026-01-01T00:00:00Z", SourceURL: "https://example.com/old.qcow2"}
+	tests := []struct {
+		name       string
+		secret     *corev1.Secret
+		routes     map[string]fakeResponse
+		wantDone   bool
+		wantImage  string
+		wantSent   []string
+		wantUnsent []string
+	}{
+		{
+			name:       "import started",
+			secret:     imageSecret(map[string]string{"url": "https://example.com/amphora.qcow2"}),
+			routes:     map[string]fakeResponse{tagged: {body: map[string]any{"images": []glanceImage{older}}}, "POST /v2/images": {status: http.StatusCreated, body: glanceImage{ID: "img-2", Status: "queued"}}, "POST /v2/images/img-2/import": {status: http.StatusAccepted}},
+			wantSent:   []string{"POST /v2/images", "POST /v2/images/img-2/import"},
+			wantUnsent: []string{"DELETE /v2/images/img-1/tags/amphora"},
+		},
+		{
+			name:       "imported image active",
+			secret:     imageSecret(map[string]string{"url": "https://example.com/amphora.qcow2"}),
+			routes:     map[string]fakeResponse{tagged: {body: map[string]any{"images": []glanceImage{older, newer}}}, "DELETE /v2/images/img-1/tags/amphora": {status: http.StatusNoContent}},
+			wantDone:   true,
+			wantImage:  "img-2",
+			wantSent:   []string{"DELETE /v2/images/img-1/tags/amphora"},
+			wantUnsent: []string{"POST /v2/images"},
+		},
+		{
+			name:   "failed import retried",
+			secret: imageSecret(map[string]string{"url": "https://example.com/amphora.qcow2"}),
+			routes: map[string]fakeResponse{
+				tagged:                         {body: map[string]any{"images": []glanceImage{{ID: "img-0", Status: "killed", Owner: "proj", SourceURL: "https://example.com/amphora.qcow2"}}}},
+				"DELETE /v2/images/img-0":      {status: http.StatusNoContent},
+				"POST /v2/images":              {status: http.StatusCreated, body: glanceImage{ID: "img-2", Status: "queued"}},
+				"POST /v2/images/img-2/import": {status: http.StatusAccepted},
+			},
+			wantSent: []string{"DELETE /v2/images/img-0", "POST /v2/images"},
+		},
+		{
+			name:      "existing image tagged",
+			secret:    imageSecret(map[string]string{"image": "img-3"}),
+			routes:    map[string]fakeResponse{"GET /v2/images/img-3": {body: glanceImage{ID: "img-3", Status: "active", Owner: "admin"}}, "PUT /v2/images/img-3/tags/amphora": {status: http.StatusNoContent}, tagged: {body: map[string]any{"images": []glanceImage{}}}},
+			wantDone:  true,
+			wantImage: "img-3",
+			wantSent:  []string{"PUT /v2/images/img-3/tags/amphora"},
+		},
+		{
+			name:   "existing image missing",
+			secret: imageSecret(map[string]string{"image": "amphora"}),
+			routes: map[string]fakeResponse{"GET /v2/images?name=amphora": {body: map[string]any{"images": []glanceImage{}}}},
+		},
+		{name: "neither url nor image", secret: imageSecret(nil), routes: map[string]fakeResponse{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, fos := newOctaviaBootstrap(t, tt.routes, tt.secret)
+			b.instance.Spec.AmphoraImageSecretName = tt.secret.Name
+
+			done, message, err := b.ensureImage(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if done != tt.wantDone {
+				t.Fatalf("done = %v (%s), want %v", done, message, tt.wantDone)
+			}
+			if b.status.ImageID != tt.wantImage {
+				t.Errorf("ImageID = %q, want %q", b.status.ImageID, tt.wantImage)
+			}
+			for _, key := range tt.wantSent {
+				if !fos.sent(key) {
+					t.Errorf("%s not sent", key)
+				}
+			}
+			for _, key := range tt.wantUnsent {
+				if fos.sent(key) {
+					t.Errorf("%s sent", key)
+				}
+			}
+		})
+	}
+}
+
+func TestOctaviaEnsureHealthManagerPorts(t *testing.T) {
+	ovn := &openstackv1alpha1.OVNNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "ovn", Namespace: "openstack"},
+		Status: openstackv1alpha1.OVNNetworkStatus{Chassis: []openstackv1alpha1.OVNChassisStatus{
+			{Node: "node-a", Registered: true},
+			{Node: "node-b", Registered: true},
+			{Node: "node-c", Registered: false},
+		}},
+	}
+	port := func(id, node, ip string) map[string]any {
+		return map[string]any{"id": id, "name": octaviaHealthManagerPortPrefix + node, "mac_address": "fa:16:3e:00:00:0" + id[len(id)-1:], "fixed_ips": []any{map[string]any{"ip_address": ip}}}
+	}
+	b, fos := newOctaviaBootstrap(t, map[string]fakeResponse{
+		"GET /v2.0/ports?device_owner=Octavia%3Ahealth-mgr&network_id=net-1": {body: map[string]any{"ports": []any{
+			port("port-1", "node-b", "172.23.0.11"),
+			// node-c is no longer registered.
+			port("port-2", "node-c", "172.23.0.12"),
+		}}},
+		"DELETE /v2.0/ports/port-2": {status: http.StatusNoContent},
+		"POST /v2.0/ports":          {status: http.StatusCreated, body: map[string]any{"port": port("port-3", "node-a", "172.23.0.13")}},
+	}, ovn)
+	replicas := int32(2)
+	b.instance.Spec.HealthManagerReplicas = &replicas
+
+	done, message, err := b.ensureHealthManagerPorts(context.Background())
+	if err != nil || !done {
+		t.Fatalf("ensureHealthManagerPorts = %v, %v", done, err)
+	}
+	if !strings.Contains(message, "node-a, node-b") {
+		t.Errorf("message = %q", message)
+	}
+	if !fos.sent("DELETE /v2.0/ports/port-2") || fos.sent("DELETE /v2.0/ports/port-1") {
+		t.Errorf("requests = %v", fos.requests)
+	}
+	created := fos.body("POST /v2.0/ports")["port"].(map[string]any)
+	if created["binding:host_id"] != "node-a" || created["device_owner"] != octaviaHealthManagerDeviceOwner {
+		t.Errorf("created port = %v", created)
+	}
+	want := []openstackv1alpha1.OctaviaHealthManagerStatus{
+		{Node: "node-a", PortID: "port-3", MACAddress: "fa:16:3e:00:00:03", IPAddress: "172.23.0.13"},
+		{Node: "node-b", PortID: "port-1", MACAddress: "fa:16:3e:00:00:01", IPAddress: "172.23.0.11"},
+	}
+	if len(b.status.HealthManagers) != len(want) {
+		t.Fatalf("HealthManagers = %+v", b.status.HealthManagers)
+	}
+	for i := range want {
+		if b.status.HealthManagers[i] != want[i] {
+			t.Errorf("HealthManagers[%d] = %+v, want %+v", i, b.status.HealthManagers[i], want[i])
+		}
+	}
+	if got := octaviaControllerIPPortList(b.status); got != "172.23.0.13:5555, 172.23.0.11:5555" {
+		t.Errorf("controller_ip_port_list = %q", got)
+	}
+}
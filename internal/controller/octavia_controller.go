@@ -0,0 +1,573 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	octaviaAPIPort           = 9876
+	octaviaHealthManagerPort = 5555
+)
+
+// octaviaComponent describes one Octavia Deployment.
+type octaviaComponent struct {
+	name         string
+	defaultImage string
+	command      []string
+	// port is exposed through a Service named <octavia>-<name>; 0 means none.
+	port int32
+	// amphora components talk to the amphorae over lb-mgmt-net, so they run
+	// in the host network of the health manager nodes.
+	amphora bool
+}
+
+// octaviaComponents lists the api first, as it is the only component of
+// the ovn provider.
+var octaviaComponents = []octaviaComponent{
+	{name: "api", defaultImage: images.DefaultOctaviaAPI, command: []string{"octavia-api", "--config-file", "/etc/octavia/octavia.conf"}, port: octaviaAPIPort},
+	{name: "worker", defaultImage: images.DefaultOctaviaWorker, command: []string{"octavia-worker", "--config-file", "/etc/octavia/octavia.conf"}, amphora: true},
+	{name: "housekeeping", defaultImage: images.DefaultOctaviaHousekeeping, command: []string{"octavia-housekeeping", "--config-file", "/etc/octavia/octavia.conf"}, amphora: true},
+}
+
+// OctaviaReconciler reconciles an Octavia object.
+type OctaviaReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=octavias,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=octavias/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=octavias/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackflavors,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=ovnnetworks,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=deployments;daemonsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
+
+func (r *OctaviaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.Octavia{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion: the health manager ports are bound to nodes and
+	// would be left behind. The other amphora resources may still be used
+	// by load balancers and are kept, as are the CAs they trust unless
+	// their deletion was confirmed.
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			if err := r.deleteHealthManagerPorts(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.releaseAmphoraCAs(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "Reconciling", "Reconciliation in progress", instance.Generation,
+	)
+
+	conns, err := common.ResolveConnections(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+	amphora := octaviaProvider(instance) == "amphora"
+	var ovn *openstackv1alpha1.OVNNetworkStatus
+	if !amphora {
+		if ovn, err = ovnNetworkStatus(ctx, r.Client, instance.Namespace); err != nil {
+			if errors.Is(err, common.ErrDependencyNotReady) {
+				return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Ensure generated credentials. The server certificate passphrase is a
+	// Fernet key and must be 32 URL-safe base64 characters.
+	for _, name := range []string{octaviaDBSecretName(instance), octaviaServiceSecretName(instance)} {
+		if err := common.EnsureSecret(ctx, r.Client, name, instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if amphora {
+		if err := common.EnsureSecret(ctx, r.Client, octaviaAmphoraKeysSecretName(instance), instance.Namespace,
+			map[string]int{"heartbeatKey": 32, "serverCertsKeyPassphrase": 32}, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Ensure database
+	if err := common.EnsureDatabase(ctx, r.Client, common.DatabaseParams{
+		Name:          instance.Name,
+		Namespace:     instance.Namespace,
+		DatabaseName:  "octavia",
+		Username:      "octavia",
+		SecretName:    octaviaDBSecretName(instance),
+		MariaDBSecret: conns.MariaDBSecret,
+		MariaDBHost:   conns.MariaDBHost,
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "CreatingDatabase", "Waiting for database creation")
+	}
+
+	// The octavia user owns the amphora keypair, so it is registered
+	// before the amphora bootstrap.
+	apiEndpoint := fmt.Sprintf("http://%s-api.%s.svc:%d", instance.Name, instance.Namespace, octaviaAPIPort)
+	if err := common.EnsureKeystoneEndpoint(ctx, r.Client, common.EndpointParams{
+		Name:              instance.Name,
+		Namespace:         instance.Namespace,
+		ServiceName:       "octavia",
+		ServiceType:       "load-balancer",
+		InternalURL:       apiEndpoint,
+		PublicURL:         apiEndpoint,
+		AdminURL:          apiEndpoint,
+		Region:            conns.Region,
+		KeystoneSecret:    conns.KeystoneSecret,
+		KeystoneURL:       conns.KeystoneURL,
+		BootstrapImage:    images.DefaultKeystone,
+		ServiceUser:       "octavia",
+		ServiceUserSecret: octaviaServiceSecretName(instance),
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-endpoint-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "RegisteringEndpoint", "Waiting for Keystone endpoint registration")
+	}
+
+	bootstrapped := "Keystone endpoint registered"
+	if amphora {
+		if result, err := r.ensureAmphoraBootstrap(ctx, instance, conns); err != nil || !result.IsZero() {
+			return result, err
+		}
+		bootstrapped = "Keystone endpoint and amphora resources are in place"
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady), metav1.ConditionTrue, "Bootstrapped", bootstrapped, instance.Generation,
+	)
+
+	configHash, err := r.ensureConfig(ctx, instance, conns, ovn)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	params := common.DBSyncParams{
+		Name:         instance.Name,
+		Namespace:    instance.Namespace,
+		Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultOctaviaAPI),
+		Command:      []string{"octavia-db-manage", "--config-file", "/etc/octavia/octavia.conf", "upgrade", "head"},
+		SecretName:   octaviaDBSecretName(instance),
+		Volumes:      []corev1.Volume{octaviaConfigVolume(instance)},
+		VolumeMounts: []corev1.VolumeMount{octaviaConfigVolumeMount()},
+	}
+	if err := common.EnsureDBSync(ctx, r.Client, params, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-db-sync", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDatabaseReady), "SyncingDatabase", "Waiting for database migration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDatabaseReady), metav1.ConditionTrue, "DatabaseSynced", "Database created and migrated", instance.Generation,
+	)
+
+	// Ensure Octavia services
+	components := octaviaProviderComponents(instance)
+	for _, component := range components {
+		name := instance.Name + "-" + component.name
+		labels := labelsForOctavia(instance.Name, component.name)
+		if err := r.ensureDeployment(ctx, instance, name, labels, component, configHash, ovn); err != nil {
+			return ctrl.Result{}, err
+		}
+		if component.port != 0 {
+			if err := r.ensureService(ctx, instance, name, labels, component); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	if amphora {
+		if err := r.ensureHealthManager(ctx, instance, configHash); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	instance.Status.APIEndpoint = apiEndpoint
+
+	var notReady, running []string
+	for _, component := range components {
+		deploy := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-" + component.name, Namespace: instance.Namespace}, deploy); err != nil {
+			return ctrl.Result{}, err
+		}
+		if !common.IsDeploymentReady(deploy) {
+			notReady = append(notReady, component.name)
+		}
+		running = append(running, "octavia-"+component.name)
+	}
+	if amphora {
+		ds := &appsv1.DaemonSet{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-health-manager", Namespace: instance.Namespace}, ds); err != nil {
+			return ctrl.Result{}, err
+		}
+		if ds.Status.ObservedGeneration < ds.Generation || ds.Status.DesiredNumberScheduled == 0 ||
+			ds.Status.NumberReady < ds.Status.DesiredNumberScheduled || ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled {
+			notReady = append(notReady, "health-manager")
+		}
+		running = append(running, "octavia-health-manager")
+	}
+	if len(notReady) > 0 {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "DeploymentNotReady", fmt.Sprintf("Waiting for %v", notReady))
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDeploymentReady), metav1.ConditionTrue, "DeploymentReady", fmt.Sprintf("%s available", strings.Join(running, ", ")), instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "Octavia is ready", instance.Generation,
+	)
+
+	if err := r.updateStatus(ctx, instance); err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	if !amphora {
+		return ctrl.Result{}, nil
+	}
+	// Chassis come and go without an event on the Octavia, so the health
+	// manager nodes are checked periodically.
+	return ctrl.Result{RequeueAfter: time.Minute}, nil
+}
+
+// waitFor records a not-yet-satisfied condition and requeues.
+func (r *OctaviaReconciler) waitFor(ctx context.Context, instance *openstackv1alpha1.Octavia, condType, reason, message string) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(instance.Status.Conditions, condType, metav1.ConditionFalse, reason, message, instance.Generation)
+	if condType != string(openstackv1alpha1.ConditionReady) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+func (r *OctaviaReconciler) updateStatus(ctx context.Context, instance *openstackv1alpha1.Octavia) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	return r.Status().Update(ctx, instance)
+}
+
+// ensureConfig renders octavia.conf into a Secret (it embeds credentials)
+// and returns its hash. ovn is nil for the amphora provider.
+func (r *OctaviaReconciler) ensureConfig(ctx context.Context, instance *openstackv1alpha1.Octavia, conns *common.Connections, ovn *openstackv1alpha1.OVNNetworkStatus) (string, error) {
+	dbPassword, err := common.GetSecretValue(ctx, r.Client, octaviaDBSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	servicePassword, err := common.GetSecretValue(ctx, r.Client, octaviaServiceSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+	mqSecret := instance.Spec.MessageQueue.SecretName
+	if mqSecret == "" {
+		mqSecret = conns.RabbitMQSecret
+	}
+	mqUser, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "username")
+	if err != nil {
+		return "", err
+	}
+	mqPassword, err := common.GetSecretValue(ctx, r.Client, mqSecret, instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+
+	data := map[string]any{
+		"DatabaseURL":      common.DatabaseURL("octavia", dbPassword, conns.MariaDBHost, "octavia"),
+		"TransportURL":     common.TransportURL(mqUser, mqPassword, conns.RabbitMQHost),
+		"KeystoneURL":      conns.KeystoneURL,
+		"MemcachedServers": conns.MemcachedServers,
+		"Region":           conns.Region,
+		"ServiceUser":      "octavia",
+		"ServicePassword":  servicePassword,
+	}
+	if ovn != nil {
+		data["OVN"] = map[string]any{
+			"NBConnection": ovn.NorthboundDBEndpoint,
+			"SBConnection": ovn.SouthboundDBEndpoint,
+			"TLS":          ovn.TLSSecretName != "",
+		}
+	} else if amphora := instance.Status.Amphora; amphora != nil {
+		heartbeatKey, err := common.GetSecretValue(ctx, r.Client, octaviaAmphoraKeysSecretName(instance), instance.Namespace, "heartbeatKey")
+		if err != nil {
+			return "", err
+		}
+		passphrase, err := common.GetSecretValue(ctx, r.Client, octaviaAmphoraKeysSecretName(instance), instance.Namespace, "serverCertsKeyPassphrase")
+		if err != nil {
+			return "", err
+		}
+		data["Amphora"] = map[string]any{
+			"HealthManagerPort":        octaviaHealthManagerPort,
+			"ControllerIPPortList":     octaviaControllerIPPortList(amphora),
+			"HeartbeatKey":             heartbeatKey,
+			"ImageOwnerID":             amphora.ImageOwnerID,
+			"FlavorID":                 amphora.FlavorID,
+			"ManagementNetworkID":      amphora.ManagementNetworkID,
+			"SecurityGroupID":          amphora.SecurityGroupID,
+			"KeypairName":              amphora.KeypairName,
+			"ServerCertsKeyPassphrase": passphrase,
+		}
+	}
+	conf, err := common.RenderTemplate("octavia/octavia.conf", data)
+	if err != nil {
+		return "", err
+	}
+	files := map[string]string{"octavia.conf": conf}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-config", Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labelsForOctavia(instance.Name, "config")
+		secret.Data = map[string][]byte{"octavia.conf": []byte(conf)}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return "", err
+	}
+	return common.ConfigHash(files), nil
+}
+
+// ensureDeployment runs one component. With the ovn provider the api pod
+// also runs the OVN driver agent, which octavia-api reaches through
+// sockets in /var/run/octavia and which keeps the load balancer status in
+// sync with OVN.
+func (r *OctaviaReconciler) ensureDeployment(ctx context.Context, instance *openstackv1alpha1.Octavia, name string, labels map[string]string, component octaviaComponent, configHash string, ovn *openstackv1alpha1.OVNNetworkStatus) error {
+	replicas := int32(1)
+	switch component.name {
+	case "worker":
+		if instance.Spec.WorkerReplicas != nil {
+			replicas = *instance.Spec.WorkerReplicas
+		}
+	case "api":
+		if instance.Spec.Replicas != nil {
+			replicas = *instance.Spec.Replicas
+		}
+	}
+	volumes := []corev1.Volume{octaviaConfigVolume(instance)}
+	mounts := []corev1.VolumeMount{octaviaConfigVolumeMount()}
+	if component.amphora {
+		volumes = append(volumes, octaviaCertsVolume(instance))
+		mounts = append(mounts, corev1.VolumeMount{Name: "certs", MountPath: "/etc/octavia/certs", ReadOnly: true})
+	}
+	container := corev1.Container{
+		Name:         "octavia-" + component.name,
+		Image:        images.ImageOrDefault(instance.Spec.Image, component.defaultImage),
+		Command:      component.command,
+		Resources:    instance.Spec.Resources,
+		VolumeMounts: mounts,
+	}
+	if component.port != 0 {
+		container.Ports = []corev1.ContainerPort{{ContainerPort: component.port, Name: component.name}}
+		container.ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt32(component.port)},
+			},
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       10,
+		}
+	}
+	containers := []corev1.Container{container}
+	if ovn != nil {
+		volumes = append(volumes, corev1.Volume{Name: "run", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+		mounts = append(mounts, corev1.VolumeMount{Name: "run", MountPath: "/var/run/octavia"})
+		if ovn.TLSSecretName != "" {
+			volumes = append(volumes, corev1.Volume{
+				Name:         "ovn-tls",
+				VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: ovn.TLSSecretName}},
+			})
+			mounts = append(mounts, corev1.VolumeMount{Name: "ovn-tls", MountPath: "/etc/ovn/tls", ReadOnly: true})
+		}
+		container.VolumeMounts = mounts
+		containers = []corev1.Container{container, {
+			Name:         "octavia-driver-agent",
+			Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultOctaviaDriverAgent),
+			Command:      []string{"octavia-driver-agent", "--config-file", "/etc/octavia/octavia.conf"},
+			Resources:    instance.Spec.Resources,
+			VolumeMounts: mounts,
+		}}
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		deploy.Spec.Template.Spec.Affinity = nil
+		deploy.Spec.Template.Spec.HostNetwork = false
+		deploy.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirst
+		if component.amphora {
+			deploy.Spec.Template.Spec.NodeSelector = nil
+			deploy.Spec.Template.Spec.Affinity = octaviaHealthManagerAffinity(instance)
+			deploy.Spec.Template.Spec.HostNetwork = true
+			deploy.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		}
+		deploy.Spec.Template.Spec.Containers = containers
+		deploy.Spec.Template.Spec.Volumes = volumes
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
+
+func (r *OctaviaReconciler) ensureService(ctx context.Context, instance *openstackv1alpha1.Octavia, name string, labels map[string]string, component octaviaComponent) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labels
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: component.name, Port: component.port, TargetPort: intstr.FromInt32(component.port), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+// deleteHealthManagerPorts removes the lb-mgmt-net ports of the health
+// managers. If the cloud itself is gone there is nothing left to clean up.
+func (r *OctaviaReconciler) deleteHealthManagerPorts(ctx context.Context, instance *openstackv1alpha1.Octavia) error {
+	if instance.Status.Amphora == nil || len(instance.Status.Amphora.HealthManagers) == 0 {
+		return nil
+	}
+	conns, err := common.ResolveConnections(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			log.FromContext(ctx).Info("OpenStack unavailable, not deleting health manager ports", "reason", err.Error())
+			return nil
+		}
+		return err
+	}
+	osc, err := common.NewOpenStackClient(ctx, r.Client, instance.Namespace, conns)
+	if err != nil {
+		return err
+	}
+	for _, hm := range instance.Status.Amphora.HealthManagers {
+		if err := osc.Do(ctx, http.MethodDelete, "network", "/v2.0/ports/"+hm.PortID, nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *OctaviaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.Octavia{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}).
+		Owns(&batchv1.Job{}).
+		Owns(&openstackv1alpha1.OpenStackFlavor{}).
+		Complete(r)
+}
+
+// octaviaProviderComponents returns the components the provider runs.
+func octaviaProviderComponents(instance *openstackv1alpha1.Octavia) []octaviaComponent {
+	if octaviaProvider(instance) == "ovn" {
+		return octaviaComponents[:1]
+	}
+	return octaviaComponents
+}
+
+func octaviaProvider(instance *openstackv1alpha1.Octavia) string {
+	if instance.Spec.Provider != "" {
+		return instance.Spec.Provider
+	}
+	return "amphora"
+}
+
+func octaviaDBSecretName(instance *openstackv1alpha1.Octavia) string {
+	if instance.Spec.Database.SecretName != "" {
+		return instance.Spec.Database.SecretName
+	}
+	return instance.Name + "-db-password"
+}
+
+func octaviaServiceSecretName(instance *openstackv1alpha1.Octavia) string {
+	return instance.Name + "-service-password"
+}
+
+func octaviaAmphoraKeysSecretName(instance *openstackv1alpha1.Octavia) string {
+	return instance.Name + "-amphora-keys"
+}
+
+func octaviaConfigVolume(instance *openstackv1alpha1.Octavia) corev1.Volume {
+	return corev1.Volume{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: instance.Name + "-config"},
+		},
+	}
+}
+
+// octaviaConfigVolumeMount mounts only octavia.conf, so that the
+// certificates can be mounted below /etc/octavia as well.
+func octaviaConfigVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{Name: "config", MountPath: "/etc/octavia/octavia.conf", SubPath: "octavia.conf", ReadOnly: true}
+}
+
+func labelsForOctavia(name, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "octavia",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/component":  component,
+		"app.kubernetes.io/managed-by": "openstack-operator",
+	}
+}
@@ -0,0 +1,332 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// octaviaSecrets are the password Secrets ensureConfig reads.
+func octaviaSecrets() []client.Object {
+	password := func(name, key, value string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openstack"},
+			Data:       map[string][]byte{key: []byte(value)},
+		}
+	}
+	mq := password("rabbitmq-credentials", "password", "mqpass")
+	mq.Data["username"] = []byte("octavia")
+	return []client.Object{
+		password("octavia-db-password", "password", "dbpass"),
+		password("octavia-service-password", "password", "svcpass"),
+		mq,
+	}
+}
+
+func TestOctaviaEnsureConfigOVN(t *testing.T) {
+	conns := &common.Connections{
+		Region: "RegionOne", MariaDBHost: "mariadb.openstack.svc", RabbitMQHost: "rabbitmq.openstack.svc",
+		RabbitMQSecret: "rabbitmq-credentials", KeystoneURL: "http://keystone-api.openstack.svc:5000/v3",
+	}
+	tests := []struct {
+		name     string
+		provider string
+		ovn      *openstackv1alpha1.OVNNetworkStatus
+		want     []string
+		wantNot  []string
+	}{
+		{
+			name:     "ovn",
+			provider: "ovn",
+			ovn:      &openstackv1alpha1.OVNNetworkStatus{NorthboundDBEndpoint: "tcp:ovn-nb.openstack.svc:6641", SouthboundDBEndpoint: "tcp:ovn-sb.openstack.svc:6642"},
+			want: []string{
+				"default_provider_driver = ovn",
+				"enabled_provider_agents = ovn",
+				"ovn_nb_connection = tcp:ovn-nb.openstack.svc:6641",
+				"ovn_sb_connection = tcp:ovn-sb.openstack.svc:6642",
+			},
+			wantNot: []string{"ovn_nb_private_key", "[health_manager]"},
+		},
+		{
+			name:     "ovn with TLS",
+			provider: "ovn",
+			ovn: &openstackv1alpha1.OVNNetworkStatus{
+				NorthboundDBEndpoint: "ssl:ovn-nb.openstack.svc:6641", SouthboundDBEndpoint: "ssl:ovn-sb.openstack.svc:6642", TLSSecretName: "ovn-tls",
+			},
+			want: []string{
+				"ovn_nb_connection = ssl:ovn-nb.openstack.svc:6641",
+				"ovn_sb_connection = ssl:ovn-sb.openstack.svc:6642",
+				"ovn_nb_private_key = /etc/ovn/tls/tls.key",
+				"ovn_sb_ca_cert = /etc/ovn/tls/ca.crt",
+			},
+		},
+		{
+			name:     "amphora",
+			provider: "amphora",
+			wantNot:  []string{"[ovn]", "ovn_nb_connection", "enabled_provider_agents"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := &openstackv1alpha1.Octavia{
+				ObjectMeta: metav1.ObjectMeta{Name: "octavia", Namespace: "openstack", UID: "octavia-uid"},
+				Spec:       openstackv1alpha1.OctaviaSpec{Provider: tt.provider},
+			}
+			r := &OctaviaReconciler{Client: newFakeClient(t, octaviaSecrets()...), Scheme: testScheme}
+
+			hash, err := r.ensureConfig(ctx, instance, conns, tt.ovn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			secret := &corev1.Secret{}
+			if err := r.Get(ctx, client.ObjectKey{Name: "octavia-config", Namespace: "openstack"}, secret); err != nil {
+				t.Fatal(err)
+			}
+			conf := string(secret.Data["octavia.conf"])
+			for _, line := range tt.want {
+				if !strings.Contains(conf, line+"\n") {
+					t.Errorf("octavia.conf lacks %q:\n%s", line, conf)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(conf, s) {
+					t.Errorf("octavia.conf holds %q:\n%s", s, conf)
+				}
+			}
+
+			// The api pod restarts when the endpoints move.
+			if tt.ovn != nil {
+				moved := *tt.ovn
+				moved.NorthboundDBEndpoint += "0"
+				again, err := r.ensureConfig(ctx, instance, conns, &moved)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if again == hash {
+					t.Error("config hash unchanged by a new northbound endpoint")
+				}
+			}
+		})
+	}
+}
+
+func TestOctaviaEnsureDeploymentOVN(t *testing.T) {
+	tests := []struct {
+		name       string
+		ovn        *openstackv1alpha1.OVNNetworkStatus
+		wantTLS    bool
+		containers int
+	}{
+		{name: "amphora", containers: 1},
+		{name: "ovn", ovn: &openstackv1alpha1.OVNNetworkStatus{NorthboundDBEndpoint: "tcp:nb:6641", SouthboundDBEndpoint: "tcp:sb:6642"}, containers: 2},
+		{name: "ovn with TLS", ovn: &openstackv1alpha1.OVNNetworkStatus{NorthboundDBEndpoint: "ssl:nb:6641", SouthboundDBEndpoint: "ssl:sb:6642", TLSSecretName: "ovn-tls"}, wantTLS: true, containers: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			instance := &openstackv1alpha1.Octavia{ObjectMeta: metav1.ObjectMeta{Name: "octavia", Namespace: "openstack", UID: "octavia-uid"}}
+			r := &OctaviaReconciler{Client: newFakeClient(t), Scheme: testScheme}
+			labels := labelsForOctavia(instance.Name, "api")
+			if err := r.ensureDeployment(ctx, instance, "octavia-api", labels, octaviaComponents[0], "hash", tt.ovn); err != nil {
+				t.Fatal(err)
+			}
+			deploy := &appsv1.Deployment{}
+			if err := r.Get(ctx, client.ObjectKey{Name: "octavia-api", Namespace: "openstack"}, deploy); err != nil {
+				t.Fatal(err)
+			}
+			containers := deploy.Spec.Template.Spec.Containers
+			if len(containers) != tt.containers {
+				t.Fatalf("containers = %d, want %d", len(containers), tt.containers)
+			}
+			if tt.ovn == nil {
+				return
+			}
+			// The api and the driver agent share the agent sockets and the
+			// OVN client certificate.
+			for _, c := range containers {
+				mounts := map[string]string{}
+				for _, m := range c.VolumeMounts {
+					mounts[m.Name] = m.MountPath
+				}
+				if mounts["run"] != "/var/run/octavia" {
+					t.Errorf("%s mounts %v, want the agent sockets", c.Name, mounts)
+				}
+				if _, ok := mounts["ovn-tls"]; ok != tt.wantTLS {
+					t.Errorf("%s mounts %v, want OVN TLS %v", c.Name, mounts, tt.wantTLS)
+				}
+			}
+			if containers[1].Name != "octavia-driver-agent" {
+				t.Errorf("second container = %s", containers[1].Name)
+			}
+			if tt.wantTLS {
+				found := false
+				for _, v := range deploy.Spec.Template.Spec.Volumes {
+					found = found || (v.Secret != nil && v.Secret.SecretName == "ovn-tls")
+				}
+				if !found {
+					t.Error("OVN TLS Secret not mounted")
+				}
+			}
+		})
+	}
+}
+
+func TestOctaviaReconcileWaitsForOVN(t *testing.T) {
+	tests := []struct {
+		name        string
+		ovn         *openstackv1alpha1.OVNNetwork
+		wantMessage string
+	}{
+		{name: "no OVNNetwork", wantMessage: "OVNNetwork: "},
+		{
+			name: "southbound not ready",
+			ovn: &openstackv1alpha1.OVNNetwork{
+				ObjectMeta: metav1.ObjectMeta{Name: "ovn", Namespace: "openstack"},
+				Status:     openstackv1alpha1.OVNNetworkStatus{NorthboundDBEndpoint: "tcp:nb:6641"},
+			},
+			wantMessage: "OVNNetwork endpoints: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			_, fos := newFakeOpenStack(t, map[string]fakeResponse{})
+			objs := append(fos.controlPlane(), &openstackv1alpha1.Octavia{
+				ObjectMeta: metav1.ObjectMeta{Name: "octavia", Namespace: "openstack"},
+				Spec:       openstackv1alpha1.OctaviaSpec{Provider: "ovn"},
+			})
+			if tt.ovn != nil {
+				objs = append(objs, tt.ovn)
+			}
+			r := &OctaviaReconciler{Client: newFakeClient(t, objs...), Scheme: testScheme}
+
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "octavia", Namespace: "openstack"}}); err != nil {
+				t.Fatal(err)
+			}
+			instance := &openstackv1alpha1.Octavia{}
+			if err := r.Get(ctx, client.ObjectKey{Name: "octavia", Namespace: "openstack"}, instance); err != nil {
+				t.Fatal(err)
+			}
+			cond := meta.FindStatusCondition(instance.Status.Conditions, string(openstackv1alpha1.ConditionReady))
+			if cond == nil || cond.Reason != "WaitingForDependencies" || !strings.HasPrefix(cond.Message, tt.wantMessage) {
+				t.Fatalf("Ready = %+v, want waiting for %q", cond, tt.wantMessage)
+			}
+			if err := r.Get(ctx, client.ObjectKey{Name: "octavia-config", Namespace: "openstack"}, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+				t.Errorf("config rendered before OVN is ready: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeleteHealthManagerPorts(t *testing.T) {
+	healthManagers := []openstackv1alpha1.OctaviaHealthManagerStatus{
+		{Node: "node-a", PortID: "port-1"},
+		{Node: "node-b", PortID: "port-2"},
+	}
+	tests := []struct {
+		name           string
+		healthManagers []openstackv1alpha1.OctaviaHealthManagerStatus
+		noControlPlane bool
+		routes         map[string]fakeResponse
+		wantDeleted    []string
+		wantErr        bool
+	}{
+		{name: "none"},
+		{
+			name:           "deleted",
+			healthManagers: healthManagers,
+			routes: map[string]fakeResponse{
+				"DELETE /v2.0/ports/port-1": {status: http.StatusNoContent},
+				// Already gone.
+				"DELETE /v2.0/ports/port-2": {status: http.StatusNotFound},
+			},
+			wantDeleted: []string{"DELETE /v2.0/ports/port-1", "DELETE /v2.0/ports/port-2"},
+		},
+		{
+			name:           "OpenStack gone",
+			healthManagers: healthManagers,
+			noControlPlane: true,
+		},
+		{
+			name:           "neutron failing",
+			healthManagers: healthManagers,
+			routes:         map[string]fakeResponse{"DELETE /v2.0/ports/port-1": {status: http.StatusInternalServerError}},
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := tt.routes
+			if routes == nil {
+				routes = map[string]fakeResponse{}
+			}
+			_, fos := newFakeOpenStack(t, routes)
+			var objs []client.Object
+			if !tt.noControlPlane {
+				objs = fos.controlPlane()
+			}
+			instance := &openstackv1alpha1.Octavia{
+				ObjectMeta: metav1.ObjectMeta{Name: "octavia", Namespace: "openstack"},
+				Status: openstackv1alpha1.OctaviaStatus{Amphora: &openstackv1alpha1.OctaviaAmphoraStatus{
+					ManagementNetworkID: "net-1",
+					HealthManagers:      tt.healthManagers,
+				}},
+			}
+			r := &OctaviaReconciler{Client: newFakeClient(t, objs...), Scheme: testScheme}
+
+			err := r.deleteHealthManagerPorts(context.Background(), instance)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			for _, key := range tt.wantDeleted {
+				if !fos.sent(key) {
+					t.Errorf("%s not sent; requests %v", key, fos.requests)
+				}
+			}
+			if len(tt.wantDeleted) == 0 && !tt.wantErr && len(fos.requests) != 0 {
+				t.Errorf("requests = %v, want none", fos.requests)
+			}
+		})
+	}
+}
+
+func TestOctaviaReconcileDeletion(t *testing.T) {
+	ctx := context.Background()
+	_, fos := newFakeOpenStack(t, map[string]fakeResponse{
+		"DELETE /v2.0/ports/port-1": {status: http.StatusNoContent},
+	})
+	now := metav1.Now()
+	instance := &openstackv1alpha1.Octavia{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "octavia", Namespace: "openstack", UID: "octavia-uid",
+			Finalizers: []string{common.FinalizerName}, DeletionTimestamp: &now,
+		},
+		Status: openstackv1alpha1.OctaviaStatus{Amphora: &openstackv1alpha1.OctaviaAmphoraStatus{
+			HealthManagers: []openstackv1alpha1.OctaviaHealthManagerStatus{{Node: "node-a", PortID: "port-1"}},
+		}},
+	}
+	r := &OctaviaReconciler{Client: newFakeClient(t, append(fos.controlPlane(), instance)...), Scheme: testScheme}
+
+	if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)}); err != nil {
+		t.Fatal(err)
+	}
+	if !fos.sent("DELETE /v2.0/ports/port-1") {
+		t.Errorf("health manager port not deleted; requests %v", fos.requests)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(instance), &openstackv1alpha1.Octavia{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Octavia still present after its finalizer ran: %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	octaviaHealthManagerDeviceOwner = "Octavia:health-mgr"
+	octaviaHealthManagerPortPrefix  = "octavia-health-manager-"
+	// octaviaHealthManagerInterface is the OVS internal port on br-int
+	// through which a health manager node is attached to lb-mgmt-net.
+	octaviaHealthManagerInterface = "o-hm0"
+	octaviaHealthManagerBindDir   = "/etc/octavia/health-manager"
+)
+
+// neutronPort is a port as represented by the Neutron API.
+type neutronPort struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	MACAddress string `json:"mac_address"`
+	FixedIPs   []struct {
+		IPAddress string `json:"ip_address"`
+	} `json:"fixed_ips"`
+}
+
+// ensureHealthManagerPorts gives each health manager node a port on
+// lb-mgmt-net bound to its chassis, and deletes the ports of nodes that no
+// longer run a health manager. The port addresses make up
+// controller_ip_port_list, the heartbeat targets of new amphorae.
+func (b *octaviaBootstrap) ensureHealthManagerPorts(ctx context.Context) (bool, string, error) {
+	nodes, err := b.r.healthManagerNodes(ctx, b.instance)
+	if err != nil {
+		return false, "", err
+	}
+	if len(nodes) == 0 {
+		return false, "No registered OVN chassis to run health managers on", nil
+	}
+
+	var ports struct {
+		Ports []neutronPort `json:"ports"`
+	}
+	query := url.Values{"network_id": {b.status.ManagementNetworkID}, "device_owner": {octaviaHealthManagerDeviceOwner}}
+	if err := b.osc.Do(ctx, http.MethodGet, "network", "/v2.0/ports?"+query.Encode(), nil, &ports); err != nil {
+		return false, "", err
+	}
+	byNode := map[string]neutronPort{}
+	for _, port := range ports.Ports {
+		node := strings.TrimPrefix(port.Name, octaviaHealthManagerPortPrefix)
+		if _, dup := byNode[node]; !dup && slices.Contains(nodes, node) && len(port.FixedIPs) > 0 {
+			byNode[node] = port
+			continue
+		}
+		if err := b.osc.Do(ctx, http.MethodDelete, "network", "/v2.0/ports/"+port.ID, nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return false, "", err
+		}
+		log.FromContext(ctx).Info("deleted health manager port", "port", port.ID, "name", port.Name)
+	}
+
+	healthManagers := make([]openstackv1alpha1.OctaviaHealthManagerStatus, 0, len(nodes))
+	for _, node := range nodes {
+		port, ok := byNode[node]
+		if !ok {
+			var created struct {
+				Port neutronPort `json:"port"`
+			}
+			body := map[string]any{"port": map[string]any{
+				"name":            octaviaHealthManagerPortPrefix + node,
+				"network_id":      b.status.ManagementNetworkID,
+				"project_id":      b.status.ProjectID,
+				"device_owner":    octaviaHealthManagerDeviceOwner,
+				"device_id":       octaviaHealthManagerPortPrefix + node,
+				"binding:host_id": node,
+				"security_groups": []string{b.status.HealthManagerSecurityGroupID},
+				"admin_state_up":  true,
+			}}
+			if err := b.osc.Do(ctx, http.MethodPost, "network", "/v2.0/ports", body, &created); err != nil {
+				return false, "", err
+			}
+			port = created.Port
+			if len(port.FixedIPs) == 0 {
+				return false, "", fmt.Errorf("health manager port %s has no fixed IP", port.ID)
+			}
+			log.FromContext(ctx).Info("created health manager port", "port", port.ID, "node", node)
+		}
+		healthManagers = append(healthManagers, openstackv1alpha1.OctaviaHealthManagerStatus{
+			Node:       node,
+			PortID:     port.ID,
+			MACAddress: port.MACAddress,
+			IPAddress:  port.FixedIPs[0].IPAddress,
+		})
+	}
+	b.status.HealthManagers = healthManagers
+	return true, fmt.Sprintf("Health manager ports on %s", strings.Join(nodes, ", ")), nil
+}
+
+// healthManagerNodes picks the nodes to run health managers on among the
+// registered OVN chassis. Nodes already running one are kept while they
+// stay registered, since amphorae send their heartbeats to them; the rest
+// are filled up by name.
+func (r *OctaviaReconciler) healthManagerNodes(ctx context.Context, instance *openstackv1alpha1.Octavia) ([]string, error) {
+	ovns := &openstackv1alpha1.OVNNetworkList{}
+	if err := r.List(ctx, ovns, client.InNamespace(instance.Namespace)); err != nil {
+		return nil, err
+	}
+	var registered []string
+	for _, ovn := range ovns.Items {
+		for _, chassis := range ovn.Status.Chassis {
+			if chassis.Registered {
+				registered = append(registered, chassis.Node)
+			}
+		}
+	}
+	sort.Strings(registered)
+
+	replicas := 1
+	if instance.Spec.HealthManagerReplicas != nil {
+		replicas = int(*instance.Spec.HealthManagerReplicas)
+	}
+	var nodes []string
+	if amphora := instance.Status.Amphora; amphora != nil {
+		for _, hm := range amphora.HealthManagers {
+			if len(nodes) < replicas && slices.Contains(registered, hm.Node) {
+				nodes = append(nodes, hm.Node)
+			}
+		}
+	}
+	for _, node := range registered {
+		if len(nodes) < replicas && !slices.Contains(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// ensureHealthManager runs octavia-health-manager on the health manager
+// nodes. An init container plugs the node's lb-mgmt-net port into br-int
+// as an internal interface, which the worker and housekeeping on the node
+// use as well, and binds the health manager to its address.
+func (r *OctaviaReconciler) ensureHealthManager(ctx context.Context, instance *openstackv1alpha1.Octavia, configHash string) error {
+	amphora := instance.Status.Amphora
+	_, cidr, err := net.ParseCIDR(octaviaManagementCIDR(instance))
+	if err != nil {
+		return err
+	}
+	prefix, _ := cidr.Mask.Size()
+	mtu := amphora.ManagementNetworkMTU
+	if mtu == 0 {
+		mtu = 1442
+	}
+
+	var script strings.Builder
+	script.WriteString("set -e\ncase \"$NODE_NAME\" in\n")
+	for _, hm := range amphora.HealthManagers {
+		fmt.Fprintf(&script, "%s) PORT_ID=%s MAC=%s IP=%s ;;\n", hm.Node, hm.PortID, hm.MACAddress, hm.IPAddress)
+	}
+	fmt.Fprintf(&script, `*) echo "no health manager port for node $NODE_NAME" >&2; exit 1 ;;
+esac
+ovs-vsctl --may-exist add-port br-int %[1]s -- set Interface %[1]s type=internal \
+  external-ids:iface-id="$PORT_ID" external-ids:attached-mac="$MAC" \
+  external-ids:iface-status=active external-ids:skip_cleanup=true
+ip link set dev %[1]s address "$MAC" mtu %[2]d
+ip -4 addr flush dev %[1]s
+ip addr add "$IP/%[3]d" dev %[1]s
+ip link set dev %[1]s up
+printf '[health_manager]\nbind_ip = %%s\n' "$IP" > %[4]s/bind.conf
+`, octaviaHealthManagerInterface, mtu, prefix, octaviaHealthManagerBindDir)
+
+	labels := labelsForOctavia(instance.Name, "health-manager")
+	privileged := true
+	rootUser := int64(0)
+	mounts := []corev1.VolumeMount{
+		octaviaConfigVolumeMount(),
+		{Name: "certs", MountPath: "/etc/octavia/certs", ReadOnly: true},
+		{Name: "bind", MountPath: octaviaHealthManagerBindDir},
+	}
+
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-health-manager", Namespace: instance.Namespace},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, ds, func() error {
+		ds.Labels = labels
+		if ds.CreationTimestamp.IsZero() {
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		ds.Spec.Template.Labels = labels
+		ds.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		ds.Spec.Template.Spec.Affinity = octaviaHealthManagerAffinity(instance)
+		ds.Spec.Template.Spec.HostNetwork = true
+		ds.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		ds.Spec.Template.Spec.Volumes = []corev1.Volume{
+			octaviaConfigVolume(instance),
+			octaviaCertsVolume(instance),
+			hostPathVolume("run-openvswitch", "/run/openvswitch"),
+			{Name: "bind", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		}
+		ds.Spec.Template.Spec.InitContainers = []corev1.Container{
+			{
+				Name:    "plug-port",
+				Image:   images.DefaultOpenvSwitchd,
+				Command: []string{"/bin/sh", "-c", script.String()},
+				Env: []corev1.EnvVar{
+					{Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}},
+				},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "run-openvswitch", MountPath: "/run/openvswitch"},
+					{Name: "bind", MountPath: octaviaHealthManagerBindDir},
+				},
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged, RunAsUser: &rootUser},
+			},
+		}
+		ds.Spec.Template.Spec.Containers = []corev1.Container{
+			{
+				Name:  "octavia-health-manager",
+				Image: images.ImageOrDefault(instance.Spec.Image, images.DefaultOctaviaHealthManager),
+				Command: []string{
+					"octavia-health-manager",
+					"--config-file", "/etc/octavia/octavia.conf",
+					"--config-file", octaviaHealthManagerBindDir + "/bind.conf",
+				},
+				Resources:    instance.Spec.Resources,
+				VolumeMounts: mounts,
+			},
+		}
+		return controllerutil.SetControllerReference(instance, ds, r.Scheme)
+	})
+	return err
+}
+
+// octaviaHealthManagerAffinity pins pods to the health manager nodes.
+func octaviaHealthManagerAffinity(instance *openstackv1alpha1.Octavia) *corev1.Affinity {
+	var nodes []string
+	if amphora := instance.Status.Amphora; amphora != nil {
+		for _, hm := range amphora.HealthManagers {
+			nodes = append(nodes, hm.Node)
+		}
+	}
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchFields: []corev1.NodeSelectorRequirement{
+						{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: nodes},
+					},
+				}},
+			},
+		},
+	}
+}
+
+// octaviaControllerIPPortList returns the heartbeat targets of the
+// amphorae, one per health manager.
+func octaviaControllerIPPortList(amphora *openstackv1alpha1.OctaviaAmphoraStatus) string {
+	targets := make([]string, 0, len(amphora.HealthManagers))
+	for _, hm := range amphora.HealthManagers {
+		targets = append(targets, net.JoinHostPort(hm.IPAddress, fmt.Sprint(octaviaHealthManagerPort)))
+	}
+	return strings.Join(targets, ", ")
+}
+
+// octaviaCertsVolume gathers the CA and client certificates under the file
+// names octavia.conf refers to.
+func octaviaCertsVolume(instance *openstackv1alpha1.Octavia) corev1.Volume {
+	source := func(secretName string, items ...corev1.KeyToPath) corev1.VolumeProjection {
+		return corev1.VolumeProjection{Secret: &corev1.SecretProjection{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+			Items:                items,
+		}}
+	}
+	return corev1.Volume{
+		Name: "certs",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+				source(octaviaServerCASecretName(instance),
+					corev1.KeyToPath{Key: "tls.crt", Path: "server_ca.cert.pem"},
+					corev1.KeyToPath{Key: "tls.key", Path: "server_ca.key.pem"},
+				),
+				source(octaviaClientCASecretName(instance),
+					corev1.KeyToPath{Key: "tls.crt", Path: "client_ca.cert.pem"},
+				),
+				source(octaviaClientCertSecretName(instance),
+					corev1.KeyToPath{Key: "client.cert-and-key.pem", Path: "client.cert-and-key.pem"},
+				),
+			}},
+		},
+	}
+}
@@ -0,0 +1,447 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// aggregatePendingInterval is how often hosts that have not registered with
+// Nova yet are retried.
+const aggregatePendingInterval = time.Minute
+
+// novaAggregate is a host aggregate as represented by the Nova API.
+type novaAggregate struct {
+	ID               int               `json:"id,omitempty"`
+	Name             string            `json:"name"`
+	AvailabilityZone *string           `json:"availability_zone"`
+	Hosts            []string          `json:"hosts,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+// OpenStackAggregateReconciler reconciles an OpenStackAggregate object.
+type OpenStackAggregateReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackaggregates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackaggregates/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackaggregates/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackdataplanes;novas,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+func (r *OpenStackAggregateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.OpenStackAggregate{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion: Nova only deletes empty aggregates, so remove the
+	// hosts first. If Nova is gone there is nothing left to clean up; if it
+	// is only unavailable, wait for it so that the aggregate is not leaked.
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			if instance.Status.AggregateID != 0 {
+				osc, err := novaAdminClient(ctx, r.Client, instance.Namespace, novaAdminMicroversion)
+				switch {
+				case errors.Is(err, errNovaGone):
+					logger.Info("Nova removed, not deleting aggregate", "aggregate", instance.Status.AggregateID)
+				case errors.Is(err, common.ErrDependencyNotReady):
+					return r.setReady(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), 10*time.Second)
+				case err != nil:
+					return ctrl.Result{}, err
+				default:
+					if err := deleteAggregate(ctx, osc, instance.Status.AggregateID); err != nil {
+						return ctrl.Result{}, err
+					}
+				}
+			}
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	osc, err := novaAdminClient(ctx, r.Client, instance.Namespace, novaAdminMicroversion)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), 10*time.Second)
+		}
+		return ctrl.Result{}, err
+	}
+
+	aggregate, err := ensureAggregate(ctx, osc, instance)
+	if err != nil {
+		var unmanaged *aggregateNotManagedError
+		if errors.As(err, &unmanaged) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "AggregateNotManaged", err.Error(), novaResyncInterval)
+		}
+		return ctrl.Result{}, err
+	}
+	instance.Status.AggregateID = aggregate.ID
+	instance.Status.AvailabilityZone = instance.Spec.AvailabilityZone
+
+	metadata := map[string]string{common.OwnerMarkKey: common.OwnerMark(instance)}
+	for k, v := range instance.Spec.Metadata {
+		if k != common.OwnerMarkKey {
+			metadata[k] = v
+		}
+	}
+	if err := syncAggregateMetadata(ctx, osc, aggregate, metadata); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	wanted, err := r.wantedHosts(ctx, instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	members, pending, err := syncAggregateHosts(ctx, osc, aggregate, wanted)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	instance.Status.Hosts = members
+	instance.Status.PendingHosts = pending
+
+	requeue := novaResyncInterval
+	if len(pending) > 0 {
+		requeue = aggregatePendingInterval
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionHostsAssigned), metav1.ConditionFalse,
+			"HostsPending", fmt.Sprintf("Hosts not yet in the aggregate: %s", strings.Join(pending, ", ")), instance.Generation,
+		)
+	} else {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionHostsAssigned), metav1.ConditionTrue,
+			"HostsAssigned", fmt.Sprintf("%d hosts in the aggregate", len(members)), instance.Generation,
+		)
+	}
+
+	return r.setReady(ctx, instance, metav1.ConditionTrue, "Synced", fmt.Sprintf("Aggregate %d is in sync", aggregate.ID), requeue)
+}
+
+// setReady records the Ready condition and requeues after the given interval.
+func (r *OpenStackAggregateReconciler) setReady(ctx context.Context, instance *openstackv1alpha1.OpenStackAggregate, status metav1.ConditionStatus, reason, message string, requeue time.Duration) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), status, reason, message, instance.Generation,
+	)
+	instance.Status.ObservedGeneration = instance.Generation
+	return ctrl.Result{RequeueAfter: requeue}, r.Status().Update(ctx, instance)
+}
+
+// wantedHosts gathers the hosts of the aggregate from the spec, data plane
+// nodes and in-cluster compute nodes of the namespace.
+func (r *OpenStackAggregateReconciler) wantedHosts(ctx context.Context, instance *openstackv1alpha1.OpenStackAggregate) (map[string]bool, error) {
+	az := instance.Spec.AvailabilityZone
+	wanted := map[string]bool{}
+	for _, host := range instance.Spec.Hosts {
+		wanted[host] = true
+	}
+
+	dataplanes := &openstackv1alpha1.OpenStackDataPlaneList{}
+	if err := r.List(ctx, dataplanes, client.InNamespace(instance.Namespace)); err != nil {
+		return nil, err
+	}
+	for _, dataplane := range dataplanes.Items {
+		for _, node := range dataplane.Spec.Nodes {
+			if node.Role != "compute" {
+				continue
+			}
+			if (az != "" && node.AvailabilityZone == az) || slices.Contains(node.Aggregates, instance.Name) {
+				wanted[node.Hostname] = true
+			}
+		}
+	}
+
+	novas := &openstackv1alpha1.NovaList{}
+	if err := r.List(ctx, novas, client.InNamespace(instance.Namespace)); err != nil {
+		return nil, err
+	}
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes, client.HasLabels{novaComputeNodeLabel}); err != nil {
+		return nil, err
+	}
+	for _, node := range nodes.Items {
+		if novaForComputeNode(novas.Items, &node) == nil {
+			continue
+		}
+		_, labelled := node.Labels[openstackv1alpha1.AggregateNodeLabelPrefix+instance.Name]
+		if (az != "" && node.Labels[openstackv1alpha1.AvailabilityZoneNodeLabel] == az) || labelled {
+			wanted[node.Name] = true
+		}
+	}
+	return wanted, nil
+}
+
+// aggregateNotManagedError reports an existing aggregate with the desired
+// name that does not carry this resource's owner mark.
+type aggregateNotManagedError struct {
+	id          int
+	name, owner string
+}
+
+func (e *aggregateNotManagedError) Error() string {
+	if e.owner == "" {
+		return fmt.Sprintf("aggregate %s (%d) already exists and is not managed by the operator", e.name, e.id)
+	}
+	return fmt.Sprintf("aggregate %s (%d) already exists and is managed by %s", e.name, e.id, e.owner)
+}
+
+// ensureAggregate finds the aggregate by ID or name, creating it if missing
+// and updating its name and availability zone in place. An aggregate found
+// by name is only adopted if it carries the owner mark of this resource,
+// i.e. it was created by it before its ID was recorded, since the
+// controller removes hosts it does not list and deletes the aggregate with
+// the resource.
+func ensureAggregate(ctx context.Context, osc *common.OpenStackClient, instance *openstackv1alpha1.OpenStackAggregate) (*novaAggregate, error) {
+	name := instance.Spec.Name
+	if name == "" {
+		name = instance.Name
+	}
+	var az *string
+	if instance.Spec.AvailabilityZone != "" {
+		az = &instance.Spec.AvailabilityZone
+	}
+
+	var resp struct {
+		Aggregates []novaAggregate `json:"aggregates"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "compute", "/os-aggregates", nil, &resp); err != nil {
+		return nil, err
+	}
+	var current *novaAggregate
+	for i := range resp.Aggregates {
+		agg := &resp.Aggregates[i]
+		if (instance.Status.AggregateID != 0 && agg.ID == instance.Status.AggregateID) || (current == nil && agg.Name == name) {
+			current = agg
+		}
+	}
+
+	if current != nil && current.ID != instance.Status.AggregateID {
+		if owner := current.Metadata[common.OwnerMarkKey]; owner != common.OwnerMark(instance) {
+			return nil, &aggregateNotManagedError{id: current.ID, name: current.Name, owner: owner}
+		}
+	}
+
+	body := map[string]any{"aggregate": novaAggregate{Name: name, AvailabilityZone: az}}
+	if current == nil {
+		var created struct {
+			Aggregate novaAggregate `json:"aggregate"`
+		}
+		if err := osc.Do(ctx, http.MethodPost, "compute", "/os-aggregates", body, &created); err != nil {
+			return nil, err
+		}
+		// Mark the aggregate right away: its ID is only recorded in the
+		// status at the end of the reconcile, and an unmarked aggregate is
+		// not adopted.
+		mark := map[string]any{"set_metadata": map[string]any{"metadata": map[string]string{common.OwnerMarkKey: common.OwnerMark(instance)}}}
+		if err := osc.Do(ctx, http.MethodPost, "compute", aggregatePath(created.Aggregate.ID)+"/action", mark, nil); err != nil {
+			if derr := osc.Do(ctx, http.MethodDelete, "compute", aggregatePath(created.Aggregate.ID), nil, nil); derr != nil && !common.IsOpenStackNotFound(derr) {
+				log.FromContext(ctx).Error(derr, "deleting unmarked aggregate", "aggregate", created.Aggregate.ID)
+			}
+			return nil, err
+		}
+		created.Aggregate.Metadata = map[string]string{common.OwnerMarkKey: common.OwnerMark(instance)}
+		return &created.Aggregate, nil
+	}
+	if current.Name != name || stringValue(current.AvailabilityZone) != stringValue(az) {
+		if err := osc.Do(ctx, http.MethodPut, "compute", aggregatePath(current.ID), body, nil); err != nil {
+			return nil, err
+		}
+		current.Name = name
+		current.AvailabilityZone = az
+	}
+	return current, nil
+}
+
+// syncAggregateMetadata makes the aggregate metadata exactly match desired.
+// The availability_zone key is managed through the aggregate itself.
+func syncAggregateMetadata(ctx context.Context, osc *common.OpenStackClient, aggregate *novaAggregate, desired map[string]string) error {
+	changed := map[string]any{}
+	for k, v := range desired {
+		if current, ok := aggregate.Metadata[k]; !ok || current != v {
+			changed[k] = v
+		}
+	}
+	for k := range aggregate.Metadata {
+		if _, ok := desired[k]; !ok && k != "availability_zone" {
+			changed[k] = nil
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	body := map[string]any{"set_metadata": map[string]any{"metadata": changed}}
+	return osc.Do(ctx, http.MethodPost, "compute", aggregatePath(aggregate.ID)+"/action", body, nil)
+}
+
+// syncAggregateHosts adds the wanted hosts whose nova-compute service has
+// registered and removes all others. It returns the resulting members and
+// the wanted hosts that could not be added.
+func syncAggregateHosts(ctx context.Context, osc *common.OpenStackClient, aggregate *novaAggregate, wanted map[string]bool) ([]string, []string, error) {
+	logger := log.FromContext(ctx)
+
+	var services struct {
+		Services []struct {
+			Host string `json:"host"`
+		} `json:"services"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "compute", "/os-services?binary=nova-compute", nil, &services); err != nil {
+		return nil, nil, err
+	}
+	registered := map[string]bool{}
+	for _, svc := range services.Services {
+		registered[svc.Host] = true
+	}
+
+	members := map[string]bool{}
+	for _, host := range aggregate.Hosts {
+		if wanted[host] {
+			members[host] = true
+			continue
+		}
+		body := map[string]any{"remove_host": map[string]string{"host": host}}
+		if err := osc.Do(ctx, http.MethodPost, "compute", aggregatePath(aggregate.ID)+"/action", body, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return nil, nil, err
+		}
+	}
+
+	var pending []string
+	for host := range wanted {
+		if members[host] {
+			continue
+		}
+		if !registered[host] {
+			pending = append(pending, host)
+			continue
+		}
+		body := map[string]any{"add_host": map[string]string{"host": host}}
+		err := osc.Do(ctx, http.MethodPost, "compute", aggregatePath(aggregate.ID)+"/action", body, nil)
+		switch {
+		case err == nil:
+			members[host] = true
+		case common.IsOpenStackConflict(err):
+			// Already a member of another availability zone
+			logger.Info("Nova refused aggregate host", "host", host, "error", err.Error())
+			pending = append(pending, host)
+		default:
+			return nil, nil, err
+		}
+	}
+
+	hosts := make([]string, 0, len(members))
+	for host := range members {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	sort.Strings(pending)
+	return hosts, pending, nil
+}
+
+// deleteAggregate removes all hosts from an aggregate and deletes it.
+func deleteAggregate(ctx context.Context, osc *common.OpenStackClient, id int) error {
+	var resp struct {
+		Aggregate novaAggregate `json:"aggregate"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "compute", aggregatePath(id), nil, &resp); err != nil {
+		if common.IsOpenStackNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, host := range resp.Aggregate.Hosts {
+		body := map[string]any{"remove_host": map[string]string{"host": host}}
+		if err := osc.Do(ctx, http.MethodPost, "compute", aggregatePath(id)+"/action", body, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+	}
+	if err := osc.Do(ctx, http.MethodDelete, "compute", aggregatePath(id), nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func aggregatePath(id int) string {
+	return "/os-aggregates/" + strconv.Itoa(id)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (r *OpenStackAggregateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.OpenStackAggregate{}).
+		Watches(&openstackv1alpha1.Nova{}, handler.EnqueueRequestsFromMapFunc(r.aggregatesForObject)).
+		Watches(&openstackv1alpha1.OpenStackDataPlane{}, handler.EnqueueRequestsFromMapFunc(r.aggregatesForObject)).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.aggregatesForNode),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Complete(r)
+}
+
+// aggregatesForObject maps an object to the aggregates in its namespace.
+func (r *OpenStackAggregateReconciler) aggregatesForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.aggregatesInNamespace(ctx, obj.GetNamespace())
+}
+
+// aggregatesForNode maps an in-cluster compute node to the aggregates in the
+// namespace of the Nova it is assigned to.
+func (r *OpenStackAggregateReconciler) aggregatesForNode(ctx context.Context, obj client.Object) []reconcile.Request {
+	novas := &openstackv1alpha1.NovaList{}
+	if err := r.List(ctx, novas); err != nil {
+		return nil
+	}
+	nova := novaForComputeNode(novas.Items, obj)
+	if nova == nil {
+		return nil
+	}
+	return r.aggregatesInNamespace(ctx, nova.Namespace)
+}
+
+func (r *OpenStackAggregateReconciler) aggregatesInNamespace(ctx context.Context, namespace string) []reconcile.Request {
+	aggregates := &openstackv1alpha1.OpenStackAggregateList{}
+	if err := r.List(ctx, aggregates, client.InNamespace(namespace)); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(aggregates.Items))
+	for _, aggregate := range aggregates.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&aggregate)})
+	}
+	return requests
+}
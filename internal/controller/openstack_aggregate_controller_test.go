@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func newAggregate(recordedID int) *openstackv1alpha1.OpenStackAggregate {
+	instance := &openstackv1alpha1.OpenStackAggregate{
+		ObjectMeta: metav1.ObjectMeta{Name: "fast", Namespace: "openstack"},
+		Spec:       openstackv1alpha1.OpenStackAggregateSpec{AvailabilityZone: "az1"},
+	}
+	instance.Status.AggregateID = recordedID
+	return instance
+}
+
+// aggregates is a Nova os-aggregates listing.
+func aggregates(aggs ...novaAggregate) fakeResponse {
+	return fakeResponse{body: map[string]any{"aggregates": aggs}}
+}
+
+func TestEnsureAggregate(t *testing.T) {
+	az1 := "az1"
+	ours := map[string]string{common.OwnerMarkKey: "openstack/fast"}
+	tests := []struct {
+		name       string
+		recordedID int
+		existing   []novaAggregate
+		// markStatus is the status the owner mark of a new aggregate is set with.
+		markStatus int
+		wantID     int
+		wantErr    string
+		wantCreate bool
+		wantRename bool
+		wantDelete bool
+	}{
+		{name: "created and marked", wantID: 7, wantCreate: true},
+		{name: "mark fails", markStatus: http.StatusInternalServerError, wantErr: "500", wantCreate: true, wantDelete: true},
+		{
+			name:     "adopted by owner mark",
+			existing: []novaAggregate{{ID: 3, Name: "fast", AvailabilityZone: &az1, Metadata: ours}},
+			wantID:   3,
+		},
+		{
+			name:     "unmarked aggregate of the same name",
+			existing: []novaAggregate{{ID: 3, Name: "fast", AvailabilityZone: &az1}},
+			wantErr:  "aggregate fast (3) already exists and is not managed by the operator",
+		},
+		{
+			name:     "aggregate of another resource",
+			existing: []novaAggregate{{ID: 3, Name: "fast", Metadata: map[string]string{common.OwnerMarkKey: "tenant-b/fast"}}},
+			wantErr:  "managed by tenant-b/fast",
+		},
+		{
+			name:       "recorded ID, renamed outside",
+			recordedID: 5,
+			existing:   []novaAggregate{{ID: 5, Name: "renamed", AvailabilityZone: &az1}},
+			wantID:     5,
+			wantRename: true,
+		},
+		{
+			name:       "recorded ID wins over a name match",
+			recordedID: 5,
+			existing: []novaAggregate{
+				{ID: 3, Name: "fast", AvailabilityZone: &az1},
+				{ID: 5, Name: "fast", AvailabilityZone: &az1, Metadata: ours},
+			},
+			wantID: 5,
+		},
+		{
+			name:       "availability zone changed",
+			recordedID: 5,
+			existing:   []novaAggregate{{ID: 5, Name: "fast", Metadata: ours}},
+			wantID:     5,
+			wantRename: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			markStatus := tt.markStatus
+			if markStatus == 0 {
+				markStatus = http.StatusOK
+			}
+			osc, fos := newFakeOpenStack(t, map[string]fakeResponse{
+				"GET /os-aggregates":           aggregates(tt.existing...),
+				"POST /os-aggregates":          {body: map[string]any{"aggregate": novaAggregate{ID: 7, Name: "fast", AvailabilityZone: &az1}}},
+				"POST /os-aggregates/7/action": {status: markStatus},
+				"PUT /os-aggregates/5":         {},
+				"DELETE /os-aggregates/7":      {},
+			})
+
+			agg, err := ensureAggregate(context.Background(), osc, newAggregate(tt.recordedID))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if agg.ID != tt.wantID {
+					t.Errorf("aggregate = %d, want %d", agg.ID, tt.wantID)
+				}
+			}
+			var unmanaged *aggregateNotManagedError
+			if errors.As(err, &unmanaged) && (fos.sent("POST /os-aggregates") || fos.sent("PUT /os-aggregates/3")) {
+				t.Error("an aggregate that is not ours was changed")
+			}
+			if got := fos.sent("POST /os-aggregates"); got != tt.wantCreate {
+				t.Errorf("created = %v, want %v", got, tt.wantCreate)
+			}
+			if tt.wantCreate {
+				mark := fos.body("POST /os-aggregates/7/action")["set_metadata"].(map[string]any)["metadata"].(map[string]any)
+				if mark[common.OwnerMarkKey] != "openstack/fast" {
+					t.Errorf("owner mark = %v", mark)
+				}
+			}
+			if got := fos.sent("PUT /os-aggregates/5"); got != tt.wantRename {
+				t.Errorf("updated = %v, want %v", got, tt.wantRename)
+			}
+			if tt.wantRename {
+				body := fos.body("PUT /os-aggregates/5")["aggregate"].(map[string]any)
+				if body["name"] != "fast" || body["availability_zone"] != "az1" {
+					t.Errorf("update = %v", body)
+				}
+			}
+			// An aggregate that cannot be marked would never be adopted.
+			if got := fos.sent("DELETE /os-aggregates/7"); got != tt.wantDelete {
+				t.Errorf("deleted = %v, want %v", got, tt.wantDelete)
+			}
+		})
+	}
+}
+
+func TestSyncAggregateMetadata(t *testing.T) {
+	desired := map[string]string{common.OwnerMarkKey: "openstack/fast", "ssd": "true"}
+	tests := []struct {
+		name    string
+		current map[string]string
+		want    map[string]any
+	}{
+		{name: "in sync", current: map[string]string{common.OwnerMarkKey: "openstack/fast", "ssd": "true", "availability_zone": "az1"}},
+		{
+			name:    "changed and removed keys",
+			current: map[string]string{common.OwnerMarkKey: "openstack/fast", "ssd": "false", "gpu": "true", "availability_zone": "az1"},
+			want:    map[string]any{"ssd": "true", "gpu": nil},
+		},
+		{
+			name:    "owner mark restored",
+			current: map[string]string{"ssd": "true"},
+			want:    map[string]any{common.OwnerMarkKey: "openstack/fast"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osc, fos := newFakeOpenStack(t, map[string]fakeResponse{"POST /os-aggregates/3/action": {}})
+
+			if err := syncAggregateMetadata(context.Background(), osc, &novaAggregate{ID: 3, Metadata: tt.current}, desired); err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if fos.sent("POST /os-aggregates/3/action") {
+					t.Error("metadata set although in sync")
+				}
+				return
+			}
+			got := fos.body("POST /os-aggregates/3/action")["set_metadata"].(map[string]any)["metadata"]
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("metadata = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncAggregateHosts(t *testing.T) {
+	tests := []struct {
+		name        string
+		members     []string
+		wanted      []string
+		actionCode  int
+		wantMembers []string
+		wantPending []string
+		wantAction  map[string]any
+	}{
+		{name: "in sync", members: []string{"compute-0"}, wanted: []string{"compute-0"}, wantMembers: []string{"compute-0"}},
+		{
+			name:        "registered host added",
+			wanted:      []string{"compute-0"},
+			wantMembers: []string{"compute-0"},
+			wantAction:  map[string]any{"add_host": map[string]any{"host": "compute-0"}},
+		},
+		{name: "host not registered yet", wanted: []string{"compute-9"}, wantMembers: []string{}, wantPending: []string{"compute-9"}},
+		{
+			name:        "host in another availability zone",
+			wanted:      []string{"compute-0"},
+			actionCode:  http.StatusConflict,
+			wantMembers: []string{},
+			wantPending: []string{"compute-0"},
+		},
+		{
+			name:        "host no longer wanted",
+			members:     []string{"compute-0"},
+			wantMembers: []string{},
+			wantAction:  map[string]any{"remove_host": map[string]any{"host": "compute-0"}},
+		},
+		{
+			name:        "host removed outside",
+			members:     []string{"compute-0"},
+			actionCode:  http.StatusNotFound,
+			wantMembers: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osc, fos := newFakeOpenStack(t, map[string]fakeResponse{
+				"GET /os-services?binary=nova-compute": {body: map[string]any{"services": []map[string]string{{"host": "compute-0"}}}},
+				"POST /os-aggregates/3/action":         {status: tt.actionCode},
+			})
+			wanted := map[string]bool{}
+			for _, host := range tt.wanted {
+				wanted[host] = true
+			}
+
+			members, pending, err := syncAggregateHosts(context.Background(), osc, &novaAggregate{ID: 3, Hosts: tt.members}, wanted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(members, tt.wantMembers) || !reflect.DeepEqual(pending, tt.wantPending) {
+				t.Errorf("members %v, pending %v, want %v, %v", members, pending, tt.wantMembers, tt.wantPending)
+			}
+			if tt.wantAction != nil && !reflect.DeepEqual(fos.body("POST /os-aggregates/3/action"), tt.wantAction) {
+				t.Errorf("action = %v, want %v", fos.body("POST /os-aggregates/3/action"), tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestDeleteAggregate(t *testing.T) {
+	t.Run("hosts removed first", func(t *testing.T) {
+		osc, fos := newFakeOpenStack(t, map[string]fakeResponse{
+			"GET /os-aggregates/3":         {body: map[string]any{"aggregate": novaAggregate{ID: 3, Name: "fast", Hosts: []string{"compute-0", "compute-1"}}}},
+			"POST /os-aggregates/3/action": {},
+			"DELETE /os-aggregates/3":      {},
+		})
+		if err := deleteAggregate(context.Background(), osc, 3); err != nil {
+			t.Fatal(err)
+		}
+		if n := fos.count("POST /os-aggregates/3/action"); n != 2 {
+			t.Errorf("%d hosts removed, want 2", n)
+		}
+		if last := fos.requests[len(fos.requests)-1]; last != "DELETE /os-aggregates/3" {
+			t.Errorf("last request = %s, want the aggregate deleted after its hosts", last)
+		}
+	})
+	t.Run("already gone", func(t *testing.T) {
+		osc, fos := newFakeOpenStack(t, map[string]fakeResponse{})
+		if err := deleteAggregate(context.Background(), osc, 3); err != nil {
+			t.Fatal(err)
+		}
+		if fos.sent("DELETE /os-aggregates/3") {
+			t.Error("deleted an aggregate that does not exist")
+		}
+	})
+}
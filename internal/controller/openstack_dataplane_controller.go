@@ -0,0 +1,328 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+const (
+	// dataPlaneDiscoveryInterval is how often registration is checked while
+	// nodes have not all registered.
+	dataPlaneDiscoveryInterval = 30 * time.Second
+
+	// dataPlaneResyncInterval is how often registration is rechecked once
+	// every node has registered.
+	dataPlaneResyncInterval = 5 * time.Minute
+)
+
+// OpenStackDataPlaneReconciler registers provisioned data-plane nodes with
+// the control plane. Provisioning itself reports progress through
+// Status.DeployedNodes; whenever that count rises, Nova host discovery runs
+// for the cells of the new compute hosts until every deployed node's
+// nova-compute service and OVN chassis have registered.
+type OpenStackDataPlaneReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Executor *common.PodExecutor
+}
+
+// dataPlaneRegistrations is what Nova and OVN report about registered hosts.
+type dataPlaneRegistrations struct {
+	// services maps nova-compute hosts to whether the service is up.
+	services map[string]bool
+	// mappings maps hosts to the cell they are mapped to.
+	mappings map[string]string
+	// chassis holds SB chassis by name and hostname; nil without OVN.
+	chassis map[string]sbChassisInfo
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackdataplanes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackdataplanes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=novas;ovnnetworks,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+func (r *OpenStackDataPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.OpenStackDataPlane{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !instance.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	instance.Status.TotalNodes = int32(len(instance.Spec.Nodes))
+
+	nova, err := r.nova(ctx, instance.Namespace)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if nova == nil {
+		return r.setRegistered(ctx, instance, metav1.ConditionFalse, "WaitingForNova", "No Nova in the namespace", dataPlaneDiscoveryInterval)
+	}
+
+	regs, err := r.registrations(ctx, instance.Namespace, nova)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.setRegistered(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), dataPlaneDiscoveryInterval)
+		}
+		return ctrl.Result{}, err
+	}
+
+	if instance.Status.DiscoveredNodes > instance.Status.DeployedNodes {
+		instance.Status.DiscoveredNodes = instance.Status.DeployedNodes
+	}
+
+	// Map compute hosts whose service is up but which discovery has not
+	// picked up yet, one discover_hosts run per cell.
+	if instance.Status.DeployedNodes > instance.Status.DiscoveredNodes {
+		cells := map[string]bool{}
+		for _, node := range instance.Spec.Nodes {
+			if node.Role == "compute" && regs.services[node.Hostname] && regs.mappings[node.Hostname] == "" {
+				cells[dataPlaneNodeCell(node)] = true
+			}
+		}
+		for cell := range cells {
+			discovered, err := r.discoverHosts(ctx, nova, cell)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if discovered {
+				logger.Info("ran host discovery", "cell", cell)
+			}
+		}
+		if len(cells) > 0 {
+			if regs.mappings, err = r.hostMappings(ctx, nova); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	instance.Status.Nodes = make([]openstackv1alpha1.DataPlaneNodeStatus, 0, len(instance.Spec.Nodes))
+	for _, node := range instance.Spec.Nodes {
+		instance.Status.Nodes = append(instance.Status.Nodes, dataPlaneNodeStatus(node, regs))
+	}
+	status, reason, message := dataPlaneRegistered(&instance.Status)
+	requeue := dataPlaneDiscoveryInterval
+	if status == metav1.ConditionTrue {
+		requeue = dataPlaneResyncInterval
+	}
+	return r.setRegistered(ctx, instance, status, reason, message, requeue)
+}
+
+// setRegistered records the NodesRegistered condition and requeues after the
+// given interval. The Ready condition belongs to provisioning.
+func (r *OpenStackDataPlaneReconciler) setRegistered(ctx context.Context, instance *openstackv1alpha1.OpenStackDataPlane, status metav1.ConditionStatus, reason, message string, requeue time.Duration) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionNodesRegistered), status, reason, message, instance.Generation,
+	)
+	return ctrl.Result{RequeueAfter: requeue}, r.Status().Update(ctx, instance)
+}
+
+// nova returns the Nova of the namespace, or nil if there is none.
+func (r *OpenStackDataPlaneReconciler) nova(ctx context.Context, namespace string) (*openstackv1alpha1.Nova, error) {
+	novas := &openstackv1alpha1.NovaList{}
+	if err := r.List(ctx, novas, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(novas.Items) == 0 {
+		return nil, nil
+	}
+	return &novas.Items[0], nil
+}
+
+// registrations collects nova-compute services, cell host mappings and, if
+// the namespace has an OVNNetwork, SB chassis.
+func (r *OpenStackDataPlaneReconciler) registrations(ctx context.Context, namespace string, nova *openstackv1alpha1.Nova) (*dataPlaneRegistrations, error) {
+	osc, err := novaAdminClient(ctx, r.Client, namespace, novaAdminMicroversion)
+	if err != nil {
+		return nil, err
+	}
+	var services struct {
+		Services []struct {
+			Host  string `json:"host"`
+			State string `json:"state"`
+		} `json:"services"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "compute", "/os-services?binary=nova-compute", nil, &services); err != nil {
+		return nil, err
+	}
+	regs := &dataPlaneRegistrations{services: map[string]bool{}}
+	for _, svc := range services.Services {
+		regs.services[svc.Host] = svc.State == "up"
+	}
+
+	if regs.mappings, err = r.hostMappings(ctx, nova); err != nil {
+		return nil, err
+	}
+
+	ovns := &openstackv1alpha1.OVNNetworkList{}
+	if err := r.List(ctx, ovns, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(ovns.Items) > 0 {
+		ovn := &ovns.Items[0]
+		if ovn.Status.SouthboundDBEndpoint == "" {
+			return nil, fmt.Errorf("OVN southbound database: %w", common.ErrDependencyNotReady)
+		}
+		var tlsConfig *tls.Config
+		if ovn.Status.TLSSecretName != "" {
+			if tlsConfig, err = common.OVSDBTLSConfig(ctx, r.Client, ovn.Status.TLSSecretName, namespace); err != nil {
+				return nil, err
+			}
+		}
+		if regs.chassis, err = sbChassis(ctx, ovn.Status.SouthboundDBEndpoint, tlsConfig); err != nil {
+			return nil, fmt.Errorf("reading OVN chassis: %w", err)
+		}
+	}
+	return regs, nil
+}
+
+// hostMappings lists the compute hosts mapped to cells, keyed by host name.
+func (r *OpenStackDataPlaneReconciler) hostMappings(ctx context.Context, nova *openstackv1alpha1.Nova) (map[string]string, error) {
+	pod, err := novaAPIPod(ctx, r.Client, nova)
+	if err != nil {
+		return nil, err
+	}
+	if pod == "" {
+		return nil, fmt.Errorf("nova-api: %w", common.ErrDependencyNotReady)
+	}
+	out, err := r.Executor.Exec(ctx, nova.Namespace, pod, "nova-api", []string{"nova-manage", "cell_v2", "list_hosts"})
+	if err != nil {
+		return nil, err
+	}
+	mappings := map[string]string{}
+	for _, row := range parseNovaManageTable(out) {
+		if len(row) >= 3 {
+			mappings[row[2]] = row[0]
+		}
+	}
+	return mappings, nil
+}
+
+// discoverHosts maps the unmapped compute hosts of one cell. It returns
+// false if the cell has no mapping yet.
+func (r *OpenStackDataPlaneReconciler) discoverHosts(ctx context.Context, nova *openstackv1alpha1.Nova, cell string) (bool, error) {
+	var uuid string
+	for _, status := range nova.Status.Cells {
+		if status.Name == cell {
+			uuid = status.UUID
+		}
+	}
+	if uuid == "" {
+		log.FromContext(ctx).Info("cell has no mapping, skipping host discovery", "cell", cell)
+		return false, nil
+	}
+	pod, err := novaAPIPod(ctx, r.Client, nova)
+	if err != nil || pod == "" {
+		return false, err
+	}
+	_, err = r.Executor.Exec(ctx, nova.Namespace, pod, "nova-api", []string{"nova-manage", "cell_v2", "discover_hosts", "--cell_uuid", uuid})
+	return err == nil, err
+}
+
+// dataPlaneRegistered counts the registered nodes of the status and, once
+// every deployed node has registered, records DeployedNodes as discovered.
+// It returns the NodesRegistered condition.
+func dataPlaneRegistered(status *openstackv1alpha1.OpenStackDataPlaneStatus) (metav1.ConditionStatus, string, string) {
+	var waiting []string
+	status.RegisteredNodes = 0
+	for _, node := range status.Nodes {
+		if node.Registered {
+			status.RegisteredNodes++
+		} else {
+			waiting = append(waiting, node.Hostname)
+		}
+	}
+	sort.Strings(waiting)
+
+	switch {
+	case status.RegisteredNodes < status.DeployedNodes:
+		return metav1.ConditionFalse, "WaitingForRegistration",
+			fmt.Sprintf("%d of %d deployed nodes registered; not registered: %s", status.RegisteredNodes, status.DeployedNodes, strings.Join(waiting, ", "))
+	case status.RegisteredNodes < status.TotalNodes:
+		status.DiscoveredNodes = status.DeployedNodes
+		return metav1.ConditionFalse, "WaitingForDeployment",
+			fmt.Sprintf("%d of %d nodes deployed; not registered: %s", status.DeployedNodes, status.TotalNodes, strings.Join(waiting, ", "))
+	default:
+		status.DiscoveredNodes = status.DeployedNodes
+		return metav1.ConditionTrue, "NodesRegistered", fmt.Sprintf("%d nodes registered", status.RegisteredNodes)
+	}
+}
+
+// dataPlaneNodeStatus evaluates the registrations expected for a node's role.
+func dataPlaneNodeStatus(node openstackv1alpha1.DataPlaneNodeSpec, regs *dataPlaneRegistrations) openstackv1alpha1.DataPlaneNodeStatus {
+	status := openstackv1alpha1.DataPlaneNodeStatus{Hostname: node.Hostname}
+	if node.Role == "storage" {
+		status.Registered = true
+		return status
+	}
+
+	chassisOK := true
+	if regs.chassis != nil {
+		short, _, _ := strings.Cut(node.Hostname, ".")
+		_, byName := regs.chassis[node.Hostname]
+		_, byShortName := regs.chassis[short]
+		status.ChassisRegistered = byName || byShortName
+		chassisOK = status.ChassisRegistered
+	}
+	if node.Role == "networker" {
+		status.Registered = chassisOK
+		return status
+	}
+
+	status.ComputeRegistered = regs.services[node.Hostname]
+	status.HostMapped = regs.mappings[node.Hostname] == dataPlaneNodeCell(node)
+	status.Registered = status.ComputeRegistered && status.HostMapped && chassisOK
+	return status
+}
+
+// dataPlaneNodeCell returns the cell of a compute node, defaulting to cell1
+// for nodes created before the field existed.
+func dataPlaneNodeCell(node openstackv1alpha1.DataPlaneNodeSpec) string {
+	if node.Cell == "" {
+		return "cell1"
+	}
+	return node.Cell
+}
+
+func (r *OpenStackDataPlaneReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.OpenStackDataPlane{}).
+		Watches(&openstackv1alpha1.Nova{}, handler.EnqueueRequestsFromMapFunc(r.dataPlanesForObject)).
+		Complete(r)
+}
+
+// dataPlanesForObject maps a Nova to the data planes in its namespace, as
+// host discovery needs its cell mappings.
+func (r *OpenStackDataPlaneReconciler) dataPlanesForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	dataplanes := &openstackv1alpha1.OpenStackDataPlaneList{}
+	if err := r.List(ctx, dataplanes, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(dataplanes.Items))
+	for _, dataplane := range dataplanes.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&dataplane)})
+	}
+	return requests
+}
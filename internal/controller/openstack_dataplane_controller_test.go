@@ -0,0 +1,215 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestDataPlaneNodeStatus(t *testing.T) {
+	regs := &dataPlaneRegistrations{
+		services: map[string]bool{"compute-0.example.com": true, "compute-1.example.com": false, "compute-2.example.com": true},
+		mappings: map[string]string{"compute-0.example.com": "cell1", "compute-2.example.com": "cell1"},
+		chassis:  map[string]sbChassisInfo{"compute-0": {}, "net-0.example.com": {}},
+	}
+	tests := []struct {
+		name string
+		node openstackv1alpha1.DataPlaneNodeSpec
+		want openstackv1alpha1.DataPlaneNodeStatus
+	}{
+		{
+			name: "registered compute by short chassis name",
+			node: openstackv1alpha1.DataPlaneNodeSpec{Hostname: "compute-0.example.com", Role: "compute"},
+			want: openstackv1alpha1.DataPlaneNodeStatus{Hostname: "compute-0.example.com", ComputeRegistered: true, HostMapped: true, ChassisRegistered: true, Registered: true},
+		},
+		{
+			name: "compute with service down",
+			node: openstackv1alpha1.DataPlaneNodeSpec{Hostname: "compute-1.example.com", Role: "compute"},
+			want: openstackv1alpha1.DataPlaneNodeStatus{Hostname: "compute-1.example.com"},
+		},
+		{
+			name: "compute mapped to another cell without chassis",
+			node: openstackv1alpha1.DataPlaneNodeSpec{Hostname: "compute-2.example.com", Role: "compute", Cell: "cell2"},
+			want: openstackv1alpha1.DataPlaneNodeStatus{Hostname: "compute-2.example.com", ComputeRegistered: true},
+		},
+		{
+			name: "networker",
+			node: openstackv1alpha1.DataPlaneNodeSpec{Hostname: "net-0.example.com", Role: "networker"},
+			want: openstackv1alpha1.DataPlaneNodeStatus{Hostname: "net-0.example.com", ChassisRegistered: true, Registered: true},
+		},
+		{
+			name: "storage",
+			node: openstackv1alpha1.DataPlaneNodeSpec{Hostname: "ceph-0.example.com", Role: "storage"},
+			want: openstackv1alpha1.DataPlaneNodeStatus{Hostname: "ceph-0.example.com", Registered: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dataPlaneNodeStatus(tt.node, regs); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDataPlaneRegistered(t *testing.T) {
+	nodes := []openstackv1alpha1.DataPlaneNodeStatus{
+		{Hostname: "compute-1", Registered: false},
+		{Hostname: "compute-0", Registered: true},
+		{Hostname: "ceph-0", Registered: true},
+		{Hostname: "net-0", Registered: false},
+	}
+	tests := []struct {
+		name           string
+		deployed       int32
+		discovered     int32
+		nodes          []openstackv1alpha1.DataPlaneNodeStatus
+		wantStatus     metav1.ConditionStatus
+		wantReason     string
+		wantMessage    string
+		wantDiscovered int32
+	}{
+		{
+			name:        "deployed nodes not registered yet",
+			deployed:    4,
+			discovered:  2,
+			nodes:       nodes,
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  "WaitingForRegistration",
+			wantMessage: "2 of 4 deployed nodes registered; not registered: compute-1, net-0",
+			// Discovery keeps running until the deployed nodes register.
+			wantDiscovered: 2,
+		},
+		{
+			name:           "deployed nodes registered, others not deployed",
+			deployed:       2,
+			nodes:          nodes,
+			wantStatus:     metav1.ConditionFalse,
+			wantReason:     "WaitingForDeployment",
+			wantMessage:    "2 of 4 nodes deployed; not registered: compute-1, net-0",
+			wantDiscovered: 2,
+		},
+		{
+			name:     "all registered",
+			deployed: 2,
+			nodes: []openstackv1alpha1.DataPlaneNodeStatus{
+				{Hostname: "compute-0", Registered: true},
+				{Hostname: "ceph-0", Registered: true},
+			},
+			wantStatus:     metav1.ConditionTrue,
+			wantReason:     "NodesRegistered",
+			wantMessage:    "2 nodes registered",
+			wantDiscovered: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &openstackv1alpha1.OpenStackDataPlaneStatus{
+				DeployedNodes:   tt.deployed,
+				DiscoveredNodes: tt.discovered,
+				TotalNodes:      int32(len(tt.nodes)),
+				Nodes:           tt.nodes,
+			}
+			got, reason, message := dataPlaneRegistered(status)
+			if got != tt.wantStatus || reason != tt.wantReason || message != tt.wantMessage {
+				t.Errorf("got %s %s %q, want %s %s %q", got, reason, message, tt.wantStatus, tt.wantReason, tt.wantMessage)
+			}
+			if status.DeployedNodes != tt.deployed {
+				t.Errorf("DeployedNodes changed to %d", status.DeployedNodes)
+			}
+			if status.DiscoveredNodes != tt.wantDiscovered {
+				t.Errorf("DiscoveredNodes = %d, want %d", status.DiscoveredNodes, tt.wantDiscovered)
+			}
+		})
+	}
+}
+
+// provision plays the part of provisioning: it reports deployed nodes, the
+// Nova compute configuration they installed and readiness through the
+// status subresource, as documented on OpenStackDataPlaneStatus.
+func provision(ctx context.Context, c client.Client, name string, deployed int32, hash string) error {
+	dataplane := &openstackv1alpha1.OpenStackDataPlane{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: "openstack"}, dataplane); err != nil {
+		return err
+	}
+	dataplane.Status.DeployedNodes = deployed
+	dataplane.Status.NovaComputeConfigHash = hash
+	dataplane.Status.Conditions = common.SetCondition(dataplane.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Deployed", "", dataplane.Generation)
+	return c.Status().Update(ctx, dataplane)
+}
+
+func TestDataPlaneProvisioningContract(t *testing.T) {
+	notReady := &openstackv1alpha1.Nova{ObjectMeta: metav1.ObjectMeta{Name: "nova", Namespace: "openstack"}}
+	tests := []struct {
+		name       string
+		nova       *openstackv1alpha1.Nova
+		wantReason string
+	}{
+		{name: "no Nova", wantReason: "WaitingForNova"},
+		{name: "Nova not ready", nova: notReady, wantReason: "WaitingForDependencies"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dataplane := &openstackv1alpha1.OpenStackDataPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "openstack"},
+				Spec: openstackv1alpha1.OpenStackDataPlaneSpec{Nodes: []openstackv1alpha1.DataPlaneNodeSpec{
+					{Hostname: "compute-0", Role: "compute"},
+					{Hostname: "compute-1", Role: "compute"},
+				}},
+			}
+			objs := []client.Object{dataplane}
+			if tt.nova != nil {
+				objs = append(objs, tt.nova.DeepCopy())
+			}
+			c := newFakeClient(t, objs...)
+			r := &OpenStackDataPlaneReconciler{Client: c, Scheme: testScheme}
+			if err := provision(ctx, c, "edge", 1, "hash-1"); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "edge", Namespace: "openstack"}}); err != nil {
+				t.Fatal(err)
+			}
+			got := &openstackv1alpha1.OpenStackDataPlane{}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(dataplane), got); err != nil {
+				t.Fatal(err)
+			}
+			// The operator records its own fields and keeps those of
+			// provisioning.
+			if got.Status.TotalNodes != 2 {
+				t.Errorf("TotalNodes = %d", got.Status.TotalNodes)
+			}
+			if cond := meta.FindStatusCondition(got.Status.Conditions, string(openstackv1alpha1.ConditionNodesRegistered)); cond == nil || cond.Reason != tt.wantReason {
+				t.Errorf("NodesRegistered = %+v, want reason %s", cond, tt.wantReason)
+			}
+			if got.Status.DeployedNodes != 1 || got.Status.NovaComputeConfigHash != "hash-1" || !common.IsReady(got.Status.Conditions) {
+				t.Errorf("provisioning status not kept: %+v", got.Status)
+			}
+
+			// A status computed before provisioning reported more nodes
+			// does not overwrite them.
+			stale := got.DeepCopy()
+			if err := provision(ctx, c, "edge", 2, "hash-2"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.setRegistered(ctx, stale, metav1.ConditionFalse, tt.wantReason, "", dataPlaneDiscoveryInterval); !apierrors.IsConflict(err) {
+				t.Fatalf("stale status update error = %v, want a conflict", err)
+			}
+			if err := c.Get(ctx, client.ObjectKeyFromObject(dataplane), got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.DeployedNodes != 2 || got.Status.NovaComputeConfigHash != "hash-2" {
+				t.Errorf("provisioning status overwritten: %+v", got.Status)
+			}
+		})
+	}
+}
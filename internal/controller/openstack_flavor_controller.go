@@ -0,0 +1,481 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// novaFlavor is a flavor as represented by the Nova API.
+type novaFlavor struct {
+	ID          string  `json:"id,omitempty"`
+	Name        string  `json:"name"`
+	VCPUs       int32   `json:"vcpus"`
+	RAM         int32   `json:"ram"`
+	Disk        int32   `json:"disk"`
+	Ephemeral   int32   `json:"OS-FLV-EXT-DATA:ephemeral"`
+	Swap        int32   `json:"swap"`
+	Public      bool    `json:"os-flavor-access:is_public"`
+	Description *string `json:"description"`
+}
+
+// OpenStackFlavorReconciler reconciles an OpenStackFlavor object.
+type OpenStackFlavorReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackflavors,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackflavors/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackflavors/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=novas,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+func (r *OpenStackFlavorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.OpenStackFlavor{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion: remove the flavor from Nova. If the cloud itself is
+	// gone there is nothing left to clean up; if it is only unavailable, wait
+	// for it so that the flavor is not leaked.
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			if instance.Status.FlavorID != "" {
+				osc, err := novaAdminClient(ctx, r.Client, instance.Namespace, novaAdminMicroversion)
+				switch {
+				case errors.Is(err, errNovaGone):
+					logger.Info("Nova removed, not deleting flavor", "flavor", instance.Status.FlavorID)
+				case errors.Is(err, common.ErrDependencyNotReady):
+					return r.setReady(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), 10*time.Second)
+				case err != nil:
+					return ctrl.Result{}, err
+				default:
+					if err := osc.Do(ctx, http.MethodDelete, "compute", "/flavors/"+instance.Status.FlavorID, nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+						return ctrl.Result{}, err
+					}
+				}
+			}
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	osc, err := novaAdminClient(ctx, r.Client, instance.Namespace, novaAdminMicroversion)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), 10*time.Second)
+		}
+		return ctrl.Result{}, err
+	}
+
+	projects, err := resolveProjects(ctx, osc, instance.Spec.ProjectAccess)
+	if err != nil {
+		var missing *missingProjectError
+		if errors.As(err, &missing) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "ProjectNotFound", err.Error(), novaResyncInterval)
+		}
+		return ctrl.Result{}, err
+	}
+
+	flavor, err := r.ensureFlavor(ctx, osc, instance)
+	if err != nil {
+		var drift *flavorDriftError
+		if errors.As(err, &drift) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "ReplacementRejected", err.Error(), novaResyncInterval)
+		}
+		var unmanaged *flavorNotManagedError
+		if errors.As(err, &unmanaged) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "FlavorNotManaged", err.Error(), novaResyncInterval)
+		}
+		return ctrl.Result{}, err
+	}
+	instance.Status.FlavorID = flavor.ID
+
+	extraSpecs := map[string]string{common.OwnerMarkKey: common.OwnerMark(instance)}
+	for k, v := range instance.Spec.ExtraSpecs {
+		if k != common.OwnerMarkKey {
+			extraSpecs[k] = v
+		}
+	}
+	if err := syncFlavorExtraSpecs(ctx, osc, flavor.ID, extraSpecs); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !flavor.Public {
+		if err := syncFlavorAccess(ctx, osc, flavor.ID, projects); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return r.setReady(ctx, instance, metav1.ConditionTrue, "Synced", fmt.Sprintf("Flavor %s is in sync", flavor.ID), novaResyncInterval)
+}
+
+// setReady records the Ready condition and requeues after the given interval.
+func (r *OpenStackFlavorReconciler) setReady(ctx context.Context, instance *openstackv1alpha1.OpenStackFlavor, status metav1.ConditionStatus, reason, message string, requeue time.Duration) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), status, reason, message, instance.Generation,
+	)
+	instance.Status.ObservedGeneration = instance.Generation
+	return ctrl.Result{RequeueAfter: requeue}, r.Status().Update(ctx, instance)
+}
+
+// novaResyncInterval is how often the flavors and aggregates managed through
+// the Nova API are compared with Nova to correct drift.
+const novaResyncInterval = 5 * time.Minute
+
+// novaAdminMicroversion is the compute microversion of the admin client. It
+// returns flavor swap as an integer and supports flavor descriptions.
+const novaAdminMicroversion = "2.75"
+
+// errNovaGone reports that the namespace has no Nova at all, as opposed to
+// one that is not Ready yet. It wraps ErrDependencyNotReady.
+var errNovaGone = fmt.Errorf("Nova not found: %w", common.ErrDependencyNotReady)
+
+// novaAdminClient returns an admin client requesting the given compute
+// microversion once a Nova in the namespace is Ready, or ErrDependencyNotReady.
+// errNovaGone is returned when the namespace has no Nova.
+func novaAdminClient(ctx context.Context, c client.Client, namespace, microversion string) (*common.OpenStackClient, error) {
+	novas := &openstackv1alpha1.NovaList{}
+	if err := c.List(ctx, novas, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	if len(novas.Items) == 0 {
+		return nil, errNovaGone
+	}
+	if !common.IsReady(novas.Items[0].Status.Conditions) {
+		return nil, fmt.Errorf("Nova: %w", common.ErrDependencyNotReady)
+	}
+	conns, err := common.ResolveConnections(ctx, c, namespace)
+	if err != nil {
+		return nil, err
+	}
+	osc, err := common.NewOpenStackClient(ctx, c, namespace, conns)
+	if err != nil {
+		return nil, err
+	}
+	return osc.WithMicroversion("compute", microversion), nil
+}
+
+// flavorDriftError reports an immutable difference that the update policy
+// does not allow to be replaced.
+type flavorDriftError struct {
+	diff []string
+}
+
+func (e *flavorDriftError) Error() string {
+	return fmt.Sprintf("flavor differs in immutable fields (%s) and updatePolicy is Reject", strings.Join(e.diff, ", "))
+}
+
+// flavorNotManagedError reports an existing flavor with the desired name that
+// does not carry this resource's owner mark.
+type flavorNotManagedError struct {
+	id, name, owner string
+}
+
+func (e *flavorNotManagedError) Error() string {
+	if e.owner == "" {
+		return fmt.Sprintf("flavor %s (%s) already exists and is not managed by the operator", e.name, e.id)
+	}
+	return fmt.Sprintf("flavor %s (%s) already exists and is managed by %s", e.name, e.id, e.owner)
+}
+
+// ensureFlavor finds the flavor by ID or name, creating it if missing and
+// replacing it if an immutable field differs. The description is updated in
+// place. A flavor found by name is only adopted if it carries the owner mark
+// of this resource, i.e. it was created by it before its ID was recorded.
+func (r *OpenStackFlavorReconciler) ensureFlavor(ctx context.Context, osc *common.OpenStackClient, instance *openstackv1alpha1.OpenStackFlavor) (*novaFlavor, error) {
+	logger := log.FromContext(ctx)
+	desired := desiredFlavor(instance)
+
+	current, err := findFlavor(ctx, osc, instance.Status.FlavorID, desired.Name)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ID != instance.Status.FlavorID {
+		var resp struct {
+			ExtraSpecs map[string]string `json:"extra_specs"`
+		}
+		if err := osc.Do(ctx, http.MethodGet, "compute", "/flavors/"+url.PathEscape(current.ID)+"/os-extra_specs", nil, &resp); err != nil {
+			return nil, err
+		}
+		if owner := resp.ExtraSpecs[common.OwnerMarkKey]; owner != common.OwnerMark(instance) {
+			return nil, &flavorNotManagedError{id: current.ID, name: current.Name, owner: owner}
+		}
+	}
+	if current != nil {
+		if diff := flavorDiff(current, desired); len(diff) > 0 {
+			if instance.Spec.UpdatePolicy == "Reject" {
+				return nil, &flavorDriftError{diff: diff}
+			}
+			logger.Info("replacing flavor", "flavor", current.ID, "changed", diff)
+			if err := osc.Do(ctx, http.MethodDelete, "compute", "/flavors/"+current.ID, nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+				return nil, err
+			}
+			now := metav1.Now()
+			instance.Status.LastReplacementTime = &now
+			current = nil
+		}
+	}
+
+	if current == nil {
+		var resp struct {
+			Flavor novaFlavor `json:"flavor"`
+		}
+		if err := osc.Do(ctx, http.MethodPost, "compute", "/flavors", map[string]any{"flavor": desired}, &resp); err != nil {
+			return nil, err
+		}
+		// Mark the flavor right away: its ID is only recorded in the status
+		// at the end of the reconcile, and an unmarked flavor is not adopted.
+		body := map[string]any{"extra_specs": map[string]string{common.OwnerMarkKey: common.OwnerMark(instance)}}
+		if err := osc.Do(ctx, http.MethodPost, "compute", "/flavors/"+resp.Flavor.ID+"/os-extra_specs", body, nil); err != nil {
+			if derr := osc.Do(ctx, http.MethodDelete, "compute", "/flavors/"+resp.Flavor.ID, nil, nil); derr != nil && !common.IsOpenStackNotFound(derr) {
+				logger.Error(derr, "deleting unmarked flavor", "flavor", resp.Flavor.ID)
+			}
+			return nil, err
+		}
+		return &resp.Flavor, nil
+	}
+
+	if current.Description == nil || *current.Description != *desired.Description {
+		body := map[string]any{"flavor": map[string]any{"description": desired.Description}}
+		if err := osc.Do(ctx, http.MethodPut, "compute", "/flavors/"+current.ID, body, nil); err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+// desiredFlavor converts the spec to its Nova representation.
+func desiredFlavor(instance *openstackv1alpha1.OpenStackFlavor) *novaFlavor {
+	name := instance.Spec.Name
+	if name == "" {
+		name = instance.Name
+	}
+	description := instance.Spec.Description
+	return &novaFlavor{
+		Name:        name,
+		VCPUs:       instance.Spec.VCPUs,
+		RAM:         instance.Spec.RAM,
+		Disk:        instance.Spec.Disk,
+		Ephemeral:   instance.Spec.Ephemeral,
+		Swap:        instance.Spec.Swap,
+		Public:      instance.Spec.Public,
+		Description: &description,
+	}
+}
+
+// findFlavor returns the flavor with the given ID, falling back to a lookup
+// by name; nil if neither exists.
+func findFlavor(ctx context.Context, osc *common.OpenStackClient, id, name string) (*novaFlavor, error) {
+	if id != "" {
+		var resp struct {
+			Flavor novaFlavor `json:"flavor"`
+		}
+		err := osc.Do(ctx, http.MethodGet, "compute", "/flavors/"+url.PathEscape(id), nil, &resp)
+		if err == nil {
+			return &resp.Flavor, nil
+		}
+		if !common.IsOpenStackNotFound(err) {
+			return nil, err
+		}
+	}
+	var resp struct {
+		Flavors []novaFlavor `json:"flavors"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "compute", "/flavors/detail?is_public=None", nil, &resp); err != nil {
+		return nil, err
+	}
+	for i := range resp.Flavors {
+		if resp.Flavors[i].Name == name {
+			return &resp.Flavors[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// flavorDiff lists the immutable fields in which current differs from desired.
+func flavorDiff(current, desired *novaFlavor) []string {
+	var diff []string
+	if current.Name != desired.Name {
+		diff = append(diff, "name")
+	}
+	if current.VCPUs != desired.VCPUs {
+		diff = append(diff, "vcpus")
+	}
+	if current.RAM != desired.RAM {
+		diff = append(diff, "ram")
+	}
+	if current.Disk != desired.Disk {
+		diff = append(diff, "disk")
+	}
+	if current.Ephemeral != desired.Ephemeral {
+		diff = append(diff, "ephemeral")
+	}
+	if current.Swap != desired.Swap {
+		diff = append(diff, "swap")
+	}
+	if current.Public != desired.Public {
+		diff = append(diff, "public")
+	}
+	return diff
+}
+
+// syncFlavorExtraSpecs makes the flavor's extra specs exactly match desired.
+func syncFlavorExtraSpecs(ctx context.Context, osc *common.OpenStackClient, id string, desired map[string]string) error {
+	path := "/flavors/" + id + "/os-extra_specs"
+	var resp struct {
+		ExtraSpecs map[string]string `json:"extra_specs"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "compute", path, nil, &resp); err != nil {
+		return err
+	}
+	changed := map[string]string{}
+	for k, v := range desired {
+		if current, ok := resp.ExtraSpecs[k]; !ok || current != v {
+			changed[k] = v
+		}
+	}
+	if len(changed) > 0 {
+		if err := osc.Do(ctx, http.MethodPost, "compute", path, map[string]any{"extra_specs": changed}, nil); err != nil {
+			return err
+		}
+	}
+	for k := range resp.ExtraSpecs {
+		if _, ok := desired[k]; ok {
+			continue
+		}
+		if err := osc.Do(ctx, http.MethodDelete, "compute", path+"/"+url.PathEscape(k), nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncFlavorAccess makes the projects with access to a private flavor exactly match desired.
+func syncFlavorAccess(ctx context.Context, osc *common.OpenStackClient, id string, desired []string) error {
+	var resp struct {
+		FlavorAccess []struct {
+			TenantID string `json:"tenant_id"`
+		} `json:"flavor_access"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "compute", "/flavors/"+id+"/os-flavor-access", nil, &resp); err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	for _, access := range resp.FlavorAccess {
+		current[access.TenantID] = true
+	}
+	want := map[string]bool{}
+	for _, project := range desired {
+		want[project] = true
+		if current[project] {
+			continue
+		}
+		body := map[string]any{"addTenantAccess": map[string]string{"tenant": project}}
+		if err := osc.Do(ctx, http.MethodPost, "compute", "/flavors/"+id+"/action", body, nil); err != nil && !common.IsOpenStackConflict(err) {
+			return err
+		}
+	}
+	for project := range current {
+		if want[project] {
+			continue
+		}
+		body := map[string]any{"removeTenantAccess": map[string]string{"tenant": project}}
+		if err := osc.Do(ctx, http.MethodPost, "compute", "/flavors/"+id+"/action", body, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingProjectError reports projects that do not exist in Keystone.
+type missingProjectError struct {
+	projects []string
+}
+
+func (e *missingProjectError) Error() string {
+	return fmt.Sprintf("projects not found: %s", strings.Join(e.projects, ", "))
+}
+
+// resolveProjects maps project names or IDs to IDs.
+func resolveProjects(ctx context.Context, osc *common.OpenStackClient, refs []string) ([]string, error) {
+	var ids, missing []string
+	for _, ref := range refs {
+		var byName struct {
+			Projects []struct {
+				ID string `json:"id"`
+			} `json:"projects"`
+		}
+		if err := osc.Do(ctx, http.MethodGet, "identity", "/projects?name="+url.QueryEscape(ref), nil, &byName); err != nil {
+			return nil, err
+		}
+		if len(byName.Projects) > 0 {
+			ids = append(ids, byName.Projects[0].ID)
+			continue
+		}
+		err := osc.Do(ctx, http.MethodGet, "identity", "/projects/"+url.PathEscape(ref), nil, nil)
+		switch {
+		case err == nil:
+			ids = append(ids, ref)
+		case common.IsOpenStackNotFound(err):
+			missing = append(missing, ref)
+		default:
+			return nil, err
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, &missingProjectError{projects: missing}
+	}
+	return ids, nil
+}
+
+func (r *OpenStackFlavorReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.OpenStackFlavor{}).
+		Watches(&openstackv1alpha1.Nova{}, handler.EnqueueRequestsFromMapFunc(r.flavorsForObject)).
+		Complete(r)
+}
+
+// flavorsForObject maps an object to the flavors in its namespace.
+func (r *OpenStackFlavorReconciler) flavorsForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	flavors := &openstackv1alpha1.OpenStackFlavorList{}
+	if err := r.List(ctx, flavors, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(flavors.Items))
+	for _, flavor := range flavors.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&flavor)})
+	}
+	return requests
+}
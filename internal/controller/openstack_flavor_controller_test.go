@@ -0,0 +1,231 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestFlavorDiff(t *testing.T) {
+	description := "general purpose"
+	base := novaFlavor{Name: "m1.small", VCPUs: 1, RAM: 2048, Disk: 20, Ephemeral: 0, Swap: 0, Public: true}
+	tests := []struct {
+		name   string
+		change func(f *novaFlavor)
+		want   []string
+	}{
+		{name: "equal", change: func(f *novaFlavor) {}},
+		{name: "ID and description are not compared", change: func(f *novaFlavor) { f.ID = "42"; f.Description = &description }},
+		{name: "name", change: func(f *novaFlavor) { f.Name = "m1.medium" }, want: []string{"name"}},
+		{name: "vcpus", change: func(f *novaFlavor) { f.VCPUs = 2 }, want: []string{"vcpus"}},
+		{name: "ram", change: func(f *novaFlavor) { f.RAM = 4096 }, want: []string{"ram"}},
+		{name: "disk", change: func(f *novaFlavor) { f.Disk = 40 }, want: []string{"disk"}},
+		{name: "ephemeral", change: func(f *novaFlavor) { f.Ephemeral = 10 }, want: []string{"ephemeral"}},
+		{name: "swap", change: func(f *novaFlavor) { f.Swap = 512 }, want: []string{"swap"}},
+		{name: "public", change: func(f *novaFlavor) { f.Public = false }, want: []string{"public"}},
+		{
+			name:   "several, in field order",
+			change: func(f *novaFlavor) { f.Public = false; f.RAM = 1024; f.VCPUs = 4 },
+			want:   []string{"vcpus", "ram", "public"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := base
+			tt.change(&current)
+			desired := base
+			if got := flavorDiff(&current, &desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("flavorDiff = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newFlavor(recordedID string) *openstackv1alpha1.OpenStackFlavor {
+	instance := &openstackv1alpha1.OpenStackFlavor{
+		ObjectMeta: metav1.ObjectMeta{Name: "small", Namespace: "openstack", Finalizers: []string{common.FinalizerName}},
+		Spec:       openstackv1alpha1.OpenStackFlavorSpec{VCPUs: 1, RAM: 2048, Disk: 20, Public: true},
+	}
+	instance.Status.FlavorID = recordedID
+	return instance
+}
+
+// flavorNova is a Nova, Ready unless ready is false.
+func flavorNova(ready bool) *openstackv1alpha1.Nova {
+	nova := computeNova(nil)
+	if ready {
+		nova.Status.Conditions = common.SetCondition(nil, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "", 0)
+	}
+	return nova
+}
+
+// reconcileFlavor reconciles instance against the fake OpenStack and
+// returns the stored resource, or nil once it is gone.
+func reconcileFlavor(t *testing.T, fos *fakeOpenStack, instance *openstackv1alpha1.OpenStackFlavor, nova *openstackv1alpha1.Nova) (*openstackv1alpha1.OpenStackFlavor, ctrl.Result) {
+	t.Helper()
+	ctx := context.Background()
+	objs := append(fos.controlPlane(), instance)
+	if nova != nil {
+		objs = append(objs, nova)
+	}
+	c := newFakeClient(t, objs...)
+	r := &OpenStackFlavorReconciler{Client: c, Scheme: testScheme}
+	result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := &openstackv1alpha1.OpenStackFlavor{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(instance), current); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, result
+		}
+		t.Fatal(err)
+	}
+	return current, result
+}
+
+func TestFlavorReconcile(t *testing.T) {
+	description := ""
+	small := novaFlavor{ID: "f-1", Name: "small", VCPUs: 1, RAM: 2048, Disk: 20, Public: true, Description: &description}
+	created := small
+	created.ID = "f-2"
+	resized := small
+	resized.RAM = 1024
+	ours := map[string]any{"extra_specs": map[string]string{common.OwnerMarkKey: "openstack/small"}}
+	tests := []struct {
+		name        string
+		recordedID  string
+		policy      string
+		existing    *novaFlavor
+		extraSpecs  map[string]any
+		wantID      string
+		wantReason  string
+		wantCreated bool
+		wantDeleted bool
+	}{
+		{name: "created and marked", wantID: "f-2", wantReason: "Synced", wantCreated: true},
+		{name: "adopted by owner mark", existing: &small, extraSpecs: ours, wantID: "f-1", wantReason: "Synced"},
+		{
+			name:       "unmarked flavor of the same name",
+			existing:   &small,
+			extraSpecs: map[string]any{"extra_specs": map[string]string{}},
+			wantReason: "FlavorNotManaged",
+		},
+		// Nova flavors are immutable, so a changed field replaces the flavor.
+		{
+			name:        "immutable field changed",
+			recordedID:  "f-1",
+			existing:    &resized,
+			extraSpecs:  ours,
+			wantID:      "f-2",
+			wantReason:  "Synced",
+			wantCreated: true,
+			wantDeleted: true,
+		},
+		{
+			name:       "immutable field changed, replacement rejected",
+			recordedID: "f-1",
+			policy:     "Reject",
+			existing:   &resized,
+			extraSpecs: ours,
+			wantID:     "f-1",
+			wantReason: "ReplacementRejected",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var flavors []novaFlavor
+			routes := map[string]fakeResponse{
+				"POST /flavors":                    {body: map[string]any{"flavor": created}},
+				"GET /flavors/f-2/os-extra_specs":  {body: ours},
+				"POST /flavors/f-2/os-extra_specs": {},
+				"DELETE /flavors/f-1":              {status: http.StatusAccepted},
+			}
+			if tt.existing != nil {
+				flavors = append(flavors, *tt.existing)
+				routes["GET /flavors/f-1"] = fakeResponse{body: map[string]any{"flavor": tt.existing}}
+				routes["GET /flavors/f-1/os-extra_specs"] = fakeResponse{body: tt.extraSpecs}
+			}
+			routes["GET /flavors/detail?is_public=None"] = fakeResponse{body: map[string]any{"flavors": flavors}}
+			_, fos := newFakeOpenStack(t, routes)
+			instance := newFlavor(tt.recordedID)
+			instance.Spec.UpdatePolicy = tt.policy
+
+			current, _ := reconcileFlavor(t, fos, instance, flavorNova(true))
+			cond := meta.FindStatusCondition(current.Status.Conditions, string(openstackv1alpha1.ConditionReady))
+			if cond == nil || cond.Reason != tt.wantReason {
+				t.Fatalf("Ready = %+v, want %s", cond, tt.wantReason)
+			}
+			if current.Status.FlavorID != tt.wantID {
+				t.Errorf("flavor = %q, want %q", current.Status.FlavorID, tt.wantID)
+			}
+			if got := fos.sent("POST /flavors"); got != tt.wantCreated {
+				t.Errorf("created = %v, want %v", got, tt.wantCreated)
+			}
+			if got := fos.sent("DELETE /flavors/f-1"); got != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", got, tt.wantDeleted)
+			}
+			if tt.wantCreated {
+				mark := fos.body("POST /flavors/f-2/os-extra_specs")["extra_specs"].(map[string]any)
+				if mark[common.OwnerMarkKey] != "openstack/small" {
+					t.Errorf("owner mark = %v", mark)
+				}
+			}
+			if got := current.Status.LastReplacementTime != nil; got != tt.wantDeleted {
+				t.Errorf("replacement time recorded = %v, want %v", got, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestFlavorReconcileDeletion(t *testing.T) {
+	tests := []struct {
+		name        string
+		nova        *openstackv1alpha1.Nova
+		wantKept    bool
+		wantDeleted bool
+	}{
+		// The flavor could not be deleted yet, so the deletion waits
+		// instead of leaking it.
+		{name: "Nova not ready", nova: flavorNova(false), wantKept: true},
+		{name: "Nova removed"},
+		{name: "Nova ready", nova: flavorNova(true), wantDeleted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, fos := newFakeOpenStack(t, map[string]fakeResponse{"DELETE /flavors/f-1": {status: http.StatusAccepted}})
+			instance := newFlavor("f-1")
+			now := metav1.Now()
+			instance.DeletionTimestamp = &now
+
+			current, result := reconcileFlavor(t, fos, instance, tt.nova)
+			if got := fos.sent("DELETE /flavors/f-1"); got != tt.wantDeleted {
+				t.Errorf("flavor deleted = %v, want %v", got, tt.wantDeleted)
+			}
+			if !tt.wantKept {
+				if current != nil {
+					t.Errorf("finalizers = %v, want removed", current.Finalizers)
+				}
+				return
+			}
+			if current == nil || result.RequeueAfter == 0 {
+				t.Fatalf("resource = %v, requeue = %v, want kept and retried", current, result.RequeueAfter)
+			}
+			cond := meta.FindStatusCondition(current.Status.Conditions, string(openstackv1alpha1.ConditionReady))
+			if cond == nil || cond.Reason != "WaitingForDependencies" || !strings.Contains(cond.Message, "Nova") {
+				t.Errorf("Ready = %+v, want waiting for Nova", cond)
+			}
+		})
+	}
+}
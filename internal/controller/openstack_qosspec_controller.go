@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// cinderQoSSpecs are QoS specs as represented by the Cinder API.
+type cinderQoSSpecs struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Consumer string            `json:"consumer"`
+	Specs    map[string]string `json:"specs"`
+}
+
+// OpenStackQoSSpecReconciler reconciles an OpenStackQoSSpec object.
+type OpenStackQoSSpecReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackqosspecs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackqosspecs/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackqosspecs/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackvolumetypes;cinders,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+func (r *OpenStackQoSSpecReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.OpenStackQoSSpec{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion: keep the QoS specs while volume types reference them,
+	// then remove them from Cinder. Types created by hand keep them in use
+	// until disassociated.
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			users, err := r.volumeTypesUsing(ctx, instance)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if len(users) > 0 {
+				message := fmt.Sprintf("used by OpenStackVolumeTypes %s", strings.Join(users, ", "))
+				return r.setReady(ctx, instance, metav1.ConditionFalse, "InUse", message, volumeTypeInUseInterval)
+			}
+			if instance.Status.QoSSpecID != "" {
+				osc, _, err := cinderAdminClient(ctx, r.Client, instance.Namespace)
+				switch {
+				case errors.Is(err, errCinderGone):
+					logger.Info("Cinder removed, not deleting QoS specs", "qosSpecs", instance.Status.QoSSpecID)
+				case errors.Is(err, common.ErrDependencyNotReady):
+					return r.setReady(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), 10*time.Second)
+				case err != nil:
+					return ctrl.Result{}, err
+				default:
+					if message, err := deleteQoSSpecs(ctx, osc, instance); err != nil {
+						return ctrl.Result{}, err
+					} else if message != "" {
+						return r.setReady(ctx, instance, metav1.ConditionFalse, "InUse", message, volumeTypeInUseInterval)
+					}
+				}
+			}
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	osc, _, err := cinderAdminClient(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), 10*time.Second)
+		}
+		return ctrl.Result{}, err
+	}
+
+	qos, err := ensureQoSSpecs(ctx, osc, instance)
+	if err != nil {
+		var unmanaged *cinderNotManagedError
+		if errors.As(err, &unmanaged) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "QoSSpecsNotManaged", err.Error(), volumeTypeResyncInterval)
+		}
+		return ctrl.Result{}, err
+	}
+	instance.Status.QoSSpecID = qos.ID
+
+	return r.setReady(ctx, instance, metav1.ConditionTrue, "Synced", fmt.Sprintf("QoS specs %s are in sync", qos.ID), volumeTypeResyncInterval)
+}
+
+// setReady records the Ready condition and requeues after the given interval.
+func (r *OpenStackQoSSpecReconciler) setReady(ctx context.Context, instance *openstackv1alpha1.OpenStackQoSSpec, status metav1.ConditionStatus, reason, message string, requeue time.Duration) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), status, reason, message, instance.Generation,
+	)
+	instance.Status.ObservedGeneration = instance.Generation
+	return ctrl.Result{RequeueAfter: requeue}, r.Status().Update(ctx, instance)
+}
+
+// volumeTypesUsing returns the names of the OpenStackVolumeTypes referencing the QoS specs.
+func (r *OpenStackQoSSpecReconciler) volumeTypesUsing(ctx context.Context, instance *openstackv1alpha1.OpenStackQoSSpec) ([]string, error) {
+	volumeTypes := &openstackv1alpha1.OpenStackVolumeTypeList{}
+	if err := r.List(ctx, volumeTypes, client.InNamespace(instance.Namespace)); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, volumeType := range volumeTypes.Items {
+		if volumeType.Spec.QoSSpecName == instance.Name {
+			names = append(names, volumeType.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// deleteQoSSpecs deletes the QoS specs unless volume types are associated
+// with them, in which case it returns why they were kept. Specs without the
+// owner mark of the resource are left alone.
+func deleteQoSSpecs(ctx context.Context, osc *common.OpenStackClient, instance *openstackv1alpha1.OpenStackQoSSpec) (string, error) {
+	id := instance.Status.QoSSpecID
+	var resp struct {
+		QoSSpecs cinderQoSSpecs `json:"qos_specs"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", "/qos-specs/"+url.PathEscape(id), nil, &resp); err != nil {
+		if common.IsOpenStackNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if owner := resp.QoSSpecs.Specs[common.OwnerMarkKey]; owner != common.OwnerMark(instance) {
+		log.FromContext(ctx).Info("QoS specs not managed by this resource, not deleting them", "qosSpecs", id, "owner", owner)
+		return "", nil
+	}
+	err := osc.Do(ctx, http.MethodDelete, "volumev3", "/qos-specs/"+id, nil, nil)
+	switch {
+	case common.IsOpenStackBadRequest(err):
+		// Volume types created by hand are associated with the specs.
+		return err.Error(), nil
+	case err != nil && !common.IsOpenStackNotFound(err):
+		return "", err
+	}
+	return "", nil
+}
+
+// desiredQoSSpecsKeys returns the QoS keys including the owner mark.
+func desiredQoSSpecsKeys(instance *openstackv1alpha1.OpenStackQoSSpec) map[string]string {
+	specs := make(map[string]string, len(instance.Spec.Specs)+1)
+	for k, v := range instance.Spec.Specs {
+		specs[k] = v
+	}
+	specs[common.OwnerMarkKey] = common.OwnerMark(instance)
+	return specs
+}
+
+// ensureQoSSpecs finds the QoS specs by ID or name, creating them if missing,
+// and makes their consumer and keys exactly match the spec. Specs found by
+// name are only adopted if they carry the owner mark of this resource, i.e.
+// they were created by it before their ID was recorded.
+func ensureQoSSpecs(ctx context.Context, osc *common.OpenStackClient, instance *openstackv1alpha1.OpenStackQoSSpec) (*cinderQoSSpecs, error) {
+	name := instance.Spec.Name
+	if name == "" {
+		name = instance.Name
+	}
+	current, err := findQoSSpecs(ctx, osc, instance.Status.QoSSpecID, name)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ID != instance.Status.QoSSpecID {
+		if owner := current.Specs[common.OwnerMarkKey]; owner != common.OwnerMark(instance) {
+			return nil, &cinderNotManagedError{kind: "QoS specs", id: current.ID, name: current.Name, owner: owner}
+		}
+	}
+	specs := desiredQoSSpecsKeys(instance)
+	if current == nil {
+		body := map[string]string{"name": name, "consumer": instance.Spec.Consumer}
+		for k, v := range specs {
+			body[k] = v
+		}
+		var resp struct {
+			QoSSpecs cinderQoSSpecs `json:"qos_specs"`
+		}
+		if err := osc.Do(ctx, http.MethodPost, "volumev3", "/qos-specs", map[string]any{"qos_specs": body}, &resp); err != nil {
+			return nil, err
+		}
+		return &resp.QoSSpecs, nil
+	}
+
+	changed, removed := specsDiff(current.Specs, specs)
+	if current.Consumer != instance.Spec.Consumer {
+		changed["consumer"] = instance.Spec.Consumer
+	}
+	if len(changed) > 0 {
+		if err := osc.Do(ctx, http.MethodPut, "volumev3", "/qos-specs/"+current.ID, map[string]any{"qos_specs": changed}, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(removed) > 0 {
+		if err := osc.Do(ctx, http.MethodPut, "volumev3", "/qos-specs/"+current.ID+"/delete_keys", map[string]any{"keys": removed}, nil); err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+// findQoSSpecs returns the QoS specs with the given ID, falling back to a
+// lookup by name; nil if neither exists.
+func findQoSSpecs(ctx context.Context, osc *common.OpenStackClient, id, name string) (*cinderQoSSpecs, error) {
+	if id != "" {
+		var resp struct {
+			QoSSpecs cinderQoSSpecs `json:"qos_specs"`
+		}
+		err := osc.Do(ctx, http.MethodGet, "volumev3", "/qos-specs/"+url.PathEscape(id), nil, &resp)
+		if err == nil {
+			return &resp.QoSSpecs, nil
+		}
+		if !common.IsOpenStackNotFound(err) {
+			return nil, err
+		}
+	}
+	var resp struct {
+		QoSSpecs []cinderQoSSpecs `json:"qos_specs"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", "/qos-specs", nil, &resp); err != nil {
+		return nil, err
+	}
+	for i := range resp.QoSSpecs {
+		if resp.QoSSpecs[i].Name == name {
+			return &resp.QoSSpecs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *OpenStackQoSSpecReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.OpenStackQoSSpec{}).
+		Watches(&openstackv1alpha1.Cinder{}, handler.EnqueueRequestsFromMapFunc(r.qosSpecsForObject)).
+		Watches(&openstackv1alpha1.OpenStackVolumeType{}, handler.EnqueueRequestsFromMapFunc(r.qosSpecsForObject)).
+		Complete(r)
+}
+
+// qosSpecsForObject maps an object to the QoS specs in its namespace.
+func (r *OpenStackQoSSpecReconciler) qosSpecsForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	qosSpecs := &openstackv1alpha1.OpenStackQoSSpecList{}
+	if err := r.List(ctx, qosSpecs, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(qosSpecs.Items))
+	for _, qos := range qosSpecs.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&qos)})
+	}
+	return requests
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestEnsureQoSSpecsAdoption(t *testing.T) {
+	tests := []struct {
+		name    string
+		owner   string
+		wantErr bool
+	}{
+		{name: "created by hand", wantErr: true},
+		{name: "marked by another resource", owner: "other/limits", wantErr: true},
+		{name: "marked by this resource", owner: "openstack/limits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs := map[string]string{"total_iops_sec": "500"}
+			if tt.owner != "" {
+				specs[common.OwnerMarkKey] = tt.owner
+			}
+			osc, fos := newFakeOpenStack(t, map[string]fakeResponse{
+				"GET /qos-specs": {body: map[string]any{"qos_specs": []cinderQoSSpecs{
+					{ID: "qos-1", Name: "limits", Consumer: "back-end", Specs: specs},
+				}}},
+			})
+			instance := &openstackv1alpha1.OpenStackQoSSpec{
+				ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "openstack"},
+				Spec: openstackv1alpha1.OpenStackQoSSpecSpec{
+					Consumer: "back-end",
+					Specs:    map[string]string{"total_iops_sec": "500"},
+				},
+			}
+
+			got, err := ensureQoSSpecs(context.Background(), osc, instance)
+			if tt.wantErr {
+				var unmanaged *cinderNotManagedError
+				if !errors.As(err, &unmanaged) {
+					t.Fatalf("error = %v, want cinderNotManagedError", err)
+				}
+				if fos.sent("POST /qos-specs") || fos.sent("PUT /qos-specs/qos-1") {
+					t.Error("changed QoS specs that are not managed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != "qos-1" {
+				t.Errorf("ID = %s, want qos-1", got.ID)
+			}
+			if fos.sent("PUT /qos-specs/qos-1") {
+				t.Error("updated QoS specs already in sync")
+			}
+		})
+	}
+}
+
+func TestDeleteQoSSpecs(t *testing.T) {
+	tests := []struct {
+		name        string
+		owner       string
+		deleteResp  fakeResponse
+		wantDeleted bool
+		wantInUse   bool
+	}{
+		{name: "marked by this resource", owner: "openstack/limits", deleteResp: fakeResponse{status: http.StatusAccepted}, wantDeleted: true},
+		{name: "not marked", deleteResp: fakeResponse{status: http.StatusAccepted}},
+		{name: "associated with a hand-made type", owner: "openstack/limits", deleteResp: fakeResponse{status: http.StatusBadRequest}, wantDeleted: true, wantInUse: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osc, fos := newFakeOpenStack(t, map[string]fakeResponse{
+				"GET /qos-specs/qos-1": {body: map[string]any{"qos_specs": cinderQoSSpecs{
+					ID: "qos-1", Name: "limits", Specs: map[string]string{common.OwnerMarkKey: tt.owner},
+				}}},
+				"DELETE /qos-specs/qos-1": tt.deleteResp,
+			})
+			instance := &openstackv1alpha1.OpenStackQoSSpec{
+				ObjectMeta: metav1.ObjectMeta{Name: "limits", Namespace: "openstack"},
+				Status:     openstackv1alpha1.OpenStackQoSSpecStatus{QoSSpecID: "qos-1"},
+			}
+
+			message, err := deleteQoSSpecs(context.Background(), osc, instance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := message != ""; got != tt.wantInUse {
+				t.Errorf("in use = %v (%q), want %v", got, message, tt.wantInUse)
+			}
+			if got := fos.sent("DELETE /qos-specs/qos-1"); got != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", got, tt.wantDeleted)
+			}
+			if fos.sent("DELETE /qos-specs/qos-1?force=True") {
+				t.Error("QoS specs deleted with force")
+			}
+		})
+	}
+}
+
+func TestQoSSpecReconcileDeletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		cinder   *openstackv1alpha1.Cinder
+		wantKept bool
+	}{
+		{name: "Cinder not ready", cinder: notReadyCinder(), wantKept: true},
+		{name: "Cinder removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			_, fos := newFakeOpenStack(t, map[string]fakeResponse{})
+			now := metav1.Now()
+			instance := &openstackv1alpha1.OpenStackQoSSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "limits", Namespace: "openstack", Finalizers: []string{common.FinalizerName}, DeletionTimestamp: &now,
+				},
+				Status: openstackv1alpha1.OpenStackQoSSpecStatus{QoSSpecID: "qos-1"},
+			}
+			objs := append(fos.controlPlane(), instance)
+			if tt.cinder != nil {
+				objs = append(objs, tt.cinder)
+			}
+			c := newFakeClient(t, objs...)
+			r := &OpenStackQoSSpecReconciler{Client: c, Scheme: testScheme}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fos.requests) != 0 {
+				t.Errorf("requests = %v, want none", fos.requests)
+			}
+			current := &openstackv1alpha1.OpenStackQoSSpec{}
+			err = c.Get(ctx, client.ObjectKeyFromObject(instance), current)
+			if !tt.wantKept {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("get = %v, want the finalizer removed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !common.HasFinalizer(current, common.FinalizerName) || result.RequeueAfter == 0 {
+				t.Errorf("finalizers = %v, requeue = %v, want kept and retried", current.Finalizers, result.RequeueAfter)
+			}
+			cond := meta.FindStatusCondition(current.Status.Conditions, string(openstackv1alpha1.ConditionReady))
+			if cond == nil || cond.Reason != "WaitingForDependencies" {
+				t.Errorf("Ready = %+v, want WaitingForDependencies", cond)
+			}
+		})
+	}
+}
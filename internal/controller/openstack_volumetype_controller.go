@@ -0,0 +1,684 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+const (
+	// volumeTypeResyncInterval is how often volume types and QoS specs are
+	// compared with Cinder to correct drift.
+	volumeTypeResyncInterval = 5 * time.Minute
+
+	// volumeTypeInUseInterval is how often the deletion of a volume type or
+	// QoS specs in use is retried.
+	volumeTypeInUseInterval = time.Minute
+
+	// volumePageSize is the page size for listing volumes, Cinder's default
+	// osapi_max_limit.
+	volumePageSize = 1000
+)
+
+// cinderVolumeType is a volume type as represented by the Cinder API.
+type cinderVolumeType struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Public      bool    `json:"is_public"`
+	QoSSpecsID  *string `json:"qos_specs_id"`
+}
+
+// cinderEncryption is a volume type encryption as represented by the Cinder API.
+type cinderEncryption struct {
+	EncryptionID    string `json:"encryption_id,omitempty"`
+	Provider        string `json:"provider"`
+	Cipher          string `json:"cipher"`
+	KeySize         int32  `json:"key_size"`
+	ControlLocation string `json:"control_location"`
+}
+
+// OpenStackVolumeTypeReconciler reconciles an OpenStackVolumeType object.
+type OpenStackVolumeTypeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackvolumetypes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackvolumetypes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackvolumetypes/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=openstackqosspecs;cinders;barbicans,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+func (r *OpenStackVolumeTypeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.OpenStackVolumeType{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion: remove the volume type from Cinder once no volume
+	// uses it. If Cinder itself is gone there is nothing left to clean up.
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			if instance.Status.VolumeTypeID != "" {
+				osc, cinder, err := cinderAdminClient(ctx, r.Client, instance.Namespace)
+				switch {
+				case errors.Is(err, errCinderGone):
+					logger.Info("Cinder removed, not deleting volume type", "volumeType", instance.Status.VolumeTypeID)
+				case errors.Is(err, common.ErrDependencyNotReady):
+					return r.setReady(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), 10*time.Second)
+				case err != nil:
+					return ctrl.Result{}, err
+				default:
+					if message, err := r.deleteVolumeType(ctx, osc, cinder, instance); err != nil {
+						return ctrl.Result{}, err
+					} else if message != "" {
+						return r.setReady(ctx, instance, metav1.ConditionFalse, "InUse", message, volumeTypeInUseInterval)
+					}
+				}
+			}
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	osc, cinder, err := cinderAdminClient(ctx, r.Client, instance.Namespace)
+	if err == nil && instance.Spec.Encryption != nil {
+		err = barbicanReady(ctx, r.Client, instance.Namespace)
+	}
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "WaitingForDependencies", err.Error(), 10*time.Second)
+		}
+		return ctrl.Result{}, err
+	}
+
+	desired := desiredVolumeType(instance)
+	if message := volumeTypeBackendError(cinder, desired.Name, instance.Spec.Backend); message != "" {
+		return r.setReady(ctx, instance, metav1.ConditionFalse, "InvalidBackend", message, volumeTypeResyncInterval)
+	}
+
+	qosSpecID := ""
+	if instance.Spec.QoSSpecName != "" {
+		qos := &openstackv1alpha1.OpenStackQoSSpec{}
+		err := r.Get(ctx, client.ObjectKey{Name: instance.Spec.QoSSpecName, Namespace: instance.Namespace}, qos)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if err != nil || qos.Status.QoSSpecID == "" || !qos.DeletionTimestamp.IsZero() {
+			message := fmt.Sprintf("OpenStackQoSSpec %s is not ready", instance.Spec.QoSSpecName)
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "QoSSpecNotReady", message, 10*time.Second)
+		}
+		qosSpecID = qos.Status.QoSSpecID
+	}
+
+	projects, err := resolveProjects(ctx, osc, instance.Spec.ProjectAccess)
+	if err != nil {
+		var missing *missingProjectError
+		if errors.As(err, &missing) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "ProjectNotFound", err.Error(), volumeTypeResyncInterval)
+		}
+		return ctrl.Result{}, err
+	}
+
+	extraSpecs := desiredVolumeTypeExtraSpecs(instance)
+	backendOwner := ""
+	if cinderHasBackend(cinder, desired.Name) {
+		backendOwner = common.OwnerMark(cinder)
+	}
+	volumeType, err := ensureCinderVolumeType(ctx, osc, instance.Status.VolumeTypeID, desired, extraSpecs, backendOwner)
+	if err != nil {
+		var unmanaged *cinderNotManagedError
+		if errors.As(err, &unmanaged) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "VolumeTypeNotManaged", err.Error(), volumeTypeResyncInterval)
+		}
+		return ctrl.Result{}, err
+	}
+	instance.Status.VolumeTypeID = volumeType.ID
+
+	if err := syncVolumeType(ctx, osc, volumeType, extraSpecs, projects, qosSpecID, instance.Spec.Encryption); err != nil {
+		// Cinder refuses to change the extra specs or encryption of a type
+		// in use; retry on the next resync in case the volumes are gone.
+		if common.IsOpenStackBadRequest(err) {
+			return r.setReady(ctx, instance, metav1.ConditionFalse, "UpdateRejected", err.Error(), volumeTypeResyncInterval)
+		}
+		return ctrl.Result{}, err
+	}
+	instance.Status.QoSSpecID = qosSpecID
+	instance.Status.Volumes = 0
+
+	return r.setReady(ctx, instance, metav1.ConditionTrue, "Synced", fmt.Sprintf("Volume type %s is in sync", volumeType.ID), volumeTypeResyncInterval)
+}
+
+// setReady records the Ready condition and requeues after the given interval.
+func (r *OpenStackVolumeTypeReconciler) setReady(ctx context.Context, instance *openstackv1alpha1.OpenStackVolumeType, status metav1.ConditionStatus, reason, message string, requeue time.Duration) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), status, reason, message, instance.Generation,
+	)
+	instance.Status.ObservedGeneration = instance.Generation
+	return ctrl.Result{RequeueAfter: requeue}, r.Status().Update(ctx, instance)
+}
+
+// deleteVolumeType deletes the volume type unless volumes use it, in which
+// case it returns why the type was kept. A type without the owner mark of
+// the resource is left alone, and the type of a Cinder backend, which the
+// Cinder controller manages, is marked as the Cinder's again.
+func (r *OpenStackVolumeTypeReconciler) deleteVolumeType(ctx context.Context, osc *common.OpenStackClient, cinder *openstackv1alpha1.Cinder, instance *openstackv1alpha1.OpenStackVolumeType) (string, error) {
+	logger := log.FromContext(ctx)
+	id := instance.Status.VolumeTypeID
+	var resp struct {
+		VolumeType cinderVolumeType `json:"volume_type"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", "/types/"+url.PathEscape(id), nil, &resp); err != nil {
+		if common.IsOpenStackNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	owner, err := volumeTypeOwnerMark(ctx, osc, id)
+	if err != nil {
+		return "", err
+	}
+	if owner != common.OwnerMark(instance) {
+		logger.Info("Volume type not managed by this resource, not deleting it", "volumeType", id, "owner", owner)
+		return "", nil
+	}
+	if cinderHasBackend(cinder, resp.VolumeType.Name) {
+		logger.Info("Volume type belongs to a Cinder backend, not deleting it", "volumeType", id)
+		body := map[string]any{"extra_specs": map[string]string{common.OwnerMarkKey: common.OwnerMark(cinder)}}
+		return "", osc.Do(ctx, http.MethodPost, "volumev3", "/types/"+id+"/extra_specs", body, nil)
+	}
+	volumes, err := countVolumesOfType(ctx, osc, resp.VolumeType.Name)
+	if err != nil {
+		return "", err
+	}
+	instance.Status.Volumes = volumes
+	if volumes > 0 {
+		return fmt.Sprintf("%d volumes use volume type %s", volumes, resp.VolumeType.Name), nil
+	}
+	err = osc.Do(ctx, http.MethodDelete, "volumev3", "/types/"+id, nil, nil)
+	switch {
+	case common.IsOpenStackBadRequest(err):
+		// A volume was created meanwhile, or snapshots or groups use the type.
+		return err.Error(), nil
+	case err != nil && !common.IsOpenStackNotFound(err):
+		return "", err
+	}
+	return "", nil
+}
+
+// countVolumesOfType counts the volumes of all projects with the given type.
+// Without a microversion Cinder reports the type of a volume by name.
+func countVolumesOfType(ctx context.Context, osc *common.OpenStackClient, name string) (int32, error) {
+	var count int32
+	marker := ""
+	for {
+		path := "/volumes/detail?all_tenants=1&limit=" + strconv.Itoa(volumePageSize)
+		if marker != "" {
+			path += "&marker=" + url.QueryEscape(marker)
+		}
+		var resp struct {
+			Volumes []struct {
+				ID         string `json:"id"`
+				VolumeType string `json:"volume_type"`
+			} `json:"volumes"`
+		}
+		if err := osc.Do(ctx, http.MethodGet, "volumev3", path, nil, &resp); err != nil {
+			return 0, err
+		}
+		for _, volume := range resp.Volumes {
+			if volume.VolumeType == name {
+				count++
+			}
+		}
+		if len(resp.Volumes) < volumePageSize {
+			return count, nil
+		}
+		marker = resp.Volumes[len(resp.Volumes)-1].ID
+	}
+}
+
+// errCinderGone reports that the namespace has no Cinder at all, as opposed
+// to one that is not Ready yet. It wraps ErrDependencyNotReady.
+var errCinderGone = fmt.Errorf("Cinder not found: %w", common.ErrDependencyNotReady)
+
+// cinderAdminClient returns an admin client and the Cinder in the namespace
+// once it is Ready, or ErrDependencyNotReady. errCinderGone is returned when
+// the namespace has no Cinder.
+func cinderAdminClient(ctx context.Context, c client.Client, namespace string) (*common.OpenStackClient, *openstackv1alpha1.Cinder, error) {
+	cinders := &openstackv1alpha1.CinderList{}
+	if err := c.List(ctx, cinders, client.InNamespace(namespace)); err != nil {
+		return nil, nil, err
+	}
+	if len(cinders.Items) == 0 {
+		return nil, nil, errCinderGone
+	}
+	if !common.IsReady(cinders.Items[0].Status.Conditions) {
+		return nil, nil, fmt.Errorf("Cinder: %w", common.ErrDependencyNotReady)
+	}
+	conns, err := common.ResolveConnections(ctx, c, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	osc, err := common.NewOpenStackClient(ctx, c, namespace, conns)
+	if err != nil {
+		return nil, nil, err
+	}
+	return osc, &cinders.Items[0], nil
+}
+
+// barbicanReady returns ErrDependencyNotReady unless a Barbican in the
+// namespace is Ready to store volume encryption keys.
+func barbicanReady(ctx context.Context, c client.Client, namespace string) error {
+	barbicans := &openstackv1alpha1.BarbicanList{}
+	if err := c.List(ctx, barbicans, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	if len(barbicans.Items) == 0 || !common.IsReady(barbicans.Items[0].Status.Conditions) {
+		return fmt.Errorf("Barbican: %w", common.ErrDependencyNotReady)
+	}
+	return nil
+}
+
+// cinderHasBackend reports whether the Cinder has a backend of the given
+// name, whose volume type the Cinder controller creates.
+func cinderHasBackend(cinder *openstackv1alpha1.Cinder, name string) bool {
+	for _, backend := range cinder.Spec.Backends {
+		if backend.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeTypeBackendError explains why the type may not bind to backend, or
+// returns "". The types the Cinder controller creates for its backends may
+// be managed here too, but only bound to their own backend.
+func volumeTypeBackendError(cinder *openstackv1alpha1.Cinder, name, backend string) string {
+	found := backend == ""
+	for _, b := range cinder.Spec.Backends {
+		if b.Name == name && backend != name {
+			return fmt.Sprintf("volume type %s belongs to Cinder backend %s and must set backend %s", name, b.Name, b.Name)
+		}
+		if b.Name == backend {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Sprintf("Cinder %s has no backend %s", cinder.Name, backend)
+	}
+	return ""
+}
+
+// desiredVolumeType converts the spec to its Cinder representation.
+func desiredVolumeType(instance *openstackv1alpha1.OpenStackVolumeType) *cinderVolumeType {
+	name := instance.Spec.Name
+	if name == "" {
+		name = instance.Name
+	}
+	description := instance.Spec.Description
+	return &cinderVolumeType{
+		Name:        name,
+		Description: &description,
+		Public:      instance.Spec.Public,
+	}
+}
+
+// desiredVolumeTypeExtraSpecs returns the extra specs including the backend
+// binding and the owner mark.
+func desiredVolumeTypeExtraSpecs(instance *openstackv1alpha1.OpenStackVolumeType) map[string]string {
+	specs := make(map[string]string, len(instance.Spec.ExtraSpecs)+2)
+	for k, v := range instance.Spec.ExtraSpecs {
+		specs[k] = v
+	}
+	if instance.Spec.Backend != "" {
+		specs["volume_backend_name"] = instance.Spec.Backend
+	}
+	specs[common.OwnerMarkKey] = common.OwnerMark(instance)
+	return specs
+}
+
+// cinderNotManagedError reports an existing volume type or QoS specs with
+// the desired name that do not carry the resource's owner mark.
+type cinderNotManagedError struct {
+	kind, id, name, owner string
+}
+
+func (e *cinderNotManagedError) Error() string {
+	if e.owner == "" {
+		return fmt.Sprintf("%s %s (%s) already exists and is not managed by the operator", e.kind, e.name, e.id)
+	}
+	return fmt.Sprintf("%s %s (%s) already exists and is managed by %s", e.kind, e.name, e.id, e.owner)
+}
+
+// volumeTypeOwnerMark returns the owner mark of a volume type, or "".
+func volumeTypeOwnerMark(ctx context.Context, osc *common.OpenStackClient, id string) (string, error) {
+	var resp struct {
+		ExtraSpecs map[string]string `json:"extra_specs"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", "/types/"+url.PathEscape(id)+"/extra_specs", nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.ExtraSpecs[common.OwnerMarkKey], nil
+}
+
+// ensureCinderVolumeType finds the volume type by ID or name, creating it
+// with its extra specs, owner mark included, if missing, and updates its
+// name, description and visibility in place. A type found by name is only
+// adopted if it carries the owner mark in extraSpecs, i.e. it was created
+// by the resource before its ID was recorded, or, if it is the type of a
+// Cinder backend, if it carries backendOwner, the mark the Cinder
+// controller creates it with, or no mark.
+func ensureCinderVolumeType(ctx context.Context, osc *common.OpenStackClient, id string, desired *cinderVolumeType, extraSpecs map[string]string, backendOwner string) (*cinderVolumeType, error) {
+	current, err := findVolumeType(ctx, osc, id, desired.Name)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.ID != id {
+		owner, err := volumeTypeOwnerMark(ctx, osc, current.ID)
+		if err != nil {
+			return nil, err
+		}
+		backendType := backendOwner != "" && (owner == "" || owner == backendOwner)
+		if owner != extraSpecs[common.OwnerMarkKey] && !backendType {
+			return nil, &cinderNotManagedError{kind: "volume type", id: current.ID, name: current.Name, owner: owner}
+		}
+	}
+	var resp struct {
+		VolumeType cinderVolumeType `json:"volume_type"`
+	}
+	if current == nil {
+		body := map[string]any{"volume_type": map[string]any{
+			"name":                            desired.Name,
+			"description":                     desired.Description,
+			"os-volume-type-access:is_public": desired.Public,
+			"extra_specs":                     extraSpecs,
+		}}
+		if err := osc.Do(ctx, http.MethodPost, "volumev3", "/types", body, &resp); err != nil {
+			return nil, err
+		}
+		return &resp.VolumeType, nil
+	}
+
+	if current.Name == desired.Name && current.Public == desired.Public &&
+		current.Description != nil && *current.Description == *desired.Description {
+		return current, nil
+	}
+	body := map[string]any{"volume_type": map[string]any{
+		"name":        desired.Name,
+		"description": desired.Description,
+		"is_public":   desired.Public,
+	}}
+	if err := osc.Do(ctx, http.MethodPut, "volumev3", "/types/"+current.ID, body, &resp); err != nil {
+		return nil, err
+	}
+	resp.VolumeType.QoSSpecsID = current.QoSSpecsID
+	return &resp.VolumeType, nil
+}
+
+// findVolumeType returns the volume type with the given ID, falling back to
+// a lookup by name; nil if neither exists.
+func findVolumeType(ctx context.Context, osc *common.OpenStackClient, id, name string) (*cinderVolumeType, error) {
+	if id != "" {
+		var resp struct {
+			VolumeType cinderVolumeType `json:"volume_type"`
+		}
+		err := osc.Do(ctx, http.MethodGet, "volumev3", "/types/"+url.PathEscape(id), nil, &resp)
+		if err == nil {
+			return &resp.VolumeType, nil
+		}
+		if !common.IsOpenStackNotFound(err) {
+			return nil, err
+		}
+	}
+	var resp struct {
+		VolumeTypes []cinderVolumeType `json:"volume_types"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", "/types?is_public=None", nil, &resp); err != nil {
+		return nil, err
+	}
+	for i := range resp.VolumeTypes {
+		if resp.VolumeTypes[i].Name == name {
+			return &resp.VolumeTypes[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// syncVolumeType makes the extra specs, project access, QoS association and
+// encryption of a volume type match desired.
+func syncVolumeType(ctx context.Context, osc *common.OpenStackClient, volumeType *cinderVolumeType, extraSpecs map[string]string, projects []string, qosSpecID string, encryption *openstackv1alpha1.VolumeTypeEncryption) error {
+	if err := syncVolumeTypeExtraSpecs(ctx, osc, volumeType.ID, extraSpecs); err != nil {
+		return err
+	}
+	if !volumeType.Public {
+		if err := syncVolumeTypeAccess(ctx, osc, volumeType.ID, projects); err != nil {
+			return err
+		}
+	}
+	if err := syncVolumeTypeQoS(ctx, osc, volumeType, qosSpecID); err != nil {
+		return err
+	}
+	return syncVolumeTypeEncryption(ctx, osc, volumeType.ID, encryption)
+}
+
+// syncVolumeTypeExtraSpecs makes the volume type's extra specs exactly match desired.
+func syncVolumeTypeExtraSpecs(ctx context.Context, osc *common.OpenStackClient, id string, desired map[string]string) error {
+	path := "/types/" + id + "/extra_specs"
+	var resp struct {
+		ExtraSpecs map[string]string `json:"extra_specs"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", path, nil, &resp); err != nil {
+		return err
+	}
+	changed, removed := specsDiff(resp.ExtraSpecs, desired)
+	if len(changed) > 0 {
+		if err := osc.Do(ctx, http.MethodPost, "volumev3", path, map[string]any{"extra_specs": changed}, nil); err != nil {
+			return err
+		}
+	}
+	for _, k := range removed {
+		if err := osc.Do(ctx, http.MethodDelete, "volumev3", path+"/"+url.PathEscape(k), nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// specsDiff returns the keys of desired that current lacks or holds with
+// another value, and the sorted keys of current that desired lacks.
+func specsDiff(current, desired map[string]string) (map[string]string, []string) {
+	changed := map[string]string{}
+	for k, v := range desired {
+		if value, ok := current[k]; !ok || value != v {
+			changed[k] = v
+		}
+	}
+	var removed []string
+	for k := range current {
+		if _, ok := desired[k]; !ok {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(removed)
+	return changed, removed
+}
+
+// syncVolumeTypeAccess makes the projects with access to a private volume
+// type exactly match desired.
+func syncVolumeTypeAccess(ctx context.Context, osc *common.OpenStackClient, id string, desired []string) error {
+	var resp struct {
+		VolumeTypeAccess []struct {
+			ProjectID string `json:"project_id"`
+		} `json:"volume_type_access"`
+	}
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", "/types/"+id+"/os-volume-type-access", nil, &resp); err != nil {
+		return err
+	}
+	current := make([]string, 0, len(resp.VolumeTypeAccess))
+	for _, access := range resp.VolumeTypeAccess {
+		current = append(current, access.ProjectID)
+	}
+	added, removed := projectAccessDiff(current, desired)
+	for _, project := range added {
+		body := map[string]any{"addProjectAccess": map[string]string{"project": project}}
+		if err := osc.Do(ctx, http.MethodPost, "volumev3", "/types/"+id+"/action", body, nil); err != nil && !common.IsOpenStackConflict(err) {
+			return err
+		}
+	}
+	for _, project := range removed {
+		body := map[string]any{"removeProjectAccess": map[string]string{"project": project}}
+		if err := osc.Do(ctx, http.MethodPost, "volumev3", "/types/"+id+"/action", body, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// projectAccessDiff returns the sorted projects to grant and to revoke
+// access so that current becomes desired.
+func projectAccessDiff(current, desired []string) (added, removed []string) {
+	have := map[string]bool{}
+	for _, project := range current {
+		have[project] = true
+	}
+	want := map[string]bool{}
+	for _, project := range desired {
+		if !have[project] && !want[project] {
+			added = append(added, project)
+		}
+		want[project] = true
+	}
+	for project := range have {
+		if !want[project] {
+			removed = append(removed, project)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// syncVolumeTypeQoS associates the volume type with the desired QoS specs,
+// or with none when desired is "".
+func syncVolumeTypeQoS(ctx context.Context, osc *common.OpenStackClient, volumeType *cinderVolumeType, desired string) error {
+	current := ""
+	if volumeType.QoSSpecsID != nil {
+		current = *volumeType.QoSSpecsID
+	}
+	if current == desired {
+		return nil
+	}
+	query := "?vol_type_id=" + url.QueryEscape(volumeType.ID)
+	if current != "" {
+		if err := osc.Do(ctx, http.MethodGet, "volumev3", "/qos-specs/"+current+"/disassociate"+query, nil, nil); err != nil && !common.IsOpenStackNotFound(err) {
+			return err
+		}
+	}
+	if desired != "" {
+		return osc.Do(ctx, http.MethodGet, "volumev3", "/qos-specs/"+desired+"/associate"+query, nil, nil)
+	}
+	return nil
+}
+
+// syncVolumeTypeEncryption creates, updates or removes the encryption of a
+// volume type to match desired.
+func syncVolumeTypeEncryption(ctx context.Context, osc *common.OpenStackClient, id string, desired *openstackv1alpha1.VolumeTypeEncryption) error {
+	path := "/types/" + id + "/encryption"
+	var current cinderEncryption
+	if err := osc.Do(ctx, http.MethodGet, "volumev3", path, nil, &current); err != nil {
+		return err
+	}
+	method, want := encryptionChange(current, desired)
+	switch method {
+	case http.MethodDelete:
+		err := osc.Do(ctx, http.MethodDelete, "volumev3", path+"/"+current.EncryptionID, nil, nil)
+		if common.IsOpenStackNotFound(err) {
+			return nil
+		}
+		return err
+	case http.MethodPost:
+		return osc.Do(ctx, http.MethodPost, "volumev3", path, map[string]any{"encryption": want}, nil)
+	case http.MethodPut:
+		return osc.Do(ctx, http.MethodPut, "volumev3", path+"/"+current.EncryptionID, map[string]any{"encryption": want}, nil)
+	}
+	return nil
+}
+
+// encryptionChange returns the request method that makes the current
+// encryption of a volume type match desired, "" if it already does, and the
+// encryption to send with POST or PUT.
+func encryptionChange(current cinderEncryption, desired *openstackv1alpha1.VolumeTypeEncryption) (string, cinderEncryption) {
+	if desired == nil {
+		if current.EncryptionID == "" {
+			return "", cinderEncryption{}
+		}
+		return http.MethodDelete, cinderEncryption{}
+	}
+	want := cinderEncryption{
+		Provider:        desired.Provider,
+		Cipher:          desired.Cipher,
+		KeySize:         desired.KeySize,
+		ControlLocation: desired.ControlLocation,
+	}
+	if current.EncryptionID == "" {
+		return http.MethodPost, want
+	}
+	current.EncryptionID = ""
+	if current == want {
+		return "", want
+	}
+	return http.MethodPut, want
+}
+
+func (r *OpenStackVolumeTypeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.OpenStackVolumeType{}).
+		Watches(&openstackv1alpha1.Cinder{}, handler.EnqueueRequestsFromMapFunc(r.volumeTypesForObject)).
+		Watches(&openstackv1alpha1.Barbican{}, handler.EnqueueRequestsFromMapFunc(r.volumeTypesForObject)).
+		Watches(&openstackv1alpha1.OpenStackQoSSpec{}, handler.EnqueueRequestsFromMapFunc(r.volumeTypesForObject)).
+		Complete(r)
+}
+
+// volumeTypesForObject maps an object to the volume types in its namespace.
+func (r *OpenStackVolumeTypeReconciler) volumeTypesForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	volumeTypes := &openstackv1alpha1.OpenStackVolumeTypeList{}
+	if err := r.List(ctx, volumeTypes, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(volumeTypes.Items))
+	for _, volumeType := range volumeTypes.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&volumeType)})
+	}
+	return requests
+}
@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestSpecsDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     map[string]string
+		desired     map[string]string
+		wantChanged map[string]string
+		wantRemoved []string
+	}{
+		{name: "equal", current: map[string]string{"a": "1"}, desired: map[string]string{"a": "1"}, wantChanged: map[string]string{}},
+		{name: "added", current: nil, desired: map[string]string{"a": "1"}, wantChanged: map[string]string{"a": "1"}},
+		{name: "changed", current: map[string]string{"a": "1"}, desired: map[string]string{"a": "2"}, wantChanged: map[string]string{"a": "2"}},
+		{name: "set to empty", current: map[string]string{"a": "1"}, desired: map[string]string{"a": ""}, wantChanged: map[string]string{"a": ""}},
+		{
+			name:        "removed, sorted",
+			current:     map[string]string{"c": "3", "a": "1", "b": "2"},
+			desired:     map[string]string{"b": "2"},
+			wantChanged: map[string]string{},
+			wantRemoved: []string{"a", "c"},
+		},
+		{
+			name:        "owner mark added to a hand-made type",
+			current:     map[string]string{"volume_backend_name": "rbd"},
+			desired:     map[string]string{"volume_backend_name": "rbd", common.OwnerMarkKey: "openstack/rbd"},
+			wantChanged: map[string]string{common.OwnerMarkKey: "openstack/rbd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, removed := specsDiff(tt.current, tt.desired)
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestProjectAccessDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		current     []string
+		desired     []string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{name: "none"},
+		{name: "equal in another order", current: []string{"p1", "p2"}, desired: []string{"p2", "p1"}},
+		{name: "granted, sorted", desired: []string{"p2", "p1"}, wantAdded: []string{"p1", "p2"}},
+		{name: "revoked, sorted", current: []string{"p3", "p1", "p2"}, desired: []string{"p2"}, wantRemoved: []string{"p1", "p3"}},
+		{name: "both", current: []string{"p1"}, desired: []string{"p2"}, wantAdded: []string{"p2"}, wantRemoved: []string{"p1"}},
+		{name: "duplicates granted once", desired: []string{"p1", "p1"}, wantAdded: []string{"p1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := projectAccessDiff(tt.current, tt.desired)
+			if !reflect.DeepEqual(added, tt.wantAdded) {
+				t.Errorf("added = %v, want %v", added, tt.wantAdded)
+			}
+			if !reflect.DeepEqual(removed, tt.wantRemoved) {
+				t.Errorf("removed = %v, want %v", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestEncryptionChange(t *testing.T) {
+	desired := &openstackv1alpha1.VolumeTypeEncryption{Provider: "luks", Cipher: "aes-xts-plain64", KeySize: 256, ControlLocation: "front-end"}
+	want := cinderEncryption{Provider: "luks", Cipher: "aes-xts-plain64", KeySize: 256, ControlLocation: "front-end"}
+	current := want
+	current.EncryptionID = "enc-1"
+	tests := []struct {
+		name       string
+		current    cinderEncryption
+		desired    *openstackv1alpha1.VolumeTypeEncryption
+		change     func(e *cinderEncryption)
+		wantMethod string
+	}{
+		{name: "none wanted, none set"},
+		{name: "removed", current: current, wantMethod: http.MethodDelete},
+		{name: "created", desired: desired, wantMethod: http.MethodPost},
+		{name: "equal", current: current, desired: desired},
+		{name: "cipher", current: current, desired: desired, change: func(e *cinderEncryption) { e.Cipher = "aes-cbc-essiv" }, wantMethod: http.MethodPut},
+		{name: "key size", current: current, desired: desired, change: func(e *cinderEncryption) { e.KeySize = 512 }, wantMethod: http.MethodPut},
+		{name: "control location", current: current, desired: desired, change: func(e *cinderEncryption) { e.ControlLocation = "back-end" }, wantMethod: http.MethodPut},
+		{name: "provider", current: current, desired: desired, change: func(e *cinderEncryption) { e.Provider = "plain" }, wantMethod: http.MethodPut},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.change != nil {
+				tt.change(&tt.current)
+			}
+			method, got := encryptionChange(tt.current, tt.desired)
+			if method != tt.wantMethod {
+				t.Errorf("method = %q, want %q", method, tt.wantMethod)
+			}
+			if (method == http.MethodPost || method == http.MethodPut) && got != want {
+				t.Errorf("encryption = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestEnsureCinderVolumeTypeAdoption(t *testing.T) {
+	description := ""
+	desired := &cinderVolumeType{Name: "gold", Description: &description, Public: true}
+	existing := cinderVolumeType{ID: "vt-1", Name: "gold", Description: &description, Public: true}
+	tests := []struct {
+		name         string
+		owner        string
+		backendOwner string
+		wantErr      bool
+	}{
+		{name: "created by hand", wantErr: true},
+		{name: "marked by another resource", owner: "other/gold", wantErr: true},
+		{name: "marked by this resource", owner: "openstack/gold"},
+		{name: "type of a Cinder backend", owner: "openstack/cinder", backendOwner: "openstack/cinder"},
+		{name: "unmarked type of a Cinder backend", backendOwner: "openstack/cinder"},
+		{name: "backend type marked by another resource", owner: "other/gold", backendOwner: "openstack/cinder", wantErr: true},
+		{name: "marked by the Cinder, not a backend type", owner: "openstack/cinder", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs := map[string]string{}
+			if tt.owner != "" {
+				specs[common.OwnerMarkKey] = tt.owner
+			}
+			osc, fos := newFakeOpenStack(t, map[string]fakeResponse{
+				"GET /types?is_public=None":   {body: map[string]any{"volume_types": []cinderVolumeType{existing}}},
+				"GET /types/vt-1/extra_specs": {body: map[string]any{"extra_specs": specs}},
+			})
+			extraSpecs := map[string]string{common.OwnerMarkKey: "openstack/gold"}
+
+			got, err := ensureCinderVolumeType(context.Background(), osc, "", desired, extraSpecs, tt.backendOwner)
+			if tt.wantErr {
+				var unmanaged *cinderNotManagedError
+				if !errors.As(err, &unmanaged) {
+					t.Fatalf("error = %v, want cinderNotManagedError", err)
+				}
+				if fos.sent("POST /types") {
+					t.Error("created a second type with the name")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != "vt-1" {
+				t.Errorf("ID = %s, want vt-1", got.ID)
+			}
+		})
+	}
+}
+
+func TestDeleteVolumeType(t *testing.T) {
+	instance := &openstackv1alpha1.OpenStackVolumeType{
+		ObjectMeta: metav1.ObjectMeta{Name: "gold", Namespace: "openstack"},
+		Status:     openstackv1alpha1.OpenStackVolumeTypeStatus{VolumeTypeID: "vt-1"},
+	}
+	tests := []struct {
+		name         string
+		typeName     string
+		owner        string
+		wantDeleted  bool
+		wantReturned bool
+	}{
+		{name: "marked by this resource", typeName: "gold", owner: "openstack/gold", wantDeleted: true},
+		{name: "not marked", typeName: "gold"},
+		{name: "marked by another resource", typeName: "gold", owner: "other/gold"},
+		{name: "type of a Cinder backend", typeName: "rbd", owner: "openstack/gold", wantReturned: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			osc, fos := newFakeOpenStack(t, map[string]fakeResponse{
+				"GET /types/vt-1":                              {body: map[string]any{"volume_type": cinderVolumeType{ID: "vt-1", Name: tt.typeName}}},
+				"GET /types/vt-1/extra_specs":                  {body: map[string]any{"extra_specs": map[string]string{common.OwnerMarkKey: tt.owner}}},
+				"GET /volumes/detail?all_tenants=1&limit=1000": {body: map[string]any{"volumes": []any{}}},
+				"DELETE /types/vt-1":                           {status: http.StatusAccepted},
+				"POST /types/vt-1/extra_specs":                 {},
+			})
+			cinder := &openstackv1alpha1.Cinder{ObjectMeta: metav1.ObjectMeta{Name: "cinder", Namespace: "openstack"}, Spec: openstackv1alpha1.CinderSpec{Backends: []openstackv1alpha1.CinderBackend{{Name: "rbd", Type: "ceph"}}}}
+			r := &OpenStackVolumeTypeReconciler{}
+
+			message, err := r.deleteVolumeType(context.Background(), osc, cinder, instance.DeepCopy())
+			if err != nil || message != "" {
+				t.Fatalf("deleteVolumeType = %q, %v", message, err)
+			}
+			if got := fos.sent("DELETE /types/vt-1"); got != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", got, tt.wantDeleted)
+			}
+			// The Cinder manages the type of its backend again.
+			if got := fos.sent("POST /types/vt-1/extra_specs"); got != tt.wantReturned {
+				t.Errorf("returned to the Cinder = %v, want %v", got, tt.wantReturned)
+			}
+			if tt.wantReturned {
+				if mark := fos.body("POST /types/vt-1/extra_specs")["extra_specs"].(map[string]any)[common.OwnerMarkKey]; mark != "openstack/cinder" {
+					t.Errorf("owner mark = %v, want openstack/cinder", mark)
+				}
+			}
+		})
+	}
+}
+
+// notReadyCinder is a Cinder in the middle of a rollout.
+func notReadyCinder() *openstackv1alpha1.Cinder {
+	return &openstackv1alpha1.Cinder{ObjectMeta: metav1.ObjectMeta{Name: "cinder", Namespace: "openstack"}}
+}
+
+func TestVolumeTypeReconcileDeletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		cinder   *openstackv1alpha1.Cinder
+		wantKept bool
+	}{
+		// The type cannot be checked for volumes or deleted yet, so the
+		// deletion waits instead of leaking it.
+		{name: "Cinder not ready", cinder: notReadyCinder(), wantKept: true},
+		{name: "Cinder removed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			_, fos := newFakeOpenStack(t, map[string]fakeResponse{})
+			now := metav1.Now()
+			instance := &openstackv1alpha1.OpenStackVolumeType{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "gold", Namespace: "openstack", Finalizers: []string{common.FinalizerName}, DeletionTimestamp: &now,
+				},
+				Status: openstackv1alpha1.OpenStackVolumeTypeStatus{VolumeTypeID: "vt-1"},
+			}
+			objs := append(fos.controlPlane(), instance)
+			if tt.cinder != nil {
+				objs = append(objs, tt.cinder)
+			}
+			c := newFakeClient(t, objs...)
+			r := &OpenStackVolumeTypeReconciler{Client: c, Scheme: testScheme}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(instance)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fos.requests) != 0 {
+				t.Errorf("requests = %v, want none", fos.requests)
+			}
+			current := &openstackv1alpha1.OpenStackVolumeType{}
+			err = c.Get(ctx, client.ObjectKeyFromObject(instance), current)
+			if !tt.wantKept {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("get = %v, want the finalizer removed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !common.HasFinalizer(current, common.FinalizerName) || result.RequeueAfter == 0 {
+				t.Errorf("finalizers = %v, requeue = %v, want kept and retried", current.Finalizers, result.RequeueAfter)
+			}
+			cond := meta.FindStatusCondition(current.Status.Conditions, string(openstackv1alpha1.ConditionReady))
+			if cond == nil || cond.Reason != "WaitingForDependencies" {
+				t.Errorf("Ready = %+v, want WaitingForDependencies", cond)
+			}
+		})
+	}
+}
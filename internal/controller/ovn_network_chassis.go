@@ -0,0 +1,481 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	defaultComputeNodeLabel = "openstack.k8s.io/compute"
+	defaultGatewayNodeLabel = "openstack.k8s.io/network-gateway"
+)
+
+// reconcileChassis runs ovn-controller (with a node-local Open vSwitch) and
+// the OVN metadata agent on the selected nodes while a Nova in the namespace
+// hosts compute inside Kubernetes, and reports chassis registration. In
+// shared mode the CNI already runs ovn-controller on every node, so only the
+// metadata agent is deployed. It returns whether chassis are managed.
+func (r *OVNNetworkReconciler) reconcileChassis(ctx context.Context, instance *openstackv1alpha1.OVNNetwork) (bool, error) {
+	nova, err := r.kubernetesComputeNova(ctx, instance.Namespace)
+	if err != nil {
+		return false, err
+	}
+	daemonSets := []string{instance.Name + "-ovn-controller", instance.Name + "-ovn-controller-gw", instance.Name + "-ovn-metadata-agent"}
+	if nova == nil {
+		for _, name := range daemonSets {
+			ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
+			if err := r.Delete(ctx, ds); client.IgnoreNotFound(err) != nil {
+				return false, err
+			}
+		}
+		instance.Status.Chassis = nil
+		return false, nil
+	}
+
+	encapType, bridgeMappings, err := r.chassisNetworking(ctx, instance)
+	if err != nil {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionChassisReady), metav1.ConditionFalse, "UnsupportedTunnelType", err.Error(), instance.Generation,
+		)
+		return true, nil
+	}
+
+	metadataSecret := instance.Name + "-metadata-secret"
+	if err := common.EnsureSecret(ctx, r.Client, metadataSecret, instance.Namespace, map[string]int{"secret": 32}, instance); err != nil {
+		return true, err
+	}
+	instance.Status.MetadataSecretName = metadataSecret
+	configHash, err := r.ensureMetadataAgentConfig(ctx, instance, nova, metadataSecret)
+	if err != nil {
+		return true, err
+	}
+
+	nodeSelector := instance.Spec.Chassis.NodeSelector
+	if len(nodeSelector) == 0 {
+		nodeSelector = map[string]string{defaultComputeNodeLabel: "true"}
+	}
+	gatewayLabel := instance.Spec.Chassis.GatewayNodeLabel
+	if gatewayLabel == "" {
+		gatewayLabel = defaultGatewayNodeLabel
+	}
+
+	if instance.Spec.SharedWithCNI {
+		for _, name := range daemonSets[:2] {
+			ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
+			if err := r.Delete(ctx, ds); client.IgnoreNotFound(err) != nil {
+				return true, err
+			}
+		}
+	} else {
+		for _, gateway := range []bool{false, true} {
+			if err := r.ensureOVNControllerDaemonSet(ctx, instance, nodeSelector, gatewayLabel, gateway, encapType, bridgeMappings); err != nil {
+				return true, err
+			}
+		}
+	}
+	if err := r.ensureMetadataAgentDaemonSet(ctx, instance, nodeSelector, configHash); err != nil {
+		return true, err
+	}
+
+	return true, r.updateChassisStatus(ctx, instance, nodeSelector, gatewayLabel)
+}
+
+// kubernetesComputeNova returns a Nova in the namespace that runs compute
+// inside Kubernetes, or nil if there is none.
+func (r *OVNNetworkReconciler) kubernetesComputeNova(ctx context.Context, namespace string) (*openstackv1alpha1.Nova, error) {
+	novas := &openstackv1alpha1.NovaList{}
+	if err := r.List(ctx, novas, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	for i := range novas.Items {
+		replicas := novas.Items[i].Spec.ComputeReplicas
+		if replicas == nil || *replicas > 0 {
+			return &novas.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// chassisNetworking derives the encapsulation type and bridge mappings from
+// the Neutron in the namespace.
+func (r *OVNNetworkReconciler) chassisNetworking(ctx context.Context, instance *openstackv1alpha1.OVNNetwork) (string, string, error) {
+	tunnelType := "geneve"
+	externalBridge := "br-ex"
+	neutrons := &openstackv1alpha1.NeutronList{}
+	if err := r.List(ctx, neutrons, client.InNamespace(instance.Namespace)); err != nil {
+		return "", "", err
+	}
+	if len(neutrons.Items) > 0 {
+		if t := neutrons.Items[0].Spec.TunnelType; t != "" {
+			tunnelType = t
+		}
+		if b := neutrons.Items[0].Spec.ExternalBridge; b != "" {
+			externalBridge = b
+		}
+	}
+	if tunnelType != "geneve" && tunnelType != "vxlan" {
+		return "", "", fmt.Errorf("OVN chassis support geneve and vxlan encapsulation, not %s", tunnelType)
+	}
+
+	mappings := instance.Spec.Chassis.BridgeMappings
+	if len(mappings) == 0 {
+		mappings = []string{"physnet1:" + externalBridge}
+	}
+	return tunnelType, strings.Join(mappings, ","), nil
+}
+
+func (r *OVNNetworkReconciler) ensureMetadataAgentConfig(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, nova *openstackv1alpha1.Nova, metadataSecret string) (string, error) {
+	secretValue, err := common.GetSecretValue(ctx, r.Client, metadataSecret, instance.Namespace, "secret")
+	if err != nil {
+		return "", err
+	}
+	rendered, err := common.RenderTemplate("ovn/ovn_metadata_agent.ini", map[string]any{
+		"NovaMetadataHost": fmt.Sprintf("%s-metadata.%s.svc", nova.Name, nova.Namespace),
+		"MetadataSecret":   secretValue,
+		"OVNSBConnection":  instance.Status.SouthboundDBEndpoint,
+		"OVNTLS":           instance.Status.TLSSecretName != "",
+	})
+	if err != nil {
+		return "", err
+	}
+	files := map[string]string{"ovn_metadata_agent.ini": rendered}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-metadata-agent-config", Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labelsForOVN(instance.Name, "metadata-agent")
+		secret.Data = map[string][]byte{"ovn_metadata_agent.ini": []byte(rendered)}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return "", err
+	}
+	return common.ConfigHash(files), nil
+}
+
+// ensureOVNControllerDaemonSet runs Open vSwitch and ovn-controller on
+// chassis nodes. Gateway and non-gateway nodes get separate DaemonSets so
+// that each pod knows its role without reading its node.
+func (r *OVNNetworkReconciler) ensureOVNControllerDaemonSet(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, nodeSelector map[string]string, gatewayLabel string, gateway bool, encapType, bridgeMappings string) error {
+	name := instance.Name + "-ovn-controller"
+	component := "ovn-controller"
+	gatewayOperator := corev1.NodeSelectorOpNotIn
+	if gateway {
+		name += "-gw"
+		component += "-gw"
+		gatewayOperator = corev1.NodeSelectorOpIn
+	}
+	labels := labelsForOVN(instance.Name, component)
+
+	volumes := []corev1.Volume{
+		ovsdbScriptsVolume(instance),
+		hostPathVolume("run-openvswitch", "/run/openvswitch"),
+		hostPathVolume("var-lib-openvswitch", "/var/lib/openvswitch"),
+		hostPathVolume("lib-modules", "/lib/modules"),
+	}
+	ovsMounts := []corev1.VolumeMount{
+		{Name: "scripts", MountPath: "/scripts", ReadOnly: true},
+		{Name: "run-openvswitch", MountPath: "/run/openvswitch"},
+		{Name: "var-lib-openvswitch", MountPath: "/var/lib/openvswitch"},
+		{Name: "lib-modules", MountPath: "/lib/modules", ReadOnly: true},
+	}
+	controllerMounts := []corev1.VolumeMount{ovsMounts[0], ovsMounts[1]}
+	if instance.Status.TLSSecretName != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name:         "tls",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: instance.Status.TLSSecretName}},
+		})
+		controllerMounts = append(controllerMounts, corev1.VolumeMount{Name: "tls", MountPath: "/etc/ovn/tls", ReadOnly: true})
+	}
+
+	privileged := true
+	securityContext := &corev1.SecurityContext{Privileged: &privileged}
+	env := []corev1.EnvVar{
+		{Name: "NODE_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}},
+		{Name: "HOST_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"}}},
+		{Name: "SB_REMOTE", Value: instance.Status.SouthboundDBEndpoint},
+		{Name: "ENCAP_TYPE", Value: encapType},
+		{Name: "BRIDGE_MAPPINGS", Value: bridgeMappings},
+		{Name: "GATEWAY", Value: fmt.Sprint(gateway)},
+	}
+
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, ds, func() error {
+		ds.Labels = labels
+		if ds.CreationTimestamp.IsZero() {
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		ds.Spec.Template.Labels = labels
+		ds.Spec.Template.Spec.NodeSelector = nodeSelector
+		ds.Spec.Template.Spec.Affinity = &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchExpressions: []corev1.NodeSelectorRequirement{
+							{Key: gatewayLabel, Operator: gatewayOperator, Values: []string{"true"}},
+						},
+					}},
+				},
+			},
+		}
+		ds.Spec.Template.Spec.HostNetwork = true
+		ds.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		ds.Spec.Template.Spec.Volumes = volumes
+		ds.Spec.Template.Spec.Containers = []corev1.Container{
+			{
+				Name:            "ovsdb-server",
+				Image:           images.DefaultOpenvSwitchDB,
+				Command:         []string{"/bin/sh", "/scripts/ovs-db-server.sh"},
+				VolumeMounts:    ovsMounts,
+				SecurityContext: securityContext,
+				ReadinessProbe:  chassisReadinessProbe("ovsdb-server"),
+			},
+			{
+				Name:            "ovs-vswitchd",
+				Image:           images.DefaultOpenvSwitchd,
+				Command:         []string{"/bin/sh", "/scripts/ovs-vswitchd.sh"},
+				VolumeMounts:    ovsMounts,
+				SecurityContext: securityContext,
+				ReadinessProbe:  chassisReadinessProbe("ovs-vswitchd"),
+			},
+			{
+				Name:            "ovn-controller",
+				Image:           images.DefaultOVNController,
+				Command:         []string{"/bin/sh", "/scripts/ovn-controller.sh"},
+				Env:             env,
+				VolumeMounts:    controllerMounts,
+				SecurityContext: securityContext,
+				ReadinessProbe:  chassisReadinessProbe("ovn-controller"),
+			},
+		}
+		return controllerutil.SetControllerReference(instance, ds, r.Scheme)
+	})
+	return err
+}
+
+// chassisReadinessProbe runs chassis-ready.sh for a daemon of the chassis
+// DaemonSets, so that their readiness reflects Open vSwitch and the
+// southbound connection rather than container start.
+func chassisReadinessProbe(daemon string) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "/scripts/chassis-ready.sh", daemon}},
+		},
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+		TimeoutSeconds:      10,
+	}
+}
+
+func (r *OVNNetworkReconciler) ensureMetadataAgentDaemonSet(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, nodeSelector map[string]string, configHash string) error {
+	labels := labelsForOVN(instance.Name, "metadata-agent")
+	bidirectional := corev1.MountPropagationBidirectional
+	privileged := true
+
+	volumes := []corev1.Volume{
+		{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: instance.Name + "-metadata-agent-config"},
+			},
+		},
+		hostPathVolume("run-openvswitch", "/run/openvswitch"),
+		hostPathVolume("run-netns", "/run/netns"),
+		hostPathVolume("var-lib-neutron", "/var/lib/neutron"),
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{Name: "config", MountPath: "/etc/neutron/ovn_metadata_agent.ini", SubPath: "ovn_metadata_agent.ini", ReadOnly: true},
+		{Name: "run-openvswitch", MountPath: "/run/openvswitch"},
+		{Name: "run-netns", MountPath: "/run/netns", MountPropagation: &bidirectional},
+		{Name: "var-lib-neutron", MountPath: "/var/lib/neutron"},
+	}
+	if instance.Status.TLSSecretName != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name:         "tls",
+			VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: instance.Status.TLSSecretName}},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: "tls", MountPath: "/etc/ovn/tls", ReadOnly: true})
+	}
+
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-ovn-metadata-agent", Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, ds, func() error {
+		ds.Labels = labels
+		if ds.CreationTimestamp.IsZero() {
+			ds.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		ds.Spec.Template.Labels = labels
+		ds.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		ds.Spec.Template.Spec.NodeSelector = nodeSelector
+		ds.Spec.Template.Spec.HostNetwork = true
+		ds.Spec.Template.Spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		ds.Spec.Template.Spec.Volumes = volumes
+		ds.Spec.Template.Spec.Containers = []corev1.Container{
+			{
+				Name:  "neutron-ovn-metadata-agent",
+				Image: images.DefaultNeutronOVNMetadata,
+				Command: []string{
+					"neutron-ovn-metadata-agent",
+					"--config-file", "/etc/neutron/ovn_metadata_agent.ini",
+				},
+				VolumeMounts:    volumeMounts,
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+			},
+		}
+		return controllerutil.SetControllerReference(instance, ds, r.Scheme)
+	})
+	return err
+}
+
+// updateChassisStatus reports, for every selected node, whether a Chassis
+// row exists in the SB database. Chassis are matched by system-id (the node
+// name for operator-run chassis) or by hostname (for CNI-run chassis).
+func (r *OVNNetworkReconciler) updateChassisStatus(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, nodeSelector map[string]string, gatewayLabel string) error {
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes, client.MatchingLabels(nodeSelector)); err != nil {
+		return err
+	}
+
+	var tlsConfig *tls.Config
+	if instance.Status.TLSSecretName != "" {
+		var err error
+		if tlsConfig, err = common.OVSDBTLSConfig(ctx, r.Client, instance.Status.TLSSecretName, instance.Namespace); err != nil {
+			return err
+		}
+	}
+	chassis, err := sbChassis(ctx, instance.Status.SouthboundDBEndpoint, tlsConfig)
+	if err != nil {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionChassisReady), metav1.ConditionFalse, "SouthboundUnreachable", err.Error(), instance.Generation,
+		)
+		return nil
+	}
+
+	statuses := make([]openstackv1alpha1.OVNChassisStatus, 0, len(nodes.Items))
+	var missing []string
+	for _, node := range nodes.Items {
+		status := openstackv1alpha1.OVNChassisStatus{Node: node.Name}
+		if c, ok := chassis[node.Name]; ok {
+			status.Registered = true
+			status.Gateway = c.gateway
+			status.EncapIP = c.encapIP
+		} else {
+			missing = append(missing, node.Name)
+			status.Gateway = node.Labels[gatewayLabel] == "true"
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Node < statuses[j].Node })
+	instance.Status.Chassis = statuses
+
+	switch {
+	case len(nodes.Items) == 0:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionChassisReady), metav1.ConditionFalse, "NoChassisNodes",
+			fmt.Sprintf("No nodes match the chassis node selector %v", nodeSelector), instance.Generation,
+		)
+	case len(missing) > 0:
+		sort.Strings(missing)
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionChassisReady), metav1.ConditionFalse, "ChassisNotRegistered",
+			fmt.Sprintf("Waiting for chassis registration of %s", strings.Join(missing, ", ")), instance.Generation,
+		)
+	default:
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionChassisReady), metav1.ConditionTrue, "ChassisRegistered",
+			fmt.Sprintf("%d chassis registered", len(nodes.Items)), instance.Generation,
+		)
+	}
+	return nil
+}
+
+// sbChassisInfo is what the SB database reports about one chassis.
+type sbChassisInfo struct {
+	gateway bool
+	encapIP string
+}
+
+// sbChassis reads Chassis and Encap rows, keyed by both chassis name and hostname.
+func sbChassis(ctx context.Context, remotes string, tlsConfig *tls.Config) (map[string]sbChassisInfo, error) {
+	c, err := common.DialOVSDB(ctx, remotes, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	encaps, err := c.Select(ovsdbSouthbound.dbName, "Encap", []string{"chassis_name", "ip"})
+	if err != nil {
+		return nil, err
+	}
+	encapIPs := map[string]string{}
+	for _, row := range encaps {
+		name, _ := row["chassis_name"].(string)
+		ip, _ := row["ip"].(string)
+		encapIPs[name] = ip
+	}
+
+	rows, err := c.Select(ovsdbSouthbound.dbName, "Chassis", []string{"name", "hostname", "other_config", "external_ids"})
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]sbChassisInfo{}
+	for _, row := range rows {
+		name, _ := row["name"].(string)
+		hostname, _ := row["hostname"].(string)
+		// Newer OVN publishes ovn-cms-options in other_config, older in external_ids.
+		cmsOptions := common.OVSDBMap(row["other_config"])["ovn-cms-options"]
+		if cmsOptions == "" {
+			cmsOptions = common.OVSDBMap(row["external_ids"])["ovn-cms-options"]
+		}
+		info := sbChassisInfo{
+			gateway: strings.Contains(cmsOptions, "enable-chassis-as-gw"),
+			encapIP: encapIPs[name],
+		}
+		out[name] = info
+		if hostname != "" {
+			out[hostname] = info
+		}
+	}
+	return out, nil
+}
+
+// ovnNetworksForObject maps a Nova or Neutron to the OVNNetworks in its
+// namespace, whose chassis configuration depends on them.
+func (r *OVNNetworkReconciler) ovnNetworksForObject(ctx context.Context, obj client.Object) []reconcile.Request {
+	ovns := &openstackv1alpha1.OVNNetworkList{}
+	if err := r.List(ctx, ovns, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(ovns.Items))
+	for _, ovn := range ovns.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ovn)})
+	}
+	return requests
+}
+
+func hostPathVolume(name, path string) corev1.Volume {
+	hostPathType := corev1.HostPathDirectoryOrCreate
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: path, Type: &hostPathType},
+		},
+	}
+}
@@ -0,0 +1,274 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// chassisOVNNetwork is a ready standalone OVNNetwork whose southbound
+// endpoint cannot be dialled, so chassis registration is never seen.
+func chassisOVNNetwork() *openstackv1alpha1.OVNNetwork {
+	instance := newOVNNetwork(1, false)
+	instance.UID = "ovn-uid"
+	instance.Status.SouthboundDBEndpoint = "unix:/run/ovn/ovnsb_db.sock"
+	return instance
+}
+
+func computeNova(replicas *int32) *openstackv1alpha1.Nova {
+	return &openstackv1alpha1.Nova{
+		ObjectMeta: metav1.ObjectMeta{Name: "nova", Namespace: "openstack"},
+		Spec:       openstackv1alpha1.NovaSpec{ComputeReplicas: replicas},
+	}
+}
+
+func chassisDaemonSets(t *testing.T, c client.Client) map[string]*appsv1.DaemonSet {
+	t.Helper()
+	list := &appsv1.DaemonSetList{}
+	if err := c.List(context.Background(), list, client.InNamespace("openstack")); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]*appsv1.DaemonSet{}
+	for i := range list.Items {
+		out[list.Items[i].Name] = &list.Items[i]
+	}
+	return out
+}
+
+func TestKubernetesComputeNova(t *testing.T) {
+	zero, two := int32(0), int32(2)
+	tests := []struct {
+		name string
+		nova *openstackv1alpha1.Nova
+		want bool
+	}{
+		{name: "no Nova"},
+		{name: "external compute", nova: computeNova(&zero)},
+		{name: "default replicas", nova: computeNova(nil), want: true},
+		{name: "compute replicas", nova: computeNova(&two), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []client.Object
+			if tt.nova != nil {
+				objs = append(objs, tt.nova)
+			}
+			r := &OVNNetworkReconciler{Client: newFakeClient(t, objs...), Scheme: testScheme}
+			got, err := r.kubernetesComputeNova(context.Background(), "openstack")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got != nil) != tt.want {
+				t.Errorf("nova = %v, want found %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileChassis(t *testing.T) {
+	ctx := context.Background()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "compute-0", Labels: map[string]string{defaultComputeNodeLabel: "true"}}}
+	c := newFakeClient(t, computeNova(nil), node)
+	r := &OVNNetworkReconciler{Client: c, Scheme: testScheme}
+	instance := chassisOVNNetwork()
+
+	managed, err := r.reconcileChassis(ctx, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !managed {
+		t.Fatal("chassis not managed")
+	}
+	daemonSets := chassisDaemonSets(t, c)
+	for name, operator := range map[string]corev1.NodeSelectorOperator{
+		"ovn-ovn-controller":    corev1.NodeSelectorOpNotIn,
+		"ovn-ovn-controller-gw": corev1.NodeSelectorOpIn,
+	} {
+		ds := daemonSets[name]
+		if ds == nil {
+			t.Fatalf("DaemonSet %s not created", name)
+		}
+		term := ds.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0]
+		if req := term.MatchExpressions[0]; req.Key != defaultGatewayNodeLabel || req.Operator != operator {
+			t.Errorf("%s gateway affinity = %+v", name, req)
+		}
+		if ds.Spec.Template.Spec.NodeSelector[defaultComputeNodeLabel] != "true" {
+			t.Errorf("%s node selector = %v", name, ds.Spec.Template.Spec.NodeSelector)
+		}
+		// Readiness reflects each daemon, not container start.
+		for _, container := range ds.Spec.Template.Spec.Containers {
+			probe := container.ReadinessProbe
+			if probe == nil || probe.Exec == nil || probe.Exec.Command[len(probe.Exec.Command)-1] != container.Name {
+				t.Errorf("%s/%s readiness probe = %+v", name, container.Name, probe)
+			}
+		}
+	}
+	metadata := daemonSets["ovn-ovn-metadata-agent"]
+	if metadata == nil {
+		t.Fatal("metadata agent DaemonSet not created")
+	}
+	hash := metadata.Spec.Template.Annotations[common.ConfigHashAnnotation]
+	if hash == "" {
+		t.Error("metadata agent has no config hash")
+	}
+	if instance.Status.MetadataSecretName != "ovn-metadata-secret" {
+		t.Errorf("metadata secret = %q", instance.Status.MetadataSecretName)
+	}
+	// The southbound database is unreachable, so no chassis is registered.
+	cond := meta.FindStatusCondition(instance.Status.Conditions, string(openstackv1alpha1.ConditionChassisReady))
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "SouthboundUnreachable" {
+		t.Errorf("ChassisReady = %+v, want SouthboundUnreachable", cond)
+	}
+
+	// A second pass rewrites nothing and keeps the metadata secret, so the
+	// agents are not restarted.
+	if _, err := r.reconcileChassis(ctx, chassisOVNNetwork()); err != nil {
+		t.Fatal(err)
+	}
+	for name, ds := range chassisDaemonSets(t, c) {
+		if ds.ResourceVersion != daemonSets[name].ResourceVersion {
+			t.Errorf("%s updated", name)
+		}
+	}
+}
+
+func TestReconcileChassisWithoutCompute(t *testing.T) {
+	ctx := context.Background()
+	zero := int32(0)
+	var objs []client.Object
+	for _, name := range []string{"ovn-ovn-controller", "ovn-ovn-controller-gw", "ovn-ovn-metadata-agent"} {
+		objs = append(objs, &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openstack"}})
+	}
+	c := newFakeClient(t, append(objs, computeNova(&zero))...)
+	r := &OVNNetworkReconciler{Client: c, Scheme: testScheme}
+	instance := chassisOVNNetwork()
+	instance.Status.Chassis = []openstackv1alpha1.OVNChassisStatus{{Node: "compute-0", Registered: true}}
+
+	managed, err := r.reconcileChassis(ctx, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if managed {
+		t.Error("chassis managed without Kubernetes compute")
+	}
+	if len(chassisDaemonSets(t, c)) != 0 {
+		t.Errorf("DaemonSets left: %v", chassisDaemonSets(t, c))
+	}
+	if instance.Status.Chassis != nil {
+		t.Errorf("chassis status = %v", instance.Status.Chassis)
+	}
+
+	// Nothing to delete is not an error.
+	if _, err := r.reconcileChassis(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileChassisShared(t *testing.T) {
+	ctx := context.Background()
+	stale := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "ovn-ovn-controller", Namespace: "openstack"}}
+	c := newFakeClient(t, computeNova(nil), stale)
+	r := &OVNNetworkReconciler{Client: c, Scheme: testScheme}
+	instance := chassisOVNNetwork()
+	instance.Spec.SharedWithCNI = true
+
+	if _, err := r.reconcileChassis(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+	// The CNI runs ovn-controller; only the metadata agent is the operator's.
+	daemonSets := chassisDaemonSets(t, c)
+	if len(daemonSets) != 1 || daemonSets["ovn-ovn-metadata-agent"] == nil {
+		t.Errorf("DaemonSets = %v, want the metadata agent only", daemonSets)
+	}
+}
+
+func TestReconcileChassisTunnelType(t *testing.T) {
+	neutron := &openstackv1alpha1.Neutron{
+		ObjectMeta: metav1.ObjectMeta{Name: "neutron", Namespace: "openstack"},
+		Spec:       openstackv1alpha1.NeutronSpec{TunnelType: "gre"},
+	}
+	c := newFakeClient(t, computeNova(nil), neutron)
+	r := &OVNNetworkReconciler{Client: c, Scheme: testScheme}
+	instance := chassisOVNNetwork()
+
+	managed, err := r.reconcileChassis(context.Background(), instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !managed {
+		t.Error("chassis not managed")
+	}
+	cond := meta.FindStatusCondition(instance.Status.Conditions, string(openstackv1alpha1.ConditionChassisReady))
+	if cond == nil || cond.Reason != "UnsupportedTunnelType" {
+		t.Errorf("ChassisReady = %+v, want UnsupportedTunnelType", cond)
+	}
+	if len(chassisDaemonSets(t, c)) != 0 {
+		t.Error("DaemonSets created for an unsupported tunnel type")
+	}
+}
+
+func TestChassisDaemonSetsTLS(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t)
+	r := &OVNNetworkReconciler{Client: c, Scheme: testScheme}
+	instance := chassisOVNNetwork()
+	instance.Status.TLSSecretName = ovnTLSSecretName(instance)
+	selector := map[string]string{defaultComputeNodeLabel: "true"}
+
+	if err := r.ensureOVNControllerDaemonSet(ctx, instance, selector, defaultGatewayNodeLabel, false, "geneve", "physnet1:br-ex"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ensureMetadataAgentDaemonSet(ctx, instance, selector, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	for name, container := range map[string]string{"ovn-ovn-controller": "ovn-controller", "ovn-ovn-metadata-agent": "neutron-ovn-metadata-agent"} {
+		ds := &appsv1.DaemonSet{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: "openstack"}, ds); err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, vol := range ds.Spec.Template.Spec.Volumes {
+			if vol.Secret != nil && vol.Secret.SecretName == "ovn-ovndb-tls" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s does not mount the TLS Secret", name)
+		}
+		for _, ctr := range ds.Spec.Template.Spec.Containers {
+			if ctr.Name != container {
+				continue
+			}
+			mounted := false
+			for _, mount := range ctr.VolumeMounts {
+				mounted = mounted || mount.MountPath == "/etc/ovn/tls"
+			}
+			if !mounted {
+				t.Errorf("%s/%s has no /etc/ovn/tls mount", name, container)
+			}
+		}
+	}
+
+	// Without TLS the mounts go away again.
+	instance.Status.TLSSecretName = ""
+	if err := r.ensureMetadataAgentDaemonSet(ctx, instance, selector, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	ds := &appsv1.DaemonSet{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "ovn-ovn-metadata-agent", Namespace: "openstack"}, ds); err != nil {
+		t.Fatal(err)
+	}
+	for _, vol := range ds.Spec.Template.Spec.Volumes {
+		if vol.Name == "tls" {
+			t.Error("TLS volume kept after TLS was disabled")
+		}
+	}
+}
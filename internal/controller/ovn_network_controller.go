@@ -0,0 +1,610 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+// raftStatusInterval is how often Raft state is refreshed in Raft mode.
+const raftStatusInterval = 30 * time.Second
+
+// ovsdbComponent describes one of the two OVN databases.
+type ovsdbComponent struct {
+	name       string // "nb" or "sb"
+	dbName     string
+	dbFile     string
+	schema     string
+	clientPort int32
+	raftPort   int32
+	image      string
+	condition  openstackv1alpha1.ConditionType
+}
+
+var (
+	ovsdbNorthbound = ovsdbComponent{
+		name:       "nb",
+		dbName:     "OVN_Northbound",
+		dbFile:     "ovnnb_db.db",
+		schema:     "/usr/share/ovn/ovn-nb.ovsschema",
+		clientPort: 6641,
+		raftPort:   6643,
+		image:      images.DefaultOVNNBDB,
+		condition:  openstackv1alpha1.ConditionNorthboundDBReady,
+	}
+	ovsdbSouthbound = ovsdbComponent{
+		name:       "sb",
+		dbName:     "OVN_Southbound",
+		dbFile:     "ovnsb_db.db",
+		schema:     "/usr/share/ovn/ovn-sb.ovsschema",
+		clientPort: 6642,
+		raftPort:   6644,
+		image:      images.DefaultOVNSBDB,
+		condition:  openstackv1alpha1.ConditionSouthboundDBReady,
+	}
+)
+
+// OVNNetworkReconciler reconciles an OVNNetwork object.
+type OVNNetworkReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Executor runs ovs-appctl in database pods for Raft status, member
+	// removal and compaction.
+	Executor *common.PodExecutor
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=ovnnetworks,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=ovnnetworks/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=ovnnetworks/finalizers,verbs=update
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=novas;neutrons,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets;deployments;daemonsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=pods;nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups=batch,resources=jobs;cronjobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+
+func (r *OVNNetworkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.OVNNetwork{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "Reconciling", "Reconciliation in progress", instance.Generation,
+	)
+
+	// The CRD enforces these rules too; re-check here so that objects created
+	// before the schema was tightened do not produce a split-brain database.
+	if err := validateOVNNetwork(instance); err != nil {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "InvalidSpec", err.Error(), instance.Generation,
+		)
+		return ctrl.Result{}, r.updateStatus(ctx, instance)
+	}
+
+	if instance.Spec.SharedWithCNI {
+		return r.reconcileShared(ctx, instance)
+	}
+
+	proto := "tcp"
+	if instance.Spec.TLS.Enabled {
+		proto = "ssl"
+		if err := r.ensureCertificate(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		instance.Status.TLSSecretName = ovnTLSSecretName(instance)
+	} else {
+		instance.Status.TLSSecretName = ""
+	}
+
+	if err := r.ensureScripts(ctx, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Seed new clusters from snapshots before any database pod starts.
+	if instance.Spec.Maintenance.Restore != nil {
+		skipped := false
+		for _, component := range []ovsdbComponent{ovsdbNorthbound, ovsdbSouthbound} {
+			state, err := r.ensureRestore(ctx, instance, component, proto)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			switch state {
+			case restorePending:
+				return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDBRestored), "Restoring",
+					fmt.Sprintf("Waiting for %s restore", component.dbName))
+			case restoreSkipped:
+				skipped = true
+			}
+		}
+		if skipped {
+			instance.Status.Conditions = common.SetCondition(
+				instance.Status.Conditions, string(openstackv1alpha1.ConditionDBRestored), metav1.ConditionFalse, "ClusterExists",
+				"Snapshots are only restored into a new cluster", instance.Generation,
+			)
+		} else {
+			instance.Status.Conditions = common.SetCondition(
+				instance.Status.Conditions, string(openstackv1alpha1.ConditionDBRestored), metav1.ConditionTrue, "Restored",
+				"Databases restored from snapshots", instance.Generation,
+			)
+		}
+	}
+
+	endpoints := map[string]string{}
+	allReady := true
+	for _, component := range []ovsdbComponent{ovsdbNorthbound, ovsdbSouthbound} {
+		replicas := ovsdbReplicas(instance, component)
+		if err := r.ensureDBService(ctx, instance, component); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.ensureDBStatefulSet(ctx, instance, component, replicas, proto); err != nil {
+			return ctrl.Result{}, err
+		}
+		endpoints[component.name] = ovsdbEndpoints(instance, component, replicas, proto)
+
+		sts := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, client.ObjectKey{Name: ovsdbName(instance, component), Namespace: instance.Namespace}, sts); err != nil {
+			return ctrl.Result{}, err
+		}
+		if common.IsStatefulSetReady(sts) {
+			instance.Status.Conditions = common.SetCondition(
+				instance.Status.Conditions, string(component.condition), metav1.ConditionTrue, "StatefulSetReady",
+				fmt.Sprintf("%d/%d %s replicas ready", sts.Status.ReadyReplicas, replicas, component.dbName), instance.Generation,
+			)
+		} else {
+			allReady = false
+			instance.Status.Conditions = common.SetCondition(
+				instance.Status.Conditions, string(component.condition), metav1.ConditionFalse, "StatefulSetNotReady",
+				fmt.Sprintf("%d/%d %s replicas ready", sts.Status.ReadyReplicas, replicas, component.dbName), instance.Generation,
+			)
+		}
+
+		var raftStatus *openstackv1alpha1.OVNRaftStatus
+		if instance.Spec.EnableRaftClustering {
+			status, members, err := r.collectRaftStatus(ctx, instance, component)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.remediateRaftMembers(ctx, instance, component, replicas, members); err != nil {
+				return ctrl.Result{}, err
+			}
+			raftStatus = status
+		}
+		if component.name == ovsdbNorthbound.name {
+			instance.Status.NorthboundRaft = raftStatus
+		} else {
+			instance.Status.SouthboundRaft = raftStatus
+		}
+	}
+
+	// Clients get every member so they can follow the Raft leader.
+	instance.Status.NorthboundDBEndpoint = endpoints[ovsdbNorthbound.name]
+	instance.Status.SouthboundDBEndpoint = endpoints[ovsdbSouthbound.name]
+
+	if err := r.ensureNorthd(ctx, instance, proto); err != nil {
+		return ctrl.Result{}, err
+	}
+	northd := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-northd", Namespace: instance.Namespace}, northd); err != nil {
+		return ctrl.Result{}, err
+	}
+	if common.IsDeploymentReady(northd) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionNorthdReady), metav1.ConditionTrue, "DeploymentReady", "ovn-northd is available", instance.Generation,
+		)
+	} else {
+		allReady = false
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionNorthdReady), metav1.ConditionFalse, "DeploymentNotReady", "Waiting for ovn-northd", instance.Generation,
+		)
+	}
+
+	if err := r.ensureBackup(ctx, instance, proto); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	chassisManaged := false
+	if allReady {
+		var err error
+		if chassisManaged, err = r.reconcileChassis(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	var result ctrl.Result
+	if allReady {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "OVN databases and northd are ready", instance.Generation,
+		)
+		nextCompaction, err := r.compactDatabases(ctx, instance)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		result.RequeueAfter = nextCompaction
+	}
+	// Raft roles, terms and chassis registration change without any watched
+	// object changing, so poll them.
+	if (instance.Spec.EnableRaftClustering || chassisManaged) && (result.RequeueAfter == 0 || result.RequeueAfter > raftStatusInterval) {
+		result.RequeueAfter = raftStatusInterval
+	}
+
+	if err := r.updateStatus(ctx, instance); err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return result, nil
+}
+
+// waitFor records a not-yet-satisfied condition and requeues.
+func (r *OVNNetworkReconciler) waitFor(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, condType, reason, message string) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(instance.Status.Conditions, condType, metav1.ConditionFalse, reason, message, instance.Generation)
+	if condType != string(openstackv1alpha1.ConditionReady) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+func (r *OVNNetworkReconciler) updateStatus(ctx context.Context, instance *openstackv1alpha1.OVNNetwork) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	return r.Status().Update(ctx, instance)
+}
+
+// validateOVNNetwork enforces the replica rules for standalone and Raft modes.
+func validateOVNNetwork(instance *openstackv1alpha1.OVNNetwork) error {
+	nb := ovsdbReplicas(instance, ovsdbNorthbound)
+	sb := ovsdbReplicas(instance, ovsdbSouthbound)
+	if instance.Spec.EnableRaftClustering {
+		if nb < 3 || sb < 3 {
+			return fmt.Errorf("enableRaftClustering requires at least 3 northbound and southbound DB replicas (have %d/%d)", nb, sb)
+		}
+	} else if nb != 1 || sb != 1 {
+		return fmt.Errorf("more than one DB replica requires enableRaftClustering (have %d/%d)", nb, sb)
+	}
+	if instance.Spec.TLS.Enabled && instance.Spec.TLS.IssuerRef == nil {
+		return fmt.Errorf("tls.issuerRef is required when tls.enabled is true")
+	}
+	return nil
+}
+
+func (r *OVNNetworkReconciler) ensureCertificate(ctx context.Context, instance *openstackv1alpha1.OVNNetwork) error {
+	var dnsNames []string
+	for _, component := range []ovsdbComponent{ovsdbNorthbound, ovsdbSouthbound} {
+		svc := ovsdbName(instance, component)
+		dnsNames = append(dnsNames,
+			svc,
+			fmt.Sprintf("%s.%s.svc", svc, instance.Namespace),
+			fmt.Sprintf("*.%s.%s.svc", svc, instance.Namespace),
+		)
+	}
+	return common.EnsureCertificate(ctx, r.Client, common.CertificateParams{
+		Name:       instance.Name + "-ovndb",
+		Namespace:  instance.Namespace,
+		SecretName: ovnTLSSecretName(instance),
+		CommonName: instance.Name + "-ovndb",
+		DNSNames:   dnsNames,
+		IssuerRef:  *instance.Spec.TLS.IssuerRef,
+	}, instance)
+}
+
+func (r *OVNNetworkReconciler) ensureScripts(ctx context.Context, instance *openstackv1alpha1.OVNNetwork) error {
+	files := map[string]string{}
+	for _, name := range []string{
+		"ovsdb-server.sh", "ovsdb-ready.sh", "ovndb-backup.sh", "ovndb-restore.sh",
+		"ovs-db-server.sh", "ovs-vswitchd.sh", "ovn-controller.sh", "chassis-ready.sh",
+	} {
+		rendered, err := common.RenderTemplate("ovn/"+name, nil)
+		if err != nil {
+			return err
+		}
+		files[name] = rendered
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-ovsdb-scripts", Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		cm.Labels = labelsForOVN(instance.Name, "scripts")
+		cm.Data = files
+		return controllerutil.SetControllerReference(instance, cm, r.Scheme)
+	})
+	return err
+}
+
+// ensureDBService creates the headless Service that gives each member a stable
+// DNS name. Not-ready addresses are published so members can find each other
+// while the cluster is still forming.
+func (r *OVNNetworkReconciler) ensureDBService(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, component ovsdbComponent) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: ovsdbName(instance, component), Namespace: instance.Namespace},
+	}
+	labels := labelsForOVN(instance.Name, component.name+"-db")
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labels
+		svc.Spec.ClusterIP = corev1.ClusterIPNone
+		svc.Spec.PublishNotReadyAddresses = true
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: "ovsdb", Port: component.clientPort, TargetPort: intstr.FromInt32(component.clientPort), Protocol: corev1.ProtocolTCP},
+			{Name: "raft", Port: component.raftPort, TargetPort: intstr.FromInt32(component.raftPort), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+func (r *OVNNetworkReconciler) ensureDBStatefulSet(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, component ovsdbComponent, replicas int32, proto string) error {
+	name := ovsdbName(instance, component)
+	labels := labelsForOVN(instance.Name, component.name+"-db")
+
+	storageSize := instance.Spec.DBStorage.Size
+	if storageSize.IsZero() {
+		storageSize = resource.MustParse("10Gi")
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "DB_NAME", Value: component.dbName},
+		{Name: "DB_FILE_NAME", Value: component.dbFile},
+		{Name: "SCHEMA", Value: component.schema},
+		{Name: "CLIENT_PORT", Value: fmt.Sprint(component.clientPort)},
+		{Name: "RAFT_PORT", Value: fmt.Sprint(component.raftPort)},
+		{Name: "RAFT", Value: fmt.Sprint(instance.Spec.EnableRaftClustering)},
+		{Name: "REPLICAS", Value: fmt.Sprint(replicas)},
+		{Name: "SERVICE", Value: name},
+		{Name: "NAMESPACE", Value: instance.Namespace},
+		{Name: "PROTO", Value: proto},
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{Name: "data", MountPath: "/var/lib/ovn"},
+		{Name: "scripts", MountPath: "/scripts", ReadOnly: true},
+	}
+	volumes := []corev1.Volume{ovsdbScriptsVolume(instance)}
+	if proto == "ssl" {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: "tls", MountPath: "/etc/ovn/tls", ReadOnly: true})
+		volumes = append(volumes, ovnTLSVolume(instance))
+	}
+
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, sts, func() error {
+		sts.Labels = labels
+		sts.Spec.Replicas = &replicas
+		if sts.CreationTimestamp.IsZero() {
+			sts.Spec.ServiceName = name
+			sts.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+			// Raft members must all start together: with OrderedReady a full
+			// restart would deadlock, since member 0 cannot become ready
+			// without a quorum of its peers.
+			sts.Spec.PodManagementPolicy = appsv1.ParallelPodManagement
+			sts.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "data"},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						Resources: corev1.VolumeResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceStorage: storageSize},
+						},
+						StorageClassName: instance.Spec.DBStorage.StorageClassName,
+					},
+				},
+			}
+		}
+		sts.Spec.Template.Labels = labels
+		sts.Spec.Template.Spec.Affinity = spreadAffinity(labels)
+		sts.Spec.Template.Spec.Volumes = volumes
+		sts.Spec.Template.Spec.Containers = []corev1.Container{
+			{
+				Name:         component.name + "-ovsdb-server",
+				Image:        component.image,
+				Command:      []string{"/bin/sh", "/scripts/ovsdb-server.sh"},
+				Env:          env,
+				VolumeMounts: volumeMounts,
+				Ports: []corev1.ContainerPort{
+					{ContainerPort: component.clientPort, Name: "ovsdb"},
+					{ContainerPort: component.raftPort, Name: "raft"},
+				},
+				ReadinessProbe: &corev1.Probe{
+					ProbeHandler: corev1.ProbeHandler{
+						Exec: &corev1.ExecAction{Command: []string{"/bin/sh", "/scripts/ovsdb-ready.sh"}},
+					},
+					InitialDelaySeconds: 5,
+					PeriodSeconds:       10,
+				},
+			},
+		}
+		return controllerutil.SetControllerReference(instance, sts, r.Scheme)
+	})
+	return err
+}
+
+func (r *OVNNetworkReconciler) ensureNorthd(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, proto string) error {
+	replicas := int32(1)
+	if instance.Spec.NorthdReplicas != nil {
+		replicas = *instance.Spec.NorthdReplicas
+	}
+	labels := labelsForOVN(instance.Name, "northd")
+
+	// Multiple northd replicas are safe: they coordinate through an SB lock
+	// and only the holder is active.
+	command := []string{
+		"ovn-northd",
+		"--ovnnb-db=" + instance.Status.NorthboundDBEndpoint,
+		"--ovnsb-db=" + instance.Status.SouthboundDBEndpoint,
+		"-vconsole:info", "-vfile:off",
+	}
+	var volumeMounts []corev1.VolumeMount
+	var volumes []corev1.Volume
+	if proto == "ssl" {
+		command = append(command,
+			"--private-key=/etc/ovn/tls/tls.key",
+			"--certificate=/etc/ovn/tls/tls.crt",
+			"--ca-cert=/etc/ovn/tls/ca.crt",
+		)
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: "tls", MountPath: "/etc/ovn/tls", ReadOnly: true})
+		volumes = append(volumes, ovnTLSVolume(instance))
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-northd", Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Spec.Affinity = spreadAffinity(labels)
+		deploy.Spec.Template.Spec.Volumes = volumes
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{
+			{
+				Name:         "ovn-northd",
+				Image:        images.DefaultOVNNorthd,
+				Command:      command,
+				VolumeMounts: volumeMounts,
+			},
+		}
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	})
+	return err
+}
+
+func (r *OVNNetworkReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.OVNNetwork{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&batchv1.Job{}).
+		Owns(&batchv1.CronJob{}).
+		Owns(&appsv1.DaemonSet{}).
+		Owns(&corev1.Secret{}).
+		Watches(&openstackv1alpha1.Nova{}, handler.EnqueueRequestsFromMapFunc(r.ovnNetworksForObject)).
+		Watches(&openstackv1alpha1.Neutron{}, handler.EnqueueRequestsFromMapFunc(r.ovnNetworksForObject)).
+		Complete(r)
+}
+
+// ovsdbEndpoints returns the client connection string for a database: every
+// member in Raft mode, the Service name otherwise.
+func ovsdbEndpoints(instance *openstackv1alpha1.OVNNetwork, component ovsdbComponent, replicas int32, proto string) string {
+	svc := ovsdbName(instance, component)
+	if !instance.Spec.EnableRaftClustering {
+		return fmt.Sprintf("%s:%s.%s.svc:%d", proto, svc, instance.Namespace, component.clientPort)
+	}
+	members := make([]string, 0, replicas)
+	for i := int32(0); i < replicas; i++ {
+		members = append(members, fmt.Sprintf("%s:%s-%d.%s.%s.svc:%d", proto, svc, i, svc, instance.Namespace, component.clientPort))
+	}
+	return strings.Join(members, ",")
+}
+
+func ovsdbReplicas(instance *openstackv1alpha1.OVNNetwork, component ovsdbComponent) int32 {
+	replicas := instance.Spec.NorthboundDBReplicas
+	if component.name == ovsdbSouthbound.name {
+		replicas = instance.Spec.SouthboundDBReplicas
+	}
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+func ovsdbName(instance *openstackv1alpha1.OVNNetwork, component ovsdbComponent) string {
+	return fmt.Sprintf("%s-%s-db", instance.Name, component.name)
+}
+
+func ovnTLSSecretName(instance *openstackv1alpha1.OVNNetwork) string {
+	return instance.Name + "-ovndb-tls"
+}
+
+func ovnTLSVolume(instance *openstackv1alpha1.OVNNetwork) corev1.Volume {
+	return corev1.Volume{
+		Name: "tls",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: ovnTLSSecretName(instance)},
+		},
+	}
+}
+
+func ovsdbScriptsVolume(instance *openstackv1alpha1.OVNNetwork) corev1.Volume {
+	mode := int32(0755)
+	return corev1.Volume{
+		Name: "scripts",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: instance.Name + "-ovsdb-scripts"},
+				DefaultMode:          &mode,
+			},
+		},
+	}
+}
+
+// spreadAffinity prefers scheduling replicas with the given labels on different nodes.
+func spreadAffinity(labels map[string]string) *corev1.Affinity {
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+						TopologyKey:   "kubernetes.io/hostname",
+					},
+				},
+			},
+		},
+	}
+}
+
+func labelsForOVN(name, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "ovn",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/component":  component,
+		"app.kubernetes.io/managed-by": "openstack-operator",
+	}
+}
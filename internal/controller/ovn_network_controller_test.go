@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func newOVNNetwork(replicas int32, raft bool) *openstackv1alpha1.OVNNetwork {
+	return &openstackv1alpha1.OVNNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "ovn", Namespace: "openstack"},
+		Spec: openstackv1alpha1.OVNNetworkSpec{
+			NorthboundDBReplicas: &replicas,
+			SouthboundDBReplicas: &replicas,
+			EnableRaftClustering: raft,
+		},
+	}
+}
+
+func TestValidateOVNNetwork(t *testing.T) {
+	tlsWithoutIssuer := newOVNNetwork(1, false)
+	tlsWithoutIssuer.Spec.TLS.Enabled = true
+	mixed := newOVNNetwork(3, true)
+	one := int32(1)
+	mixed.Spec.SouthboundDBReplicas = &one
+
+	tests := []struct {
+		name     string
+		instance *openstackv1alpha1.OVNNetwork
+		wantErr  string
+	}{
+		{name: "standalone", instance: newOVNNetwork(1, false)},
+		{name: "defaults", instance: &openstackv1alpha1.OVNNetwork{}},
+		{name: "raft", instance: newOVNNetwork(3, true)},
+		{name: "standalone with replicas", instance: newOVNNetwork(3, false), wantErr: "requires enableRaftClustering"},
+		{name: "raft without quorum", instance: newOVNNetwork(1, true), wantErr: "at least 3"},
+		{name: "raft with a standalone southbound", instance: mixed, wantErr: "(have 3/1)"},
+		{name: "tls without issuer", instance: tlsWithoutIssuer, wantErr: "tls.issuerRef is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOVNNetwork(tt.instance)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOVSDBEndpoints(t *testing.T) {
+	if got := ovsdbEndpoints(newOVNNetwork(1, false), ovsdbNorthbound, 1, "tcp"); got != "tcp:ovn-nb-db.openstack.svc:6641" {
+		t.Errorf("standalone = %q", got)
+	}
+	want := "ssl:ovn-sb-db-0.ovn-sb-db.openstack.svc:6642," +
+		"ssl:ovn-sb-db-1.ovn-sb-db.openstack.svc:6642," +
+		"ssl:ovn-sb-db-2.ovn-sb-db.openstack.svc:6642"
+	if got := ovsdbEndpoints(newOVNNetwork(3, true), ovsdbSouthbound, 3, "ssl"); got != want {
+		t.Errorf("raft = %q, want %q", got, want)
+	}
+}
+
+func reconcileOVNNetwork(t *testing.T, r *OVNNetworkReconciler) {
+	t.Helper()
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "ovn", Namespace: "openstack"}}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOVNNetworkReconcileInvalidSpec(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t, newOVNNetwork(3, false))
+	r := &OVNNetworkReconciler{Client: c, Scheme: testScheme}
+
+	reconcileOVNNetwork(t, r)
+
+	instance := &openstackv1alpha1.OVNNetwork{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "ovn", Namespace: "openstack"}, instance); err != nil {
+		t.Fatal(err)
+	}
+	cond := meta.FindStatusCondition(instance.Status.Conditions, string(openstackv1alpha1.ConditionReady))
+	if cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "InvalidSpec" {
+		t.Fatalf("Ready = %+v, want InvalidSpec", cond)
+	}
+	// Nothing is deployed that could form a split-brain database.
+	sts := &appsv1.StatefulSet{}
+	err := c.Get(ctx, client.ObjectKey{Name: "ovn-nb-db", Namespace: "openstack"}, sts)
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("StatefulSet get = %v, want not found", err)
+	}
+}
+
+func TestOVNNetworkReconcile(t *testing.T) {
+	ctx := context.Background()
+	backup := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "ovn-ovndb-backup", Namespace: "openstack"}}
+	c := newFakeClient(t, newOVNNetwork(1, false), backup)
+	r := &OVNNetworkReconciler{Client: c, Scheme: testScheme}
+
+	reconcileOVNNetwork(t, r)
+
+	instance := &openstackv1alpha1.OVNNetwork{}
+	if err := c.Get(ctx, client.ObjectKey{Name: "ovn", Namespace: "openstack"}, instance); err != nil {
+		t.Fatal(err)
+	}
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		t.Error("finalizer not added")
+	}
+	if instance.Status.NorthboundDBEndpoint != "tcp:ovn-nb-db.openstack.svc:6641" ||
+		instance.Status.SouthboundDBEndpoint != "tcp:ovn-sb-db.openstack.svc:6642" {
+		t.Errorf("endpoints = %q, %q", instance.Status.NorthboundDBEndpoint, instance.Status.SouthboundDBEndpoint)
+	}
+	// Nothing runs in the fake client, so the databases never become ready.
+	for _, condType := range []openstackv1alpha1.ConditionType{
+		openstackv1alpha1.ConditionReady, openstackv1alpha1.ConditionNorthboundDBReady, openstackv1alpha1.ConditionNorthdReady,
+	} {
+		if meta.IsStatusConditionTrue(instance.Status.Conditions, string(condType)) {
+			t.Errorf("%s is true", condType)
+		}
+	}
+	// Backups are not configured, so a CronJob left from earlier is removed.
+	if err := c.Get(ctx, client.ObjectKeyFromObject(backup), &batchv1.CronJob{}); !apierrors.IsNotFound(err) {
+		t.Errorf("backup CronJob get = %v, want not found", err)
+	}
+
+	objects := func() map[string]string {
+		versions := map[string]string{}
+		for _, obj := range []client.Object{
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "ovn-nb-db"}},
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "ovn-sb-db"}},
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "ovn-nb-db"}},
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "ovn-sb-db"}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "ovn-northd"}},
+			&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "ovn-ovsdb-scripts"}},
+		} {
+			if err := c.Get(ctx, client.ObjectKey{Name: obj.GetName(), Namespace: "openstack"}, obj); err != nil {
+				t.Fatalf("%T %s: %v", obj, obj.GetName(), err)
+			}
+			versions[fmt.Sprintf("%T %s", obj, obj.GetName())] = obj.GetResourceVersion()
+		}
+		return versions
+	}
+	before := objects()
+
+	// A second pass over an unchanged spec rewrites nothing.
+	reconcileOVNNetwork(t, r)
+	for key, version := range objects() {
+		if before[key] != version {
+			t.Errorf("%s updated (resourceVersion %s -> %s)", key, before[key], version)
+		}
+	}
+}
+
+func TestOVNNetworkReconcileDeletion(t *testing.T) {
+	ctx := context.Background()
+	instance := newOVNNetwork(1, false)
+	instance.Finalizers = []string{common.FinalizerName}
+	c := newFakeClient(t, instance)
+	if err := c.Delete(ctx, instance); err != nil {
+		t.Fatal(err)
+	}
+	r := &OVNNetworkReconciler{Client: c, Scheme: testScheme}
+
+	reconcileOVNNetwork(t, r)
+
+	err := c.Get(ctx, client.ObjectKeyFromObject(instance), &openstackv1alpha1.OVNNetwork{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("get = %v, want the OVNNetwork gone", err)
+	}
+}
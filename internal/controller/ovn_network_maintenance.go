@@ -0,0 +1,520 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+// restoreState is the outcome of ensureRestore for one database.
+type restoreState int
+
+const (
+	restorePending restoreState = iota
+	restoreDone
+	// restoreSkipped means the StatefulSet already existed; snapshots are
+	// only ever restored into a new cluster.
+	restoreSkipped
+)
+
+// raftServerStatus is the parsed output of ovs-appctl cluster/status.
+type raftServerStatus struct {
+	serverID  string
+	clusterID string
+	address   string
+	status    string
+	role      string
+	leader    string
+	term      int64
+	// servers is the cluster configuration as seen by this member.
+	servers []raftPeer
+}
+
+type raftPeer struct {
+	id      string
+	address string
+}
+
+// raftPeerLine matches entries of the "Servers:" section, e.g.
+// "    a1b2 (a1b2 at tcp:ovn-nb-db-0.ovn-nb-db.openstack.svc:6643) (self)".
+var raftPeerLine = regexp.MustCompile(`^\s+([0-9a-f]+) \([0-9a-f]+ at (\S+)\)`)
+
+// raftID matches the short form of a server or cluster ID, printed as
+// "a1b2 (a1b2c3d4-...)". A cluster ID not known yet is printed as text.
+var raftID = regexp.MustCompile(`^([0-9a-f]+)(?: \(|$)`)
+
+// parseClusterStatus parses the output of cluster/status.
+func parseClusterStatus(out string) raftServerStatus {
+	var s raftServerStatus
+	inServers := false
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inServers {
+			if m := raftPeerLine.FindStringSubmatch(line); m != nil {
+				s.servers = append(s.servers, raftPeer{id: m[1], address: m[2]})
+				continue
+			}
+			inServers = false
+		}
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			if strings.TrimSpace(line) == "Servers:" {
+				inServers = true
+			}
+			continue
+		}
+		// Keep the short form of IDs, which is what the Servers section and
+		// cluster/kick use.
+		var short string
+		if m := raftID.FindStringSubmatch(value); m != nil {
+			short = m[1]
+		}
+		switch key {
+		case "Server ID":
+			s.serverID = short
+		case "Cluster ID":
+			s.clusterID = short
+		case "Address":
+			s.address = value
+		case "Status":
+			s.status = value
+		case "Role":
+			s.role = value
+		case "Leader":
+			s.leader = value
+		case "Term":
+			s.term, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return s
+}
+
+// raftAddressOrdinal returns the StatefulSet ordinal encoded in a member
+// address of the form proto:<sts>-<ordinal>.<sts>.<ns>.svc:port.
+func raftAddressOrdinal(address, sts string) (int, bool) {
+	_, hostPort, ok := strings.Cut(address, ":")
+	if !ok {
+		return 0, false
+	}
+	host, _, _ := strings.Cut(hostPort, ".")
+	suffix, ok := strings.CutPrefix(host, sts+"-")
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	return ordinal, err == nil
+}
+
+// ovsdbCtl returns the unixctl target of a database server.
+func ovsdbCtl(component ovsdbComponent) string {
+	return "/var/run/ovn/" + strings.TrimSuffix(component.dbFile, ".db") + ".ctl"
+}
+
+// collectRaftStatus queries cluster/status on every running member of a
+// database and returns the per-pod results keyed by ordinal.
+func (r *OVNNetworkReconciler) collectRaftStatus(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, component ovsdbComponent) (*openstackv1alpha1.OVNRaftStatus, map[int]raftServerStatus, error) {
+	logger := log.FromContext(ctx)
+	sts := ovsdbName(instance, component)
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(instance.Namespace), client.MatchingLabels(labelsForOVN(instance.Name, component.name+"-db"))); err != nil {
+		return nil, nil, err
+	}
+
+	status := &openstackv1alpha1.OVNRaftStatus{}
+	members := map[int]raftServerStatus{}
+	for _, pod := range pods.Items {
+		ordinal, err := strconv.Atoi(strings.TrimPrefix(pod.Name, sts+"-"))
+		if err != nil {
+			continue
+		}
+		member := openstackv1alpha1.OVNRaftMemberStatus{Pod: pod.Name}
+		if pod.Status.Phase != corev1.PodRunning || !pod.DeletionTimestamp.IsZero() {
+			member.Status = "not running"
+			status.Members = append(status.Members, member)
+			continue
+		}
+		out, err := r.Executor.Exec(ctx, instance.Namespace, pod.Name, component.name+"-ovsdb-server",
+			[]string{"ovs-appctl", "-t", ovsdbCtl(component), "cluster/status", component.dbName})
+		if err != nil {
+			logger.Info("unable to query Raft status", "pod", pod.Name, "error", err.Error())
+			member.Status = "unreachable"
+			status.Members = append(status.Members, member)
+			continue
+		}
+		parsed := parseClusterStatus(out)
+		members[ordinal] = parsed
+		member.ServerID = parsed.serverID
+		member.Role = parsed.role
+		member.Status = parsed.status
+		status.Members = append(status.Members, member)
+		if parsed.role == "leader" {
+			status.Leader = pod.Name
+			status.Term = parsed.term
+			status.ClusterID = parsed.clusterID
+		}
+	}
+	return status, members, nil
+}
+
+// remediateRaftMembers removes members that no longer belong in the cluster:
+// servers of scaled-down ordinals and stale server IDs left behind when a pod
+// lost its volume and rejoined under a new ID. At most one server is kicked
+// per call, and only while the remaining members keep a quorum. Once a stale
+// entry is kicked the joining pod's pending join completes on its own.
+func (r *OVNNetworkReconciler) remediateRaftMembers(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, component ovsdbComponent, replicas int32, members map[int]raftServerStatus) error {
+	logger := log.FromContext(ctx)
+	sts := ovsdbName(instance, component)
+
+	leaderOrdinal := -1
+	for ordinal, m := range members {
+		if m.role == "leader" {
+			leaderOrdinal = ordinal
+		}
+	}
+	if leaderOrdinal < 0 {
+		return nil
+	}
+	leader := members[leaderOrdinal]
+
+	// healthy reports whether the configured server is the live member at its address.
+	healthy := func(peer raftPeer) bool {
+		ordinal, ok := raftAddressOrdinal(peer.address, sts)
+		if !ok || ordinal >= int(replicas) {
+			return false
+		}
+		m, ok := members[ordinal]
+		return ok && m.serverID == peer.id && m.status == "cluster member"
+	}
+
+	inConfig := map[int]bool{}
+	for _, peer := range leader.servers {
+		ordinal, ok := raftAddressOrdinal(peer.address, sts)
+		if !ok {
+			continue
+		}
+		inConfig[ordinal] = true
+
+		reason := ""
+		if ordinal >= int(replicas) {
+			reason = "scaled down"
+		} else if m, ok := members[ordinal]; ok && m.serverID != "" && m.serverID != peer.id {
+			reason = fmt.Sprintf("replaced by server %s", m.serverID)
+		}
+		if reason == "" {
+			continue
+		}
+
+		remaining := 0
+		for _, other := range leader.servers {
+			if other.id != peer.id && healthy(other) {
+				remaining++
+			}
+		}
+		if remaining <= (len(leader.servers)-1)/2 {
+			logger.Info("not kicking Raft member without quorum", "db", component.dbName, "server", peer.id, "healthy", remaining)
+			return nil
+		}
+
+		logger.Info("kicking Raft member", "db", component.dbName, "server", peer.id, "address", peer.address, "reason", reason)
+		if _, err := r.Executor.Exec(ctx, instance.Namespace, fmt.Sprintf("%s-%d", sts, leaderOrdinal), component.name+"-ovsdb-server",
+			[]string{"ovs-appctl", "-t", ovsdbCtl(component), "cluster/kick", component.dbName, peer.id}); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// Volumes of scaled-down members hold a kicked server's state; remove them
+	// so a later scale-up starts from an empty volume and joins cleanly.
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, pvcs, client.InNamespace(instance.Namespace)); err != nil {
+		return err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		suffix, ok := strings.CutPrefix(pvc.Name, "data-"+sts+"-")
+		if !ok {
+			continue
+		}
+		ordinal, err := strconv.Atoi(suffix)
+		if err != nil || ordinal < int(replicas) || inConfig[ordinal] {
+			continue
+		}
+		if err := r.Get(ctx, client.ObjectKey{Name: fmt.Sprintf("%s-%d", sts, ordinal), Namespace: instance.Namespace}, &corev1.Pod{}); err == nil {
+			continue
+		} else if !errors.IsNotFound(err) {
+			return err
+		}
+		logger.Info("deleting volume of removed Raft member", "pvc", pvc.Name)
+		if err := r.Delete(ctx, pvc); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactDatabases runs online compaction on every running member of both
+// databases when the configured interval has elapsed. It returns the time
+// until the next compaction is due.
+func (r *OVNNetworkReconciler) compactDatabases(ctx context.Context, instance *openstackv1alpha1.OVNNetwork) (time.Duration, error) {
+	interval := instance.Spec.Maintenance.CompactionInterval
+	if interval == nil || interval.Duration <= 0 {
+		return 0, nil
+	}
+	if last := instance.Status.LastCompactionTime; last != nil {
+		if remaining := time.Until(last.Add(interval.Duration)); remaining > 0 {
+			return remaining, nil
+		}
+	}
+
+	for _, component := range []ovsdbComponent{ovsdbNorthbound, ovsdbSouthbound} {
+		pods := &corev1.PodList{}
+		if err := r.List(ctx, pods, client.InNamespace(instance.Namespace), client.MatchingLabels(labelsForOVN(instance.Name, component.name+"-db"))); err != nil {
+			return 0, err
+		}
+		for _, pod := range pods.Items {
+			if pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+			if _, err := r.Executor.Exec(ctx, instance.Namespace, pod.Name, component.name+"-ovsdb-server",
+				[]string{"ovs-appctl", "-t", ovsdbCtl(component), "ovsdb-server/compact", component.dbName}); err != nil {
+				return 0, err
+			}
+		}
+	}
+	now := metav1.Now()
+	instance.Status.LastCompactionTime = &now
+	return interval.Duration, nil
+}
+
+// ensureBackup maintains the backup PVC and CronJob, or removes the CronJob
+// when backups are disabled. The PVC is never deleted by the operator.
+func (r *OVNNetworkReconciler) ensureBackup(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, proto string) error {
+	name := instance.Name + "-ovndb-backup"
+	backup := instance.Spec.Maintenance.Backup
+	if backup == nil {
+		cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
+		if err := r.Delete(ctx, cronJob); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		return nil
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: instance.Namespace}, pvc); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		size := backup.Storage.Size
+		if size.IsZero() {
+			size = resource.MustParse("10Gi")
+		}
+		pvc = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: instance.Namespace,
+				Labels:    labelsForOVN(instance.Name, "backup"),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+				},
+				StorageClassName: backup.Storage.StorageClassName,
+			},
+		}
+		if err := r.Create(ctx, pvc); err != nil {
+			return err
+		}
+	}
+
+	retention := backup.Retention
+	if retention < 1 {
+		retention = 7
+	}
+	volumes := []corev1.Volume{
+		ovsdbScriptsVolume(instance),
+		{
+			Name: "backup",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: name},
+			},
+		},
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{Name: "scripts", MountPath: "/scripts", ReadOnly: true},
+		{Name: "backup", MountPath: "/backup"},
+	}
+	if proto == "ssl" {
+		volumes = append(volumes, ovnTLSVolume(instance))
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: "tls", MountPath: "/etc/ovn/tls", ReadOnly: true})
+	}
+
+	cronJob := &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace}}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, cronJob, func() error {
+		cronJob.Labels = labelsForOVN(instance.Name, "backup")
+		cronJob.Spec.Schedule = backup.Schedule
+		cronJob.Spec.ConcurrencyPolicy = batchv1.ForbidConcurrent
+		cronJob.Spec.JobTemplate.Spec.Template.Labels = labelsForOVN(instance.Name, "backup")
+		cronJob.Spec.JobTemplate.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+		cronJob.Spec.JobTemplate.Spec.Template.Spec.Volumes = volumes
+		cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers = []corev1.Container{
+			{
+				Name:    "ovndb-backup",
+				Image:   images.DefaultOVNNBDB,
+				Command: []string{"/bin/sh", "/scripts/ovndb-backup.sh"},
+				Env: []corev1.EnvVar{
+					{Name: "NB_REMOTE", Value: instance.Status.NorthboundDBEndpoint},
+					{Name: "SB_REMOTE", Value: instance.Status.SouthboundDBEndpoint},
+					{Name: "PROTO", Value: proto},
+					{Name: "RETENTION", Value: fmt.Sprint(retention)},
+				},
+				VolumeMounts: volumeMounts,
+			},
+		}
+		return controllerutil.SetControllerReference(instance, cronJob, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+	instance.Status.LastBackupTime = cronJob.Status.LastSuccessfulTime
+	return nil
+}
+
+// ensureRestore seeds member 0's volume of a database from a snapshot before
+// the StatefulSet exists. The volume is pre-created under the name the
+// StatefulSet's claim template will use, so the first pod adopts it.
+func (r *OVNNetworkReconciler) ensureRestore(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, component ovsdbComponent, proto string) (restoreState, error) {
+	restore := instance.Spec.Maintenance.Restore
+	sts := ovsdbName(instance, component)
+	jobName := sts + "-restore"
+
+	job := &batchv1.Job{}
+	jobErr := r.Get(ctx, client.ObjectKey{Name: jobName, Namespace: instance.Namespace}, job)
+	if jobErr != nil && !errors.IsNotFound(jobErr) {
+		return restorePending, jobErr
+	}
+	if err := r.Get(ctx, client.ObjectKey{Name: sts, Namespace: instance.Namespace}, &appsv1.StatefulSet{}); err == nil {
+		if jobErr == nil {
+			return restoreDone, nil
+		}
+		return restoreSkipped, nil
+	} else if !errors.IsNotFound(err) {
+		return restorePending, err
+	}
+
+	claimName := "data-" + sts + "-0"
+	if err := r.Get(ctx, client.ObjectKey{Name: claimName, Namespace: instance.Namespace}, &corev1.PersistentVolumeClaim{}); err != nil {
+		if !errors.IsNotFound(err) {
+			return restorePending, err
+		}
+		size := instance.Spec.DBStorage.Size
+		if size.IsZero() {
+			size = resource.MustParse("10Gi")
+		}
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      claimName,
+				Namespace: instance.Namespace,
+				Labels:    labelsForOVN(instance.Name, component.name+"-db"),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+				},
+				StorageClassName: instance.Spec.DBStorage.StorageClassName,
+			},
+		}
+		if err := r.Create(ctx, pvc); err != nil {
+			return restorePending, err
+		}
+	}
+
+	if errors.IsNotFound(jobErr) {
+		backupFile := restore.NorthboundFile
+		if component.name == ovsdbSouthbound.name {
+			backupFile = restore.SouthboundFile
+		}
+		backoffLimit := int32(4)
+		job = &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      jobName,
+				Namespace: instance.Namespace,
+				Labels:    labelsForOVN(instance.Name, "restore"),
+			},
+			Spec: batchv1.JobSpec{
+				BackoffLimit: &backoffLimit,
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						RestartPolicy: corev1.RestartPolicyOnFailure,
+						Volumes: []corev1.Volume{
+							ovsdbScriptsVolume(instance),
+							{
+								Name: "data",
+								VolumeSource: corev1.VolumeSource{
+									PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+								},
+							},
+							{
+								Name: "backup",
+								VolumeSource: corev1.VolumeSource{
+									PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: restore.ClaimName, ReadOnly: true},
+								},
+							},
+						},
+						Containers: []corev1.Container{
+							{
+								Name:    "ovndb-restore",
+								Image:   component.image,
+								Command: []string{"/bin/sh", "/scripts/ovndb-restore.sh"},
+								Env: []corev1.EnvVar{
+									{Name: "DB_FILE_NAME", Value: component.dbFile},
+									{Name: "BACKUP_FILE", Value: backupFile},
+									{Name: "RAFT", Value: fmt.Sprint(instance.Spec.EnableRaftClustering)},
+									{Name: "LOCAL", Value: fmt.Sprintf("%s:%s-0.%s.%s.svc:%d", proto, sts, sts, instance.Namespace, component.raftPort)},
+								},
+								VolumeMounts: []corev1.VolumeMount{
+									{Name: "scripts", MountPath: "/scripts", ReadOnly: true},
+									{Name: "data", MountPath: "/var/lib/ovn"},
+									{Name: "backup", MountPath: "/backup", ReadOnly: true},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		if err := controllerutil.SetControllerReference(instance, job, r.Scheme); err != nil {
+			return restorePending, err
+		}
+		if err := r.Create(ctx, job); err != nil {
+			return restorePending, err
+		}
+		return restorePending, nil
+	}
+
+	if done, err := common.IsJobComplete(ctx, r.Client, jobName, instance.Namespace); err != nil || !done {
+		return restorePending, err
+	}
+	return restoreDone, nil
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+const clusterStatusLeader = `a1b2
+Name: OVN_Northbound
+Cluster ID: 9c4e (9c4e1d2a-5b6c-4d7e-8f90-a1b2c3d4e5f6)
+Server ID: a1b2 (a1b2c3d4-0000-4000-8000-000000000001)
+Address: tcp:ovn-nb-db-0.ovn-nb-db.openstack.svc:6643
+Status: cluster member
+Role: leader
+Term: 42
+Leader: self
+Vote: self
+
+Last Election started 5082 ms ago, reason: timeout
+Last Election won: 5081 ms ago
+Election timer: 1000
+Log: [2, 120]
+Entries not yet committed: 0
+Entries not yet applied: 0
+Connections: ->c3d4 ->e5f6 <-c3d4 <-e5f6
+Disconnections: 0
+Servers:
+    a1b2 (a1b2 at tcp:ovn-nb-db-0.ovn-nb-db.openstack.svc:6643) (self) next_index=2 match_index=119
+    c3d4 (c3d4 at tcp:ovn-nb-db-1.ovn-nb-db.openstack.svc:6643) next_index=120 match_index=119 last msg 102 ms ago
+    e5f6 (e5f6 at tcp:ovn-nb-db-2.ovn-nb-db.openstack.svc:6643) next_index=120 match_index=119 last msg 102 ms ago
+`
+
+const clusterStatusJoining = `0f0f
+Name: OVN_Southbound
+Cluster ID: not yet known
+Server ID: 0f0f (0f0f0f0f-0000-4000-8000-000000000002)
+Address: ssl:ovn-sb-db-3.ovn-sb-db.openstack.svc:6644
+Status: joining cluster
+Remotes for joining: ssl:ovn-sb-db-0.ovn-sb-db.openstack.svc:6644
+Role: follower
+Term: 0
+Leader: unknown
+Vote: unknown
+`
+
+func TestParseClusterStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want raftServerStatus
+	}{
+		{
+			name: "leader with peers",
+			out:  clusterStatusLeader,
+			want: raftServerStatus{
+				serverID:  "a1b2",
+				clusterID: "9c4e",
+				address:   "tcp:ovn-nb-db-0.ovn-nb-db.openstack.svc:6643",
+				status:    "cluster member",
+				role:      "leader",
+				leader:    "self",
+				term:      42,
+				servers: []raftPeer{
+					{id: "a1b2", address: "tcp:ovn-nb-db-0.ovn-nb-db.openstack.svc:6643"},
+					{id: "c3d4", address: "tcp:ovn-nb-db-1.ovn-nb-db.openstack.svc:6643"},
+					{id: "e5f6", address: "tcp:ovn-nb-db-2.ovn-nb-db.openstack.svc:6643"},
+				},
+			},
+		},
+		{
+			name: "joining member without servers",
+			out:  clusterStatusJoining,
+			want: raftServerStatus{
+				serverID: "0f0f",
+				address:  "ssl:ovn-sb-db-3.ovn-sb-db.openstack.svc:6644",
+				status:   "joining cluster",
+				role:     "follower",
+				leader:   "unknown",
+			},
+		},
+		{
+			name: "empty",
+			out:  "",
+			want: raftServerStatus{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseClusterStatus(tt.out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRaftAddressOrdinal(t *testing.T) {
+	tests := []struct {
+		address string
+		ordinal int
+		ok      bool
+	}{
+		{"tcp:ovn-nb-db-2.ovn-nb-db.openstack.svc:6643", 2, true},
+		{"ssl:ovn-nb-db-10.ovn-nb-db.openstack.svc:6643", 10, true},
+		{"tcp:ovn-sb-db-0.ovn-sb-db.openstack.svc:6644", 0, false},
+		{"tcp:ovn-nb-db-x.ovn-nb-db.openstack.svc:6643", 0, false},
+		{"ovn-nb-db-0", 0, false},
+	}
+	for _, tt := range tests {
+		ordinal, ok := raftAddressOrdinal(tt.address, "ovn-nb-db")
+		if ordinal != tt.ordinal || ok != tt.ok {
+			t.Errorf("raftAddressOrdinal(%q) = %d, %v, want %d, %v", tt.address, ordinal, ok, tt.ordinal, tt.ok)
+		}
+	}
+}
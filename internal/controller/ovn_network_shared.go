@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// sharedRecheckInterval is how often the CNI's databases are re-validated.
+const sharedRecheckInterval = time.Minute
+
+// neutronLogicalSwitchPrefix is the name prefix ML2/OVN gives every logical
+// switch and router it creates ("neutron-<uuid>"). It is fixed in Neutron,
+// so the CNI must not create objects under it.
+const neutronLogicalSwitchPrefix = "neutron-"
+
+// Schema versions the bundled Neutron is known to work with: at least the
+// OVN 22.03 LTS schemas and no newer major version than the bundled OVN.
+const (
+	minNorthboundSchema = "6.1.0"
+	maxNorthboundMajor  = 7
+	minSouthboundSchema = "20.21.0"
+	maxSouthboundMajor  = 20
+)
+
+// cniDatabaseServices lists, per CNI provider, where it exposes its databases.
+var cniDatabaseServices = []struct {
+	provider  string
+	namespace string
+	nbService string
+	sbService string
+}{
+	{provider: "ovn-kubernetes", namespace: "ovn-kubernetes", nbService: "ovnkube-db", sbService: "ovnkube-db"},
+	{provider: "kube-ovn", namespace: "kube-system", nbService: "ovn-nb", sbService: "ovn-sb"},
+}
+
+// reconcileShared validates the CNI's OVN databases and publishes them for
+// Neutron instead of deploying databases and northd.
+func (r *OVNNetworkReconciler) reconcileShared(ctx context.Context, instance *openstackv1alpha1.OVNNetwork) (ctrl.Result, error) {
+	nb, sb, err := r.cniDatabases(ctx, instance)
+	if err != nil {
+		return r.externalDBFailed(ctx, instance, "NotFound", err.Error(), true)
+	}
+
+	var tlsConfig *tls.Config
+	if instance.Spec.ExternalTLSSecretName != "" {
+		tlsConfig, err = common.OVSDBTLSConfig(ctx, r.Client, instance.Spec.ExternalTLSSecretName, instance.Namespace)
+		if err != nil {
+			return r.externalDBFailed(ctx, instance, "InvalidTLSSecret", err.Error(), true)
+		}
+	}
+
+	nbVersion, collisions, err := probeNorthbound(ctx, nb, tlsConfig)
+	if err != nil {
+		return r.externalDBFailed(ctx, instance, "Unreachable", fmt.Sprintf("northbound database %s: %v", nb, err), false)
+	}
+	sbVersion, err := probeSouthbound(ctx, sb, tlsConfig)
+	if err != nil {
+		return r.externalDBFailed(ctx, instance, "Unreachable", fmt.Sprintf("southbound database %s: %v", sb, err), false)
+	}
+	instance.Status.NorthboundSchemaVersion = nbVersion
+	instance.Status.SouthboundSchemaVersion = sbVersion
+
+	if err := checkSchemaVersion("northbound", nbVersion, minNorthboundSchema, maxNorthboundMajor); err != nil {
+		return r.externalDBFailed(ctx, instance, "IncompatibleSchema", err.Error(), true)
+	}
+	if err := checkSchemaVersion("southbound", sbVersion, minSouthboundSchema, maxSouthboundMajor); err != nil {
+		return r.externalDBFailed(ctx, instance, "IncompatibleSchema", err.Error(), true)
+	}
+	if len(collisions) > 0 {
+		if len(collisions) > 5 {
+			collisions = append(collisions[:5], "...")
+		}
+		return r.externalDBFailed(ctx, instance, "NameCollision", fmt.Sprintf(
+			"logical switches not created by Neutron use the reserved prefix %q: %s", neutronLogicalSwitchPrefix, strings.Join(collisions, ", "),
+		), true)
+	}
+
+	instance.Status.NorthboundDBEndpoint = nb
+	instance.Status.SouthboundDBEndpoint = sb
+	instance.Status.TLSSecretName = instance.Spec.ExternalTLSSecretName
+	instance.Status.NorthboundRaft = nil
+	instance.Status.SouthboundRaft = nil
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionExternalDBReady), metav1.ConditionTrue, "Compatible",
+		fmt.Sprintf("Using the CNI's OVN databases (NB schema %s, SB schema %s)", nbVersion, sbVersion), instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "Shared OVN databases are ready", instance.Generation,
+	)
+	if _, err := r.reconcileChassis(ctx, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.updateStatus(ctx, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: sharedRecheckInterval}, nil
+}
+
+// externalDBFailed records why the CNI's databases cannot be used. Endpoints
+// are withdrawn for problems that will not resolve by themselves, so Neutron
+// is never pointed at an incompatible database; a transient outage keeps the
+// last validated endpoints.
+func (r *OVNNetworkReconciler) externalDBFailed(ctx context.Context, instance *openstackv1alpha1.OVNNetwork, reason, message string, withdraw bool) (ctrl.Result, error) {
+	if withdraw {
+		instance.Status.NorthboundDBEndpoint = ""
+		instance.Status.SouthboundDBEndpoint = ""
+		instance.Status.TLSSecretName = ""
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionExternalDBReady), metav1.ConditionFalse, reason, message, instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+	)
+	return ctrl.Result{RequeueAfter: 30 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+// cniDatabases returns the NB/SB connection strings to use: the external
+// ones from the spec, or those of the CNI's database Services.
+func (r *OVNNetworkReconciler) cniDatabases(ctx context.Context, instance *openstackv1alpha1.OVNNetwork) (string, string, error) {
+	if instance.Spec.ExternalNorthboundDB != "" && instance.Spec.ExternalSouthboundDB != "" {
+		return instance.Spec.ExternalNorthboundDB, instance.Spec.ExternalSouthboundDB, nil
+	}
+
+	proto := "tcp"
+	if instance.Spec.ExternalTLSSecretName != "" {
+		proto = "ssl"
+	}
+	var tried []string
+	for _, cni := range cniDatabaseServices {
+		if instance.Spec.CNIProvider != "" && instance.Spec.CNIProvider != cni.provider {
+			continue
+		}
+		found := true
+		for _, name := range []string{cni.nbService, cni.sbService} {
+			if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: cni.namespace}, &corev1.Service{}); err != nil {
+				if !errors.IsNotFound(err) {
+					return "", "", err
+				}
+				found = false
+			}
+		}
+		if found {
+			return fmt.Sprintf("%s:%s.%s.svc:%d", proto, cni.nbService, cni.namespace, ovsdbNorthbound.clientPort),
+				fmt.Sprintf("%s:%s.%s.svc:%d", proto, cni.sbService, cni.namespace, ovsdbSouthbound.clientPort), nil
+		}
+		tried = append(tried, fmt.Sprintf("%s (%s/%s, %s/%s)", cni.provider, cni.namespace, cni.nbService, cni.namespace, cni.sbService))
+	}
+	return "", "", fmt.Errorf("no CNI OVN databases found; looked for %s. Set externalNorthboundDB and externalSouthboundDB", strings.Join(tried, ", "))
+}
+
+// probeNorthbound returns the NB schema version and the logical switches
+// that use Neutron's prefix without being Neutron networks.
+func probeNorthbound(ctx context.Context, remotes string, tlsConfig *tls.Config) (string, []string, error) {
+	c, err := common.DialOVSDB(ctx, remotes, tlsConfig)
+	if err != nil {
+		return "", nil, err
+	}
+	defer c.Close()
+
+	version, err := c.SchemaVersion(ovsdbNorthbound.dbName)
+	if err != nil {
+		return "", nil, err
+	}
+	rows, err := c.Select(ovsdbNorthbound.dbName, "Logical_Switch", []string{"name", "external_ids"})
+	if err != nil {
+		return "", nil, err
+	}
+	var collisions []string
+	for _, row := range rows {
+		name, _ := row["name"].(string)
+		if !strings.HasPrefix(name, neutronLogicalSwitchPrefix) {
+			continue
+		}
+		if _, ok := common.OVSDBMap(row["external_ids"])["neutron:network_name"]; !ok {
+			collisions = append(collisions, name)
+		}
+	}
+	sort.Strings(collisions)
+	return version, collisions, nil
+}
+
+// probeSouthbound returns the SB schema version.
+func probeSouthbound(ctx context.Context, remotes string, tlsConfig *tls.Config) (string, error) {
+	c, err := common.DialOVSDB(ctx, remotes, tlsConfig)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	return c.SchemaVersion(ovsdbSouthbound.dbName)
+}
+
+// checkSchemaVersion verifies version lies within [minVersion, maxMajor.x.x].
+func checkSchemaVersion(db, version, minVersion string, maxMajor int) error {
+	cmp, err := common.CompareOVSDBVersions(version, minVersion)
+	if err != nil {
+		return err
+	}
+	if cmp < 0 {
+		return fmt.Errorf("%s schema %s is older than the minimum supported %s", db, version, minVersion)
+	}
+	major, err := common.OVSDBMajorVersion(version)
+	if err != nil {
+		return err
+	}
+	if major > maxMajor {
+		return fmt.Errorf("%s schema %s is a newer major version than the supported %d.x", db, version, maxMajor)
+	}
+	return nil
+}
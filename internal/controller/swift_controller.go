@@ -0,0 +1,396 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+const (
+	swiftProxyPort     = 8080
+	swiftObjectPort    = 6200
+	swiftContainerPort = 6201
+	swiftAccountPort   = 6202
+	swiftRsyncPort     = 873
+)
+
+// SwiftReconciler reconciles a Swift object.
+type SwiftReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=swifts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=swifts/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=swifts/finalizers,verbs=update
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=services;secrets;configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups=openstack.k8s.io,resources=cephstorages,verbs=get;list;watch
+// +kubebuilder:rbac:groups=ceph.rook.io,resources=cephobjectstores,verbs=get;list;watch;create;update;patch;delete
+
+func (r *SwiftReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	instance := &openstackv1alpha1.Swift{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// Handle deletion: the CephObjectStore lives in the Rook namespace and
+	// cannot be owned by the Swift, so it is deleted here. Rook keeps its
+	// pools.
+	if !instance.DeletionTimestamp.IsZero() {
+		if common.HasFinalizer(instance, common.FinalizerName) {
+			if err := r.deleteRGW(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+			common.RemoveFinalizer(instance, common.FinalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Ensure finalizer
+	if !common.HasFinalizer(instance, common.FinalizerName) {
+		common.AddFinalizer(instance, common.FinalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, "Reconciling", "Reconciliation in progress", instance.Generation,
+	)
+
+	conns, err := common.ResolveConnections(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "WaitingForDependencies", err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := common.EnsureSecret(ctx, r.Client, swiftServiceSecretName(instance), instance.Namespace, map[string]int{"password": 32}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Either backend serves the Swift API; only its URL differs.
+	var apiEndpoint string
+	var requeue time.Duration
+	if instance.Spec.Mode == "ceph-rgw" {
+		if apiEndpoint, err = r.ensureRGW(ctx, instance, conns); err != nil {
+			switch {
+			case errors.Is(err, errSwiftRGWURLRequired):
+				return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionReady), "RGWURLRequired", err.Error())
+			case errors.Is(err, common.ErrDependencyNotReady):
+				return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "WaitingForRGW", err.Error())
+			}
+			return ctrl.Result{}, err
+		}
+	} else {
+		// The hash path prefix and suffix place every object in the rings and
+		// must never change once data is stored.
+		if err := common.EnsureSecret(ctx, r.Client, swiftHashSecretName(instance), instance.Namespace, map[string]int{"prefix": 32, "suffix": 32}, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		hashes, err := r.ensureConfig(ctx, instance, conns)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// Storage pods come first: their PVCs are the ring devices.
+		if err := r.ensureStorageService(ctx, instance); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.ensureStorage(ctx, instance, hashes["storage"]); err != nil {
+			return ctrl.Result{}, err
+		}
+		ringsWaiting, ringsRequeue, err := r.ensureRings(ctx, instance, time.Now())
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if ringsWaiting != "" {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionRingsReady), "BuildingRings", ringsWaiting)
+		}
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionRingsReady), metav1.ConditionTrue, "RingsReady", "Rings are built and distributed", instance.Generation,
+		)
+		if err := r.ensureProxy(ctx, instance, hashes["proxy"]); err != nil {
+			return ctrl.Result{}, err
+		}
+		apiEndpoint = fmt.Sprintf("http://%s-proxy.%s.svc:%d/v1", instance.Name, instance.Namespace, swiftProxyPort)
+		requeue = ringsRequeue
+	}
+
+	catalogURL := apiEndpoint + "/AUTH_%(project_id)s"
+	if err := common.EnsureKeystoneEndpoint(ctx, r.Client, common.EndpointParams{
+		Name:              instance.Name,
+		Namespace:         instance.Namespace,
+		ServiceName:       "swift",
+		ServiceType:       "object-store",
+		InternalURL:       catalogURL,
+		PublicURL:         catalogURL,
+		AdminURL:          catalogURL,
+		Region:            conns.Region,
+		KeystoneSecret:    conns.KeystoneSecret,
+		KeystoneURL:       conns.KeystoneURL,
+		BootstrapImage:    images.DefaultKeystone,
+		ServiceUser:       "swift",
+		ServiceUserSecret: swiftServiceSecretName(instance),
+	}, instance); err != nil {
+		return ctrl.Result{}, err
+	}
+	if done, err := common.IsJobComplete(ctx, r.Client, instance.Name+"-endpoint-create", instance.Namespace); err != nil {
+		return ctrl.Result{}, err
+	} else if !done {
+		return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionBootstrapReady), "RegisteringEndpoint", "Waiting for Keystone endpoint registration")
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionBootstrapReady), metav1.ConditionTrue, "EndpointRegistered", "Keystone service, user and endpoints registered", instance.Generation,
+	)
+	instance.Status.APIEndpoint = apiEndpoint
+
+	// The RGW is Ready by the time its URL is known.
+	if instance.Spec.Mode != "ceph-rgw" {
+		var notReady []string
+		sts := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-storage", Namespace: instance.Namespace}, sts); err != nil {
+			return ctrl.Result{}, err
+		}
+		if !common.IsStatefulSetReady(sts) {
+			notReady = append(notReady, "storage")
+		}
+		deploy := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Name: instance.Name + "-proxy", Namespace: instance.Namespace}, deploy); err != nil {
+			return ctrl.Result{}, err
+		}
+		if !common.IsDeploymentReady(deploy) {
+			notReady = append(notReady, "proxy")
+		}
+		if len(notReady) > 0 {
+			return r.waitFor(ctx, instance, string(openstackv1alpha1.ConditionDeploymentReady), "DeploymentNotReady", fmt.Sprintf("Waiting for %v", notReady))
+		}
+	}
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionDeploymentReady), metav1.ConditionTrue, "DeploymentReady", "The Swift API backend is available", instance.Generation,
+	)
+	instance.Status.Conditions = common.SetCondition(
+		instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionTrue, "Ready", "Swift is ready", instance.Generation,
+	)
+
+	if err := r.updateStatus(ctx, instance); err != nil {
+		logger.Error(err, "failed to update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// waitFor records a not-yet-satisfied condition and requeues.
+func (r *SwiftReconciler) waitFor(ctx context.Context, instance *openstackv1alpha1.Swift, condType, reason, message string) (ctrl.Result, error) {
+	instance.Status.Conditions = common.SetCondition(instance.Status.Conditions, condType, metav1.ConditionFalse, reason, message, instance.Generation)
+	if condType != string(openstackv1alpha1.ConditionReady) {
+		instance.Status.Conditions = common.SetCondition(
+			instance.Status.Conditions, string(openstackv1alpha1.ConditionReady), metav1.ConditionFalse, reason, message, instance.Generation,
+		)
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, r.updateStatus(ctx, instance)
+}
+
+func (r *SwiftReconciler) updateStatus(ctx context.Context, instance *openstackv1alpha1.Swift) error {
+	instance.Status.ObservedGeneration = instance.Generation
+	return r.Status().Update(ctx, instance)
+}
+
+// ensureConfig renders the proxy, storage server and rsync configuration
+// into a Secret (it embeds credentials and the hash path secrets). It
+// returns the hash of the files used by the proxy under "proxy" and of those
+// used by the storage pods under "storage". Rings are distributed
+// separately and reloaded by the daemons without a restart.
+func (r *SwiftReconciler) ensureConfig(ctx context.Context, instance *openstackv1alpha1.Swift, conns *common.Connections) (map[string]string, error) {
+	prefix, err := common.GetSecretValue(ctx, r.Client, swiftHashSecretName(instance), instance.Namespace, "prefix")
+	if err != nil {
+		return nil, err
+	}
+	suffix, err := common.GetSecretValue(ctx, r.Client, swiftHashSecretName(instance), instance.Namespace, "suffix")
+	if err != nil {
+		return nil, err
+	}
+	servicePassword, err := common.GetSecretValue(ctx, r.Client, swiftServiceSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]any{
+		"HashPathPrefix":   prefix,
+		"HashPathSuffix":   suffix,
+		"Port":             swiftProxyPort,
+		"AccountPort":      swiftAccountPort,
+		"ContainerPort":    swiftContainerPort,
+		"ObjectPort":       swiftObjectPort,
+		"RsyncPort":        swiftRsyncPort,
+		"Modules":          []string{"account", "container", "object"},
+		"Policies":         swiftStoragePolicies(instance),
+		"KeystoneURL":      conns.KeystoneURL,
+		"MemcachedServers": conns.MemcachedServers,
+		"Region":           conns.Region,
+		"ServiceUser":      "swift",
+		"ServicePassword":  servicePassword,
+	}
+	files := map[string]string{}
+	for _, name := range []string{"swift.conf", "proxy-server.conf", "account-server.conf", "container-server.conf", "object-server.conf", "rsyncd.conf"} {
+		rendered, err := common.RenderTemplate("swift/"+name, data)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = rendered
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-config", Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labelsForSwift(instance.Name, "config")
+		secret.Data = make(map[string][]byte, len(files))
+		for k, v := range files {
+			secret.Data[k] = []byte(v)
+		}
+		return controllerutil.SetControllerReference(instance, secret, r.Scheme)
+	}); err != nil {
+		return nil, err
+	}
+
+	proxyFiles := map[string]string{"swift.conf": files["swift.conf"], "proxy-server.conf": files["proxy-server.conf"]}
+	storageFiles := map[string]string{}
+	for k, v := range files {
+		if k != "proxy-server.conf" {
+			storageFiles[k] = v
+		}
+	}
+	return map[string]string{"proxy": common.ConfigHash(proxyFiles), "storage": common.ConfigHash(storageFiles)}, nil
+}
+
+func (r *SwiftReconciler) ensureProxy(ctx context.Context, instance *openstackv1alpha1.Swift, configHash string) error {
+	name := instance.Name + "-proxy"
+	labels := labelsForSwift(instance.Name, "proxy")
+	replicas := int32(1)
+	if instance.Spec.ProxyReplicas != nil {
+		replicas = *instance.Spec.ProxyReplicas
+	}
+
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, deploy, func() error {
+		deploy.Labels = labels
+		deploy.Spec.Replicas = &replicas
+		if deploy.CreationTimestamp.IsZero() {
+			deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		}
+		deploy.Spec.Template.Labels = labels
+		deploy.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		deploy.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		deploy.Spec.Template.Spec.Containers = []corev1.Container{{
+			Name:         "swift-proxy-server",
+			Image:        images.ImageOrDefault(instance.Spec.Image, images.DefaultSwiftProxy),
+			Command:      []string{"swift-proxy-server", "/etc/swift/proxy-server.conf"},
+			Resources:    instance.Spec.Resources,
+			VolumeMounts: []corev1.VolumeMount{swiftConfigVolumeMount()},
+			Ports:        []corev1.ContainerPort{{ContainerPort: swiftProxyPort, Name: "proxy"}},
+			ReadinessProbe: &corev1.Probe{
+				ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{Path: "/healthcheck", Port: intstr.FromInt32(swiftProxyPort)},
+				},
+				InitialDelaySeconds: 5,
+				PeriodSeconds:       10,
+			},
+		}}
+		deploy.Spec.Template.Spec.Volumes = []corev1.Volume{swiftConfigVolume(instance)}
+		return controllerutil.SetControllerReference(instance, deploy, r.Scheme)
+	}); err != nil {
+		return err
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labels
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: "proxy", Port: swiftProxyPort, TargetPort: intstr.FromInt32(swiftProxyPort), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+func (r *SwiftReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&openstackv1alpha1.Swift{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
+
+func swiftHashSecretName(instance *openstackv1alpha1.Swift) string {
+	return instance.Name + "-hash-path"
+}
+
+func swiftServiceSecretName(instance *openstackv1alpha1.Swift) string {
+	return instance.Name + "-service-password"
+}
+
+// swiftConfigVolume combines the configuration Secret and the rings
+// ConfigMap in /etc/swift. The rings are optional so that pods can start
+// before the first rings are built.
+func swiftConfigVolume(instance *openstackv1alpha1.Swift) corev1.Volume {
+	optional := true
+	return corev1.Volume{
+		Name: "swift",
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: instance.Name + "-config"}}},
+					{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: swiftRingsName(instance)}, Optional: &optional}},
+				},
+			},
+		},
+	}
+}
+
+func swiftConfigVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{Name: "swift", MountPath: "/etc/swift", ReadOnly: true}
+}
+
+func labelsForSwift(name, component string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "swift",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/component":  component,
+		"app.kubernetes.io/managed-by": "openstack-operator",
+	}
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+const (
+	// swiftRGWPort is the port of the gateways of the CephObjectStore.
+	swiftRGWPort = 8080
+
+	// swiftRGWOwnerLabel marks the CephObjectStores a Swift created in the
+	// Rook namespace. Its value is <namespace>.<swift>.
+	swiftRGWOwnerLabel = "openstack.k8s.io/swift"
+
+	// swiftObjectStoreMaxName keeps the names Rook derives from the store,
+	// such as the rook-ceph-rgw-<store>-a Deployment, valid DNS labels.
+	swiftObjectStoreMaxName = 40
+)
+
+// errSwiftRGWURLRequired is returned in ceph-rgw mode when the RGW can
+// neither be created nor was given.
+var errSwiftRGWURLRequired = errors.New("an external CephStorage requires spec.rgw.url")
+
+// ensureRGW returns the Swift API root of the RGW serving ceph-rgw mode:
+// the given URL of an existing RGW, or that of a CephObjectStore created on
+// the Rook cluster. It returns ErrDependencyNotReady until the object store
+// is Ready.
+func (r *SwiftReconciler) ensureRGW(ctx context.Context, instance *openstackv1alpha1.Swift, conns *common.Connections) (string, error) {
+	rgw := instance.Spec.RGW
+	if rgw == nil {
+		rgw = &openstackv1alpha1.SwiftRGWSpec{}
+	}
+	if rgw.URL != "" {
+		// A store created before the URL was given is no longer used.
+		if err := r.deleteRGW(ctx, instance); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(rgw.URL, "/"), nil
+	}
+
+	ceph, err := common.GetCephStorage(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		return "", err
+	}
+	if ceph.Spec.Mode == "external" {
+		return "", errSwiftRGWURLRequired
+	}
+	password, err := common.GetSecretValue(ctx, r.Client, swiftServiceSecretName(instance), instance.Namespace, "password")
+	if err != nil {
+		return "", err
+	}
+
+	gateways := int64(1)
+	if rgw.Gateways > 0 {
+		gateways = int64(rgw.Gateways)
+	}
+	roles := rgw.AcceptedRoles
+	if len(roles) == 0 {
+		roles = []string{"admin", "member"}
+	}
+	// The CRD allows a single replicated policy in this mode.
+	replicas := int64(swiftStoragePolicies(instance)[0].ReplicaCount)
+	return common.EnsureCephObjectStore(ctx, r.Client, ceph, common.CephObjectStoreParams{
+		Name:             swiftObjectStoreName(instance),
+		Replicas:         replicas,
+		Gateways:         gateways,
+		Port:             swiftRGWPort,
+		KeystoneURL:      strings.TrimSuffix(conns.KeystoneURL, "/v3"),
+		KeystoneUser:     "swift",
+		KeystonePassword: password,
+		AcceptedRoles:    roles,
+		Labels:           map[string]string{swiftRGWOwnerLabel: swiftRGWOwner(instance)},
+	})
+}
+
+// swiftObjectStoreName is <namespace>-<swift>, shortened with a hash of
+// the full name when too long. The stores of all namespaces share the Rook
+// namespace, so the name includes the Swift's namespace.
+func swiftObjectStoreName(instance *openstackv1alpha1.Swift) string {
+	name := instance.Namespace + "-" + instance.Name
+	if len(name) <= swiftObjectStoreMaxName {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return strings.TrimSuffix(name[:swiftObjectStoreMaxName-9], "-") + "-" + hex.EncodeToString(sum[:4])
+}
+
+// swiftRGWOwner is the swiftRGWOwnerLabel value of a Swift's stores.
+func swiftRGWOwner(instance *openstackv1alpha1.Swift) string {
+	return instance.Namespace + "." + instance.Name
+}
+
+// deleteRGW deletes the CephObjectStores created in ceph-rgw mode, if any.
+func (r *SwiftReconciler) deleteRGW(ctx context.Context, instance *openstackv1alpha1.Swift) error {
+	if instance.Spec.Mode != "ceph-rgw" {
+		return nil
+	}
+	ceph, err := common.GetCephStorage(ctx, r.Client, instance.Namespace)
+	if err != nil {
+		if errors.Is(err, common.ErrDependencyNotReady) {
+			return nil
+		}
+		return err
+	}
+	if ceph.Spec.Mode == "external" {
+		return nil
+	}
+	names, err := common.ListCephObjectStores(ctx, r.Client, ceph, map[string]string{swiftRGWOwnerLabel: swiftRGWOwner(instance)})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := common.DeleteCephObjectStore(ctx, r.Client, ceph, name); err != nil {
+			return fmt.Errorf("deleting Ceph object store %s: %w", name, err)
+		}
+	}
+	return nil
+}
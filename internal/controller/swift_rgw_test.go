@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+// rookCephStorage is a Rook CephStorage in namespace whose cluster runs in
+// the rook-ceph namespace.
+func rookCephStorage(namespace string) *openstackv1alpha1.CephStorage {
+	return &openstackv1alpha1.CephStorage{
+		ObjectMeta: metav1.ObjectMeta{Name: "ceph", Namespace: namespace},
+		Spec:       openstackv1alpha1.CephStorageSpec{Mode: "rook"},
+	}
+}
+
+// cephObjectStore is a CephObjectStore in the rook-ceph namespace, labelled
+// with owner unless it is empty.
+func cephObjectStore(name, owner string) *unstructured.Unstructured {
+	store := &unstructured.Unstructured{}
+	store.SetGroupVersionKind(common.CephObjectStoreGVK)
+	store.SetName(name)
+	store.SetNamespace("rook-ceph")
+	if owner != "" {
+		store.SetLabels(map[string]string{swiftRGWOwnerLabel: owner})
+	}
+	return store
+}
+
+func newRGWSwift(namespace, url string) *openstackv1alpha1.Swift {
+	instance := &openstackv1alpha1.Swift{
+		ObjectMeta: metav1.ObjectMeta{Name: "swift", Namespace: namespace},
+		Spec:       openstackv1alpha1.SwiftSpec{Mode: "ceph-rgw"},
+	}
+	if url != "" {
+		instance.Spec.RGW = &openstackv1alpha1.SwiftRGWSpec{URL: url}
+	}
+	return instance
+}
+
+func TestSwiftObjectStoreName(t *testing.T) {
+	short := newRGWSwift("tenant-a", "")
+	if got := swiftObjectStoreName(short); got != "tenant-a-swift" {
+		t.Errorf("name = %q", got)
+	}
+	long := newRGWSwift("a-very-long-namespace-name-for-the-tenant", "")
+	other := newRGWSwift("a-very-long-namespace-name-for-the-tenant-b", "")
+	got := swiftObjectStoreName(long)
+	if len(got) > swiftObjectStoreMaxName || !strings.HasPrefix(got, "a-very-long-namespace") {
+		t.Errorf("name = %q", got)
+	}
+	if got == swiftObjectStoreName(other) {
+		t.Errorf("%s and %s share store %s", long.Namespace, other.Namespace, got)
+	}
+}
+
+func TestSwiftEnsureRGWUnlabelledStore(t *testing.T) {
+	ctx := context.Background()
+	conns := &common.Connections{KeystoneURL: "http://keystone-api.openstack.svc:5000/v3"}
+	// A store named after the Swift but created by someone else.
+	foreign := cephObjectStore("swift", "")
+	c := newFakeClient(t, rookCephStorage("tenant-a"), foreign, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: swiftServiceSecretName(newRGWSwift("tenant-a", "")), Namespace: "tenant-a"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	})
+	r := &SwiftReconciler{Client: c, Scheme: testScheme}
+	get := func() *unstructured.Unstructured {
+		t.Helper()
+		store, err := common.GetCephObjectStore(ctx, c, rookCephStorage("tenant-a"), "swift")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	before := get()
+
+	if _, err := r.ensureRGW(ctx, newRGWSwift("tenant-a", ""), conns); !errors.Is(err, common.ErrDependencyNotReady) {
+		t.Fatalf("error = %v, want the store not ready", err)
+	}
+	if store := get(); store.GetResourceVersion() != before.GetResourceVersion() || len(store.GetLabels()) != 0 {
+		t.Errorf("store swift changed: labels %v", store.GetLabels())
+	}
+	if store, err := common.GetCephObjectStore(ctx, c, rookCephStorage("tenant-a"), "tenant-a-swift"); err != nil || store == nil {
+		t.Fatalf("store tenant-a-swift = %v, %v, want created", store, err)
+	}
+
+	// Dropping the created store for an RGW URL leaves the other one.
+	if _, err := r.ensureRGW(ctx, newRGWSwift("tenant-a", "https://rgw.example.com/swift/v1"), conns); err != nil {
+		t.Fatal(err)
+	}
+	if get() == nil {
+		t.Error("store swift deleted")
+	}
+}
+
+func TestSwiftEnsureRGW(t *testing.T) {
+	ctx := context.Background()
+	conns := &common.Connections{KeystoneURL: "http://keystone-api.openstack.svc:5000/v3"}
+	password := func(namespace string) client.Object {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: swiftServiceSecretName(newRGWSwift(namespace, "")), Namespace: namespace},
+			Data:       map[string][]byte{"password": []byte("secret")},
+		}
+	}
+	c := newFakeClient(t, rookCephStorage("tenant-a"), rookCephStorage("tenant-b"), password("tenant-a"), password("tenant-b"))
+	r := &SwiftReconciler{Client: c, Scheme: testScheme}
+
+	// Two Swifts of the same name in different namespaces get a store each.
+	for _, namespace := range []string{"tenant-a", "tenant-b"} {
+		if _, err := r.ensureRGW(ctx, newRGWSwift(namespace, ""), conns); !errors.Is(err, common.ErrDependencyNotReady) {
+			t.Fatalf("error = %v, want the store not ready", err)
+		}
+	}
+	for _, namespace := range []string{"tenant-a", "tenant-b"} {
+		store := &unstructured.Unstructured{}
+		store.SetGroupVersionKind(common.CephObjectStoreGVK)
+		if err := c.Get(ctx, client.ObjectKey{Name: namespace + "-swift", Namespace: "rook-ceph"}, store); err != nil {
+			t.Fatal(err)
+		}
+		if owner := store.GetLabels()[swiftRGWOwnerLabel]; owner != namespace+".swift" {
+			t.Errorf("store %s owned by %q", store.GetName(), owner)
+		}
+	}
+
+	// Giving an RGW URL deletes the store created before, and only it.
+	url, err := r.ensureRGW(ctx, newRGWSwift("tenant-a", "https://rgw.example.com/swift/v1/"), conns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://rgw.example.com/swift/v1" {
+		t.Errorf("url = %q", url)
+	}
+	stores := &unstructured.UnstructuredList{}
+	stores.SetGroupVersionKind(common.CephObjectStoreGVK.GroupVersion().WithKind("CephObjectStoreList"))
+	if err := c.List(ctx, stores, client.InNamespace("rook-ceph")); err != nil {
+		t.Fatal(err)
+	}
+	if len(stores.Items) != 1 || stores.Items[0].GetName() != "tenant-b-swift" {
+		var names []string
+		for _, store := range stores.Items {
+			names = append(names, store.GetName())
+		}
+		t.Errorf("stores = %v, want tenant-b-swift only", names)
+	}
+}
+
+func TestSwiftDeleteRGW(t *testing.T) {
+	tests := []struct {
+		name       string
+		instance   *openstackv1alpha1.Swift
+		ceph       *openstackv1alpha1.CephStorage
+		wantStores []string
+	}{
+		{
+			name:       "created store",
+			instance:   newRGWSwift("tenant-a", ""),
+			ceph:       rookCephStorage("tenant-a"),
+			wantStores: []string{"swift", "tenant-b-swift"},
+		},
+		{
+			name:       "native mode",
+			instance:   &openstackv1alpha1.Swift{ObjectMeta: metav1.ObjectMeta{Name: "swift", Namespace: "tenant-a"}, Spec: openstackv1alpha1.SwiftSpec{Mode: "native"}},
+			ceph:       rookCephStorage("tenant-a"),
+			wantStores: []string{"swift", "tenant-a-swift", "tenant-b-swift"},
+		},
+		{
+			name:       "no CephStorage",
+			instance:   newRGWSwift("tenant-a", ""),
+			wantStores: []string{"swift", "tenant-a-swift", "tenant-b-swift"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			objs := []client.Object{
+				cephObjectStore("tenant-a-swift", "tenant-a.swift"),
+				cephObjectStore("tenant-b-swift", "tenant-b.swift"),
+				// Not the operator's.
+				cephObjectStore("swift", ""),
+			}
+			if tt.ceph != nil {
+				objs = append(objs, tt.ceph)
+			}
+			c := newFakeClient(t, objs...)
+			r := &SwiftReconciler{Client: c, Scheme: testScheme}
+
+			if err := r.deleteRGW(ctx, tt.instance); err != nil {
+				t.Fatal(err)
+			}
+			got, err := common.ListCephObjectStores(ctx, c, rookCephStorage("tenant-a"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantStores, ",") {
+				t.Errorf("stores = %v, want %v", got, tt.wantStores)
+			}
+		})
+	}
+}
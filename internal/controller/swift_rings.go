@@ -0,0 +1,465 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+const (
+	// swiftRingResyncInterval is how often converged rings are checked for
+	// new or resized devices.
+	swiftRingResyncInterval = 5 * time.Minute
+
+	// swiftDevice is the name of the one device of each storage pod.
+	swiftDevice = "d0"
+
+	// swiftRingConfigMapMaxSize is the most data the API server accepts in
+	// a ConfigMap.
+	swiftRingConfigMapMaxSize = 1 << 20
+)
+
+// errSwiftRingsTooLarge is returned when the builders or ring files do not
+// fit in their ConfigMap.
+var errSwiftRingsTooLarge = errors.New("rings too large")
+
+// swiftRing is a ring built by the operator.
+type swiftRing struct {
+	name     string
+	port     int
+	replicas int
+	// devices selects the storage pods by ordinal; empty selects all.
+	devices []int32
+}
+
+// swiftRings returns the account and container rings and the object ring
+// of each storage policy.
+func swiftRings(instance *openstackv1alpha1.Swift) []swiftRing {
+	policies := swiftStoragePolicies(instance)
+	replicas := 3
+	for _, policy := range policies {
+		if policy.Default && policy.Type == "replication" {
+			replicas = int(policy.ReplicaCount)
+		}
+	}
+	rings := []swiftRing{
+		{name: "account", port: swiftAccountPort, replicas: replicas},
+		{name: "container", port: swiftContainerPort, replicas: replicas},
+	}
+	for _, policy := range policies {
+		ring := swiftRing{name: "object", port: swiftObjectPort, replicas: int(policy.ReplicaCount), devices: policy.Devices}
+		if policy.Index > 0 {
+			ring.name = fmt.Sprintf("object-%d", policy.Index)
+		}
+		if policy.Type == "erasure_coding" {
+			// Each fragment of an object is a replica in the ring.
+			ring.replicas = int(policy.ECDataFragments + policy.ECParityFragments)
+		}
+		rings = append(rings, ring)
+	}
+	return rings
+}
+
+// swiftStoragePolicies returns the storage policies by index, defaulting to
+// a replicated Policy-0 with the replica count of the deprecated
+// StoragePolicy.
+func swiftStoragePolicies(instance *openstackv1alpha1.Swift) []openstackv1alpha1.SwiftStoragePolicy {
+	if len(instance.Spec.StoragePolicies) == 0 {
+		replicas := int32(3)
+		if legacy := instance.Spec.StoragePolicy; legacy != nil && legacy.ReplicaCount > 0 {
+			replicas = legacy.ReplicaCount
+		}
+		return []openstackv1alpha1.SwiftStoragePolicy{{Name: "Policy-0", Default: true, Type: "replication", ReplicaCount: replicas}}
+	}
+	policies := make([]openstackv1alpha1.SwiftStoragePolicy, 0, len(instance.Spec.StoragePolicies))
+	for _, policy := range instance.Spec.StoragePolicies {
+		if policy.Type == "" {
+			policy.Type = "replication"
+		}
+		if policy.ReplicaCount == 0 {
+			policy.ReplicaCount = 3
+		}
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Index < policies[j].Index })
+	return policies
+}
+
+// selected reports whether the ring uses the device of a storage pod.
+func (ring swiftRing) selected(ordinal int) bool {
+	if len(ring.devices) == 0 {
+		return true
+	}
+	for _, d := range ring.devices {
+		if int(d) == ordinal {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureRings builds the rings from the storage PVCs and, once built, moves
+// them towards the device weights the PVCs ask for: at most once every
+// MinPartHours, each weight changes by WeightStepPercent and the ring is
+// rebalanced. The builders are kept in a ConfigMap, from which the ring
+// files distributed to the pods are regenerated. It returns a message while
+// the rings cannot be built yet, and when to requeue to take the next step.
+func (r *SwiftReconciler) ensureRings(ctx context.Context, instance *openstackv1alpha1.Swift, now time.Time) (string, time.Duration, error) {
+	capacities, err := r.storageDevices(ctx, instance)
+	if err != nil {
+		return "", 0, err
+	}
+	builders, err := r.loadRingBuilders(ctx, instance)
+	if err != nil {
+		return "", 0, err
+	}
+	rings := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Name: swiftRingsName(instance), Namespace: instance.Namespace}, rings); err != nil && !apierrors.IsNotFound(err) {
+		return "", 0, err
+	}
+
+	desired := int(swiftStorageReplicas(instance))
+	partPower, minPartHours, stepPercent := 10, 1, 25
+	if instance.Spec.Rings.PartPower > 0 {
+		partPower = int(instance.Spec.Rings.PartPower)
+	}
+	if instance.Spec.Rings.MinPartHours > 0 {
+		minPartHours = int(instance.Spec.Rings.MinPartHours)
+	}
+	if instance.Spec.Rings.WeightStepPercent > 0 {
+		stepPercent = int(instance.Spec.Rings.WeightStepPercent)
+	}
+
+	requeue := swiftRingResyncInterval
+	var statuses []openstackv1alpha1.SwiftRingStatus
+	for _, ring := range swiftRings(instance) {
+		// Devices of pods beyond StorageReplicas, or no longer selected by
+		// the ring, drain to zero.
+		targets := map[int]float64{}
+		wanted := 0
+		for ordinal := 0; ordinal < desired; ordinal++ {
+			if !ring.selected(ordinal) {
+				continue
+			}
+			wanted++
+			if capacity, ok := capacities[ordinal]; ok {
+				targets[ordinal] = capacity
+			}
+		}
+
+		b := builders[ring.name]
+		if b == nil {
+			// Without its builder a ring cannot be changed safely: building
+			// a new one would move every partition.
+			if _, ok := rings.BinaryData[ring.name+".ring.gz"]; ok {
+				return fmt.Sprintf("The builder of the %s ring is missing from ConfigMap %s; restore it to change the ring", ring.name, swiftRingBuildersName(instance)), 0, nil
+			}
+			if len(targets) < wanted {
+				return fmt.Sprintf("Waiting for %d of %d storage devices of the %s ring to be bound", wanted-len(targets), wanted, ring.name), 0, nil
+			}
+			b = common.NewSwiftRingBuilder(partPower, ring.replicas, minPartHours)
+			for ordinal := 0; ordinal < desired; ordinal++ {
+				if target, ok := targets[ordinal]; ok {
+					b.AddDevice(swiftRingDevice(instance, ordinal, ring.port, target))
+				}
+			}
+			if _, err := b.Rebalance(now); err != nil {
+				return "", 0, err
+			}
+			builders[ring.name] = b
+		} else {
+			b.Replicas = ring.replicas
+			b.MinPartHours = minPartHours
+			if !now.Before(b.NextRebalance()) {
+				stepSwiftRingWeights(instance, b, ring.port, capacities, targets, stepPercent)
+				if _, err := b.Rebalance(now); err != nil {
+					if errors.Is(err, common.ErrSwiftRingNoDevices) {
+						return fmt.Sprintf("The %s ring has no device left", ring.name), 0, nil
+					}
+					return "", 0, err
+				}
+			}
+		}
+
+		status := openstackv1alpha1.SwiftRingStatus{
+			Name:              ring.name,
+			Version:           int32(b.Version),
+			Balance:           fmt.Sprintf("%.2f", b.Balance()),
+			Converged:         swiftRingConverged(instance, b, targets),
+			LastRebalanceTime: &metav1.Time{Time: b.LastRebalance},
+		}
+		for _, dev := range b.Devices {
+			if dev != nil {
+				status.Devices++
+			}
+		}
+		if !status.Converged {
+			next := b.NextRebalance()
+			status.NextRebalanceTime = &metav1.Time{Time: next}
+			requeue = min(requeue, max(next.Sub(now), time.Minute))
+		}
+		statuses = append(statuses, status)
+	}
+
+	if err := r.saveRings(ctx, instance, builders); errors.Is(err, errSwiftRingsTooLarge) {
+		return err.Error(), 0, nil
+	} else if err != nil {
+		return "", 0, err
+	}
+	instance.Status.Rings = statuses
+	return "", requeue, nil
+}
+
+// stepSwiftRingWeights moves the weight of each device one step towards its
+// target, adding the devices of new PVCs, and removes drained devices. The
+// step is WeightStepPercent of the larger of the target and the capacity
+// of the PVC, so that draining takes as many steps as filling.
+func stepSwiftRingWeights(instance *openstackv1alpha1.Swift, b *common.SwiftRingBuilder, port int, capacities, targets map[int]float64, stepPercent int) {
+	ordinals := make([]int, 0, len(targets))
+	for ordinal := range targets {
+		ordinals = append(ordinals, ordinal)
+	}
+	sort.Ints(ordinals)
+	for _, ordinal := range ordinals {
+		if b.FindDevice(swiftStorageHost(instance, ordinal), swiftDevice) == nil {
+			b.AddDevice(swiftRingDevice(instance, ordinal, port, 0))
+		}
+	}
+	for _, dev := range b.Devices {
+		if dev == nil {
+			continue
+		}
+		ordinal, ok := swiftStorageOrdinal(instance, dev.Meta)
+		capacity, bound := capacities[ordinal]
+		if !ok || !bound {
+			// The PVC of a removed pod is gone, and its data with it.
+			if !ok || ordinal >= int(swiftStorageReplicas(instance)) {
+				b.RemoveDevice(dev.ID)
+			}
+			continue
+		}
+		target := targets[ordinal]
+		step := math.Max(target, capacity) * float64(stepPercent) / 100
+		if dev.Weight < target {
+			dev.Weight = math.Min(target, roundWeight(dev.Weight+step))
+		} else if dev.Weight > target {
+			dev.Weight = math.Max(target, roundWeight(dev.Weight-step))
+		}
+		if dev.Weight == 0 && target == 0 && b.PartsOn(dev.ID) == 0 {
+			b.RemoveDevice(dev.ID)
+		}
+	}
+}
+
+// swiftRingConverged reports whether the ring holds exactly the target
+// devices at their target weights.
+func swiftRingConverged(instance *openstackv1alpha1.Swift, b *common.SwiftRingBuilder, targets map[int]float64) bool {
+	devices := 0
+	for _, dev := range b.Devices {
+		if dev == nil {
+			continue
+		}
+		devices++
+		ordinal, ok := swiftStorageOrdinal(instance, dev.Meta)
+		if target, wanted := targets[ordinal]; !ok || !wanted || dev.Weight != target {
+			return false
+		}
+	}
+	return devices == len(targets)
+}
+
+// swiftRingDevice returns the ring device of a storage pod.
+func swiftRingDevice(instance *openstackv1alpha1.Swift, ordinal, port int, weight float64) common.SwiftRingDevice {
+	host := swiftStorageHost(instance, ordinal)
+	return common.SwiftRingDevice{
+		Region:          1,
+		Zone:            ordinal + 1,
+		IP:              host,
+		Port:            port,
+		ReplicationIP:   host,
+		ReplicationPort: port,
+		Device:          swiftDevice,
+		Weight:          weight,
+		Meta:            fmt.Sprintf("%s-storage-%d", instance.Name, ordinal),
+	}
+}
+
+// swiftStorageOrdinal parses the ordinal of a storage pod from its name.
+func swiftStorageOrdinal(instance *openstackv1alpha1.Swift, pod string) (int, bool) {
+	suffix, ok := strings.CutPrefix(pod, instance.Name+"-storage-")
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	return ordinal, err == nil && ordinal >= 0
+}
+
+// roundWeight rounds a weight to two decimals.
+func roundWeight(weight float64) float64 {
+	return math.Round(weight*100) / 100
+}
+
+// storageDevices returns the capacity in GiB of the bound PVC of each
+// storage pod, by ordinal. The capacity is the device's target weight.
+func (r *SwiftReconciler) storageDevices(ctx context.Context, instance *openstackv1alpha1.Swift) (map[int]float64, error) {
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, pvcs, client.InNamespace(instance.Namespace), client.MatchingLabels(labelsForSwift(instance.Name, "storage"))); err != nil {
+		return nil, err
+	}
+	devices := map[int]float64{}
+	for _, pvc := range pvcs.Items {
+		if !pvc.DeletionTimestamp.IsZero() || pvc.Status.Phase != corev1.ClaimBound {
+			continue
+		}
+		ordinal, ok := swiftStorageOrdinal(instance, strings.TrimPrefix(pvc.Name, "data-"))
+		if !ok {
+			continue
+		}
+		capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]
+		if !ok {
+			capacity = pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		}
+		if weight := roundWeight(float64(capacity.Value()) / (1 << 30)); weight > 0 {
+			devices[ordinal] = weight
+		}
+	}
+	return devices, nil
+}
+
+// loadRingBuilders returns the saved builders by ring name.
+func (r *SwiftReconciler) loadRingBuilders(ctx context.Context, instance *openstackv1alpha1.Swift) (map[string]*common.SwiftRingBuilder, error) {
+	cm := &corev1.ConfigMap{}
+	builders := map[string]*common.SwiftRingBuilder{}
+	if err := r.Get(ctx, client.ObjectKey{Name: swiftRingBuildersName(instance), Namespace: instance.Namespace}, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return builders, nil
+		}
+		return nil, err
+	}
+	for key, data := range cm.BinaryData {
+		name, ok := strings.CutSuffix(key, ".builder")
+		if !ok {
+			continue
+		}
+		b, err := common.LoadSwiftRingBuilder(data)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s from ConfigMap %s: %w", key, cm.Name, err)
+		}
+		builders[name] = b
+	}
+	return builders, nil
+}
+
+// ringOrdinals returns the ordinals of the storage pods whose devices are
+// in a ring.
+func (r *SwiftReconciler) ringOrdinals(ctx context.Context, instance *openstackv1alpha1.Swift) (map[int]bool, error) {
+	builders, err := r.loadRingBuilders(ctx, instance)
+	if err != nil {
+		return nil, err
+	}
+	ordinals := map[int]bool{}
+	for _, b := range builders {
+		for _, dev := range b.Devices {
+			if dev == nil {
+				continue
+			}
+			if ordinal, ok := swiftStorageOrdinal(instance, dev.Meta); ok {
+				ordinals[ordinal] = true
+			}
+		}
+	}
+	return ordinals, nil
+}
+
+// saveRings stores the builders and writes the ring files generated from
+// them. Both encode deterministically, so unchanged rings are not rewritten.
+// The builders are saved first so that the rings never get ahead of them,
+// and nothing is saved if either ConfigMap would exceed its size limit.
+// Like the storage PVCs, whose data they place, the builders are not owned
+// by the Swift and survive its deletion; the ring files are regenerated.
+func (r *SwiftReconciler) saveRings(ctx context.Context, instance *openstackv1alpha1.Swift, builders map[string]*common.SwiftRingBuilder) error {
+	builderData := map[string][]byte{}
+	ringData := map[string][]byte{}
+	for name, b := range builders {
+		saved, err := b.Save()
+		if err != nil {
+			return err
+		}
+		builderData[name+".builder"] = saved
+		ring, err := b.RingData()
+		if err != nil {
+			return err
+		}
+		ringData[name+".ring.gz"] = ring
+	}
+	configMaps := []struct {
+		name  string
+		data  map[string][]byte
+		owned bool
+	}{
+		{swiftRingBuildersName(instance), builderData, false},
+		{swiftRingsName(instance), ringData, true},
+	}
+	for _, cm := range configMaps {
+		if err := checkSwiftRingConfigMapSize(cm.name, cm.data); err != nil {
+			return err
+		}
+	}
+	for _, cm := range configMaps {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: cm.name, Namespace: instance.Namespace},
+		}
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+			configMap.Labels = labelsForSwift(instance.Name, "rings")
+			configMap.BinaryData = cm.data
+			if !cm.owned {
+				// Builders saved by earlier versions are owned by the Swift.
+				configMap.OwnerReferences = slices.DeleteFunc(configMap.OwnerReferences, func(ref metav1.OwnerReference) bool {
+					return ref.UID == instance.UID
+				})
+				return nil
+			}
+			return controllerutil.SetControllerReference(instance, configMap, r.Scheme)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSwiftRingConfigMapSize returns errSwiftRingsTooLarge if data does not
+// fit in a ConfigMap.
+func checkSwiftRingConfigMapSize(name string, data map[string][]byte) error {
+	size := 0
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	if size > swiftRingConfigMapMaxSize {
+		return fmt.Errorf("%w: ConfigMap %s would hold %d bytes, more than the %d allowed; a lower rings.partPower, fewer storage policies or fewer erasure coding fragments make it smaller",
+			errSwiftRingsTooLarge, name, size, swiftRingConfigMapMaxSize)
+	}
+	return nil
+}
+
+func swiftRingsName(instance *openstackv1alpha1.Swift) string {
+	return instance.Name + "-rings"
+}
+
+func swiftRingBuildersName(instance *openstackv1alpha1.Swift) string {
+	return instance.Name + "-ring-builders"
+}
@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestStepSwiftRingWeights(t *testing.T) {
+	instance := &openstackv1alpha1.Swift{ObjectMeta: metav1.ObjectMeta{Name: "swift", Namespace: "openstack"}}
+	storageReplicas := int32(2)
+	instance.Spec.StorageReplicas = &storageReplicas
+
+	tests := []struct {
+		name string
+		// weights are the weights of the ring devices by ordinal.
+		weights    []float64
+		capacities map[int]float64
+		targets    map[int]float64
+		// want are the weights by ordinal after the step; absent ordinals
+		// must have no device.
+		want map[int]float64
+	}{
+		{
+			name:       "new device starts one step up",
+			weights:    []float64{1000},
+			capacities: map[int]float64{0: 1000, 1: 1000},
+			targets:    map[int]float64{0: 1000, 1: 1000},
+			want:       map[int]float64{0: 1000, 1: 250},
+		},
+		{
+			name:       "step up stops at the target",
+			weights:    []float64{900, 500},
+			capacities: map[int]float64{0: 1000, 1: 1000},
+			targets:    map[int]float64{0: 1000, 1: 1000},
+			want:       map[int]float64{0: 1000, 1: 750},
+		},
+		{
+			name:       "resized PVC",
+			weights:    []float64{1000, 1000},
+			capacities: map[int]float64{0: 1000, 1: 2000},
+			targets:    map[int]float64{0: 1000, 1: 2000},
+			want:       map[int]float64{0: 1000, 1: 1500},
+		},
+		{
+			name:       "unselected device drains by the capacity",
+			weights:    []float64{1000, 1000},
+			capacities: map[int]float64{0: 1000, 1: 1000},
+			targets:    map[int]float64{0: 1000},
+			want:       map[int]float64{0: 1000, 1: 750},
+		},
+		{
+			name:       "drained device without partitions is removed",
+			weights:    []float64{1000, 0},
+			capacities: map[int]float64{0: 1000, 1: 1000},
+			targets:    map[int]float64{0: 1000},
+			want:       map[int]float64{0: 1000},
+		},
+		{
+			name:       "device of a removed pod",
+			weights:    []float64{1000, 1000, 1000},
+			capacities: map[int]float64{0: 1000, 1: 1000},
+			targets:    map[int]float64{0: 1000, 1: 1000},
+			want:       map[int]float64{0: 1000, 1: 1000},
+		},
+		{
+			name:       "unbound PVC keeps its weight",
+			weights:    []float64{1000, 1000},
+			capacities: map[int]float64{0: 1000},
+			targets:    map[int]float64{0: 1000},
+			want:       map[int]float64{0: 1000, 1: 1000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := common.NewSwiftRingBuilder(6, 1, 1)
+			for ordinal, weight := range tt.weights {
+				b.AddDevice(swiftRingDevice(instance, ordinal, swiftObjectPort, weight))
+			}
+			stepSwiftRingWeights(instance, b, swiftObjectPort, tt.capacities, tt.targets, 25)
+
+			got := map[int]float64{}
+			for _, dev := range b.Devices {
+				if dev == nil {
+					continue
+				}
+				ordinal, ok := swiftStorageOrdinal(instance, dev.Meta)
+				if !ok {
+					t.Fatalf("device %d has meta %q", dev.ID, dev.Meta)
+				}
+				got[ordinal] = dev.Weight
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("weights = %v, want %v", got, tt.want)
+			}
+			for ordinal, want := range tt.want {
+				if weight, ok := got[ordinal]; !ok || weight != want {
+					t.Errorf("weight of ordinal %d = %v (present %v), want %v", ordinal, weight, ok, want)
+				}
+			}
+			converged := swiftRingConverged(instance, b, tt.targets)
+			if wantConverged := maps.Equal(tt.want, tt.targets); converged != wantConverged {
+				t.Errorf("converged = %v, want %v", converged, wantConverged)
+			}
+		})
+	}
+}
+
+func TestSaveRings(t *testing.T) {
+	ctx := context.Background()
+	instance := &openstackv1alpha1.Swift{ObjectMeta: metav1.ObjectMeta{Name: "swift", Namespace: "openstack", UID: "swift-uid"}}
+	controller := true
+	owner := metav1.OwnerReference{APIVersion: "openstack.k8s.io/v1alpha1", Kind: "Swift", Name: "swift", UID: "swift-uid", Controller: &controller}
+	// Builders saved by an earlier version, owned by the Swift.
+	saved := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "swift-ring-builders", Namespace: "openstack", OwnerReferences: []metav1.OwnerReference{owner}}}
+	r := &SwiftReconciler{Client: newFakeClient(t, instance, saved), Scheme: testScheme}
+
+	b := common.NewSwiftRingBuilder(6, 1, 1)
+	b.AddDevice(swiftRingDevice(instance, 0, swiftObjectPort, 1000))
+	if _, err := b.Rebalance(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.saveRings(ctx, instance, map[string]*common.SwiftRingBuilder{"object": b}); err != nil {
+		t.Fatal(err)
+	}
+
+	builders := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Name: "swift-ring-builders", Namespace: "openstack"}, builders); err != nil {
+		t.Fatal(err)
+	}
+	if len(builders.OwnerReferences) != 0 {
+		t.Errorf("builders owned by %v; they must survive the Swift like the PVCs", builders.OwnerReferences)
+	}
+	if _, ok := builders.BinaryData["object.builder"]; !ok {
+		t.Error("object.builder not saved")
+	}
+	rings := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Name: "swift-rings", Namespace: "openstack"}, rings); err != nil {
+		t.Fatal(err)
+	}
+	if len(rings.OwnerReferences) != 1 || rings.OwnerReferences[0].UID != instance.UID {
+		t.Errorf("rings owned by %v, want the Swift", rings.OwnerReferences)
+	}
+
+	// The saved builders are what a recreated Swift starts from.
+	loaded, err := r.loadRingBuilders(ctx, instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded["object"] == nil || loaded["object"].Version != b.Version {
+		t.Errorf("loaded builders = %v", loaded)
+	}
+}
+
+func TestCheckSwiftRingConfigMapSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "at the limit", data: map[string][]byte{"object.builder": make([]byte, swiftRingConfigMapMaxSize-len("object.builder"))}},
+		{
+			name: "over the limit in total",
+			data: map[string][]byte{
+				"object.builder":   make([]byte, swiftRingConfigMapMaxSize/2),
+				"object-1.builder": make([]byte, swiftRingConfigMapMaxSize/2),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSwiftRingConfigMapSize("swift-ring-builders", tt.data)
+			if got := errors.Is(err, errSwiftRingsTooLarge); got != tt.wantErr {
+				t.Errorf("error = %v, want too large %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSwiftStoragePolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		legacy   *openstackv1alpha1.SwiftLegacyStoragePolicy
+		policies []openstackv1alpha1.SwiftStoragePolicy
+		want     []openstackv1alpha1.SwiftStoragePolicy
+	}{
+		{
+			name: "default",
+			want: []openstackv1alpha1.SwiftStoragePolicy{{Name: "Policy-0", Default: true, Type: "replication", ReplicaCount: 3}},
+		},
+		{
+			name:   "deprecated storagePolicy",
+			legacy: &openstackv1alpha1.SwiftLegacyStoragePolicy{ReplicaCount: 2},
+			want:   []openstackv1alpha1.SwiftStoragePolicy{{Name: "Policy-0", Default: true, Type: "replication", ReplicaCount: 2}},
+		},
+		{
+			name:   "deprecated storagePolicy without replica count",
+			legacy: &openstackv1alpha1.SwiftLegacyStoragePolicy{},
+			want:   []openstackv1alpha1.SwiftStoragePolicy{{Name: "Policy-0", Default: true, Type: "replication", ReplicaCount: 3}},
+		},
+		{
+			name: "policies by index",
+			policies: []openstackv1alpha1.SwiftStoragePolicy{
+				{Name: "ec", Index: 2, Type: "erasure_coding", ECType: "isa_l_rs_vand", ECDataFragments: 4, ECParityFragments: 2},
+				{Name: "gold", Index: 0, Default: true},
+			},
+			want: []openstackv1alpha1.SwiftStoragePolicy{
+				{Name: "gold", Index: 0, Default: true, Type: "replication", ReplicaCount: 3},
+				{Name: "ec", Index: 2, Type: "erasure_coding", ReplicaCount: 3, ECType: "isa_l_rs_vand", ECDataFragments: 4, ECParityFragments: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &openstackv1alpha1.Swift{}
+			instance.Spec.StoragePolicy = tt.legacy
+			instance.Spec.StoragePolicies = tt.policies
+			got := swiftStoragePolicies(instance)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("policies = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+	"github.com/mrrauch/openstack-operator/internal/images"
+)
+
+// swiftGID is the swift group of the Kolla images; it owns the devices.
+const swiftGID = 42445
+
+// swiftDaemon is a Swift process run as a container of the storage pods.
+type swiftDaemon struct {
+	name    string
+	image   string
+	command []string
+}
+
+// swiftStorageDaemons returns the servers and background daemons of the
+// account, container and object rings. Erasure coded policies are repaired
+// by the object reconstructor rather than the replicator.
+func swiftStorageDaemons(instance *openstackv1alpha1.Swift) []swiftDaemon {
+	objectDaemons := []string{"server", "replicator", "auditor", "updater"}
+	for _, policy := range swiftStoragePolicies(instance) {
+		if policy.Type == "erasure_coding" {
+			objectDaemons = append(objectDaemons, "reconstructor")
+			break
+		}
+	}
+	var daemons []swiftDaemon
+	for _, ring := range []struct {
+		name    string
+		image   string
+		daemons []string
+	}{
+		{"account", images.DefaultSwiftAccount, []string{"server", "replicator", "auditor"}},
+		{"container", images.DefaultSwiftContainer, []string{"server", "replicator", "auditor", "updater"}},
+		{"object", images.DefaultSwiftObject, objectDaemons},
+	} {
+		for _, daemon := range ring.daemons {
+			name := ring.name + "-" + daemon
+			daemons = append(daemons, swiftDaemon{
+				name:    name,
+				image:   ring.image,
+				command: []string{"swift-" + name, "/etc/swift/" + ring.name + "-server.conf"},
+			})
+		}
+	}
+	return daemons
+}
+
+// ensureStorageService creates the headless Service giving each storage pod
+// the stable DNS name its devices are registered under in the rings. Not
+// ready addresses are published so that replication reaches pods that are
+// still starting.
+func (r *SwiftReconciler) ensureStorageService(ctx context.Context, instance *openstackv1alpha1.Swift) error {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: instance.Name + "-storage", Namespace: instance.Namespace},
+	}
+	labels := labelsForSwift(instance.Name, "storage")
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		svc.Labels = labels
+		svc.Spec.ClusterIP = corev1.ClusterIPNone
+		svc.Spec.PublishNotReadyAddresses = true
+		svc.Spec.Selector = labels
+		svc.Spec.Ports = []corev1.ServicePort{
+			{Name: "object", Port: swiftObjectPort, TargetPort: intstr.FromInt32(swiftObjectPort), Protocol: corev1.ProtocolTCP},
+			{Name: "container", Port: swiftContainerPort, TargetPort: intstr.FromInt32(swiftContainerPort), Protocol: corev1.ProtocolTCP},
+			{Name: "account", Port: swiftAccountPort, TargetPort: intstr.FromInt32(swiftAccountPort), Protocol: corev1.ProtocolTCP},
+			{Name: "rsync", Port: swiftRsyncPort, TargetPort: intstr.FromInt32(swiftRsyncPort), Protocol: corev1.ProtocolTCP},
+		}
+		return controllerutil.SetControllerReference(instance, svc, r.Scheme)
+	})
+	return err
+}
+
+// ensureStorage creates the StatefulSet of storage pods. Each pod holds one
+// device, its PVC, mounted as d0 under the Swift devices directory. Pods
+// beyond StorageReplicas are kept while their devices are still in a ring,
+// so that scaling down only removes a pod once its partitions have moved.
+func (r *SwiftReconciler) ensureStorage(ctx context.Context, instance *openstackv1alpha1.Swift, configHash string) error {
+	name := instance.Name + "-storage"
+	labels := labelsForSwift(instance.Name, "storage")
+
+	replicas := swiftStorageReplicas(instance)
+	inRings, err := r.ringOrdinals(ctx, instance)
+	if err != nil {
+		return err
+	}
+	for ordinal := range inRings {
+		replicas = max(replicas, int32(ordinal)+1)
+	}
+
+	storageSize := instance.Spec.Storage.Size
+	if storageSize.IsZero() {
+		storageSize = resource.MustParse("10Gi")
+	}
+
+	mounts := []corev1.VolumeMount{
+		swiftConfigVolumeMount(),
+		{Name: "data", MountPath: "/srv/node/d0"},
+		{Name: "cache", MountPath: "/var/cache/swift"},
+	}
+	var containers []corev1.Container
+	for _, daemon := range swiftStorageDaemons(instance) {
+		containers = append(containers, corev1.Container{
+			Name:         daemon.name,
+			Image:        daemon.image,
+			Command:      daemon.command,
+			Resources:    instance.Spec.Resources,
+			VolumeMounts: mounts,
+		})
+	}
+	for i := range containers {
+		switch containers[i].Name {
+		case "object-server":
+			containers[i].Ports = []corev1.ContainerPort{{ContainerPort: swiftObjectPort, Name: "object"}}
+		case "container-server":
+			containers[i].Ports = []corev1.ContainerPort{{ContainerPort: swiftContainerPort, Name: "container"}}
+		case "account-server":
+			containers[i].Ports = []corev1.ContainerPort{{ContainerPort: swiftAccountPort, Name: "account"}}
+		default:
+			continue
+		}
+		containers[i].ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/healthcheck", Port: intstr.FromInt32(containers[i].Ports[0].ContainerPort)},
+			},
+			InitialDelaySeconds: 5,
+			PeriodSeconds:       10,
+		}
+	}
+	// rsync serves the devices to the replicators of the other pods; it
+	// needs root to switch to the swift user.
+	root := int64(0)
+	containers = append(containers, corev1.Container{
+		Name:            "rsyncd",
+		Image:           images.DefaultSwiftRsyncd,
+		Command:         []string{"rsync", "--daemon", "--no-detach", "--config=/etc/swift/rsyncd.conf"},
+		VolumeMounts:    mounts[:2],
+		Ports:           []corev1.ContainerPort{{ContainerPort: swiftRsyncPort, Name: "rsync"}},
+		SecurityContext: &corev1.SecurityContext{RunAsUser: &root},
+	})
+
+	fsGroup := int64(swiftGID)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: instance.Namespace},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, sts, func() error {
+		sts.Labels = labels
+		sts.Spec.Replicas = &replicas
+		if sts.CreationTimestamp.IsZero() {
+			sts.Spec.ServiceName = name
+			sts.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+			sts.Spec.PodManagementPolicy = appsv1.ParallelPodManagement
+			sts.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "data", Labels: labels},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+						Resources: corev1.VolumeResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceStorage: storageSize},
+						},
+						StorageClassName: instance.Spec.Storage.StorageClassName,
+					},
+				},
+			}
+		}
+		sts.Spec.Template.Labels = labels
+		sts.Spec.Template.Annotations = map[string]string{common.ConfigHashAnnotation: configHash}
+		sts.Spec.Template.Spec.NodeSelector = instance.Spec.NodeSelector
+		sts.Spec.Template.Spec.Affinity = spreadAffinity(labels)
+		sts.Spec.Template.Spec.SecurityContext = &corev1.PodSecurityContext{FSGroup: &fsGroup}
+		sts.Spec.Template.Spec.Volumes = []corev1.Volume{swiftConfigVolume(instance), emptyDirVolume("cache")}
+		sts.Spec.Template.Spec.Containers = containers
+		return controllerutil.SetControllerReference(instance, sts, r.Scheme)
+	})
+	return err
+}
+
+// swiftStorageReplicas returns the desired number of storage pods.
+func swiftStorageReplicas(instance *openstackv1alpha1.Swift) int32 {
+	if instance.Spec.StorageReplicas != nil {
+		return *instance.Spec.StorageReplicas
+	}
+	return 3
+}
+
+// swiftStorageHost returns the DNS name of a storage pod, under which its
+// device is registered in the rings.
+func swiftStorageHost(instance *openstackv1alpha1.Swift, ordinal int) string {
+	return fmt.Sprintf("%s-storage-%d.%s-storage.%s.svc", instance.Name, ordinal, instance.Name, instance.Namespace)
+}
@@ -0,0 +1,132 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	openstackv1alpha1 "github.com/mrrauch/openstack-operator/api/v1alpha1"
+	"github.com/mrrauch/openstack-operator/internal/common"
+)
+
+func TestSwiftEnsureStorage(t *testing.T) {
+	ctx := context.Background()
+	replicas := int32(2)
+	instance := &openstackv1alpha1.Swift{ObjectMeta: metav1.ObjectMeta{Name: "swift", Namespace: "openstack", UID: "swift-uid"}}
+	instance.Spec.StorageReplicas = &replicas
+	instance.Spec.Storage.Size = resource.MustParse("5Gi")
+	r := &SwiftReconciler{Client: newFakeClient(t, instance), Scheme: testScheme}
+
+	// The device of ordinal 2 is still in the ring after a scale down.
+	b := common.NewSwiftRingBuilder(6, 1, 1)
+	for ordinal := range 3 {
+		b.AddDevice(swiftRingDevice(instance, ordinal, swiftObjectPort, 1000))
+	}
+	if _, err := b.Rebalance(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.saveRings(ctx, instance, map[string]*common.SwiftRingBuilder{"object": b}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.ensureStorage(ctx, instance, "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Name: "swift-storage", Namespace: "openstack"}, sts); err != nil {
+		t.Fatal(err)
+	}
+	if *sts.Spec.Replicas != 3 {
+		t.Errorf("replicas = %d, want 3 while ordinal 2 is in a ring", *sts.Spec.Replicas)
+	}
+	if sts.Spec.ServiceName != "swift-storage" {
+		t.Errorf("service = %q", sts.Spec.ServiceName)
+	}
+	if claims := sts.Spec.VolumeClaimTemplates; len(claims) != 1 || claims[0].Name != "data" ||
+		!claims[0].Spec.Resources.Requests.Storage().Equal(resource.MustParse("5Gi")) {
+		t.Errorf("claims = %+v, want one 5Gi data device", claims)
+	}
+	pod := sts.Spec.Template
+	if pod.Annotations[common.ConfigHashAnnotation] != "hash-1" {
+		t.Errorf("annotations = %v", pod.Annotations)
+	}
+	if pod.Spec.SecurityContext == nil || *pod.Spec.SecurityContext.FSGroup != swiftGID {
+		t.Errorf("security context = %+v, want the devices owned by the swift group", pod.Spec.SecurityContext)
+	}
+
+	// The rings are projected next to the config; the servers reload them
+	// in place, so they do not restart the pods.
+	var projected []string
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name != "swift" {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			switch {
+			case source.Secret != nil:
+				projected = append(projected, source.Secret.Name)
+			case source.ConfigMap != nil:
+				projected = append(projected, source.ConfigMap.Name)
+			}
+		}
+	}
+	if len(projected) != 2 || projected[0] != "swift-config" || projected[1] != "swift-rings" {
+		t.Errorf("/etc/swift holds %v, want swift-config and swift-rings", projected)
+	}
+
+	containers := map[string]corev1.Container{}
+	for _, container := range pod.Spec.Containers {
+		containers[container.Name] = container
+	}
+	for _, name := range []string{"account-server", "container-updater", "object-server", "object-replicator", "rsyncd"} {
+		if _, ok := containers[name]; !ok {
+			t.Errorf("container %s missing", name)
+		}
+	}
+	if _, ok := containers["object-reconstructor"]; ok {
+		t.Error("object-reconstructor without an erasure coded policy")
+	}
+	for name, container := range containers {
+		mounts := map[string]string{}
+		for _, mount := range container.VolumeMounts {
+			mounts[mount.MountPath] = mount.Name
+		}
+		if mounts["/etc/swift"] != "swift" || mounts["/srv/node/d0"] != "data" {
+			t.Errorf("%s mounts = %v, want the config and device d0", name, mounts)
+		}
+		if _, ok := mounts["/var/cache/swift"]; ok == (name == "rsyncd") {
+			t.Errorf("%s mounts = %v", name, mounts)
+		}
+	}
+
+	// A new config rolls the pods.
+	if err := r.ensureStorage(ctx, instance, "hash-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(sts), sts); err != nil {
+		t.Fatal(err)
+	}
+	if sts.Spec.Template.Annotations[common.ConfigHashAnnotation] != "hash-2" {
+		t.Errorf("annotations = %v", sts.Spec.Template.Annotations)
+	}
+}
+
+func TestSwiftStorageDaemonsErasureCoding(t *testing.T) {
+	instance := &openstackv1alpha1.Swift{}
+	instance.Spec.StoragePolicies = []openstackv1alpha1.SwiftStoragePolicy{{Name: "gold"}, {Name: "ec", Type: "erasure_coding"}}
+	for _, daemon := range swiftStorageDaemons(instance) {
+		if daemon.name == "object-reconstructor" {
+			if daemon.command[1] != "/etc/swift/object-server.conf" {
+				t.Errorf("command = %v", daemon.command)
+			}
+			return
+		}
+	}
+	t.Error("object-reconstructor not run for an erasure coded policy")
+}
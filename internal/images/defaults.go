@@ -0,0 +1,57 @@
+package images
+
+// Default container images for OpenStack services.
+// All images are from the Kolla project for the 2025.1 (Epoxy) release.
+const (
+	DefaultMariaDB                  = "quay.io/openstack.kolla/mariadb-server:2025.1"
+	DefaultRabbitMQ                 = "quay.io/openstack.kolla/rabbitmq:2025.1"
+	DefaultMemcached                = "quay.io/openstack.kolla/memcached:2025.1"
+	DefaultKeystone                 = "quay.io/openstack.kolla/keystone:2025.1"
+	DefaultGlanceAPI                = "quay.io/openstack.kolla/glance-api:2025.1"
+	DefaultPlacement                = "quay.io/openstack.kolla/placement-api:2025.1"
+	DefaultNeutronServer            = "quay.io/openstack.kolla/neutron-server:2025.1"
+	DefaultNeutronOVNVPNAgent       = "quay.io/openstack.kolla/neutron-ovn-vpn-agent:2025.1"
+	DefaultNovaAPI                  = "quay.io/openstack.kolla/nova-api:2025.1"
+	DefaultNovaScheduler            = "quay.io/openstack.kolla/nova-scheduler:2025.1"
+	DefaultNovaConductor            = "quay.io/openstack.kolla/nova-conductor:2025.1"
+	DefaultNovaCompute              = "quay.io/openstack.kolla/nova-compute:2025.1"
+	DefaultNovaNoVNCProxy           = "quay.io/openstack.kolla/nova-novncproxy:2025.1"
+	DefaultNovaLibvirt              = "quay.io/openstack.kolla/nova-libvirt:2025.1"
+	DefaultNovaSSH                  = "quay.io/openstack.kolla/nova-ssh:2025.1"
+	DefaultCinderAPI                = "quay.io/openstack.kolla/cinder-api:2025.1"
+	DefaultCinderScheduler          = "quay.io/openstack.kolla/cinder-scheduler:2025.1"
+	DefaultCinderVolume             = "quay.io/openstack.kolla/cinder-volume:2025.1"
+	DefaultCinderBackup             = "quay.io/openstack.kolla/cinder-backup:2025.1"
+	DefaultHeatAPI                  = "quay.io/openstack.kolla/heat-api:2025.1"
+	DefaultHeatAPICFN               = "quay.io/openstack.kolla/heat-api-cfn:2025.1"
+	DefaultHeatEngine               = "quay.io/openstack.kolla/heat-engine:2025.1"
+	DefaultBarbicanAPI              = "quay.io/openstack.kolla/barbican-api:2025.1"
+	DefaultBarbicanWorker           = "quay.io/openstack.kolla/barbican-worker:2025.1"
+	DefaultBarbicanKeystoneListener = "quay.io/openstack.kolla/barbican-keystone-listener:2025.1"
+	DefaultSwiftProxy               = "quay.io/openstack.kolla/swift-proxy-server:2025.1"
+	DefaultSwiftAccount             = "quay.io/openstack.kolla/swift-account:2025.1"
+	DefaultSwiftContainer           = "quay.io/openstack.kolla/swift-container:2025.1"
+	DefaultSwiftObject              = "quay.io/openstack.kolla/swift-object:2025.1"
+	DefaultSwiftRsyncd              = "quay.io/openstack.kolla/swift-rsyncd:2025.1"
+	DefaultOctaviaAPI               = "quay.io/openstack.kolla/octavia-api:2025.1"
+	DefaultOctaviaWorker            = "quay.io/openstack.kolla/octavia-worker:2025.1"
+	DefaultOctaviaHealthManager     = "quay.io/openstack.kolla/octavia-health-manager:2025.1"
+	DefaultOctaviaHousekeeping      = "quay.io/openstack.kolla/octavia-housekeeping:2025.1"
+	DefaultOctaviaDriverAgent       = "quay.io/openstack.kolla/octavia-driver-agent:2025.1"
+	DefaultTGTD                     = "quay.io/openstack.kolla/tgtd:2025.1"
+	DefaultOVNNorthd                = "quay.io/openstack.kolla/ovn-northd:2025.1"
+	DefaultOVNNBDB                  = "quay.io/openstack.kolla/ovn-nb-db-server:2025.1"
+	DefaultOVNSBDB                  = "quay.io/openstack.kolla/ovn-sb-db-server:2025.1"
+	DefaultOVNController            = "quay.io/openstack.kolla/ovn-controller:2025.1"
+	DefaultOpenvSwitchDB            = "quay.io/openstack.kolla/openvswitch-db-server:2025.1"
+	DefaultOpenvSwitchd             = "quay.io/openstack.kolla/openvswitch-vswitchd:2025.1"
+	DefaultNeutronOVNMetadata       = "quay.io/openstack.kolla/neutron-ovn-metadata-agent:2025.1"
+)
+
+// ImageOrDefault returns the image if non-empty, otherwise the defaultImage.
+func ImageOrDefault(image, defaultImage string) string {
+	if image != "" {
+		return image
+	}
+	return defaultImage
+}
@@ -0,0 +1,22 @@
+#!/bin/sh
+# Starts cinder-volume for a single backend. iSCSI targets are exported on
+# the node address, which is only known in the pod. Environment: HOST_IP
+set -e
+
+mkdir -p /var/lib/cinder/tmp
+{{- if eq .TargetHelper "tgtadm" }}
+mkdir -p /var/lib/cinder/volumes
+{{- end }}
+{{- if eq .Type "lvm" }}
+cat > /var/lib/cinder/host.conf <<CONF
+[{{ .Name }}]
+target_ip_address = ${HOST_IP}
+CONF
+{{- else }}
+: > /var/lib/cinder/host.conf
+{{- end }}
+
+exec cinder-volume \
+    --config-file /etc/cinder/cinder.conf \
+    --config-file /etc/cinder/cinder-volume-{{ .Name }}.conf \
+    --config-file /var/lib/cinder/host.conf
@@ -0,0 +1,61 @@
+#!/bin/sh
+# Runs libvirtd. With Ceph ephemeral disks or volumes it also defines the
+# libvirt secret holding the client key, which qemu uses to open RBD disks
+# and volumes; its UUID is rbd_secret_uuid in nova.conf and cinder.conf.
+# Environment: NODE_NAME, HOST_IP
+set -e
+{{- if and .Migration (eq .Migration.Transport "tls") }}
+
+# Request this host's certificate for its node name and migration address
+# from the operator, which signs it with the migration CA; the key never
+# leaves the host. libvirtd and QEMU use it as server and client, and trust
+# only the migration CA.
+addr=$(sh /usr/local/bin/migration-addr.sh)
+mkdir -p /etc/pki/CA /etc/pki/libvirt/private /etc/pki/qemu
+cp /etc/nova/migration/ca.crt /etc/pki/CA/cacert.pem
+openssl req -new -newkey rsa:3072 -nodes -subj "/CN=${NODE_NAME}" \
+    -addext "subjectAltName=DNS:${NODE_NAME},IP:${addr}" \
+    -keyout /etc/pki/qemu/server-key.pem -out /tmp/migration.csr
+chmod 600 /etc/pki/qemu/server-key.pem
+python3 /usr/local/bin/migration-cert.py /tmp/migration.csr > /tmp/migration.crt
+mv /tmp/migration.crt /etc/pki/qemu/server-cert.pem
+cp /etc/pki/CA/cacert.pem /etc/pki/qemu/ca-cert.pem
+cp /etc/pki/qemu/server-cert.pem /etc/pki/qemu/client-cert.pem
+cp /etc/pki/qemu/server-key.pem /etc/pki/qemu/client-key.pem
+cp /etc/pki/qemu/server-cert.pem /etc/pki/libvirt/servercert.pem
+cp /etc/pki/qemu/server-cert.pem /etc/pki/libvirt/clientcert.pem
+cp /etc/pki/qemu/server-key.pem /etc/pki/libvirt/private/serverkey.pem
+cp /etc/pki/qemu/server-key.pem /etc/pki/libvirt/private/clientkey.pem
+grep -q '^default_tls_x509_verify' /etc/libvirt/qemu.conf || echo 'default_tls_x509_verify = 1' >> /etc/libvirt/qemu.conf
+
+/usr/sbin/libvirtd --listen &
+{{- else if .Migration }}
+
+# libvirtd runs ssh to reach the destination's nova-ssh. ssh only accepts a
+# private key file nobody else can read, and verifies the shared host key.
+install -d -m 700 /root/.ssh
+install -m 600 /etc/nova/migration/id_ecdsa /root/.ssh/id_ecdsa
+install -m 644 /etc/nova/migration/known_hosts /etc/ssh/ssh_known_hosts
+
+/usr/sbin/libvirtd &
+{{- else }}
+
+/usr/sbin/libvirtd &
+{{- end }}
+pid=$!
+{{- with .Ceph }}
+
+until [ -S /run/libvirt/libvirt-sock ]; do sleep 1; done
+cat > /tmp/ceph-secret.xml <<XML
+<secret ephemeral='no' private='no'>
+  <uuid>{{ .SecretUUID }}</uuid>
+  <usage type='ceph'>
+    <name>client.{{ .User }} secret</name>
+  </usage>
+</secret>
+XML
+virsh secret-define --file /tmp/ceph-secret.xml
+virsh secret-set-value --secret {{ .SecretUUID }} --base64 "$(awk '$1 == "key" {print $3}' /etc/ceph/ceph.client.{{ .User }}.keyring)"
+{{- end }}
+
+wait "$pid"
@@ -0,0 +1,21 @@
+#!/bin/sh
+# Prints the address other compute hosts reach this host on for live
+# migration: its address in the migration network if one is configured,
+# otherwise HOST_IP. Environment: HOST_IP
+set -e
+{{- if .Migration.NetworkCIDR }}
+
+ip -o addr show | awk '{print $4}' | python3 -c '
+import ipaddress, sys
+net = ipaddress.ip_network(sys.argv[1], strict=False)
+for iface in sys.stdin.read().split():
+    addr = ipaddress.ip_interface(iface).ip
+    if addr in net:
+        print(addr)
+        sys.exit(0)
+sys.exit("no address in migration network %s" % net)
+' {{ .Migration.NetworkCIDR }}
+{{- else }}
+
+echo "${HOST_IP}"
+{{- end }}
@@ -0,0 +1,60 @@
+#!/usr/bin/env python3
+# Submits this host's live migration certificate request to the Kubernetes
+# CSR API as the compute service account and prints the certificate once
+# the operator has signed it. Arguments: the PEM request. Environment:
+# NODE_NAME
+import base64
+import json
+import os
+import ssl
+import sys
+import time
+import urllib.request
+
+SA = "/var/run/secrets/kubernetes.io/serviceaccount"
+API = "https://%s:%s/apis/certificates.k8s.io/v1/certificatesigningrequests" % (
+    os.environ["KUBERNETES_SERVICE_HOST"], os.environ["KUBERNETES_SERVICE_PORT"])
+CONTEXT = ssl.create_default_context(cafile=SA + "/ca.crt")
+
+
+def call(method, url, body=None):
+    # The projected token is rotated, so read it for every call.
+    with open(SA + "/token") as f:
+        token = f.read().strip()
+    data = json.dumps(body).encode() if body is not None else None
+    req = urllib.request.Request(url, data=data, method=method, headers={
+        "Authorization": "Bearer " + token,
+        "Content-Type": "application/json",
+    })
+    with urllib.request.urlopen(req, context=CONTEXT) as resp:
+        return json.load(resp)
+
+
+with open(sys.argv[1], "rb") as f:
+    request = base64.b64encode(f.read()).decode()
+
+csr = call("POST", API, {
+    "apiVersion": "certificates.k8s.io/v1",
+    "kind": "CertificateSigningRequest",
+    "metadata": {
+        "generateName": "{{ .Migration.CSRPrefix }}%s-" % os.environ["NODE_NAME"],
+        "labels": {"{{ .Migration.OwnerLabel }}": "{{ .Migration.Owner }}"},
+    },
+    "spec": {
+        "request": request,
+        "signerName": "{{ .Migration.Signer }}",
+        "usages": ["digital signature", "key encipherment", "server auth", "client auth"],
+    },
+})
+name = csr["metadata"]["name"]
+print("submitted certificate request %s" % name, file=sys.stderr)
+
+while True:
+    status = call("GET", API + "/" + name).get("status", {})
+    for cond in status.get("conditions", []):
+        if cond["type"] in ("Denied", "Failed") and cond["status"] == "True":
+            sys.exit("certificate request %s %s: %s" % (name, cond["type"].lower(), cond.get("message", "")))
+    if status.get("certificate"):
+        sys.stdout.write(base64.b64decode(status["certificate"]).decode())
+        break
+    time.sleep(5)
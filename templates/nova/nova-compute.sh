@@ -0,0 +1,27 @@
+#!/bin/sh
+# Starts nova-compute with this node's identity. The node name is the Nova
+# host name, matching the OVN chassis hostname, so service records and port
+# bindings survive pod restarts. Environment: NODE_NAME, HOST_IP
+set -e
+
+mkdir -p /var/lib/nova/instances /var/lib/nova/tmp
+{{- if .Migration }}
+migration_addr=$(sh /etc/nova/migration-addr.sh)
+{{- end }}
+cat > /var/lib/nova/host.conf <<CONF
+[DEFAULT]
+host = ${NODE_NAME}
+my_ip = ${HOST_IP}
+
+[vnc]
+server_proxyclient_address = ${HOST_IP}
+{{- if .Migration }}
+
+[libvirt]
+live_migration_inbound_addr = ${migration_addr}
+{{- end }}
+CONF
+
+until [ -S /run/libvirt/libvirt-sock ]; do sleep 1; done
+
+exec nova-compute --config-file /etc/nova/nova.conf --config-file /var/lib/nova/host.conf
@@ -0,0 +1,10 @@
+#!/bin/sh
+# Runs sshd for live migration. Source libvirtd connects as root with the
+# shared migration key, verifying the shared host key, and reaches this
+# host's libvirt socket.
+set -e
+
+mkdir -p /run/sshd
+# sshd ignores host keys that others can read.
+install -m 600 /etc/nova/migration/ssh_host_ecdsa_key /etc/ssh/ssh_host_ecdsa_key
+exec /usr/sbin/sshd -D -e -f /etc/nova/sshd_config
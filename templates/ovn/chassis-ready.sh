@@ -0,0 +1,15 @@
+#!/bin/sh
+# Readiness probe for the chassis DaemonSets: the Open vSwitch daemon named
+# by $1 is ready once it answers on its control socket, ovn-controller once
+# it is connected to the southbound database.
+set -e
+
+case "$1" in
+  ovn-controller)
+    ovn-appctl --timeout=5 -t /run/ovn/ovn-controller.ctl connection-status | grep -q "^connected$"
+    ;;
+  *)
+    # Found by the pidfile the daemon writes to /run/openvswitch.
+    OVS_RUNDIR=/run/openvswitch ovs-appctl --timeout=5 -t "$1" version >/dev/null
+    ;;
+esac
@@ -0,0 +1,38 @@
+#!/bin/sh
+# Registers this node as an OVN chassis and runs ovn-controller. Configured
+# through environment variables set by the operator:
+#   NODE_NAME, HOST_IP, SB_REMOTE, ENCAP_TYPE, BRIDGE_MAPPINGS, GATEWAY
+set -e
+
+until ovs-vsctl --timeout=5 show >/dev/null 2>&1; do sleep 1; done
+
+for mapping in $(echo "$BRIDGE_MAPPINGS" | tr ',' ' '); do
+  ovs-vsctl --may-exist add-br "${mapping#*:}"
+done
+
+# The node name is the system-id, so the chassis keeps its identity across
+# pod restarts and can be matched to its node.
+ovs-vsctl set open . \
+  external-ids:system-id="$NODE_NAME" \
+  external-ids:hostname="$NODE_NAME" \
+  external-ids:ovn-remote="\"${SB_REMOTE}\"" \
+  external-ids:ovn-encap-type="$ENCAP_TYPE" \
+  external-ids:ovn-encap-ip="$HOST_IP" \
+  external-ids:ovn-bridge-mappings="\"${BRIDGE_MAPPINGS}\""
+
+if [ "$GATEWAY" = "true" ]; then
+  ovs-vsctl set open . external-ids:ovn-cms-options=enable-chassis-as-gw
+else
+  ovs-vsctl remove open . external-ids ovn-cms-options
+fi
+
+SSL_ARGS=""
+case "$SB_REMOTE" in
+  ssl:*) SSL_ARGS="-p /etc/ovn/tls/tls.key -c /etc/ovn/tls/tls.crt -C /etc/ovn/tls/ca.crt" ;;
+esac
+
+# A fixed control socket lets chassis-ready.sh find ovn-controller.
+mkdir -p /run/ovn
+exec ovn-controller $SSL_ARGS unix:/run/openvswitch/db.sock \
+  --unixctl=/run/ovn/ovn-controller.ctl \
+  -vconsole:info -vfile:off
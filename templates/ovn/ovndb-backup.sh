@@ -0,0 +1,22 @@
+#!/bin/sh
+# Takes an ovsdb-client backup of both OVN databases into /backup and prunes
+# old snapshots. Configured through environment variables set by the operator:
+#   NB_REMOTE, SB_REMOTE (comma-separated for Raft), PROTO, RETENTION
+set -e
+
+SSL_ARGS=""
+if [ "$PROTO" = "ssl" ]; then
+  SSL_ARGS="--private-key=/etc/ovn/tls/tls.key --certificate=/etc/ovn/tls/tls.crt --ca-cert=/etc/ovn/tls/ca.crt"
+fi
+STAMP=$(date -u +%Y%m%d%H%M%S)
+
+# backup REMOTE DB_NAME PREFIX
+backup() {
+  ovsdb-client $SSL_ARGS --timeout=60 backup "$1" "$2" > "/backup/$3-${STAMP}.db.tmp"
+  mv "/backup/$3-${STAMP}.db.tmp" "/backup/$3-${STAMP}.db"
+  echo "wrote /backup/$3-${STAMP}.db"
+  ls -1t /backup/"$3"-*.db | tail -n +$((RETENTION + 1)) | xargs -r rm -f
+}
+
+backup "$NB_REMOTE" OVN_Northbound ovnnb_db
+backup "$SB_REMOTE" OVN_Southbound ovnsb_db
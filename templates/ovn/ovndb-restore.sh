@@ -0,0 +1,20 @@
+#!/bin/sh
+# Seeds the first member's volume from a backup snapshot before the database
+# StatefulSet is created. Configured through environment variables:
+#   DB_FILE_NAME, BACKUP_FILE, RAFT, LOCAL (Raft address of member 0)
+set -e
+
+DB_FILE=/var/lib/ovn/${DB_FILE_NAME}
+if [ -f "$DB_FILE" ]; then
+  echo "${DB_FILE} already exists, not restoring"
+  exit 0
+fi
+
+if [ "$RAFT" = "true" ]; then
+  # create-cluster accepts a standalone database, which is what
+  # ovsdb-client backup produces, as the initial cluster contents.
+  ovsdb-tool create-cluster "$DB_FILE" "/backup/${BACKUP_FILE}" "$LOCAL"
+else
+  cp "/backup/${BACKUP_FILE}" "$DB_FILE"
+fi
+echo "restored ${DB_FILE} from ${BACKUP_FILE}"
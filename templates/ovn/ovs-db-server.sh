@@ -0,0 +1,20 @@
+#!/bin/sh
+# Runs the node's Open vSwitch database. The database and socket live on the
+# host so that ovn-controller and nova-compute share one OVS instance.
+set -e
+
+SCHEMA=/usr/share/openvswitch/vswitch.ovsschema
+DB=/var/lib/openvswitch/conf.db
+mkdir -p /run/openvswitch /var/lib/openvswitch
+
+if [ ! -f "$DB" ]; then
+  ovsdb-tool create "$DB" "$SCHEMA"
+elif [ "$(ovsdb-tool needs-conversion "$DB" "$SCHEMA")" = "yes" ]; then
+  ovsdb-tool convert "$DB" "$SCHEMA"
+fi
+
+exec ovsdb-server "$DB" \
+  --remote=punix:/run/openvswitch/db.sock \
+  --remote=db:Open_vSwitch,Open_vSwitch,manager_options \
+  --pidfile=/run/openvswitch/ovsdb-server.pid \
+  -vconsole:info -vfile:off
@@ -0,0 +1,12 @@
+#!/bin/sh
+# Runs ovs-vswitchd against the node's Open vSwitch database.
+set -e
+
+modprobe openvswitch || true
+until [ -S /run/openvswitch/db.sock ]; do sleep 1; done
+ovs-vsctl --no-wait init
+
+exec ovs-vswitchd unix:/run/openvswitch/db.sock \
+  --pidfile=/run/openvswitch/ovs-vswitchd.pid \
+  --mlockall \
+  -vconsole:info -vfile:off
@@ -0,0 +1,13 @@
+#!/bin/sh
+# Readiness probe for ovsdb-server.sh: a Raft member is ready once it has
+# joined the cluster, a standalone server once it answers on its socket.
+set -e
+
+CTL=/var/run/ovn/${DB_FILE_NAME%.db}.ctl
+SOCK=/var/run/ovn/${DB_FILE_NAME%.db}.sock
+
+if [ "$RAFT" = "true" ]; then
+  ovs-appctl -t "$CTL" cluster/status "$DB_NAME" | grep -q "^Status: cluster member"
+else
+  ovsdb-client --timeout=5 list-dbs "unix:$SOCK" | grep -q "^${DB_NAME}$"
+fi
@@ -0,0 +1,73 @@
+#!/bin/sh
+# Starts ovsdb-server for one OVN database, either standalone or as a Raft
+# cluster member. Configured through environment variables set by the operator:
+#   DB_NAME, DB_FILE_NAME, SCHEMA, CLIENT_PORT, RAFT_PORT, RAFT, REPLICAS,
+#   SERVICE, NAMESPACE, PROTO (tcp|ssl)
+set -e
+
+mkdir -p /var/lib/ovn /var/run/ovn
+DB_FILE=/var/lib/ovn/${DB_FILE_NAME}
+CTL=/var/run/ovn/${DB_FILE_NAME%.db}.ctl
+SOCK=/var/run/ovn/${DB_FILE_NAME%.db}.sock
+
+SSL_ARGS=""
+if [ "$PROTO" = "ssl" ]; then
+  SSL_ARGS="--private-key=/etc/ovn/tls/tls.key --certificate=/etc/ovn/tls/tls.crt --ca-cert=/etc/ovn/tls/ca.crt"
+fi
+
+# member ORDINAL PORT prints the address of a StatefulSet member.
+member() {
+  echo "${PROTO}:${SERVICE}-$1.${SERVICE}.${NAMESPACE}.svc:$2"
+}
+
+# peer_in_cluster succeeds if another member already serves a connected
+# (joined) copy of the database, i.e. a cluster exists that we must join.
+peer_in_cluster() {
+  i=0
+  while [ "$i" -lt "$REPLICAS" ]; do
+    if [ "$i" != "$ORDINAL" ] && ovsdb-client $SSL_ARGS --timeout=5 query "$(member "$i" "$CLIENT_PORT")" \
+        "[\"_Server\",{\"op\":\"select\",\"table\":\"Database\",\"where\":[[\"name\",\"==\",\"${DB_NAME}\"]],\"columns\":[\"connected\"]}]" \
+        2>/dev/null | grep -q '"connected":true'; then
+      return 0
+    fi
+    i=$((i + 1))
+  done
+  return 1
+}
+
+if [ "$RAFT" = "true" ]; then
+  ORDINAL=${HOSTNAME##*-}
+  LOCAL=$(member "$ORDINAL" "$RAFT_PORT")
+  if [ ! -f "$DB_FILE" ]; then
+    # Only member 0 may bootstrap, and only if no cluster is reachable;
+    # otherwise a lost volume on member 0 would fork a second cluster.
+    if [ "$ORDINAL" = "0" ] && ! peer_in_cluster; then
+      echo "creating ${DB_NAME} cluster at ${LOCAL}"
+      ovsdb-tool create-cluster "$DB_FILE" "$SCHEMA" "$LOCAL"
+    else
+      REMOTES=""
+      i=0
+      while [ "$i" -lt "$REPLICAS" ]; do
+        if [ "$i" != "$ORDINAL" ]; then
+          REMOTES="$REMOTES $(member "$i" "$RAFT_PORT")"
+        fi
+        i=$((i + 1))
+      done
+      echo "joining ${DB_NAME} cluster via${REMOTES}"
+      ovsdb-tool join-cluster "$DB_FILE" "$DB_NAME" "$LOCAL" $REMOTES
+    fi
+  fi
+else
+  if [ ! -f "$DB_FILE" ]; then
+    ovsdb-tool create "$DB_FILE" "$SCHEMA"
+  elif [ "$(ovsdb-tool needs-conversion "$DB_FILE" "$SCHEMA")" = "yes" ]; then
+    ovsdb-tool convert "$DB_FILE" "$SCHEMA"
+  fi
+fi
+
+exec ovsdb-server "$DB_FILE" \
+  --remote=punix:"$SOCK" \
+  --remote="p${PROTO}:${CLIENT_PORT}:0.0.0.0" \
+  --unixctl="$CTL" \
+  $SSL_ARGS \
+  -vconsole:info -vfile:off
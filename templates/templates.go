@@ -0,0 +1,11 @@
+// Package templates holds the OpenStack service configuration templates.
+// Each service has its own directory; files are Go text/templates rendered
+// by internal/common.RenderTemplate.
+package templates
+
+import "embed"
+
+// FS contains all service configuration templates.
+//
+//go:embed barbican cinder heat neutron nova octavia ovn swift
+var FS embed.FS